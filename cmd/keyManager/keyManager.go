@@ -1,8 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"log"
 
+	"github.com/netsec-ethz/rains/internal/pkg/audit"
 	"github.com/netsec-ethz/rains/internal/pkg/keyManager"
 	flag "github.com/spf13/pflag"
 )
@@ -12,7 +14,16 @@ var keyName = flag.StringP("name", "n", "", "Name determines the prefix of the k
 var algo = flag.StringP("algo", "a", "ed25519", "Algorithm used to generate key")
 var phase = flag.Int("phase", 0, "Key phase of the generated key")
 var description = flag.StringP("description", "d", "", "description added when a new key pair is generated")
-var pwd = flag.String("pwd", "", "password to used to encrypt a newly generated key pair")
+var pwd = flag.String("pwd", "", "passphrase of the private key. If not set, it is read from "+
+	keyManager.PassphraseEnv+" or from the terminal")
+var format = flag.StringP("format", "f", "pem", "format of an imported or exported key: pem, jwk or hex")
+var inPath = flag.StringP("in", "i", "", "path to the key file which is imported")
+var private = flag.Bool("private", false, "if set, the private key is exported instead of the public key")
+var zone = flag.StringP("zone", "z", "", "zone for which a delegation assertion is generated")
+var plainPath = flag.String("plain", "", "path to a file containing plaintext private keys in json format which are migrated")
+var redirections = flag.StringSlice("redirect", nil, "names of the zone's authoritative servers added to a delegation request")
+var auditLog = flag.String("audit", "", "path to the audit log to which usages of private keys are appended")
+var auditHead = flag.String("head", "", "hash of a record printed by an earlier verifyaudit which must still be contained in the audit log")
 
 func main() {
 	flag.Parse()
@@ -26,10 +37,98 @@ func main() {
 	case "load", "l":
 		keyManager.LoadPublicKeys(*keyPath)
 	case "generate", "gen", "g":
-		keyManager.GenerateKey(*keyPath, *keyName, *description, *algo, *pwd, *phase)
+		if err := keyManager.GenerateKey(*keyPath, *keyName, *description, *algo, passphrase(),
+			*phase); err != nil {
+			log.Fatal(err)
+		}
+		if *zone != "" {
+			printDelegation()
+		}
+	case "delegation", "deleg":
+		printDelegation()
+	case "request", "req":
+		request, err := keyManager.CreateDelegationRequest(*keyPath, *keyName, *zone, passphrase(),
+			*redirections)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(request)
+		auditKeyUsage("request")
+	case "import", "i":
+		if err := keyManager.ImportKey(*inPath, *format, *keyPath, *keyName, *description,
+			passphrase(), *phase); err != nil {
+			log.Fatal(err)
+		}
+	case "export", "e":
+		pwd := ""
+		if *private {
+			pwd = passphrase()
+		}
+		key, err := keyManager.ExportKey(*keyPath, *keyName, pwd, *format, *private)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(key)
+		if *private {
+			auditKeyUsage("export")
+		}
 	case "decrypt", "d":
-		keyManager.DecryptKey(*keyPath, *keyName, *pwd)
+		key, err := keyManager.DecryptKey(*keyPath, *keyName, passphrase())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(key)
+		auditKeyUsage("decrypt")
+	case "migrate", "m":
+		if err := keyManager.MigratePrivateKeys(*plainPath, *keyPath, *keyName, passphrase()); err != nil {
+			log.Fatal(err)
+		}
+	case "verifyaudit", "va":
+		if *auditHead != "" {
+			if err := audit.VerifyHead(*auditLog, *auditHead); err != nil {
+				log.Fatal(err)
+			}
+		}
+		head, err := audit.Head(*auditLog)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Audit log is intact, head: %s\n", head)
 	default:
 		log.Fatal("Unknown command")
 	}
 }
+
+//passphrase returns the passphrase of the private key given with --pwd or, if it is not set, the
+//one from the environment or the terminal.
+func passphrase() string {
+	if flag.CommandLine.Changed("pwd") {
+		return *pwd
+	}
+	pwd, err := keyManager.Passphrase()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return pwd
+}
+
+//printDelegation prints a delegation assertion for zone containing the public key stored at
+//keyPath/keyName.
+func printDelegation() {
+	delegation, err := keyManager.GenerateDelegation(*keyPath, *keyName, *zone)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(delegation)
+}
+
+//auditKeyUsage appends a record about operation performed with the private key stored at
+//keyPath/keyName to the audit log if one is configured.
+func auditKeyUsage(operation string) {
+	if *auditLog == "" {
+		return
+	}
+	if err := keyManager.AuditKeyUsage(*auditLog, *keyPath, *keyName, operation); err != nil {
+		log.Fatal(err)
+	}
+}
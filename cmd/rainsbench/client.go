@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/probe"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//sample is the outcome of a single query.
+type sample struct {
+	typeIndex int
+	rtt       time.Duration
+	failed    bool
+	//notification is true if the server answered with a notification instead of sections.
+	notification bool
+}
+
+//benchmark sends the queries of a workload to a server with several concurrent workers.
+type benchmark struct {
+	addr           net.Addr
+	w              *workload
+	timeout        time.Duration
+	queriesPerConn int //zero to reuse a connection for all queries of a worker
+	connsOpened    int64
+}
+
+//run starts workers which send queries until count queries are sent or duration has passed,
+//whichever comes first. A count or duration of zero means no limit. If qps is positive, the queries
+//are sent at this rate in total. It returns the samples of all queries and the elapsed time.
+func (b *benchmark) run(workers, count int, duration time.Duration, qps float64,
+	seed int64) ([]sample, time.Duration) {
+	permits := make(chan struct{})
+	go func() {
+		defer close(permits)
+		var deadline <-chan time.Time
+		if duration > 0 {
+			deadline = time.After(duration)
+		}
+		var tick <-chan time.Time
+		if qps > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / qps))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for i := 0; count == 0 || i < count; i++ {
+			if tick != nil && i > 0 {
+				select {
+				case <-tick:
+				case <-deadline:
+					return
+				}
+			}
+			select {
+			case permits <- struct{}{}:
+			case <-deadline:
+				return
+			}
+		}
+	}()
+	var mux sync.Mutex
+	var samples []sample
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(g *generator) {
+			defer wg.Done()
+			s := b.work(g, permits)
+			mux.Lock()
+			samples = append(samples, s...)
+			mux.Unlock()
+		}(newGenerator(b.w, seed+int64(i)))
+	}
+	wg.Wait()
+	return samples, time.Since(start)
+}
+
+//work sends a query for each received permit and waits for its response before sending the next.
+func (b *benchmark) work(g *generator, permits <-chan struct{}) []sample {
+	var samples []sample
+	var conn net.Conn
+	var reader cbor.Reader
+	var writer cbor.Writer
+	sent := 0
+	closeConn := func() {
+		if conn != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+	defer closeConn()
+	for range permits {
+		name, typeIndex, types, qopts := g.next()
+		s := sample{typeIndex: typeIndex}
+		if conn == nil {
+			c, err := connection.CreateConnection(b.addr)
+			if err != nil {
+				s.failed = true
+				samples = append(samples, s)
+				continue
+			}
+			atomic.AddInt64(&b.connsOpened, 1)
+			conn, reader, writer, sent = c, cbor.NewReader(c), cbor.NewWriter(c), 0
+		}
+		msg := util.NewQueryMessage(name, g.w.context, time.Now().Unix()+g.w.validity, types, qopts,
+			token.New())
+		start := time.Now()
+		answer, err := probe.Exchange(conn, reader, writer, msg, b.timeout)
+		s.rtt = time.Since(start)
+		if err != nil {
+			//The connection's state is unknown after a failure, later responses must not be
+			//attributed to the next query.
+			s.failed = true
+			closeConn()
+		} else if len(answer.Content) > 0 {
+			_, s.notification = answer.Content[0].(*section.Notification)
+		}
+		samples = append(samples, s)
+		if sent++; b.queriesPerConn > 0 && sent >= b.queriesPerConn {
+			closeConn()
+		}
+	}
+	return samples
+}
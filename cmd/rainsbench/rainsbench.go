@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+var server = flag.String("s", "127.0.0.1:5022", "address (host:port) of the rainsd server under test.")
+var namesPath = flag.String("names", "", `path to a file containing the names to query, one per line. The order of the
+		names determines their popularity rank for the zipf distribution.`)
+var nameList = flag.String("name", "", "comma separated list of names to query. Used if -names is not set.")
+var zipfS = flag.Float64("zipf", 0, `exponent (larger than 1) of the zipf distribution of name popularity. If it is not
+		set, all names are equally popular.`)
+var context = flag.String("c", ".", "context of the queries.")
+var typeMix = flag.String("t", "ip4", `mix of queried types as a comma separated list of type=weight entries, e.g.
+		ip4=70,ip6=20,deleg=10. Types are given by name or number, any queries all types.`)
+var qoptMix = flag.String("qopt", "none", `mix of query options as a comma separated list of options=weight entries, e.g.
+		none=80,4=15,4+2=5. Options are query option numbers joined by + in descending priority.`)
+var workers = flag.Int("conns", 1, "number of concurrent workers. Each worker sends its next query after the previous one was answered.")
+var queriesPerConn = flag.Int("queriesPerConn", 0, `number of queries a worker sends over a connection before it
+		opens a new one. 1 opens a connection per query, 0 reuses the connection for the whole run.`)
+var count = flag.Int("n", 1000, "total number of queries to send. 0 means no limit, -duration must then be set.")
+var duration = flag.Duration("duration", 0, "maximal duration of the run. 0 means no limit.")
+var qps = flag.Float64("qps", 0, "total rate at which queries are sent. If it is not set, the workers send as fast as possible.")
+var timeout = flag.Duration("timeout", 5*time.Second, "how long to wait for a response before a query is counted as failed.")
+var validity = flag.Duration("validity", 10*time.Second, "validity of each query after it has been sent.")
+var seed = flag.Int64("seed", time.Now().UnixNano(), "seed of the random workload generator to reproduce a workload.")
+var format = flag.String("fmt", "text", `output format of the report. Supported values are:
+		text: human readable summary and per type table
+		json: a json object with the same information`)
+
+//main generates the configured workload against a rainsd server and prints throughput and latency.
+func main() {
+	flag.Parse()
+	if *format != "text" && *format != "json" {
+		exitf("unsupported output format: %s", *format)
+	}
+	if *count == 0 && *duration == 0 {
+		exitf("either -n or -duration must be set")
+	}
+	if *workers < 1 {
+		exitf("at least one connection is required")
+	}
+	addr, err := net.ResolveTCPAddr("tcp", *server)
+	if err != nil {
+		exitf("invalid server address: %v", err)
+	}
+	var names []string
+	if *namesPath != "" {
+		if names, err = loadNames(*namesPath); err != nil {
+			exitf("could not load names: %v", err)
+		}
+	} else if *nameList != "" {
+		names = strings.Split(*nameList, ",")
+	}
+	w, err := newWorkload(names, *zipfS, *context, *typeMix, *qoptMix, int64(*validity/time.Second))
+	if err != nil {
+		exitf("%v", err)
+	}
+	b := &benchmark{addr: addr, w: w, timeout: *timeout, queriesPerConn: *queriesPerConn}
+	samples, elapsed := b.run(*workers, *count, *duration, *qps, *seed)
+	r := newReport(samples, elapsed, b.connsOpened, w.typeMix.labels)
+	if err := r.print(); err != nil {
+		exitf("%v", err)
+	}
+	if r.Answered == 0 {
+		os.Exit(1)
+	}
+}
+
+//exitf prints the formatted error message to stderr and exits with status 1.
+func exitf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
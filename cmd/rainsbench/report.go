@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/probe"
+)
+
+//typeReport contains the results of the queries of one entry of the type mix.
+type typeReport struct {
+	Type     string        `json:"type"`
+	Queries  int           `json:"queries"`
+	Failed   int           `json:"failed"`
+	Latency  probe.Latency `json:"latency"`
+	answered []time.Duration
+}
+
+//report contains the results of a benchmark run.
+type report struct {
+	Queries       int           `json:"queries"`
+	Answered      int           `json:"answered"`
+	Notifications int           `json:"notifications"`
+	Failed        int           `json:"failed"`
+	Connections   int64         `json:"connections"`
+	DurationMs    float64       `json:"durationMs"`
+	Throughput    float64       `json:"throughputQps"`
+	Latency       probe.Latency `json:"latency"`
+	Types         []typeReport  `json:"types"`
+}
+
+//newReport computes the report of samples collected within elapsed. typeLabels are the labels of
+//the type mix.
+func newReport(samples []sample, elapsed time.Duration, connections int64,
+	typeLabels []string) report {
+	r := report{Queries: len(samples), Connections: connections, DurationMs: probe.ToMs(elapsed)}
+	var answered []time.Duration
+	types := make([]typeReport, len(typeLabels))
+	for i, label := range typeLabels {
+		types[i].Type = label
+	}
+	for _, s := range samples {
+		t := &types[s.typeIndex]
+		t.Queries++
+		if s.failed {
+			r.Failed++
+			t.Failed++
+			continue
+		}
+		if s.notification {
+			r.Notifications++
+		}
+		answered = append(answered, s.rtt)
+		t.answered = append(t.answered, s.rtt)
+	}
+	r.Answered = len(answered)
+	if elapsed > 0 {
+		r.Throughput = float64(r.Answered) / elapsed.Seconds()
+	}
+	r.Latency = probe.NewLatency(answered)
+	for i := range types {
+		types[i].Latency = probe.NewLatency(types[i].answered)
+	}
+	r.Types = types
+	return r
+}
+
+//print writes the report to stdout in the format specified by the fmt flag.
+func (r report) print() error {
+	if *format == "json" {
+		encoding, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("could not encode report: %v", err)
+		}
+		fmt.Println(string(encoding))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "queries:\t%d sent, %d answered (%d notifications), %d failed\n", r.Queries,
+		r.Answered, r.Notifications, r.Failed)
+	fmt.Fprintf(w, "connections:\t%d opened\n", r.Connections)
+	fmt.Fprintf(w, "duration:\t%.3fs\n", r.DurationMs/1000)
+	fmt.Fprintf(w, "throughput:\t%.1f answered queries/s\n", r.Throughput)
+	fmt.Fprintf(w, "latency:\t%s\n", r.Latency)
+	w.Flush()
+	fmt.Println()
+	fmt.Fprintln(w, "TYPE\tQUERIES\tFAILED\tMIN/MEAN/P50/P90/P99/MAX")
+	for _, t := range r.Types {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", t.Type, t.Queries, t.Failed, t.Latency)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+)
+
+var anyQuery = []object.Type{object.OTName, object.OTIP4Addr, object.OTIP6Addr,
+	object.OTDelegation, object.OTServiceInfo, object.OTRedirection}
+
+//mix is a set of alternatives which are chosen randomly according to their relative weights.
+type mix struct {
+	labels []string
+	//cumulative contains for each alternative the sum of its weight and all weights before it.
+	cumulative []float64
+}
+
+//parseMix returns the mix described by spec, a comma separated list of label=weight entries. The
+//weight of an entry without weight is 1.
+func parseMix(spec string) (mix, error) {
+	var m mix
+	var total float64
+	for _, entry := range strings.Split(spec, ",") {
+		label, weight := entry, 1.0
+		if i := strings.LastIndex(entry, "="); i >= 0 {
+			var err error
+			label = entry[:i]
+			if weight, err = strconv.ParseFloat(entry[i+1:], 64); err != nil || weight <= 0 {
+				return mix{}, fmt.Errorf("weight of %s must be a positive number", label)
+			}
+		}
+		if label == "" {
+			return mix{}, fmt.Errorf("malformed mix entry: %s", entry)
+		}
+		total += weight
+		m.labels = append(m.labels, label)
+		m.cumulative = append(m.cumulative, total)
+	}
+	return m, nil
+}
+
+//pick returns the index of a randomly chosen alternative.
+func (m mix) pick(r *rand.Rand) int {
+	x := r.Float64() * m.cumulative[len(m.cumulative)-1]
+	for i, c := range m.cumulative {
+		if x < c {
+			return i
+		}
+	}
+	return len(m.cumulative) - 1
+}
+
+//workload describes the queries sent to the server.
+type workload struct {
+	names    []string
+	zipfS    float64 //zero for a uniform distribution
+	context  string
+	typeMix  mix
+	types    [][]object.Type
+	qoptMix  mix
+	qopts    [][]query.Option
+	validity int64 //in seconds
+}
+
+//newWorkload returns a workload querying names. The popularity of the names follows a zipf
+//distribution with exponent zipfS or is uniform if zipfS is zero. typeSpec and qoptSpec are mixes
+//of types and query options as accepted by parseTypeMix and parseQoptMix.
+func newWorkload(names []string, zipfS float64, context, typeSpec, qoptSpec string,
+	validity int64) (*workload, error) {
+	if len(names) == 0 {
+		return nil, errors.New("no names to query")
+	}
+	if zipfS != 0 && zipfS <= 1 {
+		return nil, errors.New("zipf exponent must be larger than 1")
+	}
+	w := &workload{names: names, zipfS: zipfS, context: context, validity: validity}
+	var err error
+	if w.typeMix, w.types, err = parseTypeMix(typeSpec); err != nil {
+		return nil, err
+	}
+	if w.qoptMix, w.qopts, err = parseQoptMix(qoptSpec); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+//parseTypeMix returns the mix described by spec whose labels are types by name or number or any
+//for a query of all types.
+func parseTypeMix(spec string) (mix, [][]object.Type, error) {
+	m, err := parseMix(spec)
+	if err != nil {
+		return mix{}, nil, err
+	}
+	var types [][]object.Type
+	for _, label := range m.labels {
+		if label == "any" {
+			types = append(types, anyQuery)
+			continue
+		}
+		t, err := object.ParseType(label)
+		if err != nil {
+			return mix{}, nil, err
+		}
+		types = append(types, []object.Type{t})
+	}
+	return m, types, nil
+}
+
+//parseQoptMix returns the mix described by spec whose labels are none or a list of query option
+//numbers joined by + in descending priority.
+func parseQoptMix(spec string) (mix, [][]query.Option, error) {
+	m, err := parseMix(spec)
+	if err != nil {
+		return mix{}, nil, err
+	}
+	var qopts [][]query.Option
+	for _, label := range m.labels {
+		var opts []query.Option
+		if label != "none" {
+			for _, o := range strings.Split(label, "+") {
+				n, err := strconv.Atoi(o)
+				if err != nil || n < int(query.QOMinE2ELatency) || n > int(query.QONoProactiveCaching) {
+					return mix{}, nil, fmt.Errorf("no query option for value: %s", o)
+				}
+				opts = append(opts, query.Option(n))
+			}
+		}
+		qopts = append(qopts, opts)
+	}
+	return m, qopts, nil
+}
+
+//loadNames returns the names listed in the file at path, one per line. Empty lines and lines
+//starting with # are ignored. The order of the names determines their popularity rank.
+func loadNames(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var names []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names, scanner.Err()
+}
+
+//generator draws queries from a workload. It is not safe for concurrent use, each worker has its
+//own generator.
+type generator struct {
+	w    *workload
+	r    *rand.Rand
+	zipf *rand.Zipf
+}
+
+func newGenerator(w *workload, seed int64) *generator {
+	g := &generator{w: w, r: rand.New(rand.NewSource(seed))}
+	if w.zipfS != 0 {
+		g.zipf = rand.NewZipf(g.r, w.zipfS, 1, uint64(len(w.names)-1))
+	}
+	return g
+}
+
+//next returns the name, the index of the type mix entry, the types and the query options of the
+//next query.
+func (g *generator) next() (string, int, []object.Type, []query.Option) {
+	var name string
+	if g.zipf != nil {
+		name = g.w.names[g.zipf.Uint64()]
+	} else {
+		name = g.w.names[g.r.Intn(len(g.w.names))]
+	}
+	t := g.w.typeMix.pick(g.r)
+	return name, t, g.w.types[t], g.w.qopts[g.w.qoptMix.pick(g.r)]
+}
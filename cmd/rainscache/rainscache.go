@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+var socketPath = flag.String("socket", "", `path to the admin socket of a running rainsd server. When set, the content of the
+		caches given in -cache is requested from the server instead of being read from checkpoint files.`)
+var cacheNames = flag.String("cache", "assertions,negassertions,zonekeys", `comma separated list of caches which are
+		requested over the admin socket. Supported values are assertions, negassertions and zonekeys.`)
+var timeout = flag.Duration("timeout", 10*time.Second, "how long to wait for the server's response.")
+var zone = flag.String("zone", "", "only print sections whose subject zone is this zone or one of its subzones.")
+var context = flag.String("c", "", "only print sections of this context.")
+var nameRegexp = flag.String("name", "", `only print sections whose name matches this regular expression. The name of an
+		assertion is its fully qualified name, the name of other sections is their subject zone.`)
+var types = flag.String("t", "", `comma separated list of types (e.g. ip4,deleg or 2). Only assertions containing an
+		object of one of the types and shards and zones containing such an assertion are printed.`)
+var from = flag.String("from", "", `only print sections which are valid at some point after this time. It is either an
+		RFC3339 timestamp or a duration relative to now (e.g. -1h).`)
+var until = flag.String("until", "", `only print sections which are valid at some point before this time. It is either an
+		RFC3339 timestamp or a duration relative to now (e.g. 24h).`)
+var provenance = flag.String("provenance", "", `only print sections with this provenance. Supported values are
+		authoritative, cached and unknown (for checkpoints which do not record it).`)
+var format = flag.String("fmt", "zonefile", `output format. Supported values are:
+		zonefile: each section in zonefile format preceded by a comment with its provenance and validity
+		json: a json array of objects containing the section, its provenance and validity`)
+
+//filter decides which snapshot entries are printed.
+type filter struct {
+	zone       string
+	context    string
+	name       *regexp.Regexp
+	types      []object.Type
+	from       int64
+	until      int64
+	provenance string
+}
+
+//jsonEntry is the json representation of a snapshot entry.
+type jsonEntry struct {
+	Provenance string          `json:"provenance"`
+	ValidSince int64           `json:"validSince"`
+	ValidUntil int64           `json:"validUntil"`
+	Section    section.Section `json:"section"`
+}
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: rainscache [options] [checkpoint file]...\n\nOptions:\n")
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *format != "zonefile" && *format != "json" {
+		exitf("unsupported output format: %s", *format)
+	}
+	f, err := newFilter()
+	if err != nil {
+		exitf("%v", err)
+	}
+	var entries []rainsd.SnapshotEntry
+	if *socketPath != "" {
+		if flag.NArg() != 0 {
+			exitf("checkpoint files cannot be combined with -socket")
+		}
+		entries, err = fetchSnapshots(*socketPath, strings.Split(*cacheNames, ","))
+	} else {
+		if flag.NArg() == 0 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		entries, err = loadSnapshots(flag.Args())
+	}
+	if err != nil {
+		exitf("%v", err)
+	}
+	var matches []rainsd.SnapshotEntry
+	for _, e := range entries {
+		if f.matches(e) {
+			matches = append(matches, e)
+		}
+	}
+	if err := printEntries(matches); err != nil {
+		exitf("%v", err)
+	}
+}
+
+//exitf prints the formatted error message to stderr and exits with status 1.
+func exitf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+//loadSnapshots returns the entries of all checkpoint files at paths.
+func loadSnapshots(paths []string) ([]rainsd.SnapshotEntry, error) {
+	var entries []rainsd.SnapshotEntry
+	for _, path := range paths {
+		e, err := rainsd.LoadCacheSnapshot(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not load checkpoint %s: %v", path, err)
+		}
+		entries = append(entries, e...)
+	}
+	return entries, nil
+}
+
+//fetchSnapshots requests the content of the named caches from the server listening on socketPath.
+func fetchSnapshots(socketPath string, caches []string) ([]rainsd.SnapshotEntry, error) {
+	var entries []rainsd.SnapshotEntry
+	for _, name := range caches {
+		req := rainsd.AdminRequest{Command: rainsd.AdminCacheExport, Args: []string{name}}
+		resp, err := rainsd.AdminCall(socketPath, req, *timeout)
+		if err != nil {
+			return nil, fmt.Errorf("could not contact server at %s: %v", socketPath, err)
+		}
+		if resp.Error != "" {
+			return nil, fmt.Errorf("server returned an error: %s", resp.Error)
+		}
+		var data []byte
+		if err := json.Unmarshal(resp.Result, &data); err != nil {
+			return nil, fmt.Errorf("malformed cache export: %v", err)
+		}
+		e, err := rainsd.DecodeCacheSnapshot(data)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e...)
+	}
+	return entries, nil
+}
+
+//newFilter returns a filter according to the command line flags.
+func newFilter() (filter, error) {
+	f := filter{zone: *zone, context: *context, provenance: *provenance, until: -1}
+	var err error
+	if *nameRegexp != "" {
+		if f.name, err = regexp.Compile(*nameRegexp); err != nil {
+			return f, fmt.Errorf("malformed name regular expression: %v", err)
+		}
+	}
+	if *types != "" {
+		for _, t := range strings.Split(*types, ",") {
+			oType, err := object.ParseType(t)
+			if err != nil {
+				return f, err
+			}
+			f.types = append(f.types, oType)
+		}
+	}
+	if *from != "" {
+		if f.from, err = parseTime(*from); err != nil {
+			return f, err
+		}
+	}
+	if *until != "" {
+		if f.until, err = parseTime(*until); err != nil {
+			return f, err
+		}
+	}
+	switch f.provenance {
+	case "", rainsd.ProvenanceAuthoritative, rainsd.ProvenanceCached, rainsd.ProvenanceUnknown:
+	default:
+		return f, fmt.Errorf("unsupported provenance: %s", f.provenance)
+	}
+	return f, nil
+}
+
+//parseTime returns the unix time of value which is either an RFC3339 timestamp or a duration
+//relative to now.
+func parseTime(value string) (int64, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix(), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("time must be an RFC3339 timestamp or a duration: %s", value)
+	}
+	return time.Now().Add(d).Unix(), nil
+}
+
+//matches returns true if e satisfies all conditions of f.
+func (f filter) matches(e rainsd.SnapshotEntry) bool {
+	s := e.Section
+	if f.zone != "" && !isInZone(s.GetSubjectZone(), f.zone) {
+		return false
+	}
+	if f.context != "" && s.GetContext() != f.context {
+		return false
+	}
+	if f.provenance != "" && e.Provenance != f.provenance {
+		return false
+	}
+	if s.ValidUntil() < f.from || (f.until >= 0 && s.ValidSince() > f.until) {
+		return false
+	}
+	if f.name != nil && !f.name.MatchString(entryName(s)) {
+		return false
+	}
+	if len(f.types) > 0 {
+		switch s := s.(type) {
+		case *section.Assertion:
+			return f.hasType(s)
+		case *section.Shard:
+			return f.anyHasType(s.Content)
+		case *section.Zone:
+			return f.anyHasType(s.Content)
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+//hasType returns true if a contains an object of one of the filter's types.
+func (f filter) hasType(a *section.Assertion) bool {
+	for _, o := range a.Content {
+		for _, t := range f.types {
+			if o.Type == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//anyHasType returns true if one of assertions contains an object of one of the filter's types.
+func (f filter) anyHasType(assertions []*section.Assertion) bool {
+	for _, a := range assertions {
+		if f.hasType(a) {
+			return true
+		}
+	}
+	return false
+}
+
+//isInZone returns true if subjectZone is zone or one of its subzones.
+func isInZone(subjectZone, zone string) bool {
+	if !strings.HasSuffix(zone, ".") {
+		zone += "."
+	}
+	return zone == "." || subjectZone == zone || strings.HasSuffix(subjectZone, "."+zone)
+}
+
+//entryName returns the fully qualified name of an assertion and the subject zone of all other
+//sections.
+func entryName(s section.WithSigForward) string {
+	if a, ok := s.(*section.Assertion); ok {
+		return a.FQDN()
+	}
+	return s.GetSubjectZone()
+}
+
+//printEntries writes entries to stdout in the format specified by the fmt flag.
+func printEntries(entries []rainsd.SnapshotEntry) error {
+	if *format == "json" {
+		output := []jsonEntry{}
+		for _, e := range entries {
+			output = append(output, jsonEntry{
+				Provenance: e.Provenance,
+				ValidSince: e.Section.ValidSince(),
+				ValidUntil: e.Section.ValidUntil(),
+				Section:    e.Section,
+			})
+		}
+		encoding, err := json.Marshal(output)
+		if err != nil {
+			return fmt.Errorf("could not encode sections: %v", err)
+		}
+		fmt.Println(string(encoding))
+		return nil
+	}
+	for _, e := range entries {
+		fmt.Printf(";; %s, valid from %s until %s\n", e.Provenance, formatUnix(e.Section.ValidSince()),
+			formatUnix(e.Section.ValidUntil()))
+		fmt.Println(zonefile.GetEncoding(e.Section, true))
+	}
+	return nil
+}
+
+//formatUnix returns the unix time t as RFC3339 timestamp.
+func formatUnix(t int64) string {
+	return time.Unix(t, 0).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//classify returns whether answer is a positive, negative or referral answer to a query for name
+//with the given types. It returns an empty string if answer contains nothing relevant.
+func classify(answer message.Message, name string, types []object.Type) string {
+	kind := ""
+	for _, s := range answer.Content {
+		switch s := s.(type) {
+		case *section.Assertion:
+			if s.FQDN() == name && containsType(s, types) {
+				return "positive"
+			}
+			if isReferral(s, name) {
+				kind = "referral"
+			}
+		case *section.Shard, *section.Pshard, *section.Zone:
+			if covers(s, name) && kind == "" {
+				kind = "negative"
+			}
+		case *section.Notification:
+			if (s.Type == section.NTNoAssertionsExist || s.Type == section.NTNoAssertionAvail) &&
+				kind == "" {
+				kind = "negative"
+			}
+		}
+	}
+	return kind
+}
+
+//relevant returns true if s is part of a minimal answer to a query for name with the given types,
+//i.e. an assertion about name containing a queried type, a section proving the nonexistence of
+//name or a notification.
+func relevant(s section.Section, name string, types []object.Type) bool {
+	switch s := s.(type) {
+	case *section.Assertion:
+		return s.FQDN() == name && containsType(s, types)
+	case *section.Shard, *section.Pshard, *section.Zone:
+		return covers(s, name)
+	case *section.Notification:
+		return true
+	}
+	return false
+}
+
+//assertionsAbout returns the top level assertions of answer whose subject is name.
+func assertionsAbout(answer message.Message, name string) []*section.Assertion {
+	var assertions []*section.Assertion
+	for _, s := range answer.Content {
+		if a, ok := s.(*section.Assertion); ok && a.FQDN() == name {
+			assertions = append(assertions, a)
+		}
+	}
+	return assertions
+}
+
+//containsType returns true if a contains an object of one of the given types.
+func containsType(a *section.Assertion, types []object.Type) bool {
+	for _, o := range a.Content {
+		for _, t := range types {
+			if o.Type == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//isReferral returns true if a is a delegation, redirection or service information of a zone name
+//belongs to.
+func isReferral(a *section.Assertion, name string) bool {
+	zone := a.FQDN()
+	if name != zone && !strings.HasSuffix(name, "."+zone) {
+		return false
+	}
+	return containsType(a, []object.Type{object.OTDelegation, object.OTRedirection,
+		object.OTServiceInfo})
+}
+
+//covers returns true if the shard, pshard or zone s contains the subject of name, i.e. whether it
+//is able to prove the nonexistence of name.
+func covers(s section.Section, name string) bool {
+	switch s := s.(type) {
+	case *section.Shard:
+		subject, ok := subjectIn(name, s.SubjectZone)
+		return ok && s.InRange(subject)
+	case *section.Pshard:
+		subject, ok := subjectIn(name, s.SubjectZone)
+		return ok && s.InRange(subject)
+	case *section.Zone:
+		_, ok := subjectIn(name, s.SubjectZone)
+		return ok
+	}
+	return false
+}
+
+//subjectIn returns the subject name of name in zone and false if name is not within zone.
+func subjectIn(name, zone string) (string, bool) {
+	var subject string
+	if zone == "." {
+		subject = strings.TrimSuffix(name, ".")
+	} else if strings.HasSuffix(name, "."+zone) {
+		subject = strings.TrimSuffix(name, "."+zone)
+	}
+	return subject, subject != ""
+}
+
+//summary returns a short description of the sections of answer.
+func summary(answer message.Message) string {
+	if len(answer.Content) == 0 {
+		return "no sections"
+	}
+	descriptions := make([]string, len(answer.Content))
+	for i, s := range answer.Content {
+		descriptions[i] = describe(s)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+//describe returns a short description of s.
+func describe(s section.Section) string {
+	switch s := s.(type) {
+	case *section.Assertion:
+		types := make([]string, len(s.Content))
+		for i, o := range s.Content {
+			types[i] = o.Type.Name()
+		}
+		return fmt.Sprintf("assertion %s [%s]", s.FQDN(), strings.Join(types, " "))
+	case *section.Shard:
+		return fmt.Sprintf("shard %s (%s, %s)", s.SubjectZone, s.RangeFrom, s.RangeTo)
+	case *section.Pshard:
+		return fmt.Sprintf("pshard %s (%s, %s)", s.SubjectZone, s.RangeFrom, s.RangeTo)
+	case *section.Zone:
+		return fmt.Sprintf("zone %s", s.SubjectZone)
+	case *section.Notification:
+		if s.Data != "" {
+			return fmt.Sprintf("notification %d (%s)", s.Type, s.Data)
+		}
+		return fmt.Sprintf("notification %d", s.Type)
+	}
+	return fmt.Sprintf("%T", s)
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	gocontext "context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//Requirement levels of the checked behavior. A server not meeting a SHOULD requirement only
+//produces a warning.
+const (
+	must   = "MUST"
+	should = "SHOULD"
+)
+
+//check is a single protocol check run against the server under test.
+type check struct {
+	name        string
+	level       string
+	description string
+	//fatal checks skip all remaining checks if they do not pass.
+	fatal bool
+	run   func(t *tester) result
+}
+
+//checks contains all checks in the order in which they are run.
+var checks = []check{
+	{name: "transport/connect", level: must, fatal: true, run: checkConnect,
+		description: "the server accepts TLS connections"},
+	{name: "query/token", level: must, run: checkToken,
+		description: "the answer to a query carries the query's token"},
+	{name: "query/answer", level: must, run: checkAnswer,
+		description: "a query for the existing name is answered with relevant sections"},
+	{name: "query/nonexistent", level: must, run: checkNonexistent,
+		description: "a query for a nonexistent name is answered negatively"},
+	{name: "query/min-answer-size", level: should, run: checkMinAnswerSize,
+		description: "with option MinLastHopAnswerSize, the answer only contains relevant sections"},
+	{name: "query/cached-only", level: should, run: checkCachedOnly,
+		description: "with option CachedAnswersOnly, an uncached name is answered without delay"},
+	{name: "notification/heartbeat", level: must, run: checkHeartbeat,
+		description: "a heartbeat is not answered with an error and the connection stays usable"},
+	{name: "notification/unknown-type", level: should, run: checkUnknownNotification,
+		description: "a notification of unknown type is answered with BadMessage"},
+	{name: "capability/list", level: must, run: checkCapabilityList,
+		description: "a query with a list of capabilities is answered"},
+	{name: "capability/request", level: should, run: checkCapabilityRequest,
+		description: "a CapHashNotKnown notification without data is answered with capabilities"},
+	{name: "capability/unknown-hash", level: should, run: checkUnknownCapabilityHash,
+		description: "an unknown capability hash is answered with CapHashNotKnown"},
+	{name: "malformed/garbage", level: should, run: checkGarbage,
+		description: "bytes which are not a message are rejected with BadMessage"},
+	{name: "malformed/unknown-section", level: should, run: checkUnknownSection,
+		description: "a message with an unknown section type is rejected with BadMessage"},
+	{name: "malformed/missing-token", level: should, run: checkMissingToken,
+		description: "a message without token is rejected with BadMessage"},
+	{name: "signature/validity", level: must, run: checkSignatureValidity,
+		description: "all signed sections of the answer carry signatures which are currently valid"},
+	{name: "signature/chain", level: must, run: checkSignatureChain,
+		description: "the answer is verifiable along the delegation chain from the trust anchor"},
+}
+
+//tester contains the parameters of the checks.
+type tester struct {
+	addr     net.Addr
+	name     string
+	context  string
+	types    []object.Type
+	anchor   string
+	timeout  time.Duration
+	validity time.Duration
+}
+
+//query returns a new message with a query for name and the configured context and types.
+func (t *tester) query(name string, opts ...query.Option) message.Message {
+	return util.NewQueryMessage(name, t.context, time.Now().Add(t.validity).Unix(), t.types, opts,
+		token.New())
+}
+
+//ask sends msg over a new session and returns the response carrying msg's token.
+func (t *tester) ask(msg message.Message) (message.Message, error) {
+	s, err := dial(t.addr)
+	if err != nil {
+		return message.Message{}, err
+	}
+	defer s.close()
+	return s.exchange(msg, t.timeout)
+}
+
+//nonexistentName returns a random name in the zone of the configured name, which most likely does
+//not exist.
+func (t *tester) nonexistentName() string {
+	random := make([]byte, 8)
+	rand.Read(random)
+	label := "rainsconform-" + hex.EncodeToString(random)
+	if i := strings.Index(t.name, "."); i >= 0 && i < len(t.name)-1 {
+		return label + t.name[i:]
+	}
+	return label + "."
+}
+
+//responsive returns an error if the server does not answer a query for the configured name over a
+//new connection.
+func (t *tester) responsive() error {
+	if _, err := t.ask(t.query(t.name)); err != nil {
+		return fmt.Errorf("server is not responsive anymore: %v", err)
+	}
+	return nil
+}
+
+//checkConnect implements the check transport/connect.
+func checkConnect(t *tester) result {
+	s, err := dial(t.addr)
+	if err != nil {
+		return failed("%v", err)
+	}
+	s.close()
+	return passed("")
+}
+
+//checkToken implements the check query/token.
+func checkToken(t *tester) result {
+	msg := t.query(t.name)
+	s, err := dial(t.addr)
+	if err != nil {
+		return failed("%v", err)
+	}
+	defer s.close()
+	if _, err := s.exchange(msg, t.timeout); err != nil {
+		if len(s.received) > 0 {
+			return failed("%d message(s) received, none carries the query's token", len(s.received))
+		}
+		return failed("%v", err)
+	}
+	return passed("")
+}
+
+//checkAnswer implements the check query/answer.
+func checkAnswer(t *tester) result {
+	answer, err := t.ask(t.query(t.name))
+	if err != nil {
+		return failed("%v", err)
+	}
+	kind := classify(answer, t.name, t.types)
+	if kind == "" {
+		return failed("the answer does not contain any section relevant to %s: %s", t.name,
+			summary(answer))
+	}
+	return passed("%s answer: %s", kind, summary(answer))
+}
+
+//checkNonexistent implements the check query/nonexistent.
+func checkNonexistent(t *tester) result {
+	name := t.nonexistentName()
+	answer, err := t.ask(t.query(name))
+	if err != nil {
+		return failed("query for %s: %v", name, err)
+	}
+	if assertions := assertionsAbout(answer, name); len(assertions) > 0 {
+		return failed("the answer contains %s", describe(assertions[0]))
+	}
+	switch kind := classify(answer, name, t.types); kind {
+	case "negative", "referral":
+		return passed("%s answer for %s: %s", kind, name, summary(answer))
+	}
+	return failed("the answer for %s neither proves its nonexistence nor is a referral: %s", name,
+		summary(answer))
+}
+
+//checkMinAnswerSize implements the check query/min-answer-size.
+func checkMinAnswerSize(t *tester) result {
+	answer, err := t.ask(t.query(t.name, query.QOMinLastHopAnswerSize))
+	if err != nil {
+		return deviated("%v", err)
+	}
+	if classify(answer, t.name, t.types) != "positive" {
+		return skipped("the answer for %s is not positive: %s", t.name, summary(answer))
+	}
+	var irrelevant []string
+	for _, s := range answer.Content {
+		if !relevant(s, t.name, t.types) {
+			irrelevant = append(irrelevant, describe(s))
+		}
+	}
+	if len(irrelevant) > 0 {
+		return deviated("the answer contains %d irrelevant section(s): %s", len(irrelevant),
+			strings.Join(irrelevant, ", "))
+	}
+	return passed("%s", summary(answer))
+}
+
+//checkCachedOnly implements the check query/cached-only.
+func checkCachedOnly(t *tester) result {
+	name := t.nonexistentName()
+	start := time.Now()
+	answer, err := t.ask(t.query(name, query.QOCachedAnswersOnly))
+	if err != nil {
+		return deviated("query for %s: %v", name, err)
+	}
+	if len(assertionsAbout(answer, name)) > 0 {
+		return failed("the answer contains an assertion for %s", name)
+	}
+	return passed("answered after %v: %s", time.Since(start).Round(time.Millisecond), summary(answer))
+}
+
+//checkHeartbeat implements the check notification/heartbeat.
+func checkHeartbeat(t *tester) result {
+	s, err := dial(t.addr)
+	if err != nil {
+		return failed("%v", err)
+	}
+	defer s.close()
+	heartbeat := token.New()
+	if err := s.send(notificationMessage(heartbeat, section.NTHeartbeat, ""), t.timeout); err != nil {
+		return failed("%v", err)
+	}
+	if _, err := s.exchange(t.query(t.name), t.timeout); err != nil {
+		return failed("query after heartbeat on the same connection: %v", err)
+	}
+	for _, msg := range s.received {
+		if n := notificationOf(msg); n != nil && n.Token == heartbeat {
+			return failed("the heartbeat was answered with notification %d %s", n.Type, n.Data)
+		}
+	}
+	return passed("")
+}
+
+//checkUnknownNotification implements the check notification/unknown-type.
+func checkUnknownNotification(t *tester) result {
+	s, err := dial(t.addr)
+	if err != nil {
+		return failed("%v", err)
+	}
+	defer s.close()
+	msg := notificationMessage(token.New(), section.NotificationType(999), "")
+	answer, err := s.exchange(msg, t.timeout)
+	if err != nil {
+		return deviated("%v", err)
+	}
+	if n := notificationOf(answer); n == nil || n.Type != section.NTBadMessage {
+		return deviated("answered with %s", summary(answer))
+	}
+	return passed("")
+}
+
+//checkCapabilityList implements the check capability/list.
+func checkCapabilityList(t *tester) result {
+	msg := t.query(t.name)
+	msg.Capabilities = []message.Capability{message.TLSOverTCP}
+	answer, err := t.ask(msg)
+	if err != nil {
+		return failed("%v", err)
+	}
+	return passed("%s", summary(answer))
+}
+
+//checkCapabilityRequest implements the check capability/request.
+func checkCapabilityRequest(t *tester) result {
+	s, err := dial(t.addr)
+	if err != nil {
+		return failed("%v", err)
+	}
+	defer s.close()
+	msg := notificationMessage(token.New(), section.NTCapHashNotKnown, "")
+	if err := s.send(msg, t.timeout); err != nil {
+		return failed("%v", err)
+	}
+	answer, err := s.receive(func(m message.Message) bool { return len(m.Capabilities) > 0 },
+		t.timeout)
+	if err != nil {
+		return deviated("no capabilities received: %v", err)
+	}
+	caps := make([]string, len(answer.Capabilities))
+	for i, c := range answer.Capabilities {
+		caps[i] = string(c)
+	}
+	return passed("capabilities: %s", strings.Join(caps, ", "))
+}
+
+//checkUnknownCapabilityHash implements the check capability/unknown-hash.
+func checkUnknownCapabilityHash(t *tester) result {
+	s, err := dial(t.addr)
+	if err != nil {
+		return failed("%v", err)
+	}
+	defer s.close()
+	hash := make([]byte, 32)
+	rand.Read(hash)
+	msg := t.query(t.name)
+	msg.Capabilities = []message.Capability{message.Capability(hex.EncodeToString(hash))}
+	if err := s.send(msg, t.timeout); err != nil {
+		return failed("%v", err)
+	}
+	if _, err := s.receive(notifies(section.NTCapHashNotKnown), t.timeout); err != nil {
+		return deviated("no CapHashNotKnown notification received: %v", err)
+	}
+	return passed("")
+}
+
+//checkGarbage implements the check malformed/garbage.
+func checkGarbage(t *tester) result {
+	//0xff is a break code which cannot start a CBOR data item.
+	garbage := make([]byte, 64)
+	rand.Read(garbage)
+	garbage[0] = 0xff
+	return t.rejected(garbage)
+}
+
+//checkUnknownSection implements the check malformed/unknown-section.
+func checkUnknownSection(t *tester) result {
+	tok := token.New()
+	//The body is a valid notification, only the section type is unknown.
+	notification := &section.Notification{Token: tok, Type: section.NTHeartbeat}
+	encoding, err := encodeMap(map[int]interface{}{
+		2:  tok[:],
+		23: [][2]interface{}{{99, notification}},
+	})
+	if err != nil {
+		return failed("could not encode message: %v", err)
+	}
+	return t.rejected(encoding)
+}
+
+//checkMissingToken implements the check malformed/missing-token.
+func checkMissingToken(t *tester) result {
+	encoding, err := encodeMap(map[int]interface{}{23: [][2]interface{}{}})
+	if err != nil {
+		return failed("could not encode message: %v", err)
+	}
+	return t.rejected(encoding)
+}
+
+//rejected sends the malformed encoding and checks that the server answers with a BadMessage
+//notification. Closing the connection instead is a deviation while the server must stay
+//responsive in any case.
+func (t *tester) rejected(encoding []byte) result {
+	s, err := dial(t.addr)
+	if err != nil {
+		return failed("%v", err)
+	}
+	defer s.close()
+	if err := s.sendRaw(encoding, t.timeout); err != nil {
+		return failed("%v", err)
+	}
+	_, err = s.receive(notifies(section.NTBadMessage), t.timeout)
+	if err := t.responsive(); err != nil {
+		return failed("%v", err)
+	}
+	switch err {
+	case nil:
+		return passed("")
+	case errClosed:
+		return deviated("the connection was closed without BadMessage notification")
+	}
+	return deviated("the message was neither rejected nor was the connection closed")
+}
+
+//checkSignatureValidity implements the check signature/validity.
+func checkSignatureValidity(t *tester) result {
+	answer, err := t.ask(t.query(t.name))
+	if err != nil {
+		return failed("%v", err)
+	}
+	now := time.Now().Unix()
+	signed := 0
+	for _, s := range answer.Content {
+		sec, ok := s.(section.WithSig)
+		if !ok {
+			continue
+		}
+		signed++
+		sigs := sec.AllSigs()
+		if len(sigs) == 0 {
+			return failed("%s is not signed", describe(s))
+		}
+		for _, sig := range sigs {
+			if sig.ValidSince > now || sig.ValidUntil < now {
+				return failed("signature of %s is valid from %s until %s", describe(s),
+					time.Unix(sig.ValidSince, 0).UTC().Format(time.RFC3339),
+					time.Unix(sig.ValidUntil, 0).UTC().Format(time.RFC3339))
+			}
+		}
+	}
+	if signed == 0 {
+		return skipped("the answer does not contain signed sections")
+	}
+	return passed("%d signed section(s)", signed)
+}
+
+//checkSignatureChain implements the check signature/chain.
+func checkSignatureChain(t *tester) result {
+	if t.anchor == "" {
+		return skipped("-anchor is not set")
+	}
+	r := libresolve.New(nil, []net.Addr{t.addr}, libresolve.Forward, nil, 10)
+	r.Assertions = nil
+	r.NegAssertions = nil
+	r.IdleTimeout = 0
+	r.HopTimeout = t.timeout
+	r.Verification = libresolve.Strict
+	if err := r.LoadTrustAnchor(t.anchor); err != nil {
+		return failed("%v", err)
+	}
+	defer r.Close()
+	q := t.query(t.name).Content[0].(*query.Name)
+	answer, err := r.ClientLookup(gocontext.Background(), q)
+	if err != nil {
+		return failed("%v", err)
+	}
+	return passed("%s", summary(*answer))
+}
+
+//notificationMessage returns a message containing a notification of type t with the given token
+//and data.
+func notificationMessage(tok token.Token, t section.NotificationType, data string) message.Message {
+	return message.Message{
+		Token:   tok,
+		Content: []section.Section{&section.Notification{Token: tok, Type: t, Data: data}},
+	}
+}
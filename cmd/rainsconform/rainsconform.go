@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+)
+
+var server = flag.String("s", "127.0.0.1:5022", "address (host:port) of the RAINS server under test.")
+var name = flag.String("name", "", `fully qualified name (e.g. www.ethz.ch.) which exists in the server's zone or which the
+		server can resolve. It is used for the query checks and for checking that the server is still responsive.`)
+var context = flag.String("c", ".", "context of the queries.")
+var queryType = flag.String("t", "ip4", "type of the queries, by name (e.g. ip4, ip6, deleg, redir, srv) or by number.")
+var anchor = flag.String("anchor", "", `path to the self signed root delegation assertion. When set, the signatures of the
+		answer are verified along the delegation chain, which is requested from the server under test.`)
+var runPattern = flag.String("run", "", "regular expression selecting the checks to run by name. All checks run if it is not set.")
+var timeout = flag.Duration("timeout", 5*time.Second, "how long to wait for the server's response in each check.")
+var validity = flag.Duration("validity", 10*time.Second, "validity of each query after it has been sent.")
+var strict = flag.Bool("strict", false, "exit with status 1 if a check produced a warning, i.e. a SHOULD requirement is not met.")
+var format = flag.String("fmt", "text", `output format of the report. Supported values are:
+		text: one line per check followed by a summary
+		json: a json object with the results of all checks`)
+
+//main runs the selected checks against the server and prints the report.
+func main() {
+	flag.Parse()
+	if *format != "text" && *format != "json" {
+		exitf("unsupported output format: %s", *format)
+	}
+	if *name == "" || !strings.HasSuffix(*name, ".") {
+		exitf("-name must be set to a fully qualified name ending with a dot")
+	}
+	t, err := object.ParseType(*queryType)
+	if err != nil {
+		exitf("invalid query type: %v", err)
+	}
+	var selected *regexp.Regexp
+	if *runPattern != "" {
+		if selected, err = regexp.Compile(*runPattern); err != nil {
+			exitf("invalid -run pattern: %v", err)
+		}
+	}
+	addr, err := net.ResolveTCPAddr("tcp", *server)
+	if err != nil {
+		exitf("invalid server address: %v", err)
+	}
+	tr := &tester{
+		addr:     addr,
+		name:     *name,
+		context:  *context,
+		types:    []object.Type{t},
+		anchor:   *anchor,
+		timeout:  *timeout,
+		validity: *validity,
+	}
+	rep := runChecks(tr, checks, selected)
+	if err := rep.print(); err != nil {
+		exitf("%v", err)
+	}
+	if rep.Failed > 0 || (*strict && rep.Warnings > 0) {
+		os.Exit(1)
+	}
+}
+
+//exitf prints the formatted error message to stderr and exits with status 1.
+func exitf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
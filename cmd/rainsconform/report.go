@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+//Outcomes of a check.
+const (
+	statusPass = "pass"
+	statusWarn = "warn"
+	statusFail = "fail"
+	statusSkip = "skip"
+	//statusDeviation is returned by checks whose outcome depends on the requirement level. It is
+	//reported as warning for SHOULD requirements and as failure for MUST requirements.
+	statusDeviation = "deviation"
+)
+
+//result is the outcome of a check.
+type result struct {
+	Name        string  `json:"name"`
+	Level       string  `json:"level"`
+	Description string  `json:"description"`
+	Status      string  `json:"status"`
+	Detail      string  `json:"detail,omitempty"`
+	DurationMs  float64 `json:"durationMs"`
+}
+
+//passed returns the result of a check which passed.
+func passed(format string, args ...interface{}) result {
+	return result{Status: statusPass, Detail: fmt.Sprintf(format, args...)}
+}
+
+//failed returns the result of a check which failed regardless of its requirement level.
+func failed(format string, args ...interface{}) result {
+	return result{Status: statusFail, Detail: fmt.Sprintf(format, args...)}
+}
+
+//skipped returns the result of a check which could not be run.
+func skipped(format string, args ...interface{}) result {
+	return result{Status: statusSkip, Detail: fmt.Sprintf(format, args...)}
+}
+
+//deviated returns the result of a check in which the server did not behave as required.
+func deviated(format string, args ...interface{}) result {
+	return result{Status: statusDeviation, Detail: fmt.Sprintf(format, args...)}
+}
+
+//report contains the results of all checks run against a server.
+type report struct {
+	Server   string   `json:"server"`
+	Passed   int      `json:"passed"`
+	Warnings int      `json:"warnings"`
+	Failed   int      `json:"failed"`
+	Skipped  int      `json:"skipped"`
+	Results  []result `json:"results"`
+}
+
+//runChecks runs the checks whose name matches selected, or all if it is nil, against the server of
+//t and returns the report.
+func runChecks(t *tester, checks []check, selected *regexp.Regexp) report {
+	rep := report{Server: t.addr.String(), Results: []result{}}
+	abort := ""
+	for _, c := range checks {
+		if selected != nil && !selected.MatchString(c.name) {
+			continue
+		}
+		var r result
+		start := time.Now()
+		if abort != "" {
+			r = skipped("%s did not pass", abort)
+		} else {
+			r = c.run(t)
+		}
+		r.Name, r.Level, r.Description = c.name, c.level, c.description
+		r.DurationMs = float64(time.Since(start)) / float64(time.Millisecond)
+		if r.Status == statusDeviation {
+			r.Status = statusFail
+			if c.level == should {
+				r.Status = statusWarn
+			}
+		}
+		if c.fatal && r.Status != statusPass {
+			abort = c.name
+		}
+		switch r.Status {
+		case statusPass:
+			rep.Passed++
+		case statusWarn:
+			rep.Warnings++
+		case statusFail:
+			rep.Failed++
+		case statusSkip:
+			rep.Skipped++
+		}
+		rep.Results = append(rep.Results, r)
+	}
+	return rep
+}
+
+//print writes the report to stdout in the format specified by the fmt flag.
+func (r report) print() error {
+	if *format == "json" {
+		encoding, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("could not encode report: %v", err)
+		}
+		fmt.Println(string(encoding))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, res := range r.Results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", strings.ToUpper(res.Status), res.Name, res.Level,
+			res.Description)
+		if res.Detail != "" {
+			fmt.Fprintf(w, "\t\t\t;; %s\n", res.Detail)
+		}
+	}
+	w.Flush()
+	fmt.Printf("\n%s: %d passed, %d warnings, %d failed, %d skipped\n", r.Server, r.Passed,
+		r.Warnings, r.Failed, r.Skipped)
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/probe"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+var (
+	//errTimeout is returned if no matching message arrived before the deadline.
+	errTimeout = errors.New("no response within the timeout")
+	//errClosed is returned if the server closed the connection or sent something which is not a
+	//message.
+	errClosed = errors.New("the server closed the connection")
+)
+
+//session is a connection to the server under test.
+type session struct {
+	conn   net.Conn
+	reader cbor.Reader
+	//received contains all messages read from the connection, including the ones which did not
+	//match.
+	received []message.Message
+}
+
+//dial opens a new session with the server at addr. As in rainsreplay, the server's certificate is
+//not verified.
+func dial(addr net.Addr) (*session, error) {
+	conn, err := connection.CreateConnection(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &session{conn: conn, reader: cbor.NewReader(conn)}, nil
+}
+
+//close closes the session's connection.
+func (s *session) close() {
+	s.conn.Close()
+}
+
+//send encodes msg and sends it to the server.
+func (s *session) send(msg message.Message, timeout time.Duration) error {
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+		return err
+	}
+	return s.sendRaw(encoding.Bytes(), timeout)
+}
+
+//sendRaw sends data to the server without modifying it.
+func (s *session) sendRaw(data []byte, timeout time.Duration) error {
+	s.conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err := s.conn.Write(data)
+	return err
+}
+
+//receive reads messages until one for which match returns true arrives and returns it. It returns
+//errTimeout if none arrived within timeout and errClosed if the connection broke.
+func (s *session) receive(match func(message.Message) bool, timeout time.Duration) (
+	message.Message, error) {
+	deadline := time.Now().Add(timeout)
+	s.conn.SetReadDeadline(deadline)
+	for {
+		var msg message.Message
+		if err := s.reader.Unmarshal(&msg); err != nil {
+			//The reader wraps the errors of the connection, a timeout is recognized by the deadline.
+			if !time.Now().Before(deadline) {
+				return message.Message{}, errTimeout
+			}
+			return message.Message{}, errClosed
+		}
+		s.received = append(s.received, msg)
+		if match(msg) {
+			return msg, nil
+		}
+	}
+}
+
+//exchange sends msg and returns the response carrying its token.
+func (s *session) exchange(msg message.Message, timeout time.Duration) (message.Message, error) {
+	if err := s.send(msg, timeout); err != nil {
+		return message.Message{}, errClosed
+	}
+	return s.receive(answers(msg.Token), timeout)
+}
+
+//answers returns a function matching the responses to the message with token tok.
+func answers(tok token.Token) func(message.Message) bool {
+	return func(msg message.Message) bool {
+		return probe.AnswerToken(msg) == tok
+	}
+}
+
+//notifies returns a function matching messages whose first section is a notification of type t.
+func notifies(t section.NotificationType) func(message.Message) bool {
+	return func(msg message.Message) bool {
+		n := notificationOf(msg)
+		return n != nil && n.Type == t
+	}
+}
+
+//notificationOf returns the notification at the start of msg or nil if there is none.
+func notificationOf(msg message.Message) *section.Notification {
+	if len(msg.Content) > 0 {
+		if n, ok := msg.Content[0].(*section.Notification); ok {
+			return n
+		}
+	}
+	return nil
+}
+
+//encodeMap returns the encoding of a message with the given map, which does not have to be valid.
+func encodeMap(m map[int]interface{}) ([]byte, error) {
+	encoding := new(bytes.Buffer)
+	w := cbor.NewWriter(encoding)
+	if err := w.WriteTag(cbor.MessageTag); err != nil {
+		return nil, err
+	}
+	if err := w.WriteIntMap(m); err != nil {
+		return nil, err
+	}
+	return encoding.Bytes(), nil
+}
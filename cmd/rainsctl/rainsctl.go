@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+)
+
+var socketPath = flag.String("socket", "/var/run/rainsd.sock", "path to the admin socket of the rainsd server.")
+var format = flag.String("fmt", "table", `output format. Supported values are:
+		table: human readable tables
+		json: the raw json result returned by the server`)
+var timeout = flag.Duration("timeout", 10*time.Second, "how long to wait for the server's response.")
+
+//commands maps the subcommands of rainsctl to the command sent to the admin socket.
+var commands = map[string]string{
+	"stats":            rainsd.AdminStats,
+	"conns":            rainsd.AdminConnections,
+	"loglevel":         rainsd.AdminLogLevel,
+	"reload":           rainsd.AdminReload,
+	"cache flush":      rainsd.AdminCacheFlush,
+	"cache dump":       rainsd.AdminCacheDump,
+	"blacklist add":    rainsd.AdminBlacklistAdd,
+	"blacklist remove": rainsd.AdminBlacklistRemove,
+	"blacklist list":   rainsd.AdminBlacklistList,
+	"trace":            rainsd.AdminTrace,
+}
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rainsctl [options] <command> [args]
+
+Commands:
+  stats                                               show cache, queue and worker utilization
+  conns                                               list the open connections
+  loglevel <debug|info|warn|error|crit>               change the log level
+  reload                                              apply the changed settings of the config file
+  cache flush <assertions|negassertions|all> [zone]   remove cached entries
+  cache dump <assertions|negassertions|zonekeys>      print cached entries in zonefile format
+  blacklist add <ip|cidr>...                          refuse connections from the given addresses
+  blacklist remove <ip|cidr>...                       accept connections from the given addresses again
+  blacklist list                                      list the blacklisted addresses
+  trace <token>                                       show the path of the traced query with the given token
+
+Options:
+`)
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unsupported output format: %s\n", *format)
+		os.Exit(1)
+	}
+	req, err := parseCommand(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	resp, err := rainsd.AdminCall(*socketPath, req, *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not contact server at %s: %v\n", *socketPath, err)
+		os.Exit(1)
+	}
+	if resp.Error != "" {
+		fmt.Fprintf(os.Stderr, "server returned an error: %s\n", resp.Error)
+		os.Exit(1)
+	}
+	if err := printResult(req.Command, resp.Result); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+//parseCommand returns the admin request corresponding to the command line arguments args.
+func parseCommand(args []string) (rainsd.AdminRequest, error) {
+	if len(args) == 0 {
+		return rainsd.AdminRequest{}, fmt.Errorf("no command given")
+	}
+	if cmd, ok := commands[args[0]]; ok {
+		return rainsd.AdminRequest{Command: cmd, Args: args[1:]}, nil
+	}
+	if len(args) > 1 {
+		if cmd, ok := commands[args[0]+" "+args[1]]; ok {
+			return rainsd.AdminRequest{Command: cmd, Args: args[2:]}, nil
+		}
+	}
+	return rainsd.AdminRequest{}, fmt.Errorf("unknown command: %s", strings.Join(args, " "))
+}
+
+//printResult writes the result of command to stdout in the format specified by the fmt flag.
+func printResult(command string, result json.RawMessage) error {
+	if *format == "json" {
+		var out bytes.Buffer
+		if err := json.Indent(&out, result, "", "  "); err != nil {
+			return fmt.Errorf("could not format result: %v", err)
+		}
+		fmt.Println(out.String())
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	switch command {
+	case rainsd.AdminStats:
+		var stats rainsd.AdminStatistics
+		if err := json.Unmarshal(result, &stats); err != nil {
+			return fmt.Errorf("malformed statistics: %v", err)
+		}
+		fmt.Fprintf(w, "UPTIME\t%s\n", stats.Uptime.Round(time.Second))
+		fmt.Fprintf(w, "MODE\t%s\n", stats.Mode)
+		fmt.Fprintf(w, "CONNECTIONS\t%d\n", stats.Connections)
+		fmt.Fprintf(w, "BLACKLISTED\t%d\n", stats.Blacklisted)
+		fmt.Fprintln(w, "\t")
+		printCounts(w, "CACHE", "ENTRIES", stats.Caches)
+		fmt.Fprintln(w, "\t")
+		printCounts(w, "QUEUE", "LENGTH", stats.Queues)
+		fmt.Fprintln(w, "\t")
+		printCounts(w, "WORKERS", "BUSY", stats.Workers)
+		if stats.Mirror != nil {
+			fmt.Fprintln(w, "\t")
+			printCounts(w, "MIRROR", "QUERIES", stats.Mirror)
+		}
+		if stats.Prefetch != nil {
+			fmt.Fprintln(w, "\t")
+			printCounts(w, "PREFETCH", "ANSWERS", stats.Prefetch)
+		}
+		if stats.RateLimit != nil {
+			fmt.Fprintln(w, "\t")
+			printCounts(w, "RATE LIMIT", "ANSWERS", stats.RateLimit)
+		}
+		if len(stats.Inconsistencies) > 0 {
+			fmt.Fprintln(w, "\t")
+			printCounts(w, "INCONSISTENT ZONE", "DROPPED", stats.Inconsistencies)
+		}
+		if len(stats.Queries) > 0 {
+			fmt.Fprintln(w, "\t")
+			printCounts(w, "ZONE", "QUERIES", stats.Queries)
+		}
+		if len(stats.Peers) > 0 {
+			fmt.Fprintln(w, "\t")
+			fmt.Fprintln(w, "PEER\tCONNECTED\tRECEIVED\tSENT\tFAILED\tLAST ERROR")
+			for _, p := range stats.Peers {
+				fmt.Fprintf(w, "%s\t%t\t%d\t%d\t%d\t%s\n", p.Addr, p.Connected, p.Received, p.Sent,
+					p.Failed, p.LastError)
+			}
+		}
+		if len(stats.Notifications) > 0 {
+			fmt.Fprintln(w, "\t")
+			fmt.Fprintln(w, "NOTIFICATION\tDIRECTION\tPEER\tTYPE\tDATA")
+			for _, n := range stats.Notifications {
+				direction := "received"
+				if n.Sent {
+					direction = "sent"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", n.Time.Format(time.RFC3339), direction,
+					n.Peer, n.Type, n.Data)
+			}
+		}
+	case rainsd.AdminCacheFlush:
+		var removed rainsd.AdminFlushResult
+		if err := json.Unmarshal(result, &removed); err != nil {
+			return fmt.Errorf("malformed flush result: %v", err)
+		}
+		printCounts(w, "CACHE", "REMOVED", removed)
+	case rainsd.AdminCacheDump:
+		var sections []string
+		if err := json.Unmarshal(result, &sections); err != nil {
+			return fmt.Errorf("malformed cache dump: %v", err)
+		}
+		for _, s := range sections {
+			fmt.Println(s)
+		}
+	case rainsd.AdminConnections, rainsd.AdminBlacklistAdd, rainsd.AdminBlacklistRemove,
+		rainsd.AdminBlacklistList, rainsd.AdminReload:
+		var entries []string
+		if err := json.Unmarshal(result, &entries); err != nil {
+			return fmt.Errorf("malformed result: %v", err)
+		}
+		for _, e := range entries {
+			fmt.Fprintln(w, e)
+		}
+	case rainsd.AdminLogLevel:
+		var level string
+		if err := json.Unmarshal(result, &level); err != nil {
+			return fmt.Errorf("malformed result: %v", err)
+		}
+		fmt.Fprintf(w, "log level set to %s\n", level)
+	case rainsd.AdminTrace:
+		var hops []rainsd.AdminTraceHop
+		if err := json.Unmarshal(result, &hops); err != nil {
+			return fmt.Errorf("malformed trace: %v", err)
+		}
+		fmt.Fprintln(w, "TIME\tEVENT\tPEER\tTOKEN\tRTT\tDETAIL")
+		for _, h := range hops {
+			rtt := ""
+			if h.RTT != 0 {
+				rtt = h.RTT.String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", h.Time.Format(time.RFC3339Nano), h.Event,
+				h.Peer, h.Token, rtt, h.Detail)
+		}
+	}
+	return nil
+}
+
+//printCounts writes counts as a two column table with the given headers sorted by key.
+func printCounts(w *tabwriter.Writer, keyHeader, valueHeader string, counts map[string]int) {
+	var keys []string
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(w, "%s\t%s\n", keyHeader, valueHeader)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s\t%d\n", k, counts[k])
+	}
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 
-	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
 	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
 	"github.com/netsec-ethz/rains/tools/keycreator"
 )
 
+var shutdownTimeout = flag.Duration("shutdownTimeout", 30*time.Second, "How long the server "+
+	"waits for the queries being processed when it is stopped before it drops them.")
+
 func main() {
+	flag.Parse()
 	keycreator.DelegationAssertion(".", ".", "keys/selfSignedRootDelegationAssertion.gob", "keys/rootPrivateKey.txt")
 	server, err := rainsd.New("config/server.conf", "0")
 	if err != nil {
@@ -18,9 +26,20 @@ func main() {
 		return
 	}
 	log.Info("Server successfully initialized")
-	server.SetResolver(libresolve.New(nil, nil, libresolve.Recursive, server.Addr(), 10000))
 	go server.Start(false)
-	time.Sleep(time.Hour)
-	server.Shutdown()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	for ; sig == syscall.SIGHUP; sig = <-signals {
+		if _, err := server.Reload(); err != nil {
+			log.Error("Could not reload the configuration", "error", err)
+		}
+	}
+	log.Info("Shutting down server", "signal", sig)
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Warn("Queries were dropped during shutdown", "error", err)
+	}
 	log.Info("Server shut down")
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/probe"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//batchQuery is a query of a batch file together with its outcome.
+type batchQuery struct {
+	Name      string            `json:"name"`
+	Context   string            `json:"context"`
+	Types     []object.Type     `json:"-"`
+	TypeNames []string          `json:"types"`
+	RTT       time.Duration     `json:"-"`
+	RTTMs     float64           `json:"rttMs"`
+	Error     string            `json:"error,omitempty"`
+	Answer    []section.Section `json:"sections"`
+	msg       message.Message
+	sent      time.Time
+	done      bool
+}
+
+//parseBatchFile returns the queries stored at path. Each line contains a name optionally followed
+//by a type and a context. If they are missing, all types and the context of the c flag are used.
+//Empty lines and lines starting with # are ignored.
+func parseBatchFile(path string) ([]*batchQuery, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var queries []*batchQuery
+	scanner := bufio.NewScanner(file)
+	for lineNr := 1; scanner.Scan(); lineNr++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected name [type [context]]", path, lineNr)
+		}
+		q := &batchQuery{Name: fields[0], Context: *context, Types: anyQuery}
+		if len(fields) > 1 {
+			t, err := object.ParseType(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, lineNr, err)
+			}
+			q.Types = []object.Type{t}
+		}
+		if len(fields) > 2 {
+			q.Context = fields[2]
+		}
+		q.TypeNames = typeNames(q.Types)
+		q.msg = util.NewQueryMessage(q.Name, q.Context, *expires, q.Types, queryOptions, token.New())
+		queries = append(queries, q)
+	}
+	return queries, scanner.Err()
+}
+
+//runBatch sends all queries over a single connection to addr and matches the responses by token.
+//It waits at most timeout after the last query has been sent and returns the total time. If
+//tlsConfig is nil, the server's certificate is not verified.
+func runBatch(queries []*batchQuery, addr net.Addr, timeout time.Duration, tlsConfig *tls.Config) (
+	time.Duration, error) {
+	conn, err := connection.CreateTLSConnection(addr, tlsConfig)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	var mux sync.Mutex
+	pending := make(map[token.Token]*batchQuery)
+	answered := make(chan bool, len(queries))
+	go func() {
+		reader := codec.NewReader(conn, cbor.Limits{})
+		for {
+			msg, err := reader.Read()
+			if err != nil {
+				close(answered)
+				return
+			}
+			mux.Lock()
+			tok := probe.AnswerToken(*msg)
+			if _, ok := pending[tok]; !ok {
+				tok = msg.Token
+			}
+			if q, ok := pending[tok]; ok {
+				q.RTT = time.Since(q.sent)
+				q.Answer = msg.Content
+				q.done = true
+				delete(pending, tok)
+				answered <- true
+			}
+			mux.Unlock()
+		}
+	}()
+	start := time.Now()
+	c := codec.CBOR{}
+	remaining := 0
+	for _, q := range queries {
+		mux.Lock()
+		pending[q.msg.Token] = q
+		q.sent = time.Now()
+		mux.Unlock()
+		if err := c.Encode(conn, &q.msg); err != nil {
+			return 0, fmt.Errorf("could not send query for %s: %v", q.Name, err)
+		}
+		remaining++
+	}
+	deadline := time.After(timeout)
+	for remaining > 0 {
+		select {
+		case _, ok := <-answered:
+			if !ok {
+				remaining = 0
+				break
+			}
+			remaining--
+		case <-deadline:
+			remaining = 0
+		}
+	}
+	total := time.Since(start)
+	mux.Lock()
+	defer mux.Unlock()
+	for _, q := range queries {
+		if !q.done {
+			q.Error = "no response received"
+		}
+		q.RTTMs = float64(q.RTT) / float64(time.Millisecond)
+	}
+	return total, nil
+}
+
+//printBatch writes the per query results and aggregate timing to stdout in the format specified
+//by the fmt flag.
+func printBatch(queries []*batchQuery, total time.Duration) error {
+	var nofAnswered int
+	var minRTT, maxRTT, sum time.Duration
+	for _, q := range queries {
+		if q.Error != "" {
+			continue
+		}
+		if nofAnswered == 0 || q.RTT < minRTT {
+			minRTT = q.RTT
+		}
+		if q.RTT > maxRTT {
+			maxRTT = q.RTT
+		}
+		sum += q.RTT
+		nofAnswered++
+	}
+	var avg time.Duration
+	if nofAnswered > 0 {
+		avg = sum / time.Duration(nofAnswered)
+	}
+	if *format == "json" {
+		encoding, err := json.Marshal(struct {
+			Queries  []*batchQuery `json:"queries"`
+			Answered int           `json:"answered"`
+			Failed   int           `json:"failed"`
+			TotalMs  float64       `json:"totalMs"`
+			AvgRTTMs float64       `json:"avgRttMs"`
+		}{queries, nofAnswered, len(queries) - nofAnswered,
+			float64(total) / float64(time.Millisecond), float64(avg) / float64(time.Millisecond)})
+		if err != nil {
+			return fmt.Errorf("could not encode results: %v", err)
+		}
+		fmt.Println(string(encoding))
+		return nil
+	}
+	for _, q := range queries {
+		if q.Error != "" {
+			fmt.Printf(";; %s %s %v: %s\n\n", q.Name, q.Context, q.TypeNames, q.Error)
+			continue
+		}
+		fmt.Printf(";; %s %s %v: %d section(s) in %v\n", q.Name, q.Context, q.TypeNames, len(q.Answer),
+			q.RTT.Round(time.Microsecond))
+		if err := printSections(q.Answer, q.Types, nil); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	fmt.Printf(";; %d queries, %d answered, %d failed in %v\n", len(queries), nofAnswered,
+		len(queries)-nofAnswered, total.Round(time.Microsecond))
+	if nofAnswered > 0 {
+		fmt.Printf(";; rtt min/avg/max: %v/%v/%v\n", minRTT.Round(time.Microsecond),
+			avg.Round(time.Microsecond), maxRTT.Round(time.Microsecond))
+	}
+	return nil
+}
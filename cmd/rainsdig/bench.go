@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/probe"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//benchSample is the outcome of a single query sent in benchmark mode.
+type benchSample struct {
+	RTT    time.Duration
+	Failed bool
+}
+
+//runBench sends the query count times to servers and returns the samples in the order the queries
+//were sent. If qps is positive, the queries are sent at this rate without waiting for the
+//responses. Otherwise, the next query is sent as soon as the previous one was answered. As the
+//queries are sent over a longer period, each query expires timeout after it was sent.
+func runBench(types []object.Type, servers []net.Addr, tlsConfig *tls.Config, count int,
+	qps float64) []benchSample {
+	samples := make([]benchSample, count)
+	send := func(i int) {
+		msg := util.NewQueryMessage(*name, *context, time.Now().Add(*timeout).Unix(), types,
+			queryOptions, token.New())
+		start := time.Now()
+		_, _, err := queryServers(msg, servers, *timeout, *retries, *parallel, tlsConfig)
+		samples[i] = benchSample{RTT: time.Since(start), Failed: err != nil}
+	}
+	if qps <= 0 {
+		for i := 0; i < count; i++ {
+			send(i)
+		}
+		return samples
+	}
+	var wg sync.WaitGroup
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / qps))
+	defer ticker.Stop()
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			<-ticker.C
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			send(i)
+		}(i)
+	}
+	wg.Wait()
+	return samples
+}
+
+//runBatchBench sends the queries of the batch file count times and returns the samples of all
+//queries. If qps is positive, the rounds are spaced such that on average qps queries are sent per
+//second.
+func runBatchBench(servers []net.Addr, tlsConfig *tls.Config, count int, qps float64) (
+	[]benchSample, error) {
+	var samples []benchSample
+	var interval time.Duration
+	for i := 0; i < count; i++ {
+		queries, err := parseBatchFile(*batchPath)
+		if err != nil {
+			return nil, err
+		}
+		if qps > 0 && interval == 0 {
+			interval = time.Duration(float64(len(queries)) * float64(time.Second) / qps)
+		}
+		start := time.Now()
+		if _, err := runBatch(queries, servers[0], *timeout, tlsConfig); err != nil {
+			return nil, err
+		}
+		for _, q := range queries {
+			samples = append(samples, benchSample{RTT: q.RTT, Failed: q.Error != ""})
+		}
+		if wait := interval - time.Since(start); i < count-1 && wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return samples, nil
+}
+
+//benchStats summarizes the samples of a benchmark run. Responses which arrive in less than half
+//the round trip time of the first successful response are counted as likely cache hits, as the
+//first query usually had to be resolved while later ones are answered from the cache.
+type benchStats struct {
+	Queries   int     `json:"queries"`
+	Succeeded int     `json:"succeeded"`
+	Failed    int     `json:"failed"`
+	Success   float64 `json:"successRate"`
+	CacheHits int     `json:"likelyCacheHits"`
+	MinMs     float64 `json:"minMs"`
+	P50Ms     float64 `json:"p50Ms"`
+	P90Ms     float64 `json:"p90Ms"`
+	P99Ms     float64 `json:"p99Ms"`
+	MaxMs     float64 `json:"maxMs"`
+	TotalMs   float64 `json:"totalMs"`
+}
+
+//newBenchStats computes the statistics of samples collected within total.
+func newBenchStats(samples []benchSample, total time.Duration) benchStats {
+	stats := benchStats{Queries: len(samples), TotalMs: probe.ToMs(total)}
+	var rtts []time.Duration
+	var first time.Duration
+	for _, s := range samples {
+		if s.Failed {
+			stats.Failed++
+			continue
+		}
+		if len(rtts) == 0 {
+			first = s.RTT
+		} else if s.RTT < first/2 {
+			stats.CacheHits++
+		}
+		rtts = append(rtts, s.RTT)
+	}
+	stats.Succeeded = len(rtts)
+	if stats.Queries > 0 {
+		stats.Success = float64(stats.Succeeded) / float64(stats.Queries)
+	}
+	if len(rtts) == 0 {
+		return stats
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	stats.MinMs = probe.ToMs(rtts[0])
+	stats.P50Ms = probe.ToMs(probe.Percentile(rtts, 50))
+	stats.P90Ms = probe.ToMs(probe.Percentile(rtts, 90))
+	stats.P99Ms = probe.ToMs(probe.Percentile(rtts, 99))
+	stats.MaxMs = probe.ToMs(rtts[len(rtts)-1])
+	return stats
+}
+
+//print writes the statistics to stdout in the format specified by the fmt flag.
+func (s benchStats) print() error {
+	if *format == "json" {
+		encoding, err := json.Marshal(s)
+		if err != nil {
+			return fmt.Errorf("could not encode statistics: %v", err)
+		}
+		fmt.Println(string(encoding))
+		return nil
+	}
+	fmt.Printf(";; %d queries, %d succeeded (%.1f%%), %d failed in %.3fms\n", s.Queries, s.Succeeded,
+		100*s.Success, s.Failed, s.TotalMs)
+	if s.Succeeded > 0 {
+		fmt.Printf(";; rtt min/p50/p90/p99/max: %.3f/%.3f/%.3f/%.3f/%.3f ms\n", s.MinMs, s.P50Ms,
+			s.P90Ms, s.P99Ms, s.MaxMs)
+		fmt.Printf(";; likely cache hits: %d\n", s.CacheHits)
+	}
+	return nil
+}
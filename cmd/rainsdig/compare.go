@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/probe"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//serverAnswer is the response of a single server in compare mode.
+type serverAnswer struct {
+	Server   string            `json:"server"`
+	RTTMs    float64           `json:"rttMs"`
+	Error    string            `json:"error,omitempty"`
+	Sections []section.Section `json:"sections"`
+}
+
+//comparedSection is a distinct section together with the servers which returned it.
+type comparedSection struct {
+	Section section.Section `json:"section"`
+	Servers []string        `json:"servers"`
+}
+
+//comparison is the outcome of comparing the answers of several servers to the same query.
+type comparison struct {
+	//Agree is true if all servers answered and returned the same set of sections.
+	Agree   bool           `json:"agree"`
+	Answers []serverAnswer `json:"answers"`
+	//Common contains the sections returned by all servers which answered.
+	Common []section.Section `json:"common"`
+	//Divergent contains the sections which were not returned by all servers which answered.
+	Divergent []comparedSection `json:"divergent"`
+}
+
+//queryAll sends msg to all servers at once and returns their answers in the order of servers.
+//Each server gets its own token.
+func queryAll(msg message.Message, servers []net.Addr, timeout time.Duration,
+	tlsConfig *tls.Config) []serverAnswer {
+	answers := make([]serverAnswer, len(servers))
+	done := make(chan struct{})
+	for i, server := range servers {
+		msg.Token = token.New()
+		go func(i int, msg message.Message, server net.Addr) {
+			start := time.Now()
+			answer, err := sendQuery(msg, server, timeout, tlsConfig)
+			answers[i] = serverAnswer{Server: server.String(), RTTMs: probe.ToMs(time.Since(start))}
+			if err != nil {
+				answers[i].Error = err.Error()
+			} else {
+				answers[i].Sections = answer.Content
+			}
+			done <- struct{}{}
+		}(i, msg, server)
+	}
+	for range servers {
+		<-done
+	}
+	return answers
+}
+
+//compareAnswers groups the sections of all successful answers by their encoding and determines
+//which of them were not returned by every server that answered.
+func compareAnswers(answers []serverAnswer) comparison {
+	c := comparison{Agree: true, Answers: answers}
+	var encodings []string
+	sections := make(map[string]*comparedSection)
+	nofAnswered := 0
+	for _, a := range answers {
+		if a.Error != "" {
+			c.Agree = false
+			continue
+		}
+		nofAnswered++
+		//a server returning the same section twice is only counted once
+		seen := make(map[string]bool)
+		for _, s := range a.Sections {
+			encoding := zfParser.EncodeSection(s)
+			if seen[encoding] {
+				continue
+			}
+			seen[encoding] = true
+			cs, ok := sections[encoding]
+			if !ok {
+				cs = &comparedSection{Section: s}
+				sections[encoding] = cs
+				encodings = append(encodings, encoding)
+			}
+			cs.Servers = append(cs.Servers, a.Server)
+		}
+	}
+	c.Common = []section.Section{}
+	c.Divergent = []comparedSection{}
+	for _, encoding := range encodings {
+		cs := sections[encoding]
+		if len(cs.Servers) == nofAnswered {
+			c.Common = append(c.Common, cs.Section)
+		} else {
+			c.Divergent = append(c.Divergent, *cs)
+			c.Agree = false
+		}
+	}
+	return c
+}
+
+//answered returns true if at least one server answered.
+func (c comparison) answered() bool {
+	for _, a := range c.Answers {
+		if a.Error == "" {
+			return true
+		}
+	}
+	return false
+}
+
+//print writes the comparison to stdout in the format specified by the fmt flag.
+func (c comparison) print() error {
+	if *format == "json" {
+		encoding, err := json.Marshal(c)
+		if err != nil {
+			return fmt.Errorf("could not encode comparison: %v", err)
+		}
+		fmt.Println(string(encoding))
+		return nil
+	}
+	for _, a := range c.Answers {
+		if a.Error != "" {
+			fmt.Printf(";; %s failed after %.3fms: %s\n", a.Server, a.RTTMs, a.Error)
+		} else {
+			fmt.Printf(";; %s answered in %.3fms with %d section(s)\n", a.Server, a.RTTMs,
+				len(a.Sections))
+		}
+	}
+	if c.Agree {
+		fmt.Printf(";; answers agree: %d section(s) returned by all servers\n\n", len(c.Common))
+	} else {
+		fmt.Printf(";; ANSWERS DIVERGE: %d section(s) not returned by all answering servers\n\n",
+			len(c.Divergent))
+	}
+	if len(c.Common) > 0 && !c.Agree {
+		fmt.Println(";; returned by all answering servers:")
+	}
+	for _, s := range c.Common {
+		fmt.Println(zfParser.EncodeSection(s))
+	}
+	for _, cs := range c.Divergent {
+		fmt.Printf(";; returned only by %s%s:\n", strings.Join(cs.Servers, ", "),
+			sigExpiry(cs.Section))
+		fmt.Println(zfParser.EncodeSection(cs.Section))
+	}
+	return nil
+}
+
+//sigExpiry returns a description of the latest expiration time of s's signatures or an empty
+//string if s is not signed. It helps to spot servers serving stale sections.
+func sigExpiry(s section.Section) string {
+	sec, ok := s.(section.WithSig)
+	if !ok {
+		return ""
+	}
+	var validUntil int64
+	for _, sig := range sec.AllSigs() {
+		if sig.ValidUntil > validUntil {
+			validUntil = sig.ValidUntil
+		}
+	}
+	if validUntil == 0 {
+		return ""
+	}
+	return ", signed until " + time.Unix(validUntil, 0).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//Exit codes of rainsdig when the server answered with a notification. Notifications are grouped
+//into classes such that scripts can branch on the outcome of a query.
+const (
+	exitNoAssertion = 4
+	exitNotCapable  = 5
+	exitBadMessage  = 6
+	exitRateLimited = 7
+	exitServerError = 8
+	exitDenied      = 9
+)
+
+//notificationInfo contains the name, explanation and exit code of a notification type.
+type notificationInfo struct {
+	name        string
+	explanation string
+	exitCode    int
+}
+
+var notificationInfos = map[section.NotificationType]notificationInfo{
+	section.NTHeartbeat: {"Heartbeat", "the server is alive, the query was not answered",
+		exitServerError},
+	section.NTStaleAnswer: {"StaleAnswer",
+		"the server could not refresh the answer and served expired assertions", 0},
+	section.NTTokenTrace: {"TokenTrace",
+		"the path of the query through the server, contained in the data, was traced", 0},
+	section.NTCapHashNotKnown: {"CapHashNotKnown",
+		"the server does not know the hash of the capabilities list", exitNotCapable},
+	section.NTBadMessage: {"BadMessage", "the server could not parse the query", exitBadMessage},
+	section.NTAccessDenied: {"AccessDenied",
+		"the server does not accept queries from this address", exitDenied},
+	section.NTRcvInconsistentMsg: {"RcvInconsistentMsg",
+		"the server received an inconsistent message", exitBadMessage},
+	section.NTNoAssertionsExist: {"NoAssertionsExist",
+		"no assertion exists for the queried name, context and types", exitNoAssertion},
+	section.NTMsgTooLarge: {"MsgTooLarge", "the query exceeds the server's message size limit",
+		exitBadMessage},
+	section.NTRateLimited: {"RateLimited",
+		"the server limits the rate of its responses, retry later", exitRateLimited},
+	section.NTUnspecServerErr: {"UnspecServerErr", "the server encountered an unspecified error",
+		exitServerError},
+	section.NTServerNotCapable: {"ServerNotCapable",
+		"the server does not support a capability or option required by the query", exitNotCapable},
+	section.NTNoAssertionAvail: {"NoAssertionAvail",
+		"the server could not obtain an answer in time, e.g. because it could not reach an authority",
+		exitServerError},
+}
+
+//notificationOf returns the first notification contained in sections or nil if there is none.
+func notificationOf(sections []section.Section) *section.Notification {
+	for _, s := range sections {
+		if n, ok := s.(*section.Notification); ok {
+			return n
+		}
+	}
+	return nil
+}
+
+//describeNotification returns a human readable explanation of n and the exit code of its class.
+//Unknown notification types are treated as server errors.
+func describeNotification(n *section.Notification) (string, int) {
+	info, ok := notificationInfos[n.Type]
+	if !ok {
+		info = notificationInfo{"Unknown", "the server sent a notification of unknown type",
+			exitServerError}
+	}
+	desc := fmt.Sprintf(";; NOTIFICATION %d %s: %s", n.Type, info.name, info.explanation)
+	if n.Data != "" {
+		desc += fmt.Sprintf(" (data: %q)", n.Data)
+	}
+	return desc, info.exitCode
+}
+
+//reportNotification prints the explanation of each notification in sections and returns the exit
+//code of the class of the first one or 0 if sections do not contain a notification. Like
+//unverified sections, the explanations are written to stderr in the formats which are meant to be
+//processed further.
+func reportNotification(sections []section.Section) int {
+	first := notificationOf(sections)
+	if first == nil {
+		return 0
+	}
+	out := os.Stdout
+	if *format != "zonefile" {
+		out = os.Stderr
+	}
+	for _, s := range sections {
+		if n, ok := s.(*section.Notification); ok {
+			desc, _ := describeNotification(n)
+			fmt.Fprintln(out, desc)
+		}
+	}
+	_, code := describeNotification(first)
+	return code
+}
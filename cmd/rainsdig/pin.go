@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	gocontext "context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//serverTLSConfig returns a TLS configuration pinning the server's identity according to the pinSPKI
+//and pinCert flags. The certificate assertions for pinCert are requested from servers and verified
+//with v if it is not nil.
+func serverTLSConfig(servers []net.Addr, v *verifier) (*tls.Config, error) {
+	var spkiPin []byte
+	var certs []object.Certificate
+	var err error
+	if *pinSPKI != "" {
+		if spkiPin, err = parseSPKIPin(*pinSPKI); err != nil {
+			return nil, err
+		}
+	}
+	if *pinCert != "" {
+		if certs, err = fetchPinnedCerts(*pinCert, servers, v); err != nil {
+			return nil, err
+		}
+	}
+	return pinnedTLSConfig(spkiPin, certs), nil
+}
+
+//parseSPKIPin returns the sha256 hash of a subject public key info encoded in hexadecimal or in
+//base64 as used by HPKP.
+func parseSPKIPin(pin string) ([]byte, error) {
+	if hash, err := hex.DecodeString(pin); err == nil && len(hash) == sha256.Size {
+		return hash, nil
+	}
+	if hash, err := base64.StdEncoding.DecodeString(pin); err == nil && len(hash) == sha256.Size {
+		return hash, nil
+	}
+	return nil, fmt.Errorf("pinned SPKI hash must be a sha256 hash in hex or base64: %s", pin)
+}
+
+//pinnedTLSConfig returns a TLS configuration which only accepts a server whose certificate matches
+//spkiPin (if it is not nil) and at least one of certs (if it is not empty).
+func pinnedTLSConfig(spkiPin []byte, certs []object.Certificate) *tls.Config {
+	return &tls.Config{
+		//The certificate is checked against the pins instead of the web-PKI.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return checkPins(rawCerts, spkiPin, certs)
+		},
+	}
+}
+
+//checkPins returns an error if the certificate chain rawCerts sent by the server does not match
+//the pinned SPKI hash or none of the pinned certificates. End entity certificates must match the
+//server's certificate whereas trust anchors may match any certificate of the chain.
+func checkPins(rawCerts [][]byte, spkiPin []byte, certs []object.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("server did not present a certificate")
+	}
+	leaf, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("could not parse server certificate: %v", err)
+	}
+	if spkiPin != nil {
+		hash := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		if !bytes.Equal(hash[:], spkiPin) {
+			return fmt.Errorf("SPKI hash %s of server certificate does not match pin",
+				hex.EncodeToString(hash[:]))
+		}
+	}
+	if len(certs) == 0 {
+		return nil
+	}
+	for _, cert := range certs {
+		if cert.Type != object.PTTLS && cert.Type != object.PTUnspecified {
+			continue
+		}
+		chain := rawCerts[:1]
+		if cert.Usage == object.CUTrustAnchor {
+			chain = rawCerts
+		} else if cert.Usage != object.CUEndEntity {
+			continue
+		}
+		for _, raw := range chain {
+			if hash, ok := certHash(raw, cert.HashAlgo); ok && bytes.Equal(hash, cert.Data) {
+				return nil
+			}
+		}
+	}
+	return errors.New("server certificate does not match any pinned certificate assertion")
+}
+
+//certHash returns the hash of the DER encoded certificate raw with algo. It returns false if algo
+//is not supported.
+func certHash(raw []byte, algo algorithmTypes.Hash) ([]byte, bool) {
+	switch algo {
+	case algorithmTypes.NoHashAlgo:
+		return raw, true
+	case algorithmTypes.Sha256:
+		hash := sha256.Sum256(raw)
+		return hash[:], true
+	case algorithmTypes.Sha384:
+		hash := sha512.Sum384(raw)
+		return hash[:], true
+	case algorithmTypes.Sha512:
+		hash := sha512.Sum512(raw)
+		return hash[:], true
+	}
+	return nil, false
+}
+
+//fetchPinnedCerts requests the certificate assertions of name from servers. If v is not nil, the
+//assertions must be verifiable along the delegation chain. As the server's identity is not yet
+//established, the assertions are only trustworthy if they are verified.
+func fetchPinnedCerts(name string, servers []net.Addr, v *verifier) ([]object.Certificate, error) {
+	msg := util.NewQueryMessage(name, *context, time.Now().Add(*timeout).Unix(),
+		[]object.Type{object.OTCertInfo}, nil, token.New())
+	answer, _, err := queryServers(msg, servers, *timeout, *retries, *parallel, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch certificates of %s: %v", name, err)
+	}
+	var certs []object.Certificate
+	for _, a := range containedAssertions(answer.Content) {
+		if a.FQDN() != name {
+			continue
+		}
+		if v != nil {
+			if err := v.VerifySection(gocontext.Background(), a); err != nil {
+				return nil, fmt.Errorf("certificate assertion of %s is not verifiable: %v", name, err)
+			}
+		}
+		for _, o := range a.Content {
+			if cert, ok := o.Value.(object.Certificate); ok && o.Type == object.OTCertInfo {
+				certs = append(certs, cert)
+			}
+		}
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate assertion received for %s", name)
+	}
+	return certs, nil
+}
@@ -1,18 +1,23 @@
 package main
 
 import (
+	gocontext "context"
+	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/token"
 	"github.com/netsec-ethz/rains/internal/pkg/util"
 	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
@@ -23,86 +28,420 @@ var anyQuery = []object.Type{object.OTName, object.OTIP4Addr,
 
 //TODO add default values to description
 var revLookup = flag.String("x", "", "Reverse lookup, addr is an IPv4 address in dotted-decimal notation, or a colon-delimited IPv6 address.")
-var queryType = flag.Int("t", -1, "specifies the type for which dig issues a query.")
+var queryType = flag.String("t", "", `specifies the type for which dig issues a query, either by name (e.g. ip4, ip6,
+		deleg, redir, srv, cert, name) or by number. If it is not set, all types are queried.`)
 var name = flag.String("q", "", "sets the query's subjectName to this value.")
 var port = flag.Uint("p", 5022, "is the port number that dig will send its queries to.")
-var serverAddr = flag.String("s", "", `is the IP address of the name server to query.
-		This can be an IPv4 address in dotted-decimal notation or an IPv6 address in colon-delimited notation.`)
 var context = flag.String("c", ".", "context specifies the context for which dig issues a query.")
 var expires = flag.Int64("exp", time.Now().Add(10*time.Second).Unix(), "expires sets the valid until value of the query.")
-var filePath = flag.String("filePath", "", "specifies a file path where the query's response is appended to")
+var filePath = flag.String("filePath", "", `specifies a file path where the query's response is appended to. The exact
+		cbor encoded bytes are written as received.`)
+var queryFilePath = flag.String("queryFilePath", "", `specifies a file path where the query is appended to. The exact cbor
+		encoded bytes are written as sent.`)
+var replayPath = flag.String("replay", "", `path to a file containing a cbor encoded message, e.g. written by -queryFilePath.
+		The first message in the file is sent unchanged to the servers instead of a newly created query.`)
+var decodePath = flag.String("decode", "", `path to a file containing cbor encoded messages, e.g. written by -filePath.
+		Each message is printed as a json object whose fields correspond to the cbor map keys, and no query is sent.`)
 var insecureTLS = flag.Bool("insecureTLS", false, "when set it does not check the validity of the server's TLS certificate.")
+var trace = flag.Bool("trace", false, `when set, the name is resolved iteratively starting at the given
+		server, which is used as root server. Each delegation step is printed with the server contacted, the
+		round trip time and the received sections.`)
+var format = flag.String("fmt", "zonefile", `output format of the response. Supported values are:
+		zonefile: sections in zonefile format
+		json: a json array of the sections
+		short: only the values of the objects of the queried types, one per line`)
+var batchPath = flag.String("f", "", `reads queries from the given file, one per line as name [type [context]],
+		and sends them over a single connection to the server. Per query results and aggregate timing are printed.`)
+var trustAnchor = flag.String("trustAnchor", "", `path to the self signed root delegation assertion. When set,
+		the signatures of all received sections are verified along the delegation chain starting at this trust anchor.
+		The delegations are requested from the queried server. Unverifiable sections are marked in the output.`)
+var strict = flag.Bool("strict", false, "exit with status 2 if a received section could not be verified. Requires -trustAnchor.")
+var serverKey = flag.String("serverKey", "", `hex encoded ed25519 public infrastructure key of the queried server. When set, the
+		answer must be signed with this key by a server signing its answers, otherwise rdig exits with status 2.`)
+var timeout = flag.Duration("timeout", 10*time.Second, `how long to wait for a server's response. In batch mode, how long
+		to wait for outstanding responses.`)
+var retries = flag.Int("retries", 0, "number of times the query is retried on all servers when none of them answered.")
+var parallel = flag.Bool("parallel", false, "when set, the query is sent to all servers at once and the first answer is used.")
+var pinSPKI = flag.String("pinSPKI", "", `sha256 hash of the subject public key info of the server's TLS certificate in hex
+		or base64. The connection is aborted if the server's certificate does not match.`)
+var pinCert = flag.String("pinCert", "", `name whose certificate assertion the server's TLS certificate must match. The
+		assertion is requested from the servers and must be verifiable if -trustAnchor is set.`)
+var zoneName = flag.String("zone", "", `requests the zone section of the given zone and prints it in zonefile format. With
+		-trustAnchor, the signatures of the zone and all its assertions are verified.`)
+var count = flag.Int("count", 1, `number of times the query (or the batch of -f) is sent. If it is larger than one,
+		latency percentiles, the success rate and likely cache hits are reported instead of the response.`)
+var qps = flag.Float64("qps", 0, `rate at which queries are sent in benchmark mode. If it is not set, a query is sent as
+		soon as the previous one was answered.`)
+var compare = flag.Bool("compare", false, `when set, the query is sent to all servers at once and their answers are compared.
+		Sections not returned by every server are highlighted. Exits with status 3 if the answers diverge.`)
+var servers serverFlag
 var queryOptions qoptFlag
 
 var zfParser zonefile.ZoneFileIO
 
 func init() {
 	zfParser = zonefile.IO{}
-	//TODO CFE this list should be generated from internal constants
-	flag.Var(&queryOptions, "qopt", `specifies which query options are added to the query. Several query options are allowed. The sequence in which they are given determines the priority in descending order. Supported values are:
-	1: Minimize end-to-end latency
-	2: Minimize last-hop answer size (bandwidth)
-	3: Minimize information leakage beyond first hop
-	4: No information leakage beyond first hop: cached answers only
-	5: Expired assertions are acceptable
-	6: Enable query token tracing
-	7: Disable verification delegation (client protocol only)
-	8: Suppress proactive caching of future assertions
-	e.g. to specify query options 4 and 2 with higher priority on option 4 write: -qopt=4 -qopt=2
-	`)
+	flag.Var(&servers, "s", `is the IP address of the name server to query, optionally followed by a port.
+		This can be an IPv4 address in dotted-decimal notation or an IPv6 address in colon-delimited notation.
+		Several servers can be given by repeating the flag or as a comma separated list. They are tried in order.`)
+	usage := `specifies which query options are added to the query. Several query options are allowed. The sequence in which they are given determines the priority in descending order. Options are given by name or number. Supported values are:
+`
+	for _, o := range query.Options() {
+		usage += fmt.Sprintf("\t%d, %s: %s\n", o, o.Name(), o.Description())
+	}
+	usage += "\te.g. to specify query options 4 and 2 with higher priority on option 4 write: -qopt cached-only -qopt min-answer-size\n"
+	flag.Var(&queryOptions, "qopt", usage)
 }
 
 //main parses the input flags, creates a query, send the query to the server defined in the input, waits for a response and writes the result to the command line.
 func main() {
 	flag.Parse()
+	if *decodePath != "" {
+		if err := decodeMessages(*decodePath, os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 	if *revLookup != "" {
 		//TODO CFE implement reverse lookup
 		fmt.Println("TODO CFE reverse lookup is not yet supported")
 	} else {
-		switch flag.NArg() {
+		args := flag.Args()
+		if len(servers) == 0 && len(args) > 0 {
+			//without -s, the first argument is the server
+			servers = serverFlag{args[0]}
+			args = args[1:]
+		}
+		switch len(args) {
 		case 0:
 			//all information present
+		case 1:
+			name = &args[0]
 		case 2:
-			serverAddr = &flag.Args()[0]
-			name = &flag.Args()[1]
-		case 3:
-			serverAddr = &flag.Args()[0]
-			name = &flag.Args()[1]
-			typeNo, err := strconv.Atoi(flag.Args()[2])
-			if err != nil {
-				fmt.Println("malformed type")
-				os.Exit(1)
-			}
-			queryType = &typeNo
+			name = &args[0]
+			queryType = &args[1]
 		default:
 			fmt.Println("input parameters malformed")
 		}
 
-		tcpAddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", *serverAddr, *port))
+		if err := query.CheckOptions(queryOptions); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if *strict && *trustAnchor == "" {
+			fmt.Println("-strict requires -trustAnchor")
+			os.Exit(1)
+		}
+
+		if *format != "zonefile" && *format != "json" && *format != "short" {
+			fmt.Printf("unsupported output format: %s\n", *format)
+			os.Exit(1)
+		}
+
+		if *compare && (*trace || *zoneName != "" || *batchPath != "" || *count > 1 || *qps > 0 ||
+			*trustAnchor != "" || *format == "short") {
+			fmt.Println("-compare cannot be combined with -trace, -zone, -f, -count, -qps, -trustAnchor or -fmt short")
+			os.Exit(1)
+		}
+
+		if (*filePath != "" || *queryFilePath != "" || *replayPath != "") && (*trace || *batchPath != "") {
+			fmt.Println("-filePath, -queryFilePath and -replay are not supported with -trace or -f")
+			os.Exit(1)
+		}
+		if *replayPath != "" && (*compare || *zoneName != "" || *count > 1 || *qps > 0) {
+			fmt.Println("-replay cannot be combined with -compare, -zone, -count or -qps")
+			os.Exit(1)
+		}
+
+		serverAddrs, err := resolveServers(servers, *port)
 		if err != nil {
-			fmt.Printf("serverAddr malformed, error=%v\n", err)
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if *retries < 0 {
+			fmt.Println("retries must not be negative")
 			os.Exit(1)
 		}
 
 		var qt []object.Type
-		if *queryType == -1 {
+		if *queryType == "" {
 			qt = anyQuery
 		} else {
-			qt = []object.Type{object.Type(*queryType)}
+			t, err := object.ParseType(*queryType)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			qt = []object.Type{t}
+		}
+
+		var v *verifier
+		if *trustAnchor != "" {
+			//siglib logs every checked signature on debug level
+			h := log.StreamHandler(os.Stderr, log.LogfmtFormat())
+			log.Root().SetHandler(log.LvlFilterHandler(log.LvlWarn, h))
+			if v, err = newVerifier(*trustAnchor, serverAddrs); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+		var tlsConfig *tls.Config
+		if *pinSPKI != "" || *pinCert != "" {
+			if *trace {
+				fmt.Println("-pinSPKI and -pinCert are not supported with -trace")
+				os.Exit(1)
+			}
+			if tlsConfig, err = serverTLSConfig(serverAddrs, v); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if v != nil {
+				v.tlsConfig = tlsConfig
+			}
+		}
+
+		if *count < 1 || *qps < 0 {
+			fmt.Println("count must be positive and qps must not be negative")
+			os.Exit(1)
+		}
+		if *count > 1 || *qps > 0 {
+			var samples []benchSample
+			start := time.Now()
+			if *batchPath != "" {
+				if samples, err = runBatchBench(serverAddrs, tlsConfig, *count, *qps); err != nil {
+					fmt.Printf("could not send queries: %v\n", err)
+					os.Exit(1)
+				}
+			} else {
+				samples = runBench(qt, serverAddrs, tlsConfig, *count, *qps)
+			}
+			if err := newBenchStats(samples, time.Since(start)).print(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		if *zoneName != "" {
+			z, err := fetchZone(*zoneName, serverAddrs, tlsConfig)
+			if err != nil {
+				fmt.Printf("could not retrieve zone: %v\n", err)
+				os.Exit(1)
+			}
+			verified, err := printZone(z, v)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if !verified && *strict {
+				os.Exit(2)
+			}
+			return
+		}
+
+		if *batchPath != "" {
+			queries, err := parseBatchFile(*batchPath)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			total, err := runBatch(queries, serverAddrs[0], *timeout, tlsConfig)
+			if err != nil {
+				fmt.Printf("could not send queries: %v\n", err)
+				os.Exit(1)
+			}
+			if err := printBatch(queries, total); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		if *trace {
+			if err := traceLookup(serverAddrs, qt); err != nil {
+				fmt.Printf(";; trace failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
 		}
 
 		msg := util.NewQueryMessage(*name, *context, *expires, qt, queryOptions, token.New())
 
-		answerMsg, err := util.SendQuery(msg, tcpAddr, time.Second)
-		if err != nil {
+		if *compare {
+			c := compareAnswers(queryAll(msg, serverAddrs, *timeout, tlsConfig))
+			if err := c.print(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if !c.answered() {
+				os.Exit(1)
+			}
+			if !c.Agree {
+				os.Exit(3)
+			}
+			return
+		}
+
+		var answerMsg message.Message
+		var server net.Addr
+		if *replayPath != "" {
+			encoding, replayed, err := loadRawMessage(*replayPath)
+			if err != nil {
+				fmt.Printf("could not load message to replay: %v\n", err)
+				os.Exit(1)
+			}
+			answerMsg, server, err = replayMessage(encoding, replayed, serverAddrs, *timeout, *retries,
+				tlsConfig)
+			if err != nil {
+				log.Info(fmt.Sprintf("could not replay message: %v", err))
+				os.Exit(1)
+			}
+		} else if answerMsg, server, err = queryServers(msg, serverAddrs, *timeout, *retries, *parallel,
+			tlsConfig); err != nil {
 			log.Info(fmt.Sprintf("could not send query: %v", err))
 			os.Exit(1)
 		}
-		for _, section := range answerMsg.Content {
-			// TODO: validate signatures.
+		if len(serverAddrs) > 1 {
+			//Like unverified sections, the server is reported on stderr in the formats which are
+			//meant to be processed further.
+			out := os.Stdout
+			if *format != "zonefile" {
+				out = os.Stderr
+			}
+			fmt.Fprintf(out, ";; answered by %s\n", server)
+		}
+		if *serverKey != "" {
+			if err := verifyMessage(&answerMsg, *serverKey); err != nil {
+				fmt.Fprintf(os.Stderr, ";; %v\n", err)
+				os.Exit(2)
+			}
+		}
+		notificationCode := reportNotification(answerMsg.Content)
+		var results []error
+		if v != nil {
+			results = v.Verify(gocontext.Background(), answerMsg.Content)
+		}
+		if err := printSections(answerMsg.Content, qt, results); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if notificationCode != 0 {
+			os.Exit(notificationCode)
+		}
+		for _, err := range results {
+			if err != nil && *strict {
+				os.Exit(2)
+			}
+		}
+	}
+}
+
+//printSections writes sections to stdout in the format specified by the fmt flag. In the short
+//format, only values of objects with a type contained in types are printed. If results is not nil,
+//it contains the outcome of the signature verification of each section. In the zonefile format,
+//each section is then preceded by a line stating whether it is verified. In the other formats,
+//unverified sections are reported on stderr to not alter the output.
+func printSections(sections []section.Section, types []object.Type, results []error) error {
+	if results != nil && *format != "zonefile" {
+		for i, err := range results {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, ";; UNVERIFIED section %d: %v\n", i, err)
+			}
+		}
+	}
+	switch *format {
+	case "json":
+		if sections == nil {
+			sections = []section.Section{}
+		}
+		encoding, err := json.Marshal(sections)
+		if err != nil {
+			return fmt.Errorf("could not encode response: %v", err)
+		}
+		fmt.Println(string(encoding))
+	case "short":
+		wanted := make(map[object.Type]bool)
+		for _, t := range types {
+			wanted[t] = true
+		}
+		for _, s := range sections {
+			var assertions []*section.Assertion
+			switch s := s.(type) {
+			case *section.Assertion:
+				assertions = []*section.Assertion{s}
+			case *section.Shard:
+				assertions = s.Content
+			case *section.Zone:
+				assertions = s.Content
+			}
+			for _, a := range assertions {
+				for _, o := range a.Content {
+					if wanted[o.Type] {
+						fmt.Println(zonefile.EncodeObjectValue(o))
+					}
+				}
+			}
+		}
+	default:
+		for i, s := range sections {
+			if results != nil {
+				if results[i] != nil {
+					fmt.Printf(";; UNVERIFIED: %v\n", results[i])
+				} else {
+					fmt.Println(";; verified")
+				}
+			}
+			fmt.Println(zfParser.EncodeSection(s))
+		}
+	}
+	return nil
+}
+
+//traceLookup resolves the query iteratively starting at roots and prints each step of the lookup.
+func traceLookup(roots []net.Addr, qt []object.Type) error {
+	resolver := libresolve.New(roots, nil, libresolve.Recursive, nil, 1)
+	resolver.DialTimeout = *timeout
+	nofSteps := 0
+	resolver.Trace = func(step libresolve.TraceStep) {
+		nofSteps++
+		if step.Err != nil {
+			fmt.Printf(";; step %d: no answer from %s after %v: %v\n\n", nofSteps, step.Server,
+				step.RTT.Round(time.Microsecond), step.Err)
+			return
+		}
+		fmt.Printf(";; step %d: received %d section(s) from %s in %v\n", nofSteps,
+			len(step.Answer.Content), step.Server, step.RTT.Round(time.Microsecond))
+		for _, section := range step.Answer.Content {
 			fmt.Println(zfParser.EncodeSection(section))
 		}
+		if step.Redirect != "" && step.Next != nil {
+			fmt.Printf(";; redirected to %s at %s\n", step.Redirect, step.Next)
+		} else if step.Redirect != "" {
+			fmt.Printf(";; redirected to %s, looking up the address of its server\n", step.Redirect)
+		}
+		fmt.Println()
 	}
+	q := &query.Name{
+		Name:       *name,
+		Context:    *context,
+		Expiration: *expires,
+		Types:      qt,
+		Options:    queryOptions,
+	}
+	_, err := resolver.ClientLookup(gocontext.Background(), q)
+	return err
+}
+
+//typeNames returns the symbolic names of types.
+func typeNames(types []object.Type) []string {
+	names := []string{}
+	for _, t := range types {
+		names = append(names, t.Name())
+	}
+	return names
 }
 
 //qoptFlag defines the query options flag. It allows a user to specify multiple query options and their priority (by input sequence)
@@ -111,32 +450,17 @@ type qoptFlag []query.Option
 func (i *qoptFlag) String() string {
 	list := []string{}
 	for _, opt := range *i {
-		list = append(list, strconv.Itoa(int(opt)))
+		list = append(list, opt.Name())
 	}
 	return fmt.Sprintf("[%s]", strings.Join(list, " "))
 }
 
 //Set transforms command line input of a query option to its internal representation
 func (i *qoptFlag) Set(value string) error {
-	switch value {
-	case "1":
-		*i = append(*i, query.QOMinE2ELatency)
-	case "2":
-		*i = append(*i, query.QOMinLastHopAnswerSize)
-	case "3":
-		*i = append(*i, query.QOMinInfoLeakage)
-	case "4":
-		*i = append(*i, query.QOCachedAnswersOnly)
-	case "5":
-		*i = append(*i, query.QOExpiredAssertionsOk)
-	case "6":
-		*i = append(*i, query.QOTokenTracing)
-	case "7":
-		*i = append(*i, query.QONoVerificationDelegation)
-	case "8":
-		*i = append(*i, query.QONoProactiveCaching)
-	default:
-		return fmt.Errorf("There is no query option for value: %s", value)
+	opt, err := query.ParseOption(value)
+	if err != nil {
+		return err
 	}
+	*i = append(*i, opt)
 	return nil
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/probe"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//captureMux serializes the appends to the capture files of queries sent in parallel.
+var captureMux sync.Mutex
+
+//sendQuery sends msg to server and returns the response carrying msg's token. The exact cbor
+//encodings of the query and the response are appended to the files given by the queryFilePath and
+//filePath flags.
+func sendQuery(msg message.Message, server net.Addr, timeout time.Duration,
+	tlsConfig *tls.Config) (message.Message, error) {
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+		return message.Message{}, fmt.Errorf("failed to marshal message: %v", err)
+	}
+	return sendRaw(encoding.Bytes(), msg.Token, server, timeout, tlsConfig)
+}
+
+//sendRaw sends the cbor encoded message to server without modifying it and returns the response
+//carrying tok. Other messages received in the meantime are discarded. The sent and received bytes
+//are captured as in sendQuery.
+func sendRaw(encoding []byte, tok token.Token, server net.Addr, timeout time.Duration,
+	tlsConfig *tls.Config) (message.Message, error) {
+	if err := capture(*queryFilePath, encoding); err != nil {
+		return message.Message{}, err
+	}
+	conn, err := connection.CreateTLSConnection(server, tlsConfig)
+	if err != nil {
+		return message.Message{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(encoding); err != nil {
+		return message.Message{}, fmt.Errorf("failed to send message: %v", err)
+	}
+	for {
+		//the cbor reader does not read ahead, raw contains exactly the bytes of one message.
+		raw := new(bytes.Buffer)
+		var answer message.Message
+		if err := cbor.NewReader(io.TeeReader(conn, raw)).Unmarshal(&answer); err != nil {
+			return message.Message{}, fmt.Errorf("failed to unmarshal response: %v", err)
+		}
+		if !probe.IsResponse(answer, tok) {
+			log.Debug("Discarded message with other token", "token", answer.Token)
+			continue
+		}
+		if err := capture(*filePath, raw.Bytes()); err != nil {
+			return message.Message{}, err
+		}
+		return answer, nil
+	}
+}
+
+//capture appends data to the file at path. It does nothing if path is empty.
+func capture(path string, data []byte) error {
+	if path == "" {
+		return nil
+	}
+	captureMux.Lock()
+	defer captureMux.Unlock()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open capture file: %v", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("could not write capture file: %v", err)
+	}
+	return file.Close()
+}
+
+//loadRawMessage returns the exact encoding and the decoded form of the first cbor encoded message
+//stored in the file at path.
+func loadRawMessage(path string) ([]byte, message.Message, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, message.Message{}, err
+	}
+	raw := new(bytes.Buffer)
+	var msg message.Message
+	if err := cbor.NewReader(io.TeeReader(bytes.NewReader(data), raw)).Unmarshal(&msg); err != nil {
+		return nil, message.Message{}, fmt.Errorf("malformed message in %s: %v", path, err)
+	}
+	return raw.Bytes(), msg, nil
+}
+
+//decodeMessages writes each cbor encoded message stored in the file at path to out as indented
+//json object.
+func decodeMessages(path string, out io.Writer) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	in := bytes.NewReader(data)
+	for in.Len() > 0 {
+		//only the cbor structure is read such that messages which are semantically invalid can
+		//be inspected as well.
+		raw := new(bytes.Buffer)
+		reader := cbor.NewReader(io.TeeReader(in, raw))
+		if _, err := reader.ReadTag(); err != nil {
+			return fmt.Errorf("malformed message at offset %d in %s: %v", len(data)-in.Len()-raw.Len(),
+				path, err)
+		}
+		if _, err := reader.ReadIntMapUntagged(); err != nil {
+			return fmt.Errorf("malformed message at offset %d in %s: %v", len(data)-in.Len()-raw.Len(),
+				path, err)
+		}
+		encoding, err := message.CBORToJSON(raw.Bytes())
+		if err != nil {
+			return err
+		}
+		indented := new(bytes.Buffer)
+		if err := json.Indent(indented, encoding, "", "    "); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, indented.String())
+	}
+	return nil
+}
+
+//replayMessage sends the encoded message msg to servers without modifying it and returns the
+//first response together with the address of the server which answered. The servers are tried in
+//order and the message is resent at most retries times if none of them answered.
+func replayMessage(encoding []byte, msg message.Message, servers []net.Addr, timeout time.Duration,
+	retries int, tlsConfig *tls.Config) (message.Message, net.Addr, error) {
+	for _, s := range msg.Content {
+		if q, ok := s.(*query.Name); ok && q.Expiration < time.Now().Unix() {
+			fmt.Fprintf(os.Stderr, ";; replayed query for %s expired at %s\n", q.Name,
+				time.Unix(q.Expiration, 0).UTC().Format(time.RFC3339))
+		}
+	}
+	err := errors.New("no server specified")
+	for attempt := 0; attempt <= retries; attempt++ {
+		for _, server := range servers {
+			var answer message.Message
+			if answer, err = sendRaw(encoding, msg.Token, server, timeout, tlsConfig); err == nil {
+				return answer, server, nil
+			}
+			log.Debug("Server did not answer", "server", server, "attempt", attempt+1, "error", err)
+		}
+	}
+	return message.Message{}, nil, fmt.Errorf("no answer after %d attempt(s), last error: %v",
+		retries+1, err)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//serverFlag defines the server flag. It allows a user to specify several servers, either by
+//repeating the flag or as a comma separated list. The servers are tried in the given order.
+type serverFlag []string
+
+func (s *serverFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+//Set adds the comma separated servers in value to the list of servers. The comma of a SCION address
+//such as 1-ff00:0:110,[192.0.2.1] does not separate two servers.
+func (s *serverFlag) Set(value string) error {
+	parts := strings.Split(value, ",")
+	for i := 0; i < len(parts); i++ {
+		server := strings.TrimSpace(parts[i])
+		if scionISDAS.MatchString(server) && i+1 < len(parts) &&
+			strings.HasPrefix(strings.TrimSpace(parts[i+1]), "[") {
+			i++
+			server += "," + strings.TrimSpace(parts[i])
+		}
+		if server != "" {
+			*s = append(*s, server)
+		}
+	}
+	return nil
+}
+
+//scionISDAS matches the ISD-AS part of a SCION address such as 1-ff00:0:110,[192.0.2.1]. The AS
+//is either a decimal BGP AS number or consists of three hexadecimal groups separated by colons.
+var scionISDAS = regexp.MustCompile(`^[0-9]+-([0-9]+|[0-9a-fA-F]{1,4}:[0-9a-fA-F]{1,4}:[0-9a-fA-F]{1,4})$`)
+
+//isSCIONAddress returns true if server is a SCION address, i.e. an ISD-AS followed by a comma and
+//a host in brackets or an ISD-AS whose AS contains colons. Host names such as 1-abc or 10-20,
+//optionally followed by a port, are not SCION addresses.
+func isSCIONAddress(server string) bool {
+	if i := strings.Index(server, ","); i >= 0 {
+		return scionISDAS.MatchString(server[:i]) && strings.HasPrefix(server[i+1:], "[")
+	}
+	return scionISDAS.MatchString(server) && strings.Contains(server, ":")
+}
+
+//resolveTCPAddr resolves the address of a server. It is replaced in tests to avoid DNS lookups.
+var resolveTCPAddr = net.ResolveTCPAddr
+
+//resolveServers returns the tcp addresses of servers. A server without a port uses defaultPort.
+func resolveServers(servers []string, defaultPort uint) ([]net.Addr, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("no server specified")
+	}
+	var addrs []net.Addr
+	for _, server := range servers {
+		if isSCIONAddress(server) {
+			//TODO add SCION transport once the connection package supports it
+			return nil, fmt.Errorf("SCION address %s is not supported: only TCP is available", server)
+		}
+		hostPort := server
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			hostPort = net.JoinHostPort(strings.Trim(server, "[]"), fmt.Sprint(defaultPort))
+		}
+		addr, err := resolveTCPAddr("tcp", hostPort)
+		if err != nil {
+			return nil, fmt.Errorf("serverAddr %s malformed, error=%v", server, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+//queryServers sends msg to servers and returns the first response together with the address of
+//the server which answered. In each attempt, the servers are tried one after another or, if
+//parallel is set, all at once. Each query is given timeout to be answered. After an unsuccessful
+//attempt, the query is retried at most retries times. Each sent query gets a fresh token such that
+//late answers to earlier queries are not mistaken for the current one. If tlsConfig is nil, the
+//servers' certificates are not verified.
+func queryServers(msg message.Message, servers []net.Addr, timeout time.Duration, retries int,
+	parallel bool, tlsConfig *tls.Config) (message.Message, net.Addr, error) {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if parallel {
+			var answer message.Message
+			var server net.Addr
+			if answer, server, err = queryParallel(msg, servers, timeout, tlsConfig); err == nil {
+				return answer, server, nil
+			}
+			log.Debug("No server answered", "attempt", attempt+1, "error", err)
+			continue
+		}
+		for _, server := range servers {
+			msg.Token = token.New()
+			var answer message.Message
+			if answer, err = sendQuery(msg, server, timeout, tlsConfig); err == nil {
+				return answer, server, nil
+			}
+			log.Debug("Server did not answer", "server", server, "attempt", attempt+1, "error", err)
+		}
+	}
+	return message.Message{}, nil, fmt.Errorf("no answer after %d attempt(s), last error: %v",
+		retries+1, err)
+}
+
+//queryParallel sends msg to all servers at once and returns the first received response.
+func queryParallel(msg message.Message, servers []net.Addr, timeout time.Duration,
+	tlsConfig *tls.Config) (message.Message, net.Addr, error) {
+	type result struct {
+		answer message.Message
+		server net.Addr
+		err    error
+	}
+	results := make(chan result, len(servers))
+	for _, server := range servers {
+		msg.Token = token.New()
+		go func(msg message.Message, server net.Addr) {
+			answer, err := sendQuery(msg, server, timeout, tlsConfig)
+			results <- result{answer: answer, server: server, err: err}
+		}(msg, server)
+	}
+	var err error
+	for range servers {
+		r := <-results
+		if r.err == nil {
+			return r.answer, r.server, nil
+		}
+		err = r.err
+	}
+	return message.Message{}, nil, err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestServerFlag(t *testing.T) {
+	var tests = []struct {
+		value   string
+		servers serverFlag
+	}{
+		{"192.0.2.1", serverFlag{"192.0.2.1"}},
+		{"192.0.2.1, [2001:db8::1]:5022", serverFlag{"192.0.2.1", "[2001:db8::1]:5022"}},
+		{"1-ff00:0:110,[192.0.2.1],192.0.2.2", serverFlag{"1-ff00:0:110,[192.0.2.1]", "192.0.2.2"}},
+		{"10-20,1-abc,[192.0.2.1]", serverFlag{"10-20", "1-abc", "[192.0.2.1]"}},
+	}
+	for i, test := range tests {
+		var servers serverFlag
+		servers.Set(test.value)
+		if !reflect.DeepEqual(servers, test.servers) {
+			t.Errorf("%d: wrong servers. expected=%v actual=%v", i, test.servers, servers)
+		}
+	}
+}
+
+func TestResolveServers(t *testing.T) {
+	defer func(resolve func(string, string) (*net.TCPAddr, error)) {
+		resolveTCPAddr = resolve
+	}(resolveTCPAddr)
+	var resolved []string
+	resolveTCPAddr = func(network, address string) (*net.TCPAddr, error) {
+		resolved = append(resolved, address)
+		return &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 55553}, nil
+	}
+	var tests = []struct {
+		server string
+		scion  bool
+	}{
+		{"1-ff00:0:110,[192.0.2.1]", true},
+		{"1-ff00:0:110", true},
+		{"10-20,[192.0.2.1]:5022", true},
+		{"1-abc", false},
+		{"10-20", false},
+		{"10-20:5022", false},
+		{"ns1-ethz.ch", false},
+	}
+	for i, test := range tests {
+		resolved = nil
+		_, err := resolveServers([]string{test.server}, 55553)
+		if test.scion && (err == nil || len(resolved) != 0) {
+			t.Errorf("%d: SCION address %s was not rejected", i, test.server)
+		}
+		if !test.scion && (err != nil || len(resolved) != 1) {
+			t.Errorf("%d: host %s was not resolved over TCP. resolved=%v error=%v", i,
+				test.server, resolved, err)
+		}
+	}
+}
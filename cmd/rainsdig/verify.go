@@ -0,0 +1,90 @@
+package main
+
+import (
+	gocontext "context"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+	"golang.org/x/crypto/ed25519"
+)
+
+//verifier checks the signatures on received sections along the delegation chain from the trust
+//anchor down to the sections' zones. The delegation assertions are requested from servers, which
+//are expected to resolve them recursively.
+type verifier struct {
+	*libresolve.Verifier
+	servers   []net.Addr
+	tlsConfig *tls.Config
+}
+
+//newVerifier returns a verifier which trusts the delegation assertion stored at anchorPath. This
+//is the same self signed root delegation assertion rainsd loads from RootZonePublicKeyPath.
+func newVerifier(anchorPath string, servers []net.Addr) (*verifier, error) {
+	v := &verifier{servers: servers}
+	v.Verifier = libresolve.NewVerifier(v.fetchDelegation)
+	if err := v.LoadTrustAnchor(anchorPath); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+//fetchDelegation requests the delegation assertions of zone in ctxName from the servers.
+func (v *verifier) fetchDelegation(ctx gocontext.Context, zone, ctxName string) (
+	[]section.Section, error) {
+	msg := util.NewQueryMessage(zone, ctxName, time.Now().Add(*timeout).Unix(),
+		[]object.Type{object.OTDelegation}, nil, token.New())
+	answer, _, err := queryServers(msg, v.servers, *timeout, *retries, *parallel, v.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return answer.Content, nil
+}
+
+//containedAssertions returns all assertions of sections including the ones contained in shards
+//and zones. The context and zone of the latter are set.
+func containedAssertions(sections []section.Section) []*section.Assertion {
+	var assertions []*section.Assertion
+	for _, s := range sections {
+		switch s := s.(type) {
+		case *section.Assertion:
+			assertions = append(assertions, s)
+		case *section.Shard:
+			s.AddCtxAndZoneToContent()
+			assertions = append(assertions, s.Content...)
+		case *section.Zone:
+			s.AddCtxAndZoneToContent()
+			assertions = append(assertions, s.Content...)
+		}
+	}
+	return assertions
+}
+
+//verifyMessage returns an error if msg is not signed with the ed25519 public key encoded as hex
+//string in serverKey, e.g. the infrastructure key of the server which answered.
+func verifyMessage(msg *message.Message, serverKey string) error {
+	key, err := hex.DecodeString(serverKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("server key must be a hex encoded ed25519 public key")
+	}
+	//The key phase of the server key is not known, such that the key is tried for each signature.
+	pkeys := make(map[keys.PublicKeyID][]keys.PublicKey)
+	for _, sig := range msg.Signatures {
+		pkeys[sig.PublicKeyID] = []keys.PublicKey{{PublicKeyID: sig.PublicKeyID,
+			ValidSince: sig.ValidSince, ValidUntil: sig.ValidUntil, Key: ed25519.PublicKey(key)}}
+	}
+	if !siglib.CheckMessageSignatures(msg, pkeys) {
+		return fmt.Errorf("answer is not signed with the server key %s", serverKey)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	gocontext "context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//fetchZone requests the zone section of zone from servers. As there is no zone transfer, the zone
+//is obtained as the answer to a query without types for a name in zone. Such a query cannot be
+//answered with assertions, so servers respond with the zone and shards covering the name.
+func fetchZone(zone string, servers []net.Addr, tlsConfig *tls.Config) (*section.Zone, error) {
+	if !strings.HasSuffix(zone, ".") || zone == "." {
+		return nil, fmt.Errorf("zone must be a fully qualified name other than the root: %s", zone)
+	}
+	msg := util.NewQueryMessage("@."+zone, *context, *expires, []object.Type{}, queryOptions,
+		token.New())
+	answer, _, err := queryServers(msg, servers, *timeout, *retries, *parallel, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range answer.Content {
+		if z, ok := s.(*section.Zone); ok && z.SubjectZone == zone && z.Context == *context {
+			return z, nil
+		}
+	}
+	return nil, fmt.Errorf("server did not return the zone section of %s", zone)
+}
+
+//printZone writes z in zonefile format to stdout. If v is not nil, the signatures of z and of all
+//contained assertions are verified and the outcome is added as a comment. It returns false if z
+//could not be verified and an error if the encoding of z cannot be parsed again.
+func printZone(z *section.Zone, v *verifier) (bool, error) {
+	encoding := zfParser.Encode([]section.Section{z})
+	if _, err := zfParser.Decode([]byte(encoding)); err != nil {
+		return false, fmt.Errorf("zone %s cannot be encoded as valid zonefile: %v", z.SubjectZone, err)
+	}
+	verified := true
+	if v != nil {
+		if err := v.VerifySection(gocontext.Background(), z); err != nil {
+			fmt.Printf(";; UNVERIFIED: %v\n", err)
+			verified = false
+		} else {
+			fmt.Printf(";; verified zone %s with %d assertion(s)\n", z.SubjectZone, len(z.Content))
+		}
+	}
+	fmt.Println(encoding)
+	return verified, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+var server = flag.String("s", "127.0.0.1:5022", "address (host:port) of the rainsd server against which the queries are replayed.")
+var capturePath = flag.String("capture", "", "path to the capture file recorded by rainsd (see CapturePath in rainsd(8)).")
+var speed = flag.Float64("speed", 1, `factor by which the pacing of the recorded queries is accelerated. 1 replays them at
+the original pacing, 0 sends them as fast as possible.`)
+var workers = flag.Int("conns", 16, "number of concurrent workers, each with its own connection.")
+var timeout = flag.Duration("timeout", 5*time.Second, "how long to wait for a response before a query is counted as failed.")
+var validity = flag.Duration("validity", 10*time.Second, "validity of each replayed query after it has been sent.")
+var maxDiffs = flag.Int("diffs", 20, "maximal number of differing answers printed in the text report. -1 prints all.")
+var format = flag.String("fmt", "text", `output format of the report. Supported values are:
+text: a summary followed by the differing answers in zonefile format.
+json: the summary and all differing answers as a json object.`)
+
+func main() {
+	flag.Parse()
+	if *format != "text" && *format != "json" {
+		exitf("unsupported output format: %s", *format)
+	}
+	if *capturePath == "" {
+		exitf("-capture must be set")
+	}
+	if *speed < 0 {
+		exitf("-speed must not be negative")
+	}
+	if *workers < 1 {
+		exitf("at least one connection is required")
+	}
+	addr, err := net.ResolveTCPAddr("tcp", *server)
+	if err != nil {
+		exitf("invalid server address: %v", err)
+	}
+	queries, err := loadCapture(*capturePath)
+	if err != nil {
+		exitf("could not load capture: %v", err)
+	}
+	if len(queries) == 0 {
+		exitf("the capture does not contain any query")
+	}
+	r := &replay{addr: addr, timeout: *timeout, validity: *validity}
+	elapsed := r.run(queries, *workers, *speed)
+	rep := newReport(queries, elapsed)
+	if err := rep.print(); err != nil {
+		exitf("%v", err)
+	}
+	if rep.Answered == 0 {
+		os.Exit(1)
+	}
+}
+
+//exitf prints the formatted error message to stderr and exits with status 1.
+func exitf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
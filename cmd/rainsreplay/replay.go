@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/capture"
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/probe"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//recordedQuery is a query of the capture together with the recorded answer and the outcome of
+//its replay.
+type recordedQuery struct {
+	msg message.Message
+	//offset is the time between the first recorded query and this one.
+	offset time.Duration
+	peer   string
+	//recorded is the answer the server sent when the query was captured. It is nil if the capture
+	//contains none.
+	recorded *message.Message
+	answer   message.Message
+	rtt      time.Duration
+	//lag is the time by which the query was sent later than its scheduled time because all
+	//workers were busy.
+	lag    time.Duration
+	failed bool
+}
+
+//loadCapture returns the queries of the capture at path in the order in which they were received
+//together with the answers sent to them.
+func loadCapture(path string) ([]*recordedQuery, error) {
+	records, err := capture.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var queries []*recordedQuery
+	pending := make(map[token.Token]*recordedQuery)
+	var start int64
+	for i, r := range records {
+		msg, err := r.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %v", i+1, err)
+		}
+		if r.Type == capture.Query {
+			if len(queries) == 0 {
+				start = r.Time
+			}
+			q := &recordedQuery{msg: *msg, offset: time.Duration(r.Time - start), peer: r.Peer}
+			queries = append(queries, q)
+			pending[msg.Token] = q
+			continue
+		}
+		if q, ok := pending[probe.AnswerToken(*msg)]; ok {
+			q.recorded = msg
+			delete(pending, probe.AnswerToken(*msg))
+		}
+	}
+	return queries, nil
+}
+
+//replay sends recorded queries to a server.
+type replay struct {
+	addr     net.Addr
+	timeout  time.Duration
+	validity time.Duration
+}
+
+//run replays queries with the given number of workers. Each query is sent after its offset
+//divided by speed or as soon as possible if speed is zero. It returns the elapsed time.
+func (r *replay) run(queries []*recordedQuery, workers int, speed float64) time.Duration {
+	jobs := make(chan *recordedQuery)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work(jobs)
+		}()
+	}
+	start := time.Now()
+	for _, q := range queries {
+		scheduled := start
+		if speed > 0 {
+			scheduled = start.Add(time.Duration(float64(q.offset) / speed))
+			time.Sleep(time.Until(scheduled))
+		}
+		jobs <- q
+		q.lag = time.Since(scheduled)
+		if speed == 0 {
+			q.lag = 0
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return time.Since(start)
+}
+
+//work replays the received queries over a connection which is reopened after a failure.
+func (r *replay) work(jobs <-chan *recordedQuery) {
+	var conn net.Conn
+	var reader cbor.Reader
+	var writer cbor.Writer
+	closeConn := func() {
+		if conn != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+	defer closeConn()
+	for q := range jobs {
+		if conn == nil {
+			c, err := connection.CreateConnection(r.addr)
+			if err != nil {
+				q.failed = true
+				continue
+			}
+			conn, reader, writer = c, cbor.NewReader(c), cbor.NewWriter(c)
+		}
+		start := time.Now()
+		answer, err := probe.Exchange(conn, reader, writer, r.prepare(q.msg), r.timeout)
+		q.rtt = time.Since(start)
+		if err != nil {
+			//The connection's state is unknown after a failure, later responses must not be
+			//attributed to the next query.
+			q.failed = true
+			closeConn()
+			continue
+		}
+		q.answer = answer
+	}
+}
+
+//prepare returns a copy of the recorded msg with a new token and query expirations relative to
+//now, as the recorded ones have most likely expired. Capabilities and signatures are dropped.
+func (r *replay) prepare(msg message.Message) message.Message {
+	replayed := message.Message{Token: token.New()}
+	expiration := time.Now().Add(r.validity).Unix()
+	for _, s := range msg.Content {
+		if q, ok := s.(*query.Name); ok {
+			copied := *q
+			copied.Expiration = expiration
+			s = &copied
+		}
+		replayed.Content = append(replayed.Content, s)
+	}
+	return replayed
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/probe"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+var zfParser = zonefile.IO{}
+
+//difference describes a replayed query whose answer differs from the recorded one.
+type difference struct {
+	Peer    string   `json:"peer"`
+	Query   []string `json:"query"`
+	Offset  float64  `json:"offsetMs"`
+	Missing []string `json:"missing"`
+	Added   []string `json:"added"`
+}
+
+//report contains the results of a replay.
+type report struct {
+	Queries     int           `json:"queries"`
+	Answered    int           `json:"answered"`
+	Failed      int           `json:"failed"`
+	Unrecorded  int           `json:"unrecorded"`
+	Identical   int           `json:"identical"`
+	Different   int           `json:"different"`
+	DurationMs  float64       `json:"durationMs"`
+	Throughput  float64       `json:"throughputQps"`
+	Latency     probe.Latency `json:"latency"`
+	MaxLagMs    float64       `json:"maxLagMs"`
+	Differences []difference  `json:"differences"`
+}
+
+//newReport computes the report of the replayed queries which took elapsed in total.
+func newReport(queries []*recordedQuery, elapsed time.Duration) report {
+	r := report{Queries: len(queries), DurationMs: probe.ToMs(elapsed), Differences: []difference{}}
+	var rtts []time.Duration
+	for _, q := range queries {
+		if lag := probe.ToMs(q.lag); lag > r.MaxLagMs {
+			r.MaxLagMs = lag
+		}
+		if q.failed {
+			r.Failed++
+			continue
+		}
+		rtts = append(rtts, q.rtt)
+		if q.recorded == nil {
+			r.Unrecorded++
+			continue
+		}
+		missing, added := probe.DiffLines(probe.AnswerLines(*q.recorded), probe.AnswerLines(q.answer))
+		if len(missing) == 0 && len(added) == 0 {
+			r.Identical++
+			continue
+		}
+		r.Different++
+		r.Differences = append(r.Differences, difference{Peer: q.peer, Query: sectionLines(q.msg),
+			Offset: probe.ToMs(q.offset), Missing: missing, Added: added})
+	}
+	r.Answered = len(rtts)
+	if elapsed > 0 {
+		r.Throughput = float64(r.Answered) / elapsed.Seconds()
+	}
+	r.Latency = probe.NewLatency(rtts)
+	return r
+}
+
+//sectionLines returns the sections of msg in zonefile format.
+func sectionLines(msg message.Message) []string {
+	var lines []string
+	for _, s := range msg.Content {
+		lines = append(lines, zfParser.EncodeSection(s))
+	}
+	return lines
+}
+
+//print writes the report to stdout in the format specified by the fmt flag.
+func (r report) print() error {
+	if *format == "json" {
+		encoding, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("could not encode report: %v", err)
+		}
+		fmt.Println(string(encoding))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "queries:\t%d replayed, %d answered, %d failed\n", r.Queries, r.Answered,
+		r.Failed)
+	fmt.Fprintf(w, "answers:\t%d identical, %d different, %d without recorded answer\n",
+		r.Identical, r.Different, r.Unrecorded)
+	fmt.Fprintf(w, "duration:\t%.3fs\n", r.DurationMs/1000)
+	fmt.Fprintf(w, "throughput:\t%.1f answered queries/s\n", r.Throughput)
+	fmt.Fprintf(w, "latency:\t%s (min/mean/p50/p90/p99/max)\n", r.Latency)
+	fmt.Fprintf(w, "max lag:\t%.3fms\n", r.MaxLagMs)
+	w.Flush()
+	for i, d := range r.Differences {
+		if *maxDiffs >= 0 && i >= *maxDiffs {
+			fmt.Printf("\n;; %d more differing answers omitted\n", len(r.Differences)-i)
+			break
+		}
+		fmt.Printf("\n;; query from %s at +%.3fms:\n", d.Peer, d.Offset)
+		fmt.Println(strings.Join(d.Query, "\n"))
+		for _, l := range d.Missing {
+			fmt.Println("- " + l)
+		}
+		for _, l := range d.Added {
+			fmt.Println("+ " + l)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/britram/borat"
+	log "github.com/inconshreveable/log15"
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/datastructures/bitarray"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//Fixed values of the vectors such that generating them twice yields the same files.
+const (
+	zone       = "example.com."
+	context    = "."
+	validSince = 1577836800 //2020-01-01
+	validUntil = 2208988800 //2040-01-01
+	expiration = 2208988800
+)
+
+var out = flag.String("out", "internal/pkg/message/testdata/vectors", `Directory to which the vectors
+are written. Existing vectors in it are removed.`)
+
+//vector is a test vector of the wire format. It is written to a file named after the vector.
+type vector struct {
+	Name string `json:"name"`
+	//CBOR is the canonical encoding of the message in hexadecimal.
+	CBOR string `json:"cbor"`
+	//Message is the expected decoded message in the JSON representation of message.MarshalJSON.
+	Message json.RawMessage `json:"message"`
+	//SigningBytes are the bytes signed by the only signature of the message or of its only section
+	//in hexadecimal. They are empty if nothing is signed.
+	SigningBytes string `json:"signingBytes,omitempty"`
+	//PublicKey is the Ed25519 public key verifying the signature in hexadecimal.
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+func init() {
+	h := log.CallerFileHandler(log.StreamHandler(os.Stderr, log.LogfmtFormat()))
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+}
+
+//main generates the vectors and writes them to the output directory.
+func main() {
+	flag.Parse()
+	vectors, err := generate()
+	if err != nil {
+		log.Error("Was not able to generate the vectors", "error", err)
+		os.Exit(1)
+	}
+	if err := write(vectors, *out); err != nil {
+		log.Error("Was not able to write the vectors", "dir", *out, "error", err)
+		os.Exit(1)
+	}
+	log.Info("Wrote test vectors", "count", len(vectors), "dir", *out)
+}
+
+//generate returns a vector for each object type in a signed assertion, each combination of bloom
+//filter and hash algorithm in a signed pshard, each notification type and for a signed shard,
+//zone and message as well as queries.
+func generate() ([]vector, error) {
+	zoneKey := privateKey("zone")
+	var vectors []vector
+	add := func(v vector, err error) error {
+		if err != nil {
+			return err
+		}
+		vectors = append(vectors, v)
+		return nil
+	}
+	for _, obj := range objects() {
+		a := &section.Assertion{SubjectName: "www", SubjectZone: zone, Context: context,
+			Content: []object.Object{obj}}
+		if err := add(sectionVector("assertion-"+obj.Type.Name(), a, zoneKey)); err != nil {
+			return nil, err
+		}
+	}
+	shard := &section.Shard{SubjectZone: zone, Context: context, RangeFrom: "a", RangeTo: "z",
+		Content: contained()}
+	if err := add(sectionVector("shard", shard, zoneKey)); err != nil {
+		return nil, err
+	}
+	z := &section.Zone{SubjectZone: zone, Context: context, Content: contained()}
+	if err := add(sectionVector("zone", z, zoneKey)); err != nil {
+		return nil, err
+	}
+	for _, algo := range []section.BloomFilterAlgo{section.BloomKM12, section.BloomKM16,
+		section.BloomKM20, section.BloomKM24} {
+		for _, hash := range []algorithmTypes.Hash{algorithmTypes.Shake256, algorithmTypes.Fnv64,
+			algorithmTypes.Fnv128} {
+			filter := section.BloomFilter{Algorithm: algo, Hash: hash,
+				Filter: make(bitarray.BitArray, 32)}
+			for _, a := range contained() {
+				if err := filter.Add(a.SubjectName, zone, context, a.Content[0].Type); err != nil {
+					return nil, err
+				}
+			}
+			p := &section.Pshard{SubjectZone: zone, Context: context, RangeFrom: "a", RangeTo: "z",
+				BloomFilter: filter}
+			name := fmt.Sprintf("pshard-km%d-%s", algo.NumberOfHashes(),
+				strings.ToLower(hash.String()))
+			if err := add(sectionVector(name, p, zoneKey)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	queries := map[string]*query.Name{
+		"query": {Context: context, Name: "www." + zone, Expiration: expiration,
+			Types: []object.Type{object.OTIP4Addr}},
+		"query-options": {Context: context, Name: "www." + zone, Expiration: expiration,
+			Types: section.AllObjectType(), Options: section.AllQueryOptions(), KeyPhase: 1,
+			CurrentTime: validSince},
+	}
+	for _, name := range []string{"query", "query-options"} {
+		msg := &message.Message{Token: vectorToken(name), Content: []section.Section{queries[name]}}
+		if err := add(newVector(name, msg)); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range []section.NotificationType{section.NTHeartbeat, section.NTStaleAnswer,
+		section.NTTokenTrace, section.NTCapHashNotKnown, section.NTBadMessage, section.NTAccessDenied,
+		section.NTRcvInconsistentMsg,
+		section.NTNoAssertionsExist, section.NTMsgTooLarge, section.NTRateLimited,
+		section.NTUnspecServerErr, section.NTServerNotCapable, section.NTNoAssertionAvail} {
+		name := fmt.Sprintf("notification-%d", t)
+		n := &section.Notification{Token: vectorToken(name), Type: t, Data: "vector " + name}
+		msg := &message.Message{Token: vectorToken(name), Content: []section.Section{n}}
+		if err := add(newVector(name, msg)); err != nil {
+			return nil, err
+		}
+	}
+	if err := add(messageVector("message-signed", privateKey("infrastructure"))); err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
+
+//objects returns an object of each type.
+func objects() []object.Object {
+	key := func(label string, phase int) keys.PublicKey {
+		return keys.PublicKey{
+			PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519,
+				KeySpace: keys.RainsKeySpace, KeyPhase: phase},
+			ValidSince: validSince,
+			ValidUntil: validUntil,
+			Key:        privateKey(label).Public(),
+		}
+	}
+	return []object.Object{
+		{Type: object.OTName, Value: object.Name{Name: "web." + zone,
+			Types: []object.Type{object.OTIP4Addr, object.OTIP6Addr}}},
+		{Type: object.OTIP6Addr, Value: "2001:db8::1"},
+		{Type: object.OTIP4Addr, Value: "192.0.2.1"},
+		{Type: object.OTRedirection, Value: "ns." + zone},
+		{Type: object.OTDelegation, Value: key("delegation", 0)},
+		{Type: object.OTNameset, Value: object.NamesetExpr("[a-z]+")},
+		{Type: object.OTCertInfo, Value: object.Certificate{Type: object.PTTLS,
+			Usage: object.CUEndEntity, HashAlgo: algorithmTypes.Sha256,
+			Data: sha256Sum("certificate")}},
+		{Type: object.OTServiceInfo, Value: object.ServiceInfo{Name: "srv." + zone, Port: 55553,
+			Priority: 1}},
+		{Type: object.OTRegistrar, Value: "Registrar information"},
+		{Type: object.OTRegistrant, Value: "Registrant information"},
+		{Type: object.OTInfraKey, Value: key("infrastructure", 0)},
+		{Type: object.OTExtraKey, Value: key("extra", 2)},
+		{Type: object.OTNextKey, Value: key("next", 1)},
+	}
+}
+
+//contained returns the unsigned assertions of a shard or zone in canonical order.
+func contained() []*section.Assertion {
+	return []*section.Assertion{
+		{SubjectName: "mail", Content: []object.Object{{Type: object.OTIP6Addr,
+			Value: "2001:db8::25"}}},
+		{SubjectName: "www", Content: []object.Object{{Type: object.OTIP4Addr,
+			Value: "192.0.2.80"}}},
+	}
+}
+
+//sectionVector signs s with key and returns the vector of a message containing s.
+func sectionVector(name string, s section.WithSig, key ed25519.PrivateKey) (vector, error) {
+	encoding := new(bytes.Buffer)
+	if err := s.MarshalCBOR(borat.NewCBORWriter(encoding)); err != nil {
+		return vector{}, err
+	}
+	sig, signingBytes, err := sign(encoding.Bytes(), key)
+	if err != nil {
+		return vector{}, err
+	}
+	s.AddSig(sig)
+	v, err := newVector(name, &message.Message{Token: vectorToken(name),
+		Content: []section.Section{s}})
+	v.SigningBytes = hex.EncodeToString(signingBytes)
+	v.PublicKey = hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	return v, err
+}
+
+//messageVector returns the vector of a message with capabilities signed by key.
+func messageVector(name string, key ed25519.PrivateKey) (vector, error) {
+	msg := &message.Message{
+		Token:        vectorToken(name),
+		Capabilities: []message.Capability{message.TLSOverTCP},
+		Content: []section.Section{&section.Notification{Token: vectorToken(name),
+			Type: section.NTHeartbeat}},
+	}
+	encoding := new(bytes.Buffer)
+	if err := msg.MarshalCBOR(borat.NewCBORWriter(encoding)); err != nil {
+		return vector{}, err
+	}
+	sig, signingBytes, err := sign(encoding.Bytes(), key)
+	if err != nil {
+		return vector{}, err
+	}
+	msg.Signatures = []signature.Sig{sig}
+	v, err := newVector(name, msg)
+	v.SigningBytes = hex.EncodeToString(signingBytes)
+	v.PublicKey = hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	return v, err
+}
+
+//sign returns a signature over the unsigned encoding of a section or message created with key and
+//the signed bytes.
+func sign(encoding []byte, key ed25519.PrivateKey) (signature.Sig, []byte, error) {
+	sig := signature.Sig{
+		PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519,
+			KeySpace: keys.RainsKeySpace},
+		ValidSince: validSince,
+		ValidUntil: validUntil,
+	}
+	signingBytes, err := sig.SigningBytes(encoding)
+	if err != nil {
+		return sig, nil, err
+	}
+	return sig, signingBytes, sig.SignData(key, encoding)
+}
+
+//newVector returns the vector of msg without signing bytes.
+func newVector(name string, msg *message.Message) (vector, error) {
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(msg); err != nil {
+		return vector{}, fmt.Errorf("%s: %v", name, err)
+	}
+	canonical, err := cbor.Canonical(encoding.Bytes())
+	if err != nil {
+		return vector{}, fmt.Errorf("%s: %v", name, err)
+	}
+	decoded, err := msg.MarshalJSON()
+	if err != nil {
+		return vector{}, fmt.Errorf("%s: %v", name, err)
+	}
+	return vector{Name: name, CBOR: hex.EncodeToString(canonical), Message: decoded}, nil
+}
+
+//write replaces the vectors in dir with vectors.
+func write(vectors []vector, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	old, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range old {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	for _, v := range vectors {
+		encoding, err := json.MarshalIndent(v, "", "    ")
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, v.Name+".json")
+		if err := ioutil.WriteFile(path, append(encoding, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//privateKey returns the Ed25519 key derived from label.
+func privateKey(label string) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(sha256Sum("rainsvectors " + label))
+}
+
+//vectorToken returns the token of the vector name.
+func vectorToken(name string) token.Token {
+	var t token.Token
+	copy(t[:], sha256Sum("token "+name))
+	return t
+}
+
+func sha256Sum(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+	return sum[:]
+}
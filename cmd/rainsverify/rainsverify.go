@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/keyManager"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/publisher"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+//Exit codes of rainsverify. They allow a publishing pipeline to distinguish an incorrect zone from
+//a failure of the verification itself.
+const (
+	exitOK      = 0
+	exitInvalid = 1
+	exitFailure = 2
+)
+
+var keyPaths = flag.String("key", "", `Comma separated list of paths to pem encoded public keys as generated by
+keyManager (name_pub.pem) against which the signatures are verified.`)
+var delegPath = flag.String("deleg", "", `Path to a zonefile containing the delegation assertion of the zone, e.g.
+the name_deleg.txt file generated by keyManager or the parent's zonefile. The delegated keys are
+used to verify the signatures.`)
+var input = flag.String("in", "zonefile", `Format of the file to verify. Supported values are:
+zonefile: a signed zonefile as generated by the publisher
+cbor: a cbor encoded message (or a sequence thereof) containing the signed sections`)
+var format = flag.String("fmt", "text", `Output format of the failures. Supported values are:
+text: one failure per line
+json: a json array of objects containing the section and the problem`)
+var at = flag.String("at", "", `Time at which the signatures must be valid as RFC3339 timestamp. The
+default is now.`)
+
+func init() {
+	h := log.CallerFileHandler(log.StreamHandler(os.Stderr, log.LogfmtFormat()))
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlError, h))
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: rainsverify [options] <signed zone file>\n\nOptions:\n")
+		flag.PrintDefaults()
+	}
+}
+
+//main verifies the signed zone and prints all failures. It exits with exitInvalid if there are
+//any.
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(exitFailure)
+	}
+	if *keyPaths == "" && *delegPath == "" {
+		exitf("Either -key or -deleg must be specified")
+	}
+	if *format != "text" && *format != "json" {
+		exitf("unsupported output format: %s", *format)
+	}
+	now := time.Now()
+	if *at != "" {
+		var err error
+		if now, err = time.Parse(time.RFC3339, *at); err != nil {
+			exitf("malformed time: %v", err)
+		}
+	}
+	sections, err := loadSections(flag.Arg(0))
+	if err != nil {
+		exitf("Was not able to load %s: %v", flag.Arg(0), err)
+	}
+	pkeys, err := loadKeys(sections)
+	if err != nil {
+		exitf("%v", err)
+	}
+	failures := publisher.VerifyZone(sections, pkeys, now.Unix())
+	if *format == "json" {
+		if failures == nil {
+			failures = []publisher.VerifyFailure{}
+		}
+		encoding, err := json.Marshal(failures)
+		if err != nil {
+			exitf("could not encode failures: %v", err)
+		}
+		fmt.Println(string(encoding))
+	} else {
+		for _, f := range failures {
+			fmt.Println(f)
+		}
+		if len(failures) == 0 {
+			fmt.Printf("%d sections verified successfully\n", len(sections))
+		}
+	}
+	if len(failures) != 0 {
+		os.Exit(exitInvalid)
+	}
+	os.Exit(exitOK)
+}
+
+//exitf prints the formatted error message to stderr and exits with exitFailure.
+func exitf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(exitFailure)
+}
+
+//loadSections returns the sections stored at path in the format specified by the in flag.
+func loadSections(path string) ([]section.WithSigForward, error) {
+	switch *input {
+	case "zonefile":
+		return zonefile.IO{}.LoadZonefile(path)
+	case "cbor":
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var sections []section.WithSigForward
+		buffer := bytes.NewReader(data)
+		reader := cbor.NewReader(buffer)
+		for buffer.Len() > 0 {
+			var msg message.Message
+			if err := reader.Unmarshal(&msg); err != nil {
+				return nil, err
+			}
+			for _, s := range msg.Content {
+				sec, ok := s.(section.WithSigForward)
+				if !ok {
+					return nil, fmt.Errorf("unexpected section type %T", s)
+				}
+				sections = append(sections, sec)
+			}
+		}
+		return sections, nil
+	default:
+		return nil, fmt.Errorf("unsupported input format: %s", *input)
+	}
+}
+
+//loadKeys returns the public keys given in the key flag and the keys delegated to the zone of
+//sections in the zonefile given in the deleg flag. The keys are valid at all times.
+func loadKeys(sections []section.WithSigForward) (map[keys.PublicKeyID][]keys.PublicKey, error) {
+	pkeys := make(map[keys.PublicKeyID][]keys.PublicKey)
+	add := func(key keys.PublicKey) {
+		key.ValidSince, key.ValidUntil = 0, math.MaxInt64
+		pkeys[key.PublicKeyID] = append(pkeys[key.PublicKeyID], key)
+	}
+	if *keyPaths != "" {
+		for _, path := range strings.Split(*keyPaths, ",") {
+			key, err := keyManager.LoadPublicKey(path)
+			if err != nil {
+				return nil, fmt.Errorf("Was not able to load public key %s: %v", path, err)
+			}
+			add(key)
+		}
+	}
+	if *delegPath != "" {
+		zone := ""
+		if len(sections) > 0 {
+			zone = sections[0].GetSubjectZone()
+		}
+		delegated, err := publisher.LoadDelegatedKeys(*delegPath, zone)
+		if err != nil {
+			return nil, err
+		}
+		for _, list := range delegated {
+			for _, key := range list {
+				add(key)
+			}
+		}
+	}
+	return pkeys, nil
+}
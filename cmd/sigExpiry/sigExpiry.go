@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/publisher"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+//Exit codes of sigExpiry. They allow an alerting system to distinguish expiring signatures from a
+//failure of the check itself.
+const (
+	exitOK       = 0
+	exitExpiring = 1
+	exitFailure  = 2
+)
+
+var anyQuery = []object.Type{object.OTName, object.OTIP4Addr, object.OTIP6Addr,
+	object.OTDelegation, object.OTServiceInfo, object.OTRedirection}
+
+var zonefilePath = flag.String("zonefile", "", "Path to a zonefile whose signatures are checked.")
+var servers = flag.String("servers", "", `Comma separated list of server addresses (host:port) which
+are queried for the names given in -names.`)
+var names = flag.String("names", "", "Comma separated list of fully qualified names to query.")
+var context = flag.String("context", ".", "Context in which the names are queried.")
+var window = flag.Duration("window", 7*24*time.Hour, `Signatures expiring within this time window
+are reported.`)
+var timeout = flag.Duration("timeout", 5*time.Second, "Time to wait for a server's response.")
+
+func init() {
+	h := log.CallerFileHandler(log.StreamHandler(os.Stderr, log.LogfmtFormat()))
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+}
+
+//main collects the sections from the zonefile and the servers, prints all signatures expiring
+//within the window and exits with exitExpiring if there are any.
+func main() {
+	flag.Parse()
+	if *zonefilePath == "" && *servers == "" {
+		fmt.Fprintln(os.Stderr, "Either -zonefile or -servers must be specified")
+		os.Exit(exitFailure)
+	}
+	var sections []section.Section
+	failed := false
+	if *zonefilePath != "" {
+		zfSections, err := zonefile.IO{}.LoadZonefile(*zonefilePath)
+		if err != nil {
+			log.Error("Was not able to load zonefile", "path", *zonefilePath, "error", err)
+			failed = true
+		}
+		for _, s := range zfSections {
+			sections = append(sections, s)
+		}
+	}
+	if *servers != "" {
+		if *names == "" {
+			fmt.Fprintln(os.Stderr, "-names must be specified when querying servers")
+			os.Exit(exitFailure)
+		}
+		for _, server := range strings.Split(*servers, ",") {
+			for _, name := range strings.Split(*names, ",") {
+				answer, err := query(server, name)
+				if err != nil {
+					log.Error("Was not able to query server", "server", server, "name", name,
+						"error", err)
+					failed = true
+					continue
+				}
+				sections = append(sections, answer...)
+			}
+		}
+	}
+	now := time.Now()
+	expiring := publisher.ExpiringSignatures(sections, now.Add(*window).Unix())
+	for _, sig := range expiring {
+		validUntil := time.Unix(sig.ValidUntil, 0)
+		status := fmt.Sprintf("expires in %s", validUntil.Sub(now).Round(time.Second))
+		if validUntil.Before(now) {
+			status = "expired"
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", sig.Section, sig.KeyID, validUntil.UTC().Format(time.RFC3339),
+			status)
+	}
+	switch {
+	case failed:
+		os.Exit(exitFailure)
+	case len(expiring) != 0:
+		os.Exit(exitExpiring)
+	}
+	os.Exit(exitOK)
+}
+
+//query sends a query for name to server and returns the sections of the answer.
+func query(server, name string) ([]section.Section, error) {
+	addr, err := net.ResolveTCPAddr("tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	msg := util.NewQueryMessage(name, *context, time.Now().Add(*timeout).Unix(), anyQuery, nil,
+		token.New())
+	answer, err := util.SendQuery(msg, addr, *timeout)
+	if err != nil {
+		return nil, err
+	}
+	return answer.Content, nil
+}
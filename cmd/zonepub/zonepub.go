@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -24,6 +25,9 @@ var zonefilePath = flag.String("zonefilePath", "", "Path to the zonefile")
 var authServers addressesFlag
 var privateKeyPath = flag.String("privateKeyPath", "", `Path to a file storing the private keys. 
 Each line contains a key phase and a private key encoded in hexadecimal separated by a space.`)
+var delegationPath = flag.String("delegationPath", "", `Path to a zonefile containing the zone's
+delegation, e.g. the name_deleg.txt file generated by keyManager or the parent's zonefile. The
+signatures are verified against the delegated keys.`)
 var includeShards boolFlag
 var doSharding boolFlag
 var nofAssertionsPerShard = flag.Int("nofAssertionsPerShard", -1, `Defines the number of assertions
@@ -67,6 +71,40 @@ var addSigMetaDataToPshards boolFlag
 var outputPath = flag.String("outputPath", "", `If set, a zonefile with the signed sections is 
 generated and stored at the provided path`)
 var doPublish boolFlag
+var doDiscovery boolFlag
+var rootServers addressesFlag
+var forwarders addressesFlag
+var pushRetries = flag.Int("pushRetries", -1, `this option only has an effect when DoPublish is
+true. Defines how many times the push to a server is retried if it failed.`)
+var pushBackoff = flag.Int64("pushBackoff", -1, `this option only has an effect when DoPublish is
+true. Defines the time in seconds to wait before the first retry. The waiting time is doubled after
+each retry.`)
+var pushAckTimeout = flag.Int64("pushAckTimeout", -1, `this option only has an effect when DoPublish
+is true. Defines the time in seconds a server is given after a message has been sent to acknowledge
+it or to respond with an error notification.`)
+var dryRun boolFlag
+var maxMessageSize = flag.Int("maxMessageSize", -1, `this option only has an effect when DoPublish is
+true. If the signed sections are larger than maxMessageSize bytes, they are sent in several
+messages.`)
+var issueDelegation = flag.String("issueDelegation", "", `If set, the child zone's delegation request
+stored at the provided path is validated and the resulting delegation and redirection assertions are
+added to the zonefile. Nothing is published.`)
+var diffPush boolFlag
+var diffStatePath = flag.String("diffStatePath", "", `Path where the sections of the last successful push
+are stored. It is used to compute the delta of a differential push.`)
+var summaryPath = flag.String("summaryPath", "", `If set, a json summary of the run is stored at the
+provided path. If the path is "-", the summary is written to stdout.`)
+var auditLogPath = flag.String("auditLogPath", "", `If set, a record for each key used for signing is
+appended to the hash-chained audit log at the provided path.`)
+var dnssecBridging boolFlag
+var dnsOrigin = flag.String("dnsOrigin", "", `this option only has an effect when dnssecBridging is
+true. Apex of the DNS zone, e.g. ethz.ch.`)
+var dnsKeysets = flag.String("dnsKeysets", "", `this option only has an effect when dnssecBridging is
+true. Comma separated list of files containing the DNSKEY records of child zones. DS records with a
+matching Ed25519 DNSKEY are mapped to delegations.`)
+var verifyDNSSEC boolFlag
+var trustAnchors = flag.String("trustAnchors", "", `Comma separated list of DS records in presentation
+format against which the DNSSEC chain is verified when verifyDNSSEC is true.`)
 
 func init() {
 	h := log.CallerFileHandler(log.StdoutHandler)
@@ -113,6 +151,22 @@ func init() {
 	flag.Var(&doSigning, "doSigning", "If set, signs all assertions and shards")
 	flag.Var(&doPublish, "doPublish", `If set, sends the signed sections to all authoritative rainsd
 	servers`)
+	flag.Var(&doDiscovery, "doDiscovery", `If set, the zone's authoritative servers are looked up
+	through its redirection, service information and ip assertions. The discovered servers are used
+	in addition to authServers.`)
+	flag.Var(&rootServers, "rootServers", `Root server addresses at which the recursive lookup of the
+	authoritative servers starts. Only used when doDiscovery is set and no forwarders are given.`)
+	flag.Var(&forwarders, "forwarders", `Addresses of resolvers to which the lookup of the 
+	authoritative servers is forwarded. Only used when doDiscovery is set.`)
+	flag.Var(&dryRun, "dryRun", `If set, the zone is processed according to the configuration and a
+	report is printed instead of contacting any server. The signatures are verified against the
+	keys delegated to the zone in the zonefile at delegationPath.`)
+	flag.Var(&diffPush, "diffPush", `this option only has an effect when DoPublish is true. If set, only
+	the sections which changed since the last successful push are sent.`)
+	flag.Var(&dnssecBridging, "dnssecBridging", `If set, the zonefile is a DNS zone in master file
+	format. Its records are mapped to rains assertions before they are processed.`)
+	flag.Var(&verifyDNSSEC, "verifyDNSSEC", `this option only has an effect when dnssecBridging is true.
+	If set, the DNSSEC signatures of the zone are verified against trustAnchors before publication.`)
 	flag.Parse()
 }
 
@@ -124,7 +178,7 @@ func main() {
 	}
 	config, err := publisher.LoadConfig(flag.Args()[0])
 	if err != nil {
-		return
+		os.Exit(1)
 	}
 	//Overwrite config with provided cmd line flags
 	if *zonefilePath != "" {
@@ -136,6 +190,9 @@ func main() {
 	if *privateKeyPath != "" {
 		config.PrivateKeyPath = *privateKeyPath
 	}
+	if *delegationPath != "" {
+		config.DelegationPath = *delegationPath
+	}
 	if includeShards.set {
 		config.ShardingConf.IncludeShards = includeShards.value
 	}
@@ -220,10 +277,70 @@ func main() {
 	if doPublish.set {
 		config.DoPublish = doPublish.value
 	}
+	if doDiscovery.set {
+		config.DiscoveryConf.DoDiscovery = doDiscovery.value
+	}
+	if rootServers.set {
+		config.DiscoveryConf.RootServers = rootServers.value
+	}
+	if forwarders.set {
+		config.DiscoveryConf.Forwarders = forwarders.value
+	}
+	if *pushRetries != -1 {
+		config.PushRetries = *pushRetries
+	}
+	if *pushBackoff != -1 {
+		config.PushBackoff = time.Duration(*pushBackoff) * time.Second
+	}
+	if *pushAckTimeout != -1 {
+		config.PushAckTimeout = time.Duration(*pushAckTimeout) * time.Second
+	}
+	if dryRun.set {
+		config.DryRun = dryRun.value
+	}
+	if *maxMessageSize != -1 {
+		config.MaxMessageSize = *maxMessageSize
+	}
+	if diffPush.set {
+		config.DiffPush = diffPush.value
+	}
+	if *diffStatePath != "" {
+		config.DiffStatePath = *diffStatePath
+	}
+	if *summaryPath != "" {
+		config.SummaryPath = *summaryPath
+	}
+	if *auditLogPath != "" {
+		config.AuditLogPath = *auditLogPath
+	}
+	if dnssecBridging.set {
+		config.DNSSECConf.DoBridging = dnssecBridging.value
+	}
+	if *dnsOrigin != "" {
+		config.DNSSECConf.Origin = *dnsOrigin
+	}
+	if *dnsKeysets != "" {
+		config.DNSSECConf.KeysetPaths = strings.Split(*dnsKeysets, ",")
+	}
+	if verifyDNSSEC.set {
+		config.DNSSECConf.VerifyChain = verifyDNSSEC.value
+	}
+	if *trustAnchors != "" {
+		config.DNSSECConf.TrustAnchors = strings.Split(*trustAnchors, ",")
+	}
+
+	if *issueDelegation != "" {
+		if err := publisher.IssueDelegation(config.ZonefilePath, *issueDelegation); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
 
 	//Call rainspub to do the work according to the updated config
 	server := publisher.New(config)
-	server.Publish()
+	if err := server.Publish(); err != nil {
+		os.Exit(1)
+	}
 }
 
 type addressesFlag struct {
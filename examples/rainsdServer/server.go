@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"time"
 
 	"github.com/britram/borat"
@@ -63,7 +64,7 @@ func main() {
 	server.Write(deliverable)
 
 	time.Sleep(time.Hour)
-	server.Shutdown()
+	server.Shutdown(context.Background())
 	log.Info("Server shut down")
 }
 
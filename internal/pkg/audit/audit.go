@@ -0,0 +1,172 @@
+//Package audit implements a tamper-evident log of key usage. Each record contains the hash of its
+//predecessor such that modifying or removing a record invalidates all subsequent records. Removing
+//records from the end of the log leaves the chain intact and can only be detected by comparing the
+//log with a chain head recorded outside of it, see Head and VerifyHead.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//Record describes one usage of a key.
+type Record struct {
+	//Time is the unix timestamp in seconds at which the key was used.
+	Time int64
+	//Operation describes how the key was used, e.g. sign, decrypt or export.
+	Operation string
+	//KeyID identifies the used key.
+	KeyID string
+	//Zone and Context of the signed sections. They are empty if the key was not used for signing.
+	Zone    string
+	Context string
+	//Sections is the number of signed sections.
+	Sections int
+	//Caller identifies the program and user that used the key.
+	Caller string
+	//PrevHash is the hash of the previous record or the empty string for the first record.
+	PrevHash string
+	//Hash is the sha256 hash over all other fields of this record.
+	Hash string
+}
+
+//hash returns the hex encoded sha256 hash of all fields of r except Hash.
+func (r Record) hash() string {
+	r.Hash = ""
+	encoding, _ := json.Marshal(r)
+	sum := sha256.Sum256(encoding)
+	return hex.EncodeToString(sum[:])
+}
+
+//Log appends records to a hash-chained log file. It is safe for concurrent use.
+type Log struct {
+	path     string
+	mutex    sync.Mutex
+	lastHash string
+	loaded   bool
+}
+
+//New returns a log which stores its records at path.
+func New(path string) *Log {
+	return &Log{path: path}
+}
+
+//Append adds r to the log. The time, caller and hash fields are filled in if they are empty.
+func (l *Log) Append(r Record) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.loaded {
+		hashes, err := verify(l.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		l.lastHash = last(hashes)
+		l.loaded = true
+	}
+	if r.Time == 0 {
+		r.Time = time.Now().Unix()
+	}
+	if r.Caller == "" {
+		r.Caller = Caller()
+	}
+	r.PrevHash = l.lastHash
+	r.Hash = r.hash()
+	encoding, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(encoding, '\n')); err != nil {
+		return err
+	}
+	l.lastHash = r.Hash
+	return nil
+}
+
+//Verify checks that the hash chain of the log at path is intact. It returns an error describing the
+//first invalid record otherwise.
+func Verify(path string) error {
+	_, err := verify(path)
+	return err
+}
+
+//Head checks that the hash chain of the log at path is intact and returns the hash of its last
+//record. Storing the head outside of the log, e.g. in a monitoring system, allows VerifyHead to
+//detect records removed from the end of the log later on.
+func Head(path string) (string, error) {
+	hashes, err := verify(path)
+	return last(hashes), err
+}
+
+//VerifyHead checks that the hash chain of the log at path is intact and still contains the record
+//with hash head as returned by Head earlier.
+func VerifyHead(path, head string) error {
+	hashes, err := verify(path)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if hash == head {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: record %s is missing, the log has been truncated", path, head)
+}
+
+//verify checks the hash chain of the log at path and returns the hashes of all records in order.
+func verify(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	var hashes []string
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: malformed record: %v", path, line, err)
+		}
+		if r.PrevHash != last(hashes) {
+			return nil, fmt.Errorf("%s:%d: chain is broken, previous hash does not match", path, line)
+		}
+		if r.hash() != r.Hash {
+			return nil, fmt.Errorf("%s:%d: record has been modified", path, line)
+		}
+		hashes = append(hashes, r.Hash)
+	}
+	return hashes, scanner.Err()
+}
+
+//last returns the last hash or the empty string if there is none.
+func last(hashes []string) string {
+	if len(hashes) == 0 {
+		return ""
+	}
+	return hashes[len(hashes)-1]
+}
+
+//Caller returns a description of the running program and the user executing it.
+func Caller() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return fmt.Sprintf("%s (pid %d, user %s)", filepath.Base(os.Args[0]), os.Getpid(), name)
+}
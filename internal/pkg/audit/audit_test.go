@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestAppendAndVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := path.Join(dir, "audit.log")
+	l := New(logPath)
+	for i := 0; i < 3; i++ {
+		if err := l.Append(Record{Operation: "sign", KeyID: "AT=ed25519 KS=rains KP=1",
+			Zone: "ch.", Context: ".", Sections: i}); err != nil {
+			t.Fatalf("Was not able to append record: %v", err)
+		}
+	}
+	if err := Verify(logPath); err != nil {
+		t.Fatalf("Intact log did not verify: %v", err)
+	}
+	//A new log instance must continue the existing chain.
+	if err := New(logPath).Append(Record{Operation: "decrypt"}); err != nil {
+		t.Fatalf("Was not able to append record: %v", err)
+	}
+	if err := Verify(logPath); err != nil {
+		t.Fatalf("Continued log did not verify: %v", err)
+	}
+	head, err := Head(logPath)
+	if err != nil || head == "" {
+		t.Fatalf("Was not able to obtain head. head=%s error=%v", head, err)
+	}
+	data, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var tests = []struct {
+		name  string
+		lines []string
+	}{
+		{"modified", append([]string{strings.Replace(lines[0], `"Sections":0`, `"Sections":5`, 1)},
+			lines[1:]...)},
+		{"removed", append([]string{lines[0]}, lines[2:]...)},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(logPath, []byte(strings.Join(test.lines, "\n")), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(logPath); err == nil {
+			t.Errorf("%s: tampered log verified", test.name)
+		}
+	}
+
+	//Truncation keeps the chain intact and is only detected with the recorded head.
+	truncated := strings.Join(lines[:2], "\n")
+	if err := ioutil.WriteFile(logPath, []byte(truncated), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(logPath); err != nil {
+		t.Errorf("truncated chain is not intact: %v", err)
+	}
+	if err := VerifyHead(logPath, head); err == nil {
+		t.Errorf("truncated log verified against the recorded head")
+	}
+	if err := ioutil.WriteFile(logPath, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyHead(logPath, head); err != nil {
+		t.Errorf("intact log did not verify against its head: %v", err)
+	}
+}
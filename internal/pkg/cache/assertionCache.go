@@ -7,6 +7,7 @@ import (
 	"time"
 
 	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeCounter"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeHashMap"
 	"github.com/netsec-ethz/rains/internal/pkg/lruCache"
@@ -41,6 +42,9 @@ type AssertionImpl struct {
 	zoneMap                *safeHashMap.Map
 	entriesPerAssertionMap map[string]int //a.Hash() -> int
 	mux                    sync.Mutex     //protects entriesPerAssertionMap from simultaneous access
+	//Retention is the time for which RemoveExpiredValues keeps assertions after they expired, e.g.
+	//such that they can be served stale.
+	Retention time.Duration
 }
 
 func NewAssertion(maxSize int) *AssertionImpl {
@@ -107,7 +111,19 @@ func (c *AssertionImpl) Add(a *section.Assertion, expiration int64, isInternal b
 		}
 		value.mux.Unlock()
 	}
-	//Remove elements according to lru strategy
+	c.evict()
+	return !isFull
+}
+
+//Resize changes the maximum number of entries to maxSize. If the cache is full afterwards, the
+//least recently used assertions are removed.
+func (c *AssertionImpl) Resize(maxSize int) {
+	c.counter.SetMaxCount(maxSize)
+	c.evict()
+}
+
+//evict removes the least recently used assertions until the cache is no longer full.
+func (c *AssertionImpl) evict() {
 	for c.counter.IsFull() {
 		key, value := c.cache.GetLeastRecentlyUsed()
 		if value == nil {
@@ -132,7 +148,6 @@ func (c *AssertionImpl) Add(a *section.Assertion, expiration int64, isInternal b
 		c.counter.Sub(len(v.assertions))
 		v.mux.Unlock()
 	}
-	return !isFull
 }
 
 // zoneHierarchy returns a slice of domain names upto the root to try and find a match in the cache.
@@ -186,9 +201,10 @@ func (c *AssertionImpl) Get(fqdn, context string, objType object.Type, strict bo
 	return assertions, len(assertions) > 0
 }
 
-//RemoveExpiredValues goes through the cache and removes all expired assertions from the
-//assertionCache and the consistency cache.
+//RemoveExpiredValues goes through the cache and removes all assertions which expired more than
+//Retention ago from the assertionCache and the consistency cache.
 func (c *AssertionImpl) RemoveExpiredValues() {
+	now := clock.Now().Add(-c.Retention).Unix()
 	for _, v := range c.cache.GetAll() {
 		value := v.(*assertionCacheValue)
 		deleteCount := 0
@@ -198,7 +214,7 @@ func (c *AssertionImpl) RemoveExpiredValues() {
 			continue
 		}
 		for key, va := range value.assertions {
-			if va.expiration < time.Now().Unix() {
+			if va.expiration < now {
 				c.mux.Lock()
 				c.entriesPerAssertionMap[va.assertion.Hash()]--
 				c.mux.Unlock()
@@ -243,15 +259,91 @@ func (c *AssertionImpl) RemoveZone(zone string) {
 	}
 }
 
-//Checkpoint returns all cached assertions
+//RemoveOutdated deletes all assertions of zone and context whose subject name is in range and whose
+//hash is not contained in keep. It is used to remove assertions which are no longer listed in an
+//authoritative shard or zone.
+func (c *AssertionImpl) RemoveOutdated(zone, context string, inRange func(subjectName string) bool,
+	keep map[string]bool) {
+	set, ok := c.zoneMap.Get(zone)
+	if !ok {
+		return
+	}
+	for _, key := range set.(*safeHashMap.Map).GetAllKeys() {
+		v, ok := c.cache.Get(key)
+		if !ok {
+			continue
+		}
+		value := v.(*assertionCacheValue)
+		deleteCount := 0
+		value.mux.Lock()
+		if value.deleted {
+			value.mux.Unlock()
+			continue
+		}
+		for hash, va := range value.assertions {
+			if va.assertion.Context == context && inRange(va.assertion.SubjectName) && !keep[hash] {
+				c.mux.Lock()
+				c.entriesPerAssertionMap[hash]--
+				c.mux.Unlock()
+				delete(value.assertions, hash)
+				deleteCount++
+			}
+		}
+		if len(value.assertions) == 0 {
+			value.deleted = true
+			c.cache.Remove(value.cacheKey)
+			set.(*safeHashMap.Map).Remove(value.cacheKey)
+		}
+		value.mux.Unlock()
+		c.counter.Sub(deleteCount)
+	}
+}
+
+//GetInRange returns all assertions of zone and context whose subject name is in range. An assertion
+//with objects of several types is returned only once.
+func (c *AssertionImpl) GetInRange(zone, context string,
+	inRange func(subjectName string) bool) []*section.Assertion {
+	set, ok := c.zoneMap.Get(zone)
+	if !ok {
+		return nil
+	}
+	var assertions []*section.Assertion
+	added := make(map[string]bool)
+	for _, key := range set.(*safeHashMap.Map).GetAllKeys() {
+		v, ok := c.cache.Get(key)
+		if !ok {
+			continue
+		}
+		value := v.(*assertionCacheValue)
+		value.mux.RLock()
+		if !value.deleted {
+			for hash, va := range value.assertions {
+				if va.assertion.Context == context && inRange(va.assertion.SubjectName) &&
+					!added[hash] {
+					added[hash] = true
+					assertions = append(assertions, va.assertion)
+				}
+			}
+		}
+		value.mux.RUnlock()
+	}
+	return assertions
+}
+
+//Checkpoint returns all cached assertions. An assertion with objects of several types is indexed
+//once per type but returned only once.
 func (c *AssertionImpl) Checkpoint() (assertions []section.Section) {
 	entries := c.cache.GetAll()
+	added := make(map[string]bool)
 	for _, e := range entries {
 		values := e.(*assertionCacheValue)
 		values.mux.RLock()
 		if !values.deleted {
-			for _, v := range values.assertions {
-				assertions = append(assertions, v.assertion)
+			for hash, v := range values.assertions {
+				if !added[hash] {
+					added[hash] = true
+					assertions = append(assertions, v.assertion)
+				}
 			}
 		}
 		values.mux.RUnlock()
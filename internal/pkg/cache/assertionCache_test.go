@@ -6,10 +6,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeCounter"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeHashMap"
 	"github.com/netsec-ethz/rains/internal/pkg/lruCache"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
 )
 
 func TestAssertionCache(t *testing.T) {
@@ -153,3 +155,163 @@ func TestAssertionCheckpoint(t *testing.T) {
 		}
 	}
 }
+
+func TestAssertionRemoveOutdated(t *testing.T) {
+	c := NewAssertion(10)
+	delegationsCH := getExampleDelgations("ch")
+	delegationsORG := getExampleDelgations("org")
+	c.Add(delegationsCH[0], time.Now().Add(time.Hour).Unix(), true)
+	c.Add(delegationsCH[3], time.Now().Add(time.Hour).Unix(), true)
+	c.Add(delegationsORG[0], time.Now().Add(time.Hour).Unix(), true)
+	//Names outside the range are not removed
+	c.RemoveOutdated(".", ".", func(name string) bool { return name < "c" }, nil)
+	if c.Len() != 3 {
+		t.Errorf("Assertions outside the range were removed. expected=3 actual=%d", c.Len())
+	}
+	//Kept assertions are not removed
+	keep := map[string]bool{delegationsCH[0].Hash(): true}
+	c.RemoveOutdated(".", ".", func(name string) bool { return name == "ch" }, keep)
+	if c.Len() != 2 {
+		t.Errorf("Outdated assertion was not removed. expected=2 actual=%d", c.Len())
+	}
+	if a, ok := c.Get("ch.", ".", object.OTDelegation, true); !ok || len(a) != 1 ||
+		a[0] != delegationsCH[0] {
+		t.Errorf("Kept assertion is not cached anymore. actual=%v", a)
+	}
+	//All assertions of a zone in a different context are not removed
+	c.RemoveOutdated(".", "test-cch", func(string) bool { return true }, nil)
+	if c.Len() != 2 {
+		t.Errorf("Assertions of another context were removed. expected=2 actual=%d", c.Len())
+	}
+	c.RemoveOutdated(".", ".", func(string) bool { return true }, nil)
+	if c.Len() != 0 {
+		t.Errorf("Outdated assertions were not removed. expected=0 actual=%d", c.Len())
+	}
+}
+
+func TestAssertionGetInRange(t *testing.T) {
+	c := NewAssertion(10)
+	delegationsCH := getExampleDelgations("ch")
+	delegationsORG := getExampleDelgations("org")
+	c.Add(delegationsCH[0], time.Now().Add(time.Hour).Unix(), true)
+	c.Add(delegationsCH[3], time.Now().Add(time.Hour).Unix(), true)
+	c.Add(delegationsORG[0], time.Now().Add(time.Hour).Unix(), true)
+	var tests = []struct {
+		zone    string
+		context string
+		inRange func(string) bool
+		want    int
+	}{
+		{".", ".", func(string) bool { return true }, 3},
+		{".", ".", func(name string) bool { return name == "ch" }, 2},
+		{".", ".", func(name string) bool { return name > "d" }, 1},
+		{".", "test-cch", func(string) bool { return true }, 0},
+		{"ch.", ".", func(string) bool { return true }, 0},
+	}
+	for i, test := range tests {
+		if a := c.GetInRange(test.zone, test.context, test.inRange); len(a) != test.want {
+			t.Errorf("%d: unexpected assertions in range. expected=%d actual=%v", i, test.want, a)
+		}
+	}
+}
+
+func TestAssertionMultipleTypes(t *testing.T) {
+	c := NewAssertion(10)
+	//The delegation is not the first object of the assertion.
+	a := &section.Assertion{
+		SubjectName: "ch",
+		SubjectZone: ".",
+		Context:     ".",
+		Content: []object.Object{
+			object.Object{Type: object.OTRedirection, Value: "ns.ch."},
+			object.Object{Type: object.OTDelegation, Value: object.PublicKey()},
+		},
+	}
+	c.Add(a, time.Now().Add(time.Hour).Unix(), true)
+	for _, typ := range []object.Type{object.OTRedirection, object.OTDelegation} {
+		if as, ok := c.Get("ch.", ".", typ, true); !ok || len(as) != 1 || as[0] != a {
+			t.Errorf("Assertion is not indexed by type %v. actual=%v", typ, as)
+		}
+	}
+	if as, ok := c.Get("ch.", ".", object.OTIP4Addr, true); ok {
+		t.Errorf("Assertion is indexed by a type it does not contain. actual=%v", as)
+	}
+	if as := c.Checkpoint(); len(as) != 1 || as[0] != a {
+		t.Errorf("Assertion is not checkpointed exactly once. actual=%v", as)
+	}
+	c.RemoveOutdated(".", ".", func(string) bool { return true }, nil)
+	if c.Len() != 0 {
+		t.Errorf("Not all types of the assertion were removed. expected=0 actual=%d", c.Len())
+	}
+	for _, typ := range []object.Type{object.OTRedirection, object.OTDelegation} {
+		if as, ok := c.Get("ch.", ".", typ, true); ok {
+			t.Errorf("Removed assertion is still indexed by type %v. actual=%v", typ, as)
+		}
+	}
+}
+
+func TestAssertionRetention(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1500000000, 0))
+	defer clock.Set(fake)()
+	c := NewAssertion(10)
+	c.Retention = time.Hour
+	a := &section.Assertion{
+		SubjectName: "www",
+		SubjectZone: "ethz.ch.",
+		Context:     ".",
+		Content:     []object.Object{object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}},
+	}
+	c.Add(a, fake.Now().Add(time.Minute).Unix(), false)
+	var tests = []struct {
+		advance time.Duration
+		len     int
+	}{
+		{0, 1},
+		{time.Minute + time.Second, 1},
+		{time.Hour - time.Second, 1},
+		{time.Second, 0},
+	}
+	for i, test := range tests {
+		fake.Advance(test.advance)
+		c.RemoveExpiredValues()
+		if c.Len() != test.len {
+			t.Errorf("%d: wrong number of assertions. expected=%d actual=%d", i, test.len, c.Len())
+		}
+	}
+}
+
+func TestAssertionResize(t *testing.T) {
+	c := NewAssertion(10)
+	for _, name := range []string{"a", "b", "c"} {
+		c.Add(&section.Assertion{
+			SubjectName: name,
+			SubjectZone: "ch.",
+			Context:     ".",
+			Content:     []object.Object{object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}},
+		}, time.Now().Add(time.Hour).Unix(), false)
+	}
+	var tests = []struct {
+		size int
+		len  int
+	}{
+		{10, 3},
+		{3, 2},
+		{1, 0},
+		{5, 0},
+	}
+	for i, test := range tests {
+		c.Resize(test.size)
+		if c.Len() != test.len {
+			t.Errorf("%d: wrong number of assertions. expected=%d actual=%d", i, test.len, c.Len())
+		}
+		if _, ok := c.Get("c.ch.", ".", object.OTIP4Addr, false); ok != (test.len > 0) {
+			t.Errorf("%d: most recently added assertion is not kept. expected=%v actual=%v", i,
+				test.len > 0, ok)
+		}
+	}
+	if ok := c.Add(&section.Assertion{SubjectName: "d", SubjectZone: "ch.", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}}},
+		time.Now().Add(time.Hour).Unix(), false); !ok || c.Len() != 1 {
+		t.Errorf("Assertion was not added to the resized cache. actual=%d", c.Len())
+	}
+}
@@ -32,6 +32,12 @@ func NewCapability(maxSize int) *CapabilityImpl {
 	return cache
 }
 
+//Resize changes the maximum number of capability lists to maxSize. If the cache is full
+//afterwards, the least recently used list is removed whenever a new one is added.
+func (c *CapabilityImpl) Resize(maxSize int) {
+	c.counter.SetMaxCount(maxSize)
+}
+
 func (c *CapabilityImpl) Add(capabilities []message.Capability) {
 	//FIXME CFE take a SHA-256 hash of the CBOR byte stream derived from normalizing such an array by sorting it in lexicographically increasing order,
 	//then serializing it and add it to the cache
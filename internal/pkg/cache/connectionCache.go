@@ -35,6 +35,12 @@ func NewConnection(maxSize int) *ConnectionImpl {
 	}
 }
 
+//Resize changes the maximum number of connections to maxSize. If the cache is full afterwards,
+//the connections to the least recently used destination are closed whenever a new one is added.
+func (c *ConnectionImpl) Resize(maxSize int) {
+	c.counter.SetMaxCount(maxSize)
+}
+
 func networkAddr(addr net.Addr) string {
 	return fmt.Sprintf("%s %s", addr.Network(), addr.String())
 }
@@ -160,6 +166,23 @@ func (c *ConnectionImpl) CloseAndRemoveConnections(addr net.Addr) {
 	}
 }
 
+//Addrs returns the remote addresses of all cached connections. An address is returned once per
+//connection to it.
+func (c *ConnectionImpl) Addrs() []net.Addr {
+	var addrs []net.Addr
+	for _, e := range c.cache.GetAll() {
+		v := e.(*connCacheValue)
+		v.mux.RLock()
+		if !v.deleted {
+			for _, conn := range v.connections {
+				addrs = append(addrs, conn.RemoteAddr())
+			}
+		}
+		v.mux.RUnlock()
+	}
+	return addrs
+}
+
 func (c *ConnectionImpl) Len() int {
 	return c.counter.Value()
 }
@@ -58,6 +58,11 @@ func TestConnectionCache(t *testing.T) {
 		if !ok {
 			t.Errorf("%d: Wrong connection removed", i)
 		}
+		addrs := c.Addrs()
+		if len(addrs) != 2 || !containsAddr(addrs, connInfo2) || !containsAddr(addrs, connInfo3) {
+			t.Errorf("%d: Wrong addresses returned. expected=[%v %v] actual=%v", i, connInfo2,
+				connInfo3, addrs)
+		}
 		//test that connection can still be used.
 		outConn2[0].Write([]byte("testMsg\n"))
 		buffer := make([]byte, 7)
@@ -93,6 +98,15 @@ func TestConnectionCache(t *testing.T) {
 	}
 }
 
+func containsAddr(addrs []net.Addr, addr net.Addr) bool {
+	for _, a := range addrs {
+		if a.String() == addr.String() {
+			return true
+		}
+	}
+	return false
+}
+
 func mockServer(tcpAddr string, t *testing.T) {
 	ln, _ := net.Listen("tcp", tcpAddr)
 	for {
@@ -31,8 +31,14 @@ type Connection interface {
 	CloseAndRemoveConnection(conn net.Conn)
 	//CloseAndRemoveConnections closes and removes all cached connections to addr
 	CloseAndRemoveConnections(addr net.Addr)
+	//Addrs returns the remote addresses of all cached connections. An address is returned once
+	//per connection to it.
+	Addrs() []net.Addr
 	//Len returns the number of connections currently in the cache.
 	Len() int
+	//Resize changes the maximum number of connections. If it shrinks, connections are closed as
+	//new ones are added.
+	Resize(maxSize int)
 }
 
 //Capability stores a mapping from a hash of a capability list to a pointer of the list.
@@ -46,6 +52,8 @@ type Capability interface {
 	Get(hash []byte) ([]message.Capability, bool)
 	//Len returns the number of elements currently in the cache.
 	Len() int
+	//Resize changes the maximum number of capability lists.
+	Resize(maxSize int)
 }
 
 //ZonePublicKey is used to store public keys of zones and a pointer to delegation assertions
@@ -69,6 +77,9 @@ type ZonePublicKey interface {
 	Checkpoint() []section.Section
 	//Len returns the number of public keys currently in the cache.
 	Len() int
+	//Resize changes the maximum number of public keys. The least recently used ones are removed
+	//until they fit.
+	Resize(maxSize int)
 }
 
 type PendingKey interface {
@@ -85,12 +96,16 @@ type PendingKey interface {
 	RemoveExpiredValues()
 	//Len returns the number of sections in the cache
 	Len() int
+	//Resize changes the maximum number of pending sections. Pending sections are not removed if
+	//it shrinks.
+	Resize(maxSize int)
 }
 
 type PendingQuery interface {
-	//Add checks if this server has already forwarded a msg containing the same queries as ss. If
-	//this is the case, ss is added to the cache and false is returned. If not, ss is added together
-	//with t and expiration to the cache and true is returned.
+	//Add checks if this server has already forwarded a msg which is still pending and asks for
+	//every context, name and type of the queries in ss. If this is the case, ss is added to the
+	//cache such that it is answered together with the forwarded msg and false is returned. If not,
+	//ss is added together with t and expiration to the cache and true is returned.
 	Add(ss util.MsgSectionSender, t token.Token, expiration int64) bool
 	//GetAndRemove returns all util.MsgSectionSenders which correspond to token and delete them from the
 	//cache.
@@ -99,6 +114,9 @@ type PendingQuery interface {
 	RemoveExpiredValues()
 	//Len returns the number of sections in the cache
 	Len() int
+	//Resize changes the maximum number of pending queries. Pending queries are not removed if
+	//it shrinks.
+	Resize(maxSize int)
 }
 
 //Assertion is used to store and efficiently lookup assertions
@@ -117,10 +135,18 @@ type Assertion interface {
 	//RemoveZone deletes all assertions in the assertionCache and consistencyCache of the given
 	//zone.
 	RemoveZone(zone string)
+	//RemoveOutdated deletes all assertions of zone and context whose subject name is in range and
+	//whose hash is not contained in keep.
+	RemoveOutdated(zone, context string, inRange func(subjectName string) bool, keep map[string]bool)
+	//GetInRange returns all assertions of zone and context whose subject name is in range.
+	GetInRange(zone, context string, inRange func(subjectName string) bool) []*section.Assertion
 	//Checkpoint returns all cached assertions
 	Checkpoint() []section.Section
 	//Len returns the number of elements in the cache.
 	Len() int
+	//Resize changes the maximum number of elements. The least recently used ones are removed until
+	//they fit.
+	Resize(maxSize int)
 }
 
 type NegativeAssertion interface {
@@ -150,4 +176,7 @@ type NegativeAssertion interface {
 	Checkpoint() []section.Section
 	//Len returns the number of elements in the cache.
 	Len() int
+	//Resize changes the maximum number of elements. The least recently used ones are removed until
+	//they fit.
+	Resize(maxSize int)
 }
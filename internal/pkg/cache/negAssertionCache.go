@@ -2,8 +2,8 @@ package cache
 
 import (
 	"sync"
-	"time"
 
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeCounter"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeHashMap"
 	"github.com/netsec-ethz/rains/internal/pkg/lruCache"
@@ -93,7 +93,19 @@ func add(c *NegAssertionImpl, s section.WithSigForward, expiration int64, isInte
 		isFull = c.counter.Inc()
 	}
 	value.mux.Unlock()
-	//Remove elements according to lru strategy
+	c.evict()
+	return !isFull
+}
+
+//Resize changes the maximum number of entries to maxSize. If the cache is full afterwards, the
+//least recently used shards, pshards and zones are removed.
+func (c *NegAssertionImpl) Resize(maxSize int) {
+	c.counter.SetMaxCount(maxSize)
+	c.evict()
+}
+
+//evict removes the least recently used sections until the cache is no longer full.
+func (c *NegAssertionImpl) evict() {
 	for c.counter.IsFull() {
 		key, value := c.cache.GetLeastRecentlyUsed()
 		if value == nil {
@@ -113,7 +125,6 @@ func add(c *NegAssertionImpl, s section.WithSigForward, expiration int64, isInte
 		c.counter.Sub(len(v.sections))
 		v.mux.Unlock()
 	}
-	return !isFull
 }
 
 //Get returns true and a set of assertions matching the given key if there exist some. Otherwise
@@ -150,7 +161,7 @@ func (c *NegAssertionImpl) RemoveExpiredValues() {
 			continue
 		}
 		for key, va := range value.sections {
-			if va.expiration < time.Now().Unix() {
+			if va.expiration < clock.Now().Unix() {
 				delete(value.sections, key)
 				deleteCount++
 			}
@@ -129,3 +129,27 @@ func TestNegAssertionCheckpoint(t *testing.T) {
 		}
 	}
 }
+
+func TestNegAssertionResize(t *testing.T) {
+	c := NewNegAssertion(10)
+	shards := getShards()
+	for _, s := range []*section.Shard{shards[0], shards[1], shards[3], shards[4]} {
+		c.AddShard(s, time.Now().Add(time.Hour).Unix(), false)
+	}
+	var tests = []struct {
+		size int
+		len  int
+	}{
+		{10, 4},
+		//Both shards of the least recently used zone are removed.
+		{4, 2},
+		{2, 1},
+		{1, 0},
+	}
+	for i, test := range tests {
+		c.Resize(test.size)
+		if c.Len() != test.len {
+			t.Errorf("%d: wrong number of sections. expected=%d actual=%d", i, test.len, c.Len())
+		}
+	}
+}
@@ -1,9 +1,8 @@
 package cache
 
 import (
-	"time"
-
 	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeCounter"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeHashMap"
 	"github.com/netsec-ethz/rains/internal/pkg/token"
@@ -31,6 +30,12 @@ func NewPendingKey(maxSize int) *PendingKeyImpl {
 	}
 }
 
+//Resize changes the maximum number of pending sections to maxSize. If the cache is full
+//afterwards, no sections are added until enough have been removed.
+func (c *PendingKeyImpl) Resize(maxSize int) {
+	c.counter.SetMaxCount(maxSize)
+}
+
 //Add adds ss to the cache together with the token and expiration time of the query sent to the
 //host with the addr defined in ss.
 func (c *PendingKeyImpl) Add(ss util.MsgSectionSender, t token.Token, expiration int64) {
@@ -68,7 +73,7 @@ func (c *PendingKeyImpl) RemoveExpiredValues() {
 	keys := c.tokenMap.GetAllKeys()
 	for _, key := range keys {
 		if val, present := c.tokenMap.Get(key); present {
-			if val := val.(pkcValue); val.expiration < time.Now().Unix() {
+			if val := val.(pkcValue); val.expiration < clock.Now().Unix() {
 				c.tokenMap.Remove(key)
 				c.counter.Dec()
 				log.Warn("No response to delegation query received before expiration",
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeCounter"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeHashMap"
 	"github.com/netsec-ethz/rains/internal/pkg/token"
@@ -64,6 +65,31 @@ func TestPendingKeyCache(t *testing.T) {
 	}
 }
 
+func TestPendingKeyCacheClock(t *testing.T) {
+	mss, _ := getQueries()
+	fake := clock.NewFake(time.Unix(1500000000, 0))
+	defer clock.Set(fake)()
+	c := &PendingKeyImpl{counter: safeCounter.New(4), tokenMap: safeHashMap.New()}
+	c.Add(mss[0], mss[0].Token, fake.Now().Add(time.Minute).Unix())
+	c.Add(mss[1], mss[1].Token, fake.Now().Add(time.Hour).Unix())
+	var tests = []struct {
+		advance time.Duration
+		len     int
+	}{
+		{0, 2},
+		{time.Minute, 2},
+		{time.Second, 1},
+		{time.Hour, 0},
+	}
+	for i, test := range tests {
+		fake.Advance(test.advance)
+		c.RemoveExpiredValues()
+		if c.Len() != test.len {
+			t.Errorf("%d: wrong number of values. expected=%d actual=%d", i, test.len, c.Len())
+		}
+	}
+}
+
 func TestPendingKeyCacheCounter(t *testing.T) {
 	mss, _ := getQueries()
 	var tests = []struct {
@@ -2,11 +2,10 @@ package cache
 
 import (
 	"fmt"
-	"strings"
 	"sync"
-	"time"
 
 	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeCounter"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
@@ -19,29 +18,40 @@ import (
 type pqcValue struct {
 	sss        []util.MsgSectionSender
 	expiration int64
+	//keys are the pqcKeys of the forwarded queries.
+	keys []string
 }
 
-//pqcKey returns a unique string representation of sections. Sections MUST only contain queries
-func pqcKey(sections []section.Section) (string, error) {
+//pqcKeys returns a unique string representation of each context, name and type asked for in
+//sections, without duplicates. Sections MUST only contain queries
+func pqcKeys(sections []section.Section) ([]string, error) {
 	result := []string{}
+	seen := make(map[string]bool)
 	for _, q := range sections {
 		q, ok := q.(*query.Name)
 		if !ok {
-			return "", fmt.Errorf("sections MUST only contain queries. sections=%v", sections)
+			return nil, fmt.Errorf("sections MUST only contain queries. sections=%v", sections)
 		}
 		for _, t := range q.Types {
+			key := fmt.Sprintf("%s:%s:%d", q.Context, q.Name, t)
 			if t == object.OTDelegation {
-				result = append(result, fmt.Sprintf("%s:%s:%d:%d", q.Name, q.Context, q.Types, q.KeyPhase))
-			} else {
-				result = append(result, fmt.Sprintf("%s:%s:%d", q.Name, q.Context, q.Types))
+				key = fmt.Sprintf("%s:%d", key, q.KeyPhase)
+			}
+			if !seen[key] {
+				seen[key] = true
+				result = append(result, key)
 			}
 		}
 	}
-	return strings.Join(result, "::"), nil
+	return result, nil
 }
 
+//PendingQueryImpl coalesces identical queries. The names of queries are fully qualified, such that
+//a context, name and type identify the zone and the name within the zone.
 type PendingQueryImpl struct {
-	qmux     sync.Mutex
+	qmux sync.Mutex
+	//queryMap maps each context, name and type to the token of the most recently forwarded query
+	//asking for it.
 	queryMap map[string]token.Token
 
 	tmux     sync.Mutex
@@ -59,37 +69,75 @@ func NewPendingQuery(maxSize int) *PendingQueryImpl {
 	}
 }
 
-//Add checks if this server has already forwarded a msg containing the same queries as ss. If
-//this is the case, ss is added to the cache and false is returned. If not, ss is added together
-//with t and expiration to the cache and true is returned.
+//Resize changes the maximum number of pending queries to maxSize. If the cache is full
+//afterwards, no queries are added until enough have been answered or removed.
+func (c *PendingQueryImpl) Resize(maxSize int) {
+	c.counter.SetMaxCount(maxSize)
+}
+
+//Add checks if this server has already forwarded a msg which is still pending and asks for every
+//context, name and type of the queries in ss. If this is the case, ss is added to the cache such
+//that it is answered together with the forwarded msg and false is returned. If not, ss is added
+//together with t and expiration to the cache and true is returned.
 func (c *PendingQueryImpl) Add(ss util.MsgSectionSender, t token.Token, expiration int64) bool {
 	c.qmux.Lock()
 	c.tmux.Lock()
+	defer c.qmux.Unlock()
 	defer c.tmux.Unlock()
 
 	if c.counter.IsFull() {
-		c.qmux.Unlock()
 		log.Error("Pending query cache is full")
 		return false
 	}
-	qmKey, err := pqcKey(ss.Sections)
+	keys, err := pqcKeys(ss.Sections)
 	if err != nil {
-		c.qmux.Unlock()
 		return false
 	}
 	c.counter.Inc()
-	if t, present := c.queryMap[qmKey]; present && c.tokenMap[t].expiration > time.Now().Unix() {
-		c.qmux.Unlock()
-		val := c.tokenMap[t]
+	if val := c.pending(keys); val != nil {
 		val.sss = append(val.sss, ss)
 		return false
 	}
-	c.queryMap[qmKey] = t
-	c.qmux.Unlock()
-	c.tokenMap[t] = &pqcValue{sss: []util.MsgSectionSender{ss}, expiration: expiration}
+	for _, key := range keys {
+		c.queryMap[key] = t
+	}
+	c.tokenMap[t] = &pqcValue{sss: []util.MsgSectionSender{ss}, expiration: expiration, keys: keys}
 	return true
 }
 
+//pending returns the entry of the forwarded msg which has not yet expired and asks for all keys or
+//nil if there is none. c.qmux and c.tmux must be held.
+func (c *PendingQueryImpl) pending(keys []string) *pqcValue {
+	if len(keys) == 0 {
+		return nil
+	}
+	t, present := c.queryMap[keys[0]]
+	if !present {
+		return nil
+	}
+	for _, key := range keys[1:] {
+		if c.queryMap[key] != t {
+			return nil
+		}
+	}
+	if val, present := c.tokenMap[t]; present && val.expiration > clock.Now().Unix() {
+		return val
+	}
+	return nil
+}
+
+//remove deletes the entry of t whose value is val. The forwarded queries of other entries are
+//kept. c.qmux and c.tmux must be held.
+func (c *PendingQueryImpl) remove(t token.Token, val *pqcValue) {
+	delete(c.tokenMap, t)
+	for _, key := range val.keys {
+		if c.queryMap[key] == t {
+			delete(c.queryMap, key)
+		}
+	}
+	c.counter.Sub(len(val.sss))
+}
+
 //GetAndRemove returns all util.MsgSectionSenders which correspond to token and delete them from the
 //cache.
 func (c *PendingQueryImpl) GetAndRemove(t token.Token) []util.MsgSectionSender {
@@ -99,10 +147,7 @@ func (c *PendingQueryImpl) GetAndRemove(t token.Token) []util.MsgSectionSender {
 	defer c.tmux.Unlock()
 
 	if val, present := c.tokenMap[t]; present {
-		delete(c.tokenMap, t)
-		key, _ := pqcKey(val.sss[0].Sections) //error case is catched in Add method.
-		delete(c.queryMap, key)               //all sss have the same pqcKey
-		c.counter.Sub(len(val.sss))
+		c.remove(t, val)
 		return val.sss
 	}
 	return nil
@@ -116,11 +161,8 @@ func (c *PendingQueryImpl) RemoveExpiredValues() {
 	defer c.tmux.Unlock()
 
 	for k, v := range c.tokenMap {
-		if v.expiration < time.Now().Unix() {
-			delete(c.tokenMap, k)
-			key, _ := pqcKey(v.sss[0].Sections) //error case is catched in Add method.
-			delete(c.queryMap, key)             //all sss have the same pqcKey
-			c.counter.Sub(len(v.sss))
+		if v.expiration < clock.Now().Unix() {
+			c.remove(k, v)
 		}
 	}
 }
@@ -6,8 +6,11 @@ import (
 	"time"
 
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeCounter"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
 )
 
 //TODO make compatible with new pendingQueryCache
@@ -80,3 +83,91 @@ func TestPendingQueryCache(t *testing.T) {
 		}
 	}
 }
+
+func TestPendingQueryCoalescing(t *testing.T) {
+	name := func(ctx, name string, keyPhase int, types ...object.Type) *query.Name {
+		return &query.Name{Context: ctx, Name: name, Types: types, KeyPhase: keyPhase}
+	}
+	ip4, ip6 := object.OTIP4Addr, object.OTIP6Addr
+	srv, deleg := object.OTServiceInfo, object.OTDelegation
+	var tests = []struct {
+		pending []section.Section
+		added   []section.Section
+		want    bool
+	}{
+		{[]section.Section{name(".", "www.ethz.ch.", 0, ip4)},
+			[]section.Section{name(".", "www.ethz.ch.", 0, ip4)}, false},
+		{[]section.Section{name(".", "www.ethz.ch.", 0, ip4, ip6)},
+			[]section.Section{name(".", "www.ethz.ch.", 0, ip6, ip4, ip6)}, false},
+		{[]section.Section{name(".", "www.ethz.ch.", 0, ip4, ip6)},
+			[]section.Section{name(".", "www.ethz.ch.", 0, ip6)}, false},
+		{[]section.Section{name(".", "www.ethz.ch.", 0, ip4), name(".", "ethz.ch.", 0, srv)},
+			[]section.Section{name(".", "ethz.ch.", 0, srv)}, false},
+		{[]section.Section{name(".", "www.ethz.ch.", 0, ip4)},
+			[]section.Section{name(".", "www.ethz.ch.", 0, ip4, ip6)}, true},
+		{[]section.Section{name(".", "www.ethz.ch.", 0, ip4)},
+			[]section.Section{name("cx-ethz.ch.", "www.ethz.ch.", 0, ip4)}, true},
+		{[]section.Section{name(".", "www.ethz.ch.", 0, ip4)},
+			[]section.Section{name(".", "ethz.ch.", 0, ip4)}, true},
+		{[]section.Section{name(".", "ch.", 0, deleg)},
+			[]section.Section{name(".", "ch.", 0, deleg)}, false},
+		{[]section.Section{name(".", "ch.", 0, deleg)},
+			[]section.Section{name(".", "ch.", 1, deleg)}, true},
+	}
+	for i, test := range tests {
+		c := NewPendingQuery(10)
+		exp := time.Now().Add(time.Hour).Unix()
+		first := util.MsgSectionSender{Sections: test.pending, Token: token.New()}
+		if !c.Add(first, first.Token, exp) {
+			t.Fatalf("%d: first query was not forwarded", i)
+		}
+		added := util.MsgSectionSender{Sections: test.added, Token: token.New()}
+		if isNew := c.Add(added, added.Token, exp); isNew != test.want {
+			t.Errorf("%d: wrong forwarding decision for %v while %v is pending. expected=%t actual=%t",
+				i, test.added, test.pending, test.want, isNew)
+		}
+		want := 2
+		if test.want {
+			want = 1
+		}
+		if v := c.GetAndRemove(first.Token); len(v) != want {
+			t.Errorf("%d: wrong number of waiting senders. expected=%d actual=%d", i, want, len(v))
+		}
+	}
+}
+
+func TestPendingQueryCoalescingAfterRemoval(t *testing.T) {
+	c := NewPendingQuery(10)
+	exp := time.Now().Add(time.Hour).Unix()
+	ip4 := &query.Name{Context: ".", Name: "www.ethz.ch.", Types: []object.Type{object.OTIP4Addr}}
+	both := &query.Name{Context: ".", Name: "www.ethz.ch.",
+		Types: []object.Type{object.OTIP4Addr, object.OTIP6Addr}}
+	mss := []util.MsgSectionSender{
+		{Sections: []section.Section{ip4}, Token: token.New()},
+		{Sections: []section.Section{both}, Token: token.New()},
+		{Sections: []section.Section{ip4}, Token: token.New()},
+		{Sections: []section.Section{ip4}, Token: token.New()},
+	}
+	c.Add(mss[0], mss[0].Token, exp)
+	if !c.Add(mss[1], mss[1].Token, exp) {
+		t.Fatal("query asking for an additional type was not forwarded")
+	}
+	//The forwarded query with both types is now the most recent one asking for the IPv4 address.
+	if c.Add(mss[2], mss[2].Token, exp) {
+		t.Error("query was forwarded although an identical one is pending")
+	}
+	if v := c.GetAndRemove(mss[0].Token); len(v) != 1 {
+		t.Errorf("wrong number of senders waiting for the first query. actual=%d", len(v))
+	}
+	if c.Add(mss[3], mss[3].Token, exp) {
+		t.Error("query was forwarded although the second forwarded query is still pending")
+	}
+	if v := c.GetAndRemove(mss[1].Token); len(v) != 3 || c.Len() != 0 {
+		t.Errorf("wrong senders waiting for the second query. actual=%d len=%d", len(v), c.Len())
+	}
+	//Expired queries are forwarded again.
+	c.Add(mss[0], mss[0].Token, time.Now().Add(-time.Hour).Unix())
+	if !c.Add(mss[2], mss[2].Token, exp) {
+		t.Error("query was not forwarded although the pending one expired")
+	}
+}
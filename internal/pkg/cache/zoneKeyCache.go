@@ -3,10 +3,10 @@ package cache
 import (
 	"fmt"
 	"sync"
-	"time"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeCounter"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeHashMap"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
@@ -105,37 +105,56 @@ func (c *ZoneKeyImpl) Add(assertion *section.Assertion, publicKey keys.PublicKey
 		v.mux.Unlock()
 		if c.counter.Inc() {
 			//cache is full, remove least recently used public key.
-			for {
-				if !c.counter.IsFull() {
-					return false
-				}
-				_, e := c.cache.GetLeastRecentlyUsed()
-				val := e.(*zoneKeyCacheValue)
-				val.mux.Lock() //This lock makes sure that no other add method can insert a new
-				//entry to this zoneKeyCacheValue publicKeys. Thus, it is safe to first get all keys
-				//and then remove one after an other from publicKeys.
-				if val.deleted {
-					val.mux.Unlock()
-					continue
-				}
-				val.deleted = true
-				for _, key := range val.publicKeys.GetAllKeys() {
-					if _, ok := val.publicKeys.Remove(key); ok {
-						c.counter.Dec()
-						c.mux.Lock()
-						c.keysPerContextZone[val.getContextZone()]--
-						c.mux.Unlock()
-					}
-				}
-				c.cache.Remove(val.getCacheKey())
-				val.mux.Unlock()
-				return false
-			}
+			c.removeLeastRecentlyUsed()
+			return false
 		}
 	}
 	return c.counter.Value() < c.warnSize
 }
 
+//Resize changes the maximum number of public keys to maxSize. If the cache is full afterwards,
+//the least recently used public keys are removed.
+func (c *ZoneKeyImpl) Resize(maxSize int) {
+	c.counter.SetMaxCount(maxSize)
+	for c.counter.IsFull() {
+		if !c.removeLeastRecentlyUsed() {
+			return
+		}
+	}
+}
+
+//removeLeastRecentlyUsed removes all public keys of the least recently used zone and context if
+//the cache is full. It returns false if there is nothing to remove.
+func (c *ZoneKeyImpl) removeLeastRecentlyUsed() bool {
+	for c.counter.IsFull() {
+		_, e := c.cache.GetLeastRecentlyUsed()
+		if e == nil {
+			return false
+		}
+		val := e.(*zoneKeyCacheValue)
+		val.mux.Lock() //This lock makes sure that no other add method can insert a new
+		//entry to this zoneKeyCacheValue publicKeys. Thus, it is safe to first get all keys
+		//and then remove one after an other from publicKeys.
+		if val.deleted {
+			val.mux.Unlock()
+			continue
+		}
+		val.deleted = true
+		for _, key := range val.publicKeys.GetAllKeys() {
+			if _, ok := val.publicKeys.Remove(key); ok {
+				c.counter.Dec()
+				c.mux.Lock()
+				c.keysPerContextZone[val.getContextZone()]--
+				c.mux.Unlock()
+			}
+		}
+		c.cache.Remove(val.getCacheKey())
+		val.mux.Unlock()
+		return true
+	}
+	return false
+}
+
 //Get returns true and a valid public key matching zone and publicKeyID. It returns false if
 //there exists no valid public key in the cache.
 func (c *ZoneKeyImpl) Get(zone, context string, sigMetaData signature.MetaData) (
@@ -147,7 +166,7 @@ func (c *ZoneKeyImpl) Get(zone, context string, sigMetaData signature.MetaData)
 	values := e.(*zoneKeyCacheValue).publicKeys.GetAll()
 	for _, v := range values {
 		key := v.(publicKeyAssertion).publicKey
-		if key.ValidUntil > time.Now().Unix() {
+		if key.ValidUntil > clock.Now().Unix() {
 			//key is non expired and valid
 			if key.ValidSince <= sigMetaData.ValidUntil && key.ValidUntil >= sigMetaData.ValidSince {
 				return key, v.(publicKeyAssertion).assertion, true
@@ -164,7 +183,7 @@ func (c *ZoneKeyImpl) RemoveExpiredKeys() {
 		val := value.(*zoneKeyCacheValue)
 		keys := val.publicKeys.GetAllKeys()
 		for _, key := range keys {
-			if k, ok := val.publicKeys.Get(key); ok && k.(publicKeyAssertion).publicKey.ValidUntil < time.Now().Unix() {
+			if k, ok := val.publicKeys.Get(key); ok && k.(publicKeyAssertion).publicKey.ValidUntil < clock.Now().Unix() {
 				if _, ok := val.publicKeys.Remove(key); ok {
 					c.counter.Dec()
 					c.mux.Lock()
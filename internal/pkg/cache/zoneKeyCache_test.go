@@ -127,3 +127,25 @@ func TestCheckpoint(t *testing.T) {
 		}
 	}
 }
+
+func TestZoneKeyResize(t *testing.T) {
+	c := NewZoneKey(10, 10, 2)
+	for _, tld := range []string{"ch", "org", "com"} {
+		delegation := getExampleDelgations(tld)[0]
+		c.Add(delegation, delegation.Content[0].Value.(keys.PublicKey), false)
+	}
+	var tests = []struct {
+		size int
+		len  int
+	}{
+		{10, 3},
+		{3, 2},
+		{1, 0},
+	}
+	for i, test := range tests {
+		c.Resize(test.size)
+		if c.Len() != test.len {
+			t.Errorf("%d: wrong number of public keys. expected=%d actual=%d", i, test.len, c.Len())
+		}
+	}
+}
@@ -0,0 +1,146 @@
+//Package capture records the queries a server receives and the answers it sends, similar to
+//dnstap, such that the traffic can be replayed against another server later. A capture is a file
+//with one JSON encoded record per line.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+)
+
+//Types of records
+const (
+	//Query is a received message containing a query.
+	Query = "query"
+	//Response is a sent message answering a query.
+	Response = "response"
+)
+
+//maxRecordLength is the maximal length of a record in bytes.
+const maxRecordLength = 4 << 20
+
+//Record is a message received or sent by a server.
+type Record struct {
+	//Time is the unix timestamp in nanoseconds at which the message was received or sent.
+	Time int64 `json:"time"`
+	//Type is either Query or Response.
+	Type string `json:"type"`
+	//Peer is the address from which the query was received or to which the response was sent.
+	Peer string `json:"peer"`
+	//Message is the CBOR encoding of the message.
+	Message []byte `json:"message"`
+}
+
+//Decode returns the message of r.
+func (r Record) Decode() (*message.Message, error) {
+	return message.Decode(r.Message)
+}
+
+//Writer appends records to a capture file. It is safe for concurrent use.
+type Writer struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+//Create opens the capture file at path for appending and creates it if it does not exist.
+func Create(path string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{file: file}, nil
+}
+
+//Write appends a record of type typ for msg exchanged with peer.
+func (w *Writer) Write(typ string, peer net.Addr, msg *message.Message) error {
+	r := Record{Time: time.Now().UnixNano(), Type: typ}
+	if peer != nil {
+		r.Peer = peer.String()
+	}
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(msg); err != nil {
+		return fmt.Errorf("could not encode message: %v", err)
+	}
+	r.Message = encoding.Bytes()
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, err = w.file.Write(append(line, '\n'))
+	return err
+}
+
+//Close closes the capture file.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.file.Close()
+}
+
+//Reader reads the records of a capture in the order in which they were written.
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+//NewReader returns a reader of the capture read from r.
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordLength)
+	return &Reader{scanner: scanner}
+}
+
+//Next returns the next record. It returns io.EOF after the last record.
+func (r *Reader) Next() (Record, error) {
+	for r.scanner.Scan() {
+		r.line++
+		if len(bytes.TrimSpace(r.scanner.Bytes())) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(r.scanner.Bytes(), &record); err != nil {
+			return Record{}, fmt.Errorf("line %d: malformed record: %v", r.line, err)
+		}
+		if record.Type != Query && record.Type != Response {
+			return Record{}, fmt.Errorf("line %d: unknown record type %q", r.line, record.Type)
+		}
+		return record, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
+
+//ReadFile returns all records of the capture file at path.
+func ReadFile(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var records []Record
+	reader := NewReader(file)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		records = append(records, record)
+	}
+}
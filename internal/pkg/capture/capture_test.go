@@ -0,0 +1,88 @@
+package capture
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "capture")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatalf("Was not able to create capture: %v", err)
+	}
+	peer := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5022}
+	tok := token.New()
+	msgs := []struct {
+		typ string
+		msg message.Message
+	}{
+		{Query, message.Message{Token: tok, Content: []section.Section{&query.Name{Context: ".",
+			Name: "example.com.", Types: []object.Type{object.OTIP4Addr}, Expiration: 1500000000,
+			Options: []query.Option{}}}}},
+		{Response, message.Message{Token: tok, Content: []section.Section{&section.Notification{
+			Token: tok, Type: section.NTNoAssertionAvail}}}},
+	}
+	for _, m := range msgs {
+		if err := w.Write(m.typ, peer, &m.msg); err != nil {
+			t.Fatalf("Was not able to write record: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadFile(path)
+	if err != nil || len(records) != len(msgs) {
+		t.Fatalf("Was not able to read records. records=%v err=%v", records, err)
+	}
+	for i, r := range records {
+		if r.Type != msgs[i].typ || r.Peer != peer.String() || r.Time == 0 ||
+			(i > 0 && r.Time < records[i-1].Time) {
+			t.Errorf("%d: wrong record %+v", i, r)
+		}
+		msg, err := r.Decode()
+		if err != nil || !reflect.DeepEqual(*msg, msgs[i].msg) {
+			t.Errorf("%d: wrong message. expected=%v actual=%v err=%v", i, msgs[i].msg, msg, err)
+		}
+	}
+}
+
+func TestReadMalformed(t *testing.T) {
+	var tests = []struct {
+		content string
+		err     string
+	}{
+		{`{"time":1,"type":"query"`, "line 1: malformed record"},
+		{"\n" + `{"time":1,"type":"answer","message":""}`, `line 2: unknown record type "answer"`},
+	}
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i, test := range tests {
+		path := filepath.Join(dir, "capture")
+		if err := ioutil.WriteFile(path, []byte(test.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadFile(path); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%d: wrong error. expected=%s actual=%v", i, test.err, err)
+		}
+	}
+}
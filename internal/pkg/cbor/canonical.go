@@ -0,0 +1,348 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+//Major types of CBOR data items.
+const (
+	majorUnsigned byte = iota
+	majorNegative
+	majorBytes
+	majorString
+	majorArray
+	majorMap
+	majorTag
+	majorOther
+)
+
+//indefinite is the additional information of an item with indefinite length and breakByte the
+//byte terminating it.
+const (
+	indefinite = 31
+	breakByte  = 0xff
+)
+
+//maxNesting is the maximum depth of nested arrays, maps and tags accepted by Canonical.
+const maxNesting = 512
+
+//Canonical returns the deterministic encoding of the single CBOR data item in data as defined in
+//section 4.2.1 of RFC 8949: integers, lengths and tags are encoded in their shortest form, floating
+//point numbers in the shortest form preserving their value, items of indefinite length are turned
+//into definite ones and the keys of maps are sorted by the bytewise order of their encodings. The
+//encoding of the writer returned by NewWriter is not guaranteed to be deterministic, e.g. it
+//encodes 255 in three bytes and sorts string keys lexicographically, so encodings which are signed
+//must be passed through Canonical such that the signatures can be verified by any implementation.
+func Canonical(data []byte) ([]byte, error) {
+	d := &decoder{data: data}
+	out := new(bytes.Buffer)
+	if err := d.canonical(out, 0); err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("%d bytes of trailing data after the CBOR item", len(data)-d.pos)
+	}
+	return out.Bytes(), nil
+}
+
+//IsCanonical returns true if data is the deterministic encoding of a single CBOR data item.
+func IsCanonical(data []byte) bool {
+	canonical, err := Canonical(data)
+	return err == nil && bytes.Equal(canonical, data)
+}
+
+//decoder reads CBOR data items from data starting at pos.
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+//head reads the initial byte and argument of the next data item. For items of indefinite length,
+//arg is zero and isIndefinite true.
+func (d *decoder) head() (major byte, info byte, arg uint64, isIndefinite bool, err error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, 0, false, errors.New("unexpected end of CBOR data")
+	}
+	major, info = d.data[d.pos]>>5, d.data[d.pos]&0x1f
+	d.pos++
+	switch {
+	case info < 24:
+		return major, info, uint64(info), false, nil
+	case info <= 27:
+		n := 1 << (info - 24)
+		if d.pos+n > len(d.data) {
+			return 0, 0, 0, false, errors.New("unexpected end of CBOR data")
+		}
+		for _, b := range d.data[d.pos : d.pos+n] {
+			arg = arg<<8 | uint64(b)
+		}
+		d.pos += n
+		return major, info, arg, false, nil
+	case info == indefinite && (major >= majorBytes && major <= majorMap || major == majorOther):
+		return major, info, 0, true, nil
+	default:
+		return 0, 0, 0, false, fmt.Errorf("malformed CBOR item: additional information %d for "+
+			"major type %d", info, major)
+	}
+}
+
+//bytes returns the next n bytes.
+func (d *decoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errors.New("unexpected end of CBOR data")
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+//atBreak returns true and consumes the break byte if it is the next byte.
+func (d *decoder) atBreak() bool {
+	if d.pos < len(d.data) && d.data[d.pos] == breakByte {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+//canonical writes the deterministic encoding of the next data item to out.
+func (d *decoder) canonical(out *bytes.Buffer, depth int) error {
+	if depth > maxNesting {
+		return fmt.Errorf("CBOR items are nested deeper than %d levels", maxNesting)
+	}
+	major, info, arg, isIndefinite, err := d.head()
+	if err != nil {
+		return err
+	}
+	switch major {
+	case majorUnsigned, majorNegative:
+		writeHead(out, major, arg)
+	case majorBytes, majorString:
+		return d.canonicalString(out, major, arg, isIndefinite)
+	case majorArray:
+		var items [][]byte
+		for i := uint64(0); isIndefinite || i < arg; i++ {
+			if isIndefinite && d.atBreak() {
+				break
+			}
+			item := new(bytes.Buffer)
+			if err := d.canonical(item, depth+1); err != nil {
+				return err
+			}
+			items = append(items, item.Bytes())
+		}
+		writeHead(out, majorArray, uint64(len(items)))
+		for _, item := range items {
+			out.Write(item)
+		}
+	case majorMap:
+		return d.canonicalMap(out, arg, isIndefinite, depth)
+	case majorTag:
+		writeHead(out, majorTag, arg)
+		return d.canonical(out, depth+1)
+	case majorOther:
+		return d.canonicalOther(out, info, arg, isIndefinite)
+	}
+	return nil
+}
+
+//canonicalString writes the definite length encoding of a byte or text string to out. The chunks
+//of a string of indefinite length are concatenated.
+func (d *decoder) canonicalString(out *bytes.Buffer, major byte, length uint64,
+	isIndefinite bool) error {
+	if !isIndefinite {
+		content, err := d.bytes(length)
+		if err != nil {
+			return err
+		}
+		writeHead(out, major, uint64(len(content)))
+		out.Write(content)
+		return nil
+	}
+	var content []byte
+	for !d.atBreak() {
+		chunkMajor, _, chunkLength, chunkIndefinite, err := d.head()
+		if err != nil {
+			return err
+		}
+		if chunkMajor != major || chunkIndefinite {
+			return errors.New("malformed CBOR item: invalid chunk in string of indefinite length")
+		}
+		chunk, err := d.bytes(chunkLength)
+		if err != nil {
+			return err
+		}
+		content = append(content, chunk...)
+	}
+	writeHead(out, major, uint64(len(content)))
+	out.Write(content)
+	return nil
+}
+
+//canonicalMap writes a map with keys sorted by the bytewise order of their deterministic encodings
+//to out. Maps with duplicate keys have no deterministic encoding.
+func (d *decoder) canonicalMap(out *bytes.Buffer, length uint64, isIndefinite bool,
+	depth int) error {
+	type pair struct{ key, value []byte }
+	var pairs []pair
+	for i := uint64(0); isIndefinite || i < length; i++ {
+		if isIndefinite && d.atBreak() {
+			break
+		}
+		key, value := new(bytes.Buffer), new(bytes.Buffer)
+		if err := d.canonical(key, depth+1); err != nil {
+			return err
+		}
+		if err := d.canonical(value, depth+1); err != nil {
+			return err
+		}
+		pairs = append(pairs, pair{key: key.Bytes(), value: value.Bytes()})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].key, pairs[j].key) < 0 })
+	for i := 1; i < len(pairs); i++ {
+		if bytes.Equal(pairs[i-1].key, pairs[i].key) {
+			return errors.New("CBOR map contains a duplicate key")
+		}
+	}
+	writeHead(out, majorMap, uint64(len(pairs)))
+	for _, p := range pairs {
+		out.Write(p.key)
+		out.Write(p.value)
+	}
+	return nil
+}
+
+//canonicalOther writes a simple value or a floating point number in its shortest form to out.
+func (d *decoder) canonicalOther(out *bytes.Buffer, info byte, arg uint64,
+	isIndefinite bool) error {
+	switch {
+	case isIndefinite:
+		return errors.New("malformed CBOR item: unexpected break")
+	case info < 24:
+		out.WriteByte(majorOther<<5 | info)
+	case info == 24:
+		if arg < 32 {
+			return fmt.Errorf("malformed CBOR item: simple value %d in two bytes", arg)
+		}
+		out.Write([]byte{majorOther<<5 | 24, byte(arg)})
+	case info == 25:
+		writeFloat(out, halfToFloat(uint16(arg)))
+	case info == 26:
+		writeFloat(out, float64(math.Float32frombits(uint32(arg))))
+	case info == 27:
+		writeFloat(out, math.Float64frombits(arg))
+	}
+	return nil
+}
+
+//writeHead writes the initial byte of an item of the given major type with its argument in the
+//shortest form.
+func writeHead(out *bytes.Buffer, major byte, arg uint64) {
+	switch {
+	case arg < 24:
+		out.WriteByte(major<<5 | byte(arg))
+	case arg <= math.MaxUint8:
+		out.Write([]byte{major<<5 | 24, byte(arg)})
+	case arg <= math.MaxUint16:
+		out.WriteByte(major<<5 | 25)
+		binary.Write(out, binary.BigEndian, uint16(arg))
+	case arg <= math.MaxUint32:
+		out.WriteByte(major<<5 | 26)
+		binary.Write(out, binary.BigEndian, uint32(arg))
+	default:
+		out.WriteByte(major<<5 | 27)
+		binary.Write(out, binary.BigEndian, arg)
+	}
+}
+
+//ArrayHeaderGrowth returns an upper bound of how many bytes the head of an array with n elements
+//is larger than the head of an array with at most one element.
+func ArrayHeaderGrowth(n int) int {
+	switch {
+	case n < 24:
+		return 0
+	case n <= math.MaxUint8:
+		return 1
+	case n <= math.MaxUint16:
+		return 2
+	default:
+		return 8
+	}
+}
+
+//writeFloat writes f in the shortest of the half, single and double precision encodings which
+//preserves its value. All NaNs are encoded as the half precision quiet NaN.
+func writeFloat(out *bytes.Buffer, f float64) {
+	if math.IsNaN(f) {
+		out.Write([]byte{majorOther<<5 | 25, 0x7e, 0x00})
+		return
+	}
+	f32 := float32(f)
+	if float64(f32) != f {
+		out.WriteByte(majorOther<<5 | 27)
+		binary.Write(out, binary.BigEndian, math.Float64bits(f))
+		return
+	}
+	if half, ok := floatToHalf(f32); ok {
+		out.WriteByte(majorOther<<5 | 25)
+		binary.Write(out, binary.BigEndian, half)
+		return
+	}
+	out.WriteByte(majorOther<<5 | 26)
+	binary.Write(out, binary.BigEndian, math.Float32bits(f32))
+}
+
+//floatToHalf returns the half precision encoding of f and true if it represents f exactly.
+func floatToHalf(f float32) (uint16, bool) {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp, mant := int(bits>>23&0xff), bits&0x7fffff
+	switch {
+	case exp == 0xff:
+		//infinity, NaNs are handled by the caller
+		return sign | 0x7c00, mant == 0
+	case exp == 0 && mant == 0:
+		return sign, true
+	case exp == 0:
+		//single precision subnormals are too small for half precision
+		return 0, false
+	}
+	e := exp - 127
+	switch {
+	case e >= -14 && e <= 15:
+		return sign | uint16(e+15)<<10 | uint16(mant>>13), mant&0x1fff == 0
+	case e >= -24 && e < -14:
+		//half precision subnormal: the value is significand * 2^-24
+		significand, shift := 0x800000|mant, uint(-(e + 1))
+		return sign | uint16(significand>>shift), significand&(1<<shift-1) == 0
+	default:
+		return 0, false
+	}
+}
+
+//halfToFloat returns the value of the half precision number h.
+func halfToFloat(h uint16) float64 {
+	exp, mant := int(h>>10&0x1f), float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(1024+mant, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
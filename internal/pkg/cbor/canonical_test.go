@@ -0,0 +1,112 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/britram/borat"
+)
+
+func TestCanonical(t *testing.T) {
+	var tests = []struct {
+		input     string
+		canonical string
+	}{
+		{"00", "00"},
+		{"1817", "17"},
+		{"1818", "1818"},
+		{"1900ff", "18ff"},
+		{"1a00000100", "190100"},
+		{"1b00000000ffffffff", "1affffffff"},
+		{"3b0000000000000000", "20"},
+		{"5a00000002abcd", "42abcd"},
+		{"5f41ab41cdff", "42abcd"},
+		{"7f61616162ff", "626162"},
+		{"9f0102ff", "820102"},
+		{"bf0102ff", "a10102"},
+		{"a2616202616101", "a2616101616202"},
+		{"a2616202180a01", "a20a01616202"},
+		{"a26161018119010003", "a26161018119010003"},
+		{"d9000100", "c100"},
+		{"fb3ff8000000000000", "f93e00"},
+		{"fa47c35000", "fa47c35000"},
+		{"fb3ff199999999999a", "fb3ff199999999999a"},
+		{"fa7fc00000", "f97e00"},
+		{"fb7ff0000000000000", "f97c00"},
+		{"fa33800000", "f90001"},
+		{"f97bff", "f97bff"},
+		{"f820", "f820"},
+	}
+	for i, test := range tests {
+		input, _ := hex.DecodeString(test.input)
+		expected, _ := hex.DecodeString(test.canonical)
+		canonical, err := Canonical(input)
+		if err != nil {
+			t.Fatalf("%d: unexpected error for %s: %v", i, test.input, err)
+		}
+		if !bytes.Equal(canonical, expected) {
+			t.Errorf("%d: wrong canonical encoding of %s, expected=%s actual=%x", i, test.input,
+				test.canonical, canonical)
+		}
+		if IsCanonical(input) != (test.input == test.canonical) {
+			t.Errorf("%d: IsCanonical(%s)=%v", i, test.input, !(test.input == test.canonical))
+		}
+		if !IsCanonical(canonical) {
+			t.Errorf("%d: canonical encoding %x is not canonical", i, canonical)
+		}
+	}
+}
+
+func TestCanonicalErrors(t *testing.T) {
+	var tests = []string{
+		"",
+		"18",
+		"0000",
+		"1c",
+		"1f",
+		"42ab",
+		"5f01ff",
+		"5f41ab",
+		"ff",
+		"f810",
+		"a20101",
+		"a2010101",
+		"a201010101",
+	}
+	for i, test := range tests {
+		input, _ := hex.DecodeString(test)
+		if _, err := Canonical(input); err == nil {
+			t.Errorf("%d: expected an error for %s", i, test)
+		}
+	}
+}
+
+func TestCanonicalWriterEncoding(t *testing.T) {
+	encoding := new(bytes.Buffer)
+	w := borat.NewCBORWriter(encoding)
+	if err := w.WriteIntMap(map[int]interface{}{255: 1, 24: []interface{}{65535, "a"}, 1: 4294967295}); err != nil {
+		t.Fatalf("could not encode map: %v", err)
+	}
+	expected, _ := hex.DecodeString("a301" + "1affffffff" + "1818" + "8219ffff6161" + "18ff" + "01")
+	canonical, err := Canonical(encoding.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(canonical, expected) {
+		t.Errorf("wrong canonical encoding, expected=%x actual=%x", expected, canonical)
+	}
+}
+
+func TestArrayHeaderGrowth(t *testing.T) {
+	one := new(bytes.Buffer)
+	writeHead(one, 4, 1)
+	for i, n := range []int{0, 1, 23, 24, 255, 256, 65535, 65536, 1 << 20} {
+		head := new(bytes.Buffer)
+		writeHead(head, 4, uint64(n))
+		if growth := ArrayHeaderGrowth(n); growth < head.Len()-one.Len() {
+			t.Errorf("%d: growth of %d elements is %d, but head grows by %d bytes", i, n, growth,
+				head.Len()-one.Len())
+		}
+	}
+}
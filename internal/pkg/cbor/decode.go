@@ -0,0 +1,216 @@
+package cbor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+//chunkSize is the size of the buffers from which decoded byte strings are allocated.
+const chunkSize = 4096
+
+//Decode decodes the single CBOR data item in data into the form produced by the untagged reads of
+//the reader returned by NewReader: integers are decoded as int, byte strings as []byte, text
+//strings as string, arrays as []interface{}, maps with integer keys as map[int]interface{} and
+//maps with text string keys as map[string]interface{}. A tagged data item is rejected with a
+//*TagError, the tag of a message must be removed with SplitTag first.
+//
+//Contrary to the reader returned by NewReader, the data item is decoded in a single pass. Slices
+//and maps are allocated with the length announced in the encoding, bounded by the number of
+//remaining bytes such that a malicious length cannot cause a large allocation, and byte strings
+//share a few larger buffers instead of being allocated one by one. Maps with duplicate keys are
+//rejected with a *DecodeError.
+func Decode(data []byte) (interface{}, error) {
+	d := &valueDecoder{decoder: decoder{data: data}}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("%d bytes of trailing data after the CBOR item", len(data)-d.pos)
+	}
+	return v, nil
+}
+
+//valueDecoder decodes data items into Go values.
+type valueDecoder struct {
+	decoder
+	//chunk is the buffer from which byte strings are allocated.
+	chunk []byte
+}
+
+//value decodes the next data item.
+func (d *valueDecoder) value(depth int) (interface{}, error) {
+	if depth > maxNesting {
+		return nil, fmt.Errorf("CBOR items are nested deeper than %d levels", maxNesting)
+	}
+	major, info, arg, isIndefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case majorUnsigned:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("CBOR integer does not fit into an int")
+		}
+		return int(arg), nil
+	case majorNegative:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("CBOR integer does not fit into an int")
+		}
+		return -1 - int(arg), nil
+	case majorBytes:
+		content, err := d.content(major, arg, isIndefinite)
+		if err != nil {
+			return nil, err
+		}
+		return d.alloc(content), nil
+	case majorString:
+		content, err := d.content(major, arg, isIndefinite)
+		if err != nil {
+			return nil, err
+		}
+		return string(content), nil
+	case majorArray:
+		array := make([]interface{}, 0, d.capacity(arg, isIndefinite, 1))
+		for i := uint64(0); isIndefinite || i < arg; i++ {
+			if isIndefinite && d.atBreak() {
+				break
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, v)
+		}
+		return array, nil
+	case majorMap:
+		return d.mapValue(arg, isIndefinite, depth)
+	case majorTag:
+		return nil, &TagError{Tag: arg}
+	}
+	//major type 7
+	switch {
+	case isIndefinite:
+		return nil, errors.New("malformed CBOR item: unexpected break")
+	case info == 20:
+		return false, nil
+	case info == 21:
+		return true, nil
+	case info == 22:
+		return nil, nil
+	case info == 25:
+		return halfToFloat(uint16(arg)), nil
+	case info == 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case info == 27:
+		return math.Float64frombits(arg), nil
+	}
+	return nil, fmt.Errorf("unsupported CBOR simple value %d", arg)
+}
+
+//mapValue decodes the entries of a map. The type of the first key determines whether the map has
+//integer or text string keys.
+func (d *valueDecoder) mapValue(length uint64, isIndefinite bool, depth int) (interface{},
+	error) {
+	size := d.capacity(length, isIndefinite, 2)
+	var intMap map[int]interface{}
+	var stringMap map[string]interface{}
+	for i := uint64(0); isIndefinite || i < length; i++ {
+		if isIndefinite && d.atBreak() {
+			break
+		}
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			if _, ok := key.(string); ok {
+				stringMap = make(map[string]interface{}, size)
+			} else {
+				intMap = make(map[int]interface{}, size)
+			}
+		}
+		value, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch key := key.(type) {
+		case int:
+			if intMap == nil {
+				return nil, errors.New("CBOR map has keys of different types")
+			}
+			if _, ok := intMap[key]; ok {
+				return nil, &DecodeError{Item: "map", Key: key, Kind: ErrDuplicateKey}
+			}
+			intMap[key] = value
+		case string:
+			if stringMap == nil {
+				return nil, errors.New("CBOR map has keys of different types")
+			}
+			if _, ok := stringMap[key]; ok {
+				return nil, &DecodeError{Item: "map", Key: -1, Kind: ErrDuplicateKey,
+					Detail: "key " + key}
+			}
+			stringMap[key] = value
+		default:
+			return nil, fmt.Errorf("unsupported CBOR map key of type %T", key)
+		}
+	}
+	if stringMap != nil {
+		return stringMap, nil
+	}
+	if intMap == nil {
+		intMap = make(map[int]interface{})
+	}
+	return intMap, nil
+}
+
+//capacity returns the number of elements to allocate for an array or map announcing length
+//elements, each consisting of itemsPerElement data items. As every data item takes at least one
+//byte, the remaining bytes bound the number of elements.
+func (d *valueDecoder) capacity(length uint64, isIndefinite bool, itemsPerElement int) int {
+	remaining := uint64((len(d.data) - d.pos) / itemsPerElement)
+	if isIndefinite || length > remaining {
+		return int(remaining)
+	}
+	return int(length)
+}
+
+//content returns the content of a byte or text string. The chunks of a string of indefinite
+//length are concatenated.
+func (d *valueDecoder) content(major byte, length uint64, isIndefinite bool) ([]byte, error) {
+	if !isIndefinite {
+		return d.bytes(length)
+	}
+	var content []byte
+	for !d.atBreak() {
+		chunkMajor, _, chunkLength, chunkIndefinite, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if chunkMajor != major || chunkIndefinite {
+			return nil, errors.New("malformed CBOR item: invalid chunk in string of indefinite length")
+		}
+		chunk, err := d.bytes(chunkLength)
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, chunk...)
+	}
+	return content, nil
+}
+
+//alloc returns a copy of b. Small copies are allocated from a shared buffer. The capacity of the
+//copy is limited to its length such that appending to it does not overwrite other byte strings.
+func (d *valueDecoder) alloc(b []byte) []byte {
+	if len(b) > chunkSize/4 {
+		return append(make([]byte, 0, len(b)), b...)
+	}
+	if len(b) > cap(d.chunk)-len(d.chunk) {
+		d.chunk = make([]byte, 0, chunkSize)
+	}
+	start := len(d.chunk)
+	d.chunk = append(d.chunk, b...)
+	return d.chunk[start:len(d.chunk):len(d.chunk)]
+}
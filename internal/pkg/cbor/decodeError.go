@@ -0,0 +1,86 @@
+package cbor
+
+import (
+	"errors"
+	"fmt"
+)
+
+//Kinds of a DecodeError. They are compared with the Kind of a DecodeError.
+var (
+	ErrMissingKey   = errors.New("missing mandatory key")
+	ErrUnknownKey   = errors.New("unknown key")
+	ErrDuplicateKey = errors.New("duplicate key")
+	ErrWrongType    = errors.New("wrong type")
+	ErrOutOfRange   = errors.New("value out of range")
+	ErrWrongLength  = errors.New("wrong number of elements")
+)
+
+//DecodeError is returned when a decoded CBOR map or array does not have the structure of the
+//message, section or object it is unmarshaled into.
+type DecodeError struct {
+	//Item names the decoded structure, e.g. assertion or signature.
+	Item string
+	//Key is the offending map key or array index. It is -1 if the error concerns the whole item.
+	Key int
+	//Kind is one of the Err* values above.
+	Kind error
+	//Detail optionally describes the problem further.
+	Detail string
+}
+
+func (e *DecodeError) Error() string {
+	msg := "cbor " + e.Item
+	if e.Key >= 0 {
+		msg += fmt.Sprintf(" key %d", e.Key)
+	}
+	msg += ": " + e.Kind.Error()
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+//MissingKey returns an error stating that the mandatory key is absent in the map of item.
+func MissingKey(item string, key int) error {
+	return &DecodeError{Item: item, Key: key, Kind: ErrMissingKey}
+}
+
+//WrongType returns an error stating that the value at key of item is not of the expected type.
+func WrongType(item string, key int, expected string) error {
+	return &DecodeError{Item: item, Key: key, Kind: ErrWrongType, Detail: "expected " + expected}
+}
+
+//OutOfRange returns an error stating that value at key of item is not a valid enumeration value
+//or lies outside the allowed range.
+func OutOfRange(item string, key int, value int) error {
+	return &DecodeError{Item: item, Key: key, Kind: ErrOutOfRange, Detail: fmt.Sprint(value)}
+}
+
+//WrongLength returns an error stating that the array at key of item does not have the expected
+//number of elements.
+func WrongLength(item string, key int, expected, actual int) error {
+	return &DecodeError{Item: item, Key: key, Kind: ErrWrongLength,
+		Detail: fmt.Sprintf("expected %d, actual %d", expected, actual)}
+}
+
+//CheckKeys returns an error naming the smallest key of m which is not in allowed. Unknown keys are
+//rejected as the protocol does not allow to extend a section with optional keys.
+func CheckKeys(item string, m map[int]interface{}, allowed ...int) error {
+	unknown, found := 0, false
+	for key := range m {
+		known := false
+		for _, k := range allowed {
+			if key == k {
+				known = true
+				break
+			}
+		}
+		if !known && (!found || key < unknown) {
+			unknown, found = key, true
+		}
+	}
+	if found {
+		return &DecodeError{Item: item, Key: unknown, Kind: ErrUnknownKey}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package cbor
+
+import "testing"
+
+func TestCheckKeys(t *testing.T) {
+	var tests = []struct {
+		input map[int]interface{}
+		key   int
+	}{
+		{map[int]interface{}{}, -1},
+		{map[int]interface{}{3: "a", 6: "."}, -1},
+		{map[int]interface{}{3: "a", 5: 1}, 5},
+		{map[int]interface{}{9: 1, 3: "a", 5: 1}, 5},
+	}
+	for i, test := range tests {
+		err := CheckKeys("assertion", test.input, 3, 6)
+		if test.key == -1 && err != nil {
+			t.Errorf("%d: unexpected error: %v", i, err)
+		} else if test.key != -1 {
+			decodeErr, ok := err.(*DecodeError)
+			if !ok || decodeErr.Key != test.key || decodeErr.Kind != ErrUnknownKey {
+				t.Errorf("%d: expected unknown key %d, actual error=%v", i, test.key, err)
+			}
+		}
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	var tests = []struct {
+		err    error
+		kind   error
+		errMsg string
+	}{
+		{MissingKey("assertion", 3), ErrMissingKey, "cbor assertion key 3: missing mandatory key"},
+		{WrongType("query", 12, "int"), ErrWrongType, "cbor query key 12: wrong type: expected int"},
+		{OutOfRange("object", 0, 99), ErrOutOfRange, "cbor object key 0: value out of range: 99"},
+		{WrongLength("signature", -1, 6, 5), ErrWrongLength,
+			"cbor signature: wrong number of elements: expected 6, actual 5"},
+	}
+	for i, test := range tests {
+		if decodeErr, ok := test.err.(*DecodeError); !ok || decodeErr.Kind != test.kind {
+			t.Errorf("%d: expected error of kind %v, actual=%v", i, test.kind, test.err)
+		}
+		if test.err.Error() != test.errMsg {
+			t.Errorf("%d: wrong error message, expected=%s, actual=%s", i, test.errMsg, test.err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package cbor
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  interface{}
+	}{
+		{"00", 0},
+		{"1903e8", 1000},
+		{"3903e7", -1000},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"5f42010241ffff", []byte{1, 2, 0xff}},
+		{"6461626364", "abcd"},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"f93e00", 1.5},
+		{"fb3ff8000000000000", 1.5},
+		{"83018202036161", []interface{}{1, []interface{}{2, 3}, "a"}},
+		{"9f0102ff", []interface{}{1, 2}},
+		{"a0", map[int]interface{}{}},
+		{"a201613120426869", map[int]interface{}{1: "1", -1: []byte("hi")}},
+		{"bf0000ff", map[int]interface{}{0: 0}},
+		{"a1616101", map[string]interface{}{"a": 1}},
+	}
+	for i, test := range tests {
+		input, _ := hex.DecodeString(test.input)
+		v, err := Decode(input)
+		if err != nil {
+			t.Errorf("%d: unexpected error decoding %s: %v", i, test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, test.want) {
+			t.Errorf("%d: wrong value decoding %s. expected=%#v actual=%#v", i, test.input,
+				test.want, v)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var tests = []struct {
+		input     string
+		duplicate bool
+	}{
+		{"", false},
+		{"0101", false},
+		{"1bffffffffffffffff", false},
+		{"9b00000000ffffffff00", false},
+		{"a2000100", false},
+		{"a2000161610100", false},
+		{"a1f400", false},
+		{"a200010002", true},
+		{"a2616100616101", true},
+		{"ff", false},
+		{"f7", false},
+	}
+	for i, test := range tests {
+		input, _ := hex.DecodeString(test.input)
+		_, err := Decode(input)
+		if err == nil {
+			t.Errorf("%d: expected an error decoding %s", i, test.input)
+			continue
+		}
+		decodeErr, ok := err.(*DecodeError)
+		if duplicate := ok && decodeErr.Kind == ErrDuplicateKey; duplicate != test.duplicate {
+			t.Errorf("%d: wrong error decoding %s: %v", i, test.input, err)
+		}
+	}
+}
+
+func TestDecodeSharesBuffers(t *testing.T) {
+	input, _ := hex.DecodeString("82420102420304")
+	v, err := Decode(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	elements := v.([]interface{})
+	first, second := elements[0].([]byte), elements[1].([]byte)
+	if cap(first) != 2 {
+		t.Errorf("byte string capacity exceeds its length. cap=%d", cap(first))
+	}
+	_ = append(first, 9)
+	if !reflect.DeepEqual(second, []byte{3, 4}) {
+		t.Errorf("appending to a byte string modified another one: %v", second)
+	}
+	input[1] = 7
+	if elements[0].([]byte)[0] != 1 {
+		t.Error("decoded byte string refers to the input")
+	}
+}
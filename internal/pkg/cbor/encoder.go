@@ -0,0 +1,56 @@
+package cbor
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/britram/borat"
+)
+
+//maxPooledSize is the capacity above which an encoder's buffer is dropped on release such that a
+//single large zone does not keep its memory allocated.
+const maxPooledSize = 1 << 20
+
+var encoderPool = sync.Pool{
+	New: func() interface{} {
+		e := &Encoder{}
+		e.writer = borat.NewCBORWriter(&e.buf)
+		return e
+	},
+}
+
+//Encoder encodes values into a buffer which is reused by other encoders after Release. Reusing the
+//buffer and the writer avoids allocating both for each message sent.
+type Encoder struct {
+	buf    bytes.Buffer
+	writer *borat.CBORWriter
+}
+
+//NewEncoder returns an encoder from the pool whose buffer can hold at least sizeHint bytes without
+//growing.
+func NewEncoder(sizeHint int) *Encoder {
+	e := encoderPool.Get().(*Encoder)
+	if sizeHint > 0 {
+		e.buf.Grow(sizeHint)
+	}
+	return e
+}
+
+//Encode marshals x and returns its encoding. The returned slice is only valid until the next call
+//to Encode or Release.
+func (e *Encoder) Encode(x interface{}) ([]byte, error) {
+	e.buf.Reset()
+	if err := e.writer.Marshal(x); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+//Release returns e to the pool. Neither e nor the encodings it returned must be used afterwards.
+func (e *Encoder) Release() {
+	if e.buf.Cap() > maxPooledSize {
+		e.buf = bytes.Buffer{}
+	}
+	e.buf.Reset()
+	encoderPool.Put(e)
+}
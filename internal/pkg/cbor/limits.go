@@ -0,0 +1,297 @@
+package cbor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/britram/borat"
+)
+
+//Default limits of a reader returned by NewLimitedReader.
+const (
+	defaultMaxSize   = 1 << 20
+	defaultMaxDepth  = 32
+	defaultMaxLength = 1 << 16
+)
+
+//Limits bounds the resources a single encoded data item, e.g. a message, may use. A zero value
+//selects the default of the respective limit.
+type Limits struct {
+	//MaxSize is the maximum number of bytes of an encoded data item. It defaults to 1 MiB.
+	MaxSize int
+	//MaxDepth is the maximum nesting depth of arrays, maps and tags. It defaults to 32.
+	MaxDepth int
+	//MaxLength is the maximum number of elements of an array, entries of a map or chunks of a
+	//string. It defaults to 65536.
+	MaxLength int
+}
+
+//WithDefaults returns l with all zero limits set to their default.
+func (l Limits) WithDefaults() Limits {
+	if l.MaxSize <= 0 {
+		l.MaxSize = defaultMaxSize
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = defaultMaxDepth
+	}
+	if l.MaxLength <= 0 {
+		l.MaxLength = defaultMaxLength
+	}
+	return l
+}
+
+//LimitError is returned by a limited reader if a data item exceeds one of its limits.
+type LimitError struct {
+	//Limit is the name of the exceeded limit, e.g. MaxSize.
+	Limit string
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("CBOR item exceeds %s of %d", e.Limit, e.Max)
+}
+
+//limitedReader reads one data item at a time from in and only passes it to a borat reader once it
+//is complete and within its limits.
+type limitedReader struct {
+	in     *bufio.Reader
+	limits Limits
+	frame  bytes.Buffer
+	//tagged is the reader of the item which was tagged by the tag returned by the last ReadTag.
+	tagged *borat.CBORReader
+}
+
+//NewLimitedReader returns a cbor reader which reads from in and returns a *LimitError as soon as a
+//data item exceeds limits. The data item is read incrementally such that a length announced by a
+//malicious peer does not cause a large allocation before the data has actually been received. A map
+//containing the same key twice is rejected with a *DecodeError, as the decoded map would silently
+//keep only one of the values. A data item is rejected with a *TagError if it has a tag not used by
+//RAINS or contains a tagged item. The stream cannot be read further after an error.
+func NewLimitedReader(in io.Reader, limits Limits) Reader {
+	return &limitedReader{in: bufio.NewReader(in), limits: limits.WithDefaults()}
+}
+
+//ReadFrame reads the encoding of the next data item from in and returns it without decoding it. It
+//checks limits and returns the same errors as a reader returned by NewLimitedReader.
+func ReadFrame(in *bufio.Reader, limits Limits) ([]byte, error) {
+	r := &limitedReader{in: in, limits: limits.WithDefaults()}
+	if err := r.readFrame(); err != nil {
+		return nil, err
+	}
+	return r.frame.Bytes(), nil
+}
+
+//Unmarshal reads the next data item and unmarshals it into x.
+func (r *limitedReader) Unmarshal(x interface{}) error {
+	reader, err := r.next()
+	if err != nil {
+		return err
+	}
+	return reader.Unmarshal(x)
+}
+
+//ReadTag reads the next data item which must be a tag. The tagged item is read by the next call.
+func (r *limitedReader) ReadTag() (borat.CBORTag, error) {
+	reader, err := r.next()
+	if err != nil {
+		return 0, err
+	}
+	tag, err := reader.ReadTag()
+	if err != nil {
+		return 0, err
+	}
+	r.tagged = reader
+	return tag, nil
+}
+
+//ReadIntMapUntagged reads the next data item which must be a map with integer keys.
+func (r *limitedReader) ReadIntMapUntagged() (map[int]interface{}, error) {
+	reader, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	return reader.ReadIntMapUntagged()
+}
+
+//next reads the next data item and returns a borat reader for it. If the last call read a tag,
+//the reader of the tagged item is returned instead.
+func (r *limitedReader) next() (*borat.CBORReader, error) {
+	if reader := r.tagged; reader != nil {
+		r.tagged = nil
+		return reader, nil
+	}
+	if err := r.readFrame(); err != nil {
+		return nil, err
+	}
+	return borat.NewCBORReader(bytes.NewReader(r.frame.Bytes())), nil
+}
+
+//readFrame reads the encoding of the next data item into r.frame. It returns io.EOF if the stream
+//ended before the data item started and io.ErrUnexpectedEOF if it ended within it.
+func (r *limitedReader) readFrame() error {
+	r.frame.Reset()
+	if _, err := r.in.Peek(1); err != nil {
+		return err
+	}
+	if err := r.readItem(0); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+//read appends the next n bytes of the stream to r.frame.
+func (r *limitedReader) read(n uint64) error {
+	if n > uint64(r.limits.MaxSize-r.frame.Len()) {
+		return &LimitError{Limit: "MaxSize", Max: r.limits.MaxSize}
+	}
+	if _, err := io.CopyN(&r.frame, r.in, int64(n)); err != nil {
+		return err
+	}
+	return nil
+}
+
+//readHead reads the initial byte and argument of the next data item.
+func (r *limitedReader) readHead() (major byte, info byte, arg uint64, isIndefinite bool,
+	err error) {
+	start := r.frame.Len()
+	if err := r.read(1); err != nil {
+		return 0, 0, 0, false, err
+	}
+	if info := r.frame.Bytes()[start] & 0x1f; info >= 24 && info <= 27 {
+		if err := r.read(1 << (info - 24)); err != nil {
+			return 0, 0, 0, false, err
+		}
+	}
+	d := &decoder{data: r.frame.Bytes(), pos: start}
+	return d.head()
+}
+
+//atBreak returns true and reads the break byte if it is the next byte of the stream.
+func (r *limitedReader) atBreak() (bool, error) {
+	b, err := r.in.Peek(1)
+	if err != nil {
+		return false, err
+	}
+	if b[0] != breakByte {
+		return false, nil
+	}
+	return true, r.read(1)
+}
+
+//readItem reads the next data item at the given nesting depth into r.frame while checking the
+//limits.
+func (r *limitedReader) readItem(depth int) error {
+	major, _, arg, isIndefinite, err := r.readHead()
+	if err != nil {
+		return err
+	}
+	switch major {
+	case majorBytes, majorString:
+		if !isIndefinite {
+			return r.read(arg)
+		}
+		return r.readElements(depth, 1, func() error {
+			chunkMajor, _, length, chunkIndefinite, err := r.readHead()
+			if err != nil {
+				return err
+			}
+			if chunkMajor != major || chunkIndefinite {
+				return errors.New("malformed CBOR item: invalid chunk in string of indefinite length")
+			}
+			return r.read(length)
+		}, arg, isIndefinite)
+	case majorArray:
+		return r.readElements(depth, 1, nil, arg, isIndefinite)
+	case majorMap:
+		return r.readElements(depth, 2, nil, arg, isIndefinite)
+	case majorTag:
+		if _, ok := TagName(arg); !ok || depth > 0 {
+			return &TagError{Tag: arg}
+		}
+		if depth+1 > r.limits.MaxDepth {
+			return &LimitError{Limit: "MaxDepth", Max: r.limits.MaxDepth}
+		}
+		return r.readItem(depth + 1)
+	case majorOther:
+		if isIndefinite {
+			return errors.New("malformed CBOR item: unexpected break")
+		}
+	}
+	return nil
+}
+
+//readElements reads the length elements of an array, map or string of indefinite length, each
+//consisting of itemsPerElement data items read with readElement. If readElement is nil, the nested
+//data items are read.
+func (r *limitedReader) readElements(depth, itemsPerElement int, readElement func() error,
+	length uint64, isIndefinite bool) error {
+	if readElement == nil {
+		if depth+1 > r.limits.MaxDepth {
+			return &LimitError{Limit: "MaxDepth", Max: r.limits.MaxDepth}
+		}
+		//keys contains the canonical encodings of the keys of a map read so far.
+		var keys map[string]bool
+		if itemsPerElement == 2 {
+			keys = make(map[string]bool)
+		}
+		readElement = func() error {
+			start := r.frame.Len()
+			if err := r.readItem(depth + 1); err != nil {
+				return err
+			}
+			if keys != nil {
+				if err := checkDuplicateKey(keys, r.frame.Bytes()[start:]); err != nil {
+					return err
+				}
+			}
+			for i := 1; i < itemsPerElement; i++ {
+				if err := r.readItem(depth + 1); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	for i := uint64(0); isIndefinite || i < length; i++ {
+		if isIndefinite {
+			if done, err := r.atBreak(); err != nil || done {
+				return err
+			}
+		}
+		if i >= uint64(r.limits.MaxLength) {
+			return &LimitError{Limit: "MaxLength", Max: r.limits.MaxLength}
+		}
+		if err := readElement(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//checkDuplicateKey returns an error if the map key encoded in key is already contained in keys and
+//adds it otherwise. Keys are compared by their canonical encoding such that e.g. an integer
+//encoded in different lengths is recognized as the same key.
+func checkDuplicateKey(keys map[string]bool, key []byte) error {
+	canonical, err := Canonical(key)
+	if err != nil {
+		return err
+	}
+	if !keys[string(canonical)] {
+		keys[string(canonical)] = true
+		return nil
+	}
+	d := &decoder{data: canonical}
+	major, _, arg, _, _ := d.head()
+	if major == majorUnsigned && arg <= math.MaxInt32 {
+		return &DecodeError{Item: "map", Key: int(arg), Kind: ErrDuplicateKey}
+	}
+	return &DecodeError{Item: "map", Key: -1, Kind: ErrDuplicateKey,
+		Detail: fmt.Sprintf("key %x", canonical)}
+}
@@ -0,0 +1,89 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+func TestLimitedReader(t *testing.T) {
+	limits := Limits{MaxSize: 16, MaxDepth: 2, MaxLength: 3}
+	var tests = []struct {
+		input string
+		limit string
+	}{
+		{"83010203", ""},
+		{"84010203", "MaxLength"},
+		{"9f010203ff", ""},
+		{"9f01020304ff", "MaxLength"},
+		{"a3010102020303", ""},
+		{"a401010202030304", "MaxLength"},
+		{"818101", ""},
+		{"81818101", "MaxDepth"},
+		{"da00e99ba8818101", "MaxDepth"},
+		{"4f000102030405060708090a0b0c0d0e", ""},
+		{"50000102030405060708090a0b0c0d0e0f", "MaxSize"},
+		{"5bffffffffffffffff", "MaxSize"},
+		{"5f430001024400010203ff", ""},
+		{"5f41004101410241034104ff", "MaxLength"},
+	}
+	for i, test := range tests {
+		input, _ := hex.DecodeString(test.input)
+		var x interface{}
+		err := NewLimitedReader(bytes.NewReader(input), limits).Unmarshal(&x)
+		limitErr, ok := err.(*LimitError)
+		if test.limit == "" && ok {
+			t.Errorf("%d: %s should be within the limits: %v", i, test.input, err)
+		} else if test.limit != "" && (!ok || limitErr.Limit != test.limit) {
+			t.Errorf("%d: expected %s to exceed %s, actual error=%v", i, test.input, test.limit, err)
+		}
+	}
+}
+
+func TestLimitedReaderStream(t *testing.T) {
+	input, _ := hex.DecodeString("8201026161" + "18")
+	reader := NewLimitedReader(bytes.NewReader(input), Limits{})
+	var array []int
+	if err := reader.Unmarshal(&array); err != nil || len(array) != 2 || array[1] != 2 {
+		t.Fatalf("could not read array: %v %v", array, err)
+	}
+	var s string
+	if err := reader.Unmarshal(&s); err != nil || s != "a" {
+		t.Fatalf("could not read string: %v %v", s, err)
+	}
+	if err := reader.Unmarshal(&s); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected unexpected EOF but got %v", err)
+	}
+	if err := reader.Unmarshal(&s); err != io.EOF {
+		t.Fatalf("expected EOF but got %v", err)
+	}
+}
+
+func TestLimitedReaderDuplicateKeys(t *testing.T) {
+	var tests = []struct {
+		input string
+		key   int
+	}{
+		{"a3010102020303", -2},
+		{"a201010102", 1},
+		{"a2010118010102", 1},
+		{"a2616101616102", -1},
+		{"bf01010102ff", 1},
+		{"81a2020102020303", 2},
+		{"a201a1010102a1010102", -2},
+	}
+	for i, test := range tests {
+		input, _ := hex.DecodeString(test.input)
+		var x interface{}
+		err := NewLimitedReader(bytes.NewReader(input), Limits{}).Unmarshal(&x)
+		decodeErr, ok := err.(*DecodeError)
+		if test.key == -2 && ok {
+			t.Errorf("%d: %s has no duplicate keys: %v", i, test.input, err)
+		} else if test.key != -2 && (!ok || decodeErr.Kind != ErrDuplicateKey ||
+			decodeErr.Key != test.key) {
+			t.Errorf("%d: expected duplicate key %d in %s, actual error=%v", i, test.key,
+				test.input, err)
+		}
+	}
+}
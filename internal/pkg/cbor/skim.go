@@ -0,0 +1,158 @@
+package cbor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+//SplitTag returns the tag number and the encoding of the tagged data item of the tagged CBOR
+//data item in data without decoding the latter.
+func SplitTag(data []byte) (uint64, []byte, error) {
+	d := &decoder{data: data}
+	major, _, tag, _, err := d.head()
+	if err != nil {
+		return 0, nil, err
+	}
+	if major != majorTag {
+		return 0, nil, errors.New("CBOR item is not tagged")
+	}
+	item, err := d.item()
+	if err != nil {
+		return 0, nil, err
+	}
+	if d.pos != len(data) {
+		return 0, nil, fmt.Errorf("%d bytes of trailing data after the CBOR item", len(data)-d.pos)
+	}
+	return tag, item, nil
+}
+
+//SplitMap returns the encoding of each value of the CBOR map in data by its integer key without
+//decoding the values. It returns a *DecodeError if a key occurs twice.
+func SplitMap(data []byte) (map[int][]byte, error) {
+	d := &decoder{data: data}
+	major, _, length, isIndefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if major != majorMap {
+		return nil, errors.New("CBOR item is not a map")
+	}
+	values := make(map[int][]byte)
+	for i := uint64(0); isIndefinite || i < length; i++ {
+		if isIndefinite && d.atBreak() {
+			break
+		}
+		keyMajor, _, arg, _, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if keyMajor != majorUnsigned && keyMajor != majorNegative || arg > math.MaxInt32 {
+			return nil, errors.New("CBOR map key is not a small integer")
+		}
+		key := int(arg)
+		if keyMajor == majorNegative {
+			key = -1 - key
+		}
+		if _, ok := values[key]; ok {
+			return nil, &DecodeError{Item: "map", Key: key, Kind: ErrDuplicateKey}
+		}
+		if values[key], err = d.item(); err != nil {
+			return nil, err
+		}
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("%d bytes of trailing data after the CBOR item", len(data)-d.pos)
+	}
+	return values, nil
+}
+
+//SplitArray returns the encoding of each element of the CBOR array in data without decoding the
+//elements.
+func SplitArray(data []byte) ([][]byte, error) {
+	d := &decoder{data: data}
+	major, _, length, isIndefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if major != majorArray {
+		return nil, errors.New("CBOR item is not an array")
+	}
+	var elements [][]byte
+	for i := uint64(0); isIndefinite || i < length; i++ {
+		if isIndefinite && d.atBreak() {
+			break
+		}
+		element, err := d.item()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("%d bytes of trailing data after the CBOR item", len(data)-d.pos)
+	}
+	return elements, nil
+}
+
+//item returns the encoding of the next data item and skips it.
+func (d *decoder) item() ([]byte, error) {
+	start := d.pos
+	if err := d.skip(0); err != nil {
+		return nil, err
+	}
+	return d.data[start:d.pos], nil
+}
+
+//skip moves past the next data item without decoding it.
+func (d *decoder) skip(depth int) error {
+	if depth > maxNesting {
+		return fmt.Errorf("CBOR items are nested deeper than %d levels", maxNesting)
+	}
+	major, _, arg, isIndefinite, err := d.head()
+	if err != nil {
+		return err
+	}
+	items := uint64(1)
+	switch major {
+	case majorBytes, majorString:
+		if !isIndefinite {
+			_, err := d.bytes(arg)
+			return err
+		}
+		for !d.atBreak() {
+			chunkMajor, _, length, chunkIndefinite, err := d.head()
+			if err != nil {
+				return err
+			}
+			if chunkMajor != major || chunkIndefinite {
+				return errors.New("malformed CBOR item: invalid chunk in string of indefinite length")
+			}
+			if _, err := d.bytes(length); err != nil {
+				return err
+			}
+		}
+		return nil
+	case majorMap:
+		items = 2
+		fallthrough
+	case majorArray:
+		for i := uint64(0); isIndefinite || i < arg; i++ {
+			if isIndefinite && d.atBreak() {
+				return nil
+			}
+			for j := uint64(0); j < items; j++ {
+				if err := d.skip(depth + 1); err != nil {
+					return err
+				}
+			}
+		}
+	case majorTag:
+		return d.skip(depth + 1)
+	case majorOther:
+		if isIndefinite {
+			return errors.New("malformed CBOR item: unexpected break")
+		}
+	}
+	return nil
+}
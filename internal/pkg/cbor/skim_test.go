@@ -0,0 +1,48 @@
+package cbor
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tagged, _ := hex.DecodeString("da00e99ba8a200820102178261618163626364")
+	tag, item, err := SplitTag(tagged)
+	if err != nil || tag != 0xe99ba8 || hex.EncodeToString(item) != "a200820102178261618163626364" {
+		t.Fatalf("wrong tag split. tag=%x item=%x err=%v", tag, item, err)
+	}
+	values, err := SplitMap(item)
+	if err != nil || len(values) != 2 || hex.EncodeToString(values[0]) != "820102" ||
+		hex.EncodeToString(values[23]) != "8261618163626364" {
+		t.Fatalf("wrong map split. values=%x err=%v", values, err)
+	}
+	elements, err := SplitArray(values[23])
+	if err != nil || fmt.Sprintf("%x", elements) != "[6161 8163626364]" {
+		t.Fatalf("wrong array split. elements=%x err=%v", elements, err)
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	var tests = []struct {
+		input string
+		split func([]byte) error
+	}{
+		{"a10101", func(b []byte) error { _, _, err := SplitTag(b); return err }},
+		{"c1a1010101", func(b []byte) error { _, _, err := SplitTag(b); return err }},
+		{"8101", func(b []byte) error { _, err := SplitMap(b); return err }},
+		{"a2010101", func(b []byte) error { _, err := SplitMap(b); return err }},
+		{"a1616101", func(b []byte) error { _, err := SplitMap(b); return err }},
+		{"a201010102", func(b []byte) error { _, err := SplitMap(b); return err }},
+		{"a101", func(b []byte) error { _, err := SplitArray(b); return err }},
+		{"9f0101", func(b []byte) error { _, err := SplitArray(b); return err }},
+		{"820102ff", func(b []byte) error { _, err := SplitArray(b); return err }},
+		{"815f4101", func(b []byte) error { _, err := SplitArray(b); return err }},
+	}
+	for i, test := range tests {
+		input, _ := hex.DecodeString(test.input)
+		if err := test.split(input); err == nil {
+			t.Errorf("%d: expected an error when splitting %s", i, test.input)
+		}
+	}
+}
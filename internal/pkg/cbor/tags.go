@@ -0,0 +1,45 @@
+package cbor
+
+import "fmt"
+
+//Tag numbers of the CBOR data items defined by RAINS. Only a message as a whole is tagged. A tag
+//within a message is rejected when decoding, as its meaning would be lost on a receiver ignoring
+//it.
+const (
+	//MessageTag tags the encoding of a message. It is the UTF-8 encoding of U+96E8, the Chinese
+	//character for rain.
+	MessageTag = 0xE99BA8
+)
+
+//tagNames contains the name of each tag number used by RAINS.
+var tagNames = map[uint64]string{
+	MessageTag: "RAINS message",
+}
+
+//TagName returns the name of tag and false if tag is not used by RAINS.
+func TagName(tag uint64) (string, bool) {
+	name, ok := tagNames[tag]
+	return name, ok
+}
+
+//TagError is returned when a data item has a tag which is unknown or not allowed at its position.
+type TagError struct {
+	Tag uint64
+	//Expected is the tag the data item must have. It is zero if the data item must not be tagged.
+	Expected uint64
+}
+
+func (e *TagError) Error() string {
+	if name, ok := TagName(e.Expected); ok {
+		return fmt.Sprintf("expected tag for %s but got: %d", name, e.Tag)
+	}
+	return fmt.Sprintf("unexpected CBOR tag %d", e.Tag)
+}
+
+//CheckTag returns a *TagError if tag is not expected.
+func CheckTag(tag, expected uint64) error {
+	if tag != expected {
+		return &TagError{Tag: tag, Expected: expected}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestCheckTag(t *testing.T) {
+	if err := CheckTag(MessageTag, MessageTag); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := CheckTag(MessageTag+1, MessageTag)
+	if err == nil || err.Error() != "expected tag for RAINS message but got: 15309737" {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestStrictTags(t *testing.T) {
+	var tests = []struct {
+		input string
+		tag   uint64
+	}{
+		{"c11a5bd1e0a0", 1},
+		{"d9d9f7a0", 55799},
+		{"da00e99ba8a101c202", 2},
+		{"da00e99ba881da00e99ba8a0", MessageTag},
+	}
+	for i, test := range tests {
+		input, _ := hex.DecodeString(test.input)
+		var x interface{}
+		err := NewLimitedReader(bytes.NewReader(input), Limits{}).Unmarshal(&x)
+		if tagErr, ok := err.(*TagError); !ok || tagErr.Tag != test.tag {
+			t.Errorf("%d: expected tag error for tag %d but got %v", i, test.tag, err)
+		}
+		if tag, item, _ := SplitTag(input); tag == MessageTag {
+			if _, err := Decode(item); err == nil {
+				t.Errorf("%d: expected Decode to reject the nested tag", i)
+			}
+		}
+	}
+}
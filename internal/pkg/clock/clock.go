@@ -0,0 +1,93 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+//Clock returns the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+//System is the clock of the operating system.
+type System struct{}
+
+//Now implements Clock
+func (System) Now() time.Time {
+	return time.Now()
+}
+
+var (
+	mux     sync.RWMutex
+	current Clock = System{}
+)
+
+//Now returns the current time of the clock in use. The validity of queries, signatures and cache
+//entries is checked and computed against it. Timeouts and deadlines of connections always use the
+//system clock.
+func Now() time.Time {
+	mux.RLock()
+	defer mux.RUnlock()
+	return current.Now()
+}
+
+//Set replaces the clock in use by c and returns a function which restores the previous clock.
+//The clock is shared by all servers, caches and publishers of the process.
+func Set(c Clock) func() {
+	mux.Lock()
+	defer mux.Unlock()
+	previous := current
+	current = c
+	return func() {
+		mux.Lock()
+		defer mux.Unlock()
+		current = previous
+	}
+}
+
+//Fake is a clock which stands still until it is advanced. It is safe for concurrent use.
+type Fake struct {
+	mux sync.Mutex
+	now time.Time
+}
+
+//NewFake returns a Fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+//Now implements Clock
+func (f *Fake) Now() time.Time {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.now
+}
+
+//Advance moves the clock forward by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.now = f.now.Add(d)
+}
+
+//Shifted is the system clock shifted by an offset. Other than a Fake clock, it keeps running
+//such that timers relative to the current time still expire. It is safe for concurrent use.
+type Shifted struct {
+	mux    sync.Mutex
+	offset time.Duration
+}
+
+//Now implements Clock
+func (s *Shifted) Now() time.Time {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return time.Now().Add(s.offset)
+}
+
+//Advance moves the clock forward by d.
+func (s *Shifted) Advance(d time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.offset += d
+}
@@ -0,0 +1,44 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	fake := NewFake(start)
+	restore := Set(fake)
+	defer restore()
+	var tests = []struct {
+		advance  time.Duration
+		expected time.Time
+	}{
+		{0, start},
+		{time.Hour, start.Add(time.Hour)},
+		{24 * time.Hour, start.Add(25 * time.Hour)},
+	}
+	for i, test := range tests {
+		fake.Advance(test.advance)
+		if now := Now(); !now.Equal(test.expected) {
+			t.Errorf("%d: wrong time. expected=%v actual=%v", i, test.expected, now)
+		}
+	}
+	restore()
+	if now := Now(); now.Sub(time.Now()) > time.Second || time.Now().Sub(now) > time.Second {
+		t.Errorf("System clock was not restored: %v", now)
+	}
+}
+
+func TestShifted(t *testing.T) {
+	shifted := &Shifted{}
+	restore := Set(shifted)
+	defer restore()
+	shifted.Advance(48 * time.Hour)
+	before := time.Now().Add(48 * time.Hour)
+	now := Now()
+	after := time.Now().Add(48 * time.Hour)
+	if now.Before(before) || now.After(after) {
+		t.Errorf("wrong time. expected between %v and %v actual=%v", before, after, now)
+	}
+}
@@ -0,0 +1,55 @@
+package codec
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+)
+
+//cborMessageStart is the first byte of a CBOR encoded message, the start of its 4 byte tag.
+const cborMessageStart = 0xda
+
+//CBOR is the default codec which all peers support.
+type CBOR struct{}
+
+//Capability implements Codec.
+func (CBOR) Capability() message.Capability {
+	return CBORCapability
+}
+
+//IsFirstByte implements Codec.
+func (CBOR) IsFirstByte(b byte) bool {
+	return b == cborMessageStart
+}
+
+//Encode implements Codec.
+func (CBOR) Encode(w io.Writer, msg *message.Message) error {
+	encoder := cbor.NewEncoder(msg.WireSizeEstimate())
+	defer encoder.Release()
+	encoding, err := encoder.Encode(msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(encoding)
+	return err
+}
+
+//Decode implements Codec.
+func (CBOR) Decode(in *bufio.Reader, limits cbor.Limits) (*message.Message, error) {
+	frame, err := cbor.ReadFrame(in, limits)
+	if err != nil {
+		return nil, err
+	}
+	return message.Decode(frame)
+}
+
+//DecodeEnvelope implements Codec.
+func (CBOR) DecodeEnvelope(in *bufio.Reader, limits cbor.Limits) (*message.Envelope, error) {
+	frame, err := cbor.ReadFrame(in, limits)
+	if err != nil {
+		return nil, err
+	}
+	return message.DecodeEnvelope(frame)
+}
@@ -0,0 +1,129 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+)
+
+//ChecksumCapability is advertised by peers accepting messages in checksum frames.
+const ChecksumCapability message.Capability = "urn:x-rains:frame:crc32c"
+
+//A checksum frame is a CBOR byte string with a four byte length. It contains the encoding of a
+//message in any codec followed by the CRC-32C checksum of all preceding bytes of the frame,
+//including its head.
+const (
+	checksumFrameStart = 0x5a
+	checksumHeadSize   = 5
+	checksumSize       = 4
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+//ChecksumError is returned when the checksum of a frame does not match its content, i.e. when the
+//message has been corrupted in transport.
+type ChecksumError struct {
+	Expected uint32
+	Actual   uint32
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("corrupted frame: checksum is 0x%08x instead of 0x%08x", e.Actual,
+		e.Expected)
+}
+
+//WithChecksum returns a codec which encodes messages like c and puts each of them into a checksum
+//frame. It has the capability of c and must therefore not be registered, as it would replace c.
+func WithChecksum(c Codec) Codec {
+	return checksummed{c}
+}
+
+//checksummed frames the messages of a codec with a checksum.
+type checksummed struct {
+	Codec
+}
+
+//IsFirstByte implements Codec.
+func (checksummed) IsFirstByte(b byte) bool {
+	return b == checksumFrameStart
+}
+
+//Encode implements Codec.
+func (c checksummed) Encode(w io.Writer, msg *message.Message) error {
+	frame := bytes.NewBuffer(make([]byte, checksumHeadSize, checksumHeadSize+
+		msg.WireSizeEstimate()+checksumSize))
+	if err := c.Codec.Encode(frame, msg); err != nil {
+		return err
+	}
+	encoding := frame.Bytes()
+	encoding[0] = checksumFrameStart
+	binary.BigEndian.PutUint32(encoding[1:], uint32(len(encoding)-checksumHeadSize+checksumSize))
+	checksum := make([]byte, checksumSize)
+	binary.BigEndian.PutUint32(checksum, crc32.Checksum(encoding, castagnoli))
+	encoding = append(encoding, checksum...)
+	_, err := w.Write(encoding)
+	return err
+}
+
+//Decode implements Codec.
+func (c checksummed) Decode(in *bufio.Reader, limits cbor.Limits) (*message.Message, error) {
+	payload, err := readChecksumFrame(in, limits)
+	if err != nil {
+		return nil, err
+	}
+	return c.Codec.Decode(payload, limits)
+}
+
+//DecodeEnvelope implements Codec.
+func (c checksummed) DecodeEnvelope(in *bufio.Reader, limits cbor.Limits) (*message.Envelope,
+	error) {
+	payload, err := readChecksumFrame(in, limits)
+	if err != nil {
+		return nil, err
+	}
+	return c.Codec.DecodeEnvelope(payload, limits)
+}
+
+//readChecksumFrame reads the next checksum frame from in and returns a reader of the message it
+//contains. It returns a *ChecksumError if the checksum does not match. The frame is consumed
+//completely in this case such that the next message can be read, unless the corruption affected
+//the length of the frame.
+func readChecksumFrame(in *bufio.Reader, limits cbor.Limits) (*bufio.Reader, error) {
+	limits = limits.WithDefaults()
+	head := make([]byte, checksumHeadSize)
+	if _, err := io.ReadFull(in, head); err != nil {
+		return nil, err
+	}
+	if head[0] != checksumFrameStart {
+		return nil, fmt.Errorf("expected checksum frame but got first byte 0x%02x", head[0])
+	}
+	length := binary.BigEndian.Uint32(head[1:])
+	if length < checksumSize {
+		return nil, fmt.Errorf("checksum frame of %d bytes is too short", length)
+	}
+	if uint64(length)-checksumSize > uint64(limits.MaxSize) {
+		return nil, &cbor.LimitError{Limit: "MaxSize", Max: limits.MaxSize}
+	}
+	//The frame is copied incrementally such that a length announced by a malicious peer does not
+	//cause a large allocation before the data has actually been received.
+	frame := bytes.NewBuffer(head)
+	if _, err := io.CopyN(frame, in, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	encoding := frame.Bytes()
+	content, sum := encoding[:len(encoding)-checksumSize], encoding[len(encoding)-checksumSize:]
+	expected, actual := binary.BigEndian.Uint32(sum), crc32.Checksum(content, castagnoli)
+	if expected != actual {
+		return nil, &ChecksumError{Expected: expected, Actual: actual}
+	}
+	return bufio.NewReader(bytes.NewReader(content[checksumHeadSize:])), nil
+}
@@ -0,0 +1,161 @@
+//Package codec contains the wire formats in which messages can be exchanged and selects the one
+//to use on a connection based on the capabilities of the peer.
+//
+//All peers understand CBOR. A peer which supports further codecs lists their capabilities in the
+//order of its preference. As the first byte of a message identifies its codec, a reader accepts
+//messages in any registered codec regardless of what has been negotiated. Peers listing
+//ChecksumCapability additionally put each message into a frame ending with a checksum such that
+//corruption in transport is detected before the message is decoded.
+package codec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+)
+
+//Capabilities of the built-in codecs.
+const (
+	CBORCapability message.Capability = "urn:x-rains:codec:cbor"
+	JSONCapability message.Capability = "urn:x-rains:codec:json"
+)
+
+//Codec encodes and decodes messages in one wire format.
+type Codec interface {
+	//Capability is advertised by peers supporting this codec.
+	Capability() message.Capability
+	//IsFirstByte returns true if a message in this codec can start with b.
+	IsFirstByte(b byte) bool
+	//Encode writes the encoding of msg to w in a single call to w.Write.
+	Encode(w io.Writer, msg *message.Message) error
+	//Decode reads the next message from in. It returns a *cbor.LimitError if the message exceeds
+	//limits and io.EOF if in ended before the message started.
+	Decode(in *bufio.Reader, limits cbor.Limits) (*message.Message, error)
+	//DecodeEnvelope reads the next message from in like Decode but only decodes its envelope.
+	DecodeEnvelope(in *bufio.Reader, limits cbor.Limits) (*message.Envelope, error)
+}
+
+var registry = struct {
+	mux    sync.RWMutex
+	codecs []Codec
+}{}
+
+func init() {
+	Register(CBOR{})
+	Register(JSON{})
+}
+
+//Register adds c to the codecs used by Negotiate and Reader. It replaces a codec with the same
+//capability.
+func Register(c Codec) {
+	registry.mux.Lock()
+	defer registry.mux.Unlock()
+	for i, registered := range registry.codecs {
+		if registered.Capability() == c.Capability() {
+			registry.codecs[i] = c
+			return
+		}
+	}
+	registry.codecs = append(registry.codecs, c)
+}
+
+//ByCapability returns the registered codec with capability c.
+func ByCapability(c message.Capability) (Codec, bool) {
+	registry.mux.RLock()
+	defer registry.mux.RUnlock()
+	for _, codec := range registry.codecs {
+		if codec.Capability() == c {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+//Negotiate returns the first registered codec in own, which lists the capabilities of this peer
+//in order of preference, which is also supported by the peer with capabilities peer. It returns
+//CBOR if there is none. The codec puts messages into checksum frames if both peers list
+//ChecksumCapability.
+func Negotiate(own, peer []message.Capability) Codec {
+	supported := make(map[message.Capability]bool)
+	for _, c := range peer {
+		supported[c] = true
+	}
+	var codec Codec = CBOR{}
+	for _, c := range own {
+		if registered, ok := ByCapability(c); ok && supported[c] {
+			codec = registered
+			break
+		}
+	}
+	for _, c := range own {
+		if c == ChecksumCapability && supported[c] {
+			return WithChecksum(codec)
+		}
+	}
+	return codec
+}
+
+//Reader reads messages in any registered codec from a stream.
+type Reader struct {
+	in     *bufio.Reader
+	limits cbor.Limits
+}
+
+//NewReader returns a reader of the messages in in. Each message must stay within limits.
+func NewReader(in io.Reader, limits cbor.Limits) *Reader {
+	return &Reader{in: bufio.NewReader(in), limits: limits}
+}
+
+//Read returns the next message. It returns io.EOF if the stream ended before the message started.
+//The stream cannot be read further after a *cbor.LimitError or an error of the underlying reader.
+//A message in a checksum frame is only decoded if the checksum matches, otherwise a
+//*ChecksumError is returned.
+func (r *Reader) Read() (*message.Message, error) {
+	codec, in, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decode(in, r.limits)
+}
+
+//ReadEnvelope returns the envelope of the next message. The sections of the message are decoded
+//by calling Decode on the envelope. Errors are returned as by Read.
+func (r *Reader) ReadEnvelope() (*message.Envelope, error) {
+	codec, in, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	return codec.DecodeEnvelope(in, r.limits)
+}
+
+//next returns the codec of the next message in the stream and the reader from which it must be
+//decoded. A checksum frame is read and verified completely, the returned reader then contains the
+//message in the frame.
+func (r *Reader) next() (Codec, *bufio.Reader, error) {
+	in := r.in
+	first, err := in.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if first[0] == checksumFrameStart {
+		if in, err = readChecksumFrame(r.in, r.limits); err != nil {
+			return nil, nil, err
+		}
+		if first, err = in.Peek(1); err != nil {
+			return nil, nil, errors.New("checksum frame contains no message")
+		}
+	}
+	registry.mux.RLock()
+	defer registry.mux.RUnlock()
+	for _, c := range registry.codecs {
+		if c.IsFirstByte(first[0]) {
+			return c, in, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no codec for a message starting with 0x%02x", first[0])
+}
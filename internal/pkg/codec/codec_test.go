@@ -0,0 +1,222 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+func TestNegotiate(t *testing.T) {
+	tls := message.TLSOverTCP
+	var tests = []struct {
+		own      []message.Capability
+		peer     []message.Capability
+		expected message.Capability
+	}{
+		{nil, nil, CBORCapability},
+		{[]message.Capability{JSONCapability}, nil, CBORCapability},
+		{[]message.Capability{JSONCapability}, []message.Capability{tls}, CBORCapability},
+		{[]message.Capability{tls, JSONCapability}, []message.Capability{JSONCapability}, JSONCapability},
+		{[]message.Capability{CBORCapability, JSONCapability},
+			[]message.Capability{JSONCapability, CBORCapability}, CBORCapability},
+		{[]message.Capability{JSONCapability, CBORCapability},
+			[]message.Capability{CBORCapability, JSONCapability}, JSONCapability},
+		{[]message.Capability{"urn:x-rains:codec:unknown"},
+			[]message.Capability{"urn:x-rains:codec:unknown"}, CBORCapability},
+	}
+	for i, test := range tests {
+		if c := Negotiate(test.own, test.peer); c.Capability() != test.expected {
+			t.Errorf("%d: wrong codec. expected=%s actual=%s", i, test.expected, c.Capability())
+		}
+	}
+}
+
+func TestNegotiateChecksum(t *testing.T) {
+	var tests = []struct {
+		own      []message.Capability
+		peer     []message.Capability
+		checksum bool
+	}{
+		{[]message.Capability{JSONCapability}, []message.Capability{JSONCapability}, false},
+		{[]message.Capability{ChecksumCapability}, nil, false},
+		{nil, []message.Capability{ChecksumCapability}, false},
+		{[]message.Capability{ChecksumCapability}, []message.Capability{ChecksumCapability}, true},
+		{[]message.Capability{JSONCapability, ChecksumCapability},
+			[]message.Capability{ChecksumCapability, JSONCapability}, true},
+	}
+	for i, test := range tests {
+		c := Negotiate(test.own, test.peer)
+		if _, ok := c.(checksummed); ok != test.checksum {
+			t.Errorf("%d: wrong framing. expected checksum=%v actual=%T", i, test.checksum, c)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	var msgs []message.Message
+	for i := 0; i < 4; i++ {
+		msgs = append(msgs, message.Message{
+			Token:   token.New(),
+			Content: []section.Section{section.GetQuery(), section.GetNotification()},
+		})
+	}
+	codecs := []Codec{WithChecksum(CBOR{}), WithChecksum(JSON{}), CBOR{}, WithChecksum(CBOR{})}
+	var frames [][]byte
+	for i := range msgs {
+		frame := new(bytes.Buffer)
+		if err := codecs[i].Encode(frame, &msgs[i]); err != nil {
+			t.Fatalf("%d: Was not able to encode msg: %v", i, err)
+		}
+		frames = append(frames, frame.Bytes())
+	}
+	//The checksum covers the head of the frame, the message and its last byte.
+	for _, pos := range []int{2, 10, len(frames[0]) - 5} {
+		frames[0][pos] ^= 0x01
+		_, err := NewReader(bytes.NewReader(frames[0]), cbor.Limits{}).Read()
+		if _, ok := err.(*ChecksumError); !ok && pos != 2 {
+			t.Errorf("expected a checksum error after flipping byte %d but got %v", pos, err)
+		}
+		frames[0][pos] ^= 0x01
+	}
+	frames[3][20] ^= 0x80
+	reader := NewReader(bytes.NewReader(bytes.Join(frames, nil)), cbor.Limits{})
+	for i := range msgs {
+		msg, err := reader.Read()
+		if i == 3 {
+			if _, ok := err.(*ChecksumError); !ok {
+				t.Errorf("%d: expected a checksum error but got %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d: Was not able to read msg: %v", i, err)
+		}
+		if msg.Token != msgs[i].Token || len(msg.Content) != len(msgs[i].Content) {
+			t.Errorf("%d: wrong msg read. expected=%v actual=%v", i, msgs[i], msg)
+		}
+	}
+	if _, err := reader.Read(); err != io.EOF {
+		t.Errorf("expected EOF after the last msg but got %v", err)
+	}
+}
+
+func TestChecksumErrors(t *testing.T) {
+	var tests = []struct {
+		input  string
+		limits cbor.Limits
+		limit  bool
+	}{
+		{"\x5a\x00\x00", cbor.Limits{}, false},
+		{"\x5a\x00\x00\x00\x02ab", cbor.Limits{}, false},
+		{"\x5a\x00\x00\x00\x08abcd", cbor.Limits{}, false},
+		{"\x5a\x00\x00\x00\x04\x0a\x63\x97\x29", cbor.Limits{}, false},
+		{"\x5a\x00\x01\x00\x00", cbor.Limits{MaxSize: 1024}, true},
+	}
+	for i, test := range tests {
+		_, err := NewReader(strings.NewReader(test.input), test.limits).Read()
+		if err == nil {
+			t.Fatalf("%d: expected an error", i)
+		}
+		if _, ok := err.(*cbor.LimitError); ok != test.limit {
+			t.Errorf("%d: unexpected error %v", i, err)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	var msgs []message.Message
+	for i := 0; i < 4; i++ {
+		msgs = append(msgs, message.Message{
+			Token:   token.New(),
+			Content: []section.Section{section.GetQuery(), section.GetNotification()},
+		})
+	}
+	stream := new(bytes.Buffer)
+	for i := range msgs {
+		var c Codec = CBOR{}
+		if i%2 == 1 {
+			c = JSON{}
+		}
+		if err := c.Encode(stream, &msgs[i]); err != nil {
+			t.Fatalf("%d: Was not able to encode msg: %v", i, err)
+		}
+	}
+	reader := NewReader(stream, cbor.Limits{})
+	for i := range msgs {
+		msg, err := reader.Read()
+		if err != nil {
+			t.Fatalf("%d: Was not able to read msg: %v", i, err)
+		}
+		if msg.Token != msgs[i].Token || len(msg.Content) != len(msgs[i].Content) {
+			t.Errorf("%d: wrong msg read. expected=%v actual=%v", i, msgs[i], msg)
+		}
+	}
+	if _, err := reader.Read(); err != io.EOF {
+		t.Errorf("expected EOF after the last msg but got %v", err)
+	}
+}
+
+func TestReaderEnvelope(t *testing.T) {
+	msgs := []message.Message{
+		{Token: token.New(), Capabilities: []message.Capability{CBORCapability},
+			Content: []section.Section{section.GetQuery(), section.GetNotification()}},
+		{Token: token.New(), Content: []section.Section{section.GetNotification()}},
+	}
+	stream := new(bytes.Buffer)
+	CBOR{}.Encode(stream, &msgs[0])
+	JSON{}.Encode(stream, &msgs[1])
+	expectedTypes := [][]int{
+		{message.QueryType, message.NotificationType},
+		{message.NotificationType},
+	}
+	reader := NewReader(stream, cbor.Limits{})
+	for i := range msgs {
+		env, err := reader.ReadEnvelope()
+		if err != nil {
+			t.Fatalf("%d: Was not able to read envelope: %v", i, err)
+		}
+		if env.Token != msgs[i].Token || len(env.Capabilities) != len(msgs[i].Capabilities) ||
+			fmt.Sprint(env.SectionTypes) != fmt.Sprint(expectedTypes[i]) {
+			t.Errorf("%d: wrong envelope read. expected=%v actual=%v", i, msgs[i], env)
+		}
+		msg, err := env.Decode()
+		if err != nil || len(msg.Content) != len(msgs[i].Content) {
+			t.Errorf("%d: Was not able to decode envelope: %v %v", i, msg, err)
+		}
+	}
+	if _, err := reader.ReadEnvelope(); err != io.EOF {
+		t.Errorf("expected EOF after the last msg but got %v", err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	msg := message.Message{Token: token.New(), Content: []section.Section{section.GetNotification()}}
+	encoding := new(bytes.Buffer)
+	JSON{}.Encode(encoding, &msg)
+	var tests = []struct {
+		input  string
+		limits cbor.Limits
+		limit  bool
+	}{
+		{"x", cbor.Limits{}, false},
+		{"{\"token\": 1}\n", cbor.Limits{}, false},
+		{strings.TrimSuffix(encoding.String(), "\n"), cbor.Limits{}, false},
+		{encoding.String(), cbor.Limits{MaxSize: 32}, true},
+	}
+	for i, test := range tests {
+		_, err := NewReader(strings.NewReader(test.input), test.limits).Read()
+		if err == nil {
+			t.Fatalf("%d: expected an error", i)
+		}
+		if _, ok := err.(*cbor.LimitError); ok != test.limit {
+			t.Errorf("%d: unexpected error %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+)
+
+//JSON encodes messages in their JSON representation, one message per line. It is meant for
+//debugging and for peers without a CBOR implementation.
+type JSON struct{}
+
+//Capability implements Codec.
+func (JSON) Capability() message.Capability {
+	return JSONCapability
+}
+
+//IsFirstByte implements Codec.
+func (JSON) IsFirstByte(b byte) bool {
+	return b == '{'
+}
+
+//Encode implements Codec.
+func (JSON) Encode(w io.Writer, msg *message.Message) error {
+	encoding, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(encoding, '\n'))
+	return err
+}
+
+//Decode implements Codec. The line containing the message is read completely even if it is
+//malformed such that the next message can be read.
+func (JSON) Decode(in *bufio.Reader, limits cbor.Limits) (*message.Message, error) {
+	line, err := readLine(in, limits)
+	if err != nil {
+		return nil, err
+	}
+	msg := &message.Message{}
+	if err := json.Unmarshal(line, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+//DecodeEnvelope implements Codec. The message is converted to CBOR first.
+func (JSON) DecodeEnvelope(in *bufio.Reader, limits cbor.Limits) (*message.Envelope, error) {
+	line, err := readLine(in, limits)
+	if err != nil {
+		return nil, err
+	}
+	encoding, err := message.JSONToCBOR(line)
+	if err != nil {
+		return nil, err
+	}
+	return message.DecodeEnvelope(encoding)
+}
+
+//readLine reads the next line from in. It returns a *cbor.LimitError if the line is longer than
+//limits.MaxSize.
+func readLine(in *bufio.Reader, limits cbor.Limits) ([]byte, error) {
+	limits = limits.WithDefaults()
+	line := new(bytes.Buffer)
+	for {
+		chunk, err := in.ReadSlice('\n')
+		if line.Len()+len(chunk) > limits.MaxSize {
+			return nil, &cbor.LimitError{Limit: "MaxSize", Max: limits.MaxSize}
+		}
+		line.Write(chunk)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && line.Len() > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		return line.Bytes(), nil
+	}
+}
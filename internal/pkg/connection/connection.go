@@ -1,15 +1,18 @@
 package connection
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"reflect"
 	"time"
 
 	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/token"
@@ -44,6 +47,9 @@ func UnmarshalNetAddr(data []byte) (Type, net.Addr, error) {
 	case "Chan":
 		value = reflect.New(reflect.TypeOf(ChannelAddr{})).Interface()
 		t = TCP
+	case "Mem":
+		value = reflect.New(reflect.TypeOf(MemAddr{})).Interface()
+		t = Mem
 	default:
 		return -1, nil, errors.New("Unknown Addr type")
 	}
@@ -68,6 +74,7 @@ type Type int
 const (
 	Chan Type = iota
 	TCP
+	Mem
 )
 
 type Message struct {
@@ -104,8 +111,9 @@ func (c *Channel) Read(b []byte) (n int, err error) {
 }
 
 func (c *Channel) Write(b []byte) (n int, err error) {
+	//b must not be retained as the caller may reuse it after Write returns.
 	c.RemoteChan <- Message{
-		Msg: b,
+		Msg: append([]byte(nil), b...),
 		Sender: &Channel{
 			remoteAddr: c.LocalAddr().(ChannelAddr),
 			RemoteChan: c.LocalChan,
@@ -141,19 +149,71 @@ func (c *Channel) SetWriteDeadline(t time.Time) error {
 
 //CreateConnection returns a newly created connection with connInfo or an error
 func CreateConnection(addr net.Addr) (conn net.Conn, err error) {
+	return CreateTLSConnection(addr, nil)
+}
+
+//CreateTLSConnection returns a newly created connection to addr using config for the TLS handshake
+//or an error. If config is nil, the server's certificate is not verified.
+func CreateTLSConnection(addr net.Addr, config *tls.Config) (conn net.Conn, err error) {
+	return CreateTLSConnectionContext(context.Background(), addr, config)
+}
+
+//CreateTLSConnectionContext works like CreateTLSConnection but aborts dialing and the TLS handshake
+//as soon as ctx is done. Besides TCP, it supports TLS over Unix sockets and unencrypted in-memory
+//connections.
+func CreateTLSConnectionContext(ctx context.Context, addr net.Addr, config *tls.Config) (
+	conn net.Conn, err error) {
+	if config == nil {
+		config = &tls.Config{InsecureSkipVerify: true}
+	}
 	switch addr.(type) {
-	case *net.TCPAddr:
-		return tls.Dial(addr.Network(), addr.String(), &tls.Config{InsecureSkipVerify: true})
+	case *net.TCPAddr, *net.UnixAddr:
+		return dialTLS(ctx, addr, config)
+	case *MemAddr:
+		return DialMem(ctx, addr)
 	default:
 		return nil, errors.New("unsupported Network address type")
 	}
 }
 
+//dialTLS connects to addr and performs the TLS handshake with config. If config does not set a
+//ServerName, it is taken from addr. It aborts as soon as ctx is done.
+func dialTLS(ctx context.Context, addr net.Addr, config *tls.Config) (net.Conn, error) {
+	raw, err := new(net.Dialer).DialContext(ctx, addr.Network(), addr.String())
+	if err != nil {
+		return nil, err
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			config.ServerName = host
+		} else {
+			config.ServerName = addr.String()
+		}
+	}
+	conn := tls.Client(raw, config)
+	handshake := make(chan error, 1)
+	go func() {
+		handshake <- conn.Handshake()
+	}()
+	select {
+	case err = <-handshake:
+	case <-ctx.Done():
+		raw.Close()
+		<-handshake
+		err = ctx.Err()
+	}
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
 func Listen(conn net.Conn, tok token.Token, done chan<- message.Message, ec chan<- error) {
-	reader := cbor.NewReader(conn)
-	var msg message.Message
-	if err := reader.Unmarshal(&msg); err != nil {
-		if err.Error() == "failed to read tag: EOF" {
+	msg, err := codec.NewReader(conn, cbor.Limits{}).Read()
+	if err != nil {
+		if err == io.EOF {
 			ec <- fmt.Errorf("connection has been closed")
 		} else {
 			ec <- fmt.Errorf("failed to unmarshal response: %v", err)
@@ -166,5 +226,5 @@ func Listen(conn net.Conn, tok token.Token, done chan<- message.Message, ec chan
 			return
 		}
 	}
-	done <- msg
+	done <- *msg
 }
@@ -0,0 +1,166 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+//memBacklog is the number of in-memory connections which are queued until the listener accepts
+//them. Further dials block.
+const memBacklog = 128
+
+//ErrListenerClosed is the error returned by Accept of an in-memory listener which has been closed.
+var ErrListenerClosed = errors.New("use of closed in-memory listener")
+
+//memListeners contains all open in-memory listeners of the process by the ID of their address.
+var memListeners = struct {
+	sync.Mutex
+	byID map[string]*memListener
+	//next is used to choose unique IDs for listeners without an ID and for dialing clients.
+	next int
+}{byID: make(map[string]*memListener)}
+
+//MemAddr is the address of an in-memory listener. In-memory connections connect rainsd servers,
+//publishers and resolvers running in the same process without sockets, e.g. to simulate large
+//topologies in tests. They are not encrypted.
+type MemAddr struct {
+	ID string
+}
+
+//Network returns mem
+func (a *MemAddr) Network() string {
+	return "mem"
+}
+
+//String returns the address' id
+func (a *MemAddr) String() string {
+	return a.ID
+}
+
+//MemDialer connects to in-memory listeners. It can be used as the dialer of a resolver or a
+//publisher.
+type MemDialer struct{}
+
+//DialContext calls DialMem(ctx, addr).
+func (MemDialer) DialContext(ctx context.Context, addr net.Addr) (net.Conn, error) {
+	return DialMem(ctx, addr)
+}
+
+//ListenMem returns a listener accepting in-memory connections to addr. If addr has no ID, a unique
+//one is chosen. It returns an error if another listener uses the ID.
+func ListenMem(addr *MemAddr) (net.Listener, error) {
+	memListeners.Lock()
+	defer memListeners.Unlock()
+	id := addr.ID
+	if id == "" {
+		id = nextMemID()
+	}
+	if _, ok := memListeners.byID[id]; ok {
+		return nil, &net.OpError{Op: "listen", Net: "mem", Addr: addr,
+			Err: errors.New("address already in use")}
+	}
+	l := &memListener{
+		addr:   &MemAddr{ID: id},
+		conns:  make(chan net.Conn, memBacklog),
+		closed: make(chan struct{}),
+	}
+	memListeners.byID[id] = l
+	return l, nil
+}
+
+//DialMem connects to the in-memory listener whose ID is addr.String(). As the ID of a listener can
+//be chosen freely, a listener with an ID of the form ip:port is reachable under the *net.TCPAddr
+//of a delegation, such that resolvers can follow delegations to in-memory servers.
+func DialMem(ctx context.Context, addr net.Addr) (net.Conn, error) {
+	memListeners.Lock()
+	l, ok := memListeners.byID[addr.String()]
+	local := &MemAddr{ID: nextMemID()}
+	memListeners.Unlock()
+	if !ok {
+		return nil, &net.OpError{Op: "dial", Net: "mem", Addr: addr,
+			Err: errors.New("connection refused")}
+	}
+	client, server := net.Pipe()
+	var err error
+	select {
+	case l.conns <- &memConn{Conn: server, local: l.addr, remote: local}:
+		return &memConn{Conn: client, local: local, remote: l.addr}, nil
+	case <-l.closed:
+		err = &net.OpError{Op: "dial", Net: "mem", Addr: addr, Err: errors.New("connection refused")}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	client.Close()
+	server.Close()
+	return nil, err
+}
+
+//nextMemID returns a new unique ID. memListeners must be locked.
+func nextMemID() string {
+	memListeners.next++
+	return fmt.Sprintf("mem-%d", memListeners.next)
+}
+
+//memListener is a net.Listener for in-memory connections.
+type memListener struct {
+	addr   *MemAddr
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+//Accept waits for and returns the next connection to the listener.
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, &net.OpError{Op: "accept", Net: "mem", Addr: l.addr, Err: ErrListenerClosed}
+	}
+}
+
+//Close stops the listener. Connections which have not been accepted yet are closed while
+//established connections stay open.
+func (l *memListener) Close() error {
+	l.once.Do(func() {
+		memListeners.Lock()
+		delete(memListeners.byID, l.addr.ID)
+		memListeners.Unlock()
+		close(l.closed)
+		for {
+			select {
+			case conn := <-l.conns:
+				conn.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+//Addr returns the listener's address.
+func (l *memListener) Addr() net.Addr {
+	return l.addr
+}
+
+//memConn is one end of an in-memory connection. Writes block until the other end has read the
+//data, such that both ends must read concurrently to writing.
+type memConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+//LocalAddr returns the address of this end of the connection.
+func (c *memConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+//RemoteAddr returns the address of the other end of the connection.
+func (c *memConn) RemoteAddr() net.Addr {
+	return c.remote
+}
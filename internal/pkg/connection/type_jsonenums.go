@@ -11,11 +11,13 @@ var (
 	_TypeNameToValue = map[string]Type{
 		"Chan": Chan,
 		"TCP":  TCP,
+		"Mem":  Mem,
 	}
 
 	_TypeValueToName = map[Type]string{
 		Chan: "Chan",
 		TCP:  "TCP",
+		Mem:  "Mem",
 	}
 )
 
@@ -25,6 +27,7 @@ func init() {
 		_TypeNameToValue = map[string]Type{
 			interface{}(Chan).(fmt.Stringer).String(): Chan,
 			interface{}(TCP).(fmt.Stringer).String():  TCP,
+			interface{}(Mem).(fmt.Stringer).String():  Mem,
 		}
 	}
 }
@@ -67,6 +67,13 @@ func (m *Counter) Info() (int, int) {
 	return m.count, m.maxCount
 }
 
+//SetMaxCount changes the count at which the counter is full to maxCount.
+func (m *Counter) SetMaxCount(maxCount int) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.maxCount = maxCount
+}
+
 //IsFull returns true if count is larger or equal to maxCount.
 func (m *Counter) IsFull() bool {
 	return m.count >= m.maxCount
@@ -172,6 +172,19 @@ func TestIsFull(t *testing.T) {
 	}
 }
 
+func TestSetMaxCount(t *testing.T) {
+	counter := New(5)
+	counter.count = 3
+	counter.SetMaxCount(2)
+	if !counter.IsFull() || counter.maxCount != 2 {
+		t.Errorf("counter is not full after shrinking. %v", counter)
+	}
+	counter.SetMaxCount(4)
+	if counter.IsFull() || counter.maxCount != 4 {
+		t.Errorf("counter is full after growing. %v", counter)
+	}
+}
+
 func TestString(t *testing.T) {
 	counter := New(5)
 	if counter.String() != "0/5" {
@@ -0,0 +1,201 @@
+package keyManager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+const (
+	//requestSuffix is the suffix of the file containing a delegation request.
+	requestSuffix = "_req.pem"
+
+	delegationRequestType = "RAINS DELEGATION REQUEST"
+
+	//maxRequestAge is the time after its creation until which a delegation request is accepted.
+	maxRequestAge = 7 * 24 * time.Hour
+	//maxClockSkew is how far in the future the creation time of a request may lie.
+	maxClockSkew = 5 * time.Minute
+)
+
+//DelegationRequest is submitted by a child zone to its parent zone's operator to obtain a
+//delegation. It is signed with the child zone's private key to prove possession of it.
+type DelegationRequest struct {
+	//Zone is the fully qualified name of the child zone.
+	Zone      string
+	PublicKey keys.PublicKey
+	//Redirections are the names of the child zone's authoritative servers.
+	Redirections []string
+	//Created is the unix timestamp in seconds at which the request was created.
+	Created   int64
+	Signature []byte
+}
+
+//signedData returns the data over which the request's signature is computed. Each field is
+//prefixed with its length such that no two distinct requests have the same encoding.
+func (r *DelegationRequest) signedData() []byte {
+	data := new(bytes.Buffer)
+	field := func(value []byte) {
+		binary.Write(data, binary.BigEndian, uint32(len(value)))
+		data.Write(value)
+	}
+	field([]byte(delegationRequestType))
+	field([]byte(r.Zone))
+	binary.Write(data, binary.BigEndian, int64(r.PublicKey.Algorithm))
+	binary.Write(data, binary.BigEndian, int64(r.PublicKey.KeyPhase))
+	field(r.PublicKey.Key.(ed25519.PublicKey))
+	binary.Write(data, binary.BigEndian, uint32(len(r.Redirections)))
+	for _, name := range r.Redirections {
+		field([]byte(name))
+	}
+	binary.Write(data, binary.BigEndian, r.Created)
+	return data.Bytes()
+}
+
+//Verify checks that the request is signed by the private key corresponding to the contained public
+//key and that it has been created within the last maxRequestAge.
+func (r *DelegationRequest) Verify() error {
+	publicKey, ok := r.PublicKey.Key.(ed25519.PublicKey)
+	if !ok || len(publicKey) != ed25519.PublicKeySize {
+		return errors.New("delegation request contains an unsupported public key")
+	}
+	if !ed25519.Verify(publicKey, r.signedData(), r.Signature) {
+		return errors.New("signature of delegation request is invalid")
+	}
+	created := time.Unix(r.Created, 0)
+	if age := time.Since(created); age > maxRequestAge {
+		return fmt.Errorf("delegation request has expired, it was created %s ago", age)
+	} else if age < -maxClockSkew {
+		return fmt.Errorf("creation time of delegation request lies in the future: %s", created)
+	}
+	return nil
+}
+
+//Assertions returns the delegation assertion and, if redirections are present, the redirection
+//assertion for the child zone as they must be added to the zone identified by parent.
+func (r *DelegationRequest) Assertions(parent string) ([]*section.Assertion, error) {
+	subjectName, zone, err := splitZone(r.Zone)
+	if err != nil {
+		return nil, err
+	}
+	if zone != parent {
+		return nil, fmt.Errorf("%s is not a child zone of %s", r.Zone, parent)
+	}
+	output := []*section.Assertion{&section.Assertion{
+		SubjectName: subjectName,
+		SubjectZone: parent,
+		Context:     ".",
+		Content:     []object.Object{object.Object{Type: object.OTDelegation, Value: r.PublicKey}},
+	}}
+	if len(r.Redirections) != 0 {
+		redir := &section.Assertion{SubjectName: subjectName, SubjectZone: parent, Context: "."}
+		for _, name := range r.Redirections {
+			redir.Content = append(redir.Content, object.Object{Type: object.OTRedirection, Value: name})
+		}
+		output = append(output, redir)
+	}
+	return output, nil
+}
+
+//CreateDelegationRequest loads the private key stored at keyPath/name, decrypts it with pwd and
+//returns a pem encoded delegation request for zone signed with it. The request is also stored at
+//keyPath/name_req.pem.
+func CreateDelegationRequest(keyPath, name, zone, pwd string, redirections []string) (string,
+	error) {
+	data, err := ioutil.ReadFile(path.Join(keyPath, name+secSuffix))
+	if err != nil {
+		return "", err
+	}
+	privateKeys, err := DecryptPrivateKeys(data, pwd)
+	if err != nil {
+		return "", err
+	}
+	privateKey, ok := privateKeys[0].Key.(ed25519.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("unsupported private key type: %T", privateKeys[0].Key)
+	}
+	if !strings.HasSuffix(zone, ".") {
+		zone += "."
+	}
+	if _, _, err := splitZone(zone); err != nil {
+		return "", err
+	}
+	request := &DelegationRequest{
+		Zone: zone,
+		PublicKey: keys.PublicKey{
+			PublicKeyID: privateKeys[0].PublicKeyID,
+			Key:         privateKey.Public().(ed25519.PublicKey),
+		},
+		Redirections: redirections,
+		Created:      time.Now().Unix(),
+	}
+	request.Signature = ed25519.Sign(privateKey, request.signedData())
+	encoding := pem.EncodeToMemory(&pem.Block{
+		Type: delegationRequestType,
+		Headers: map[string]string{
+			"zone":         request.Zone,
+			"keyAlgo":      "ed25519",
+			"keyPhase":     strconv.Itoa(request.PublicKey.KeyPhase),
+			"redirections": strings.Join(request.Redirections, ","),
+			"created":      strconv.FormatInt(request.Created, 10),
+			"signature":    hex.EncodeToString(request.Signature),
+		},
+		Bytes: request.PublicKey.Key.(ed25519.PublicKey),
+	})
+	if err := ioutil.WriteFile(path.Join(keyPath, name+requestSuffix), encoding, 0644); err != nil {
+		return "", err
+	}
+	return string(encoding), nil
+}
+
+//LoadDelegationRequest reads the pem encoded delegation request stored at path and verifies its
+//signature.
+func LoadDelegationRequest(path string) (*DelegationRequest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != delegationRequestType {
+		return nil, errors.New("Was not able to decode pem encoded delegation request")
+	}
+	id, err := publicKeyID(block.Headers)
+	if err != nil {
+		return nil, err
+	}
+	if id.Algorithm != algorithmTypes.Ed25519 || len(block.Bytes) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("unsupported public key: %s", block.Headers["keyAlgo"])
+	}
+	request := &DelegationRequest{
+		Zone:      block.Headers["zone"],
+		PublicKey: keys.PublicKey{PublicKeyID: id, Key: ed25519.PublicKey(block.Bytes)},
+	}
+	if block.Headers["redirections"] != "" {
+		request.Redirections = strings.Split(block.Headers["redirections"], ",")
+	}
+	if request.Created, err = strconv.ParseInt(block.Headers["created"], 10, 64); err != nil {
+		return nil, fmt.Errorf("Was not able to parse creation time: %v", err)
+	}
+	if request.Signature, err = hex.DecodeString(block.Headers["signature"]); err != nil {
+		return nil, fmt.Errorf("Was not able to decode signature: %v", err)
+	}
+	if err := request.Verify(); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
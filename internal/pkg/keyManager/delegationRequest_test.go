@@ -0,0 +1,84 @@
+package keyManager
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+)
+
+func TestDelegationRequestVerify(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+	now := time.Now()
+	var tests = []struct {
+		modify func(r *DelegationRequest)
+		valid  bool
+	}{
+		{func(r *DelegationRequest) {}, true},
+		{func(r *DelegationRequest) { r.Zone = "eth.ch." }, false},
+		{func(r *DelegationRequest) { r.PublicKey.KeyPhase = 2 }, false},
+		{func(r *DelegationRequest) { r.PublicKey.Key = otherKey }, false},
+		{func(r *DelegationRequest) { r.Redirections = []string{"ns.ethz.ch.", "ns2.ethz.ch."} }, false},
+		//Moving the separator between redirections must invalidate the signature.
+		{func(r *DelegationRequest) { r.Redirections = []string{"ns.ethz.ch.,ns1.ethz.ch."} }, false},
+		{func(r *DelegationRequest) { r.Created++ }, false},
+		{func(r *DelegationRequest) { r.Signature[0] ^= 1 }, false},
+		{func(r *DelegationRequest) { r.Created = now.Add(-8 * 24 * time.Hour).Unix() }, false},
+		{func(r *DelegationRequest) { r.Created = now.Add(time.Hour).Unix() }, false},
+		{func(r *DelegationRequest) { r.Created = now.Add(-6 * 24 * time.Hour).Unix() }, true},
+	}
+	for i, test := range tests {
+		r := &DelegationRequest{
+			Zone: "ethz.ch.",
+			PublicKey: keys.PublicKey{
+				PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519, KeyPhase: 1},
+				Key:         publicKey,
+			},
+			Redirections: []string{"ns.ethz.ch.", "ns1.ethz.ch."},
+			Created:      now.Unix(),
+		}
+		r.Signature = ed25519.Sign(privateKey, r.signedData())
+		test.modify(r)
+		if test.valid {
+			//Changes of valid test cases are signed again.
+			r.Signature = ed25519.Sign(privateKey, r.signedData())
+		}
+		if err := r.Verify(); (err == nil) != test.valid {
+			t.Errorf("%d: wrong verification result. expected valid=%t actual=%v", i, test.valid,
+				err)
+		}
+	}
+}
+
+func TestDelegationRequestEncoding(t *testing.T) {
+	publicKey, _, _ := ed25519.GenerateKey(nil)
+	request := func(redirections ...string) *DelegationRequest {
+		return &DelegationRequest{
+			Zone: "ethz.ch.",
+			PublicKey: keys.PublicKey{
+				PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519},
+				Key:         publicKey,
+			},
+			Redirections: redirections,
+		}
+	}
+	var tests = []struct {
+		a, b *DelegationRequest
+	}{
+		{request("a,b"), request("a", "b")},
+		{request("a b"), request("a", "b")},
+		{request(""), request()},
+		{&DelegationRequest{Zone: "ethz.ch. 1", PublicKey: request().PublicKey},
+			&DelegationRequest{Zone: "ethz.ch.", PublicKey: request().PublicKey, Created: 1}},
+	}
+	for i, test := range tests {
+		if bytes.Equal(test.a.signedData(), test.b.signedData()) {
+			t.Errorf("%d: distinct requests have the same encoding", i)
+		}
+	}
+}
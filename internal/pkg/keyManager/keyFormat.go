@@ -0,0 +1,229 @@
+package keyManager
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	//FormatPEM is the PKIX (public key) and PKCS #8 (private key) pem encoding.
+	FormatPEM = "pem"
+	//FormatJWK is the json web key encoding as defined in RFC 8037.
+	FormatJWK = "jwk"
+	//FormatHex is a hex encoded ed25519 private key as used by earlier versions of the publisher.
+	FormatHex = "hex"
+)
+
+//jwk is a json web key for an octet key pair as defined in RFC 8037.
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	D   string `json:"d,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+}
+
+//oidEd25519 identifies the ed25519 signature algorithm in PKIX and PKCS #8 encodings, see RFC 8410.
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+//pkcs8 is the PKCS #8 encoding of a private key.
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+//pkixPublicKey is the PKIX encoding of a public key.
+type pkixPublicKey struct {
+	Algo      pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+//marshalPKCS8PrivateKey returns the PKCS #8 der encoding of privateKey as defined in RFC 8410.
+func marshalPKCS8PrivateKey(privateKey ed25519.PrivateKey) ([]byte, error) {
+	seed, err := asn1.Marshal(privateKey.Seed())
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs8{Algo: pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+		PrivateKey: seed})
+}
+
+//marshalPKIXPublicKey returns the PKIX der encoding of publicKey as defined in RFC 8410.
+func marshalPKIXPublicKey(publicKey ed25519.PublicKey) ([]byte, error) {
+	return asn1.Marshal(pkixPublicKey{Algo: pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+		PublicKey: asn1.BitString{Bytes: publicKey, BitLength: 8 * len(publicKey)}})
+}
+
+//parsePKCS8PrivateKey returns the ed25519 private key of the PKCS #8 der encoding der.
+func parsePKCS8PrivateKey(der []byte) (ed25519.PrivateKey, error) {
+	var key pkcs8
+	if rest, err := asn1.Unmarshal(der, &key); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("trailing data after PKCS #8 private key")
+	}
+	if !key.Algo.Algorithm.Equal(oidEd25519) {
+		return nil, fmt.Errorf("unsupported private key algorithm: %v", key.Algo.Algorithm)
+	}
+	var seed []byte
+	if _, err := asn1.Unmarshal(key.PrivateKey, &seed); err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("incorrect private key length")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+//ExportKey returns the key pair stored at keyPath/name in the given format. If private is true,
+//the private key is decrypted with pwd and exported. Otherwise, only the public key is exported.
+func ExportKey(keyPath, name, pwd, format string, private bool) (string, error) {
+	publicKey, privateKey, err := loadKeyPair(keyPath, name, pwd, private)
+	if err != nil {
+		return "", err
+	}
+	switch format {
+	case FormatPEM:
+		if private {
+			der, err := marshalPKCS8PrivateKey(privateKey)
+			if err != nil {
+				return "", err
+			}
+			return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+		}
+		der, err := marshalPKIXPublicKey(publicKey)
+		if err != nil {
+			return "", err
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+	case FormatJWK:
+		key := jwk{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(publicKey),
+			Kid: name,
+			Use: "sig",
+			Alg: "EdDSA",
+		}
+		if private {
+			key.D = base64.RawURLEncoding.EncodeToString(privateKey.Seed())
+		}
+		encoding, err := json.MarshalIndent(key, "", "    ")
+		if err != nil {
+			return "", err
+		}
+		return string(encoding) + "\n", nil
+	case FormatHex:
+		if !private {
+			return hex.EncodeToString(publicKey) + "\n", nil
+		}
+		return hex.EncodeToString(privateKey) + "\n", nil
+	default:
+		return "", fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+//ImportKey reads the ed25519 private key stored in the given format at inPath and stores it
+//together with the corresponding public key at keyPath/name in the keyManager's pem format. The
+//private key is encrypted with pwd.
+func ImportKey(inPath, format, keyPath, name, description, pwd string, phase int) error {
+	data, err := ioutil.ReadFile(inPath)
+	if err != nil {
+		return err
+	}
+	var privateKey ed25519.PrivateKey
+	switch format {
+	case FormatPEM:
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "PRIVATE KEY" {
+			return errors.New("input does not contain a pem encoded PKCS #8 private key")
+		}
+		if privateKey, err = parsePKCS8PrivateKey(block.Bytes); err != nil {
+			return err
+		}
+	case FormatJWK:
+		var key jwk
+		if err := json.Unmarshal(data, &key); err != nil {
+			return err
+		}
+		if key.Kty != "OKP" || key.Crv != "Ed25519" {
+			return fmt.Errorf("unsupported json web key: kty=%s crv=%s", key.Kty, key.Crv)
+		}
+		if key.D == "" {
+			return errors.New("json web key does not contain a private key")
+		}
+		seed, err := base64.RawURLEncoding.DecodeString(key.D)
+		if err != nil {
+			return err
+		}
+		if len(seed) != ed25519.SeedSize {
+			return errors.New("incorrect private key length")
+		}
+		privateKey = ed25519.NewKeyFromSeed(seed)
+	case FormatHex:
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return err
+		}
+		switch len(key) {
+		case ed25519.PrivateKeySize:
+			privateKey = ed25519.PrivateKey(key)
+		case ed25519.SeedSize:
+			privateKey = ed25519.NewKeyFromSeed(key)
+		default:
+			return errors.New("incorrect private key length")
+		}
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	return storeKeyPair(keyPath, name, description, "ed25519", pwd, phase, publicKey, privateKey)
+}
+
+//loadKeyPair returns the public key stored at keyPath/name. If private is true, it also decrypts
+//the corresponding private key with pwd and returns it.
+func loadKeyPair(keyPath, name, pwd string, private bool) (ed25519.PublicKey, ed25519.PrivateKey,
+	error) {
+	data, err := ioutil.ReadFile(path.Join(keyPath, name+pubSuffix))
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != publicKeyType {
+		return nil, nil, errors.New("Was not able to decode pem encoded public key")
+	}
+	if block.Headers["keyAlgo"] != "ed25519" || len(block.Bytes) != ed25519.PublicKeySize {
+		return nil, nil, fmt.Errorf("unsupported public key: %s", block.Headers["keyAlgo"])
+	}
+	publicKey := ed25519.PublicKey(block.Bytes)
+	if !private {
+		return publicKey, nil, nil
+	}
+	data, err = ioutil.ReadFile(path.Join(keyPath, name+secSuffix))
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKeys, err := DecryptPrivateKeys(data, pwd)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKey, ok := privateKeys[0].Key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported private key type: %T", privateKeys[0].Key)
+	}
+	return publicKey, privateKey, nil
+}
@@ -0,0 +1,70 @@
+package keyManager
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestExportImportKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyManager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := GenerateKey(dir, "ethz", "ethz.ch", "ed25519", "secret", 1); err != nil {
+		t.Fatalf("Was not able to generate key: %v", err)
+	}
+	for _, format := range []string{FormatPEM, FormatJWK, FormatHex} {
+		public, err := ExportKey(dir, "ethz", "", format, false)
+		if err != nil {
+			t.Errorf("%s: was not able to export public key: %v", format, err)
+			continue
+		}
+		private, err := ExportKey(dir, "ethz", "secret", format, true)
+		if err != nil {
+			t.Errorf("%s: was not able to export private key: %v", format, err)
+			continue
+		}
+		if _, err := ExportKey(dir, "ethz", "wrong", format, true); err == nil {
+			t.Errorf("%s: private key was exported with a wrong passphrase", format)
+		}
+		inPath := path.Join(dir, "export."+format)
+		if err := ioutil.WriteFile(inPath, []byte(private), 0600); err != nil {
+			t.Fatal(err)
+		}
+		//The imported key pair is encrypted with its own passphrase and exports identically.
+		name := "imported-" + format
+		if err := ImportKey(inPath, format, dir, name, "imported", "other", 1); err != nil {
+			t.Errorf("%s: was not able to import private key: %v", format, err)
+			continue
+		}
+		if _, err := ExportKey(dir, name, "secret", format, true); err == nil {
+			t.Errorf("%s: imported key was exported with the original passphrase", format)
+		}
+		//The key id of a json web key is the name of the key pair.
+		kid := strings.NewReplacer(`"kid": "ethz"`, `"kid": "`+name+`"`)
+		imported, err := ExportKey(dir, name, "other", format, true)
+		if err != nil || imported != kid.Replace(private) {
+			t.Errorf("%s: imported private key differs. expected=%s actual=%s error=%v", format,
+				private, imported, err)
+		}
+		imported, err = ExportKey(dir, name, "", format, false)
+		if err != nil || imported != kid.Replace(public) {
+			t.Errorf("%s: imported public key differs. expected=%s actual=%s error=%v", format,
+				public, imported, err)
+		}
+		//A hex encoded public key cannot be distinguished from a private key's seed.
+		if format == FormatHex {
+			continue
+		}
+		if err := ioutil.WriteFile(inPath, []byte(public), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ImportKey(inPath, format, dir, "public-"+format, "", "other", 1); err == nil {
+			t.Errorf("%s: public key was imported as private key", format)
+		}
+	}
+}
@@ -1,29 +1,62 @@
 package keyManager
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 
 	log "github.com/inconshreveable/log15"
 	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/audit"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
 )
 
 const (
 	pubSuffix = "_pub.pem"
 	secSuffix = "_sec.pem"
+	//delegSuffix is the suffix of the file containing the delegation assertion in zonefile format.
+	delegSuffix = "_deleg.txt"
+
+	publicKeyType  = "RAINS PUBLIC KEY"
+	privateKeyType = "RAINS ENCRYPTED PRIVATE KEY"
+
+	kdfScrypt    = "scrypt"
+	cipherAESGCM = "aes-256-gcm"
+
+	saltSize = 16
+	keySize  = 32
+	scryptN  = 1 << 15
+	scryptR  = 8
+	scryptP  = 1
+
+	//PassphraseEnv is the environment variable from which the passphrase of encrypted private keys
+	//is read if it is set.
+	PassphraseEnv = "RAINSPUB_KEY_PASSPHRASE"
 )
 
+//errEmptyPassphrase is returned when a private key would be encrypted with an empty passphrase.
+var errEmptyPassphrase = errors.New("passphrase of private keys must not be empty")
+
 //LoadPublicKeys prints all public keys stored in the directory at keypath in pem format.
 func LoadPublicKeys(keyPath string) {
 	files, err := ioutil.ReadDir(keyPath)
@@ -42,51 +75,88 @@ func LoadPublicKeys(keyPath string) {
 	}
 }
 
+//keyGenerators maps the name of a signature algorithm to a function generating a new key pair for
+//it. Algorithms which are defined by the protocol but not yet implemented map to nil.
+var keyGenerators = map[string]func() (publicKey, privateKey []byte, err error){
+	"ed25519": func() ([]byte, []byte, error) {
+		return ed25519.GenerateKey(nil)
+	},
+	"ed448": nil,
+}
+
+//SupportedAlgorithms returns the names of all algorithms for which keys can be generated.
+func SupportedAlgorithms() []string {
+	var algos []string
+	for algo, generator := range keyGenerators {
+		if generator != nil {
+			algos = append(algos, algo)
+		}
+	}
+	sort.Strings(algos)
+	return algos
+}
+
 //GenerateKey generates a keypair according to algo and stores them separately at keyPath/name in
 //pem format. The suffix of the filename is either PublicKey or PrivateKey. The private key is
 //encrypted using pwd. Both pem blocks contain the description and the key phase in the header. The
-//private key pem block additionally has a salt and iv value in the header required for decryption.
-func GenerateKey(keyPath, name, description, algo, pwd string, phase int) {
-	var publicKey, privateKey []byte
-	var err error
-	switch algo {
-	case "ed25519":
-		if publicKey, privateKey, err = ed25519.GenerateKey(nil); err != nil {
-			log.Error("Was not able to generate ed25519 key pair", "error", err)
-			return
-		}
-	case "ed448":
-		log.Warn("Not yet supported")
-		return
-	default:
-		log.Error("unsupported algorithm", "algo", algo)
-		return
+//private key pem block additionally has a salt and nonce value in the header required for
+//decryption. It returns an error if pwd is empty.
+func GenerateKey(keyPath, name, description, algo, pwd string, phase int) error {
+	generator, ok := keyGenerators[algo]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %s, supported are %v", algo,
+			SupportedAlgorithms())
+	}
+	if generator == nil {
+		return fmt.Errorf("algorithm %s is not yet supported, supported are %v", algo,
+			SupportedAlgorithms())
+	}
+	if pwd == "" {
+		return errEmptyPassphrase
 	}
+	publicKey, privateKey, err := generator()
+	if err != nil {
+		return fmt.Errorf("Was not able to generate key pair: %v", err)
+	}
+	if err := storeKeyPair(keyPath, name, description, algo, pwd, phase, publicKey,
+		privateKey); err != nil {
+		return fmt.Errorf("Was not able to store key pair: %v", err)
+	}
+	return nil
+}
+
+//storeKeyPair stores publicKey and privateKey separately at keyPath/name in pem format. The private
+//key is encrypted using pwd.
+func storeKeyPair(keyPath, name, description, algo, pwd string, phase int, publicKey,
+	privateKey []byte) error {
 	publicBlock, privateBlock := createPEMBlocks(description, algo, pwd, phase, publicKey, privateKey)
+	if privateBlock == nil {
+		return errors.New("Was not able to encrypt private key")
+	}
 	publicFile, err := os.Create(path.Join(keyPath, name+pubSuffix))
 	if err != nil {
-		log.Error("Was not able to create file for public key", "error", err)
-		return
+		return fmt.Errorf("Was not able to create file for public key: %v", err)
 	}
-	privateFile, err := os.Create(path.Join(keyPath, name+secSuffix))
+	defer publicFile.Close()
+	privateFile, err := os.OpenFile(path.Join(keyPath, name+secSuffix),
+		os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		log.Error("Was not able to create file for private key", "error", err)
-		return
+		return fmt.Errorf("Was not able to create file for private key: %v", err)
 	}
+	defer privateFile.Close()
 	if err = pem.Encode(publicFile, publicBlock); err != nil {
-		log.Error("Was not able to write public pem block to file", "error", err)
-		return
+		return fmt.Errorf("Was not able to write public pem block to file: %v", err)
 	}
 	if err = pem.Encode(privateFile, privateBlock); err != nil {
-		log.Error("Was not able to write private pem block to file", "error", err)
-		return
+		return fmt.Errorf("Was not able to write private pem block to file: %v", err)
 	}
+	return nil
 }
 
 func createPEMBlocks(description, algo, pwd string, phase int, publicKey, privateKey []byte) (
 	blockPublic *pem.Block, blockPrivate *pem.Block) {
 	blockPublic = &pem.Block{
-		Type: "RAINS PUBLIC KEY",
+		Type: publicKeyType,
 		Headers: map[string]string{
 			"keyAlgo":     algo,
 			"keyPhase":    strconv.Itoa(phase),
@@ -94,80 +164,466 @@ func createPEMBlocks(description, algo, pwd string, phase int, publicKey, privat
 		},
 		Bytes: publicKey,
 	}
-	salt, iv, ciphertext, err := encryptPrivateKey(pwd, privateKey)
+	blockPrivate, err := encryptPrivateKey(pwd, algo, phase, privateKey)
 	if err != nil {
-		log.Error("Was not able to encrypt private key")
-	}
-	blockPrivate = &pem.Block{
-		Type: "RAINS ENCRYPTED PRIVATE KEY",
-		Headers: map[string]string{
-			"keyAlgo":     algo,
-			"keyPhase":    strconv.Itoa(phase),
-			"description": description,
-			"salt":        hex.EncodeToString(salt),
-			"iv":          hex.EncodeToString(iv),
-		},
-		Bytes: ciphertext,
+		log.Error("Was not able to encrypt private key", "error", err)
+		return
 	}
+	blockPrivate.Headers["description"] = description
 	return
 }
 
-func encryptPrivateKey(pwd string, privateKey []byte) (salt, iv, ciphertext []byte, err error) {
-	salt = make([]byte, 8)
+//encryptPrivateKey returns a pem block containing privateKey of algo and phase encrypted with
+//aes-256-gcm. The aes key is derived from pwd and a random salt using scrypt. The salt, nonce,
+//algorithm and key phase are stored in the block's header. The latter two are authenticated as
+//additional data such that they cannot be changed without detection. It returns an error if pwd is
+//empty.
+func encryptPrivateKey(pwd, algo string, phase int, privateKey []byte) (*pem.Block, error) {
+	if pwd == "" {
+		return nil, errEmptyPassphrase
+	}
+	salt := make([]byte, saltSize)
 	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
-		return nil, nil, nil, err
+		return nil, err
+	}
+	aead, err := newAEAD(pwd, salt)
+	if err != nil {
+		return nil, err
 	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	headers := map[string]string{
+		"kdf":      kdfScrypt,
+		"cipher":   cipherAESGCM,
+		"salt":     hex.EncodeToString(salt),
+		"nonce":    hex.EncodeToString(nonce),
+		"keyAlgo":  algo,
+		"keyPhase": strconv.Itoa(phase),
+	}
+	return &pem.Block{
+		Type:    privateKeyType,
+		Headers: headers,
+		Bytes:   aead.Seal(nil, nonce, privateKey, additionalData(headers)),
+	}, nil
+}
+
+//additionalData returns the headers of an encrypted private key which are authenticated along with
+//the ciphertext. Pem header values cannot contain line breaks which makes the encoding unambiguous.
+func additionalData(headers map[string]string) []byte {
+	return []byte(fmt.Sprintf("keyAlgo:%s\nkeyPhase:%s", headers["keyAlgo"], headers["keyPhase"]))
+}
 
-	dk, err := scrypt.Key([]byte(pwd), salt, 1<<15, 8, 1, 32)
+//decryptPrivateKey returns the private key contained in block decrypted with pwd. It returns an
+//error if pwd is wrong or the ciphertext, the algorithm or the key phase of the block has been
+//modified. Blocks encrypted with aes-cfb by earlier versions of the keyManager are supported as
+//well but cannot be authenticated. For them, a wrong pwd is only detected for ed25519 keys whose
+//second half must match the public key derived from the seed.
+func decryptPrivateKey(pwd string, block *pem.Block) ([]byte, error) {
+	if block.Type != privateKeyType {
+		return nil, fmt.Errorf("unexpected pem block type: %s", block.Type)
+	}
+	salt, err := hex.DecodeString(block.Headers["salt"])
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, fmt.Errorf("Was not able to decode salt from pem encoding: %v", err)
+	}
+	switch block.Headers["cipher"] {
+	case cipherAESGCM:
+		nonce, err := hex.DecodeString(block.Headers["nonce"])
+		if err != nil {
+			return nil, fmt.Errorf("Was not able to decode nonce from pem encoding: %v", err)
+		}
+		aead, err := newAEAD(pwd, salt)
+		if err != nil {
+			return nil, err
+		}
+		if len(nonce) != aead.NonceSize() {
+			return nil, errors.New("nonce in pem encoding has wrong length")
+		}
+		privateKey, err := aead.Open(nil, nonce, block.Bytes, additionalData(block.Headers))
+		if err != nil {
+			return nil, errors.New("wrong password or corrupted private key")
+		}
+		return privateKey, nil
+	case "":
+		//legacy aes-cfb encryption
+		iv, err := hex.DecodeString(block.Headers["iv"])
+		if err != nil {
+			return nil, fmt.Errorf("Was not able to decode iv from pem encoding: %v", err)
+		}
+		dk, err := scrypt.Key([]byte(pwd), salt, scryptN, scryptR, scryptP, keySize)
+		if err != nil {
+			return nil, err
+		}
+		c, err := aes.NewCipher(dk)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != c.BlockSize() {
+			return nil, errors.New("iv in pem encoding has wrong length")
+		}
+		privateKey := make([]byte, len(block.Bytes))
+		cipher.NewCFBDecrypter(c, iv).XORKeyStream(privateKey, block.Bytes)
+		if block.Headers["keyAlgo"] == "ed25519" && (len(privateKey) != ed25519.PrivateKeySize ||
+			!bytes.Equal(ed25519.NewKeyFromSeed(privateKey[:ed25519.SeedSize]), privateKey)) {
+			return nil, errors.New("wrong password or corrupted private key")
+		}
+		return privateKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported cipher: %s", block.Headers["cipher"])
 	}
+}
 
+//newAEAD returns an aes-256-gcm cipher whose key is derived from pwd and salt using scrypt.
+func newAEAD(pwd string, salt []byte) (cipher.AEAD, error) {
+	dk, err := scrypt.Key([]byte(pwd), salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, err
+	}
 	block, err := aes.NewCipher(dk)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
+	return cipher.NewGCM(block)
+}
 
-	ciphertext = make([]byte, len(privateKey))
-	iv = make([]byte, aes.BlockSize)
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return nil, nil, nil, err
+//DecryptKey decrypts the private key stored at keyPath/name with pwd and returns it in pem format.
+func DecryptKey(keyPath, name, pwd string) (string, error) {
+	data, err := ioutil.ReadFile(path.Join(keyPath, name+secSuffix))
+	if err != nil {
+		return "", fmt.Errorf("Was not able to read private key file: %v", err)
 	}
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(ciphertext, privateKey)
-	return
+	pblock, _ := pem.Decode(data)
+	if pblock == nil {
+		return "", errors.New("Was not able to decode pem encoded private key")
+	}
+	privateKey, err := decryptPrivateKey(pwd, pblock)
+	if err != nil {
+		return "", fmt.Errorf("Was not able to decrypt private key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type: "RAINS PRIVATE KEY",
+		Headers: map[string]string{
+			"keyAlgo":     pblock.Headers["keyAlgo"],
+			"keyPhase":    pblock.Headers["keyPhase"],
+			"description": pblock.Headers["description"],
+		},
+		Bytes: privateKey,
+	})), nil
 }
 
-//DecryptKey decryptes the private key stored at keyPath/name with pwd and prints it in pem format.
-func DecryptKey(keyPath, name, pwd string) {
-	data, err := ioutil.ReadFile(path.Join(keyPath, name+secSuffix))
+//DecryptPrivateKeys returns all private keys contained in the pem encoded data decrypted with pwd.
+func DecryptPrivateKeys(data []byte, pwd string) ([]keys.PrivateKey, error) {
+	var privateKeys []keys.PrivateKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		privateKey, err := decryptPrivateKey(pwd, block)
+		if err != nil {
+			return nil, err
+		}
+		id, err := publicKeyID(block.Headers)
+		if err != nil {
+			return nil, err
+		}
+		switch id.Algorithm {
+		case algorithmTypes.Ed25519:
+			if len(privateKey) != ed25519.PrivateKeySize {
+				return nil, errors.New("incorrect private key length")
+			}
+			privateKeys = append(privateKeys, keys.PrivateKey{
+				PublicKeyID: id,
+				Key:         ed25519.PrivateKey(privateKey),
+			})
+		default:
+			return nil, fmt.Errorf("unsupported algorithm: %s", id.Algorithm)
+		}
+	}
+	if len(privateKeys) == 0 {
+		return nil, errors.New("no pem encoded private key found")
+	}
+	return privateKeys, nil
+}
+
+//EncryptPrivateKeys returns privateKeys pem encoded where each key is encrypted with pwd. It returns
+//an error if pwd is empty.
+func EncryptPrivateKeys(privateKeys []keys.PrivateKey, pwd string) ([]byte, error) {
+	if pwd == "" {
+		return nil, errEmptyPassphrase
+	}
+	var output []byte
+	for _, key := range privateKeys {
+		var keyData []byte
+		var algo string
+		switch k := key.Key.(type) {
+		case ed25519.PrivateKey:
+			keyData, algo = k, "ed25519"
+		default:
+			return nil, fmt.Errorf("unsupported private key type: %T", key.Key)
+		}
+		block, err := encryptPrivateKey(pwd, algo, key.KeyPhase, keyData)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, pem.EncodeToMemory(block)...)
+	}
+	return output, nil
+}
+
+//IsEncrypted returns true if data contains pem encoded encrypted private keys.
+func IsEncrypted(data []byte) bool {
+	block, _ := pem.Decode(data)
+	return block != nil && block.Type == privateKeyType
+}
+
+//LoadPrivateKeys returns the private keys stored at path. They are either pem encoded and
+//encrypted as generated by the keyManager or stored in plaintext json format as by earlier
+//versions of the publisher. Encrypted keys are decrypted with the passphrase returned by
+//passphrase. It returns an error if the keys are encrypted and passphrase is nil.
+func LoadPrivateKeys(path string, passphrase func() (string, error)) ([]keys.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Error("Was not able to read private key file", "error", err)
-		return
+		return nil, err
+	}
+	if !IsEncrypted(data) {
+		log.Warn("Private keys are stored in plaintext. Use the keyManager's migrate command to "+
+			"encrypt them", "path", path)
+		return plaintextPrivateKeys(data)
 	}
-	pblock, rest := pem.Decode(data)
-	if len(rest) != 0 {
-		log.Error("Was not able to decode pem encoded private key", "error", err)
+	if passphrase == nil {
+		return nil, fmt.Errorf("private keys at %s are encrypted but no passphrase is configured",
+			path)
 	}
-	salt, err := hex.DecodeString(pblock.Headers["salt"])
+	pwd, err := passphrase()
 	if err != nil {
-		log.Error("Was not able to decode salt from pem encoding", "error", err)
+		return nil, fmt.Errorf("Was not able to obtain passphrase: %v", err)
 	}
-	iv, err := hex.DecodeString(pblock.Headers["iv"])
+	return DecryptPrivateKeys(data, pwd)
+}
+
+//PassphraseFile returns a function reading the passphrase of private keys from the file at path,
+//e.g. for LoadPrivateKeys. A trailing newline is not part of the passphrase.
+func PassphraseFile(path string) func() (string, error) {
+	return func() (string, error) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+}
+
+//Passphrase returns the passphrase of encrypted private keys. It is taken from the environment
+//variable PassphraseEnv if it is set. Otherwise, the user is prompted to enter it on the terminal
+//without echoing it. It returns an error if stdin is not a terminal.
+func Passphrase() (string, error) {
+	if pwd, ok := os.LookupEnv(PassphraseEnv); ok {
+		return pwd, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", fmt.Errorf("stdin is not a terminal, set %s instead", PassphraseEnv)
+	}
+	fmt.Fprint(os.Stderr, "Passphrase for private keys: ")
+	pwd, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
 	if err != nil {
-		log.Error("Was not able to decode iv from pem encoding", "error", err)
+		return "", err
 	}
-	dk, err := scrypt.Key([]byte(pwd), salt, 1<<15, 8, 1, 32)
+	return string(pwd), nil
+}
+
+//plaintextPrivateKeys returns the hex encoded ed25519 private keys stored in json format in data.
+func plaintextPrivateKeys(data []byte) ([]keys.PrivateKey, error) {
+	var plainKeys []keys.PrivateKey
+	if err := json.Unmarshal(data, &plainKeys); err != nil {
+		return nil, fmt.Errorf("Was not able to unmarshal plaintext private keys: %v", err)
+	}
+	for i, key := range plainKeys {
+		keyString, ok := key.Key.(string)
+		if !ok {
+			return nil, errors.New("private key is not hex encoded")
+		}
+		privateKey, err := hex.DecodeString(keyString)
+		if err != nil {
+			return nil, err
+		}
+		if key.Algorithm != algorithmTypes.Ed25519 || len(privateKey) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("unsupported private key: %s", key.PublicKeyID)
+		}
+		plainKeys[i].Key = ed25519.PrivateKey(privateKey)
+	}
+	return plainKeys, nil
+}
+
+//MigratePrivateKeys reads the plaintext private keys stored in json format at plainPath (as used
+//by earlier versions of the publisher), encrypts them with pwd and stores them at
+//keyPath/name_sec.pem. If plainPath contains pem encoded private keys encrypted with aes-cfb by
+//earlier versions of the keyManager instead, they are decrypted with pwd and encrypted again with
+//aes-256-gcm. It returns an error if pwd is empty.
+func MigratePrivateKeys(plainPath, keyPath, name, pwd string) error {
+	if pwd == "" {
+		return errEmptyPassphrase
+	}
+	data, err := ioutil.ReadFile(plainPath)
 	if err != nil {
-		log.Error("Was not able to create key from password and salt", "error", err)
+		return err
 	}
-	block, err := aes.NewCipher(dk)
+	if IsEncrypted(data) {
+		if data, err = reencryptPrivateKeys(data, pwd); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path.Join(keyPath, name+secSuffix), data, 0600)
+	}
+	plainKeys, err := plaintextPrivateKeys(data)
+	if err != nil {
+		return err
+	}
+	encoding, err := EncryptPrivateKeys(plainKeys, pwd)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(keyPath, name+secSuffix), encoding, 0600)
+}
+
+//reencryptPrivateKeys returns the pem encoded private keys contained in data encrypted again with
+//pwd. The description of the keys is preserved.
+func reencryptPrivateKeys(data []byte, pwd string) ([]byte, error) {
+	var output []byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		privateKey, err := decryptPrivateKey(pwd, block)
+		if err != nil {
+			return nil, err
+		}
+		id, err := publicKeyID(block.Headers)
+		if err != nil {
+			return nil, err
+		}
+		migrated, err := encryptPrivateKey(pwd, block.Headers["keyAlgo"], id.KeyPhase, privateKey)
+		if err != nil {
+			return nil, err
+		}
+		if description, ok := block.Headers["description"]; ok {
+			migrated.Headers["description"] = description
+		}
+		output = append(output, pem.EncodeToMemory(migrated)...)
+	}
+	return output, nil
+}
+
+//publicKeyID returns the public key identifier stored in the pem headers.
+func publicKeyID(headers map[string]string) (keys.PublicKeyID, error) {
+	id := keys.PublicKeyID{KeySpace: keys.RainsKeySpace}
+	switch headers["keyAlgo"] {
+	case "ed25519":
+		id.Algorithm = algorithmTypes.Ed25519
+	case "ed448":
+		id.Algorithm = algorithmTypes.Ed448
+	default:
+		return id, fmt.Errorf("unsupported algorithm: %s", headers["keyAlgo"])
+	}
+	phase, err := strconv.Atoi(headers["keyPhase"])
 	if err != nil {
-		log.Error("Was not able to create aes cipher from key", "error", err)
+		return id, fmt.Errorf("Was not able to parse key phase: %v", err)
 	}
+	id.KeyPhase = phase
+	return id, nil
+}
+
+//AuditKeyUsage appends a record about operation performed with the key pair stored at keyPath/name
+//to the audit log at logPath.
+func AuditKeyUsage(logPath, keyPath, name, operation string) error {
+	data, err := ioutil.ReadFile(path.Join(keyPath, name+pubSuffix))
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != publicKeyType {
+		return errors.New("Was not able to decode pem encoded public key")
+	}
+	id, err := publicKeyID(block.Headers)
+	if err != nil {
+		return err
+	}
+	return audit.New(logPath).Append(audit.Record{Operation: operation, KeyID: id.String()})
+}
 
-	stream := cipher.NewCFBDecrypter(block, iv)
-	// XORKeyStream can work in-place if the two arguments are the same.
-	stream.XORKeyStream(pblock.Bytes, pblock.Bytes)
-	fmt.Printf("%s", pem.EncodeToMemory(pblock))
+//GenerateDelegation loads the public key stored at keyPath/name and returns a delegation assertion
+//for zone in zonefile format which can be included in the zone's parent zonefile. The assertion is
+//preceded by a comment stating the parent zone and is also stored at keyPath/name_deleg.txt.
+func GenerateDelegation(keyPath, name, zone string) (string, error) {
+	publicKey, err := LoadPublicKey(path.Join(keyPath, name+pubSuffix))
+	if err != nil {
+		return "", err
+	}
+	subjectName, parent, err := splitZone(zone)
+	if err != nil {
+		return "", err
+	}
+	a := &section.Assertion{
+		SubjectName: subjectName,
+		SubjectZone: parent,
+		Context:     ".",
+		Content: []object.Object{object.Object{
+			Type:  object.OTDelegation,
+			Value: publicKey,
+		}},
+	}
+	encoding := fmt.Sprintf("; delegation of %s to be included in zone %s\n%s\n", zone, parent,
+		zonefile.IO{}.EncodeSection(a))
+	if err := ioutil.WriteFile(path.Join(keyPath, name+delegSuffix), []byte(encoding), 0644); err != nil {
+		return "", err
+	}
+	return encoding, nil
+}
+
+//LoadPublicKey returns the pem encoded public key stored in the file at path as generated by
+//GenerateKey. The returned key has no validity period.
+func LoadPublicKey(path string) (keys.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return keys.PublicKey{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != publicKeyType {
+		return keys.PublicKey{}, errors.New("Was not able to decode pem encoded public key")
+	}
+	id, err := publicKeyID(block.Headers)
+	if err != nil {
+		return keys.PublicKey{}, err
+	}
+	switch id.Algorithm {
+	case algorithmTypes.Ed25519:
+		if len(block.Bytes) != ed25519.PublicKeySize {
+			return keys.PublicKey{}, errors.New("incorrect public key length")
+		}
+		return keys.PublicKey{PublicKeyID: id, Key: ed25519.PublicKey(block.Bytes)}, nil
+	default:
+		return keys.PublicKey{}, fmt.Errorf("unsupported algorithm: %s", id.Algorithm)
+	}
+}
+
+//splitZone returns the first label of zone and the name of its parent zone. It returns an error for
+//the root zone as it has no parent.
+func splitZone(zone string) (subjectName, parent string, err error) {
+	if !strings.HasSuffix(zone, ".") {
+		zone += "."
+	}
+	if zone == "." {
+		return "", "", errors.New("the root zone has no parent")
+	}
+	labels := strings.SplitN(zone, ".", 2)
+	if labels[1] == "" {
+		return labels[0], ".", nil
+	}
+	return labels[0], labels[1], nil
 }
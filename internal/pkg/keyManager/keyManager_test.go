@@ -0,0 +1,327 @@
+package keyManager
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+func TestEncryptPrivateKey(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	var tests = []struct {
+		pwd    string
+		modify func(block *pem.Block)
+		valid  bool
+	}{
+		{"secret", func(block *pem.Block) {}, true},
+		{"wrong", func(block *pem.Block) {}, false},
+		{"secret", func(block *pem.Block) { block.Bytes[0] ^= 1 }, false},
+		{"secret", func(block *pem.Block) { block.Headers["keyPhase"] = "2" }, false},
+		{"secret", func(block *pem.Block) { block.Headers["keyAlgo"] = "ed448" }, false},
+		{"secret", func(block *pem.Block) { delete(block.Headers, "keyPhase") }, false},
+		{"secret", func(block *pem.Block) { block.Headers["keyPhase"] = "01" }, false},
+		{"secret", func(block *pem.Block) { block.Headers["nonce"] = block.Headers["salt"][:24] },
+			false},
+		//without the cipher header, the block is decrypted as a legacy block without iv
+		{"secret", func(block *pem.Block) { delete(block.Headers, "cipher") }, false},
+		{"secret", func(block *pem.Block) { block.Headers["description"] = "changed" }, true},
+	}
+	for i, test := range tests {
+		block, err := encryptPrivateKey("secret", "ed25519", 1, privateKey)
+		if err != nil {
+			t.Fatalf("%d: was not able to encrypt private key: %v", i, err)
+		}
+		if bytes.Contains(block.Bytes, privateKey) {
+			t.Errorf("%d: private key is stored in plaintext", i)
+		}
+		test.modify(block)
+		decrypted, err := decryptPrivateKey(test.pwd, block)
+		if test.valid && (err != nil || !bytes.Equal(decrypted, privateKey)) {
+			t.Errorf("%d: decryption failed. expected=%x actual=%x error=%v", i,
+				[]byte(privateKey), decrypted, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%d: modified block or wrong password was not detected", i)
+		}
+	}
+}
+
+func TestMigrateLegacyPrivateKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyManager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	legacyPath := path.Join(dir, "legacy"+secSuffix)
+	if err := ioutil.WriteFile(legacyPath, legacyBlock(t, "secret", privateKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigratePrivateKeys(legacyPath, dir, "ethz", "secret"); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	data, err := ioutil.ReadFile(path.Join(dir, "ethz"+secSuffix))
+	if err != nil {
+		t.Fatalf("migrated key was not stored: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Headers["cipher"] != cipherAESGCM ||
+		block.Headers["description"] != "legacy key" {
+		t.Fatalf("key was not encrypted again. block=%v", block)
+	}
+	migrated, err := DecryptPrivateKeys(data, "secret")
+	if err != nil || len(migrated) != 1 {
+		t.Fatalf("was not able to decrypt migrated key. keys=%v error=%v", migrated, err)
+	}
+	if !bytes.Equal(migrated[0].Key.(ed25519.PrivateKey), privateKey) ||
+		migrated[0].KeyPhase != 1 {
+		t.Errorf("migrated key differs. expected=%x actual=%v", []byte(privateKey), migrated[0])
+	}
+	if err := MigratePrivateKeys(legacyPath, dir, "ethz", "wrong"); err == nil {
+		t.Errorf("legacy key was migrated with a wrong password")
+	}
+}
+
+func TestDecryptLegacyPrivateKey(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	var tests = []struct {
+		pwd    string
+		modify func(block *pem.Block)
+		valid  bool
+	}{
+		{"secret", func(block *pem.Block) {}, true},
+		{"wrong", func(block *pem.Block) {}, false},
+		{"secret", func(block *pem.Block) { block.Bytes[40] ^= 1 }, false},
+		{"secret", func(block *pem.Block) { block.Headers["iv"] = "00" }, false},
+		//the key phase of legacy blocks is not authenticated
+		{"secret", func(block *pem.Block) { block.Headers["keyPhase"] = "2" }, true},
+	}
+	for i, test := range tests {
+		block, _ := pem.Decode(legacyBlock(t, "secret", privateKey))
+		test.modify(block)
+		decrypted, err := decryptPrivateKey(test.pwd, block)
+		if test.valid && (err != nil || !bytes.Equal(decrypted, privateKey)) {
+			t.Errorf("%d: decryption failed. expected=%x actual=%x error=%v", i,
+				[]byte(privateKey), decrypted, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%d: modified block or wrong password was not detected", i)
+		}
+	}
+	privateKeys, err := DecryptPrivateKeys(legacyBlock(t, "secret", privateKey), "secret")
+	if err != nil || len(privateKeys) != 1 || privateKeys[0].KeyPhase != 1 ||
+		!bytes.Equal(privateKeys[0].Key.(ed25519.PrivateKey), privateKey) {
+		t.Errorf("wrong legacy private keys. keys=%v error=%v", privateKeys, err)
+	}
+}
+
+//legacyBlock returns privateKey pem encoded and encrypted with aes-cfb as by earlier versions of
+//the keyManager.
+func legacyBlock(t *testing.T, pwd string, privateKey []byte) []byte {
+	t.Helper()
+	salt, iv := []byte("saltsalt"), bytes.Repeat([]byte{1}, aes.BlockSize)
+	dk, err := scrypt.Key([]byte(pwd), salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := aes.NewCipher(dk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := make([]byte, len(privateKey))
+	cipher.NewCFBEncrypter(c, iv).XORKeyStream(ciphertext, privateKey)
+	return pem.EncodeToMemory(&pem.Block{
+		Type: privateKeyType,
+		Headers: map[string]string{
+			"keyAlgo":     "ed25519",
+			"keyPhase":    "1",
+			"description": "legacy key",
+			"salt":        hex.EncodeToString(salt),
+			"iv":          hex.EncodeToString(iv),
+		},
+		Bytes: ciphertext,
+	})
+}
+
+func TestLoadPrivateKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyManager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	id := keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519, KeySpace: keys.RainsKeySpace,
+		KeyPhase: 1}
+	encrypted, err := EncryptPrivateKeys([]keys.PrivateKey{{PublicKeyID: id, Key: privateKey}},
+		"secret")
+	if err != nil {
+		t.Fatalf("Was not able to encrypt private key: %v", err)
+	}
+	plaintext, _ := json.Marshal([]keys.PrivateKey{{PublicKeyID: id,
+		Key: hex.EncodeToString(privateKey)}})
+	files := map[string]string{
+		"encrypted":  string(encrypted),
+		"plaintext":  string(plaintext),
+		"passphrase": "secret\n",
+		"wrong":      "wrong",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var tests = []struct {
+		keyFile    string
+		passphrase func() (string, error)
+		valid      bool
+	}{
+		{"encrypted", PassphraseFile(path.Join(dir, "passphrase")), true},
+		{"encrypted", nil, false},
+		{"encrypted", PassphraseFile(path.Join(dir, "wrong")), false},
+		{"encrypted", PassphraseFile(path.Join(dir, "missing")), false},
+		{"plaintext", nil, true},
+		{"missing", nil, false},
+	}
+	for i, test := range tests {
+		privateKeys, err := LoadPrivateKeys(path.Join(dir, test.keyFile), test.passphrase)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%d: private keys were loaded", i)
+			}
+			continue
+		}
+		if err != nil || len(privateKeys) != 1 || privateKeys[0].PublicKeyID != id ||
+			!bytes.Equal(privateKeys[0].Key.(ed25519.PrivateKey), privateKey) {
+			t.Errorf("%d: wrong private keys. keys=%v error=%v", i, privateKeys, err)
+		}
+	}
+}
+
+func TestEmptyPassphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyManager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	id := keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519, KeySpace: keys.RainsKeySpace}
+	plaintext, _ := json.Marshal([]keys.PrivateKey{{PublicKeyID: id,
+		Key: hex.EncodeToString(privateKey)}})
+	plainPath, hexPath := path.Join(dir, "plain.json"), path.Join(dir, "key.hex")
+	if err := ioutil.WriteFile(plainPath, plaintext, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(hexPath, []byte(hex.EncodeToString(privateKey)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []func(pwd string) error{
+		func(pwd string) error { return GenerateKey(dir, "generated", "", "ed25519", pwd, 0) },
+		func(pwd string) error {
+			_, err := EncryptPrivateKeys([]keys.PrivateKey{{PublicKeyID: id, Key: privateKey}}, pwd)
+			return err
+		},
+		func(pwd string) error { return MigratePrivateKeys(plainPath, dir, "migrated", pwd) },
+		func(pwd string) error { return ImportKey(hexPath, FormatHex, dir, "imported", "", pwd, 0) },
+	}
+	for i, test := range tests {
+		if err := test(""); err == nil {
+			t.Errorf("%d: private key was encrypted with an empty passphrase", i)
+		}
+		if err := test("secret"); err != nil {
+			t.Errorf("%d: private key was not encrypted: %v", i, err)
+		}
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	if previous, ok := os.LookupEnv(PassphraseEnv); ok {
+		defer os.Setenv(PassphraseEnv, previous)
+	} else {
+		defer os.Unsetenv(PassphraseEnv)
+	}
+	os.Setenv(PassphraseEnv, "secret")
+	if pwd, err := Passphrase(); pwd != "secret" || err != nil {
+		t.Errorf("passphrase was not read from the environment. pwd=%s error=%v", pwd, err)
+	}
+}
+
+func TestSplitZone(t *testing.T) {
+	var tests = []struct {
+		zone, subjectName, parent string
+		valid                     bool
+	}{
+		{"ethz.ch.", "ethz", "ch.", true},
+		{"ethz.ch", "ethz", "ch.", true},
+		{"inf.ethz.ch.", "inf", "ethz.ch.", true},
+		{"ch.", "ch", ".", true},
+		{"ch", "ch", ".", true},
+		{".", "", "", false},
+		{"", "", "", false},
+	}
+	for i, test := range tests {
+		subjectName, parent, err := splitZone(test.zone)
+		if (err == nil) != test.valid || subjectName != test.subjectName ||
+			parent != test.parent {
+			t.Errorf("%d: wrong split of %s. expected=(%s, %s) actual=(%s, %s) error=%v", i,
+				test.zone, test.subjectName, test.parent, subjectName, parent, err)
+		}
+	}
+}
+
+func TestGenerateDelegation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyManager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := GenerateKey(dir, "ch", "", "ed25519", "secret", 2); err != nil {
+		t.Fatalf("Was not able to generate key: %v", err)
+	}
+	publicKey, err := LoadPublicKey(path.Join(dir, "ch"+pubSuffix))
+	if err != nil {
+		t.Fatalf("Was not able to load public key: %v", err)
+	}
+	delegation, err := GenerateDelegation(dir, "ch", "ch")
+	if err != nil {
+		t.Fatalf("Was not able to generate delegation: %v", err)
+	}
+	sections, err := zonefile.IO{}.LoadZonefile(path.Join(dir, "ch"+delegSuffix))
+	if err != nil || len(sections) != 1 {
+		t.Fatalf("stored delegation is not a single section. sections=%v error=%v", sections, err)
+	}
+	//The assertion obtains its zone and context from the parent's zonefile in which it is
+	//included.
+	a, ok := sections[0].(*section.Assertion)
+	if !ok || !strings.HasPrefix(delegation, "; delegation of ch to be included in zone .\n") ||
+		a.SubjectName != "ch" || len(a.Content) != 1 || a.Content[0].Type != object.OTDelegation {
+		t.Fatalf("wrong delegation of ch. delegation=%s", delegation)
+	}
+	if key, ok := a.Content[0].Value.(keys.PublicKey); !ok || key.CompareTo(publicKey) != 0 ||
+		key.KeyPhase != 2 {
+		t.Errorf("delegation contains the wrong key. expected=%v actual=%v", publicKey,
+			a.Content[0].Value)
+	}
+	if _, err := GenerateDelegation(dir, "ch", "."); err == nil {
+		t.Errorf("delegation of the root zone was generated")
+	}
+	if _, err := GenerateDelegation(dir, "missing", "ethz.ch."); err == nil {
+		t.Errorf("delegation of a missing key was generated")
+	}
+}
@@ -0,0 +1,171 @@
+package libresolve
+
+import (
+	"net"
+	"sort"
+)
+
+//SortAddrs sorts addrs such that the address which should be dialed first comes first. It follows
+//the destination address selection of RFC 6724: addresses for which the host has no route come
+//last, then addresses whose scope and label match the ones of the source address used to reach
+//them are preferred, followed by addresses of higher precedence (IPv6 before IPv4 by default),
+//smaller scope and, among IPv6 addresses, a longer prefix shared with the source address. Addresses
+//which are equal according to these rules keep their order.
+func SortAddrs(addrs []net.IPAddr) {
+	entries := make([]addrEntry, len(addrs))
+	for i, addr := range addrs {
+		entries[i] = addrEntry{dst: addr, src: sourceAddr(addr)}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].preferredTo(entries[j]) })
+	for i, e := range entries {
+		addrs[i] = e.dst
+	}
+}
+
+//addrEntry is a destination address together with the source address the host would use to reach
+//it. src is nil if there is no route to dst.
+type addrEntry struct {
+	dst net.IPAddr
+	src net.IP
+}
+
+//sourceAddr returns the source address the host uses to reach addr or nil if addr is unreachable.
+//No packet is sent as connecting a UDP socket only selects a route.
+func sourceAddr(addr net.IPAddr) net.IP {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: addr.IP, Port: 9, Zone: addr.Zone})
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	if local, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return local.IP
+	}
+	return nil
+}
+
+//preferredTo returns true if a should be dialed before b.
+func (a addrEntry) preferredTo(b addrEntry) bool {
+	//Rule 1: avoid unusable destinations
+	if (a.src == nil) != (b.src == nil) {
+		return a.src != nil
+	}
+	if a.src == nil {
+		return false
+	}
+	scopeA, scopeB := addrScope(a.dst.IP), addrScope(b.dst.IP)
+	//Rule 2: prefer matching scope
+	matchA, matchB := scopeA == addrScope(a.src), scopeB == addrScope(b.src)
+	if matchA != matchB {
+		return matchA
+	}
+	policyA, policyB := policyOf(a.dst.IP), policyOf(b.dst.IP)
+	//Rule 5: prefer matching label
+	matchA, matchB = policyA.label == policyOf(a.src).label, policyB.label == policyOf(b.src).label
+	if matchA != matchB {
+		return matchA
+	}
+	//Rule 6: prefer higher precedence
+	if policyA.precedence != policyB.precedence {
+		return policyA.precedence > policyB.precedence
+	}
+	//Rule 8: prefer smaller scope
+	if scopeA != scopeB {
+		return scopeA < scopeB
+	}
+	//Rule 9: use longest matching prefix, only among IPv6 addresses as IPv4 prefixes say little
+	//about proximity
+	if a.dst.IP.To4() == nil && b.dst.IP.To4() == nil {
+		return commonPrefixLen(a.src, a.dst.IP) > commonPrefixLen(b.src, b.dst.IP)
+	}
+	//Rule 10: otherwise, leave the order unchanged
+	return false
+}
+
+//policy is an entry of the default policy table of RFC 6724.
+type policy struct {
+	prefix     *net.IPNet
+	precedence int
+	label      int
+}
+
+//policyTable is the default policy table of RFC 6724 sorted by decreasing prefix length. IPv4
+//addresses are matched in their IPv4-mapped IPv6 form.
+var policyTable = []policy{
+	{mustCIDR("::1/128"), 50, 0},
+	{mustCIDR("::ffff:0:0/96"), 35, 4},
+	{mustCIDR("::/96"), 1, 3},
+	{mustCIDR("2001::/32"), 5, 5},
+	{mustCIDR("2002::/16"), 30, 2},
+	{mustCIDR("3ffe::/16"), 1, 12},
+	{mustCIDR("fec0::/10"), 1, 11},
+	{mustCIDR("fc00::/7"), 3, 13},
+	{mustCIDR("::/0"), 40, 1},
+}
+
+func mustCIDR(s string) *net.IPNet {
+	_, prefix, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return prefix
+}
+
+//policyOf returns the entry of the policy table matching ip.
+func policyOf(ip net.IP) policy {
+	ip = ip.To16()
+	for _, p := range policyTable {
+		if p.prefix.Contains(ip) {
+			return p
+		}
+	}
+	return policy{}
+}
+
+//Scopes of RFC 4291 and RFC 6724.
+const (
+	scopeLinkLocal = 0x2
+	scopeSiteLocal = 0x5
+	scopeGlobal    = 0xe
+)
+
+//addrScope returns the scope of ip. IPv4 loopback and link-local addresses have link-local scope.
+func addrScope(ip net.IP) int {
+	if ip4 := ip.To4(); ip4 != nil {
+		if ip4.IsLoopback() || ip4.IsLinkLocalUnicast() {
+			return scopeLinkLocal
+		}
+		return scopeGlobal
+	}
+	switch {
+	case ip.IsMulticast():
+		return int(ip[1] & 0xf)
+	case ip.IsLoopback(), ip.IsLinkLocalUnicast():
+		return scopeLinkLocal
+	case len(ip) == net.IPv6len && ip[0] == 0xfe && ip[1]&0xc0 == 0xc0:
+		return scopeSiteLocal
+	default:
+		return scopeGlobal
+	}
+}
+
+//commonPrefixLen returns the number of leading bits a and b have in common.
+func commonPrefixLen(a, b net.IP) int {
+	a, b = a.To16(), b.To16()
+	if a == nil || b == nil {
+		return 0
+	}
+	n := 0
+	for i := range a {
+		diff := a[i] ^ b[i]
+		if diff == 0 {
+			n += 8
+			continue
+		}
+		for diff&0x80 == 0 {
+			n++
+			diff <<= 1
+		}
+		break
+	}
+	return n
+}
@@ -0,0 +1,63 @@
+package libresolve
+
+import (
+	"net"
+	"testing"
+)
+
+//Examples of RFC 6724 section 10.2 and the rules they exercise.
+func TestAddrPreferredTo(t *testing.T) {
+	entry := func(dst, src string) addrEntry {
+		return addrEntry{dst: net.IPAddr{IP: net.ParseIP(dst)}, src: net.ParseIP(src)}
+	}
+	var tests = []struct {
+		preferred, other addrEntry
+	}{
+		//Rule 1: avoid unusable destinations
+		{entry("198.51.100.121", "198.51.100.117"), entry("2001:db8:1::1", "")},
+		//Rule 2: prefer matching scope
+		{entry("198.51.100.121", "198.51.100.117"), entry("2001:db8:1::1", "fe80::1")},
+		{entry("fe80::1", "fe80::2"), entry("2001:db8:1::1", "fe80::2")},
+		//Rule 5: prefer matching label
+		{entry("2002:c633:6401::1", "2002:c633:6401::2"),
+			entry("2001:db8:1::1", "2002:c633:6401::2")},
+		//Rule 6: prefer higher precedence
+		{entry("2001:db8:1::1", "2001:db8:1::2"), entry("198.51.100.121", "198.51.100.117")},
+		{entry("2001:db8:1::1", "2001:db8:1::2"), entry("10.1.2.3", "10.1.2.4")},
+		//Rule 8: prefer smaller scope
+		{entry("fe80::1", "fe80::2"), entry("2001:db8::1", "2001:db8::2")},
+		//Rule 9: use longest matching prefix
+		{entry("2001:db8:1::1", "2001:db8:1::2"), entry("2001:db8:3ffe::1", "2001:db8:1::2")},
+	}
+	for i, test := range tests {
+		if !test.preferred.preferredTo(test.other) {
+			t.Errorf("%d: %v is not preferred to %v", i, test.preferred.dst, test.other.dst)
+		}
+		if test.other.preferredTo(test.preferred) {
+			t.Errorf("%d: %v is preferred to %v", i, test.other.dst, test.preferred.dst)
+		}
+	}
+	//Rule 10: IPv4 addresses differing only in their prefix keep their order.
+	a, b := entry("198.51.100.1", "198.51.100.2"), entry("203.0.113.1", "198.51.100.2")
+	if a.preferredTo(b) || b.preferredTo(a) {
+		t.Errorf("order of equally preferred IPv4 addresses is not kept")
+	}
+}
+
+func TestCommonPrefixLen(t *testing.T) {
+	var tests = []struct {
+		a, b string
+		n    int
+	}{
+		{"2001:db8::1", "2001:db8::1", 128},
+		{"2001:db8:1::1", "2001:db8:3ffe::1", 34},
+		{"2001:db8::1", "3001:db8::1", 3},
+		{"192.0.2.1", "192.0.2.1", 128},
+		{"192.0.2.1", "192.0.2.129", 120},
+	}
+	for i, test := range tests {
+		if n := commonPrefixLen(net.ParseIP(test.a), net.ParseIP(test.b)); n != test.n {
+			t.Errorf("%d: wrong prefix length. expected=%d actual=%d", i, test.n, n)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package libresolve
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//lookup answers q within LookupTimeout and accounts the lookup in the resolver's counters. If q
+//has no query options, the resolver's default options are used. The steps of the lookup are
+//collected and passed to OnResolution together with the outcome or reported in a
+//*BudgetExceededError.
+func (r *Resolver) lookup(ctx context.Context, q *query.Name) (*message.Message, error) {
+	start := time.Now()
+	q = r.withOptions(q)
+	parent := ctx
+	if r.LookupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.LookupTimeout)
+		defer cancel()
+	}
+	recorder := &stepRecorder{}
+	ctx = context.WithValue(ctx, stepsKey{}, recorder)
+	ctx = context.WithValue(ctx, referralsKey{}, &referralRecorder{})
+	ctx = context.WithValue(ctx, optionsKey{}, q.Options)
+	answer, origin, err := r.lookupAnswer(ctx, q)
+	recorder.mux.Lock()
+	steps := recorder.steps
+	recorder.mux.Unlock()
+	if berr, ok := err.(*BudgetExceededError); ok {
+		err = berr.withSteps(steps)
+	} else if err != nil && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+		answer, err = nil, &BudgetExceededError{
+			Name:  q.Name,
+			Limit: BudgetTime,
+			Max:   r.LookupTimeout.String(),
+			Steps: steps,
+		}
+	}
+	r.metrics.countLookup(origin, err)
+	if r.OnResolution != nil {
+		r.OnResolution(Resolution{
+			Query:    q,
+			Answer:   answer,
+			Err:      err,
+			Origin:   origin,
+			Duration: time.Since(start),
+			Steps:    steps,
+		})
+	}
+	return answer, err
+}
+
+//bypassCacheKey is the context key which marks lookups that must not be answered from the cache.
+type bypassCacheKey struct{}
+
+//WithoutCache returns a copy of ctx with which lookups are not answered from the resolver's cache,
+//e.g. to refresh cached answers which are about to expire. The received answers are cached.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCacheKey{}, true)
+}
+
+//lookupAnswer answers q from the static overrides or the resolver's cache if possible. Otherwise,
+//it forwards q to the forwarders or performs a recursive lookup, verifies the received answer
+//according to the verification mode and caches its verified sections. The lookup is aborted as
+//soon as ctx is done.
+func (r *Resolver) lookupAnswer(ctx context.Context, q *query.Name) (*message.Message, Origin,
+	error) {
+	if err := query.CheckOptions(q.Options); err != nil {
+		return nil, OriginNetwork, err
+	}
+	if answer, ok := r.overrideAnswer(q); ok {
+		log.Debug("respond with static overrides", "query", q, "answer", answer)
+		return answer, OriginOverride, nil
+	}
+	if ctx.Value(bypassCacheKey{}) == nil {
+		if answer, ok := r.cachedAnswer(q); ok {
+			log.Debug("respond with cached assertions", "query", q, "answer", answer)
+			return answer, OriginCache, nil
+		}
+	}
+	if r.Mode == Recursive && q.ContainsOption(query.QOCachedAnswersOnly) {
+		//in forwarding mode, the forwarder answers from its cache
+		return nil, OriginCache, fmt.Errorf("no cached answer to query %s and option %s forbids "+
+			"contacting servers", q.Name, query.QOCachedAnswersOnly.Name())
+	}
+	answer, err := r.resolve(ctx, q)
+	if err != nil {
+		return nil, OriginNetwork, err
+	}
+	results := r.verifyAnswer(ctx, answer)
+	for i, err := range results {
+		if err == nil {
+			continue
+		}
+		if r.Verification == Strict {
+			return nil, OriginNetwork, fmt.Errorf("answer to query %s could not be verified: %v",
+				q.Name, err)
+		}
+		log.Warn("section of answer could not be verified", "query", q, "error", err)
+		if r.Unverified != nil {
+			r.Unverified(answer.Content[i], err)
+		}
+	}
+	r.cacheAnswer(answer, results)
+	r.cacheReferrals(ctx)
+	return answer, OriginNetwork, nil
+}
+
+//resolve forwards q to the forwarders or performs a recursive lookup depending on the resolver's
+//mode. The answer is neither cached nor verified. If an identical query is already being resolved,
+//e.g. by another goroutine looking up the same name, its answer is shared instead of sending q
+//again.
+func (r *Resolver) resolve(ctx context.Context, q *query.Name) (*message.Message, error) {
+	answer, shared, err := r.inflight.do(ctx, q, func() (*message.Message, error) {
+		switch r.Mode {
+		case Recursive:
+			return r.recursiveResolve(ctx, q)
+		case Forward:
+			return r.forwardQuery(ctx, q)
+		default:
+			return nil, fmt.Errorf("Unsupported resolution mode: %v", r.Mode)
+		}
+	})
+	if shared {
+		atomic.AddInt64(&r.metrics.sharedResolutions, 1)
+	}
+	return answer, err
+}
+
+//cachedAnswer returns a message containing the cached sections answering q. For each queried type,
+//either a cached assertion or a cached shard, zone or pshard proving that no such assertion exists
+//must be usable, otherwise false is returned as the answer would be incomplete. A section is
+//usable if its signatures are currently valid or, if q contains the ExpiredAssertionsOk option,
+//were valid at some point.
+func (r *Resolver) cachedAnswer(q *query.Name) (*message.Message, bool) {
+	if r.Assertions == nil || len(q.Types) == 0 {
+		return nil, false
+	}
+	now := clock.Now().Unix()
+	expiredOk := q.ContainsOption(query.QOExpiredAssertionsOk)
+	usable := func(s section.WithSig) bool {
+		validSince, validUntil := sigValidity(s)
+		return validSince <= now && (expiredOk || validUntil >= now) && validUntil != 0
+	}
+	answer := &message.Message{}
+	seen := make(map[string]bool)
+	add := func(s section.WithSigForward) {
+		if !seen[s.Hash()] {
+			seen[s.Hash()] = true
+			answer.Content = append(answer.Content, s)
+		}
+	}
+	var proofs []section.WithSigForward
+	if r.NegAssertions != nil {
+		if subject, zone, ok := splitName(q.Name); ok {
+			proofs, _ = r.NegAssertions.Get(zone, q.Context, section.StringInterval{Name: subject})
+		}
+	}
+	for _, t := range q.Types {
+		found := false
+		assertions, _ := r.Assertions.Get(q.Name, q.Context, t, true)
+		for _, a := range assertions {
+			if usable(a) {
+				found = true
+				add(a)
+			}
+		}
+		for _, p := range proofs {
+			if !found && usable(p) && provesAbsence(p, q.Name, t) {
+				found = true
+				add(p)
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return answer, true
+}
+
+//provesAbsence returns true if s answers the query for name and type t. A shard or zone answers it
+//as it contains all assertions within its range, a pshard only if its bloom filter does not
+//contain the type.
+func provesAbsence(s section.WithSigForward, name string, t object.Type) bool {
+	p, ok := s.(*section.Pshard)
+	if !ok {
+		return true
+	}
+	subject, _, _ := splitName(name)
+	contained, err := p.BloomFilter.Contains(subject, p.SubjectZone, p.Context, t)
+	return err == nil && !contained
+}
+
+//splitName splits a fully qualified name into its first label and the zone it belongs to. It
+//returns false for the root zone and names not ending with a dot.
+func splitName(name string) (subject, zone string, ok bool) {
+	if !strings.HasSuffix(name, ".") || name == "." {
+		return "", "", false
+	}
+	parts := strings.SplitN(name, ".", 2)
+	if parts[1] == "" {
+		return parts[0], ".", true
+	}
+	return parts[0], parts[1], true
+}
+
+//cacheAnswer adds the signed assertions of msg, including the ones contained in shards and zones,
+//to the resolver's assertion cache and the signed shards, zones and pshards to its negative
+//assertion cache. Each section is cached until its last signature expires. If results is not nil,
+//sections which could not be verified according to results are not cached.
+func (r *Resolver) cacheAnswer(msg *message.Message, results []error) {
+	if msg == nil {
+		return
+	}
+	now := clock.Now().Unix()
+	for i, sec := range msg.Content {
+		if results != nil && results[i] != nil {
+			continue
+		}
+		switch s := sec.(type) {
+		case *section.Assertion:
+			r.cacheAssertion(s, now)
+		case *section.Shard:
+			for _, a := range s.Content {
+				r.cacheAssertion(a.Copy(s.Context, s.SubjectZone), now)
+			}
+			if validUntil, ok := r.negCacheable(s, now); ok {
+				r.NegAssertions.AddShard(s, validUntil, false)
+			}
+		case *section.Zone:
+			for _, a := range s.Content {
+				r.cacheAssertion(a.Copy(s.Context, s.SubjectZone), now)
+			}
+			if validUntil, ok := r.negCacheable(s, now); ok {
+				r.NegAssertions.AddZone(s, validUntil, false)
+			}
+		case *section.Pshard:
+			if validUntil, ok := r.negCacheable(s, now); ok {
+				r.NegAssertions.AddPshard(s, validUntil, false)
+			}
+		}
+	}
+}
+
+//flushAnswers removes all sections from the resolver's assertion and negative assertion cache.
+//It is called when a trust anchor changes as the cached sections were verified with its old keys
+//and cachedAnswer does not verify them again.
+func (r *Resolver) flushAnswers() {
+	removeZones := func(sections []section.Section, remove func(zone string)) {
+		removed := make(map[string]bool)
+		for _, s := range sections {
+			if zone := s.(section.WithSig).GetSubjectZone(); !removed[zone] {
+				removed[zone] = true
+				remove(zone)
+			}
+		}
+	}
+	if r.Assertions != nil {
+		removeZones(r.Assertions.Checkpoint(), r.Assertions.RemoveZone)
+	}
+	if r.NegAssertions != nil {
+		removeZones(r.NegAssertions.Checkpoint(), r.NegAssertions.RemoveZone)
+	}
+}
+
+//cacheAssertion adds a to the resolver's cache if it has a subject zone and context and at least
+//one of its signatures is valid at time now.
+func (r *Resolver) cacheAssertion(a *section.Assertion, now int64) {
+	if r.Assertions == nil || a.SubjectZone == "" || a.Context == "" {
+		return
+	}
+	validSince, validUntil := sigValidity(a)
+	if validSince > now || validUntil < now {
+		return
+	}
+	r.Assertions.Add(a, validUntil, false)
+}
+
+//negCacheable returns the expiration time of s and true if the resolver has a negative assertion
+//cache and at least one of s's signatures is valid at time now.
+func (r *Resolver) negCacheable(s section.WithSigForward, now int64) (int64, bool) {
+	if r.NegAssertions == nil {
+		return 0, false
+	}
+	validSince, validUntil := sigValidity(s)
+	return validUntil, validSince <= now && validUntil >= now
+}
+
+//sigValidity returns the earliest validSince and the latest validUntil time of s's signatures.
+//Both are zero if s is not signed.
+func sigValidity(s section.WithSig) (validSince, validUntil int64) {
+	for i, sig := range s.AllSigs() {
+		if i == 0 || sig.ValidSince < validSince {
+			validSince = sig.ValidSince
+		}
+		if sig.ValidUntil > validUntil {
+			validUntil = sig.ValidUntil
+		}
+	}
+	return
+}
@@ -0,0 +1,130 @@
+package libresolve
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//cachingResolver returns a resolver in Forward mode without verification whose forwarder answers
+//queries with the sections stored in answers under the queried name. The origins of its lookups
+//are appended to origins.
+func cachingResolver(answers map[string][]section.Section, origins *[]Origin) *Resolver {
+	r := New(nil, []net.Addr{&net.TCPAddr{IP: net.ParseIP("192.0.2.53"), Port: 55553}},
+		Forward, nil, 1)
+	r.Verification = NoVerification
+	r.Dialer = DialerFunc(func(ctx context.Context, addr net.Addr) (net.Conn, error) {
+		client, server := net.Pipe()
+		go verifyingServer(server, answers)
+		return client, nil
+	})
+	r.OnResolution = func(res Resolution) { *origins = append(*origins, res.Origin) }
+	return r
+}
+
+//cacheStep is a lookup performed after the clock has been advanced by advance.
+type cacheStep struct {
+	advance time.Duration
+	name    string
+	qtype   object.Type
+	options []query.Option
+	origin  Origin
+}
+
+//runCacheSteps performs the lookups of steps with r and checks where they were answered from.
+func runCacheSteps(t *testing.T, r *Resolver, fake *clock.Fake, origins *[]Origin,
+	steps []cacheStep) {
+	t.Helper()
+	for i, step := range steps {
+		fake.Advance(step.advance)
+		q := &query.Name{Name: step.name, Context: ".", Types: []object.Type{step.qtype},
+			Options: step.options, Expiration: clock.Now().Add(time.Minute).Unix()}
+		answer, err := r.ClientLookup(context.Background(), q)
+		if err != nil || len(answer.Content) != 1 {
+			t.Errorf("%d: lookup failed. answer=%v error=%v", i, answer, err)
+			continue
+		}
+		if origin := (*origins)[len(*origins)-1]; origin != step.origin {
+			t.Errorf("%d: wrong origin. expected=%v actual=%v", i, step.origin, origin)
+		}
+	}
+}
+
+func TestAnswerCache(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	defer clock.Set(fake)()
+	k := newTestKey(0)
+	ip4 := object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}
+	answers := map[string][]section.Section{
+		"www.ethz.ch.": []section.Section{testAssertion(t, "www", "ethz.ch.", k, ip4)},
+	}
+	var origins []Origin
+	r := cachingResolver(answers, &origins)
+	defer r.Close()
+	expiredOk := []query.Option{query.QOExpiredAssertionsOk}
+	//The assertion is valid for another hour.
+	runCacheSteps(t, r, fake, &origins, []cacheStep{
+		{0, "www.ethz.ch.", object.OTIP4Addr, nil, OriginNetwork},
+		{0, "www.ethz.ch.", object.OTIP4Addr, nil, OriginCache},
+		{0, "www.ethz.ch.", object.OTIP6Addr, nil, OriginNetwork},
+		{30 * time.Minute, "www.ethz.ch.", object.OTIP4Addr, nil, OriginCache},
+		{time.Hour, "www.ethz.ch.", object.OTIP4Addr, expiredOk, OriginCache},
+		{0, "www.ethz.ch.", object.OTIP4Addr, nil, OriginNetwork},
+		//the expired answer is not cached again
+		{0, "www.ethz.ch.", object.OTIP4Addr, nil, OriginNetwork},
+	})
+	if stats := r.Stats(); stats.CacheHits != 3 {
+		t.Errorf("wrong number of cache hits. expected=3 actual=%d", stats.CacheHits)
+	}
+
+	//Without an assertion cache, every lookup is sent to the network.
+	fake.Advance(-90 * time.Minute)
+	origins = nil
+	r = cachingResolver(answers, &origins)
+	r.Assertions = nil
+	defer r.Close()
+	runCacheSteps(t, r, fake, &origins, []cacheStep{
+		{0, "www.ethz.ch.", object.OTIP4Addr, nil, OriginNetwork},
+		{0, "www.ethz.ch.", object.OTIP4Addr, nil, OriginNetwork},
+	})
+}
+
+func TestNegativeAnswerCache(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	defer clock.Set(fake)()
+	k := newTestKey(0)
+	//The shard proves that ethz.ch. contains no assertions. It is valid for another hour.
+	shard := &section.Shard{SubjectZone: "ethz.ch.", Context: "."}
+	k.sign(t, shard, time.Now())
+	answers := map[string][]section.Section{"ftp.ethz.ch.": []section.Section{shard}}
+	var origins []Origin
+	r := cachingResolver(answers, &origins)
+	defer r.Close()
+	runCacheSteps(t, r, fake, &origins, []cacheStep{
+		{0, "ftp.ethz.ch.", object.OTIP4Addr, nil, OriginNetwork},
+		{0, "ftp.ethz.ch.", object.OTIP4Addr, nil, OriginCache},
+		{0, "ftp.ethz.ch.", object.OTIP6Addr, nil, OriginCache},
+		{59 * time.Minute, "ftp.ethz.ch.", object.OTIP4Addr, nil, OriginCache},
+		{2 * time.Minute, "ftp.ethz.ch.", object.OTIP4Addr,
+			[]query.Option{query.QOExpiredAssertionsOk}, OriginCache},
+		{0, "ftp.ethz.ch.", object.OTIP4Addr, nil, OriginNetwork},
+		//the expired shard is not cached again
+		{0, "ftp.ethz.ch.", object.OTIP4Addr, nil, OriginNetwork},
+	})
+	//A shard is only used for names within its range.
+	shard = &section.Shard{SubjectZone: "ethz.ch.", Context: ".", RangeFrom: "a", RangeTo: "g"}
+	k.sign(t, shard, fake.Now())
+	answers["ftp.ethz.ch."] = []section.Section{shard}
+	answers["www.ethz.ch."] = []section.Section{shard}
+	runCacheSteps(t, r, fake, &origins, []cacheStep{
+		{0, "ftp.ethz.ch.", object.OTIP4Addr, nil, OriginNetwork},
+		{0, "ftp.ethz.ch.", object.OTIP4Addr, nil, OriginCache},
+		{0, "www.ethz.ch.", object.OTIP4Addr, nil, OriginNetwork},
+	})
+}
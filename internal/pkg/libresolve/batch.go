@@ -0,0 +1,61 @@
+package libresolve
+
+import (
+	"context"
+	"sync"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+)
+
+//defaultBatchWorkers is the number of concurrent lookups of LookupBatch if none is specified.
+const defaultBatchWorkers = 16
+
+//LookupResult is the outcome of an asynchronous lookup.
+type LookupResult struct {
+	Query  *query.Name
+	Answer *message.Message
+	Err    error
+}
+
+//LookupAsync starts a lookup of q like ClientLookup and returns a channel on which its result is
+//delivered once it is available. The channel is closed afterwards.
+func (r *Resolver) LookupAsync(ctx context.Context, q *query.Name) <-chan LookupResult {
+	result := make(chan LookupResult, 1)
+	go func() {
+		answer, err := r.ClientLookup(ctx, q)
+		result <- LookupResult{Query: q, Answer: answer, Err: err}
+		close(result)
+	}()
+	return result
+}
+
+//LookupBatch resolves queries concurrently with at most workers lookups at a time and returns
+//their results in the order of queries. If workers is not positive, 16 lookups run concurrently.
+//Identical queries sent at the same time, e.g. the delegation queries needed to verify answers from
+//the same zone, are only sent once.
+func (r *Resolver) LookupBatch(ctx context.Context, queries []*query.Name,
+	workers int) []LookupResult {
+	if workers <= 0 {
+		workers = defaultBatchWorkers
+	}
+	results := make([]LookupResult, len(queries))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(queries); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				answer, err := r.ClientLookup(ctx, queries[i])
+				results[i] = LookupResult{Query: queries[i], Answer: answer, Err: err}
+			}
+		}()
+	}
+	for i := range queries {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,88 @@
+package libresolve
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//gatedServer counts the queries received on conn in queries and answers them with the sections
+//stored in answers under the queried name once release is closed.
+func gatedServer(conn net.Conn, answers map[string][]section.Section, release <-chan struct{},
+	queries *int32) {
+	defer conn.Close()
+	reader := codec.NewReader(conn, cbor.Limits{})
+	for {
+		msg, err := reader.Read()
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(queries, 1)
+		<-release
+		q := msg.Content[0].(*query.Name)
+		answer := message.Message{Token: msg.Token, Content: answers[q.Name]}
+		if err := (codec.CBOR{}).Encode(conn, &answer); err != nil {
+			return
+		}
+	}
+}
+
+func TestLookupBatch(t *testing.T) {
+	assertion := func(name string) []section.Section {
+		return []section.Section{&section.Assertion{SubjectName: name, SubjectZone: "ethz.ch.",
+			Context: ".", Content: []object.Object{object.Object{Type: object.OTIP4Addr,
+				Value: "192.0.2.80"}}}}
+	}
+	answers := map[string][]section.Section{
+		"www.ethz.ch.": assertion("www"),
+		"ftp.ethz.ch.": assertion("ftp"),
+	}
+	var queries int32
+	release := make(chan struct{})
+	r := New(nil, []net.Addr{&net.TCPAddr{IP: net.ParseIP("192.0.2.53"), Port: 55553}},
+		Forward, nil, 1)
+	r.Assertions = nil
+	r.Dialer = DialerFunc(func(ctx context.Context, addr net.Addr) (net.Conn, error) {
+		client, server := net.Pipe()
+		go gatedServer(server, answers, release, &queries)
+		return client, nil
+	})
+	defer r.Close()
+	names := []string{"www.ethz.ch.", "www.ethz.ch.", "ftp.ethz.ch.", "www.ethz.ch."}
+	var batch []*query.Name
+	for _, name := range names {
+		batch = append(batch, &query.Name{Name: name, Context: ".",
+			Types: []object.Type{object.OTIP4Addr}, Expiration: time.Now().Add(time.Minute).Unix()})
+	}
+	//give the workers time to start all lookups
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	results := r.LookupBatch(context.Background(), batch, len(batch))
+	for i, res := range results {
+		if res.Err != nil || res.Query != batch[i] || len(res.Answer.Content) != 1 ||
+			res.Answer.Content[0].(*section.Assertion).FQDN() != names[i] {
+			t.Errorf("%d: wrong result. expected=%s actual=%+v", i, names[i], res)
+		}
+	}
+	//the identical lookups of www.ethz.ch. share one query
+	if queries := atomic.LoadInt32(&queries); queries != 2 {
+		t.Errorf("wrong number of queries. expected=2 actual=%d", queries)
+	}
+
+	async := r.LookupAsync(context.Background(), batch[2])
+	res, ok := <-async
+	if !ok || res.Err != nil || res.Query != batch[2] || len(res.Answer.Content) != 1 {
+		t.Errorf("wrong asynchronous result: %+v", res)
+	}
+	if _, ok := <-async; ok {
+		t.Error("result channel was not closed")
+	}
+}
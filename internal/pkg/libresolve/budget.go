@@ -0,0 +1,88 @@
+package libresolve
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//Budget identifies one of the limits a lookup must stay within.
+type Budget int
+
+const (
+	//BudgetSteps limits the number of queries of a recursive lookup, see Resolver.MaxSteps.
+	BudgetSteps Budget = iota
+	//BudgetDepth limits the number of delegations followed from the root, see Resolver.MaxDepth.
+	BudgetDepth
+	//BudgetTime limits the duration of a lookup, see Resolver.LookupTimeout.
+	BudgetTime
+)
+
+func (b Budget) String() string {
+	switch b {
+	case BudgetDepth:
+		return "depth"
+	case BudgetTime:
+		return "time"
+	default:
+		return "step"
+	}
+}
+
+//BudgetExceededError is returned if a lookup was aborted because it exceeded one of the
+//resolver's limits.
+type BudgetExceededError struct {
+	//Name is the name of the query which could not be resolved.
+	Name  string
+	Limit Budget
+	//Max describes the exceeded limit, e.g. "32 queries".
+	Max string
+	//Steps contains the queries completed before the lookup was aborted, including the ones needed
+	//to look up glue records and to verify answers. Their redirections show how far the
+	//delegation chain was followed.
+	Steps []TraceStep
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("lookup for %s exceeded its %s budget of %s after %d queries", e.Name,
+		e.Limit, e.Max, len(e.Steps))
+}
+
+//withSteps returns a copy of e containing steps. e itself may be shared between the lookups of
+//identical queries.
+func (e *BudgetExceededError) withSteps(steps []TraceStep) *BudgetExceededError {
+	eCopy := *e
+	eCopy.Steps = steps
+	return &eCopy
+}
+
+//hopTimeout returns the time a server is given to answer a single query.
+func (r *Resolver) hopTimeout() time.Duration {
+	if r.HopTimeout > 0 {
+		return r.HopTimeout
+	}
+	return r.DialTimeout
+}
+
+//backoff waits before the retry-th retry of a query for RetryBackoff doubled retry-1 times, but at
+//most a minute. It returns an error if ctx is done before.
+func (r *Resolver) backoff(ctx context.Context, retry int) error {
+	if r.RetryBackoff <= 0 {
+		return ctx.Err()
+	}
+	wait := r.RetryBackoff
+	for i := 1; i < retry && wait < time.Minute; i++ {
+		wait *= 2
+	}
+	if wait > time.Minute {
+		wait = time.Minute
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
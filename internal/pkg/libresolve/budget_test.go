@@ -0,0 +1,91 @@
+package libresolve
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestBudgetExceeded(t *testing.T) {
+	servers := map[string]map[string][]section.Section{
+		rootIP: {"www.ethz.ch.": {redirection("ch", ".", "ns.ch."),
+			glue("ns", "ch.", "192.0.2.2")}},
+		"192.0.2.2": {"www.ethz.ch.": {redirection("ethz", "ch.", "ns.ethz.ch."),
+			glue("ns", "ethz.ch.", "192.0.2.3")}},
+		"192.0.2.3": {"www.ethz.ch.": {&section.Assertion{SubjectName: "www",
+			SubjectZone: "ethz.ch.", Context: ".", Content: []object.Object{object.Object{
+				Type: object.OTIP4Addr, Value: "192.0.2.80"}}}}},
+	}
+	var tests = []struct {
+		maxSteps, maxDepth int
+		timeout            time.Duration
+		//hang makes the root server never answer.
+		hang  bool
+		limit Budget
+		//steps is the number of queries reported in the error.
+		steps int
+	}{
+		{2, 16, 0, false, BudgetSteps, 2},
+		{32, 1, 0, false, BudgetDepth, 2},
+		{32, 16, 50 * time.Millisecond, true, BudgetTime, 1},
+	}
+	for i, test := range tests {
+		r := recursiveResolver(servers)
+		r.MaxSteps, r.MaxDepth, r.LookupTimeout = test.maxSteps, test.maxDepth, test.timeout
+		if test.hang {
+			r.HopTimeout = time.Minute
+			r.Dialer = DialerFunc(func(ctx context.Context, addr net.Addr) (net.Conn, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			})
+		}
+		q := &query.Name{Name: "www.ethz.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr},
+			Expiration: time.Now().Add(time.Minute).Unix()}
+		_, err := r.ClientLookup(context.Background(), q)
+		r.Close()
+		berr, ok := err.(*BudgetExceededError)
+		if !ok {
+			t.Errorf("%d: expected *BudgetExceededError. actual=%v", i, err)
+			continue
+		}
+		if berr.Limit != test.limit || berr.Name != q.Name || len(berr.Steps) != test.steps {
+			t.Errorf("%d: wrong error. expected limit=%s steps=%d actual=%v", i, test.limit,
+				test.steps, err)
+		}
+	}
+	r := recursiveResolver(servers)
+	defer r.Close()
+	q := &query.Name{Name: "www.ethz.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr},
+		Expiration: time.Now().Add(time.Minute).Unix()}
+	if answer, err := r.ClientLookup(context.Background(), q); err != nil ||
+		len(answer.Content) != 1 {
+		t.Errorf("lookup within the default budget failed. answer=%v error=%v", answer, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	r := New(nil, nil, Forward, nil, 1)
+	defer r.Close()
+	if err := r.backoff(context.Background(), 3); err != nil {
+		t.Errorf("backoff without RetryBackoff failed: %v", err)
+	}
+	r.RetryBackoff = time.Millisecond
+	start := time.Now()
+	if err := r.backoff(context.Background(), 3); err != nil {
+		t.Errorf("backoff failed: %v", err)
+	}
+	if waited := time.Since(start); waited < 4*time.Millisecond {
+		t.Errorf("backoff of the third retry too short: %v", waited)
+	}
+	r.RetryBackoff = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.backoff(ctx, 1); err != context.Canceled {
+		t.Errorf("backoff did not end with its context. actual=%v", err)
+	}
+}
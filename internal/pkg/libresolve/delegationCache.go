@@ -0,0 +1,149 @@
+package libresolve
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//referralCache remembers the authoritative servers of zones learned from the redirections received
+//in recursive lookups, such that later lookups below a zone can start at its servers. It is kept
+//apart from the assertion cache such that its entries are not evicted by end-entity answers and
+//are retained independently.
+type referralCache struct {
+	mux     sync.Mutex
+	entries map[zoneContext]referralEntry
+}
+
+//referralEntry contains the redirection targets and servers of a zone and the time until which
+//they are used.
+type referralEntry struct {
+	targets []string
+	servers []net.Addr
+	expires time.Time
+}
+
+func newReferralCache() *referralCache {
+	return &referralCache{entries: make(map[zoneContext]referralEntry)}
+}
+
+//add remembers e as the servers of zone in context. If the cache is full, e is only added if
+//expired entries can be removed.
+func (c *referralCache) add(zone, context string, e referralEntry) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	id := zoneKeyID(zone, context)
+	if _, ok := c.entries[id]; !ok && len(c.entries) >= defaultCacheSize {
+		now := clock.Now()
+		for key, e := range c.entries {
+			if e.expires.Before(now) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= defaultCacheSize {
+			return
+		}
+	}
+	c.entries[id] = e
+}
+
+//closest returns the most specific zone in context above name whose servers are known. name
+//itself is not considered as a zone's own delegation is served by its parent zone.
+func (c *referralCache) closest(name, context string) (string, referralEntry, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	now := clock.Now()
+	for _, zone, ok := splitName(name); ok && zone != "."; _, zone, ok = splitName(zone) {
+		if e, found := c.entries[zoneKeyID(zone, context)]; found && e.expires.After(now) {
+			return zone, e, true
+		}
+	}
+	return "", referralEntry{}, false
+}
+
+//remove forgets the servers of zone in context.
+func (c *referralCache) remove(zone, context string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.entries, zoneKeyID(zone, context))
+}
+
+//clear forgets the servers of all zones.
+func (c *referralCache) clear() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.entries = make(map[zoneContext]referralEntry)
+}
+
+//learnedReferral is a redirection to the servers of a zone which answered during a lookup. It is
+//remembered once its assertions are verified.
+type learnedReferral struct {
+	zone       string
+	context    string
+	targets    []string
+	servers    []net.Addr
+	assertions []*section.Assertion
+}
+
+//referralsKey is the context key under which the referrals learned during a lookup are collected.
+type referralsKey struct{}
+
+//referralRecorder collects the referrals learned during a lookup.
+type referralRecorder struct {
+	mux       sync.Mutex
+	referrals []learnedReferral
+}
+
+//learnReferral adds ref to the referrals of the lookup ctx belongs to.
+func learnReferral(ctx context.Context, ref learnedReferral) {
+	if recorder, ok := ctx.Value(referralsKey{}).(*referralRecorder); ok {
+		recorder.mux.Lock()
+		recorder.referrals = append(recorder.referrals, ref)
+		recorder.mux.Unlock()
+	}
+}
+
+//cacheReferrals remembers the servers of the referrals learned during the lookup ctx belongs to
+//for DelegationRetention, but at most until the signatures of their assertions expire. Unless
+//verification is disabled, a referral is only remembered if all its assertions can be verified.
+func (r *Resolver) cacheReferrals(ctx context.Context) {
+	recorder, ok := ctx.Value(referralsKey{}).(*referralRecorder)
+	if !ok || r.DelegationRetention <= 0 {
+		return
+	}
+	recorder.mux.Lock()
+	referrals := recorder.referrals
+	recorder.referrals = nil
+	recorder.mux.Unlock()
+	for _, ref := range referrals {
+		expires := clock.Now().Add(r.DelegationRetention)
+		verified := true
+		for _, a := range ref.assertions {
+			if r.Verification != NoVerification {
+				if err := r.verifier().VerifySection(ctx, a); err != nil {
+					log.Debug("redirection could not be verified, servers are not remembered",
+						"zone", ref.zone, "assertion", a, "error", err)
+					verified = false
+					break
+				}
+			}
+			if _, validUntil := sigValidity(a); validUntil != 0 &&
+				time.Unix(validUntil, 0).Before(expires) {
+				expires = time.Unix(validUntil, 0)
+			}
+		}
+		if verified {
+			r.referrals.add(ref.zone, ref.context, referralEntry{
+				targets: ref.targets,
+				servers: ref.servers,
+				expires: expires,
+			})
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package libresolve
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestReferralCache(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	defer clock.Set(fake)()
+	c := newReferralCache()
+	ch := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: testPort}
+	ethz := &net.TCPAddr{IP: net.ParseIP("192.0.2.3"), Port: testPort}
+	c.add("ch.", ".", referralEntry{targets: []string{"ns.ch."}, servers: []net.Addr{ch},
+		expires: fake.Now().Add(time.Hour)})
+	c.add("ethz.ch.", ".", referralEntry{targets: []string{"ns.ethz.ch."},
+		servers: []net.Addr{ethz}, expires: fake.Now().Add(time.Minute)})
+	var tests = []struct {
+		name, context string
+		//zone is the expected closest zone or empty if there is none.
+		zone string
+	}{
+		{"www.ethz.ch.", ".", "ethz.ch."},
+		{"ethz.ch.", ".", "ch."},
+		{"www.ch.", ".", "ch."},
+		{"ch.", ".", ""},
+		{"www.ethz.ch.", "other.", ""},
+		{"www.example.com.", ".", ""},
+	}
+	for i, test := range tests {
+		zone, _, ok := c.closest(test.name, test.context)
+		if ok != (test.zone != "") || zone != test.zone {
+			t.Errorf("%d: wrong closest zone of %s. expected=%q actual=%q", i, test.name,
+				test.zone, zone)
+		}
+	}
+	fake.Advance(2 * time.Minute)
+	if zone, e, ok := c.closest("www.ethz.ch.", "."); !ok || zone != "ch." || e.servers[0] != ch {
+		t.Errorf("expired entry was used. actual=%s %v", zone, e)
+	}
+	c.remove("ch.", ".")
+	if zone, _, ok := c.closest("www.ethz.ch.", "."); ok {
+		t.Errorf("removed entry was used. actual=%s", zone)
+	}
+}
+
+func TestDelegationRetention(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	defer clock.Set(fake)()
+	servers := map[string]map[string][]section.Section{
+		rootIP:      {},
+		"192.0.2.2": {},
+		"192.0.2.3": {},
+	}
+	for _, name := range []string{"www", "mail"} {
+		fqdn := name + ".ethz.ch."
+		servers[rootIP][fqdn] = []section.Section{redirection("ch", ".", "ns.ch."),
+			glue("ns", "ch.", "192.0.2.2")}
+		servers["192.0.2.2"][fqdn] = []section.Section{redirection("ethz", "ch.", "ns.ethz.ch."),
+			glue("ns", "ethz.ch.", "192.0.2.3")}
+		servers["192.0.2.3"][fqdn] = []section.Section{&section.Assertion{SubjectName: name,
+			SubjectZone: "ethz.ch.", Context: ".", Content: []object.Object{object.Object{
+				Type: object.OTIP4Addr, Value: "192.0.2.80"}}}}
+	}
+	r := recursiveResolver(servers)
+	defer r.Close()
+	r.DelegationRetention = time.Hour
+	var steps int
+	r.OnResolution = func(res Resolution) { steps = len(res.Steps) }
+	var tests = []struct {
+		name string
+		//advance is the time passed before the lookup.
+		advance time.Duration
+		steps   int
+		hits    int64
+	}{
+		{"www.ethz.ch.", 0, 3, 0},
+		{"mail.ethz.ch.", time.Minute, 1, 1},
+		{"mail.ethz.ch.", 2 * time.Hour, 3, 1},
+	}
+	for i, test := range tests {
+		fake.Advance(test.advance)
+		q := &query.Name{Name: test.name, Context: ".", Types: []object.Type{object.OTIP4Addr},
+			Expiration: fake.Now().Add(time.Minute).Unix()}
+		if _, err := r.ClientLookup(context.Background(), q); err != nil {
+			t.Fatalf("%d: lookup failed: %v", i, err)
+		}
+		if steps != test.steps || r.Stats().DelegationCacheHits != test.hits {
+			t.Errorf("%d: wrong delegation walk. expected steps=%d hits=%d actual steps=%d hits=%d",
+				i, test.steps, test.hits, steps, r.Stats().DelegationCacheHits)
+		}
+	}
+}
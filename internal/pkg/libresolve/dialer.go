@@ -0,0 +1,61 @@
+package libresolve
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+)
+
+//Dialer establishes connections to RAINS servers. It allows to use the resolver with transports
+//other than TLS over TCP, e.g. SCION or QUIC, over proxies or with in-memory connections in tests.
+//The returned connection must carry CBOR encoded messages in both directions.
+type Dialer interface {
+	DialContext(ctx context.Context, addr net.Addr) (net.Conn, error)
+}
+
+//DialerFunc is a function used as Dialer.
+type DialerFunc func(ctx context.Context, addr net.Addr) (net.Conn, error)
+
+//DialContext calls f(ctx, addr).
+func (f DialerFunc) DialContext(ctx context.Context, addr net.Addr) (net.Conn, error) {
+	return f(ctx, addr)
+}
+
+//TLSDialer connects to servers over TLS on TCP or Unix sockets, depending on the type of the
+//address. This is the transport used by rainsd.
+type TLSDialer struct {
+	//Config is used for the TLS handshake. If it is nil, the server's certificate is not verified.
+	Config *tls.Config
+}
+
+//DialContext connects to addr, which must be a *net.TCPAddr or a *net.UnixAddr.
+func (d TLSDialer) DialContext(ctx context.Context, addr net.Addr) (net.Conn, error) {
+	return connection.CreateTLSConnectionContext(ctx, addr, d.Config)
+}
+
+//DialAddr is the address of a root server or forwarder which is reached with its own Dialer
+//instead of the resolver's. It is used as *DialAddr in Resolver.RootNameServers and
+//Resolver.Forwarders.
+type DialAddr struct {
+	net.Addr
+	Dialer Dialer
+}
+
+//NewDialAddr returns the address of a server at addr which is reached with dialer.
+func NewDialAddr(addr net.Addr, dialer Dialer) *DialAddr {
+	return &DialAddr{Addr: addr, Dialer: dialer}
+}
+
+//dial connects to addr with the dialer of addr if it is a *DialAddr, otherwise with the resolver's
+//Dialer or, if it is nil, a TLSDialer.
+func (r *Resolver) dial(ctx context.Context, addr net.Addr) (net.Conn, error) {
+	if a, ok := addr.(*DialAddr); ok {
+		return a.Dialer.DialContext(ctx, a.Addr)
+	}
+	if r.Dialer != nil {
+		return r.Dialer.DialContext(ctx, addr)
+	}
+	return TLSDialer{}.DialContext(ctx, addr)
+}
@@ -0,0 +1,82 @@
+package libresolve
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//recordingDialer connects to in-memory servers answering with the sections stored in servers under
+//their ip address and the queried name. It records the addresses it dialed.
+type recordingDialer struct {
+	mux     sync.Mutex
+	servers map[string]map[string][]section.Section
+	dialed  []string
+}
+
+func (d *recordingDialer) DialContext(ctx context.Context, addr net.Addr) (net.Conn, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	ip := addr.(*net.TCPAddr).IP.String()
+	d.dialed = append(d.dialed, ip)
+	client, server := net.Pipe()
+	go verifyingServer(server, d.servers[ip])
+	return client, nil
+}
+
+func (d *recordingDialer) addrs() []string {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return append([]string(nil), d.dialed...)
+}
+
+func TestDialAddr(t *testing.T) {
+	servers := map[string]map[string][]section.Section{
+		rootIP: {"www.ethz.ch.": {redirection("ch", ".", "ns.ch."),
+			glue("ns", "ch.", "192.0.2.2")}},
+		"192.0.2.2": {"www.ethz.ch.": {redirection("ethz", "ch.", "ns.ethz.ch."),
+			glue("ns", "ethz.ch.", "192.0.2.3")}},
+		"192.0.2.3": {"www.ethz.ch.": {&section.Assertion{SubjectName: "www",
+			SubjectZone: "ethz.ch.", Context: ".", Content: []object.Object{object.Object{
+				Type: object.OTIP4Addr, Value: "192.0.2.80"}}}}},
+	}
+	root := &net.TCPAddr{IP: net.ParseIP(rootIP), Port: testPort}
+	var tests = []struct {
+		mode ResolutionMode
+		//upstream is the dialer of the root server or forwarder, resolver the resolver's own.
+		upstream, resolver []string
+	}{
+		{Recursive, []string{rootIP}, []string{"192.0.2.2", "192.0.2.3"}},
+		{Forward, []string{rootIP}, nil},
+	}
+	for i, test := range tests {
+		upstream := &recordingDialer{servers: servers}
+		resolver := &recordingDialer{servers: servers}
+		addrs := []net.Addr{NewDialAddr(root, upstream)}
+		r := New(addrs, addrs, test.mode, nil, 1)
+		r.Assertions, r.NegAssertions, r.DelegationRetention = nil, nil, 0
+		r.Dialer = resolver
+		q := &query.Name{Name: "www.ethz.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr},
+			Expiration: time.Now().Add(time.Minute).Unix()}
+		_, err := r.ClientLookup(context.Background(), q)
+		r.Close()
+		if test.mode == Recursive && err != nil {
+			t.Errorf("%d: lookup failed: %v", i, err)
+		}
+		if got := upstream.addrs(); !reflect.DeepEqual(got, test.upstream) {
+			t.Errorf("%d: wrong addresses dialed with the upstream dialer. expected=%v actual=%v",
+				i, test.upstream, got)
+		}
+		if got := resolver.addrs(); !reflect.DeepEqual(got, test.resolver) {
+			t.Errorf("%d: wrong addresses dialed with the resolver's dialer. expected=%v actual=%v",
+				i, test.resolver, got)
+		}
+	}
+}
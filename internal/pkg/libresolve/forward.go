@@ -0,0 +1,177 @@
+package libresolve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//rttStats keeps track of the smoothed round trip time of each server.
+type rttStats struct {
+	mux sync.Mutex
+	rtt map[string]time.Duration
+}
+
+func newRTTStats() *rttStats {
+	return &rttStats{rtt: make(map[string]time.Duration)}
+}
+
+//update adds the round trip time of a query to addr. As in TCP, the smoothed round trip time
+//moves by an eighth of the difference towards the new sample.
+func (f *rttStats) update(addr net.Addr, rtt time.Duration) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if srtt, ok := f.rtt[addr.String()]; ok {
+		f.rtt[addr.String()] = srtt + (rtt-srtt)/8
+	} else {
+		f.rtt[addr.String()] = rtt
+	}
+}
+
+//order returns the forwarders sorted by their smoothed round trip time. Forwarders which have not
+//been queried yet come first in their configured order such that they get measured.
+func (f *rttStats) order(forwarders []net.Addr) []net.Addr {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	sorted := append([]net.Addr(nil), forwarders...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return f.rtt[sorted[i].String()] < f.rtt[sorted[j].String()]
+	})
+	return sorted
+}
+
+//ForwarderRTTs returns the smoothed round trip time of each forwarder which has been queried. A
+//forwarder which failed is accounted with the full HopTimeout and one which was outrun by another
+//forwarder with the time it had to answer.
+func (r *Resolver) ForwarderRTTs() map[string]time.Duration {
+	r.forwarders.mux.Lock()
+	defer r.forwarders.mux.Unlock()
+	rtts := make(map[string]time.Duration)
+	for addr, rtt := range r.forwarders.rtt {
+		rtts[addr] = rtt
+	}
+	return rtts
+}
+
+//forwardResult is the outcome of a query sent to a single forwarder.
+type forwardResult struct {
+	forwarder net.Addr
+	answer    message.Message
+	err       error
+}
+
+//forwardQuery sends q to the forwarders, see forwardRace. If none of them answers, the race is
+//repeated up to Retries times, see backoff.
+func (r *Resolver) forwardQuery(parent context.Context, q *query.Name) (*message.Message, error) {
+	if _, forwarders := r.upstream(); len(forwarders) == 0 {
+		return nil, errors.New("forwarders must be specified to use this mode")
+	}
+	for retry := 1; ; retry++ {
+		answer, err := r.forwardRace(parent, q)
+		if err == nil || parent.Err() != nil || retry > r.Retries {
+			return answer, err
+		}
+		log.Debug("no forwarder answered, retrying after backoff", "query", q, "retry", retry,
+			"error", err)
+		if werr := r.backoff(parent, retry); werr != nil {
+			return nil, fmt.Errorf("forwarding query %s aborted: %v", q.Name, werr)
+		}
+	}
+}
+
+//forwardRace sends q to the forwarders, the fastest first, starting the next one each
+//ForwarderStagger or as soon as a forwarder failed. The first answer is returned and the
+//remaining queries are cancelled. In Strict mode, only answers which could be verified are
+//accepted. The forwarders are given HopTimeout to answer unless parent is done earlier.
+func (r *Resolver) forwardRace(parent context.Context, q *query.Name) (*message.Message, error) {
+	ctx, cancel := context.WithTimeout(parent, r.hopTimeout())
+	defer cancel()
+	_, upstream := r.upstream()
+	forwarders := r.forwarders.order(upstream)
+	results := make(chan forwardResult, len(forwarders))
+	//outstanding contains the launch time of each forwarder which has not yet answered.
+	outstanding := make(map[net.Addr]time.Time)
+	next := 0
+	var lastLaunch time.Time
+	launch := func() {
+		go r.askForwarder(ctx, forwarders[next], q, results)
+		lastLaunch = time.Now()
+		outstanding[forwarders[next]] = lastLaunch
+		next++
+	}
+	//Forwarders which did not answer in time are accounted with the time they had. This also
+	//covers the ones which are still establishing their connection, which is not cancellable.
+	defer func() {
+		for forwarder, launched := range outstanding {
+			r.forwarders.update(forwarder, time.Since(launched))
+		}
+	}()
+	launch()
+	err := errors.New("no forwarder answered")
+	for len(outstanding) > 0 {
+		var stagger <-chan time.Time
+		if next < len(forwarders) {
+			stagger = time.After(time.Until(lastLaunch.Add(r.ForwarderStagger)))
+		}
+		select {
+		case <-stagger:
+			launch()
+		case res := <-results:
+			delete(outstanding, res.forwarder)
+			if res.err == nil {
+				log.Debug("forwarder answered first", "forwarder", res.forwarder, "query", q)
+				return &res.answer, nil
+			}
+			log.Warn("forwarder did not answer", "forwarder", res.forwarder, "error", res.err)
+			err = res.err
+			if next < len(forwarders) {
+				launch()
+			}
+		case <-ctx.Done():
+			if parent.Err() != nil {
+				return nil, fmt.Errorf("forwarding query %s aborted: %v", q.Name, parent.Err())
+			}
+			return nil, fmt.Errorf("no forwarder answered within %v", r.hopTimeout())
+		}
+	}
+	return nil, fmt.Errorf("could not obtain an answer from any of the forwarders %v: %v",
+		forwarders, err)
+}
+
+//askForwarder sends q to forwarder and reports the outcome on results. The round trip time is
+//recorded if the forwarder answered or failed before the query was cancelled.
+func (r *Resolver) askForwarder(ctx context.Context, forwarder net.Addr, q *query.Name,
+	results chan<- forwardResult) {
+	msg := message.Message{Token: token.New(), Content: []section.Section{q}}
+	start := time.Now()
+	answer, err := r.pool.query(ctx, msg, forwarder, r.IdleTimeout, r.dial, r.Codec)
+	if err == nil || ctx.Err() == nil {
+		r.trace(ctx, TraceStep{Server: forwarder, RTT: time.Since(start), Token: msg.Token,
+			Answer: answer, Err: err})
+	}
+	if err == nil {
+		r.forwarders.update(forwarder, time.Since(start))
+		if r.Verification == Strict {
+			for _, verr := range r.verifyAnswer(ctx, &answer) {
+				if verr != nil {
+					err = fmt.Errorf("answer could not be verified: %v", verr)
+					break
+				}
+			}
+		}
+	} else if ctx.Err() == nil {
+		r.forwarders.update(forwarder, r.hopTimeout())
+	}
+	results <- forwardResult{forwarder: forwarder, answer: answer, err: err}
+}
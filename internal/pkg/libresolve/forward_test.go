@@ -0,0 +1,102 @@
+package libresolve
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestRTTStats(t *testing.T) {
+	a := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 55553}
+	b := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 55553}
+	c := &net.TCPAddr{IP: net.ParseIP("192.0.2.3"), Port: 55553}
+	stats := newRTTStats()
+	stats.update(a, 80*time.Millisecond)
+	stats.update(a, 160*time.Millisecond)
+	stats.update(b, 50*time.Millisecond)
+	if rtt := stats.rtt[a.String()]; rtt != 90*time.Millisecond {
+		t.Errorf("wrong smoothed rtt. expected=%v actual=%v", 90*time.Millisecond, rtt)
+	}
+	//c has not been queried yet and comes first.
+	order := stats.order([]net.Addr{a, b, c})
+	if order[0] != c || order[1] != b || order[2] != a {
+		t.Errorf("wrong order. expected=[%v %v %v] actual=%v", c, b, a, order)
+	}
+}
+
+//silentServer counts the queries received on conn without answering them and closes cancelled
+//when conn is closed by the client.
+func silentServer(conn net.Conn, queries *int32, cancelled chan<- struct{}) {
+	defer conn.Close()
+	reader := codec.NewReader(conn, cbor.Limits{})
+	for {
+		if _, err := reader.Read(); err != nil {
+			close(cancelled)
+			return
+		}
+		atomic.AddInt32(queries, 1)
+	}
+}
+
+func TestForwardRace(t *testing.T) {
+	slow := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 55553}
+	fast := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 55553}
+	k := newTestKey(0)
+	answers := map[string][]section.Section{"www.ethz.ch.": []section.Section{
+		testAssertion(t, "www", "ethz.ch.", k,
+			object.Object{Type: object.OTIP4Addr, Value: "192.0.2.80"})}}
+	var slowQueries int32
+	cancelled := make(chan struct{})
+	r := New(nil, []net.Addr{slow, fast}, Forward, nil, 1)
+	r.Assertions = nil
+	r.IdleTimeout = 0
+	r.ForwarderStagger = 20 * time.Millisecond
+	r.Dialer = DialerFunc(func(ctx context.Context, addr net.Addr) (net.Conn, error) {
+		client, server := net.Pipe()
+		if addr.String() == slow.String() {
+			go silentServer(server, &slowQueries, cancelled)
+		} else {
+			go verifyingServer(server, answers)
+		}
+		return client, nil
+	})
+	defer r.Close()
+	q := &query.Name{Name: "www.ethz.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr},
+		Expiration: time.Now().Add(time.Minute).Unix()}
+
+	//The slow forwarder is asked first and outrun by the fast one after the stagger.
+	start := time.Now()
+	answer, err := r.ClientLookup(context.Background(), q)
+	if err != nil || len(answer.Content) != 1 {
+		t.Fatalf("lookup failed. answer=%v error=%v", answer, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the slow forwarder delayed the answer by %v", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("the query to the slow forwarder was not cancelled")
+	}
+	rtts := r.ForwarderRTTs()
+	if rtts[fast.String()] >= rtts[slow.String()] {
+		t.Errorf("the slow forwarder has a smaller rtt: %v", rtts)
+	}
+
+	//The fast forwarder is asked first now and answers before the slow one is started.
+	r.ForwarderStagger = time.Second
+	if _, err := r.ClientLookup(context.Background(), q); err != nil {
+		t.Fatalf("second lookup failed: %v", err)
+	}
+	if queries := atomic.LoadInt32(&slowQueries); queries != 1 {
+		t.Errorf("wrong number of queries to the slow forwarder. expected=1 actual=%d", queries)
+	}
+}
@@ -0,0 +1,104 @@
+package libresolve
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+)
+
+//inflight deduplicates identical queries which are resolved at the same time such that only one
+//of them is sent upstream.
+type inflight struct {
+	mux   sync.Mutex
+	calls map[string]*inflightCall
+}
+
+//inflightCall is a query being resolved. done is closed once answer, err and aborted are set.
+type inflightCall struct {
+	done   chan struct{}
+	answer *message.Message
+	err    error
+	//aborted is true if the resolution failed because the context of its caller was done.
+	aborted bool
+}
+
+func newInflight() *inflight {
+	return &inflight{calls: make(map[string]*inflightCall)}
+}
+
+//inflightKey returns the key of q. Queries for the same name, context and types are identical
+//regardless of the order of their types. The order of the options matters as it expresses their
+//priority.
+func inflightKey(q *query.Name) string {
+	types := append([]object.Type(nil), q.Types...)
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return fmt.Sprintf("%s %s %v %v", q.Context, q.Name, types, q.Options)
+}
+
+//do calls resolve unless an identical query is already being resolved, in which case its outcome
+//is awaited and shared is true. As answers are modified during verification, each waiting caller
+//obtains its own copy of the answer. The identical query is resolved with the context of the caller
+//who started it. If it is aborted because that context is done, a waiting caller whose context is
+//not done resolves the query itself.
+func (f *inflight) do(ctx context.Context, q *query.Name,
+	resolve func() (*message.Message, error)) (answer *message.Message, shared bool, err error) {
+	key := inflightKey(q)
+	for {
+		f.mux.Lock()
+		c, ok := f.calls[key]
+		if !ok {
+			break
+		}
+		f.mux.Unlock()
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		if c.aborted && ctx.Err() == nil {
+			continue
+		}
+		if c.err != nil {
+			return nil, true, c.err
+		}
+		answer, err = copyMessage(c.answer)
+		return answer, true, err
+	}
+	c := &inflightCall{done: make(chan struct{})}
+	f.calls[key] = c
+	f.mux.Unlock()
+
+	answer, err = resolve()
+	if err == nil {
+		//the copy for waiting callers must be taken before answer is returned and modified
+		c.answer, c.err = copyMessage(answer)
+	} else {
+		c.err = err
+		c.aborted = ctx.Err() != nil
+	}
+	f.mux.Lock()
+	delete(f.calls, key)
+	f.mux.Unlock()
+	close(c.done)
+	return answer, false, err
+}
+
+//copyMessage returns a deep copy of msg obtained by encoding and decoding it.
+func copyMessage(msg *message.Message) (*message.Message, error) {
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(msg); err != nil {
+		return nil, fmt.Errorf("could not copy answer: %v", err)
+	}
+	msgCopy := &message.Message{}
+	if err := cbor.NewReader(encoding).Unmarshal(msgCopy); err != nil {
+		return nil, fmt.Errorf("could not copy answer: %v", err)
+	}
+	return msgCopy, nil
+}
@@ -0,0 +1,145 @@
+package libresolve
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+func TestInflightKey(t *testing.T) {
+	q := &query.Name{Name: "www.ethz.ch.", Context: ".",
+		Types: []object.Type{object.OTIP4Addr, object.OTIP6Addr}}
+	var tests = []struct {
+		other     *query.Name
+		identical bool
+	}{
+		{&query.Name{Name: "www.ethz.ch.", Context: ".",
+			Types: []object.Type{object.OTIP6Addr, object.OTIP4Addr}}, true},
+		{&query.Name{Name: "www.ethz.ch.", Context: ".",
+			Types: []object.Type{object.OTIP4Addr, object.OTIP6Addr}, Expiration: 1}, true},
+		{&query.Name{Name: "ftp.ethz.ch.", Context: ".",
+			Types: []object.Type{object.OTIP4Addr, object.OTIP6Addr}}, false},
+		{&query.Name{Name: "www.ethz.ch.", Context: "cx-ethz.ch.",
+			Types: []object.Type{object.OTIP4Addr, object.OTIP6Addr}}, false},
+		{&query.Name{Name: "www.ethz.ch.", Context: ".",
+			Types: []object.Type{object.OTIP4Addr}}, false},
+		{&query.Name{Name: "www.ethz.ch.", Context: ".",
+			Types:   []object.Type{object.OTIP4Addr, object.OTIP6Addr},
+			Options: []query.Option{query.QOCachedAnswersOnly}}, false},
+	}
+	for i, test := range tests {
+		if (inflightKey(q) == inflightKey(test.other)) != test.identical {
+			t.Errorf("%d: wrong key. expected identical=%t actual=%s and %s", i, test.identical,
+				inflightKey(q), inflightKey(test.other))
+		}
+	}
+}
+
+func TestInflightDo(t *testing.T) {
+	f := newInflight()
+	q := &query.Name{Name: "www.ethz.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr}}
+	answer := &message.Message{Token: token.New(), Content: []section.Section{
+		&section.Assertion{SubjectName: "www", SubjectZone: "ethz.ch.", Context: ".",
+			Content: []object.Object{object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}}}}}
+	var calls int32
+	started, release := make(chan struct{}), make(chan struct{})
+	resolve := func() (*message.Message, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return answer, nil
+	}
+	const callers = 10
+	answers := make([]*message.Message, callers)
+	shared := make([]bool, callers)
+	var wg sync.WaitGroup
+	wg.Add(callers)
+	go func() {
+		defer wg.Done()
+		answers[0], shared[0], _ = f.do(context.Background(), q, resolve)
+	}()
+	<-started
+	for i := 1; i < callers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			answers[i], shared[i], _ = f.do(context.Background(), q, resolve)
+		}(i)
+	}
+	//give the callers time to find the pending resolution
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("identical queries were resolved %d times", calls)
+	}
+	for i := range answers {
+		if answers[i] == nil || answers[i].Token != answer.Token || shared[i] != (i > 0) {
+			t.Errorf("%d: wrong outcome. shared=%t answer=%v", i, shared[i], answers[i])
+		}
+		if i > 0 && (answers[i] == answer || answers[i] == answers[i-1]) {
+			t.Errorf("%d: waiting caller did not obtain its own copy of the answer", i)
+		}
+	}
+	//Once resolved, the query is not shared anymore.
+	if _, shared, _ := f.do(context.Background(), q, resolve); shared || calls != 2 {
+		t.Errorf("completed resolution was shared. shared=%t calls=%d", shared, calls)
+	}
+}
+
+func TestInflightDoError(t *testing.T) {
+	q := &query.Name{Name: "www.ethz.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr}}
+	errFailed := errors.New("failed")
+	var tests = []struct {
+		err     error
+		aborted bool
+	}{
+		{errFailed, false},
+		{context.Canceled, true},
+	}
+	for i, test := range tests {
+		f := newInflight()
+		started, release := make(chan struct{}), make(chan struct{})
+		leaderCtx, cancel := context.WithCancel(context.Background())
+		leaderErr := test.err
+		go f.do(leaderCtx, q, func() (*message.Message, error) {
+			close(started)
+			<-release
+			return nil, leaderErr
+		})
+		<-started
+		done := make(chan error)
+		var calls int32
+		go func() {
+			_, _, err := f.do(context.Background(), q, func() (*message.Message, error) {
+				atomic.AddInt32(&calls, 1)
+				return &message.Message{}, nil
+			})
+			done <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		if test.aborted {
+			cancel()
+		}
+		close(release)
+		err := <-done
+		//A failure is shared, but a resolution aborted because its caller gave up is repeated by
+		//a waiting caller.
+		if test.aborted && (err != nil || calls != 1) {
+			t.Errorf("%d: aborted resolution was not repeated. calls=%d error=%v", i, calls, err)
+		}
+		if !test.aborted && (err != errFailed || calls != 0) {
+			t.Errorf("%d: failure was not shared. calls=%d error=%v", i, calls, err)
+		}
+		cancel()
+	}
+}
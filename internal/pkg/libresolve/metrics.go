@@ -0,0 +1,166 @@
+package libresolve
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+)
+
+//Origin indicates how the answer of a lookup was obtained.
+type Origin int
+
+const (
+	OriginNetwork Origin = iota
+	OriginCache
+	OriginOverride
+)
+
+func (o Origin) String() string {
+	switch o {
+	case OriginCache:
+		return "cache"
+	case OriginOverride:
+		return "override"
+	default:
+		return "network"
+	}
+}
+
+//Resolution describes a completed lookup. It is passed to Resolver.OnResolution.
+type Resolution struct {
+	Query  *query.Name
+	Answer *message.Message
+	//Err is set if the lookup failed. Answer is nil in this case.
+	Err      error
+	Origin   Origin
+	Duration time.Duration
+	//Steps contains all queries sent during the lookup in the order they were completed,
+	//including the ones needed to verify the answer.
+	Steps []TraceStep
+}
+
+//Stats contains the counters of a resolver since its creation.
+type Stats struct {
+	//Lookups is the number of lookups through ClientLookup and ServerLookup.
+	Lookups int64
+	//Failures is the number of lookups which returned an error.
+	Failures int64
+	//CacheHits is the number of lookups answered from the cache.
+	CacheHits int64
+	//OverrideHits is the number of lookups answered from the static overrides.
+	OverrideHits int64
+	//Queries is the number of queries sent to servers and QueryFailures the number of them which
+	//were not answered.
+	Queries       int64
+	QueryFailures int64
+	//VerificationFailures is the number of received sections whose signatures could not be
+	//verified.
+	VerificationFailures int64
+	//SharedResolutions is the number of resolutions which sent no query as an identical one was
+	//already in flight and its answer could be shared.
+	SharedResolutions int64
+	//DelegationCacheHits is the number of recursive resolutions which started at the remembered
+	//servers of a zone instead of at the root servers.
+	DelegationCacheHits int64
+	//ServerRTTs contains the smoothed round trip time of each server which answered a query.
+	ServerRTTs map[string]time.Duration
+}
+
+//metrics contains the counters of a resolver. They are updated atomically.
+type metrics struct {
+	lookups              int64
+	failures             int64
+	cacheHits            int64
+	overrideHits         int64
+	queries              int64
+	queryFailures        int64
+	verificationFailures int64
+	sharedResolutions    int64
+	delegationCacheHits  int64
+	rtts                 *rttStats
+}
+
+func newMetrics() *metrics {
+	return &metrics{rtts: newRTTStats()}
+}
+
+//Stats returns a snapshot of the resolver's counters such that applications can export them to
+//their monitoring.
+func (r *Resolver) Stats() Stats {
+	stats := Stats{
+		Lookups:              atomic.LoadInt64(&r.metrics.lookups),
+		Failures:             atomic.LoadInt64(&r.metrics.failures),
+		CacheHits:            atomic.LoadInt64(&r.metrics.cacheHits),
+		OverrideHits:         atomic.LoadInt64(&r.metrics.overrideHits),
+		Queries:              atomic.LoadInt64(&r.metrics.queries),
+		QueryFailures:        atomic.LoadInt64(&r.metrics.queryFailures),
+		VerificationFailures: atomic.LoadInt64(&r.metrics.verificationFailures),
+		SharedResolutions:    atomic.LoadInt64(&r.metrics.sharedResolutions),
+		DelegationCacheHits:  atomic.LoadInt64(&r.metrics.delegationCacheHits),
+		ServerRTTs:           make(map[string]time.Duration),
+	}
+	r.metrics.rtts.mux.Lock()
+	defer r.metrics.rtts.mux.Unlock()
+	for addr, rtt := range r.metrics.rtts.rtt {
+		stats.ServerRTTs[addr] = rtt
+	}
+	return stats
+}
+
+//countLookup updates the counters after a lookup with the given origin and error.
+func (m *metrics) countLookup(origin Origin, err error) {
+	atomic.AddInt64(&m.lookups, 1)
+	switch {
+	case err != nil:
+		atomic.AddInt64(&m.failures, 1)
+	case origin == OriginCache:
+		atomic.AddInt64(&m.cacheHits, 1)
+	case origin == OriginOverride:
+		atomic.AddInt64(&m.overrideHits, 1)
+	}
+}
+
+//stepsKey is the context key under which the steps of a lookup are collected.
+type stepsKey struct{}
+
+//stepRecorder collects the steps of a lookup. Steps can be added concurrently when forwarders are
+//raced.
+type stepRecorder struct {
+	mux   sync.Mutex
+	steps []TraceStep
+}
+
+//stepTraceKey is the context key under which the function tracing the steps of a lookup is stored.
+type stepTraceKey struct{}
+
+//WithTrace returns a copy of ctx with which trace is called after each query sent to a server
+//during lookups, in addition to the resolver's Trace, e.g. to trace the lookups of a single query.
+func WithTrace(ctx context.Context, trace func(step TraceStep)) context.Context {
+	return context.WithValue(ctx, stepTraceKey{}, trace)
+}
+
+//trace accounts step in the resolver's counters, adds it to the steps of the lookup ctx belongs to
+//and passes it to the function set with WithTrace and to r.Trace if they are set.
+func (r *Resolver) trace(ctx context.Context, step TraceStep) {
+	atomic.AddInt64(&r.metrics.queries, 1)
+	if step.Err != nil {
+		atomic.AddInt64(&r.metrics.queryFailures, 1)
+	} else {
+		r.metrics.rtts.update(step.Server, step.RTT)
+	}
+	if recorder, ok := ctx.Value(stepsKey{}).(*stepRecorder); ok {
+		recorder.mux.Lock()
+		recorder.steps = append(recorder.steps, step)
+		recorder.mux.Unlock()
+	}
+	if trace, ok := ctx.Value(stepTraceKey{}).(func(TraceStep)); ok {
+		trace(step)
+	}
+	if r.Trace != nil {
+		r.Trace(step)
+	}
+}
@@ -0,0 +1,72 @@
+package libresolve
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestMetrics(t *testing.T) {
+	k := newTestKey(0)
+	ip := object.Object{Type: object.OTIP4Addr, Value: "192.0.2.80"}
+	answers := map[string][]section.Section{
+		"www.ethz.ch.": []section.Section{testAssertion(t, "www", "ethz.ch.", k, ip)},
+		"ftp.ethz.ch.": []section.Section{testAssertion(t, "ftp", "ethz.ch.", k, ip)},
+	}
+	//forwarder is the address of the forwarder of cachingResolver.
+	const forwarder = "192.0.2.53:55553"
+	var origins []Origin
+	r := cachingResolver(answers, &origins)
+	defer r.Close()
+	var resolutions []Resolution
+	r.OnResolution = func(res Resolution) { resolutions = append(resolutions, res) }
+	r.AddOverride("local.", ".", ip)
+	var traced []TraceStep
+	ctx := WithTrace(context.Background(), func(step TraceStep) { traced = append(traced, step) })
+	var tests = []struct {
+		name   string
+		strict bool
+		origin Origin
+		steps  int
+		failed bool
+	}{
+		{"www.ethz.ch.", false, OriginNetwork, 1, false},
+		{"www.ethz.ch.", false, OriginCache, 0, false},
+		{"local.", false, OriginOverride, 0, false},
+		//there is no trust anchor to verify the answer
+		{"ftp.ethz.ch.", true, OriginNetwork, 1, true},
+	}
+	for i, test := range tests {
+		if test.strict {
+			r.Verification = Strict
+		}
+		q := &query.Name{Name: test.name, Context: ".", Types: []object.Type{object.OTIP4Addr},
+			Expiration: time.Now().Add(time.Minute).Unix()}
+		traced = nil
+		_, err := r.ClientLookup(ctx, q)
+		if (err != nil) != test.failed {
+			t.Errorf("%d: wrong outcome. expected failed=%t actual=%v", i, test.failed, err)
+		}
+		res := resolutions[len(resolutions)-1]
+		if res.Query != q || res.Origin != test.origin || (res.Err != nil) != test.failed ||
+			len(res.Steps) < test.steps || len(res.Steps) != len(traced) {
+			t.Errorf("%d: wrong resolution. expected origin=%v steps=%d actual=%+v traced=%d", i,
+				test.origin, test.steps, res, len(traced))
+		}
+		if len(res.Steps) > 0 && res.Steps[0].Server.String() != forwarder {
+			t.Errorf("%d: wrong server in first step. actual=%v", i, res.Steps[0].Server)
+		}
+	}
+	stats := r.Stats()
+	if stats.Lookups != 4 || stats.Failures != 1 || stats.CacheHits != 1 ||
+		stats.OverrideHits != 1 || stats.Queries < 2 || stats.VerificationFailures < 1 {
+		t.Errorf("wrong counters: %+v", stats)
+	}
+	if _, ok := stats.ServerRTTs[forwarder]; !ok {
+		t.Errorf("round trip time of the forwarder is missing: %v", stats.ServerRTTs)
+	}
+}
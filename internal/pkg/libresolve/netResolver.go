@@ -0,0 +1,416 @@
+package libresolve
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//maxAliases is the maximum number of name objects followed in a lookup. It protects against alias
+//loops.
+const maxAliases = 8
+
+//Source indicates whether the result of a lookup was obtained over RAINS or DNS.
+type Source int
+
+const (
+	SourceRAINS Source = iota
+	SourceDNS
+)
+
+func (s Source) String() string {
+	if s == SourceDNS {
+		return "DNS"
+	}
+	return "RAINS"
+}
+
+//NetResolver provides the lookup methods of net.Resolver on top of a RAINS resolver such that Go
+//programs can switch their name resolution to RAINS with minimal changes. Errors are of type
+//*net.DNSError. Name objects are followed like CNAME records in DNS.
+type NetResolver struct {
+	Resolver *Resolver
+	//Context is the context in which names are looked up. The global context "." is used if it is
+	//empty.
+	Context string
+	//DNSFallback, if not nil, is used to resolve names whose RAINS lookup failed, e.g. because
+	//their zone is not reachable over RAINS. Use net.DefaultResolver for the system's DNS
+	//configuration. LookupObjects has no fallback as DNS does not know RAINS' object types.
+	DNSFallback *net.Resolver
+	//OnFallback, if not nil, is called before name is resolved over DNS because its RAINS lookup
+	//failed with err. It allows to mark results which were not obtained over RAINS.
+	OnFallback func(name string, err error)
+}
+
+//AddrResult contains the addresses of a host together with the assertions they were taken from.
+type AddrResult struct {
+	//Addrs contains the addresses sorted by SortAddrs such that the preferred one comes first.
+	Addrs []net.IPAddr
+	//Name is the fully qualified name at which the addresses were found after following name
+	//objects.
+	Name string
+	//Assertions contains the assertions about Name containing the addresses.
+	Assertions []*section.Assertion
+}
+
+//NewNetResolver returns a NetResolver looking up names in context with r.
+func NewNetResolver(r *Resolver, context string) *NetResolver {
+	return &NetResolver{Resolver: r, Context: context}
+}
+
+//LookupHost looks up the given host and returns its IPv6 and IPv4 addresses, the preferred one
+//first.
+func (n *NetResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, nil
+	}
+	res, err := n.lookupAddrs(ctx, host, []object.Type{object.OTIP6Addr, object.OTIP4Addr})
+	if err != nil {
+		if n.fallback(ctx, host, err) {
+			return n.DNSFallback.LookupHost(ctx, host)
+		}
+		return nil, err
+	}
+	addrs := make([]string, len(res.Addrs))
+	for i, addr := range res.Addrs {
+		addrs[i] = addr.String()
+	}
+	return addrs, nil
+}
+
+//LookupAddrs looks up the IPv6 and IPv4 addresses of host. Besides the addresses in the order in
+//which they should be dialed, it returns the assertions containing them. There is no DNS fallback
+//as the result of DNS contains no assertions.
+func (n *NetResolver) LookupAddrs(ctx context.Context, host string) (AddrResult, error) {
+	return n.lookupAddrs(ctx, host, []object.Type{object.OTIP6Addr, object.OTIP4Addr})
+}
+
+//LookupIPAddr looks up the given host and returns its IPv6 and IPv4 addresses, the preferred one
+//first.
+func (n *NetResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, _, err := n.LookupIPAddrSource(ctx, host)
+	return addrs, err
+}
+
+//LookupIPAddrSource works like LookupIPAddr but also returns whether the addresses were obtained
+//over RAINS or through the DNS fallback.
+func (n *NetResolver) LookupIPAddrSource(ctx context.Context, host string) ([]net.IPAddr, Source,
+	error) {
+	res, err := n.lookupAddrs(ctx, host, []object.Type{object.OTIP6Addr, object.OTIP4Addr})
+	if err != nil && n.fallback(ctx, host, err) {
+		addrs, err := n.DNSFallback.LookupIPAddr(ctx, host)
+		return addrs, SourceDNS, err
+	}
+	return res.Addrs, SourceRAINS, err
+}
+
+//LookupIP looks up host for the given network, which must be "ip", "ip4" or "ip6". The preferred
+//address comes first.
+func (n *NetResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	var types []object.Type
+	switch network {
+	case "ip":
+		types = []object.Type{object.OTIP6Addr, object.OTIP4Addr}
+	case "ip4":
+		types = []object.Type{object.OTIP4Addr}
+	case "ip6":
+		types = []object.Type{object.OTIP6Addr}
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+	res, err := n.lookupAddrs(ctx, host, types)
+	if err != nil {
+		if n.fallback(ctx, host, err) {
+			return n.fallbackIP(ctx, network, host)
+		}
+		return nil, err
+	}
+	ips := make([]net.IP, len(res.Addrs))
+	for i, addr := range res.Addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+//fallbackIP looks up the addresses of host for network over the DNS fallback and returns the ones
+//of network's address family.
+func (n *NetResolver) fallbackIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	addrs, err := n.DNSFallback.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if isIP4 := addr.IP.To4() != nil; network == "ip" || isIP4 == (network == "ip4") {
+			ips = append(ips, addr.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}
+	return ips, nil
+}
+
+//LookupCNAME returns the canonical name of host, i.e. the name reached after following all name
+//objects of host.
+func (n *NetResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	canonical, _, err := n.lookupFollow(ctx, host, []object.Type{object.OTIP6Addr,
+		object.OTIP4Addr})
+	if err != nil && n.fallback(ctx, host, err) {
+		return n.DNSFallback.LookupCNAME(ctx, host)
+	}
+	return canonical, err
+}
+
+//LookupSRV looks up the service information objects of the given service and protocol at name.
+//As in DNS, the name _service._proto.name is looked up. If service and proto are empty, name is
+//looked up directly as it is common in RAINS. The returned records are sorted by priority. Their
+//weight is zero as RAINS service information has none.
+func (n *NetResolver) LookupSRV(ctx context.Context, service, proto, name string) (string,
+	[]*net.SRV, error) {
+	target := name
+	if service != "" || proto != "" {
+		target = "_" + service + "._" + proto + "." + name
+	}
+	srvTypes := []object.Type{object.OTServiceInfo}
+	canonical, assertions, err := n.lookupFollow(ctx, target, srvTypes)
+	if err != nil {
+		if n.fallback(ctx, target, err) {
+			return n.DNSFallback.LookupSRV(ctx, service, proto, name)
+		}
+		return "", nil, err
+	}
+	var srvs []*net.SRV
+	for _, o := range objectsOf(assertions, srvTypes) {
+		srvInfo := o.Value.(object.ServiceInfo)
+		srvs = append(srvs, &net.SRV{
+			Target:   srvInfo.Name,
+			Port:     srvInfo.Port,
+			Priority: uint16(srvInfo.Priority),
+		})
+	}
+	sort.SliceStable(srvs, func(i, j int) bool { return srvs[i].Priority < srvs[j].Priority })
+	return canonical, srvs, nil
+}
+
+//LookupTXT returns the free text information of name. As RAINS has no text records, these are the
+//values of its registrar and registrant objects.
+func (n *NetResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	objs, err := n.LookupObjects(ctx, name, object.OTRegistrar, object.OTRegistrant)
+	if err != nil {
+		if n.fallback(ctx, name, err) {
+			return n.DNSFallback.LookupTXT(ctx, name)
+		}
+		return nil, err
+	}
+	var txts []string
+	for _, o := range objs {
+		txts = append(txts, o.Value.(string))
+	}
+	return txts, nil
+}
+
+//LookupObjects returns the objects of the given types contained in the assertions about name. Name
+//objects are not followed. It gives access to all object types of RAINS.
+func (n *NetResolver) LookupObjects(ctx context.Context, name string, types ...object.Type) (
+	[]object.Object, error) {
+	assertions, err := n.lookup(ctx, name, types)
+	if err != nil {
+		return nil, err
+	}
+	objs := objectsOf(assertions, types)
+	if len(objs) == 0 {
+		return nil, notFound(name)
+	}
+	return objs, nil
+}
+
+//fallback returns true if name should be resolved over DNS as its RAINS lookup failed with err.
+//This is the case if a DNS fallback is configured and ctx is not done.
+func (n *NetResolver) fallback(ctx context.Context, name string, err error) bool {
+	if n.DNSFallback == nil || ctx.Err() != nil {
+		return false
+	}
+	log.Info("RAINS lookup failed, falling back to DNS", "name", name, "error", err)
+	if n.OnFallback != nil {
+		n.OnFallback(name, err)
+	}
+	return true
+}
+
+//lookupAddrs returns the addresses of host of the given types sorted by SortAddrs.
+func (n *NetResolver) lookupAddrs(ctx context.Context, host string, types []object.Type) (
+	AddrResult, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return AddrResult{Addrs: []net.IPAddr{{IP: ip}}, Name: host}, nil
+	}
+	name, assertions, err := n.lookupFollow(ctx, host, types)
+	if err != nil {
+		return AddrResult{}, err
+	}
+	res := AddrResult{Name: name, Assertions: assertions}
+	for _, o := range objectsOf(assertions, types) {
+		ip := net.ParseIP(o.Value.(string))
+		if ip == nil {
+			log.Warn("received ip address is malformed", "host", host, "ip", o.Value)
+			continue
+		}
+		res.Addrs = append(res.Addrs, net.IPAddr{IP: ip})
+	}
+	if len(res.Addrs) == 0 {
+		return AddrResult{}, notFound(host)
+	}
+	SortAddrs(res.Addrs)
+	return res, nil
+}
+
+//lookupFollow looks up the objects of the given types of host. If there are none, the name object
+//of host valid for these types is followed. It returns the name at which the objects were found
+//and the assertions about it containing them. Like net.Resolver does for A and AAAA records, each
+//type is queried separately.
+func (n *NetResolver) lookupFollow(ctx context.Context, host string, types []object.Type) (string,
+	[]*section.Assertion, error) {
+	name := host
+	for i := 0; i <= maxAliases; i++ {
+		var found []*section.Assertion
+		seen := make(map[string]bool)
+		alias := ""
+		for _, t := range types {
+			assertions, err := n.lookup(ctx, name, []object.Type{t, object.OTName})
+			if err != nil {
+				return "", nil, err
+			}
+			for _, a := range assertions {
+				if len(objectsOf([]*section.Assertion{a}, types)) > 0 && !seen[a.Hash()] {
+					seen[a.Hash()] = true
+					found = append(found, a)
+				}
+				for _, o := range objectsOf([]*section.Assertion{a}, []object.Type{object.OTName}) {
+					if nameObj := o.Value.(object.Name); alias == "" && aliasFor(nameObj, types) {
+						alias = nameObj.Name
+					}
+				}
+			}
+		}
+		if len(found) > 0 {
+			return fqdn(name), found, nil
+		}
+		if alias == "" {
+			return "", nil, notFound(host)
+		}
+		name = alias
+	}
+	return "", nil, &net.DNSError{Err: "too many name objects to follow", Name: host}
+}
+
+//aliasFor returns true if the name object o applies to at least one of types.
+func aliasFor(o object.Name, types []object.Type) bool {
+	if len(o.Types) == 0 {
+		return true
+	}
+	for _, t := range o.Types {
+		for _, wanted := range types {
+			if t == wanted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//lookup resolves name for the given types and returns the assertions about name containing
+//objects of these types.
+func (n *NetResolver) lookup(ctx context.Context, name string, types []object.Type) (
+	[]*section.Assertion, error) {
+	rainsContext := n.Context
+	if rainsContext == "" {
+		rainsContext = "."
+	}
+	expiration := clock.Now().Add(defaultTimeout)
+	if deadline, ok := ctx.Deadline(); ok {
+		expiration = clock.Now().Add(time.Until(deadline))
+	}
+	q := &query.Name{
+		Name:       fqdn(name),
+		Context:    rainsContext,
+		Types:      types,
+		Expiration: expiration.Unix(),
+	}
+	answer, err := n.Resolver.ClientLookup(ctx, q)
+	if err != nil {
+		return nil, &net.DNSError{
+			Err:       err.Error(),
+			Name:      name,
+			IsTimeout: ctx.Err() == context.DeadlineExceeded,
+		}
+	}
+	return answerAssertions(answer, q.Name, types), nil
+}
+
+//answerAssertions returns the assertions of answer with name as fully qualified domain name which
+//contain objects of the given types, including the ones contained in shards and zones.
+func answerAssertions(answer *message.Message, name string,
+	types []object.Type) []*section.Assertion {
+	var assertions []*section.Assertion
+	for _, s := range answer.Content {
+		switch s := s.(type) {
+		case *section.Assertion:
+			assertions = append(assertions, s)
+		case *section.Shard:
+			for _, a := range s.Content {
+				assertions = append(assertions, a.Copy(s.Context, s.SubjectZone))
+			}
+		case *section.Zone:
+			for _, a := range s.Content {
+				assertions = append(assertions, a.Copy(s.Context, s.SubjectZone))
+			}
+		}
+	}
+	var about []*section.Assertion
+	for _, a := range assertions {
+		if a.FQDN() == name && len(objectsOf([]*section.Assertion{a}, types)) > 0 {
+			about = append(about, a)
+		}
+	}
+	return about
+}
+
+//objectsOf returns the objects of the given types contained in assertions.
+func objectsOf(assertions []*section.Assertion, types []object.Type) []object.Object {
+	wanted := make(map[object.Type]bool)
+	for _, t := range types {
+		wanted[t] = true
+	}
+	var objs []object.Object
+	for _, a := range assertions {
+		for _, o := range a.Content {
+			if wanted[o.Type] {
+				objs = append(objs, o)
+			}
+		}
+	}
+	return objs
+}
+
+//fqdn returns name with a trailing dot as RAINS only resolves fully qualified names.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+//notFound returns the error reported when name has no objects of the requested types.
+func notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
@@ -0,0 +1,169 @@
+package libresolve
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//netResolverAnswers are the answers of the forwarder used to test the NetResolver.
+func netResolverAnswers() map[string][]section.Section {
+	assertion := func(name string, objs ...object.Object) []section.Section {
+		return []section.Section{&section.Assertion{SubjectName: name, SubjectZone: "ethz.ch.",
+			Context: ".", Content: objs}}
+	}
+	return map[string][]section.Section{
+		"www.ethz.ch.": assertion("www",
+			object.Object{Type: object.OTIP4Addr, Value: "192.0.2.80"},
+			object.Object{Type: object.OTIP6Addr, Value: "2001:db8::80"}),
+		"alias.ethz.ch.": assertion("alias", object.Object{Type: object.OTName,
+			Value: object.Name{Name: "www.ethz.ch.",
+				Types: []object.Type{object.OTIP4Addr, object.OTIP6Addr}}}),
+		"_rainsd._tcp.ethz.ch.": assertion("_rainsd._tcp",
+			object.Object{Type: object.OTServiceInfo,
+				Value: object.ServiceInfo{Name: "ns2.ethz.ch.", Port: 55553, Priority: 2}},
+			object.Object{Type: object.OTServiceInfo,
+				Value: object.ServiceInfo{Name: "ns1.ethz.ch.", Port: 55553, Priority: 1}}),
+		"registrar.ethz.ch.": assertion("registrar",
+			object.Object{Type: object.OTRegistrar, Value: "ETH Zurich"}),
+	}
+}
+
+func TestNetResolver(t *testing.T) {
+	var origins []Origin
+	r := cachingResolver(netResolverAnswers(), &origins)
+	defer r.Close()
+	n := NewNetResolver(r, "")
+	ctx := context.Background()
+
+	hosts, err := n.LookupHost(ctx, "www.ethz.ch")
+	sort.Strings(hosts)
+	if expected := []string{"192.0.2.80", "2001:db8::80"}; err != nil ||
+		!reflect.DeepEqual(hosts, expected) {
+		t.Errorf("wrong hosts. expected=%v actual=%v error=%v", expected, hosts, err)
+	}
+	if hosts, err := n.LookupHost(ctx, "192.0.2.1"); err != nil || len(hosts) != 1 ||
+		hosts[0] != "192.0.2.1" {
+		t.Errorf("ip address was not returned as is. actual=%v error=%v", hosts, err)
+	}
+	ips, err := n.LookupIP(ctx, "ip4", "alias.ethz.ch")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.0.2.80")) {
+		t.Errorf("wrong ips of alias. expected=[192.0.2.80] actual=%v error=%v", ips, err)
+	}
+	if _, err := n.LookupIP(ctx, "tcp", "www.ethz.ch"); err == nil {
+		t.Error("lookup for unknown network succeeded")
+	}
+	if cname, err := n.LookupCNAME(ctx, "alias.ethz.ch"); err != nil || cname != "www.ethz.ch." {
+		t.Errorf("wrong canonical name. expected=www.ethz.ch. actual=%s error=%v", cname, err)
+	}
+	cname, srvs, err := n.LookupSRV(ctx, "rainsd", "tcp", "ethz.ch.")
+	if err != nil || cname != "_rainsd._tcp.ethz.ch." || len(srvs) != 2 ||
+		srvs[0].Target != "ns1.ethz.ch." || srvs[1].Target != "ns2.ethz.ch." ||
+		srvs[0].Port != 55553 {
+		t.Errorf("wrong service information. cname=%s srvs=%v error=%v", cname, srvs, err)
+	}
+	if txts, err := n.LookupTXT(ctx, "registrar.ethz.ch"); err != nil || len(txts) != 1 ||
+		txts[0] != "ETH Zurich" {
+		t.Errorf("wrong text records. expected=[ETH Zurich] actual=%v error=%v", txts, err)
+	}
+	_, err = n.LookupHost(ctx, "registrar.ethz.ch")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Errorf("host without addresses was found. error=%v", err)
+	}
+}
+
+//dnsServer answers the DNS queries received on conn, which are framed as over TCP, with ip for
+//queries of type A and without records otherwise until conn is closed.
+func dnsServer(conn net.Conn, ip net.IP) {
+	defer conn.Close()
+	for {
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		//the question follows the header and ends with its type and class after the name
+		end := 12
+		for end < len(req) && req[end] != 0 {
+			end += int(req[end]) + 1
+		}
+		end += 5
+		if end > len(req) {
+			return
+		}
+		//id, response with recursion desired and available, one question
+		resp := append([]byte{req[0], req[1], 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0}, req[12:end]...)
+		if binary.BigEndian.Uint16(req[end-4:end-2]) == 1 {
+			resp[7] = 1
+			//pointer to the question's name, type A, class IN, ttl, length of the address
+			resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+			resp = append(resp, ip.To4()...)
+		}
+		binary.BigEndian.PutUint16(length[:], uint16(len(resp)))
+		if _, err := conn.Write(append(length[:], resp...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestDNSFallback(t *testing.T) {
+	var origins []Origin
+	r := cachingResolver(netResolverAnswers(), &origins)
+	defer r.Close()
+	n := NewNetResolver(r, "")
+	n.DNSFallback = &net.Resolver{PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go dnsServer(server, net.ParseIP("198.51.100.1"))
+			return client, nil
+		}}
+	var fallbacks []string
+	n.OnFallback = func(name string, err error) { fallbacks = append(fallbacks, name) }
+	var tests = []struct {
+		host   string
+		addr   string
+		source Source
+	}{
+		{"www.ethz.ch.", "192.0.2.80", SourceRAINS},
+		{"legacy.example.", "198.51.100.1", SourceDNS},
+	}
+	for i, test := range tests {
+		addrs, source, err := n.LookupIPAddrSource(context.Background(), test.host)
+		if err != nil || source != test.source {
+			t.Errorf("%d: wrong source. expected=%v actual=%v error=%v", i, test.source, source,
+				err)
+			continue
+		}
+		found := false
+		for _, addr := range addrs {
+			found = found || addr.IP.Equal(net.ParseIP(test.addr))
+		}
+		if !found {
+			t.Errorf("%d: wrong addresses. expected=%s actual=%v", i, test.addr, addrs)
+		}
+	}
+	if len(fallbacks) != 1 || fallbacks[0] != "legacy.example." {
+		t.Errorf("wrong fallbacks. expected=[legacy.example.] actual=%v", fallbacks)
+	}
+	if ips, err := n.LookupIP(context.Background(), "ip4", "legacy.example."); err != nil ||
+		len(ips) != 1 || !ips[0].Equal(net.ParseIP("198.51.100.1")) {
+		t.Errorf("wrong fallback addresses. expected=[198.51.100.1] actual=%v error=%v", ips, err)
+	}
+	//A cancelled lookup is not resolved over DNS.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, source, err := n.LookupIPAddrSource(ctx, "legacy.example."); err == nil ||
+		source != SourceRAINS {
+		t.Errorf("cancelled lookup fell back to DNS. source=%v error=%v", source, err)
+	}
+}
@@ -0,0 +1,28 @@
+package libresolve
+
+import (
+	"context"
+
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+)
+
+//optionsKey is the context key under which the query options of a lookup are stored such that the
+//queries sent to verify its answer carry them as well.
+type optionsKey struct{}
+
+//withOptions returns q if it specifies query options. Otherwise, it returns a copy of q with the
+//resolver's default options.
+func (r *Resolver) withOptions(q *query.Name) *query.Name {
+	if len(q.Options) > 0 || len(r.Options) == 0 {
+		return q
+	}
+	qCopy := *q
+	qCopy.Options = append([]query.Option(nil), r.Options...)
+	return &qCopy
+}
+
+//lookupOptions returns the query options of the lookup ctx belongs to.
+func lookupOptions(ctx context.Context) []query.Option {
+	opts, _ := ctx.Value(optionsKey{}).([]query.Option)
+	return opts
+}
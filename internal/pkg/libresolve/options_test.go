@@ -0,0 +1,91 @@
+package libresolve
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//optionsServer sends the options of the queries received on conn to received and answers them
+//with an assertion about the queried name until conn is closed.
+func optionsServer(conn net.Conn, received chan<- []query.Option) {
+	defer conn.Close()
+	reader := codec.NewReader(conn, cbor.Limits{})
+	for {
+		msg, err := reader.Read()
+		if err != nil {
+			return
+		}
+		q := msg.Content[0].(*query.Name)
+		received <- q.Options
+		subject, zone, _ := splitName(q.Name)
+		answer := message.Message{Token: msg.Token, Content: []section.Section{
+			&section.Assertion{SubjectName: subject, SubjectZone: zone, Context: q.Context,
+				Content: []object.Object{object.Object{Type: object.OTIP4Addr,
+					Value: "192.0.2.80"}}}}}
+		if err := (codec.CBOR{}).Encode(conn, &answer); err != nil {
+			return
+		}
+	}
+}
+
+func TestQueryOptions(t *testing.T) {
+	received := make(chan []query.Option, 10)
+	dialer := DialerFunc(func(ctx context.Context, addr net.Addr) (net.Conn, error) {
+		client, server := net.Pipe()
+		go optionsServer(server, received)
+		return client, nil
+	})
+	server := []net.Addr{&net.TCPAddr{IP: net.ParseIP("192.0.2.53"), Port: 55553}}
+	defaults := []query.Option{query.QOTokenTracing, query.QOMinE2ELatency}
+	var tests = []struct {
+		mode    ResolutionMode
+		options []query.Option
+		//sent are the options received by the server or nil if no query must be sent.
+		sent  []query.Option
+		valid bool
+	}{
+		{Forward, nil, defaults, true},
+		{Forward, []query.Option{query.QOMinInfoLeakage}, []query.Option{query.QOMinInfoLeakage},
+			true},
+		{Forward, []query.Option{query.QOCachedAnswersOnly},
+			[]query.Option{query.QOCachedAnswersOnly}, true},
+		{Forward, []query.Option{query.QOTokenTracing, query.QOTokenTracing}, nil, false},
+		//in recursive mode, the resolver answers from its own cache
+		{Recursive, []query.Option{query.QOCachedAnswersOnly}, nil, false},
+		{Recursive, nil, defaults, true},
+	}
+	for i, test := range tests {
+		r := New(server, server, test.mode, nil, 1)
+		r.Assertions, r.NegAssertions = nil, nil
+		r.Options = defaults
+		r.Dialer = dialer
+		q := &query.Name{Name: "www.ethz.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr},
+			Options: test.options, Expiration: time.Now().Add(time.Minute).Unix()}
+		_, err := r.ClientLookup(context.Background(), q)
+		r.Close()
+		if (err == nil) != test.valid {
+			t.Errorf("%d: wrong outcome. expected valid=%t actual=%v", i, test.valid, err)
+		}
+		var sent []query.Option
+		select {
+		case sent = <-received:
+		default:
+		}
+		if !reflect.DeepEqual(sent, test.sent) {
+			t.Errorf("%d: wrong options sent. expected=%v actual=%v", i, test.sent, sent)
+		}
+		if !reflect.DeepEqual(q.Options, test.options) {
+			t.Errorf("%d: options of the query were modified: %v", i, q.Options)
+		}
+	}
+}
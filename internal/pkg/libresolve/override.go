@@ -0,0 +1,112 @@
+package libresolve
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+//overrideTable contains statically configured objects which are returned instead of the result of
+//a network lookup, similar to a hosts file in DNS.
+type overrideTable struct {
+	mux     sync.RWMutex
+	objects map[nameContext][]object.Object
+}
+
+//nameContext identifies the overridden objects of a fully qualified name in a context.
+type nameContext struct {
+	name    string
+	context string
+}
+
+func newOverrideTable() *overrideTable {
+	return &overrideTable{objects: make(map[nameContext][]object.Object)}
+}
+
+//AddOverride adds objs to the static objects of the fully qualified name in context. A lookup
+//for name in context is answered with its static objects of the queried types without contacting
+//any server. If name has no static object of the queried types, the lookup proceeds as usual.
+//Static objects are neither signed nor verified.
+func (r *Resolver) AddOverride(name, context string, objs ...object.Object) {
+	r.overrides.mux.Lock()
+	defer r.overrides.mux.Unlock()
+	key := nameContext{name: name, context: context}
+	r.overrides.objects[key] = append(r.overrides.objects[key], objs...)
+}
+
+//LoadOverrides adds the objects of all assertions in the zonefile at path as static objects,
+//including the ones contained in zones and shards. Signatures in the zonefile are ignored.
+func (r *Resolver) LoadOverrides(path string) error {
+	sections, err := zonefile.IO{}.LoadZonefile(path)
+	if err != nil {
+		return fmt.Errorf("could not load overrides: %v", err)
+	}
+	var assertions []*section.Assertion
+	for _, s := range sections {
+		switch s := s.(type) {
+		case *section.Assertion:
+			assertions = append(assertions, s)
+		case *section.Shard:
+			for _, a := range s.Content {
+				assertions = append(assertions, a.Copy(s.Context, s.SubjectZone))
+			}
+		case *section.Zone:
+			for _, a := range s.Content {
+				assertions = append(assertions, a.Copy(s.Context, s.SubjectZone))
+			}
+		}
+	}
+	for _, a := range assertions {
+		name := a.FQDN()
+		if a.SubjectName == "@" {
+			name = a.SubjectZone
+		}
+		r.AddOverride(name, a.Context, a.Content...)
+	}
+	log.Info("loaded overrides", "path", path, "assertions", len(assertions))
+	return nil
+}
+
+//RemoveOverride removes all static objects of the fully qualified name in context.
+func (r *Resolver) RemoveOverride(name, context string) {
+	r.overrides.mux.Lock()
+	defer r.overrides.mux.Unlock()
+	delete(r.overrides.objects, nameContext{name: name, context: context})
+}
+
+//overrideAnswer returns a message containing an unsigned assertion with the static objects of the
+//queried name and types. It returns false if there are none.
+func (r *Resolver) overrideAnswer(q *query.Name) (*message.Message, bool) {
+	r.overrides.mux.RLock()
+	defer r.overrides.mux.RUnlock()
+	objs, ok := r.overrides.objects[nameContext{name: q.Name, context: q.Context}]
+	if !ok {
+		return nil, false
+	}
+	types := make(map[object.Type]bool)
+	for _, t := range q.Types {
+		types[t] = true
+	}
+	a := &section.Assertion{Context: q.Context}
+	if subject, zone, ok := splitName(q.Name); ok {
+		a.SubjectName, a.SubjectZone = subject, zone
+	} else {
+		a.SubjectName, a.SubjectZone = "@", q.Name
+	}
+	for _, o := range objs {
+		if types[o.Type] || len(q.Types) == 0 {
+			a.Content = append(a.Content, o)
+		}
+	}
+	if len(a.Content) == 0 {
+		return nil, false
+	}
+	return &message.Message{Content: []section.Section{a}}, true
+}
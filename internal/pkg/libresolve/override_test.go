@@ -0,0 +1,84 @@
+package libresolve
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libresolve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	zonefile := path.Join(dir, "overrides.txt")
+	if err := ioutil.WriteFile(zonefile, []byte(":Z: example. . [\n"+
+		"    :A: local [ :ip4: 192.0.2.10 ]\n"+
+		"    :A: @ [ :ip4: 192.0.2.11 ]\n"+
+		"]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assertion := func(name, zone string, o object.Object) []section.Section {
+		return []section.Section{&section.Assertion{SubjectName: name, SubjectZone: zone,
+			Context: ".", Content: []object.Object{o}}}
+	}
+	answers := map[string][]section.Section{
+		"www.ethz.ch.": assertion("www", "ethz.ch.",
+			object.Object{Type: object.OTIP4Addr, Value: "192.0.2.80"}),
+		"local.example.": assertion("local", "example.",
+			object.Object{Type: object.OTIP6Addr, Value: "2001:db8::10"}),
+	}
+	var origins []Origin
+	r := cachingResolver(answers, &origins)
+	defer r.Close()
+	r.AddOverride("www.ethz.ch.", ".", object.Object{Type: object.OTIP4Addr, Value: "192.0.2.99"})
+	if err := r.LoadOverrides(zonefile); err != nil {
+		t.Fatalf("Was not able to load overrides: %v", err)
+	}
+	if err := r.LoadOverrides(path.Join(dir, "missing.txt")); err == nil {
+		t.Error("missing zonefile was loaded")
+	}
+	var tests = []struct {
+		remove string
+		name   string
+		qtype  object.Type
+		origin Origin
+		value  string
+	}{
+		{"", "www.ethz.ch.", object.OTIP4Addr, OriginOverride, "192.0.2.99"},
+		{"", "local.example.", object.OTIP4Addr, OriginOverride, "192.0.2.10"},
+		{"", "example.", object.OTIP4Addr, OriginOverride, "192.0.2.11"},
+		//local.example. has no static objects of this type
+		{"", "local.example.", object.OTIP6Addr, OriginNetwork, "2001:db8::10"},
+		{"www.ethz.ch.", "www.ethz.ch.", object.OTIP4Addr, OriginNetwork, "192.0.2.80"},
+	}
+	for i, test := range tests {
+		if test.remove != "" {
+			r.RemoveOverride(test.remove, ".")
+		}
+		q := &query.Name{Name: test.name, Context: ".", Types: []object.Type{test.qtype},
+			Expiration: time.Now().Add(time.Minute).Unix()}
+		answer, err := r.ClientLookup(context.Background(), q)
+		if err != nil || len(answer.Content) != 1 {
+			t.Errorf("%d: lookup failed. answer=%v error=%v", i, answer, err)
+			continue
+		}
+		//the static objects of a zone are returned in an assertion about @
+		a, ok := answer.Content[0].(*section.Assertion)
+		if !ok || (a.FQDN() != test.name && a.SubjectZone != test.name) || len(a.Content) != 1 ||
+			a.Content[0].Value != test.value {
+			t.Errorf("%d: wrong answer. expected=%s actual=%v", i, test.value, answer.Content[0])
+		}
+		if origin := origins[len(origins)-1]; origin != test.origin {
+			t.Errorf("%d: wrong origin. expected=%v actual=%v", i, test.origin, origin)
+		}
+	}
+}
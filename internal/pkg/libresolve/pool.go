@@ -0,0 +1,270 @@
+package libresolve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//errConnClosed is returned for queries whose connection was closed before the answer arrived.
+var errConnClosed = errors.New("connection has been closed")
+
+//connPool keeps one persistent connection per server. Queries to the same server are multiplexed
+//over its connection and the answers are matched to the queries by their token. A connection is
+//closed after it has not been used for the idle timeout of its last query. If the idle timeout is
+//not positive, the connection is closed as soon as no query is pending on it.
+type connPool struct {
+	mux   sync.Mutex
+	conns map[string]*pooledConn
+}
+
+func newConnPool() *connPool {
+	return &connPool{conns: make(map[string]*pooledConn)}
+}
+
+//pooledConn is a connection of the pool together with the queries waiting for an answer on it.
+type pooledConn struct {
+	pool     *connPool
+	addr     string
+	conn     net.Conn
+	writeMux sync.Mutex
+
+	//mux protects the fields below.
+	mux         sync.Mutex
+	pending     map[token.Token]chan message.Message
+	lastUsed    time.Time
+	idleTimeout time.Duration
+	idle        *time.Timer
+	closed      bool
+}
+
+//query sends msg encoded with c to addr over a pooled connection, establishing a new one with dial
+//if necessary, and waits for the answer until ctx is done. The connection is kept open for idleTimeout after the
+//answer. If a reused connection turns out to be closed by the server, the query is sent once more
+//over a new connection.
+func (p *connPool) query(ctx context.Context, msg message.Message, addr net.Addr,
+	idleTimeout time.Duration, dial DialerFunc, c codec.Codec) (message.Message, error) {
+	pc, reused, err := p.get(ctx, addr, dial)
+	if err != nil {
+		return message.Message{}, err
+	}
+	answer, err := pc.query(ctx, msg, idleTimeout, c)
+	if err == errConnClosed && reused && ctx.Err() == nil {
+		log.Debug("pooled connection has been closed, redialing", "server", addr)
+		if pc, _, err = p.get(ctx, addr, dial); err != nil {
+			return message.Message{}, err
+		}
+		answer, err = pc.query(ctx, msg, idleTimeout, c)
+	}
+	return answer, err
+}
+
+//get returns the pooled connection to addr and whether it has been used before. A new connection
+//is established with dial if there is none.
+func (p *connPool) get(ctx context.Context, addr net.Addr, dial DialerFunc) (*pooledConn, bool,
+	error) {
+	p.mux.Lock()
+	if pc, ok := p.conns[addr.String()]; ok {
+		p.mux.Unlock()
+		return pc, true, nil
+	}
+	p.mux.Unlock()
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, false, fmt.Errorf("timed out connecting to %s", addr)
+		}
+		return nil, false, err
+	}
+	pc := &pooledConn{
+		pool:     p,
+		addr:     addr.String(),
+		conn:     conn,
+		pending:  make(map[token.Token]chan message.Message),
+		lastUsed: clock.Now(),
+	}
+	p.mux.Lock()
+	if existing, ok := p.conns[pc.addr]; ok {
+		//another lookup connected in the mean time
+		p.mux.Unlock()
+		conn.Close()
+		return existing, true, nil
+	}
+	p.conns[pc.addr] = pc
+	p.mux.Unlock()
+	log.Debug("opened pooled connection", "server", addr)
+	go pc.read()
+	return pc, false, nil
+}
+
+//closeAll closes all connections of the pool.
+func (p *connPool) closeAll() {
+	p.mux.Lock()
+	conns := p.conns
+	p.conns = make(map[string]*pooledConn)
+	p.mux.Unlock()
+	for _, pc := range conns {
+		pc.close()
+	}
+}
+
+//query sends msg encoded with c over pc and waits for the answer with the same token until ctx is
+//done.
+func (pc *pooledConn) query(ctx context.Context, msg message.Message, idleTimeout time.Duration,
+	c codec.Codec) (message.Message, error) {
+	answers := make(chan message.Message, 1)
+	pc.mux.Lock()
+	if pc.closed {
+		pc.mux.Unlock()
+		return message.Message{}, errConnClosed
+	}
+	pc.pending[msg.Token] = answers
+	pc.idleTimeout = idleTimeout
+	if pc.idle != nil {
+		pc.idle.Stop()
+		pc.idle = nil
+	}
+	pc.mux.Unlock()
+	defer pc.release(msg.Token)
+
+	pc.writeMux.Lock()
+	if deadline, ok := ctx.Deadline(); ok {
+		pc.conn.SetWriteDeadline(deadline)
+	}
+	err := c.Encode(pc.conn, &msg)
+	pc.conn.SetWriteDeadline(time.Time{})
+	pc.writeMux.Unlock()
+	if err != nil {
+		pc.close()
+		return message.Message{}, errConnClosed
+	}
+
+	select {
+	case answer, ok := <-answers:
+		if !ok {
+			return message.Message{}, errConnClosed
+		}
+		return answer, nil
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return message.Message{}, fmt.Errorf("timed out waiting for response")
+		}
+		return message.Message{}, ctx.Err()
+	}
+}
+
+//release removes the query with token tok from the pending ones and starts the idle timer or
+//closes the connection if no query is pending anymore.
+func (pc *pooledConn) release(tok token.Token) {
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+	delete(pc.pending, tok)
+	pc.lastUsed = clock.Now()
+	if len(pc.pending) > 0 || pc.closed {
+		return
+	}
+	if pc.idleTimeout <= 0 {
+		go pc.close()
+		return
+	}
+	if pc.idle == nil {
+		pc.idle = time.AfterFunc(pc.idleTimeout, pc.closeIfIdle)
+	}
+}
+
+//closeIfIdle closes pc if no query is pending and it has not been used for its idle timeout
+//according to the clock in use. Otherwise, the check is repeated when the idle timeout would be
+//reached.
+func (pc *pooledConn) closeIfIdle() {
+	pc.mux.Lock()
+	if len(pc.pending) > 0 || pc.closed {
+		pc.mux.Unlock()
+		return
+	}
+	if remaining := pc.idleTimeout - clock.Now().Sub(pc.lastUsed); remaining > 0 {
+		pc.idle = time.AfterFunc(remaining, pc.closeIfIdle)
+		pc.mux.Unlock()
+		return
+	}
+	pc.mux.Unlock()
+	log.Debug("closing idle pooled connection", "server", pc.addr)
+	pc.close()
+}
+
+//read passes the messages received on pc to the pending queries with the same token until the
+//connection is closed.
+func (pc *pooledConn) read() {
+	reader := codec.NewReader(pc.conn, cbor.Limits{})
+	for {
+		msg, err := reader.Read()
+		if err != nil {
+			pc.mux.Lock()
+			closed := pc.closed
+			pc.mux.Unlock()
+			if !closed && err != io.EOF {
+				log.Warn("failed to read from pooled connection", "server", pc.addr, "error", err)
+			}
+			pc.close()
+			return
+		}
+		pc.mux.Lock()
+		tok := msg.Token
+		answers, ok := pc.pending[tok]
+		if !ok {
+			for _, s := range msg.Content {
+				if n, isNotification := s.(*section.Notification); isNotification {
+					if answers, ok = pc.pending[n.Token]; ok {
+						tok = n.Token
+						break
+					}
+				}
+			}
+		}
+		if ok {
+			delete(pc.pending, tok)
+			answers <- *msg
+		} else {
+			log.Warn("received message for unknown query on pooled connection", "server",
+				pc.addr, "token", msg.Token)
+		}
+		pc.mux.Unlock()
+	}
+}
+
+//close closes the connection, removes it from the pool and signals all pending queries that no
+//answer will arrive.
+func (pc *pooledConn) close() {
+	pc.mux.Lock()
+	if pc.closed {
+		pc.mux.Unlock()
+		return
+	}
+	pc.closed = true
+	if pc.idle != nil {
+		pc.idle.Stop()
+	}
+	for tok, answers := range pc.pending {
+		close(answers)
+		delete(pc.pending, tok)
+	}
+	pc.mux.Unlock()
+	pc.conn.Close()
+	pc.pool.mux.Lock()
+	if pc.pool.conns[pc.addr] == pc {
+		delete(pc.pool.conns, pc.addr)
+	}
+	pc.pool.mux.Unlock()
+}
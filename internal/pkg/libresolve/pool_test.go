@@ -0,0 +1,141 @@
+package libresolve
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//reversingServer reads batch messages from conn and echoes them in reverse order until conn is
+//closed.
+func reversingServer(conn net.Conn, batch int) {
+	defer conn.Close()
+	reader := codec.NewReader(conn, cbor.Limits{})
+	for {
+		msgs := make([]*message.Message, batch)
+		for i := range msgs {
+			msg, err := reader.Read()
+			if err != nil {
+				return
+			}
+			msgs[i] = msg
+		}
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if err := (codec.CBOR{}).Encode(conn, msgs[i]); err != nil {
+				return
+			}
+		}
+	}
+}
+
+//countingDialer returns a dialer which counts its connections in dials and serves each of them
+//with a reversingServer.
+func countingDialer(dials *int32, batch int) DialerFunc {
+	return func(ctx context.Context, addr net.Addr) (net.Conn, error) {
+		atomic.AddInt32(dials, 1)
+		client, server := net.Pipe()
+		go reversingServer(server, batch)
+		return client, nil
+	}
+}
+
+//poolQuery returns a message with a fresh token containing a query for name.
+func poolQuery(name string) message.Message {
+	return message.Message{Token: token.New(), Content: []section.Section{&query.Name{Name: name,
+		Context: ".", Types: []object.Type{object.OTIP4Addr}}}}
+}
+
+func TestPoolMultiplexing(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.53"), Port: 55553}
+	p := newConnPool()
+	defer p.closeAll()
+	var dials int32
+	dial := countingDialer(&dials, 2)
+	//The server answers both queries in reverse order over the same connection.
+	names := []string{"www.ethz.ch.", "ftp.ethz.ch."}
+	answers := make([]message.Message, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, msg message.Message) {
+			defer wg.Done()
+			answers[i], errs[i] = p.query(context.Background(), msg, addr, time.Minute, dial,
+				codec.CBOR{})
+			if errs[i] == nil && answers[i].Token != msg.Token {
+				t.Errorf("%d: answer has wrong token. expected=%v actual=%v", i, msg.Token,
+					answers[i].Token)
+			}
+		}(i, poolQuery(name))
+	}
+	wg.Wait()
+	for i, name := range names {
+		if errs[i] != nil {
+			t.Errorf("%d: query failed: %v", i, errs[i])
+			continue
+		}
+		if q, ok := answers[i].Content[0].(*query.Name); !ok || q.Name != name {
+			t.Errorf("%d: wrong answer. expected=%s actual=%v", i, name, answers[i].Content)
+		}
+	}
+	if dials := atomic.LoadInt32(&dials); dials != 1 {
+		t.Errorf("wrong number of connections. expected=1 actual=%d", dials)
+	}
+}
+
+func TestPoolIdleReuse(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	defer clock.Set(fake)()
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.53"), Port: 55553}
+	p := newConnPool()
+	defer p.closeAll()
+	var dials int32
+	dial := countingDialer(&dials, 1)
+	const idleTimeout = 20 * time.Millisecond
+	var tests = []struct {
+		//advance is the time the clock is advanced before the query.
+		advance time.Duration
+		dials   int32
+	}{
+		{0, 1},
+		{0, 1},
+		//the clock stands still, the connection does not become idle in the mean time
+		{0, 1},
+		{idleTimeout / 2, 1},
+		{2 * idleTimeout, 2},
+		{0, 2},
+	}
+	for i, test := range tests {
+		fake.Advance(test.advance)
+		//give the idle timer the chance to fire
+		time.Sleep(2 * idleTimeout)
+		if _, err := p.query(context.Background(), poolQuery("www.ethz.ch."), addr, idleTimeout,
+			dial, codec.CBOR{}); err != nil {
+			t.Errorf("%d: query failed: %v", i, err)
+		}
+		if d := atomic.LoadInt32(&dials); d != test.dials {
+			t.Errorf("%d: wrong number of connections. expected=%d actual=%d", i, test.dials, d)
+		}
+	}
+	//Without idle timeout, a connection is closed after its last query.
+	p.query(context.Background(), poolQuery("www.ethz.ch."), addr, 0, dial, codec.CBOR{})
+	time.Sleep(idleTimeout)
+	p.mux.Lock()
+	pooled := len(p.conns)
+	p.mux.Unlock()
+	if pooled != 0 {
+		t.Errorf("connection without idle timeout was kept open")
+	}
+}
@@ -0,0 +1,520 @@
+package libresolve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//ResolutionError describes where a recursive lookup failed.
+type ResolutionError struct {
+	//Name is the name of the query which could not be resolved.
+	Name string
+	//Step is the number of queries sent before the lookup failed.
+	Step int
+	//Server is the last server which was queried or nil if none was.
+	Server net.Addr
+	//Authority is the redirection target whose servers were queried last. It is empty for the
+	//root servers.
+	Authority string
+	//Reason explains why the lookup could not be continued.
+	Reason string
+	//fatal is set if no alternate server must be tried, e.g. because the lookup was cancelled.
+	fatal bool
+	//unanswered is set if Server did not answer, such that it may be asked again.
+	unanswered bool
+}
+
+func (e *ResolutionError) Error() string {
+	authority := e.Authority
+	if authority == "" {
+		authority = "root"
+	}
+	return fmt.Sprintf("recursive lookup for %s failed at step %d (server %v, authority %s): %s",
+		e.Name, e.Step, e.Server, authority, e.Reason)
+}
+
+//resolution is the state shared by all queries of one recursive lookup, including the lookups of
+//missing glue records.
+type resolution struct {
+	name  string
+	steps int
+	//depth is the number of delegations followed from the root to reach the servers currently
+	//queried.
+	depth int
+	//resolving contains the names whose glue records are being looked up.
+	resolving map[string]bool
+}
+
+//fail returns a ResolutionError describing why the lookup could not be continued at server. path
+//contains the redirection targets followed to reach server.
+func (res *resolution) fail(server net.Addr, path []string, fatal bool, format string,
+	args ...interface{}) *ResolutionError {
+	err := &ResolutionError{
+		Name:   res.name,
+		Step:   res.steps,
+		Server: server,
+		Reason: fmt.Sprintf(format, args...),
+		fatal:  fatal,
+	}
+	if len(path) > 0 {
+		err.Authority = path[len(path)-1]
+	}
+	return err
+}
+
+//exceeded returns the error reporting that the lookup exceeded the given budget.
+func (res *resolution) exceeded(limit Budget, format string, args ...interface{}) error {
+	return &BudgetExceededError{Name: res.name, Limit: limit, Max: fmt.Sprintf(format, args...)}
+}
+
+//isFatal returns true if err ends the whole lookup instead of only the attempt at one server.
+func isFatal(err error) bool {
+	if _, ok := err.(*BudgetExceededError); ok {
+		return true
+	}
+	rerr, ok := err.(*ResolutionError)
+	return ok && rerr.fatal
+}
+
+//isUnanswered returns true if err reports that server itself did not answer, as opposed to a
+//failure further down the delegations it redirected to.
+func isUnanswered(err error, server net.Addr) bool {
+	rerr, ok := err.(*ResolutionError)
+	return ok && rerr.unanswered && rerr.Server == server
+}
+
+// recursiveResolve starts at the root and follows delegations until it receives an answer. If a
+// server does not answer or its redirections lead to a dead end, the alternate servers of the same
+// authority are tried. At most MaxSteps queries are sent and MaxDepth delegations followed. A
+// *ResolutionError is returned if no answer could be obtained and a *BudgetExceededError if a
+// limit was reached.
+func (r *Resolver) recursiveResolve(ctx context.Context, q *query.Name) (*message.Message, error) {
+	//Check for cached delegation assertion if it answers all queried types
+	if a, ok := r.Delegations.Get(q.Name); ok && answersTypes(a.(*section.Assertion), q.Types) {
+		log.Info("respond with a cached delegation", "delegation", a, "query", q)
+		return &message.Message{Content: []section.Section{a.(*section.Assertion)}}, nil
+	}
+	res := &resolution{name: q.Name, resolving: make(map[string]bool)}
+	return r.iterateClosest(ctx, res, q)
+}
+
+//answersTypes returns true if a contains an object of each of the non-empty types.
+func answersTypes(a *section.Assertion, types []object.Type) bool {
+	contained := make(map[object.Type]bool)
+	for _, o := range a.Content {
+		contained[o.Type] = true
+	}
+	for _, t := range types {
+		if !contained[t] {
+			return false
+		}
+	}
+	return len(types) > 0
+}
+
+//iterateClosest resolves q starting at the remembered servers of the most specific zone above
+//q.Name or, if there are none or they fail, at the root servers.
+func (r *Resolver) iterateClosest(ctx context.Context, res *resolution, q *query.Name) (
+	*message.Message, error) {
+	if zone, e, ok := r.referrals.closest(q.Name, q.Context); ok {
+		atomic.AddInt64(&r.metrics.delegationCacheHits, 1)
+		log.Debug("starting lookup at remembered servers", "zone", zone, "servers", e.servers)
+		answer, err := r.iterate(ctx, res, q, zone, e.servers, e.targets)
+		if err == nil || isFatal(err) {
+			return answer, err
+		}
+		log.Debug("remembered servers failed, starting at the root servers", "zone", zone,
+			"error", err)
+		r.referrals.remove(zone, q.Context)
+	}
+	roots, _ := r.upstream()
+	return r.iterate(ctx, res, q, ".", roots, nil)
+}
+
+//iterate sends q to servers one after the other until one of them answers it, directly or through
+//its redirections. If all of them fail, the servers which did not answer are asked again up to
+//Retries times, see backoff. zone is the zone of servers and path contains the redirection targets
+//followed to reach them.
+func (r *Resolver) iterate(ctx context.Context, res *resolution, q *query.Name, zone string,
+	servers []net.Addr, path []string) (*message.Message, error) {
+	if len(servers) == 0 {
+		return nil, res.fail(nil, path, false, "no server to query")
+	}
+	var err error
+	for retry := 1; ; retry++ {
+		var unanswered []net.Addr
+		for _, addr := range servers {
+			var answer *message.Message
+			if answer, err = r.iterateAt(ctx, res, q, zone, addr, path); err == nil ||
+				isFatal(err) {
+				return answer, err
+			}
+			if isUnanswered(err, addr) {
+				unanswered = append(unanswered, addr)
+			}
+			log.Debug("lookup failed at server, trying alternate server", "serverAddr", addr,
+				"error", err)
+		}
+		if retry > r.Retries || len(unanswered) == 0 {
+			return nil, err
+		}
+		log.Debug("no server answered, retrying after backoff", "zone", zone, "servers",
+			unanswered, "retry", retry)
+		if werr := r.backoff(ctx, retry); werr != nil {
+			return nil, res.fail(nil, path, true, "aborted: %v", werr)
+		}
+		servers = unanswered
+	}
+}
+
+//iterateAt sends q to addr, a server of zone, and follows the redirections of its answer. If q has
+//the QOMinInfoLeakage option, only the redirection of the name one label below zone is asked for
+//unless it is the name of q. If zone is empty, q is sent as is.
+func (r *Resolver) iterateAt(ctx context.Context, res *resolution, q *query.Name, zone string,
+	addr net.Addr, path []string) (*message.Message, error) {
+	if res.steps >= r.MaxSteps {
+		return nil, res.exceeded(BudgetSteps, "%d queries", r.MaxSteps)
+	}
+	res.steps++
+	sent := q
+	if next := nextName(q.Name, zone); q.ContainsOption(query.QOMinInfoLeakage) && next != q.Name {
+		sent = &query.Name{
+			Context:    q.Context,
+			Name:       next,
+			Types:      []object.Type{object.OTRedirection},
+			Expiration: q.Expiration,
+			Options:    q.Options,
+		}
+	}
+	log.Debug("sending query in recursive lookup", "serverAddr", addr, "query", sent)
+	msg := message.Message{Token: token.New(), Content: []section.Section{sent}}
+	start := time.Now()
+	queryCtx, cancel := context.WithTimeout(ctx, r.hopTimeout())
+	answer, err := r.pool.query(queryCtx, msg, addr, r.IdleTimeout, r.dial, r.Codec)
+	cancel()
+	step := TraceStep{Server: addr, RTT: time.Since(start), Token: msg.Token, Answer: answer,
+		Err: err}
+	if err == nil && len(answer.Content) == 0 {
+		step.Err = errors.New("received empty answer")
+	}
+	if ctx.Err() != nil {
+		r.trace(ctx, step)
+		return nil, res.fail(addr, path, true, "aborted: %v", ctx.Err())
+	}
+	if step.Err != nil {
+		r.trace(ctx, step)
+		rerr := res.fail(addr, path, false, "no answer: %v", step.Err)
+		rerr.unanswered = true
+		return nil, rerr
+	}
+	log.Info("recursive resolver rcv answer", "answer", answer, "query", sent)
+	isFinal, isRedir, ref := r.handleAnswer(answer, sent)
+	if sent != q {
+		if _, ok := ref.redirs[sent.Name]; !ok {
+			//sent.Name is not delegated by zone, such that its servers must be asked for q.
+			r.trace(ctx, step)
+			log.Debug("no redirection for minimized query, sending full query", "serverAddr", addr,
+				"query", sent)
+			return r.iterateAt(ctx, res, q, "", addr, path)
+		}
+		isFinal, isRedir = false, true
+	}
+	if isFinal {
+		r.trace(ctx, step)
+		return &answer, nil
+	}
+	if !isRedir {
+		r.trace(ctx, step)
+		return nil, res.fail(addr, path, false, "server neither answered nor redirected the query")
+	}
+	zone, targets, err := ref.targets(q.Name)
+	if err != nil {
+		r.trace(ctx, step)
+		return nil, res.fail(addr, path, false, "%v", err)
+	}
+	for _, target := range targets {
+		for _, followed := range path {
+			if target == followed {
+				r.trace(ctx, step)
+				return nil, res.fail(addr, path, false, "delegation loop: redirected to %s again",
+					target)
+			}
+		}
+	}
+	if res.depth >= r.MaxDepth {
+		step.Redirect = targets[0]
+		r.trace(ctx, step)
+		return nil, res.exceeded(BudgetDepth, "%d delegations", r.MaxDepth)
+	}
+	res.depth++
+	defer func() { res.depth-- }()
+	path = append(path[:len(path):len(path)], targets...)
+	glued, glueless := ref.addrs(targets)
+	step.Redirect = targets[0]
+	if len(glued) > 0 {
+		step.Next = glued[0]
+	}
+	r.trace(ctx, step)
+	var lastErr error
+	learned := learnedReferral{zone: zone, context: q.Context, targets: targets,
+		assertions: ref.assertions}
+	if len(glued) > 0 {
+		msg, err := r.iterate(ctx, res, q, zone, glued, path)
+		if err == nil {
+			learned.servers = glued
+			learnReferral(ctx, learned)
+		}
+		if err == nil || isFatal(err) {
+			return msg, err
+		}
+		lastErr = err
+	}
+	if len(glueless) == 0 {
+		return nil, lastErr
+	}
+	log.Debug("looking up servers without glue records", "targets", glueless)
+	resolved, err := r.resolveGlue(ctx, res, q, ref, glueless)
+	if isFatal(err) {
+		return nil, err
+	}
+	if len(resolved) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, res.fail(addr, path, false,
+			"dead end: the addresses of the servers of %s could not be obtained: %v",
+			strings.Join(targets, ", "), err)
+	}
+	final, err := r.iterate(ctx, res, q, zone, resolved, path)
+	if err == nil {
+		learned.servers = resolved
+		learnReferral(ctx, learned)
+	}
+	return final, err
+}
+
+//nextName returns the name one label below zone on the way to name, or name if it is not below
+//zone or zone is empty.
+func nextName(name, zone string) string {
+	if zone == "" || name == zone || !isSubdomain(name, zone) {
+		return name
+	}
+	labels := strings.Split(strings.TrimSuffix(strings.TrimSuffix(name, zone), "."), ".")
+	if zone == "." {
+		return labels[len(labels)-1] + "."
+	}
+	return labels[len(labels)-1] + "." + zone
+}
+
+//resolveGlue looks up the missing service information and ip addresses of the given redirection
+//targets and server names starting at the root servers. It returns the obtained server addresses.
+func (r *Resolver) resolveGlue(ctx context.Context, res *resolution, q *query.Name, ref referral,
+	names []string) ([]net.Addr, error) {
+	var addrs []net.Addr
+	var err error
+	for _, name := range names {
+		srvs, ok := ref.srvs[name]
+		if !ok {
+			//name is a redirection target without service information
+			var sub referral
+			if sub, err = r.lookupGlue(ctx, res, q, name, object.OTServiceInfo); err != nil {
+				if isFatal(err) {
+					return nil, err
+				}
+				continue
+			}
+			srvs = sub.srvs[name]
+			ref = ref.merge(sub)
+		} else {
+			//name is the host of a service
+			srvs = nil
+			for _, list := range ref.srvs {
+				for _, srv := range list {
+					if srv.Name == name {
+						srvs = append(srvs, srv)
+					}
+				}
+			}
+		}
+		for _, srv := range srvs {
+			ips := ref.ips[srv.Name]
+			if len(ips) == 0 {
+				var sub referral
+				if sub, err = r.lookupGlue(ctx, res, q, srv.Name, object.OTIP4Addr,
+					object.OTIP6Addr); err != nil {
+					if isFatal(err) {
+						return nil, err
+					}
+					continue
+				}
+				ips = sub.ips[srv.Name]
+			}
+			for _, ip := range ips {
+				if addr, err := serverAddr(ip, srv.Port); err == nil {
+					addrs = append(addrs, addr)
+				}
+			}
+		}
+	}
+	return addrs, err
+}
+
+//lookupGlue resolves name for the given types in a separate recursive lookup sharing the step
+//budget of res and returns the information contained in the answer. The names of servers are not
+//looked up with minimized queries as they do not reveal the name of q and a server may only send
+//the addresses of its delegates when asked for them.
+func (r *Resolver) lookupGlue(ctx context.Context, res *resolution, q *query.Name, name string,
+	types ...object.Type) (referral, error) {
+	if res.resolving[name] {
+		return referral{}, res.fail(nil, nil, false, "glue loop: %s is needed to resolve itself",
+			name)
+	}
+	res.resolving[name] = true
+	depth := res.depth
+	res.depth = 0
+	defer func() {
+		delete(res.resolving, name)
+		res.depth = depth
+	}()
+	glueQuery := &query.Name{
+		Name:       name,
+		Context:    q.Context,
+		Types:      types,
+		Expiration: q.Expiration,
+	}
+	for _, opt := range q.Options {
+		if opt != query.QOMinInfoLeakage {
+			glueQuery.Options = append(glueQuery.Options, opt)
+		}
+	}
+	answer, err := r.iterateClosest(ctx, res, glueQuery)
+	if err != nil {
+		return referral{}, err
+	}
+	_, _, ref := r.handleAnswer(*answer, glueQuery)
+	return ref, nil
+}
+
+//referral contains the redirections, service information and ip addresses of an answer which are
+//needed to continue a recursive lookup. All maps are indexed by the fully qualified name of the
+//assertion containing the object. assertions contains the assertions the information was taken
+//from.
+type referral struct {
+	redirs     map[string][]string
+	srvs       map[string][]object.ServiceInfo
+	ips        map[string][]string
+	assertions []*section.Assertion
+}
+
+func newReferral() referral {
+	return referral{
+		redirs: make(map[string][]string),
+		srvs:   make(map[string][]object.ServiceInfo),
+		ips:    make(map[string][]string),
+	}
+}
+
+//merge returns a referral containing the information of ref and other.
+func (ref referral) merge(other referral) referral {
+	merged := newReferral()
+	for _, r := range []referral{ref, other} {
+		merged.assertions = append(merged.assertions, r.assertions...)
+		for name, values := range r.redirs {
+			merged.redirs[name] = append(merged.redirs[name], values...)
+		}
+		for name, values := range r.srvs {
+			merged.srvs[name] = append(merged.srvs[name], values...)
+		}
+		for name, values := range r.ips {
+			merged.ips[name] = append(merged.ips[name], values...)
+		}
+	}
+	return merged
+}
+
+//targets returns the zone of the most specific redirection for name and its redirection targets.
+//Redirections of redirection targets contained in the same answer are followed.
+func (ref referral) targets(name string) (string, []string, error) {
+	redirected := ""
+	for key := range ref.redirs {
+		if isSubdomain(name, key) && len(key) > len(redirected) {
+			redirected = key
+		}
+	}
+	if redirected == "" {
+		return "", nil, errors.New("answer contains no redirection for the queried name")
+	}
+	var targets []string
+	seen := map[string]bool{redirected: true}
+	next := ref.redirs[redirected]
+	for len(next) > 0 {
+		target := next[0]
+		next = next[1:]
+		if seen[target] {
+			if _, ok := ref.redirs[target]; ok {
+				return "", nil, fmt.Errorf("redirect loop detected at target %s", target)
+			}
+			continue
+		}
+		seen[target] = true
+		if further, ok := ref.redirs[target]; ok {
+			next = append(next, further...)
+		} else {
+			targets = append(targets, target)
+		}
+	}
+	return redirected, targets, nil
+}
+
+//addrs returns the addresses of the targets' servers whose service information and ip addresses
+//are contained in ref. glueless contains the targets without service information and the names of
+//the servers without ip address.
+func (ref referral) addrs(targets []string) (glued []net.Addr, glueless []string) {
+	for _, target := range targets {
+		srvs, ok := ref.srvs[target]
+		if !ok {
+			glueless = append(glueless, target)
+			continue
+		}
+		for _, srv := range srvs {
+			ips, ok := ref.ips[srv.Name]
+			if !ok {
+				glueless = append(glueless, srv.Name)
+				continue
+			}
+			for _, ip := range ips {
+				addr, err := serverAddr(ip, srv.Port)
+				if err != nil {
+					log.Warn("received ip address or port is malformed", "ip", ip, "error", err)
+					continue
+				}
+				glued = append(glued, addr)
+			}
+		}
+	}
+	return
+}
+
+//serverAddr returns the TCP address of the server with the given ip address and port.
+func serverAddr(ip string, port uint16) (net.Addr, error) {
+	return net.ResolveTCPAddr("tcp", net.JoinHostPort(ip, strconv.Itoa(int(port))))
+}
+
+//isSubdomain returns true if name is equal to zone or lies within it.
+func isSubdomain(name, zone string) bool {
+	return name == zone || zone == "." || strings.HasSuffix(name, "."+zone)
+}
@@ -0,0 +1,101 @@
+package libresolve
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+const (
+	rootIP   = "192.0.2.1"
+	testPort = 55553
+)
+
+//redirection returns the unsigned redirection of name in zone to target.
+func redirection(name, zone, target string) *section.Assertion {
+	return &section.Assertion{SubjectName: name, SubjectZone: zone, Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTRedirection, Value: target}}}
+}
+
+//glue returns the unsigned service information and ip addresses of the server name in zone. The
+//service is provided by the server itself.
+func glue(name, zone string, ips ...string) *section.Assertion {
+	a := &section.Assertion{SubjectName: name, SubjectZone: zone, Context: "."}
+	a.Content = append(a.Content, object.Object{Type: object.OTServiceInfo,
+		Value: object.ServiceInfo{Name: a.FQDN(), Port: testPort}})
+	for _, ip := range ips {
+		a.Content = append(a.Content, object.Object{Type: object.OTIP4Addr, Value: ip})
+	}
+	return a
+}
+
+//recursiveResolver returns a resolver in Recursive mode without caches whose servers are reached
+//over in-memory connections. The server at an ip address answers queries with the sections stored
+//in servers under its ip address and the queried name. The root server is at rootIP.
+func recursiveResolver(servers map[string]map[string][]section.Section) *Resolver {
+	r := New([]net.Addr{&net.TCPAddr{IP: net.ParseIP(rootIP), Port: testPort}}, nil, Recursive,
+		nil, 1)
+	r.Assertions, r.NegAssertions, r.DelegationRetention = nil, nil, 0
+	r.Dialer = DialerFunc(func(ctx context.Context, addr net.Addr) (net.Conn, error) {
+		client, server := net.Pipe()
+		go verifyingServer(server, servers[addr.(*net.TCPAddr).IP.String()])
+		return client, nil
+	})
+	return r
+}
+
+func TestRecursiveLoops(t *testing.T) {
+	www := []section.Section{&section.Assertion{SubjectName: "www", SubjectZone: "ethz.ch.",
+		Context: ".", Content: []object.Object{object.Object{Type: object.OTIP4Addr,
+			Value: "192.0.2.80"}}}}
+	var tests = []struct {
+		servers map[string]map[string][]section.Section
+		err     string
+	}{
+		//the second server delegates ethz.ch. back to itself
+		{map[string]map[string][]section.Section{
+			rootIP: {"www.ethz.ch.": {redirection("ch", ".", "ns.ch."),
+				glue("ns", "ch.", "192.0.2.2")}},
+			"192.0.2.2": {"www.ethz.ch.": {redirection("ethz", "ch.", "ns.ch."),
+				glue("ns", "ch.", "192.0.2.2")}},
+		}, "delegation loop: redirected to ns.ch. again"},
+		//the redirection targets of an answer redirect to each other
+		{map[string]map[string][]section.Section{
+			rootIP: {"www.ethz.ch.": {redirection("ch", ".", "ns.ch."),
+				redirection("ns", "ch.", "ch.")}},
+		}, "redirect loop detected at target ch."},
+		//the address of the server of ch. can only be obtained from itself
+		{map[string]map[string][]section.Section{
+			rootIP: {"www.ethz.ch.": {redirection("ch", ".", "ns.ch.")},
+				"ns.ch.": {redirection("ch", ".", "ns.ch.")}},
+		}, "dead end: the addresses of the servers of ns.ch. could not be obtained"},
+		//the first server of ch. does not answer, the alternate one does
+		{map[string]map[string][]section.Section{
+			rootIP: {"www.ethz.ch.": {redirection("ch", ".", "ns.ch."),
+				glue("ns", "ch.", "192.0.2.2", "192.0.2.3")}},
+			"192.0.2.3": {"www.ethz.ch.": www},
+		}, ""},
+	}
+	for i, test := range tests {
+		r := recursiveResolver(test.servers)
+		q := &query.Name{Name: "www.ethz.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr},
+			Expiration: time.Now().Add(time.Minute).Unix()}
+		answer, err := r.ClientLookup(context.Background(), q)
+		r.Close()
+		if test.err == "" {
+			if err != nil || len(answer.Content) != 1 {
+				t.Errorf("%d: lookup failed. answer=%v error=%v", i, answer, err)
+			}
+			continue
+		}
+		if _, ok := err.(*ResolutionError); !ok || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%d: wrong error. expected=%s actual=%v", i, test.err, err)
+		}
+	}
+}
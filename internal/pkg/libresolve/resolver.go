@@ -1,17 +1,24 @@
 // Package libresolve implements a recursive and stub resolver for RAINS.
+//
+// Reverse lookups are not supported. This implementation of the protocol has no address query,
+// address assertion and address zone sections, so there is neither a query to send for an address
+// nor an answer to verify.
 package libresolve
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"io"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/netsec-ethz/rains/internal/pkg/cache"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
 	"github.com/netsec-ethz/rains/internal/pkg/connection"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeHashMap"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
@@ -19,7 +26,6 @@ import (
 	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/token"
-	"github.com/netsec-ethz/rains/internal/pkg/util"
 )
 
 var (
@@ -27,6 +33,13 @@ var (
 	defaultFailFast     = true
 	defaultInsecureTLS  = false
 	defaultQueryTimeout = time.Duration(1000) //in milliseconds
+	defaultCacheSize    = 10000
+	defaultStagger      = 100 * time.Millisecond
+	defaultIdleTimeout  = 30 * time.Second
+	defaultMaxSteps     = 32
+	defaultMaxDepth     = 16
+	defaultRetention    = 24 * time.Hour
+	defaultRetryBackoff = 100 * time.Millisecond
 )
 
 type ResolutionMode int
@@ -46,201 +59,204 @@ type Resolver struct {
 	FailFast        bool
 	Delegations     *safeHashMap.Map
 	Connections     cache.Connection
+	//Dialer establishes the connections to root servers, forwarders and authoritative servers. If
+	//it is nil, a TLSDialer is used. Root servers and forwarders given as *DialAddr are reached
+	//with their own dialer.
+	Dialer Dialer
+	//Codec encodes the queries and answers sent by the resolver. Received messages are accepted in
+	//any registered codec. It defaults to CBOR.
+	Codec codec.Codec
+	//ForwarderStagger is the time after which the query is additionally sent to the next
+	//forwarder in Forward mode if none has answered yet. Forwarders are tried fastest first.
+	ForwarderStagger time.Duration
+	//IdleTimeout is the time a connection to a root server, forwarder or authoritative server is
+	//kept open after its last query such that subsequent lookups can reuse it. If it is not
+	//positive, a new connection is established for each query.
+	IdleTimeout time.Duration
+	//MaxSteps is the maximum number of queries sent during a recursive lookup, including the
+	//lookups of missing glue records.
+	MaxSteps int
+	//MaxDepth is the maximum number of delegations followed from the root servers in a recursive
+	//lookup. Glue records are looked up with a separate depth budget.
+	MaxDepth int
+	//DelegationRetention is the time the servers of a zone, learned from the redirections received
+	//in a recursive lookup, are remembered such that later lookups below the zone start at them
+	//instead of at the root servers. They are kept apart from Assertions and remembered at most
+	//until the signatures of the redirections expire. Unless Verification is disabled, only the
+	//servers of verified redirections are remembered. If it is not positive, every lookup starts
+	//at the root servers.
+	DelegationRetention time.Duration
+	//HopTimeout is the time a server is given to answer a single query, including establishing
+	//the connection. In Forward mode, it bounds the race of all forwarders. If it is not positive,
+	//DialTimeout is used.
+	HopTimeout time.Duration
+	//Retries is the number of times the servers of a zone or the forwarders which did not answer a
+	//query are asked again after all alternate servers failed as well. Servers which answered are
+	//not asked again. The resolver waits RetryBackoff before the first retry and doubles the wait
+	//for each further one. Retries count towards MaxSteps and LookupTimeout.
+	Retries      int
+	RetryBackoff time.Duration
+	//LookupTimeout, if positive, is the time after which a lookup is aborted, including the
+	//verification of its answer. A lookup exceeding MaxSteps, MaxDepth or LookupTimeout fails with
+	//a *BudgetExceededError.
+	LookupTimeout time.Duration
+	//Options are the query options of lookups whose query specifies none. A query's own options
+	//take precedence. The options are sent along with all queries of a lookup. The resolver itself
+	//honors CachedAnswersOnly, by not contacting any server in Recursive mode, and
+	//ExpiredAssertionsOk. The other options are left to the servers.
+	Options []query.Option
+	//Assertions caches the signed assertions of received answers until their signatures expire.
+	//Repeated lookups are answered from it. Its size can be changed by replacing it with
+	//cache.NewAssertion(size). If it is nil, every lookup is sent to the network.
+	Assertions cache.Assertion
+	//NegAssertions caches the signed shards, zones and pshards of received answers such that
+	//repeated lookups for non existing names are answered from it. If it is nil, only positive
+	//answers are cached.
+	NegAssertions cache.NegativeAssertion
+	//Verification determines whether the signatures of answers are verified along the delegation
+	//chain starting at the trust anchors added with AddTrustAnchor.
+	Verification VerificationMode
+	//Unverified, if not nil, is called in Permissive mode for each section of an answer which
+	//could not be verified.
+	Unverified func(s section.Section, err error)
+	//Trace, if not nil, is called after each query sent to a server.
+	Trace func(step TraceStep)
+	//OnResolution, if not nil, is called after each lookup through ClientLookup or ServerLookup
+	//with its outcome and all its steps.
+	OnResolution func(res Resolution)
+	trust        *keyStore
+	forwarders   *rttStats
+	pool         *connPool
+	referrals    *referralCache
+	overrides    *overrideTable
+	metrics      *metrics
+	inflight     *inflight
+	//upstreamMux protects RootNameServers and Forwarders while they are replaced by SetUpstream.
+	upstreamMux sync.RWMutex
+}
+
+//TraceStep describes one query sent during a lookup.
+type TraceStep struct {
+	Server net.Addr
+	RTT    time.Duration
+	//Token is the token of the query. Servers log it if the query has the TokenTracing option.
+	Token  token.Token
+	Answer message.Message
+	//Err is set if no answer was received from Server.
+	Err error
+	//Redirect is the redirection target which is followed after this step and Next the address of
+	//its first authoritative server. Both are empty if the answer is final or the lookup cannot
+	//continue. Next is also empty if the server's address must first be looked up.
+	Redirect string
+	Next     net.Addr
 }
 
 //New creates a resolver with the given parameters and default settings
 func New(rootNS, forwarders []net.Addr, mode ResolutionMode, addr net.Addr, maxConn int) *Resolver {
 	return &Resolver{
-		RootNameServers: rootNS,
-		Forwarders:      forwarders,
-		Mode:            mode,
-		InsecureTLS:     defaultInsecureTLS,
-		DialTimeout:     defaultTimeout,
-		FailFast:        defaultFailFast,
-		Delegations:     safeHashMap.New(),
-		Connections:     cache.NewConnection(maxConn),
+		RootNameServers:     rootNS,
+		Forwarders:          forwarders,
+		Mode:                mode,
+		InsecureTLS:         defaultInsecureTLS,
+		DialTimeout:         defaultTimeout,
+		Codec:               codec.CBOR{},
+		FailFast:            defaultFailFast,
+		Delegations:         safeHashMap.New(),
+		Connections:         cache.NewConnection(maxConn),
+		Assertions:          cache.NewAssertion(defaultCacheSize),
+		NegAssertions:       cache.NewNegAssertion(defaultCacheSize),
+		ForwarderStagger:    defaultStagger,
+		IdleTimeout:         defaultIdleTimeout,
+		MaxSteps:            defaultMaxSteps,
+		MaxDepth:            defaultMaxDepth,
+		DelegationRetention: defaultRetention,
+		RetryBackoff:        defaultRetryBackoff,
+		trust:               newKeyStore(),
+		forwarders:          newRTTStats(),
+		pool:                newConnPool(),
+		referrals:           newReferralCache(),
+		overrides:           newOverrideTable(),
+		metrics:             newMetrics(),
+		inflight:            newInflight(),
 	}
 }
 
-//ClientLookup forwards the query to the specified forwarders or performs a recursive lookup starting at
-//the specified root servers. It returns the received information.
-func (r *Resolver) ClientLookup(query *query.Name) (*message.Message, error) {
-	switch r.Mode {
-	case Recursive:
-		return r.recursiveResolve(query)
-	case Forward:
-		return r.forwardQuery(query)
-	default:
-		return nil, fmt.Errorf("Unsupported resolution mode: %v", r.Mode)
-	}
+//Close closes all connections which are kept open for reuse. The resolver can still be used
+//afterwards.
+func (r *Resolver) Close() {
+	r.pool.closeAll()
+}
+
+//SetUpstream replaces the root servers and the forwarders. Lookups which have already started
+//continue with the previous ones. In contrast to setting RootNameServers and Forwarders, it is
+//safe to call while the resolver is in use.
+func (r *Resolver) SetUpstream(rootNS, forwarders []net.Addr) {
+	r.upstreamMux.Lock()
+	defer r.upstreamMux.Unlock()
+	r.RootNameServers = rootNS
+	r.Forwarders = forwarders
+}
+
+//upstream returns the root servers and the forwarders.
+func (r *Resolver) upstream() (rootNS, forwarders []net.Addr) {
+	r.upstreamMux.RLock()
+	defer r.upstreamMux.RUnlock()
+	return r.RootNameServers, r.Forwarders
+}
+
+//ClientLookup answers the query from the cache or forwards it to the specified forwarders or
+//performs a recursive lookup starting at the specified root servers. It returns the received
+//information. The lookup is aborted as soon as ctx is done, including pending dials and reads, or
+//after LookupTimeout. Each query sent to a server additionally times out after HopTimeout.
+func (r *Resolver) ClientLookup(ctx context.Context, query *query.Name) (*message.Message, error) {
+	return r.lookup(ctx, query)
 }
 
-//ServerLookup forwards the query to the specified forwarders or performs a recursive lookup
-//starting at the specified root servers. It sends the received information to conInfo.
-func (r *Resolver) ServerLookup(query *query.Name, addr net.Addr, token token.Token) {
-	var msg *message.Message
+//ServerLookup answers the query from the cache or forwards it to the specified forwarders or
+//performs a recursive lookup starting at the specified root servers. It sends the received
+//information to conInfo. The lookup is aborted as soon as ctx is done. It returns an error if the
+//query could not be answered, in which case nothing is sent.
+func (r *Resolver) ServerLookup(ctx context.Context, query *query.Name, addr net.Addr,
+	token token.Token) error {
 	log.Info("recResolver received query", "query", query, "token", token)
-	switch r.Mode {
-	case Recursive:
-		msg, _ = r.recursiveResolve(query)
-	case Forward:
-		msg, _ = r.forwardQuery(query)
-	default:
-		log.Error("Unsupported resolution mode", "mode", r.Mode)
+	msg, err := r.lookup(ctx, query)
+	if err != nil {
+		log.Error("recResolver was not able to answer query", "query", query, "error", err)
+		return err
 	}
 	msg.Token = token
 	if conn, ok := r.Connections.GetConnection(addr); ok {
 		log.Info("recResolver answers query", "answer", msg, "token", token, "conn",
 			conn[0].RemoteAddr(), "resolver", conn[0].LocalAddr())
-		writer := cbor.NewWriter(conn[0])
-		if err := writer.Marshal(msg); err != nil {
+		if err := r.Codec.Encode(conn[0], msg); err != nil {
 			r.createConnAndWrite(addr, msg) //Connection has been closed in the mean time
 		}
 	} else {
 		r.createConnAndWrite(addr, msg)
 	}
+	return nil
 }
 
 func (r *Resolver) createConnAndWrite(addr net.Addr, msg *message.Message) {
-	conn, err := connection.CreateConnection(addr)
+	conn, err := r.connect(addr)
 	if err != nil {
 		log.Error("Was not able to open a connection", "dst", addr)
 		return
 	}
-	r.Connections.AddConnection(conn)
-	go r.answerDelegQueries(conn)
-	writer := cbor.NewWriter(conn)
-	if err := writer.Marshal(msg); err != nil {
+	if err := r.Codec.Encode(conn, msg); err != nil {
 		log.Error("failed to marshal message", err)
 		r.Connections.CloseAndRemoveConnections(addr)
 	}
 }
 
-func (r *Resolver) forwardQuery(q *query.Name) (*message.Message, error) {
-	if len(r.Forwarders) == 0 {
-		return nil, errors.New("forwarders must be specified to use this mode")
-	}
-	for _, forwarder := range r.Forwarders {
-		msg := message.Message{Token: token.New(), Content: []section.Section{q}}
-		answer, err := util.SendQuery(msg, forwarder, r.DialTimeout*time.Millisecond)
-		if err == nil {
-			return &answer, nil
-		}
-	}
-	return nil, fmt.Errorf("could not connect to any of the specified resolver: %v", r.Forwarders)
-}
-
-// recursiveResolve starts at the root and follows delegations until it receives an answer.
-func (r *Resolver) recursiveResolve(q *query.Name) (*message.Message, error) {
-	//Check for cached delegation assertion
-	for _, t := range q.Types {
-		if t == object.OTDelegation {
-			if a, ok := r.Delegations.Get(q.Name); ok {
-				log.Info("respond with a cached delegation", "delegation", a, "query", q)
-				return &message.Message{Content: []section.Section{a.(*section.Assertion)}}, nil
-			}
-			break
-		}
-	}
-	//Start recursive lookup
-	for _, root := range r.RootNameServers {
-		log.Debug("connecting to root server", "serverAddr", root, "query", q)
-		addr := root
-		for {
-			msg := message.Message{Token: token.New(), Content: []section.Section{q}}
-			answer, err := util.SendQuery(msg, addr, r.DialTimeout*time.Millisecond)
-			if err != nil || len(answer.Content) == 0 {
-				continue
-			}
-			log.Info("recursive resolver rcv answer", "answer", answer, "query", q)
-			isFinal, isRedir, redirMap, srvMap, ipMap := r.handleAnswer(answer, q)
-			log.Info("handling answer in recursive lookup", "serverAddr", addr, "isFinal",
-				isFinal, "isRedir", isRedir, "redirMap", redirMap, "srvMap", srvMap, "ipMap", ipMap)
-			if isFinal {
-				return &answer, nil
-			} else if isRedir {
-				redirTarget, err := followRedirect(redirMap, answer, q.Name)
-				if err != nil {
-					return nil, err
-				}
-				if addr, err = updateConnInfo(answer, redirTarget, srvMap, ipMap); err != nil {
-					return nil, err
-				}
-			} else {
-				log.Warn("received unexpected answer to query. Recursive lookup cannot be continued",
-					"authServer", addr)
-				break
-			}
-		}
-	}
-	return nil, fmt.Errorf("Was not able to obtain an answer through a recursive lookup for query: %s",
-		q.String())
-}
-
-//followRedirect returns the last name of the redirect chain which should have a corresponding
-//service information object
-func followRedirect(redirMap map[string]string, msg message.Message, name string) (string, error) {
-	// Check if there is a redirection for a suffix we are interested in.
-	redirTarget := ""
-	for key, value := range redirMap {
-		if strings.HasSuffix(name, key) {
-			redirTarget = value
-			break
-		}
-	}
-	if redirTarget == "" {
-		return "", fmt.Errorf("failed to find result or redirection, response was: %v", msg)
-	}
-
-	// Follow redir until we encounter a srv.
-	seen := make(map[string]bool)
-	for {
-		if _, ok := seen[redirTarget]; ok {
-			return "", fmt.Errorf("redirect loop detected, target %q, response: %v", redirTarget, msg)
-		}
-		seen[redirTarget] = true
-		if next, ok := redirMap[redirTarget]; ok {
-			redirTarget = next
-		} else {
-			break
-		}
-	}
-	return redirTarget, nil
-}
-
-//updateConnInfo returns the network address of the next hop in the recursive lookup. If not
-//sufficient information is available, an error is returned
-func updateConnInfo(msg message.Message, redirTarget string, srvMap map[string]object.ServiceInfo,
-	ipMap map[string]string) (addr net.Addr, err error) {
-	if srvInfo, ok := srvMap[redirTarget]; ok {
-		if ipAddr, ok := ipMap[srvInfo.Name]; ok {
-			addr, err = net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", ipAddr, srvInfo.Port))
-			if err != nil {
-				return nil, fmt.Errorf("received IP address or port is malformed: %v", msg)
-			}
-		} else {
-			return nil, fmt.Errorf(
-				"serviceInfo target could not be found in response, target: %q, response: %v",
-				srvInfo.Name, msg)
-		}
-	} else {
-		return nil, fmt.Errorf(
-			"received incomplete response, missing serviceInfo for target FQDN %q, response: %v",
-			redirTarget, msg)
-	}
-	return
-}
-
 //handleAnswer stores delegation assertions in the delegationCache. It informs the caller if msg
 //answers q. It also returns if the msg contains a redirect assertion which indicates that
-//another lookup must be performed. Information that is relevant for the next lookup are returned in
-//maps.
+//another lookup must be performed. Information that is relevant for the next lookup is returned in
+//a referral.
 func (r *Resolver) handleAnswer(msg message.Message, q *query.Name) (isFinal bool, isRedir bool,
-	redirMap map[string]string, srvMap map[string]object.ServiceInfo, ipMap map[string]string) {
+	ref referral) {
 	types := make(map[object.Type]bool)
-	redirMap = make(map[string]string)
-	srvMap = make(map[string]object.ServiceInfo)
-	ipMap = make(map[string]string)
+	ref = newReferral()
 	for _, t := range q.Types {
 		types[t] = true
 	}
@@ -248,39 +264,46 @@ func (r *Resolver) handleAnswer(msg message.Message, q *query.Name) (isFinal boo
 		//FIXME check signature of sections and request delegations if necessary
 		switch s := sec.(type) {
 		case *section.Assertion:
-			r.handleAssertion(s, redirMap, srvMap, ipMap, types, q.Name, &isFinal, &isRedir)
+			if r.handleAssertion(s, ref, types, q.Name, &isFinal, &isRedir) {
+				ref.assertions = append(ref.assertions, s)
+			}
 		case *section.Shard:
 			handleShard(s, types, q.Name, &isFinal)
 		case *section.Zone:
-			r.handleZone(s, redirMap, srvMap, ipMap, types, q.Name, &isFinal, &isRedir)
+			ref.assertions = append(ref.assertions,
+				r.handleZone(s, ref, types, q.Name, &isFinal, &isRedir)...)
 		}
 	}
 	return
 }
 
-func (r *Resolver) handleAssertion(a *section.Assertion, redirMap map[string]string,
-	srvMap map[string]object.ServiceInfo, ipMap map[string]string, types map[object.Type]bool,
-	name string, isFinal, isRedir *bool) {
+//handleAssertion adds the redirections, service information and ip addresses of a to ref. It
+//returns true if a contains any of them.
+func (r *Resolver) handleAssertion(a *section.Assertion, ref referral, types map[object.Type]bool,
+	name string, isFinal, isRedir *bool) bool {
+	referred := false
 	for _, o := range a.Content {
 		switch o.Type {
 		case object.OTRedirection:
-			redirMap[a.FQDN()] = o.Value.(string)
+			ref.redirs[a.FQDN()] = append(ref.redirs[a.FQDN()], o.Value.(string))
 			if _, ok := types[object.OTRedirection]; !ok || a.FQDN() != name {
 				*isRedir = true
 			}
+			referred = true
 		case object.OTDelegation:
 			r.Delegations.Add(a.FQDN(), a)
 		case object.OTServiceInfo:
-			srvMap[a.FQDN()] = o.Value.(object.ServiceInfo)
-		case object.OTIP6Addr:
-			ipMap[a.FQDN()] = o.Value.(string)
-		case object.OTIP4Addr:
-			ipMap[a.FQDN()] = o.Value.(string)
+			ref.srvs[a.FQDN()] = append(ref.srvs[a.FQDN()], o.Value.(object.ServiceInfo))
+			referred = true
+		case object.OTIP6Addr, object.OTIP4Addr:
+			ref.ips[a.FQDN()] = append(ref.ips[a.FQDN()], o.Value.(string))
+			referred = true
 		}
 		if _, ok := types[o.Type]; ok && a.FQDN() == name {
 			*isFinal = true
 		}
 	}
+	return referred
 }
 
 //handleShard checks if s is an answer to the query. Note that a shard containing a positive answer
@@ -292,27 +315,54 @@ func handleShard(s *section.Shard, types map[object.Type]bool, name string, isFi
 	}
 }
 
-//handleZone checks if z or the contained assertions are an answer to the query.
-func (r *Resolver) handleZone(z *section.Zone, redirMap map[string]string,
-	srvMap map[string]object.ServiceInfo, ipMap map[string]string, types map[object.Type]bool,
-	name string, isFinal, isRedir *bool) {
+//handleZone checks if z or the contained assertions are an answer to the query. It returns the
+//contained assertions with redirections, service information or ip addresses together with the
+//context and zone of z.
+func (r *Resolver) handleZone(z *section.Zone, ref referral, types map[object.Type]bool,
+	name string, isFinal, isRedir *bool) []*section.Assertion {
+	var referred []*section.Assertion
 	for _, sec := range z.Content {
-		r.handleAssertion(sec, redirMap, srvMap, ipMap, types, name, isFinal, isRedir)
+		if r.handleAssertion(sec, ref, types, name, isFinal, isRedir) {
+			referred = append(referred, sec.Copy(z.Context, z.SubjectZone))
+		}
 	}
 	if strings.HasSuffix(name, z.SubjectZone) {
 		*isFinal = true
 	}
+	return referred
+}
+
+//Connect opens a connection to the server at addr over which ServerLookup sends its answers
+//unless there already is one. This allows the server to stop accepting connections while the
+//resolver still answers its queries.
+func (r *Resolver) Connect(addr net.Addr) error {
+	if _, ok := r.Connections.GetConnection(addr); ok {
+		return nil
+	}
+	_, err := r.connect(addr)
+	return err
+}
+
+//connect opens a connection to the server at addr, adds it to the cache and answers the
+//delegation queries the server sends over it.
+func (r *Resolver) connect(addr net.Addr) (net.Conn, error) {
+	conn, err := connection.CreateConnection(addr)
+	if err != nil {
+		return nil, err
+	}
+	r.Connections.AddConnection(conn)
+	go r.answerDelegQueries(conn)
+	return conn, nil
 }
 
 //answerDelegQueries answers delegation queries on conn from its cache. The cache is populated
 //through delegations received in a recursive lookup.
 func (r *Resolver) answerDelegQueries(conn net.Conn) {
-	reader := cbor.NewReader(conn)
-	writer := cbor.NewWriter(conn)
+	reader := codec.NewReader(conn, cbor.Limits{})
 	for {
-		var msg message.Message
-		if err := reader.Unmarshal(&msg); err != nil {
-			if err.Error() == "failed to read tag: EOF" {
+		msg, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
 				log.Info("Connection has been closed", "remoteAddr", conn.RemoteAddr())
 			} else {
 				log.Warn(fmt.Sprintf("failed to read from client: %v", err))
@@ -320,10 +370,10 @@ func (r *Resolver) answerDelegQueries(conn net.Conn) {
 			r.Connections.CloseAndRemoveConnection(conn)
 			break
 		}
-		answer := r.getDelegations(msg)
+		answer := r.getDelegations(*msg)
 		log.Info("received delegation query. Answer with cached assertions", "query", msg, "assertions", answer)
-		msg = message.Message{Token: msg.Token, Content: answer}
-		if err := writer.Marshal(&msg); err != nil {
+		msg = &message.Message{Token: msg.Token, Content: answer}
+		if err := r.Codec.Encode(conn, msg); err != nil {
 			log.Error("failed to marshal message", err)
 			r.Connections.CloseAndRemoveConnection(conn)
 			break
@@ -0,0 +1,269 @@
+package libresolve
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//TrustAnchor contains the public keys of a zone which are trusted without verification.
+type TrustAnchor struct {
+	Zone    string
+	Context string
+	Keys    []keys.PublicKey
+}
+
+//LoadTrustAnchor adds the self signed delegation assertion stored at path (e.g. the root zone's
+//selfSignedRootDelegationAssertion.gob) as trust anchor.
+func (r *Resolver) LoadTrustAnchor(path string) error {
+	a, err := loadTrustAnchor(path)
+	if err != nil {
+		return err
+	}
+	return r.AddTrustAnchor(a)
+}
+
+//AddTrustAnchor adds the public keys delegated by the self signed delegation assertion a as trust
+//anchor. The signatures of answers are verified along the delegation chain starting at the trust
+//anchors if Verification is enabled. All keys and zone servers obtained through delegations so far
+//are discarded.
+func (r *Resolver) AddTrustAnchor(a *section.Assertion) error {
+	zone, pkeys, err := trustAnchorKeys(a)
+	if err != nil {
+		return err
+	}
+	r.AddTrustAnchorKeys(zone, a.Context, pkeys...)
+	return nil
+}
+
+//loadTrustAnchor returns the self signed delegation assertion stored at path.
+func loadTrustAnchor(path string) (*section.Assertion, error) {
+	a := new(section.Assertion)
+	if err := util.Load(path, a); err != nil {
+		return nil, fmt.Errorf("could not load trust anchor: %v", err)
+	}
+	return a, nil
+}
+
+//trustAnchorKeys returns the zone and the public keys delegated by the self signed delegation
+//assertion a. It returns an error if a contains no delegation or is not signed by its own keys.
+func trustAnchorKeys(a *section.Assertion) (string, []keys.PublicKey, error) {
+	anchorKeys := delegatedKeys(a)
+	if len(anchorKeys) == 0 {
+		return "", nil, errors.New("trust anchor does not contain a delegation")
+	}
+	if !checkSignatures(a, anchorKeys) {
+		return "", nil, errors.New("signature of trust anchor is missing or invalid")
+	}
+	zone := a.FQDN()
+	if a.SubjectName == "@" {
+		zone = a.SubjectZone
+	}
+	var pkeys []keys.PublicKey
+	for _, list := range anchorKeys {
+		pkeys = append(pkeys, list...)
+	}
+	return zone, pkeys, nil
+}
+
+//AddTrustAnchorKeys adds pkeys to the trust anchor of zone in context. The keys are trusted
+//without verification within their validity. Keys without validity are trusted forever. All keys
+//and zone servers obtained through delegations so far are discarded.
+func (r *Resolver) AddTrustAnchorKeys(zone, context string, pkeys ...keys.PublicKey) {
+	r.trust.mux.Lock()
+	defer r.trust.mux.Unlock()
+	r.trust.addAnchorKeys(zoneKeyID(zone, context), pkeys)
+	r.trust.delegated = make(map[zoneContext]map[keys.PublicKeyID][]keys.PublicKey)
+	r.referrals.clear()
+}
+
+//RotateTrustAnchor replaces all trust anchor keys of zone in context with pkeys. All keys and zone
+//servers obtained through delegations so far and all cached answers are discarded.
+func (r *Resolver) RotateTrustAnchor(zone, context string, pkeys ...keys.PublicKey) error {
+	if len(pkeys) == 0 {
+		return errors.New("a trust anchor needs at least one public key")
+	}
+	r.trust.mux.Lock()
+	defer r.trust.mux.Unlock()
+	id := zoneKeyID(zone, context)
+	delete(r.trust.anchors, id)
+	r.trust.addAnchorKeys(id, pkeys)
+	r.trust.delegated = make(map[zoneContext]map[keys.PublicKeyID][]keys.PublicKey)
+	r.referrals.clear()
+	r.flushAnswers()
+	return nil
+}
+
+//RemoveTrustAnchor removes the trust anchor of zone in context. All keys and zone servers obtained
+//through delegations so far and all cached answers are discarded.
+func (r *Resolver) RemoveTrustAnchor(zone, context string) {
+	r.trust.mux.Lock()
+	defer r.trust.mux.Unlock()
+	delete(r.trust.anchors, zoneKeyID(zone, context))
+	r.trust.delegated = make(map[zoneContext]map[keys.PublicKeyID][]keys.PublicKey)
+	r.referrals.clear()
+	r.flushAnswers()
+}
+
+//TrustAnchors returns all trust anchors sorted by context and zone.
+func (r *Resolver) TrustAnchors() []TrustAnchor {
+	r.trust.mux.RLock()
+	defer r.trust.mux.RUnlock()
+	var anchors []TrustAnchor
+	for id, pkeys := range r.trust.anchors {
+		anchor := TrustAnchor{Zone: id.zone, Context: id.context}
+		for _, list := range pkeys {
+			anchor.Keys = append(anchor.Keys, list...)
+		}
+		if len(anchor.Keys) > 0 {
+			anchors = append(anchors, anchor)
+		}
+	}
+	sort.Slice(anchors, func(i, j int) bool {
+		if anchors[i].Context != anchors[j].Context {
+			return anchors[i].Context < anchors[j].Context
+		}
+		return anchors[i].Zone < anchors[j].Zone
+	})
+	return anchors
+}
+
+//AcceptNextKeys adds the next keys announced in a to the trust anchor of a's zone if a is
+//signed by the zone's current trust anchor keys. This allows a trust anchor to be rolled over to
+//the zone's next key before its current keys expire. Expired trust anchor keys are removed as long
+//as a valid key remains. It returns the number of accepted keys. Verified answers about the apex
+//of a trust anchor's zone are passed to it automatically.
+func (r *Resolver) AcceptNextKeys(a *section.Assertion) (int, error) {
+	zone := a.FQDN()
+	if a.SubjectName == "@" {
+		zone = a.SubjectZone
+	}
+	var next []keys.PublicKey
+	for _, o := range a.Content {
+		if pkey, ok := o.Value.(keys.PublicKey); ok && o.Type == object.OTNextKey {
+			next = append(next, pkey)
+		}
+	}
+	if len(next) == 0 {
+		return 0, nil
+	}
+	id := zoneKeyID(zone, a.Context)
+	r.trust.mux.RLock()
+	anchorKeys := r.trust.anchorKeys(id)
+	r.trust.mux.RUnlock()
+	if len(anchorKeys) == 0 {
+		return 0, fmt.Errorf("there is no trust anchor for zone %s in context %s", zone, a.Context)
+	}
+	if !checkSignatures(a, anchorKeys) {
+		return 0, fmt.Errorf("next keys of %s are not signed by the trust anchor", zone)
+	}
+	r.trust.mux.Lock()
+	defer r.trust.mux.Unlock()
+	accepted := 0
+	for _, pkey := range next {
+		if !r.trust.containsAnchorKey(id, pkey) {
+			r.trust.addAnchorKeys(id, []keys.PublicKey{pkey})
+			accepted++
+		}
+	}
+	r.trust.removeExpiredAnchorKeys(id, clock.Now().Unix())
+	if accepted > 0 {
+		log.Info("accepted next keys of trust anchor", "zone", zone, "context", a.Context,
+			"keys", accepted)
+	}
+	return accepted, nil
+}
+
+//acceptVerifiedNextKeys passes the assertions of the verified section s which announce next keys
+//of a trust anchor's zone to AcceptNextKeys.
+func (r *Resolver) acceptVerifiedNextKeys(s section.Section) {
+	var assertions []*section.Assertion
+	switch s := s.(type) {
+	case *section.Assertion:
+		assertions = []*section.Assertion{s}
+	case *section.Shard:
+		for _, a := range s.Content {
+			assertions = append(assertions, a.Copy(s.Context, s.SubjectZone))
+		}
+	case *section.Zone:
+		for _, a := range s.Content {
+			assertions = append(assertions, a.Copy(s.Context, s.SubjectZone))
+		}
+	}
+	for _, a := range assertions {
+		if a.SubjectName != "@" {
+			continue
+		}
+		r.trust.mux.RLock()
+		_, isAnchor := r.trust.anchors[zoneKeyID(a.SubjectZone, a.Context)]
+		r.trust.mux.RUnlock()
+		if !isAnchor {
+			continue
+		}
+		if _, err := r.AcceptNextKeys(a); err != nil {
+			log.Warn("could not accept next keys of trust anchor", "assertion", a, "error", err)
+		}
+	}
+}
+
+//addAnchorKeys adds pkeys to the trust anchor with the given id. Keys without validity are valid
+//forever. The caller must hold the write lock.
+func (k *keyStore) addAnchorKeys(id zoneContext, pkeys []keys.PublicKey) {
+	if k.anchors[id] == nil {
+		k.anchors[id] = make(map[keys.PublicKeyID][]keys.PublicKey)
+	}
+	for _, pkey := range pkeys {
+		if pkey.ValidSince == 0 && pkey.ValidUntil == 0 {
+			pkey.ValidUntil = math.MaxInt64
+		}
+		k.anchors[id][pkey.PublicKeyID] = append(k.anchors[id][pkey.PublicKeyID], pkey)
+	}
+}
+
+//anchorKeys returns a copy of the keys of the trust anchor with the given id, as they are modified
+//in place by rollovers. The caller must hold the lock.
+func (k *keyStore) anchorKeys(id zoneContext) map[keys.PublicKeyID][]keys.PublicKey {
+	pkeys := make(map[keys.PublicKeyID][]keys.PublicKey)
+	for keyID, list := range k.anchors[id] {
+		pkeys[keyID] = append([]keys.PublicKey(nil), list...)
+	}
+	return pkeys
+}
+
+//containsAnchorKey returns true if the trust anchor with the given id contains pkey. The caller
+//must hold the lock.
+func (k *keyStore) containsAnchorKey(id zoneContext, pkey keys.PublicKey) bool {
+	for _, key := range k.anchors[id][pkey.PublicKeyID] {
+		if key.CompareTo(pkey) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//removeExpiredAnchorKeys removes the keys of the trust anchor with the given id which expired
+//before now unless no valid key would remain. The caller must hold the write lock.
+func (k *keyStore) removeExpiredAnchorKeys(id zoneContext, now int64) {
+	valid := make(map[keys.PublicKeyID][]keys.PublicKey)
+	nofValid := 0
+	for keyID, list := range k.anchors[id] {
+		for _, key := range list {
+			if key.ValidUntil >= now {
+				valid[keyID] = append(valid[keyID], key)
+				nofValid++
+			}
+		}
+	}
+	if nofValid > 0 {
+		k.anchors[id] = valid
+	}
+}
@@ -0,0 +1,134 @@
+package libresolve
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestAcceptNextKeys(t *testing.T) {
+	now := time.Now()
+	fake := clock.NewFake(now)
+	defer clock.Set(fake)()
+	current, next, other := newTestKey(0), newTestKey(1), newTestKey(0)
+	current.public.ValidSince, current.public.ValidUntil = now.Unix(), now.Add(time.Hour).Unix()
+	next.public.ValidSince, next.public.ValidUntil = now.Unix(), now.Add(48*time.Hour).Unix()
+	//announcement returns the apex assertion of the root zone announcing the next key signed by k
+	//at time at.
+	announcement := func(k testKey, at time.Time) *section.Assertion {
+		a := &section.Assertion{SubjectName: "@", SubjectZone: ".", Context: ".",
+			Content: []object.Object{object.Object{Type: object.OTNextKey, Value: next.public}}}
+		k.sign(t, a, at)
+		return a
+	}
+	r := New(nil, nil, Recursive, nil, 1)
+	r.AddTrustAnchorKeys(".", ".", current.public)
+	if _, err := r.AcceptNextKeys(announcement(other, now)); err == nil {
+		t.Errorf("next key signed by a key other than the trust anchor was accepted")
+	}
+	if n, err := r.AcceptNextKeys(announcement(current, now)); n != 1 || err != nil {
+		t.Fatalf("next key was not accepted. accepted=%d error=%v", n, err)
+	}
+	if n, err := r.AcceptNextKeys(announcement(current, now)); n != 0 || err != nil {
+		t.Errorf("next key was accepted twice. accepted=%d error=%v", n, err)
+	}
+	if anchors := r.TrustAnchors(); len(anchors) != 1 || len(anchors[0].Keys) != 2 {
+		t.Fatalf("wrong trust anchors after rollover. actual=%v", anchors)
+	}
+	//Once the current key expired, it is removed when the next key is announced again.
+	fake.Advance(3 * time.Hour)
+	later := fake.Now()
+	if _, err := r.AcceptNextKeys(announcement(current, later)); err == nil {
+		t.Errorf("next key signed by an expired trust anchor key was accepted")
+	}
+	if n, err := r.AcceptNextKeys(announcement(next, later)); n != 0 || err != nil {
+		t.Errorf("announcement signed by the next key was not accepted. error=%v", err)
+	}
+	anchors := r.TrustAnchors()
+	if len(anchors) != 1 || len(anchors[0].Keys) != 1 ||
+		anchors[0].Keys[0].CompareTo(next.public) != 0 {
+		t.Fatalf("expired trust anchor key was not removed. actual=%v", anchors)
+	}
+	ip := object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}
+	var tests = []struct {
+		k     testKey
+		valid bool
+	}{
+		{next, true},
+		{current, false},
+	}
+	for i, test := range tests {
+		a := &section.Assertion{SubjectName: "www", SubjectZone: ".", Context: ".",
+			Content: []object.Object{ip}}
+		test.k.sign(t, a, later)
+		if err := r.verifier().VerifySection(context.Background(), a); (err == nil) != test.valid {
+			t.Errorf("%d: wrong verification result. expected valid=%t actual=%v", i, test.valid,
+				err)
+		}
+	}
+}
+
+//checkAnswersFlushed checks that the answers cached by a resolver with a trust anchor for the root
+//zone are looked up again after change modified the trust anchor.
+func checkAnswersFlushed(t *testing.T, change func(r *Resolver) error) {
+	t.Helper()
+	fake := clock.NewFake(time.Now())
+	defer clock.Set(fake)()
+	k := newTestKey(0)
+	ip4 := object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}
+	shard := &section.Shard{SubjectZone: "ethz.ch.", Context: "."}
+	k.sign(t, shard, time.Now())
+	answers := map[string][]section.Section{
+		"www.ethz.ch.": []section.Section{testAssertion(t, "www", "ethz.ch.", k, ip4)},
+		"ftp.ethz.ch.": []section.Section{shard},
+	}
+	var origins []Origin
+	r := cachingResolver(answers, &origins)
+	defer r.Close()
+	r.AddTrustAnchorKeys(".", ".", k.public)
+	runCacheSteps(t, r, fake, &origins, []cacheStep{
+		{0, "www.ethz.ch.", object.OTIP4Addr, nil, OriginNetwork},
+		{0, "ftp.ethz.ch.", object.OTIP4Addr, nil, OriginNetwork},
+		{0, "www.ethz.ch.", object.OTIP4Addr, nil, OriginCache},
+		{0, "ftp.ethz.ch.", object.OTIP4Addr, nil, OriginCache},
+	})
+	if err := change(r); err != nil {
+		t.Fatalf("could not change trust anchor: %v", err)
+	}
+	runCacheSteps(t, r, fake, &origins, []cacheStep{
+		{0, "www.ethz.ch.", object.OTIP4Addr, nil, OriginNetwork},
+		{0, "ftp.ethz.ch.", object.OTIP4Addr, nil, OriginNetwork},
+	})
+}
+
+func TestRotateTrustAnchor(t *testing.T) {
+	next := newTestKey(1)
+	if err := New(nil, nil, Recursive, nil, 1).RotateTrustAnchor(".", "."); err == nil {
+		t.Errorf("trust anchor was rotated to no keys")
+	}
+	checkAnswersFlushed(t, func(r *Resolver) error {
+		if err := r.RotateTrustAnchor(".", ".", next.public); err != nil {
+			return err
+		}
+		anchors := r.TrustAnchors()
+		if len(anchors) != 1 || len(anchors[0].Keys) != 1 ||
+			anchors[0].Keys[0].PublicKeyID != next.public.PublicKeyID {
+			t.Errorf("wrong trust anchors after rotation. actual=%v", anchors)
+		}
+		return nil
+	})
+}
+
+func TestRemoveTrustAnchor(t *testing.T) {
+	checkAnswersFlushed(t, func(r *Resolver) error {
+		r.RemoveTrustAnchor(".", ".")
+		if anchors := r.TrustAnchors(); len(anchors) != 0 {
+			t.Errorf("trust anchor was not removed. actual=%v", anchors)
+		}
+		return nil
+	})
+}
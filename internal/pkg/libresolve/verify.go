@@ -0,0 +1,364 @@
+package libresolve
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//VerificationMode determines how the resolver treats the signatures of received answers.
+type VerificationMode int
+
+const (
+	//NoVerification returns answers without checking their signatures.
+	NoVerification VerificationMode = iota
+	//Permissive returns answers even if some of their sections could not be verified. Each
+	//unverified section is reported to Resolver.Unverified and is not cached.
+	Permissive
+	//Strict returns an error instead of an answer if one of its sections could not be verified.
+	Strict
+)
+
+//verifyMaxValidity is an upper bound on the validity of verified sections. It is only used to not
+//cap the validity of the sections as the caches of rainsd would.
+const verifyMaxValidity = 100 * 365 * 24 * time.Hour
+
+//DelegationFetcher returns the sections of an answer to a query for the delegation assertions of
+//zone in context. The sections are verified by the caller.
+type DelegationFetcher func(ctx context.Context, zone, context string) ([]section.Section, error)
+
+//Verifier checks the signatures of sections along the delegation chain starting at its trust
+//anchors. The delegations of zones whose public keys are not yet known are obtained with a
+//DelegationFetcher, e.g. from the server which sent the sections. A Verifier is safe for concurrent
+//use.
+type Verifier struct {
+	trust *keyStore
+	fetch DelegationFetcher
+}
+
+//NewVerifier returns a verifier without trust anchors which obtains delegations with fetch.
+func NewVerifier(fetch DelegationFetcher) *Verifier {
+	return &Verifier{trust: newKeyStore(), fetch: fetch}
+}
+
+//LoadTrustAnchor adds the self signed delegation assertion stored at path (e.g. the root zone's
+//selfSignedRootDelegationAssertion.gob) as trust anchor.
+func (v *Verifier) LoadTrustAnchor(path string) error {
+	a, err := loadTrustAnchor(path)
+	if err != nil {
+		return err
+	}
+	return v.AddTrustAnchor(a)
+}
+
+//AddTrustAnchor adds the public keys delegated by the self signed delegation assertion a as trust
+//anchor. All keys obtained through delegations so far are discarded.
+func (v *Verifier) AddTrustAnchor(a *section.Assertion) error {
+	zone, pkeys, err := trustAnchorKeys(a)
+	if err != nil {
+		return err
+	}
+	v.trust.mux.Lock()
+	defer v.trust.mux.Unlock()
+	v.trust.addAnchorKeys(zoneKeyID(zone, a.Context), pkeys)
+	v.trust.delegated = make(map[zoneContext]map[keys.PublicKeyID][]keys.PublicKey)
+	return nil
+}
+
+//Verify checks the signatures of all sections. The returned slice contains at index i nil if the
+//signatures of sections[i] are valid and otherwise the reason why it could not be verified.
+//Sections without signatures such as notifications are not verifiable.
+func (v *Verifier) Verify(ctx context.Context, sections []section.Section) []error {
+	results := make([]error, len(sections))
+	for i, s := range sections {
+		results[i] = v.VerifySection(ctx, s)
+	}
+	return results
+}
+
+//keyStore contains the public keys of the trust anchors and the public keys obtained by following
+//the delegation chain from them. Both map a zone and context to the zone's public keys.
+type keyStore struct {
+	mux       sync.RWMutex
+	anchors   map[zoneContext]map[keys.PublicKeyID][]keys.PublicKey
+	delegated map[zoneContext]map[keys.PublicKeyID][]keys.PublicKey
+}
+
+func newKeyStore() *keyStore {
+	return &keyStore{
+		anchors:   make(map[zoneContext]map[keys.PublicKeyID][]keys.PublicKey),
+		delegated: make(map[zoneContext]map[keys.PublicKeyID][]keys.PublicKey),
+	}
+}
+
+//get returns the trusted public keys of zone in context. Delegated keys are only returned if at
+//least one of them is not yet expired.
+func (k *keyStore) get(zone, context string) (map[keys.PublicKeyID][]keys.PublicKey, bool) {
+	k.mux.RLock()
+	defer k.mux.RUnlock()
+	id := zoneKeyID(zone, context)
+	if _, ok := k.anchors[id]; ok {
+		return k.anchorKeys(id), true
+	}
+	pkeys, ok := k.delegated[id]
+	if !ok {
+		return nil, false
+	}
+	now := clock.Now().Unix()
+	for _, list := range pkeys {
+		for _, key := range list {
+			if key.ValidUntil >= now {
+				return pkeys, true
+			}
+		}
+	}
+	return nil, false
+}
+
+//verifyAnswer checks the signatures of all sections of msg according to the resolver's
+//verification mode. The returned slice contains at index i nil if msg.Content[i] is verified and
+//otherwise the reason why it could not be verified. It is nil if verification is disabled.
+func (r *Resolver) verifyAnswer(ctx context.Context, msg *message.Message) []error {
+	if r.Verification == NoVerification {
+		return nil
+	}
+	results := make([]error, len(msg.Content))
+	for i, s := range msg.Content {
+		if _, ok := s.(*section.Notification); ok {
+			//Notifications are not signed. A denial of existence is proven by the shards, zones
+			//and pshards sent along with it.
+			continue
+		}
+		if results[i] = r.verifier().VerifySection(ctx, s); results[i] == nil {
+			r.acceptVerifiedNextKeys(s)
+		} else {
+			atomic.AddInt64(&r.metrics.verificationFailures, 1)
+		}
+	}
+	return results
+}
+
+//verifier returns a verifier which shares the resolver's trust anchors and keys and obtains the
+//delegations by resolving them.
+func (r *Resolver) verifier() *Verifier {
+	return &Verifier{trust: r.trust, fetch: r.fetchDelegation}
+}
+
+//VerifySection checks the signatures on s and on all assertions contained in s.
+func (v *Verifier) VerifySection(ctx context.Context, s section.Section) error {
+	sec, ok := s.(section.WithSig)
+	if !ok {
+		return fmt.Errorf("%T is not signed", s)
+	}
+	pkeys, err := v.zoneKeys(ctx, sec.GetSubjectZone(), sec.GetContext())
+	if err != nil {
+		return err
+	}
+	var content []*section.Assertion
+	switch s := s.(type) {
+	case *section.Shard:
+		content = s.Content
+		s.DontAddSigInMarshaller()
+		ok = checkSignatures(s, pkeys)
+		s.AddSigInMarshaller()
+		s.AddCtxAndZoneToContent()
+		defer s.RemoveCtxAndZoneFromContent()
+	case *section.Zone:
+		content = s.Content
+		s.DontAddSigInMarshaller()
+		ok = checkSignatures(s, pkeys)
+		s.AddSigInMarshaller()
+		s.AddCtxAndZoneToContent()
+		defer s.RemoveCtxAndZoneFromContent()
+	default:
+		ok = checkSignatures(sec, pkeys)
+	}
+	if !ok {
+		return fmt.Errorf("signature of %s is missing or invalid", sectionName(sec))
+	}
+	for _, a := range content {
+		if !checkSignatures(a, pkeys) {
+			return fmt.Errorf("signature of contained assertion %s is missing or invalid", a.FQDN())
+		}
+	}
+	return nil
+}
+
+//zoneKeys returns the verified public keys of zone in context. If they are not yet known, the
+//delegation of zone is obtained and verified with the keys of its parent zone. Only delegations
+//issued by the direct parent of zone are followed such that the delegation chain ends at the root
+//zone.
+func (v *Verifier) zoneKeys(ctx context.Context, zone, context string) (
+	map[keys.PublicKeyID][]keys.PublicKey, error) {
+	if pkeys, ok := v.trust.get(zone, context); ok {
+		return pkeys, nil
+	}
+	if zone == "." {
+		return nil, fmt.Errorf("no trust anchor for the root zone in context %s", context)
+	}
+	delegations, err := v.fetchDelegations(ctx, zone, context)
+	if err != nil {
+		return nil, err
+	}
+	if len(delegations) > 0 {
+		parentKeys, err := v.zoneKeys(ctx, parentZone(zone), context)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range delegations {
+			if !checkSignatures(a, parentKeys) {
+				log.Warn("delegation is not signed by the parent zone", "zone", zone,
+					"delegation", a)
+				continue
+			}
+			pkeys := delegatedKeys(a)
+			v.trust.mux.Lock()
+			v.trust.delegated[zoneKeyID(zone, context)] = pkeys
+			v.trust.mux.Unlock()
+			return pkeys, nil
+		}
+	}
+	return nil, fmt.Errorf("no verifiable delegation for zone %s in context %s", zone, context)
+}
+
+//fetchDelegation resolves the delegation assertions of zone in context without verifying them.
+func (r *Resolver) fetchDelegation(ctx context.Context, zone, context string) (
+	[]section.Section, error) {
+	q := &query.Name{
+		Name:       zone,
+		Context:    context,
+		Types:      []object.Type{object.OTDelegation},
+		Expiration: clock.Now().Add(defaultTimeout).Unix(),
+		Options:    lookupOptions(ctx),
+	}
+	answer, err := r.resolve(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return answer.Content, nil
+}
+
+//fetchDelegations obtains the delegation assertions of zone in context without verifying them and
+//returns all received assertions about zone which contain a delegation and are issued by the
+//direct parent of zone.
+func (v *Verifier) fetchDelegations(ctx context.Context, zone, context string) (
+	[]*section.Assertion, error) {
+	sections, err := v.fetch(ctx, zone, context)
+	if err != nil {
+		return nil, fmt.Errorf("could not obtain delegation for %s: %v", zone, err)
+	}
+	parent := parentZone(zone)
+	var delegations []*section.Assertion
+	for _, s := range sections {
+		var assertions []*section.Assertion
+		switch s := s.(type) {
+		case *section.Assertion:
+			assertions = []*section.Assertion{s}
+		case *section.Shard:
+			for _, a := range s.Content {
+				assertions = append(assertions, a.Copy(s.Context, s.SubjectZone))
+			}
+		case *section.Zone:
+			for _, a := range s.Content {
+				assertions = append(assertions, a.Copy(s.Context, s.SubjectZone))
+			}
+		}
+		for _, a := range assertions {
+			if a.FQDN() == zone && a.SubjectZone == parent && len(delegatedKeys(a)) > 0 {
+				delegations = append(delegations, a)
+			}
+		}
+	}
+	return delegations, nil
+}
+
+//delegatedKeys returns the public keys of the delegation objects in a. As rainsd does, the validity
+//of the keys is set to the validity of a's first signature.
+func delegatedKeys(a *section.Assertion) map[keys.PublicKeyID][]keys.PublicKey {
+	if len(a.Signatures) == 0 {
+		return nil
+	}
+	pkeys := make(map[keys.PublicKeyID][]keys.PublicKey)
+	for _, o := range a.Content {
+		if o.Type != object.OTDelegation {
+			continue
+		}
+		if pkey, ok := o.Value.(keys.PublicKey); ok {
+			pkey.ValidSince = a.Signatures[0].ValidSince
+			pkey.ValidUntil = a.Signatures[0].ValidUntil
+			pkeys[pkey.PublicKeyID] = append(pkeys[pkey.PublicKeyID], pkey)
+		}
+	}
+	return pkeys
+}
+
+//checkSignatures returns true if s has at least one signature and all its signatures are valid.
+//In contrast to siglib.CheckSectionSignatures, the signatures of s are left unmodified such that
+//they are still present when s is returned to the caller.
+func checkSignatures(s section.WithSig, pkeys map[keys.PublicKeyID][]keys.PublicKey) bool {
+	sigs := s.AllSigs()
+	if len(sigs) == 0 {
+		return false
+	}
+	maxVal := util.MaxCacheValidity{
+		AssertionValidity: verifyMaxValidity,
+		ShardValidity:     verifyMaxValidity,
+		PhardValidity:     verifyMaxValidity,
+		ZoneValidity:      verifyMaxValidity,
+	}
+	ok := siglib.CheckSectionSignatures(s, pkeys, maxVal)
+	s.DeleteAllSigs()
+	for _, sig := range sigs {
+		s.AddSig(sig)
+	}
+	return ok
+}
+
+//sectionName returns a short description of s used in error messages.
+func sectionName(s section.WithSig) string {
+	switch s := s.(type) {
+	case *section.Assertion:
+		return fmt.Sprintf("assertion %s", s.FQDN())
+	case *section.Shard:
+		return fmt.Sprintf("shard [%s,%s] of %s", s.RangeFrom, s.RangeTo, s.SubjectZone)
+	case *section.Pshard:
+		return fmt.Sprintf("pshard [%s,%s] of %s", s.RangeFrom, s.RangeTo, s.SubjectZone)
+	case *section.Zone:
+		return fmt.Sprintf("zone %s", s.SubjectZone)
+	}
+	return fmt.Sprintf("%T", s)
+}
+
+//parentZone returns the zone directly above zone, e.g. ch. for ethz.ch. and . for ch.
+func parentZone(zone string) string {
+	i := strings.Index(zone, ".")
+	if i < 0 || i == len(zone)-1 {
+		return "."
+	}
+	return zone[i+1:]
+}
+
+//zoneContext identifies the public keys of a zone in a context.
+type zoneContext struct {
+	zone    string
+	context string
+}
+
+//zoneKeyID returns the key under which the public keys of zone in context are stored.
+func zoneKeyID(zone, context string) zoneContext {
+	return zoneContext{zone: zone, context: context}
+}
@@ -0,0 +1,284 @@
+package libresolve
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"golang.org/x/crypto/ed25519"
+)
+
+//testKey is a key pair of a zone used to sign the zone's sections and delegations.
+type testKey struct {
+	public  keys.PublicKey
+	private ed25519.PrivateKey
+}
+
+func newTestKey(phase int) testKey {
+	public, private, _ := ed25519.GenerateKey(nil)
+	return testKey{
+		public: keys.PublicKey{
+			PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519,
+				KeySpace: keys.RainsKeySpace, KeyPhase: phase},
+			Key: public,
+		},
+		private: private,
+	}
+}
+
+//sign signs s with k. The signature is valid from one hour before until one hour after now.
+func (k testKey) sign(t *testing.T, s section.WithSig, now time.Time) {
+	t.Helper()
+	sig := signature.Sig{
+		PublicKeyID: k.public.PublicKeyID,
+		ValidSince:  now.Add(-time.Hour).Unix(),
+		ValidUntil:  now.Add(time.Hour).Unix(),
+	}
+	if !siglib.SignSectionUnsafe(s, k.private, sig) {
+		t.Fatalf("Was not able to sign %v", s)
+	}
+}
+
+//testAssertion returns an assertion of name in zone containing objs and signed by k.
+func testAssertion(t *testing.T, name, zone string, k testKey,
+	objs ...object.Object) *section.Assertion {
+	t.Helper()
+	a := &section.Assertion{SubjectName: name, SubjectZone: zone, Context: ".", Content: objs}
+	k.sign(t, a, time.Now())
+	return a
+}
+
+//testDelegation returns the delegation of the key delegated to name in zone signed by k.
+func testDelegation(t *testing.T, name, zone string, delegated, k testKey) *section.Assertion {
+	t.Helper()
+	return testAssertion(t, name, zone, k,
+		object.Object{Type: object.OTDelegation, Value: delegated.public})
+}
+
+//testChain contains the keys and delegations of the root zone, ch. and ethz.ch.
+type testChain struct {
+	root, ch, ethz testKey
+	anchor         *section.Assertion
+	delegations    map[string][]section.Section
+}
+
+func newTestChain(t *testing.T) testChain {
+	c := testChain{root: newTestKey(0), ch: newTestKey(0), ethz: newTestKey(0)}
+	c.anchor = testDelegation(t, "@", ".", c.root, c.root)
+	c.delegations = map[string][]section.Section{
+		"ch.":      []section.Section{testDelegation(t, "ch", ".", c.ch, c.root)},
+		"ethz.ch.": []section.Section{testDelegation(t, "ethz", "ch.", c.ethz, c.ch)},
+	}
+	return c
+}
+
+//fetcher returns a DelegationFetcher answering with the sections stored in delegations under the
+//queried zone and counting the queries per zone in queries.
+func fetcher(delegations map[string][]section.Section, queries map[string]int) DelegationFetcher {
+	return func(ctx context.Context, zone, context string) ([]section.Section, error) {
+		queries[zone]++
+		sections, ok := delegations[zone]
+		if !ok {
+			return nil, errors.New("no delegation")
+		}
+		return sections, nil
+	}
+}
+
+func TestVerifySection(t *testing.T) {
+	c := newTestChain(t)
+	ip := object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}
+	tampered := testAssertion(t, "www", "ethz.ch.", c.ethz, ip)
+	tampered.Content[0].Value = "192.0.2.2"
+	var tests = []struct {
+		s           section.Section
+		delegations map[string][]section.Section
+		errMsg      string
+	}{
+		{testAssertion(t, "www", "ethz.ch.", c.ethz, ip), nil, ""},
+		{testAssertion(t, "www", "ch.", c.ch, ip), nil, ""},
+		{tampered, nil, "missing or invalid"},
+		{testAssertion(t, "www", "ethz.ch.", c.ch, ip), nil, "missing or invalid"},
+		{&section.Assertion{SubjectName: "www", SubjectZone: "ethz.ch.", Context: ".",
+			Content: []object.Object{ip}}, nil, "missing or invalid"},
+		{testAssertion(t, "www", "ethz.ch.", c.ethz, ip), map[string][]section.Section{
+			"ch.":      c.delegations["ch."],
+			"ethz.ch.": []section.Section{testDelegation(t, "ethz", "ch.", c.ethz, c.root)}},
+			"no verifiable delegation"},
+		//A delegation is only accepted from the direct parent zone, even if it is signed by a
+		//trusted key.
+		{testAssertion(t, "www", "ethz.ch.", c.ethz, ip), map[string][]section.Section{
+			"ch.":      c.delegations["ch."],
+			"ethz.ch.": []section.Section{testDelegation(t, "ethz.ch", ".", c.ethz, c.root)}},
+			"no verifiable delegation"},
+		//Delegations forming a loop between ch. and ethz.ch. are not followed.
+		{testAssertion(t, "www", "ethz.ch.", c.ethz, ip), map[string][]section.Section{
+			"ch.":      []section.Section{testDelegation(t, "ch", "ethz.ch.", c.ch, c.ethz)},
+			"ethz.ch.": []section.Section{testDelegation(t, "ethz", "ch.", c.ethz, c.ch)}},
+			"no verifiable delegation"},
+		{testAssertion(t, "www", "ethz.ch.", c.ethz, ip), map[string][]section.Section{},
+			"could not obtain delegation"},
+		{&section.Notification{Type: section.NTHeartbeat}, nil, "not signed"},
+	}
+	for i, test := range tests {
+		delegations := c.delegations
+		if test.delegations != nil {
+			delegations = test.delegations
+		}
+		v := NewVerifier(fetcher(delegations, make(map[string]int)))
+		if err := v.AddTrustAnchor(c.anchor); err != nil {
+			t.Fatalf("Was not able to add trust anchor: %v", err)
+		}
+		err := v.VerifySection(context.Background(), test.s)
+		if test.errMsg == "" && err != nil {
+			t.Errorf("%d: valid section did not verify: %v", i, err)
+		}
+		if test.errMsg != "" && (err == nil || !strings.Contains(err.Error(), test.errMsg)) {
+			t.Errorf("%d: wrong verification result. expected=%s actual=%v", i, test.errMsg, err)
+		}
+	}
+}
+
+func TestVerifierKeyCache(t *testing.T) {
+	c := newTestChain(t)
+	queries := make(map[string]int)
+	v := NewVerifier(fetcher(c.delegations, queries))
+	if err := v.AddTrustAnchor(c.anchor); err != nil {
+		t.Fatalf("Was not able to add trust anchor: %v", err)
+	}
+	ip := object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}
+	sections := []section.Section{
+		testAssertion(t, "www", "ethz.ch.", c.ethz, ip),
+		testAssertion(t, "ftp", "ethz.ch.", c.ethz, ip),
+		testAssertion(t, "www", ".", c.root, ip),
+	}
+	for i, err := range v.Verify(context.Background(), sections) {
+		if err != nil {
+			t.Errorf("%d: valid section did not verify: %v", i, err)
+		}
+	}
+	if queries["ch."] != 1 || queries["ethz.ch."] != 1 || len(queries) != 2 {
+		t.Errorf("verified delegations were not reused. queries=%v", queries)
+	}
+	//A new trust anchor discards the delegated keys.
+	if err := v.AddTrustAnchor(c.anchor); err != nil {
+		t.Fatalf("Was not able to add trust anchor: %v", err)
+	}
+	v.VerifySection(context.Background(), sections[0])
+	if queries["ethz.ch."] != 2 {
+		t.Errorf("delegated keys were kept after adding a trust anchor. queries=%v", queries)
+	}
+}
+
+func TestAddTrustAnchor(t *testing.T) {
+	c := newTestChain(t)
+	unsigned := &section.Assertion{SubjectName: "@", SubjectZone: ".", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTDelegation, Value: c.root.public}}}
+	var tests = []struct {
+		anchor *section.Assertion
+		valid  bool
+	}{
+		{c.anchor, true},
+		{testDelegation(t, "@", ".", c.root, c.ch), false},
+		{testAssertion(t, "@", ".", c.root), false},
+		{unsigned, false},
+	}
+	for i, test := range tests {
+		v := NewVerifier(fetcher(c.delegations, make(map[string]int)))
+		if err := v.AddTrustAnchor(test.anchor); (err == nil) != test.valid {
+			t.Errorf("%d: wrong outcome. expected valid=%t actual=%v", i, test.valid, err)
+		}
+	}
+}
+
+//verifyingServer answers the queries received on conn with the sections stored in answers under
+//the queried name until conn is closed.
+func verifyingServer(conn net.Conn, answers map[string][]section.Section) {
+	defer conn.Close()
+	reader := codec.NewReader(conn, cbor.Limits{})
+	for {
+		msg, err := reader.Read()
+		if err != nil {
+			return
+		}
+		for _, s := range msg.Content {
+			if q, ok := s.(*query.Name); ok {
+				answer := message.Message{Token: msg.Token, Content: answers[q.Name]}
+				if err := (codec.CBOR{}).Encode(conn, &answer); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+func TestVerificationMode(t *testing.T) {
+	c := newTestChain(t)
+	ip := object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}
+	tampered := testAssertion(t, "www", "ethz.ch.", c.ethz, ip)
+	tampered.Content[0].Value = "192.0.2.2"
+	answers := map[string][]section.Section{
+		"valid.ethz.ch.": []section.Section{testAssertion(t, "valid", "ethz.ch.", c.ethz, ip)},
+		"www.ethz.ch.": []section.Section{testAssertion(t, "www", "ethz.ch.", c.ethz, ip),
+			tampered},
+	}
+	for zone, delegations := range c.delegations {
+		answers[zone] = delegations
+	}
+	var tests = []struct {
+		mode       VerificationMode
+		name       string
+		valid      bool
+		sections   int
+		unverified int
+	}{
+		{NoVerification, "www.ethz.ch.", true, 2, 0},
+		{Permissive, "www.ethz.ch.", true, 2, 1},
+		{Permissive, "valid.ethz.ch.", true, 1, 0},
+		{Strict, "www.ethz.ch.", false, 0, 0},
+		{Strict, "valid.ethz.ch.", true, 1, 0},
+	}
+	for i, test := range tests {
+		r := New(nil, []net.Addr{&net.TCPAddr{IP: net.ParseIP("192.0.2.53"), Port: 55553}},
+			Forward, nil, 1)
+		r.Dialer = DialerFunc(func(ctx context.Context, addr net.Addr) (net.Conn, error) {
+			client, server := net.Pipe()
+			go verifyingServer(server, answers)
+			return client, nil
+		})
+		if err := r.AddTrustAnchor(c.anchor); err != nil {
+			t.Fatalf("Was not able to add trust anchor: %v", err)
+		}
+		r.Verification = test.mode
+		unverified := 0
+		r.Unverified = func(s section.Section, err error) { unverified++ }
+		q := &query.Name{Name: test.name, Context: ".", Types: []object.Type{object.OTIP4Addr},
+			Expiration: time.Now().Add(time.Minute).Unix()}
+		answer, err := r.ClientLookup(context.Background(), q)
+		if (err == nil) != test.valid {
+			t.Errorf("%d: wrong outcome. expected valid=%t actual=%v", i, test.valid, err)
+		}
+		if err == nil && len(answer.Content) != test.sections {
+			t.Errorf("%d: wrong number of sections. expected=%d actual=%d", i, test.sections,
+				len(answer.Content))
+		}
+		if unverified != test.unverified {
+			t.Errorf("%d: wrong number of unverified sections. expected=%d actual=%d", i,
+				test.unverified, unverified)
+		}
+		r.Close()
+	}
+}
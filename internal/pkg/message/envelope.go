@@ -0,0 +1,109 @@
+package message
+
+import (
+	"fmt"
+
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//Envelope contains the header of an encoded message and the types of its sections. It is obtained
+//without decoding the content of the message such that a server can decide how to route,
+//prioritize or whether to drop a message before paying the cost of decoding all its sections.
+type Envelope struct {
+	Token        token.Token
+	Capabilities []Capability
+	Signatures   []signature.Sig
+	//SectionTypes contains the type of each section in the content of the message in order, e.g.
+	//AssertionType or ZoneType.
+	SectionTypes []int
+	encoding     []byte
+}
+
+//DecodeEnvelope decodes the envelope of the CBOR encoded message in encoding. The content of the
+//message is only checked to be an array of sections of known types. The envelope keeps a
+//reference to encoding, which must therefore not be modified afterwards.
+func DecodeEnvelope(encoding []byte) (*Envelope, error) {
+	tag, item, err := rcbor.SplitTag(encoding)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tag: %v", err)
+	}
+	if err := rcbor.CheckTag(tag, rcbor.MessageTag); err != nil {
+		return nil, err
+	}
+	values, err := rcbor.SplitMap(item)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read map: %v", err)
+	}
+	header := make(map[int]interface{})
+	for key, value := range values {
+		if key == 23 {
+			continue
+		}
+		if header[key], err = rcbor.Decode(value); err != nil {
+			return nil, fmt.Errorf("failed to read map: %v", err)
+		}
+	}
+	if err := rcbor.CheckKeys("message", header, 0, 1, 2); err != nil {
+		return nil, err
+	}
+	msg := &Message{}
+	if err := msg.unmarshalHeader(header); err != nil {
+		return nil, err
+	}
+	content, ok := values[23]
+	if !ok {
+		return nil, rcbor.MissingKey("message", 23)
+	}
+	sections, err := rcbor.SplitArray(content)
+	if err != nil {
+		return nil, rcbor.WrongType("message", 23, "array")
+	}
+	env := &Envelope{
+		Token:        msg.Token,
+		Capabilities: msg.Capabilities,
+		Signatures:   msg.Signatures,
+		SectionTypes: make([]int, len(sections)),
+		encoding:     encoding,
+	}
+	for i, s := range sections {
+		elems, err := rcbor.SplitArray(s)
+		if err != nil {
+			return nil, rcbor.WrongType("message", 23, "array of section arrays")
+		}
+		if len(elems) != 2 {
+			return nil, rcbor.WrongLength("message", 23, 2, len(elems))
+		}
+		val, err := rcbor.Decode(elems[0])
+		t, ok := val.(int)
+		if err != nil || !ok {
+			return nil, rcbor.WrongType("message", 23, "section type as first element")
+		}
+		if _, ok := sectionTypeNames[t]; !ok {
+			return nil, rcbor.OutOfRange("message", 23, t)
+		}
+		env.SectionTypes[i] = t
+	}
+	return env, nil
+}
+
+//Contains returns true if the message has a section of type t.
+func (e *Envelope) Contains(t int) bool {
+	for _, sectionType := range e.SectionTypes {
+		if sectionType == t {
+			return true
+		}
+	}
+	return false
+}
+
+//Size returns the length of the message's encoding in bytes.
+func (e *Envelope) Size() int {
+	return len(e.encoding)
+}
+
+//Decode decodes the whole message.
+func (e *Envelope) Decode() (*Message, error) {
+	return Decode(e.encoding)
+}
@@ -0,0 +1,292 @@
+package message
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	cbor "github.com/britram/borat"
+
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+)
+
+//jsonKeys maps the integer keys of the CBOR maps of messages and sections to the field names of
+//their JSON representation. Keys missing in the table are represented by their number.
+var jsonKeys = map[int]string{
+	0:  "signatures",
+	1:  "capabilities",
+	2:  "token",
+	3:  "subjectName",
+	4:  "subjectZone",
+	6:  "context",
+	7:  "objects",
+	8:  "queryName",
+	10: "queryTypes",
+	11: "shardRange",
+	12: "expires",
+	13: "queryOptions",
+	14: "currentTime",
+	17: "keyPhase",
+	21: "noteType",
+	22: "noteData",
+	23: "content",
+}
+
+//sectionTypeNames maps the types of the sections in a message's content to their names in the
+//JSON representation.
+var sectionTypeNames = map[int]string{
+	AssertionType:    "assertion",
+	ShardType:        "shard",
+	PshardType:       "pshard",
+	ZoneType:         "zone",
+	QueryType:        "query",
+	NotificationType: "notification",
+}
+
+//Keys whose values are converted depending on the enclosing map.
+const (
+	objectsKey = 7
+	contentKey = 23
+)
+
+//hexKey is the only field of the JSON object representing a CBOR byte string.
+const hexKey = "hex"
+
+//MarshalJSON implements the json.Marshaler interface. The JSON representation mirrors the CBOR
+//encoding of rm: maps are objects whose field names are given by the integer keys, section and
+//object types are given by name and byte strings are objects with the single field hex. Unlike the
+//JSON representation of sections meant for display, it can be decoded with UnmarshalJSON without
+//loss, e.g. to exchange messages with HTTP clients.
+func (rm *Message) MarshalJSON() ([]byte, error) {
+	encoding := new(bytes.Buffer)
+	if err := rm.MarshalCBOR(cbor.NewCBORWriter(encoding)); err != nil {
+		return nil, err
+	}
+	return CBORToJSON(encoding.Bytes())
+}
+
+//UnmarshalJSON implements the json.Unmarshaler interface. It accepts the representation produced
+//by MarshalJSON.
+func (rm *Message) UnmarshalJSON(data []byte) error {
+	encoding, err := JSONToCBOR(data)
+	if err != nil {
+		return err
+	}
+	*rm = Message{}
+	return rm.UnmarshalCBOR(cbor.NewCBORReader(bytes.NewReader(encoding)))
+}
+
+//JSONToCBOR returns the CBOR encoding of the message in its JSON representation data as produced by
+//MarshalJSON. The content of the message is not validated.
+func JSONToCBOR(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	m, err := fromJSON(v, true)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := m.(intMap); !ok {
+		return nil, errors.New("json msg encoding must be an object")
+	}
+	encoding := new(bytes.Buffer)
+	w := cbor.NewCBORWriter(encoding)
+	if err := w.WriteTag(cbor.CBORTag(rcbor.MessageTag)); err != nil {
+		return nil, err
+	}
+	if err := m.(intMap).MarshalCBOR(w); err != nil {
+		return nil, err
+	}
+	return encoding.Bytes(), nil
+}
+
+//CBORToJSON returns the JSON representation of the CBOR encoded message in frame as produced by
+//MarshalJSON. Contrary to decoding frame into a Message first, fields unknown to this
+//implementation are preserved.
+func CBORToJSON(frame []byte) ([]byte, error) {
+	r := cbor.NewCBORReader(bytes.NewReader(frame))
+	tag, err := r.ReadTag()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tag: %v", err)
+	}
+	if err := rcbor.CheckTag(uint64(tag), rcbor.MessageTag); err != nil {
+		return nil, err
+	}
+	m, err := r.ReadIntMapUntagged()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read map: %v", err)
+	}
+	return json.Marshal(toJSON(m, true))
+}
+
+//toJSON converts a value decoded from CBOR to its JSON representation. isMessage is true if v is
+//the map of a message.
+func toJSON(v interface{}, isMessage bool) interface{} {
+	switch v := v.(type) {
+	case map[int]interface{}:
+		out := make(map[string]interface{})
+		for key, value := range v {
+			name, ok := jsonKeys[key]
+			if !ok {
+				name = strconv.Itoa(key)
+			}
+			elems, isArray := value.([]interface{})
+			switch {
+			case key == objectsKey && isArray:
+				out[name] = typedElemsToJSON(elems, func(t int) string { return object.Type(t).Name() })
+			case key == contentKey && isMessage && isArray:
+				out[name] = typedElemsToJSON(elems, func(t int) string {
+					if name, ok := sectionTypeNames[t]; ok {
+						return name
+					}
+					return strconv.Itoa(t)
+				})
+			default:
+				out[name] = toJSON(value, false)
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			out[i] = toJSON(elem, false)
+		}
+		return out
+	case []byte:
+		return map[string]string{hexKey: hex.EncodeToString(v)}
+	default:
+		return v
+	}
+}
+
+//typedElemsToJSON converts the arrays in elems whose first element is a type to their JSON
+//representation in which the type is replaced by its name.
+func typedElemsToJSON(elems []interface{}, typeName func(int) string) []interface{} {
+	out := make([]interface{}, len(elems))
+	for i, elem := range elems {
+		converted := toJSON(elem, false)
+		if arr, ok := converted.([]interface{}); ok && len(arr) > 0 {
+			if t, ok := arr[0].(int); ok {
+				arr[0] = typeName(t)
+			}
+		}
+		out[i] = converted
+	}
+	return out
+}
+
+//intMap is a map decoded from the JSON representation. The cbor writer cannot marshal nested maps
+//of type map[int]interface{} by itself.
+type intMap map[int]interface{}
+
+//MarshalCBOR implements the CBORMarshaler interface.
+func (m intMap) MarshalCBOR(w *cbor.CBORWriter) error {
+	return w.WriteIntMap(m)
+}
+
+//fromJSON converts a value decoded from the JSON representation to the form of a value decoded
+//from CBOR. isMessage is true if v is the object of a message.
+func fromJSON(v interface{}, isMessage bool) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if data, ok := v[hexKey].(string); ok && len(v) == 1 {
+			return hex.DecodeString(data)
+		}
+		out := make(intMap)
+		for name, value := range v {
+			key, err := jsonKey(name)
+			if err != nil {
+				return nil, err
+			}
+			elems, isArray := value.([]interface{})
+			switch {
+			case key == objectsKey && isArray:
+				out[key], err = typedElemsFromJSON(elems, func(name string) (int, error) {
+					t, err := object.ParseType(name)
+					return int(t), err
+				})
+			case key == contentKey && isMessage && isArray:
+				out[key], err = typedElemsFromJSON(elems, sectionType)
+			default:
+				out[key], err = fromJSON(value, false)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			var err error
+			if out[i], err = fromJSON(elem, false); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("json number is not an integer: %s", v)
+		}
+		return int(n), nil
+	default:
+		return v, nil
+	}
+}
+
+//typedElemsFromJSON converts the arrays in elems whose first element is the name of a type to the
+//form of a value decoded from CBOR.
+func typedElemsFromJSON(elems []interface{}, parseType func(string) (int, error)) ([]interface{},
+	error) {
+	out := make([]interface{}, len(elems))
+	for i, elem := range elems {
+		if arr, ok := elem.([]interface{}); ok && len(arr) > 0 {
+			if name, ok := arr[0].(string); ok {
+				t, err := parseType(name)
+				if err != nil {
+					return nil, err
+				}
+				arr[0] = json.Number(strconv.Itoa(t))
+			}
+		}
+		var err error
+		if out[i], err = fromJSON(elem, false); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+//jsonKey returns the integer key of the field name.
+func jsonKey(name string) (int, error) {
+	for key, n := range jsonKeys {
+		if n == name {
+			return key, nil
+		}
+	}
+	if key, err := strconv.Atoi(name); err == nil {
+		return key, nil
+	}
+	return 0, fmt.Errorf("unknown json field: %s", name)
+}
+
+//sectionType returns the type of the section with the given name.
+func sectionType(name string) (int, error) {
+	for t, n := range sectionTypeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	if t, err := strconv.Atoi(name); err == nil {
+		return t, nil
+	}
+	return 0, fmt.Errorf("unknown section type: %s", name)
+}
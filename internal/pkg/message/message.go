@@ -1,19 +1,25 @@
 package message
 
 import (
-	"errors"
 	"fmt"
 
 	cbor "github.com/britram/borat"
 
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/signature"
 	"github.com/netsec-ethz/rains/internal/pkg/token"
 )
 
+//Types of the sections in the content of an encoded message.
 const (
-	rainsTag = 0xE99BA8
+	AssertionType    = 1
+	ShardType        = 2
+	PshardType       = 3
+	ZoneType         = 4
+	QueryType        = 5
+	NotificationType = 23
 )
 
 //Message represents a Message
@@ -34,109 +40,172 @@ func (rm *Message) UnmarshalCBOR(r *cbor.CBORReader) error {
 	if err != nil {
 		return fmt.Errorf("failed to read tag: %v", err)
 	}
-	if tag != cbor.CBORTag(rainsTag) {
-		return fmt.Errorf("expected tag for RAINS message but got: %v", tag)
+	if err := rcbor.CheckTag(uint64(tag), rcbor.MessageTag); err != nil {
+		return err
 	}
 	m, err := r.ReadIntMapUntagged()
 	if err != nil {
 		return fmt.Errorf("failed to read map: %v", err)
 	}
+	return rm.unmarshalMap(m)
+}
 
-	if sigs, ok := m[0].([]interface{}); ok {
-		rm.Signatures = make([]signature.Sig, len(sigs))
-		for i, sig := range sigs {
-			sigVal, ok := sig.([]interface{})
-			if !ok {
-				return errors.New("cbor zone signatures entry is not an array")
-			}
-			if err := rm.Signatures[i].UnmarshalArray(sigVal); err != nil {
-				return err
-			}
-		}
-	} //Signatures might be omitted
-
-	if caps, ok := m[1].([]interface{}); ok {
-		rm.Capabilities = make([]Capability, len(caps))
-		for i, cap := range caps {
-			c, ok := cap.(string)
-			if !ok {
-				return errors.New("cbor msg encoding of a capability array's element should be a string")
-			}
-			rm.Capabilities[i] = Capability(c)
-		}
-	} //capability might be omitted
-
-	tok, ok := m[2].([]byte)
-	if !ok || len(tok) != 16 {
-		return errors.New("cbor message encoding of the token should be a byte array of length 16")
+//Decode decodes the CBOR encoded message in encoding. It is faster than UnmarshalCBOR for large
+//messages as the encoding is decoded in a single pass, preallocating slices and maps with the
+//lengths announced in the encoding.
+func Decode(encoding []byte) (*Message, error) {
+	tag, item, err := rcbor.SplitTag(encoding)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tag: %v", err)
 	}
-	for i, val := range tok {
-		rm.Token[i] = val
+	if err := rcbor.CheckTag(tag, rcbor.MessageTag); err != nil {
+		return nil, err
+	}
+	val, err := rcbor.Decode(item)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read map: %v", err)
+	}
+	m, ok := val.(map[int]interface{})
+	if !ok {
+		return nil, fmt.Errorf("failed to read map: CBOR item is not a map with integer keys")
 	}
+	msg := &Message{}
+	if err := msg.unmarshalMap(m); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
 
-	content, ok := m[23].([]interface{})
+//unmarshalMap decodes the CBOR map m of a message.
+func (rm *Message) unmarshalMap(m map[int]interface{}) error {
+	if err := rcbor.CheckKeys("message", m, 0, 1, 2, 23); err != nil {
+		return err
+	}
+	if err := rm.unmarshalHeader(m); err != nil {
+		return err
+	}
+	val, ok := m[23]
+	if !ok {
+		return rcbor.MissingKey("message", 23)
+	}
+	content, ok := val.([]interface{})
 	if !ok {
-		return errors.New("cbor msg encoding of the content should be an array")
+		return rcbor.WrongType("message", 23, "array")
 	}
+	rm.Content = make([]section.Section, 0, len(content))
 	for _, elem := range content {
 		elem, ok := elem.([]interface{})
 		if !ok {
-			return errors.New("cbor msg encoding of a content array's entry should be an array")
+			return rcbor.WrongType("message", 23, "array of section arrays")
+		}
+		if len(elem) != 2 {
+			return rcbor.WrongLength("message", 23, 2, len(elem))
 		}
 		t, ok := elem[0].(int)
 		if !ok {
-			return errors.New("cbor msg encoding of a section must start with its type")
+			return rcbor.WrongType("message", 23, "section type as first element")
 		}
 		val, ok := elem[1].(map[int]interface{})
 		if !ok {
-			return errors.New("cbor msg encoding of a section must end with a map")
+			return rcbor.WrongType("message", 23, "section map as second element")
 		}
 		switch t {
-		case 1:
+		case AssertionType:
 			a := &section.Assertion{}
 			if err := a.UnmarshalMap(val); err != nil {
 				return err
 			}
 			rm.Content = append(rm.Content, a)
-		case 2:
+		case ShardType:
 			s := &section.Shard{}
 			if err := s.UnmarshalMap(val); err != nil {
 				return err
 			}
 			rm.Content = append(rm.Content, s)
-		case 3:
+		case PshardType:
 			s := &section.Pshard{}
 			if err := s.UnmarshalMap(val); err != nil {
 				return err
 			}
 			rm.Content = append(rm.Content, s)
-		case 4:
+		case ZoneType:
 			z := &section.Zone{}
 			if err := z.UnmarshalMap(val); err != nil {
 				return err
 			}
 			rm.Content = append(rm.Content, z)
-		case 5:
+		case QueryType:
 			q := &query.Name{}
 			if err := q.UnmarshalMap(val); err != nil {
 				return err
 			}
 			rm.Content = append(rm.Content, q)
-		case 23:
+		case NotificationType:
 			n := &section.Notification{}
 			if err := n.UnmarshalMap(val); err != nil {
 				return err
 			}
 			rm.Content = append(rm.Content, n)
+		default:
+			return rcbor.OutOfRange("message", 23, t)
+		}
+	}
+	return nil
+}
+
+//unmarshalHeader decodes the signatures, capabilities and token of the CBOR map m of a message.
+func (rm *Message) unmarshalHeader(m map[int]interface{}) error {
+	if val, ok := m[0]; ok {
+		sigs, ok := val.([]interface{})
+		if !ok {
+			return rcbor.WrongType("message", 0, "array")
+		}
+		rm.Signatures = make([]signature.Sig, len(sigs))
+		for i, sig := range sigs {
+			sigVal, ok := sig.([]interface{})
+			if !ok {
+				return rcbor.WrongType("message", 0, "array of signature arrays")
+			}
+			if err := rm.Signatures[i].UnmarshalArray(sigVal); err != nil {
+				return err
+			}
 		}
+	} //Signatures might be omitted
+
+	if val, ok := m[1]; ok {
+		caps, ok := val.([]interface{})
+		if !ok {
+			return rcbor.WrongType("message", 1, "array")
+		}
+		rm.Capabilities = make([]Capability, len(caps))
+		for i, cap := range caps {
+			c, ok := cap.(string)
+			if !ok {
+				return rcbor.WrongType("message", 1, "array of strings")
+			}
+			rm.Capabilities[i] = Capability(c)
+		}
+	} //capability might be omitted
+
+	val, ok := m[2]
+	if !ok {
+		return rcbor.MissingKey("message", 2)
+	}
+	tok, ok := val.([]byte)
+	if !ok {
+		return rcbor.WrongType("message", 2, "byte string")
+	}
+	if len(tok) != 16 {
+		return rcbor.WrongLength("message", 2, 16, len(tok))
 	}
+	copy(rm.Token[:], tok)
 	return nil
 }
 
 // MarshalCBOR writes the RAINS message to the provided writer.
 // Implements the CBORMarshaler interface.
 func (rm *Message) MarshalCBOR(w *cbor.CBORWriter) error {
-	if err := w.WriteTag(cbor.CBORTag(rainsTag)); err != nil {
+	if err := w.WriteTag(cbor.CBORTag(rcbor.MessageTag)); err != nil {
 		return err
 	}
 
@@ -158,17 +227,17 @@ func (rm *Message) MarshalCBOR(w *cbor.CBORWriter) error {
 	for _, sect := range rm.Content {
 		switch sect.(type) {
 		case *section.Assertion:
-			msgsect = append(msgsect, [2]interface{}{1, sect})
+			msgsect = append(msgsect, [2]interface{}{AssertionType, sect})
 		case *section.Shard:
-			msgsect = append(msgsect, [2]interface{}{2, sect})
+			msgsect = append(msgsect, [2]interface{}{ShardType, sect})
 		case *section.Pshard:
-			msgsect = append(msgsect, [2]interface{}{3, sect})
+			msgsect = append(msgsect, [2]interface{}{PshardType, sect})
 		case *section.Zone:
-			msgsect = append(msgsect, [2]interface{}{4, sect})
+			msgsect = append(msgsect, [2]interface{}{ZoneType, sect})
 		case *query.Name:
-			msgsect = append(msgsect, [2]interface{}{5, sect})
+			msgsect = append(msgsect, [2]interface{}{QueryType, sect})
 		case *section.Notification:
-			msgsect = append(msgsect, [2]interface{}{23, sect})
+			msgsect = append(msgsect, [2]interface{}{NotificationType, sect})
 		default:
 			return fmt.Errorf("unknown section type: %T", sect)
 		}
@@ -2,12 +2,19 @@ package message
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
 	"testing"
 
 	cbor2 "github.com/britram/borat"
 	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
 )
 
 func TestCBOR(t *testing.T) {
@@ -28,14 +35,121 @@ func TestCBOR(t *testing.T) {
 			t.Fatalf("%d: Was not able to unmarshal msg, err=%s", i, err.Error())
 		}
 		CheckMessage(test.input, msg, t)
+		cbor.NewWriter(encoding).Marshal(&test.input)
+		msg = Message{}
+		err = cbor.NewLimitedReader(encoding, cbor.Limits{}).Unmarshal(&msg)
+		if err != nil {
+			t.Fatalf("%d: Was not able to unmarshal msg within limits, err=%s", i, err.Error())
+		}
+		CheckMessage(test.input, msg, t)
+	}
+}
+
+func TestCBORRoundTrip(t *testing.T) {
+	extraKey := object.PublicKey()
+	extraKey.KeyPhase = 2
+	objs := append(object.AllObjects(),
+		object.Object{Type: object.OTExtraKey, Value: extraKey},
+		object.Object{Type: object.OTIP6Addr, Value: "::ffff:192.0.2.1"},
+		object.Object{Type: object.OTName, Value: object.Name{Name: "a", Types: []object.Type{}}})
+	var sections []section.Section
+	for _, obj := range objs {
+		a := section.GetAssertion()
+		a.Content = []object.Object{obj}
+		sections = append(sections, a)
+	}
+	signed := section.GetAssertion()
+	signed.Signatures = []signature.Sig{section.Signature()}
+	sections = append(sections, signed, section.GetShard(), section.GetPshard(), section.GetZone(),
+		section.GetQuery(), section.GetNotification(), section.NotificationNoData())
+	for i, s := range sections {
+		msg := Message{Token: token.New(), Content: []section.Section{s}}
+		encoding := new(bytes.Buffer)
+		if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+			t.Fatalf("%d: Was not able to marshal %v: %v", i, s, err)
+		}
+		fast, err := Decode(encoding.Bytes())
+		if err != nil {
+			t.Fatalf("%d: Was not able to decode %v: %v", i, s, err)
+		}
+		decoded := Message{}
+		if err := cbor.NewReader(encoding).Unmarshal(&decoded); err != nil {
+			t.Fatalf("%d: Was not able to unmarshal %v: %v", i, s, err)
+		}
+		for _, decoded := range []Message{decoded, *fast} {
+			CheckMessage(msg, decoded, t)
+			if a, ok := s.(*section.Assertion); ok {
+				content := decoded.Content[0].(*section.Assertion).Content
+				if !reflect.DeepEqual(a.Content, content) {
+					t.Errorf("%d: objects changed on the wire. expected=%v actual=%v", i,
+						a.Content, content)
+				}
+			}
+		}
+	}
+}
+
+func TestCBORMarshalErrors(t *testing.T) {
+	key := object.PublicKey()
+	key.Key = []byte{1, 2, 3}
+	var tests = []object.Object{
+		{Type: object.OTIP4Addr, Value: "2001:db8::"},
+		{Type: object.OTIP6Addr, Value: "no address"},
+		{Type: object.OTIP6Addr, Value: 6},
+		{Type: object.OTRedirection, Value: 4},
+		{Type: object.OTDelegation, Value: key},
+		{Type: object.OTInfraKey, Value: keys.PublicKey{}},
+	}
+	for i, obj := range tests {
+		a := section.GetAssertion()
+		a.Content = []object.Object{obj}
+		msg := Message{Token: token.New(), Content: []section.Section{a}}
+		if err := cbor.NewWriter(new(bytes.Buffer)).Marshal(&msg); err == nil {
+			t.Errorf("%d: expected an error when marshaling %v", i, obj)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var tests = []struct {
+		input Message
+	}{
+		{GetMessage()},
+	}
+	for i, test := range tests {
+		encoding, err := json.Marshal(&test.input)
+		if err != nil {
+			t.Fatalf("%d: Was not able to marshal msg to json, err=%v", i, err)
+		}
+		msg := Message{}
+		if err := json.Unmarshal(encoding, &msg); err != nil {
+			t.Fatalf("%d: Was not able to unmarshal json msg, err=%v json=%s", i, err, encoding)
+		}
+		CheckMessage(test.input, msg, t)
+	}
+}
+
+func TestJSONErrorCases(t *testing.T) {
+	var tests = []string{
+		`[]`,
+		`{"unknown": 1}`,
+		`{"token": {"hex": "zz"}}`,
+		`{"token": {"hex": "00"}, "content": [["unknown", {}]]}`,
+		`{"expires": 1.5}`,
+	}
+	for i, test := range tests {
+		msg := Message{}
+		if err := json.Unmarshal([]byte(test), &msg); err == nil {
+			t.Errorf("%d: Expected an error when unmarshalling %s", i, test)
+		}
 	}
 }
 
 func TestCBORErrorCases(t *testing.T) {
 	encWithRainsTag := new(bytes.Buffer)
-	cbor2.NewCBORWriter(encWithRainsTag).WriteTag(cbor2.CBORTag(rainsTag))
+	cbor2.NewCBORWriter(encWithRainsTag).WriteTag(cbor2.CBORTag(cbor.MessageTag))
 	encWithTag := new(bytes.Buffer)
-	cbor2.NewCBORWriter(encWithTag).WriteTag(cbor2.CBORTag(rainsTag + 1))
+	cbor2.NewCBORWriter(encWithTag).WriteTag(cbor2.CBORTag(cbor.MessageTag + 1))
 	var tests = []struct {
 		encoding []byte
 		errMsg   string
@@ -55,6 +169,146 @@ func TestCBORErrorCases(t *testing.T) {
 	}
 }
 
+func TestCBORDecodeErrors(t *testing.T) {
+	tok := make([]byte, 16)
+	sigs := []interface{}{[]interface{}{1, 0, 0, 1000, 2000, []byte("sig")}}
+	obj := []interface{}{[]interface{}{5, 1, 0, make([]byte, 32)}}
+	assertion := func(objs []interface{}) []interface{} {
+		return []interface{}{1, intMap{0: sigs, 3: "www", 4: "ethz.ch", 6: ".", 7: objs}}
+	}
+	msg := func(content ...interface{}) intMap {
+		return intMap{2: tok, 23: content}
+	}
+	var tests = []struct {
+		input intMap
+		item  string
+		key   int
+		kind  error
+	}{
+		{msg(assertion(obj)), "", 0, nil},
+		{intMap{2: tok, 5: 1, 23: []interface{}{}}, "message", 5, cbor.ErrUnknownKey},
+		{intMap{23: []interface{}{}}, "message", 2, cbor.ErrMissingKey},
+		{intMap{2: tok[:8], 23: []interface{}{}}, "message", 2, cbor.ErrWrongLength},
+		{intMap{2: tok, 23: "content"}, "message", 23, cbor.ErrWrongType},
+		{msg([]interface{}{9, intMap{3: "www"}}), "message", 23, cbor.ErrOutOfRange},
+		{msg([]interface{}{1}), "message", 23, cbor.ErrWrongLength},
+		{msg([]interface{}{1, intMap{0: sigs, 4: "ethz.ch", 6: ".", 7: obj}}), "assertion", 3,
+			cbor.ErrMissingKey},
+		{msg([]interface{}{1, intMap{0: sigs, 3: 3, 7: obj}}), "assertion", 3, cbor.ErrWrongType},
+		{msg([]interface{}{1, intMap{0: sigs, 3: "www", 7: obj, 8: "x"}}), "assertion", 8,
+			cbor.ErrUnknownKey},
+		{msg(assertion([]interface{}{[]interface{}{99, "x"}})), "object", 0, cbor.ErrOutOfRange},
+		{msg(assertion([]interface{}{[]interface{}{1, "x"}})), "object", -1, cbor.ErrWrongLength},
+		{msg(assertion([]interface{}{[]interface{}{1, "x", []interface{}{42}}})), "object", 2,
+			cbor.ErrOutOfRange},
+		{msg(assertion([]interface{}{[]interface{}{5, 1, 0, make([]byte, 3)}})), "object", 3,
+			cbor.ErrWrongLength},
+		{msg(assertion([]interface{}{[]interface{}{8, "srv", 70000, 1}})), "object", 2,
+			cbor.ErrOutOfRange},
+		{msg(assertion([]interface{}{[]interface{}{4, 1}})), "object", 1, cbor.ErrWrongType},
+		{msg([]interface{}{1, intMap{0: []interface{}{[]interface{}{7, 0, 0, 1, 2, []byte{}}},
+			3: "www", 7: obj}}), "signature", 0, cbor.ErrOutOfRange},
+		{msg([]interface{}{2, intMap{0: sigs, 4: "ch", 6: ".", 11: []interface{}{"a"},
+			23: []interface{}{}}}), "shard", 11, cbor.ErrWrongLength},
+		{msg([]interface{}{3, intMap{0: sigs, 4: "ch", 6: ".", 11: []interface{}{"a", "b"},
+			23: []interface{}{9, 4, []byte{0}}}}), "bloom filter", 0, cbor.ErrOutOfRange},
+		{msg([]interface{}{4, intMap{0: sigs, 4: "ch", 6: ".", 23: []interface{}{1}}}), "zone", 23,
+			cbor.ErrWrongType},
+		{msg([]interface{}{5, intMap{6: ".", 8: "ethz.ch", 10: []interface{}{2}, 12: 10,
+			13: []interface{}{42}, 14: 0, 17: 0}}), "query", 13, cbor.ErrOutOfRange},
+		{msg([]interface{}{5, intMap{6: ".", 8: "ethz.ch", 10: []interface{}{2}, 13: []interface{}{},
+			14: 0, 17: 0}}), "query", 12, cbor.ErrMissingKey},
+		{msg([]interface{}{23, intMap{2: tok, 21: 999, 22: ""}}), "notification", 21,
+			cbor.ErrOutOfRange},
+	}
+	for i, test := range tests {
+		encoding := new(bytes.Buffer)
+		w := cbor2.NewCBORWriter(encoding)
+		w.WriteTag(cbor2.CBORTag(cbor.MessageTag))
+		if err := test.input.MarshalCBOR(w); err != nil {
+			t.Fatalf("%d: could not encode test input: %v", i, err)
+		}
+		msg := Message{}
+		err := cbor.NewReader(encoding).Unmarshal(&msg)
+		if test.kind == nil {
+			if err != nil {
+				t.Errorf("%d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		decodeErr, ok := err.(*cbor.DecodeError)
+		if !ok || decodeErr.Item != test.item || decodeErr.Key != test.key ||
+			decodeErr.Kind != test.kind {
+			t.Errorf("%d: expected %s key %d: %v, actual error=%v", i, test.item, test.key,
+				test.kind, err)
+		}
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	msg := GetMessage()
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+		t.Fatalf("Was not able to marshal msg: %v", err)
+	}
+	env, err := DecodeEnvelope(encoding.Bytes())
+	if err != nil {
+		t.Fatalf("Was not able to decode envelope: %v", err)
+	}
+	expectedTypes := []int{AssertionType, ShardType, ZoneType, QueryType, NotificationType,
+		PshardType}
+	if env.Token != msg.Token || fmt.Sprint(env.Capabilities) != fmt.Sprint(msg.Capabilities) ||
+		len(env.Signatures) != len(msg.Signatures) ||
+		fmt.Sprint(env.SectionTypes) != fmt.Sprint(expectedTypes) {
+		t.Errorf("Wrong envelope. expected=%v actual=%v", msg, env)
+	}
+	if !env.Contains(ZoneType) || env.Contains(99) || env.Size() != encoding.Len() {
+		t.Errorf("Wrong content or size of envelope %v", env)
+	}
+	decoded, err := env.Decode()
+	if err != nil {
+		t.Fatalf("Was not able to decode msg from envelope: %v", err)
+	}
+	CheckMessage(*decoded, msg, t)
+}
+
+func TestEnvelopeErrors(t *testing.T) {
+	tok := make([]byte, 16)
+	var tests = []struct {
+		input intMap
+		item  string
+		key   int
+		kind  error
+	}{
+		{intMap{2: tok, 5: 1, 23: []interface{}{}}, "message", 5, cbor.ErrUnknownKey},
+		{intMap{23: []interface{}{}}, "message", 2, cbor.ErrMissingKey},
+		{intMap{0: "sigs", 2: tok, 23: []interface{}{}}, "message", 0, cbor.ErrWrongType},
+		{intMap{2: tok}, "message", 23, cbor.ErrMissingKey},
+		{intMap{2: tok, 23: []interface{}{[]interface{}{1}}}, "message", 23, cbor.ErrWrongLength},
+		{intMap{2: tok, 23: []interface{}{[]interface{}{7, "a"}}}, "message", 23, cbor.ErrOutOfRange},
+		{intMap{2: tok, 23: []interface{}{[]interface{}{"1", "a"}}}, "message", 23,
+			cbor.ErrWrongType},
+	}
+	for i, test := range tests {
+		encoding := new(bytes.Buffer)
+		w := cbor2.NewCBORWriter(encoding)
+		w.WriteTag(cbor2.CBORTag(cbor.MessageTag))
+		if err := test.input.MarshalCBOR(w); err != nil {
+			t.Fatalf("%d: could not encode test input: %v", i, err)
+		}
+		_, err := DecodeEnvelope(encoding.Bytes())
+		decodeErr, ok := err.(*cbor.DecodeError)
+		if !ok || decodeErr.Item != test.item || decodeErr.Key != test.key ||
+			decodeErr.Kind != test.kind {
+			t.Errorf("%d: expected %s key %d: %v, actual error=%v", i, test.item, test.key,
+				test.kind, err)
+		}
+	}
+	if _, err := DecodeEnvelope([]byte{0xa0}); err == nil {
+		t.Error("expected an error for an untagged encoding")
+	}
+}
+
 func CheckMessage(m1, m2 Message, t *testing.T) {
 	if m1.Token != m2.Token {
 		t.Error("Token mismatch")
@@ -133,3 +387,116 @@ func CheckMessage(m1, m2 Message, t *testing.T) {
 		}
 	}
 }
+
+func TestWireSizeEstimate(t *testing.T) {
+	for i, msg := range benchmarkMessages() {
+		encoding := new(bytes.Buffer)
+		if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+			t.Fatalf("%d: Was not able to marshal msg", i)
+		}
+		if estimate := msg.WireSizeEstimate(); estimate < encoding.Len()/2 || estimate > 2*encoding.Len() {
+			t.Errorf("%d: Estimate is off by more than a factor of two. estimate=%d actual=%d", i,
+				estimate, encoding.Len())
+		}
+	}
+}
+
+//benchmarkMessages returns answers containing a signed assertion, a shard of 10 assertions and a
+//zone of 100 assertions.
+func benchmarkMessages() []Message {
+	sig := section.Signature()
+	sig.Data = make([]byte, 64)
+	assertion := func() *section.Assertion {
+		a := section.GetAssertion()
+		a.Signatures = []signature.Sig{sig}
+		return a
+	}
+	shard, zone := section.GetShard(), section.GetZone()
+	shard.Signatures, zone.Signatures = []signature.Sig{sig}, []signature.Sig{sig}
+	for i := 0; i < 10; i++ {
+		shard.Content = append(shard.Content, assertion())
+	}
+	for i := 0; i < 100; i++ {
+		zone.Content = append(zone.Content, assertion())
+	}
+	return []Message{
+		Message{Token: token.New(), Content: []section.Section{assertion()}},
+		Message{Token: token.New(), Content: []section.Section{shard}},
+		Message{Token: token.New(), Content: []section.Section{zone}},
+	}
+}
+
+func BenchmarkEncodeWriter(b *testing.B) {
+	for _, msg := range benchmarkMessages() {
+		b.Run(fmt.Sprintf("%dB", msg.WireSizeEstimate()), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				encoding := new(bytes.Buffer)
+				if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEncodePooled(b *testing.B) {
+	for _, msg := range benchmarkMessages() {
+		b.Run(fmt.Sprintf("%dB", msg.WireSizeEstimate()), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				encoder := cbor.NewEncoder(msg.WireSizeEstimate())
+				if _, err := encoder.Encode(&msg); err != nil {
+					b.Fatal(err)
+				}
+				encoder.Release()
+			}
+		})
+	}
+}
+
+//zoneEncoding returns the encoding of a message containing a signed zone of n assertions.
+func zoneEncoding(b *testing.B, n int) []byte {
+	sig := section.Signature()
+	sig.Data = make([]byte, 64)
+	zone := section.GetZone()
+	zone.Signatures = []signature.Sig{sig}
+	zone.Content = make([]*section.Assertion, n)
+	for i := range zone.Content {
+		zone.Content[i] = &section.Assertion{
+			SubjectName: fmt.Sprintf("host%d", i),
+			Content:     []object.Object{{Type: object.OTIP4Addr, Value: "192.0.2.1"}},
+		}
+	}
+	msg := Message{Token: token.New(), Content: []section.Section{zone}}
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+		b.Fatal(err)
+	}
+	return encoding.Bytes()
+}
+
+func BenchmarkDecodeZoneReader(b *testing.B) {
+	encoding := zoneEncoding(b, 100000)
+	b.SetBytes(int64(len(encoding)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		msg := Message{}
+		if err := cbor.NewReader(bytes.NewReader(encoding)).Unmarshal(&msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeZone(b *testing.B) {
+	encoding := zoneEncoding(b, 100000)
+	b.SetBytes(int64(len(encoding)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Decode(encoding); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package message
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	cbor2 "github.com/britram/borat"
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+)
+
+//go:generate go run ../../../cmd/rainsvectors -out testdata/vectors
+
+//vector is a wire format test vector as written by cmd/rainsvectors.
+type vector struct {
+	Name         string          `json:"name"`
+	CBOR         string          `json:"cbor"`
+	Message      json.RawMessage `json:"message"`
+	SigningBytes string          `json:"signingBytes"`
+	PublicKey    string          `json:"publicKey"`
+}
+
+func TestVectors(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "vectors", "*.json"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no test vectors found: %v", err)
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Was not able to read %s: %v", path, err)
+		}
+		var v vector
+		if err := json.Unmarshal(data, &v); err != nil {
+			t.Fatalf("Was not able to parse %s: %v", path, err)
+		}
+		encoding, _ := hex.DecodeString(v.CBOR)
+		if !cbor.IsCanonical(encoding) {
+			t.Errorf("%s: encoding is not canonical", v.Name)
+		}
+		msg, err := Decode(encoding)
+		if err != nil {
+			t.Errorf("%s: Was not able to decode: %v", v.Name, err)
+			continue
+		}
+		decoded, err := msg.MarshalJSON()
+		if err != nil || compactJSON(decoded) != compactJSON(v.Message) {
+			t.Errorf("%s: wrong decoded message. expected=%s actual=%s err=%v", v.Name, v.Message,
+				decoded, err)
+		}
+		fromJSON := &Message{}
+		if err := json.Unmarshal(v.Message, fromJSON); err != nil {
+			t.Errorf("%s: Was not able to unmarshal the JSON message: %v", v.Name, err)
+			continue
+		}
+		for _, m := range []*Message{msg, fromJSON} {
+			if reencoded := canonicalEncoding(t, m); !bytes.Equal(reencoded, encoding) {
+				t.Errorf("%s: wrong encoding. expected=%x actual=%x", v.Name, encoding, reencoded)
+			}
+		}
+		if v.SigningBytes != "" {
+			checkSigningBytes(t, v, msg)
+		}
+	}
+}
+
+//checkSigningBytes removes the signature of msg or of its only section and checks that it has
+//been created over the signing bytes of v.
+func checkSigningBytes(t *testing.T, v vector, msg *Message) {
+	unsigned := new(bytes.Buffer)
+	var sig signature.Sig
+	if len(msg.Signatures) == 1 {
+		sig, msg.Signatures = msg.Signatures[0], nil
+		if err := msg.MarshalCBOR(cbor2.NewCBORWriter(unsigned)); err != nil {
+			t.Fatalf("%s: Was not able to encode the message: %v", v.Name, err)
+		}
+	} else {
+		s := msg.Content[0].(section.WithSig)
+		sig = s.AllSigs()[0]
+		s.DeleteSig(0)
+		if err := s.MarshalCBOR(cbor2.NewCBORWriter(unsigned)); err != nil {
+			t.Fatalf("%s: Was not able to encode the section: %v", v.Name, err)
+		}
+	}
+	signingBytes, err := sig.SigningBytes(unsigned.Bytes())
+	if err != nil || hex.EncodeToString(signingBytes) != v.SigningBytes {
+		t.Errorf("%s: wrong signing bytes. expected=%s actual=%x err=%v", v.Name, v.SigningBytes,
+			signingBytes, err)
+	}
+	publicKey, _ := hex.DecodeString(v.PublicKey)
+	if !ed25519.Verify(publicKey, signingBytes, sig.Data.([]byte)) {
+		t.Errorf("%s: signature does not verify", v.Name)
+	}
+}
+
+func canonicalEncoding(t *testing.T, msg *Message) []byte {
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(msg); err != nil {
+		t.Fatalf("Was not able to encode msg: %v", err)
+	}
+	canonical, err := cbor.Canonical(encoding.Bytes())
+	if err != nil {
+		t.Fatalf("Was not able to canonicalize msg: %v", err)
+	}
+	return canonical
+}
+
+func compactJSON(data []byte) string {
+	compact := new(bytes.Buffer)
+	if err := json.Compact(compact, data); err != nil {
+		return string(data)
+	}
+	return compact.String()
+}
@@ -0,0 +1,88 @@
+package message
+
+import (
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+)
+
+//Estimated number of bytes of the encoding of parts whose size does not depend on their content.
+const (
+	headerWireSize    = 32
+	sectionWireSize   = 16
+	objectWireSize    = 8
+	signatureWireSize = 88
+	keyWireSize       = 48
+)
+
+//WireSizeEstimate returns an estimate of the number of bytes of m's CBOR encoding. It is cheap to
+//compute and meant to size buffers before encoding m, not as an upper bound.
+func (m *Message) WireSizeEstimate() int {
+	size := headerWireSize + len(m.Signatures)*signatureWireSize
+	for _, c := range m.Capabilities {
+		size += len(c) + 2
+	}
+	for _, s := range m.Content {
+		size += sectionWireSizeEstimate(s)
+	}
+	return size
+}
+
+//sectionWireSizeEstimate returns an estimate of the number of bytes of the encoding of s.
+func sectionWireSizeEstimate(s section.Section) int {
+	switch s := s.(type) {
+	case *section.Assertion:
+		size := sectionWireSize + len(s.SubjectName) + len(s.SubjectZone) + len(s.Context) +
+			sigsWireSize(s.Signatures)
+		for _, o := range s.Content {
+			size += objectWireSizeEstimate(o)
+		}
+		return size
+	case *section.Shard:
+		size := sectionWireSize + len(s.SubjectZone) + len(s.Context) + len(s.RangeFrom) +
+			len(s.RangeTo) + sigsWireSize(s.Signatures)
+		for _, a := range s.Content {
+			size += sectionWireSizeEstimate(a)
+		}
+		return size
+	case *section.Pshard:
+		return sectionWireSize + len(s.SubjectZone) + len(s.Context) + len(s.RangeFrom) +
+			len(s.RangeTo) + sigsWireSize(s.Signatures) + len(s.BloomFilter.Filter)
+	case *section.Zone:
+		size := sectionWireSize + len(s.SubjectZone) + len(s.Context) + sigsWireSize(s.Signatures)
+		for _, a := range s.Content {
+			size += sectionWireSizeEstimate(a)
+		}
+		return size
+	case *query.Name:
+		return sectionWireSize + len(s.Name) + len(s.Context) + len(s.Types) + len(s.Options)
+	case *section.Notification:
+		return sectionWireSize + len(s.Token) + len(s.Data)
+	default:
+		return sectionWireSize
+	}
+}
+
+//objectWireSizeEstimate returns an estimate of the number of bytes of the encoding of o.
+func objectWireSizeEstimate(o object.Object) int {
+	switch v := o.Value.(type) {
+	case string:
+		return objectWireSize + len(v)
+	case object.Name:
+		return objectWireSize + len(v.Name) + len(v.Types)
+	case object.ServiceInfo:
+		return objectWireSize + len(v.Name) + 6
+	case object.Certificate:
+		return objectWireSize + len(v.Data) + 4
+	case object.NamesetExpr:
+		return objectWireSize + len(v)
+	default:
+		return objectWireSize + keyWireSize
+	}
+}
+
+//sigsWireSize returns an estimate of the number of bytes of the encoding of sigs.
+func sigsWireSize(sigs []signature.Sig) int {
+	return len(sigs) * signatureWireSize
+}
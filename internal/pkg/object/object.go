@@ -3,15 +3,19 @@ package object
 import (
 	"bytes"
 	"encoding/hex"
-	"errors"
+	"encoding/json"
 	"fmt"
+	"math"
 	"net"
+	"net/netip"
 	"sort"
 	"strconv"
+	"strings"
 
 	cbor "github.com/britram/borat"
 	log "github.com/inconshreveable/log15"
 	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"golang.org/x/crypto/ed25519"
 )
@@ -22,27 +26,59 @@ type Object struct {
 	Value interface{}
 }
 
-// UnmarshalArray takes in a CBOR decoded array and populates the object.
+//objectLengths contains the number of elements of the CBOR array of each object type.
+var objectLengths = map[Type]int{
+	OTName:        3,
+	OTIP6Addr:     2,
+	OTIP4Addr:     2,
+	OTRedirection: 2,
+	OTDelegation:  4,
+	OTNameset:     2,
+	OTCertInfo:    5,
+	OTServiceInfo: 4,
+	OTRegistrar:   2,
+	OTRegistrant:  2,
+	OTInfraKey:    4,
+	OTExtraKey:    5,
+	OTNextKey:     6,
+}
+
+// UnmarshalArray takes in a CBOR decoded array and populates the object. It returns a
+// *cbor.DecodeError identifying the offending element if in is malformed.
 func (obj *Object) UnmarshalArray(in []interface{}) error {
+	if len(in) == 0 {
+		return rcbor.WrongLength("object", -1, 2, 0)
+	}
 	t, ok := in[0].(int)
 	if !ok {
-		return errors.New("cbor object encoding first element (type) must be an int")
+		return rcbor.WrongType("object", 0, "int")
+	}
+	length, ok := objectLengths[Type(t)]
+	if !ok {
+		return rcbor.OutOfRange("object", 0, t)
+	}
+	//The key phase of an extra key was not encoded in earlier versions.
+	if len(in) != length && !(Type(t) == OTExtraKey && len(in) == length-1) {
+		return rcbor.WrongLength("object", -1, length, len(in))
 	}
 	switch Type(t) {
 	case OTName:
 		no := Name{Types: make([]Type, 0)}
 		no.Name, ok = in[1].(string)
 		if !ok {
-			return errors.New("cbor object encoding of name not a string")
+			return rcbor.WrongType("object", 1, "string")
 		}
 		ots, ok := in[2].([]interface{})
 		if !ok {
-			return errors.New("cbor object encoding of name not an array")
+			return rcbor.WrongType("object", 2, "array")
 		}
 		for _, ot := range ots {
 			o, ok := ot.(int)
 			if !ok {
-				return errors.New("cbor object encoding of name not an array")
+				return rcbor.WrongType("object", 2, "array of ints")
+			}
+			if _, ok := objectLengths[Type(o)]; !ok {
+				return rcbor.OutOfRange("object", 2, o)
 			}
 			no.Types = append(no.Types, Type(o))
 		}
@@ -50,204 +86,155 @@ func (obj *Object) UnmarshalArray(in []interface{}) error {
 	case OTIP6Addr:
 		v, ok := in[1].([]byte)
 		if !ok {
-			return errors.New("cbor object encoding of ip6 not a byte array")
+			return rcbor.WrongType("object", 1, "byte string")
 		}
-		ip := net.IP(v)
-		obj.Value = ip.String()
+		if len(v) != net.IPv6len {
+			return rcbor.WrongLength("object", 1, net.IPv6len, len(v))
+		}
+		//netip keeps the IPv6 notation of IPv4-mapped addresses.
+		obj.Value = netip.AddrFrom16([net.IPv6len]byte(v)).String()
 	case OTIP4Addr:
 		v, ok := in[1].([]byte)
 		if !ok {
-			return errors.New("cbor object encoding of ip6 not a byte array")
+			return rcbor.WrongType("object", 1, "byte string")
 		}
-		ip := net.IP(v)
-		obj.Value = ip.String()
-	case OTRedirection:
-		obj.Value = in[1]
-	case OTDelegation:
-		alg, ok := in[1].(int)
-		if !ok {
-			return errors.New("cbor object encoding of deleg algo not an int")
+		//Earlier versions encoded IPv4 addresses as IPv4-mapped IPv6 addresses.
+		ip := net.IP(v).To4()
+		if ip == nil {
+			return rcbor.WrongLength("object", 1, net.IPv4len, len(v))
 		}
-		kp, ok := in[2].(int)
+		obj.Value = ip.String()
+	case OTRedirection, OTRegistrar, OTRegistrant:
+		obj.Value, ok = in[1].(string)
 		if !ok {
-			return errors.New("cbor object encoding of deleg phase not an int")
-		}
-		var key []byte
-		switch algorithmTypes.Signature(alg) {
-		case algorithmTypes.Ed25519:
-			key, ok = in[3].([]byte)
-			if !ok {
-				return errors.New("cbor object encoding of deleg key not a byte array")
-			}
-		default:
-			return fmt.Errorf("unsupported algorithm: %v", alg)
+			return rcbor.WrongType("object", 1, "string")
 		}
-		pkey := keys.PublicKey{
-			PublicKeyID: keys.PublicKeyID{
-				Algorithm: algorithmTypes.Signature(alg),
-				KeySpace:  keys.RainsKeySpace,
-				KeyPhase:  kp,
-			},
-			Key: ed25519.PublicKey(key),
-		}
-		obj.Value = pkey
 	case OTNameset:
 		v, ok := in[1].(string)
 		if !ok {
-			return errors.New("cbor object encoding of nameset not a string")
+			return rcbor.WrongType("object", 1, "string")
 		}
 		obj.Value = NamesetExpr(v)
 	case OTCertInfo:
 		proto, ok := in[1].(int)
 		if !ok {
-			return errors.New("cbor object encoding of cert proto not an int")
+			return rcbor.WrongType("object", 1, "int")
+		}
+		if ProtocolType(proto) != PTUnspecified && ProtocolType(proto) != PTTLS {
+			return rcbor.OutOfRange("object", 1, proto)
 		}
 		usage, ok := in[2].(int)
 		if !ok {
-			return errors.New("cbor object encoding of cert usage not an int")
+			return rcbor.WrongType("object", 2, "int")
+		}
+		if CertificateUsage(usage) != CUTrustAnchor && CertificateUsage(usage) != CUEndEntity {
+			return rcbor.OutOfRange("object", 2, usage)
 		}
 		hash, ok := in[3].(int)
 		if !ok {
-			return errors.New("cbor object encoding of cert hash not an int")
+			return rcbor.WrongType("object", 3, "int")
+		}
+		if algorithmTypes.Hash(hash) < algorithmTypes.NoHashAlgo ||
+			algorithmTypes.Hash(hash) > algorithmTypes.Fnv128 {
+			return rcbor.OutOfRange("object", 3, hash)
 		}
 		data, ok := in[4].([]byte)
 		if !ok {
-			return errors.New("cbor object encoding of cert data not a byte array")
+			return rcbor.WrongType("object", 4, "byte string")
 		}
-		co := Certificate{
+		obj.Value = Certificate{
 			Type:     ProtocolType(proto),
 			Usage:    CertificateUsage(usage),
 			HashAlgo: algorithmTypes.Hash(hash),
 			Data:     data,
 		}
-		obj.Value = co
 	case OTServiceInfo:
 		name, ok := in[1].(string)
 		if !ok {
-			return errors.New("cbor object encoding of serv name not an string")
+			return rcbor.WrongType("object", 1, "string")
 		}
 		port, ok := in[2].(int)
 		if !ok {
-			return errors.New("cbor object encoding of serv port not an int")
+			return rcbor.WrongType("object", 2, "int")
+		}
+		if port < 0 || port > math.MaxUint16 {
+			return rcbor.OutOfRange("object", 2, port)
 		}
 		prio, ok := in[3].(int)
 		if !ok {
-			return errors.New("cbor object encoding of serv prio not an int")
+			return rcbor.WrongType("object", 3, "int")
 		}
-		si := ServiceInfo{
+		if prio < 0 {
+			return rcbor.OutOfRange("object", 3, prio)
+		}
+		obj.Value = ServiceInfo{
 			Name:     name,
 			Port:     uint16(port),
 			Priority: uint(prio),
 		}
-		obj.Value = si
-	case OTRegistrar:
-		obj.Value, ok = in[1].(string)
-		if !ok {
-			return errors.New("cbor object encoding of serv name not an string")
-		}
-	case OTRegistrant:
-		obj.Value, ok = in[1].(string)
-		if !ok {
-			return errors.New("cbor object encoding of serv name not an string")
-		}
-	case OTInfraKey:
-		alg, ok := in[1].(int)
-		if !ok {
-			return errors.New("cbor object encoding of infra algo not an int")
-		}
-		kp, ok := in[2].(int)
-		if !ok {
-			return errors.New("cbor object encoding of infra phase not an int")
-		}
-		var key []byte
-		switch algorithmTypes.Signature(alg) {
-		case algorithmTypes.Ed25519:
-			key, ok = in[3].([]byte)
-			if !ok {
-				return errors.New("cbor object encoding of infra key not a byte array")
-			}
-		default:
-			return fmt.Errorf("unsupported algorithm: %v", alg)
-		}
-		pkey := keys.PublicKey{
-			PublicKeyID: keys.PublicKeyID{
-				Algorithm: algorithmTypes.Signature(alg),
-				KeySpace:  keys.RainsKeySpace,
-				KeyPhase:  kp,
-			},
-			Key: ed25519.PublicKey(key),
+	case OTDelegation, OTInfraKey, OTExtraKey, OTNextKey:
+		pkey, err := unmarshalPublicKey(Type(t), in)
+		if err != nil {
+			return err
 		}
 		obj.Value = pkey
-	case OTExtraKey:
-		alg, ok := in[1].(int)
-		if !ok {
-			return errors.New("cbor object encoding of extra algo not an int")
-		}
-		ks, ok := in[2].(int)
-		if !ok {
-			return errors.New("cbor object encoding of extra keyspace not an int")
-		}
-		var key []byte
-		switch algorithmTypes.Signature(alg) {
-		case algorithmTypes.Ed25519:
-			key, ok = in[3].([]byte)
-			if !ok {
-				return errors.New("cbor object encoding of extra key not a byte array")
-			}
-		default:
-			return fmt.Errorf("unsupported algorithm: %v", alg)
-		}
-		pkey := keys.PublicKey{
-			PublicKeyID: keys.PublicKeyID{
-				Algorithm: algorithmTypes.Signature(alg),
-				KeySpace:  keys.KeySpaceID(ks),
-			},
-			Key: ed25519.PublicKey(key),
-		}
-		obj.Value = pkey
-	case OTNextKey:
-		alg, ok := in[1].(int)
-		if !ok {
-			return errors.New("cbor object encoding of nextKey algo not an int")
+	}
+	obj.Type = Type(t)
+	return nil
+}
+
+//unmarshalPublicKey decodes the CBOR array in of a public key object of type t.
+func unmarshalPublicKey(t Type, in []interface{}) (keys.PublicKey, error) {
+	pkey := keys.PublicKey{PublicKeyID: keys.PublicKeyID{KeySpace: keys.RainsKeySpace}}
+	alg, ok := in[1].(int)
+	if !ok {
+		return pkey, rcbor.WrongType("object", 1, "int")
+	}
+	if algorithmTypes.Signature(alg) != algorithmTypes.Ed25519 {
+		return pkey, rcbor.OutOfRange("object", 1, alg)
+	}
+	pkey.Algorithm = algorithmTypes.Signature(alg)
+	//The second element is the key space of an extra key and the key phase otherwise.
+	val, ok := in[2].(int)
+	if !ok {
+		return pkey, rcbor.WrongType("object", 2, "int")
+	}
+	switch {
+	case t == OTExtraKey && keys.KeySpaceID(val) != keys.RainsKeySpace:
+		return pkey, rcbor.OutOfRange("object", 2, val)
+	case t != OTExtraKey && val < 0:
+		return pkey, rcbor.OutOfRange("object", 2, val)
+	case t != OTExtraKey:
+		pkey.KeyPhase = val
+	}
+	key, ok := in[3].([]byte)
+	if !ok {
+		return pkey, rcbor.WrongType("object", 3, "byte string")
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return pkey, rcbor.WrongLength("object", 3, ed25519.PublicKeySize, len(key))
+	}
+	pkey.Key = ed25519.PublicKey(key)
+	if t == OTExtraKey && len(in) == 5 {
+		if pkey.KeyPhase, ok = in[4].(int); !ok {
+			return pkey, rcbor.WrongType("object", 4, "int")
 		}
-		kp, ok := in[2].(int)
-		if !ok {
-			return errors.New("cbor object encoding of nextKey phase not an int")
+		if pkey.KeyPhase < 0 {
+			return pkey, rcbor.OutOfRange("object", 4, pkey.KeyPhase)
 		}
+	}
+	if t == OTNextKey {
 		vs, ok := in[4].(int)
 		if !ok {
-			return errors.New("cbor object encoding of nextKey validSince not an int")
+			return pkey, rcbor.WrongType("object", 4, "int")
 		}
 		vu, ok := in[5].(int)
 		if !ok {
-			return errors.New("cbor object encoding of nextKey validUntil not an int")
-		}
-		var key []byte
-		switch algorithmTypes.Signature(alg) {
-		case algorithmTypes.Ed25519:
-			key, ok = in[3].([]byte)
-			if !ok {
-				return errors.New("cbor object encoding of nextKey key not a byte array")
-			}
-		default:
-			return fmt.Errorf("unsupported algorithm: %v", alg)
-		}
-		pkey := keys.PublicKey{
-			PublicKeyID: keys.PublicKeyID{
-				Algorithm: algorithmTypes.Signature(alg),
-				KeySpace:  keys.RainsKeySpace,
-				KeyPhase:  kp,
-			},
-			ValidSince: int64(vs),
-			ValidUntil: int64(vu),
-			Key:        ed25519.PublicKey(key),
+			return pkey, rcbor.WrongType("object", 5, "int")
 		}
-		obj.Value = pkey
-	default:
-		return errors.New("unknown object type in unmarshalling object")
+		pkey.ValidSince, pkey.ValidUntil = int64(vs), int64(vu)
 	}
-	obj.Type = Type(in[0].(int))
-	return nil
+	return pkey, nil
 }
 
 // MarshalCBOR implements a CBORMarshaler.
@@ -265,21 +252,40 @@ func (obj Object) MarshalCBOR(w *cbor.CBORWriter) error {
 		}
 		res = []interface{}{OTName, no.Name, ots}
 	case OTIP6Addr:
-		addrStr := obj.Value.(string)
+		addrStr, ok := obj.Value.(string)
+		if !ok {
+			return fmt.Errorf("expected OTIP6Addr value to be string but got: %T", obj.Value)
+		}
 		addr := net.ParseIP(addrStr)
-		res = []interface{}{OTIP6Addr, []byte(addr)}
+		if addr == nil {
+			return fmt.Errorf("OTIP6Addr value is not an IP address: %s", addrStr)
+		}
+		res = []interface{}{OTIP6Addr, []byte(addr.To16())}
 	case OTIP4Addr:
-		addrStr := obj.Value.(string)
-		addr := net.ParseIP(addrStr)
+		addrStr, ok := obj.Value.(string)
+		if !ok {
+			return fmt.Errorf("expected OTIP4Addr value to be string but got: %T", obj.Value)
+		}
+		addr := net.ParseIP(addrStr).To4()
+		if addr == nil {
+			return fmt.Errorf("OTIP4Addr value is not an IPv4 address: %s", addrStr)
+		}
 		res = []interface{}{OTIP4Addr, []byte(addr)}
 	case OTRedirection:
-		res = []interface{}{OTRedirection, obj.Value}
+		rstr, ok := obj.Value.(string)
+		if !ok {
+			return fmt.Errorf("expected OTRedirection object to be string but got: %T", obj.Value)
+		}
+		res = []interface{}{OTRedirection, rstr}
 	case OTDelegation:
 		pkey, ok := obj.Value.(keys.PublicKey)
 		if !ok {
 			return fmt.Errorf("expected OTDelegation value to be PublicKey but got: %T", obj.Value)
 		}
-		b := pubkeyToCBORBytes(pkey)
+		b, err := pubkeyToCBORBytes(pkey)
+		if err != nil {
+			return err
+		}
 		res = []interface{}{OTDelegation, int(pkey.Algorithm), pkey.KeyPhase, b}
 	case OTNameset:
 		nse, ok := obj.Value.(NamesetExpr)
@@ -316,21 +322,30 @@ func (obj Object) MarshalCBOR(w *cbor.CBORWriter) error {
 		if !ok {
 			return fmt.Errorf("expected OTInfraKey value to be PublicKey but got: %T", obj.Value)
 		}
-		b := pubkeyToCBORBytes(pkey)
+		b, err := pubkeyToCBORBytes(pkey)
+		if err != nil {
+			return err
+		}
 		res = []interface{}{OTInfraKey, int(pkey.Algorithm), pkey.KeyPhase, b}
 	case OTExtraKey:
 		pkey, ok := obj.Value.(keys.PublicKey)
 		if !ok {
 			return fmt.Errorf("expected OTExtraKey value to be PublicKey but got: %T", obj.Value)
 		}
-		b := pubkeyToCBORBytes(pkey)
-		res = []interface{}{OTExtraKey, int(pkey.Algorithm), int(pkey.KeySpace), b}
+		b, err := pubkeyToCBORBytes(pkey)
+		if err != nil {
+			return err
+		}
+		res = []interface{}{OTExtraKey, int(pkey.Algorithm), int(pkey.KeySpace), b, pkey.KeyPhase}
 	case OTNextKey:
 		pkey, ok := obj.Value.(keys.PublicKey)
 		if !ok {
 			return fmt.Errorf("expected OTNextKey value to be PublicKey but got: %T", obj.Value)
 		}
-		b := pubkeyToCBORBytes(pkey)
+		b, err := pubkeyToCBORBytes(pkey)
+		if err != nil {
+			return err
+		}
 		res = []interface{}{OTNextKey, int(pkey.Algorithm), pkey.KeyPhase, b, pkey.ValidSince, pkey.ValidUntil}
 	default:
 		return fmt.Errorf("unknown object type: %v", obj.Type)
@@ -338,14 +353,17 @@ func (obj Object) MarshalCBOR(w *cbor.CBORWriter) error {
 	return w.WriteArray(res)
 }
 
-func pubkeyToCBORBytes(p keys.PublicKey) []byte {
+func pubkeyToCBORBytes(p keys.PublicKey) ([]byte, error) {
 	switch p.Algorithm {
 	case algorithmTypes.Ed25519:
-		return []byte(p.Key.(ed25519.PublicKey))
-	case algorithmTypes.Ed448:
-		panic("Unsupported algorithm.")
+		key, ok := p.Key.(ed25519.PublicKey)
+		if !ok || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("expected Ed25519 public key but got: %T of length %d", p.Key,
+				len(key))
+		}
+		return []byte(key), nil
 	default:
-		panic("Unsupported algorithm.")
+		return nil, fmt.Errorf("unsupported public key algorithm: %v", p.Algorithm)
 	}
 }
 
@@ -418,6 +436,71 @@ func (o Object) String() string {
 	return fmt.Sprintf("OT:%d OV:%v", o.Type, o.Value)
 }
 
+//typeNames contains the names of the object types as used in the json encoding. They correspond to
+//the type markers of the zonefile format.
+var typeNames = map[Type]string{
+	OTName:        "name",
+	OTIP6Addr:     "ip6",
+	OTIP4Addr:     "ip4",
+	OTRedirection: "redir",
+	OTDelegation:  "deleg",
+	OTNameset:     "nameset",
+	OTCertInfo:    "cert",
+	OTServiceInfo: "srv",
+	OTRegistrar:   "regr",
+	OTRegistrant:  "regt",
+	OTInfraKey:    "infra",
+	OTExtraKey:    "extra",
+	OTNextKey:     "next",
+}
+
+//MarshalJSON implements the json.Marshaler interface. The type is encoded by name and binary data
+//of the value in hexadecimal.
+func (o Object) MarshalJSON() ([]byte, error) {
+	value := o.Value
+	switch v := o.Value.(type) {
+	case Name:
+		types := []string{}
+		for _, t := range v.Types {
+			types = append(types, t.Name())
+		}
+		value = struct {
+			Name  string   `json:"name"`
+			Types []string `json:"types"`
+		}{v.Name, types}
+	case ServiceInfo:
+		value = struct {
+			Name     string `json:"name"`
+			Port     uint16 `json:"port"`
+			Priority uint   `json:"priority"`
+		}{v.Name, v.Port, v.Priority}
+	case Certificate:
+		value = struct {
+			Type     ProtocolType     `json:"protocol"`
+			Usage    CertificateUsage `json:"usage"`
+			HashAlgo string           `json:"hashAlgo"`
+			Data     string           `json:"data"`
+		}{v.Type, v.Usage, v.HashAlgo.String(), hex.EncodeToString(v.Data)}
+	case keys.PublicKey:
+		key := ""
+		if data, ok := v.Key.(ed25519.PublicKey); ok {
+			key = hex.EncodeToString(data)
+		}
+		value = struct {
+			Algorithm  string `json:"algorithm"`
+			KeySpace   string `json:"keySpace"`
+			KeyPhase   int    `json:"keyPhase"`
+			ValidSince int64  `json:"validSince"`
+			ValidUntil int64  `json:"validUntil"`
+			Key        string `json:"key"`
+		}{v.Algorithm.String(), v.KeySpace.String(), v.KeyPhase, v.ValidSince, v.ValidUntil, key}
+	}
+	return json.Marshal(struct {
+		Type  string      `json:"type"`
+		Value interface{} `json:"value"`
+	}{o.Type.Name(), value})
+}
+
 //logObjectTypeAssertionFailure logs that it was not possible to type assert value as t
 func logObjectTypeAssertionFailure(t Type, value interface{}) {
 	log.Error("Object Type and corresponding type assertion of object's value do not match",
@@ -432,6 +515,30 @@ func (o Type) String() string {
 	return strconv.Itoa(int(o))
 }
 
+//Name returns the symbolic name of the type as used in the zonefile format (without the enclosing
+//colons) or its number if the type is unknown.
+func (o Type) Name() string {
+	if name, ok := typeNames[o]; ok {
+		return name
+	}
+	return o.String()
+}
+
+//ParseType returns the type identified by s. It accepts the symbolic name of a type (case
+//insensitive and optionally enclosed in colons as in the zonefile format) or its number.
+func ParseType(s string) (Type, error) {
+	name := strings.ToLower(strings.Trim(s, ":"))
+	for t, n := range typeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	if no, err := strconv.Atoi(s); err == nil && no > 0 {
+		return Type(no), nil
+	}
+	return 0, fmt.Errorf("unknown object type: %s", s)
+}
+
 const (
 	OTName        Type = 1
 	OTIP6Addr     Type = 2
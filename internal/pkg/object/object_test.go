@@ -216,6 +216,36 @@ func TestObjectString(t *testing.T) {
 	}
 }
 
+func TestParseType(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  Type
+		valid bool
+	}{
+		{"ip4", OTIP4Addr, true},
+		{"IP6", OTIP6Addr, true},
+		{":deleg:", OTDelegation, true},
+		{"redir", OTRedirection, true},
+		{"srv", OTServiceInfo, true},
+		{"8", OTServiceInfo, true},
+		{"13", OTNextKey, true},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"a", 0, false},
+	}
+	for i, test := range tests {
+		typ, err := ParseType(test.input)
+		if (err == nil) != test.valid || typ != test.want {
+			t.Errorf("%d: ParseType(%s)=(%v,%v), expected %v", i, test.input, typ, err, test.want)
+		}
+		if test.valid {
+			if back, err := ParseType(typ.Name()); err != nil || back != typ {
+				t.Errorf("%d: Name() of %v does not parse back, got %v", i, typ, back)
+			}
+		}
+	}
+}
+
 func TestObjectSort(t *testing.T) {
 	objTypes := []Type{OTNextKey, OTExtraKey, OTInfraKey, OTRegistrant, OTRegistrar, OTServiceInfo, OTCertInfo, OTNameset, OTDelegation, OTRedirection,
 		OTIP4Addr, OTIP6Addr, OTName}
@@ -0,0 +1,55 @@
+package probe
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+//sigPattern matches the signatures of a section in zonefile format.
+var sigPattern = regexp.MustCompile(`\s*\( :sig: [^)]*\)`)
+
+//AnswerLines returns the sections of msg in zonefile format without their signatures, such that
+//re-signed but otherwise identical sections compare equal. Notifications are represented by their
+//type and data only as their token differs between servers.
+func AnswerLines(msg message.Message) []string {
+	var lines []string
+	for _, s := range msg.Content {
+		if n, ok := s.(*section.Notification); ok {
+			lines = append(lines, fmt.Sprintf(":NO: %d %s", n.Type, n.Data))
+			continue
+		}
+		lines = append(lines, sigPattern.ReplaceAllString(zonefile.IO{}.EncodeSection(s), ""))
+	}
+	return lines
+}
+
+//DiffLines returns the sorted lines only contained in expected and the sorted lines only contained
+//in actual, ignoring their order and duplicates.
+func DiffLines(expected, actual []string) (missing, added []string) {
+	contains := func(lines []string) map[string]bool {
+		set := make(map[string]bool)
+		for _, l := range lines {
+			set[l] = true
+		}
+		return set
+	}
+	expectedSet, actualSet := contains(expected), contains(actual)
+	for l := range expectedSet {
+		if !actualSet[l] {
+			missing = append(missing, l)
+		}
+	}
+	for l := range actualSet {
+		if !expectedSet[l] {
+			added = append(added, l)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(added)
+	return missing, added
+}
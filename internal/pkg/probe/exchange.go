@@ -0,0 +1,47 @@
+package probe
+
+import (
+	"net"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//AnswerToken returns the token of the message answered by msg. A server reports errors in a
+//message with a token of its own containing a notification which carries the token of the
+//answered message.
+func AnswerToken(msg message.Message) token.Token {
+	if len(msg.Content) > 0 {
+		if n, ok := msg.Content[0].(*section.Notification); ok {
+			return n.Token
+		}
+	}
+	return msg.Token
+}
+
+//IsResponse returns true if msg is the response to the message with token tok.
+func IsResponse(msg message.Message, tok token.Token) bool {
+	return msg.Token == tok || AnswerToken(msg) == tok
+}
+
+//Exchange sends msg over conn and returns the response to it. Responses to other messages are
+//discarded. The exchange fails if it takes longer than timeout.
+func Exchange(conn net.Conn, reader cbor.Reader, writer cbor.Writer, msg message.Message,
+	timeout time.Duration) (message.Message, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := writer.Marshal(&msg); err != nil {
+		return message.Message{}, err
+	}
+	for {
+		var answer message.Message
+		if err := reader.Unmarshal(&answer); err != nil {
+			return message.Message{}, err
+		}
+		if IsResponse(answer, msg.Token) {
+			return answer, nil
+		}
+	}
+}
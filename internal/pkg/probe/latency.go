@@ -0,0 +1,62 @@
+//Package probe sends queries to a server, summarizes the round trip times of the answers and
+//compares answers for rainsdig, rainsbench, rainsreplay, rainsconform and the query mirror of
+//rainsd.
+package probe
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+//Latency summarizes round trip times in milliseconds.
+type Latency struct {
+	MinMs  float64 `json:"minMs"`
+	MeanMs float64 `json:"meanMs"`
+	P50Ms  float64 `json:"p50Ms"`
+	P90Ms  float64 `json:"p90Ms"`
+	P99Ms  float64 `json:"p99Ms"`
+	MaxMs  float64 `json:"maxMs"`
+}
+
+//NewLatency returns the latency summary of rtts. It sorts rtts.
+func NewLatency(rtts []time.Duration) Latency {
+	if len(rtts) == 0 {
+		return Latency{}
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	var sum time.Duration
+	for _, rtt := range rtts {
+		sum += rtt
+	}
+	return Latency{
+		MinMs:  ToMs(rtts[0]),
+		MeanMs: ToMs(sum / time.Duration(len(rtts))),
+		P50Ms:  ToMs(Percentile(rtts, 50)),
+		P90Ms:  ToMs(Percentile(rtts, 90)),
+		P99Ms:  ToMs(Percentile(rtts, 99)),
+		MaxMs:  ToMs(rtts[len(rtts)-1]),
+	}
+}
+
+//String returns the latency summary in milliseconds in the order min/mean/p50/p90/p99/max.
+func (l Latency) String() string {
+	return fmt.Sprintf("%.3f/%.3f/%.3f/%.3f/%.3f/%.3f ms", l.MinMs, l.MeanMs, l.P50Ms, l.P90Ms,
+		l.P99Ms, l.MaxMs)
+}
+
+//Percentile returns the p-th percentile of the sorted rtts using the nearest rank method. rtts
+//must not be empty.
+func Percentile(rtts []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(rtts))))
+	if rank < 1 {
+		rank = 1
+	}
+	return rtts[rank-1]
+}
+
+//ToMs returns d in milliseconds.
+func ToMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,119 @@
+package probe
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+func TestNewLatency(t *testing.T) {
+	var rtts []time.Duration
+	for i := 100; i > 0; i-- {
+		rtts = append(rtts, time.Duration(i)*time.Millisecond)
+	}
+	expected := Latency{MinMs: 1, MeanMs: 50.5, P50Ms: 50, P90Ms: 90, P99Ms: 99, MaxMs: 100}
+	if l := NewLatency(rtts); l != expected {
+		t.Errorf("wrong latency. expected=%v actual=%v", expected, l)
+	}
+	if l := NewLatency(nil); l != (Latency{}) {
+		t.Errorf("latency of no rtts is not empty: %v", l)
+	}
+	if p := Percentile([]time.Duration{time.Second}, 1); p != time.Second {
+		t.Errorf("wrong percentile of a single rtt: %v", p)
+	}
+}
+
+func TestIsResponse(t *testing.T) {
+	tok := token.New()
+	var tests = []struct {
+		msg  message.Message
+		want bool
+	}{
+		{message.Message{Token: tok}, true},
+		{message.Message{Token: token.New()}, false},
+		{message.Message{Token: token.New(), Content: []section.Section{
+			&section.Notification{Type: section.NTNoAssertionAvail, Token: tok}}}, true},
+		{message.Message{Token: token.New(), Content: []section.Section{
+			&section.Notification{Type: section.NTNoAssertionAvail, Token: token.New()}}}, false},
+		{message.Message{Token: tok, Content: []section.Section{
+			&section.Notification{Type: section.NTNoAssertionAvail, Token: token.New()}}}, true},
+	}
+	for i, test := range tests {
+		if got := IsResponse(test.msg, tok); got != test.want {
+			t.Errorf("%d: wrong result. expected=%t actual=%t", i, test.want, got)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	msg := message.Message{Token: token.New()}
+	notification := message.Message{Token: token.New(), Content: []section.Section{
+		&section.Notification{Type: section.NTNoAssertionAvail, Token: msg.Token}}}
+	go func() {
+		defer server.Close()
+		var received message.Message
+		if err := cbor.NewReader(server).Unmarshal(&received); err != nil {
+			return
+		}
+		writer := cbor.NewWriter(server)
+		other := message.Message{Token: token.New()}
+		writer.Marshal(&other)
+		writer.Marshal(&notification)
+	}()
+	answer, err := Exchange(client, cbor.NewReader(client), cbor.NewWriter(client), msg,
+		time.Second)
+	if err != nil || answer.Token != notification.Token {
+		t.Errorf("wrong response. expected=%v actual=%v error=%v", notification, answer, err)
+	}
+}
+
+func TestAnswerLines(t *testing.T) {
+	ip4 := object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}
+	signed := func(data byte) *section.Assertion {
+		a := &section.Assertion{SubjectName: "www", SubjectZone: "ethz.ch.", Context: ".",
+			Content: []object.Object{ip4}}
+		a.AddSig(signature.Sig{PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519},
+			ValidSince: 1000, ValidUntil: 2000, Data: []byte{data}})
+		return a
+	}
+	first := AnswerLines(message.Message{Token: token.New(), Content: []section.Section{
+		signed(1), &section.Notification{Type: section.NTNoAssertionAvail, Token: token.New()}}})
+	second := AnswerLines(message.Message{Token: token.New(), Content: []section.Section{
+		signed(2), &section.Notification{Type: section.NTNoAssertionAvail, Token: token.New()}}})
+	if len(first) != 2 || !reflect.DeepEqual(first, second) {
+		t.Errorf("answers differing in signatures and tokens only are not equal. first=%v "+
+			"second=%v", first, second)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	var tests = []struct {
+		expected []string
+		actual   []string
+		missing  []string
+		added    []string
+	}{
+		{nil, nil, nil, nil},
+		{[]string{"a", "b"}, []string{"b", "a", "a"}, nil, nil},
+		{[]string{"c", "a", "b"}, []string{"b", "e", "d"}, []string{"a", "c"}, []string{"d", "e"}},
+	}
+	for i, test := range tests {
+		missing, added := DiffLines(test.expected, test.actual)
+		if !reflect.DeepEqual(missing, test.missing) || !reflect.DeepEqual(added, test.added) {
+			t.Errorf("%d: wrong difference. expected=%v %v actual=%v %v", i, test.missing,
+				test.added, missing, added)
+		}
+	}
+}
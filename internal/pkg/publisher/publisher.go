@@ -1,16 +1,19 @@
 package publisher
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
+	"os"
 	"sort"
+	"strings"
 	"time"
 
-	"github.com/netsec-ethz/rains/internal/pkg/connection"
-
 	log "github.com/inconshreveable/log15"
 
+	"github.com/netsec-ethz/rains/internal/pkg/audit"
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/bitarray"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
@@ -25,37 +28,163 @@ import (
 //anything from just one step to the whole process of publishing information to the zone's
 //authoritative servers.
 type Rainspub struct {
-	Config Config
+	Config   Config
+	signer   Signer
+	dialer   Dialer
+	codec    codec.Codec
+	progress Progress
 }
 
-//New creates a Rainspub instance and returns a pointer to it.
-func New(config Config) *Rainspub {
-	return &Rainspub{
+//New creates a Rainspub instance configured by config and opts and returns a pointer to it. By
+//default, sections are signed with the private keys stored at Config.PrivateKeyPath and pushed
+//over TLS in CBOR encoded messages.
+func New(config Config, opts ...Option) *Rainspub {
+	r := &Rainspub{
 		Config: config,
+		dialer: TLSDialer{},
+		codec:  codec.CBOR{},
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
+	return r
 }
 
 //Publish performs various tasks of a zone's publishing process to rains servers according to its
-//configuration. This implementation assumes that there is exactly one zone per zonefile.
-func (r *Rainspub) Publish() {
+//configuration. This implementation assumes that there is exactly one zone per zonefile. If
+//Config.DryRun is set, no server is contacted and a report about the result is printed instead. If
+//Config.DelegationPath is set, nothing is published unless all signatures verify against the keys
+//delegated to the zone. If Config.SummaryPath is set, a summary of the run is stored there in json
+//format. It returns an error if any of the steps failed.
+func (r *Rainspub) Publish() error {
+	return r.PublishContext(context.Background())
+}
+
+//PublishContext is like Publish but stops as soon as ctx is done, in which case ctx's error is
+//returned.
+func (r *Rainspub) PublishContext(ctx context.Context) (err error) {
+	summary := &Summary{Start: time.Now().Unix()}
+	if r.Config.SummaryPath != "" {
+		defer func() {
+			summary.finish(err)
+			if err := summary.Store(r.Config.SummaryPath); err != nil {
+				log.Error("Was not able to store summary", "path", r.Config.SummaryPath, "error", err)
+			}
+		}()
+	}
+	zone, shards, pshards, err := r.prepare(ctx, summary)
+	if err != nil {
+		return err
+	}
+	output := outputSections(zone, shards, pshards)
+	if r.Config.SummaryPath != "" {
+		summary.addSectionStats(zone, shards, pshards, r.Config.DoSigning)
+		summary.Bytes = wireSize(output)
+	}
+	if r.Config.OutputPath != "" {
+		encoder := zonefile.IO{}
+		if err := encoder.EncodeAndStore(r.Config.OutputPath, output); err != nil {
+			log.Error(err.Error())
+			return err
+		}
+		log.Info("Writing updated zonefile to disk completed successfully")
+	}
+	if r.Config.DryRun {
+		report := dryRunReport(zone, shards, pshards, r.Config.DelegationPath)
+		report.Print(os.Stdout)
+		if !report.Verified && !report.VerifySkipped {
+			return errors.New("dry run verification failed: " + report.VerifyError)
+		}
+		return nil
+	}
+	if r.Config.DelegationPath != "" {
+		if err := verifyZoneContent(zone, shards, pshards, r.Config.DelegationPath); err != nil {
+			log.Error("Signatures do not verify against the delegated keys", "error", err)
+			return err
+		}
+	}
+	config := r.Config
+	if config.DoPublish && config.DiscoveryConf.DoDiscovery {
+		discovered, err := discoverAuthServers(ctx, zone.SubjectZone, zone.Context,
+			newDiscoveryResolver(config.DiscoveryConf))
+		if err != nil {
+			log.Error("Was not able to discover authoritative servers", "error", err)
+			if len(config.AuthServers) == 0 {
+				return err
+			}
+		}
+		config.AuthServers = mergeAuthServers(config.AuthServers, discovered)
+	}
+	pushed := output
+	if config.DoPublish && config.DiffPush {
+		if previous, ok := loadPushState(config.DiffStatePath); !ok {
+			log.Info("No state of a previous push available, pushing the whole zone",
+				"path", config.DiffStatePath)
+		} else if delta, ok := deltaSections(previous, output); ok {
+			pushed = delta
+		}
+	}
+	results, err := r.publishZone(ctx, pushed, config)
+	summary.addPushResults(results)
+	if err == nil && config.DoPublish && config.DiffStatePath != "" {
+		if err := storePushState(config.DiffStatePath, output); err != nil {
+			log.Error("Was not able to store state of the push", "path", config.DiffStatePath,
+				"error", err)
+			return err
+		}
+	}
+	return err
+}
+
+//Sign loads the zonefile and shards, sorts and signs its content according to the config like
+//Publish. It returns the zone followed by its shards and pshards without storing or pushing them.
+//If Config.DelegationPath is set, an error is returned unless all signatures verify against the
+//public keys delegated to the zone.
+func (r *Rainspub) Sign(ctx context.Context) ([]section.Section, error) {
+	zone, shards, pshards, err := r.prepare(ctx, &Summary{})
+	if err != nil {
+		return nil, err
+	}
+	if r.Config.DelegationPath != "" {
+		if err := verifyZoneContent(zone, shards, pshards, r.Config.DelegationPath); err != nil {
+			return nil, err
+		}
+	}
+	return outputSections(zone, shards, pshards), nil
+}
+
+//prepare loads the zonefile and returns the zone, shards and pshards after sharding, sorting and
+//signing them according to the config. It records the zone and the signing time in summary.
+func (r *Rainspub) prepare(ctx context.Context, summary *Summary) (zone *section.Zone,
+	shards []*section.Shard, pshards []*section.Pshard, err error) {
+	if err := r.Config.validate(r.signer == nil); err != nil {
+		log.Error(err.Error())
+		return nil, nil, nil, err
+	}
 	encoder := zonefile.IO{}
-	zoneContent, err := encoder.LoadZonefile(r.Config.ZonefilePath)
+	var zoneContent []section.WithSigForward
+	if r.Config.DNSSECConf.DoBridging {
+		zoneContent, err = LoadDNSZone(r.Config.ZonefilePath, r.Config.DNSSECConf)
+	} else {
+		zoneContent, err = encoder.LoadZonefile(r.Config.ZonefilePath)
+	}
 	if err != nil {
 		log.Error(err.Error())
-		return
+		return nil, nil, nil, err
 	}
 	log.Info("Zonefile successful loaded")
-	zone, shards, pshards, err := splitZoneContent(zoneContent,
+	zone, shards, pshards, err = splitZoneContent(zoneContent,
 		!r.Config.ShardingConf.IncludeShards, !r.Config.PShardingConf.IncludePshards)
 	if err != nil {
 		log.Error(err.Error())
-		return
+		return nil, nil, nil, err
 	}
+	summary.Zone, summary.Context = zone.SubjectZone, zone.Context
 	if r.Config.ShardingConf.DoSharding {
 		if shards, err = DoSharding(zone.SubjectZone, zone.Context, zone.Content, shards,
 			r.Config.ShardingConf, r.Config.ConsistencyConf.SortShards); err != nil {
 			log.Error(err.Error())
-			return
+			return nil, nil, nil, err
 		}
 	}
 	if r.Config.PShardingConf.DoPsharding {
@@ -63,7 +192,7 @@ func (r *Rainspub) Publish() {
 			r.Config.PShardingConf,
 			!r.Config.ShardingConf.IncludeShards && r.Config.ConsistencyConf.SortShards); err != nil {
 			log.Error(err.Error())
-			return
+			return nil, nil, nil, err
 		}
 	}
 	if r.Config.ConsistencyConf.SortZone {
@@ -73,15 +202,40 @@ func (r *Rainspub) Publish() {
 		addSignatureMetaData(zone, shards, pshards, r.Config.MetaDataConf)
 	}
 	if !isConsistent(zone, shards, pshards, r.Config.ConsistencyConf) {
-		return
+		return nil, nil, nil, errors.New("zone content is not consistent")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, err
 	}
 	if r.Config.DoSigning {
-		if err := signZoneContent(zone, shards, pshards, r.Config.PrivateKeyPath); err != nil {
+		start := time.Now()
+		signer := r.signer
+		if signer == nil {
+			if signer, err = NewKeySigner(r.Config.PrivateKeyPath); err != nil {
+				log.Error(err.Error())
+				return nil, nil, nil, err
+			}
+		}
+		if err := r.signZoneContent(ctx, zone, shards, pshards, signer); err != nil {
 			log.Error(err.Error())
-			return
+			return nil, nil, nil, err
 		}
+		summary.SigningTimeMs = time.Since(start).Nanoseconds() / int64(time.Millisecond)
 		log.Info("Signing completed successfully")
+		if r.Config.AuditLogPath != "" {
+			if err := auditSigning(r.Config.AuditLogPath, zone, shards, pshards); err != nil {
+				log.Error("Was not able to write audit record", "path", r.Config.AuditLogPath,
+					"error", err)
+				return nil, nil, nil, err
+			}
+		}
 	}
+	return zone, shards, pshards, nil
+}
+
+//outputSections returns zone followed by shards and pshards.
+func outputSections(zone *section.Zone, shards []*section.Shard,
+	pshards []*section.Pshard) []section.Section {
 	output := []section.Section{zone}
 	for _, shard := range shards {
 		output = append(output, shard)
@@ -89,14 +243,7 @@ func (r *Rainspub) Publish() {
 	for _, pshard := range pshards {
 		output = append(output, pshard)
 	}
-	if r.Config.OutputPath != "" {
-		if err := encoder.EncodeAndStore(r.Config.OutputPath, output); err != nil {
-			log.Error(err.Error())
-			return
-		}
-		log.Info("Writing updated zonefile to disk completed successfully")
-	}
-	r.publishZone(output, r.Config)
+	return output
 }
 
 //splitZoneContent returns assertions, pshards and shards contained in zone as three separate
@@ -147,6 +294,9 @@ func DoSharding(zone, ctx string, assertions []*section.Assertion, shards []*sec
 	} else {
 		return nil, errors.New("MaxShardSize or NofAssertionsPerShard must be positive when DoSharding is set")
 	}
+	for _, shard := range newShards {
+		shard.SubjectZone, shard.Context = zone, ctx
+	}
 	if len(shards) != 0 {
 		shards = append(shards, newShards...)
 		sort.Slice(shards, func(i, j int) bool { return shards[i].CompareTo(shards[j]) < 0 })
@@ -411,22 +561,64 @@ func doConsistencyCheck(section section.WithSigForward, config ConsistencyConfig
 	return true
 }
 
-func signZoneContent(zone *section.Zone, shards []*section.Shard, pshards []*section.Pshard,
-	keyPath string) error {
-	keys, err := LoadPrivateKeys(keyPath)
-	if err != nil {
-		return errors.New("Was not able to load private keys")
-	}
-	if err := signZone(zone, keys); err != nil {
+//signZoneContent signs the zone, shards, pshards and all contained assertions with signer. It
+//reports each signed shard to the progress callback and stops if ctx is done.
+func (r *Rainspub) signZoneContent(ctx context.Context, zone *section.Zone,
+	shards []*section.Shard, pshards []*section.Pshard, signer Signer) error {
+	if err := signZone(zone, signer); err != nil {
 		return err
 	}
-	for _, shard := range shards {
-		if err := signShard(shard, keys); err != nil {
+	for i, shard := range shards {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
+		if err := signShard(shard, signer); err != nil {
+			return err
+		}
+		if r.progress.ShardSigned != nil {
+			r.progress.ShardSigned(shard, i+1, len(shards))
+		}
 	}
 	for _, pshard := range pshards {
-		if err := signSection(pshard, keys); err != nil {
+		if err := signSection(pshard, signer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//auditSigning appends for each key used to sign the zone, shards, pshards and contained
+//assertions a record with the number of sections it signed to the audit log at path.
+func auditSigning(path string, zone *section.Zone, shards []*section.Shard,
+	pshards []*section.Pshard) error {
+	sigSections := []section.WithSig{zone}
+	for _, a := range zone.Content {
+		sigSections = append(sigSections, a)
+	}
+	for _, shard := range shards {
+		sigSections = append(sigSections, shard)
+		for _, a := range shard.Content {
+			sigSections = append(sigSections, a)
+		}
+	}
+	for _, pshard := range pshards {
+		sigSections = append(sigSections, pshard)
+	}
+	usage := make(map[keys.PublicKeyID]int)
+	for _, s := range sigSections {
+		for _, sig := range s.AllSigs() {
+			usage[sig.PublicKeyID]++
+		}
+	}
+	auditLog := audit.New(path)
+	for id, count := range usage {
+		if err := auditLog.Append(audit.Record{
+			Operation: "sign",
+			KeyID:     id.String(),
+			Zone:      zone.SubjectZone,
+			Context:   zone.Context,
+			Sections:  count,
+		}); err != nil {
 			return err
 		}
 	}
@@ -434,39 +626,138 @@ func signZoneContent(zone *section.Zone, shards []*section.Shard, pshards []*sec
 }
 
 //publishZone publishes the zone's content either to the specified authoritative servers or to a
-//file in zonefile format.
-func (r *Rainspub) publishZone(zoneContent []section.Section, config Config) {
-	if config.DoPublish {
-		//TODO check if zone is not too large. If it is, split it up and send
-		//content separately.
-		log.Debug("published zone", "zone", zoneContent)
-		msg := message.Message{
-			Token:        token.New(),
-			Content:      zoneContent,
-			Capabilities: []message.Capability{message.NoCapability},
+//file in zonefile format. Each message must be acknowledged within config.PushAckTimeout. Servers
+//to which the push failed are retried config.PushRetries times with exponential backoff starting
+//at config.PushBackoff. It returns an error if the push to at
+//least one server ultimately failed or ctx is done. The outcome of the push is returned per server.
+//The content is split into several messages if it exceeds config.MaxMessageSize.
+func (r *Rainspub) publishZone(ctx context.Context, zoneContent []section.Section, config Config) (
+	map[string]pushResult, error) {
+	if !config.DoPublish {
+		return nil, nil
+	}
+	log.Debug("published zone", "zone", zoneContent)
+	chunks, err := splitSections(zoneContent, config.MaxMessageSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) > 1 {
+		log.Info("Zone content is split into several messages", "messages", len(chunks),
+			"maxMessageSize", config.MaxMessageSize)
+	}
+	servers := []net.Addr{}
+	for _, info := range config.AuthServers {
+		servers = append(servers, info.Addr)
+	}
+	results := make(map[string]pushResult)
+	backoff := config.PushBackoff
+	ackTimeout := config.PushAckTimeout
+	if ackTimeout == 0 {
+		ackTimeout = defaultPushAckTimeout
+	}
+	for attempt := 0; attempt <= config.PushRetries && len(servers) > 0; attempt++ {
+		if attempt > 0 {
+			log.Info("Retry pushing to servers", "attempt", attempt, "servers", servers,
+				"backoff", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return results, ctx.Err()
+			}
+			backoff *= 2
 		}
-		unreachableServers := publishSections(msg, config.AuthServers)
-		if unreachableServers != nil {
-			log.Warn("Was not able to connect to all authoritative servers", "unreachableServers", unreachableServers)
+		var failed []net.Addr
+		for _, result := range publishSections(ctx, chunks, servers, r.dialer, r.codec,
+			ackTimeout) {
+			result.Attempts = attempt + 1
+			results[result.Server.String()] = result
+			if r.progress.ServerPushed != nil {
+				r.progress.ServerPushed(result.Server, result.Attempts, result.Err)
+			}
+			if result.Err != nil {
+				failed = append(failed, result.Server)
+			}
+		}
+		servers = failed
+	}
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	return results, pushSummary(results)
+}
+
+//pushSummary logs the outcome of the push per server. It returns an error if the push to at least
+//one server failed.
+func pushSummary(results map[string]pushResult) error {
+	var failed []string
+	for server, result := range results {
+		if result.Err != nil {
+			log.Error("Publishing to server failed", "server", server, "attempts", result.Attempts,
+				"error", result.Err)
+			failed = append(failed, server)
 		} else {
-			log.Info("publishing to server completed successfully")
+			log.Info("Publishing to server completed successfully", "server", server,
+				"attempts", result.Attempts)
 		}
 	}
+	if len(failed) != 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("publishing failed for servers: %s", strings.Join(failed, ", "))
+	}
+	return nil
 }
 
-//publishSections establishes connections to all authoritative servers according to the r.Config. It
-//then sends sections to all of them. It returns the connection information of those servers it was
-//not able to push sections, otherwise nil is returned.
-func publishSections(msg message.Message, authServers []connection.Info) []net.Addr {
-	var errorConns []net.Addr
-	results := make(chan net.Addr, len(authServers))
-	for _, info := range authServers {
-		go connectAndSendMsg(msg, info.Addr, results)
+//publishSections sends the chunks encoded with c to all servers concurrently. Each message must be
+//acknowledged within ackTimeout. It returns the outcome of the push per server.
+func publishSections(ctx context.Context, chunks [][]section.Section, servers []net.Addr,
+	dialer Dialer, c codec.Codec, ackTimeout time.Duration) []pushResult {
+	var output []pushResult
+	results := make(chan pushResult, len(servers))
+	for _, server := range servers {
+		go func(server net.Addr) {
+			results <- pushChunks(ctx, chunks, server, dialer, c, ackTimeout)
+		}(server)
+	}
+	for i := 0; i < len(servers); i++ {
+		output = append(output, <-results)
 	}
-	for i := 0; i < len(authServers); i++ {
-		if errorConn := <-results; errorConn != nil {
-			errorConns = append(errorConns, errorConn)
+	return output
+}
+
+//pushChunks sends each chunk in a separate message to server. If the server responds that a
+//message is too large, the chunk is split such that each part is at most half as large and the
+//parts are sent instead. The push fails as soon as one chunk cannot be delivered.
+func pushChunks(ctx context.Context, chunks [][]section.Section, server net.Addr,
+	dialer Dialer, c codec.Codec, ackTimeout time.Duration) pushResult {
+	output := pushResult{Server: server}
+	result := make(chan pushResult, 1)
+	for len(chunks) > 0 {
+		//A fresh token per message such that late notifications of a previous message are not
+		//attributed to this one.
+		msg := message.Message{
+			Token:        token.New(),
+			Content:      chunks[0],
+			Capabilities: []message.Capability{message.NoCapability},
+		}
+		connectAndSendMsg(ctx, msg, server, dialer, c, ackTimeout, result)
+		r := <-result
+		output.Latency += r.Latency
+		if r.Err == errMsgTooLarge {
+			maxSize := wireSize(chunks[0]) / 2
+			log.Info("Splitting rejected message", "server", server, "maxMessageSize", maxSize)
+			parts, err := splitSections(chunks[0], maxSize)
+			if err != nil {
+				output.Err = err
+				return output
+			}
+			chunks = append(parts, chunks[1:]...)
+			continue
+		}
+		if r.Err != nil {
+			output.Err = r.Err
+			return output
 		}
+		chunks = chunks[1:]
 	}
-	return errorConns
+	return output
 }
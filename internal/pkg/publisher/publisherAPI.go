@@ -0,0 +1,129 @@
+package publisher
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+)
+
+//Signer computes the signature data of sig over s and adds the signature to s. Implementations
+//can keep the private keys outside of the publisher's process, e.g. in a hardware security module.
+type Signer interface {
+	Sign(s section.WithSigForward, sig signature.Sig) error
+}
+
+//Dialer establishes a connection to an authoritative server over which the zone's content is
+//pushed.
+type Dialer interface {
+	DialContext(ctx context.Context, server net.Addr) (net.Conn, error)
+}
+
+//Progress contains callbacks which are invoked while publishing. Callbacks which are nil are not
+//invoked.
+type Progress struct {
+	//ShardSigned is called after shard and all contained assertions have been signed. done is the
+	//number of signed shards so far out of total.
+	ShardSigned func(shard *section.Shard, done, total int)
+	//ServerPushed is called after each push attempt to server. err is nil if the push succeeded.
+	ServerPushed func(server net.Addr, attempt int, err error)
+}
+
+//Option configures optional behavior of a Rainspub instance.
+type Option func(*Rainspub)
+
+//WithSigner returns an option which makes the publisher sign with signer instead of the private
+//keys stored at Config.PrivateKeyPath.
+func WithSigner(signer Signer) Option {
+	return func(r *Rainspub) {
+		r.signer = signer
+	}
+}
+
+//WithDialer returns an option which makes the publisher connect to the authoritative servers with
+//dialer instead of a TLSDialer.
+func WithDialer(dialer Dialer) Option {
+	return func(r *Rainspub) {
+		r.dialer = dialer
+	}
+}
+
+//WithCodec returns an option which makes the publisher encode the messages pushed to the
+//authoritative servers with c instead of CBOR.
+func WithCodec(c codec.Codec) Option {
+	return func(r *Rainspub) {
+		r.codec = c
+	}
+}
+
+//WithProgress returns an option which makes the publisher report its progress to progress.
+func WithProgress(progress Progress) Option {
+	return func(r *Rainspub) {
+		r.progress = progress
+	}
+}
+
+//KeySigner signs sections with private keys held in memory.
+type KeySigner struct {
+	keys map[keys.PublicKeyID]interface{}
+}
+
+//NewKeySigner returns a signer using the private keys stored at path. See LoadPrivateKeys for the
+//supported formats.
+func NewKeySigner(path string) (*KeySigner, error) {
+	privateKeys, err := LoadPrivateKeys(path)
+	if err != nil {
+		return nil, errors.New("Was not able to load private keys")
+	}
+	return &KeySigner{keys: privateKeys}, nil
+}
+
+//Sign signs s with the private key corresponding to sig's public key identifier.
+func (k *KeySigner) Sign(s section.WithSigForward, sig signature.Sig) error {
+	key, ok := k.keys[sig.PublicKeyID]
+	if !ok {
+		return errors.New("no private key for " + sig.PublicKeyID.String())
+	}
+	if !siglib.SignSectionUnsafe(s, key, sig) {
+		return errors.New("Was not able to sign and add the signature")
+	}
+	return nil
+}
+
+//TLSDialer establishes TLS connections over TCP. If Config is nil, the server's certificate is
+//not verified.
+type TLSDialer struct {
+	Config *tls.Config
+}
+
+//DialContext connects to server. It returns an error if server is not a TCP address. Both the TCP
+//connection and the TLS handshake are aborted when ctx is done.
+func (d TLSDialer) DialContext(ctx context.Context, server net.Addr) (net.Conn, error) {
+	addr, ok := server.(*net.TCPAddr)
+	if !ok {
+		return nil, errors.New("unsupported connection information type")
+	}
+	conf := d.Config
+	if conf == nil {
+		conf = &tls.Config{InsecureSkipVerify: true}
+	} else if conf.ServerName == "" {
+		conf = conf.Clone()
+		conf.ServerName = addr.IP.String()
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, server.Network(), server.String())
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, conf)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
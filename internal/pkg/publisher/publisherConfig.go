@@ -1,6 +1,8 @@
 package publisher
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/netsec-ethz/rains/internal/pkg/connection"
@@ -15,14 +17,92 @@ type Config struct {
 	ZonefilePath    string
 	AuthServers     []connection.Info
 	PrivateKeyPath  string
+	DelegationPath  string
 	ShardingConf    ShardingConfig
 	PShardingConf   PShardingConfig
 	MetaDataConf    MetaDataConfig
 	ConsistencyConf ConsistencyConfig
 	DoSigning       bool
 	MaxZoneSize     int
+	MaxMessageSize  int
 	OutputPath      string
 	DoPublish       bool
+	DiscoveryConf   DiscoveryConfig
+	PushRetries     int
+	PushBackoff     time.Duration
+	PushAckTimeout  time.Duration
+	DryRun          bool
+	SummaryPath     string
+	AuditLogPath    string
+	DiffPush        bool
+	DiffStatePath   string
+	DNSSECConf      DNSSECConfig
+}
+
+//Validate returns an error describing all invalid or contradicting options of c. It returns nil
+//if c is valid.
+func (c Config) Validate() error {
+	return c.validate(true)
+}
+
+//validate is like Validate. If needKeys is false, PrivateKeyPath is not required for signing, e.g.
+//because a custom Signer is used.
+func (c Config) validate(needKeys bool) error {
+	var problems []string
+	if c.ZonefilePath == "" {
+		problems = append(problems, "ZonefilePath must be set")
+	}
+	if needKeys && c.DoSigning && c.PrivateKeyPath == "" {
+		problems = append(problems, "PrivateKeyPath must be set when DoSigning is set")
+	}
+	if c.DryRun && c.DelegationPath == "" {
+		problems = append(problems, "DelegationPath must be set when DryRun is set")
+	}
+	if c.ShardingConf.DoSharding && c.ShardingConf.MaxShardSize <= 0 &&
+		c.ShardingConf.NofAssertionsPerShard <= 0 {
+		problems = append(problems,
+			"MaxShardSize or NofAssertionsPerShard must be positive when DoSharding is set")
+	}
+	if c.PShardingConf.DoPsharding {
+		if c.PShardingConf.NofAssertionsPerPshard <= 0 {
+			problems = append(problems,
+				"NofAssertionsPerPshard must be positive when DoPsharding is set")
+		}
+		if c.PShardingConf.BloomFilterConf.BloomFilterSize <= 0 {
+			problems = append(problems, "BloomFilterSize must be positive when DoPsharding is set")
+		}
+	}
+	if c.MetaDataConf.AddSignatureMetaData &&
+		c.MetaDataConf.SigValidUntil <= c.MetaDataConf.SigValidSince {
+		problems = append(problems, "SigValidUntil must be larger than SigValidSince")
+	}
+	if c.DoPublish && len(c.AuthServers) == 0 && !c.DiscoveryConf.DoDiscovery {
+		problems = append(problems, "AuthServers must not be empty when DoPublish is set")
+	}
+	if c.DiscoveryConf.DoDiscovery && len(c.DiscoveryConf.RootServers) == 0 &&
+		len(c.DiscoveryConf.Forwarders) == 0 {
+		problems = append(problems, "RootServers or Forwarders must be set when DoDiscovery is set")
+	}
+	if c.MaxMessageSize < 0 {
+		problems = append(problems, "MaxMessageSize must not be negative")
+	}
+	if c.DiffPush && c.DiffStatePath == "" {
+		problems = append(problems, "DiffStatePath must be set when DiffPush is set")
+	}
+	if c.DNSSECConf.DoBridging && c.DNSSECConf.Origin == "" {
+		problems = append(problems, "Origin must be set when DoBridging is set")
+	}
+	if c.DNSSECConf.VerifyChain && len(c.DNSSECConf.TrustAnchors) == 0 {
+		problems = append(problems, "TrustAnchors must not be empty when VerifyChain is set")
+	}
+	if c.PushRetries < 0 || c.PushBackoff < 0 || c.PushAckTimeout < 0 {
+		problems = append(problems,
+			"PushRetries, PushBackoff and PushAckTimeout must not be negative")
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("invalid publisher config: %s", strings.Join(problems, "; "))
+	}
+	return nil
 }
 
 //ShardingConfig contains configuration options on how to split a zone into shards.
@@ -62,6 +142,30 @@ type MetaDataConfig struct {
 	SigSigningInterval         time.Duration
 }
 
+//DiscoveryConfig determines if and how the zone's authoritative servers are discovered through
+//the naming system in addition to the statically configured AuthServers.
+type DiscoveryConfig struct {
+	DoDiscovery bool
+	RootServers []connection.Info
+	Forwarders  []connection.Info
+}
+
+//DNSSECConfig determines whether the zonefile is a DNS zone in master file format and how its
+//content is mapped to rains. Only Ed25519 keys of child zones can be mapped to delegations.
+type DNSSECConfig struct {
+	DoBridging bool
+	//Origin is the apex of the DNS zone. It becomes the rains zone's name.
+	Origin  string
+	Context string
+	//KeysetPaths are files in master file format containing the DNSKEY records of child zones.
+	KeysetPaths []string
+	VerifyChain bool
+	//TrustAnchors are DS records of the zone in presentation format as published by the parent.
+	TrustAnchors       []string
+	NSServicePort      int
+	DelegationKeyPhase int
+}
+
 //ConsistencyConfig determines which consistency checks are performed prior to signing.
 type ConsistencyConfig struct {
 	DoConsistencyCheck bool
@@ -1,7 +1,9 @@
 package publisher
 
 import (
-	"crypto/tls"
+	"context"
+	"errors"
+	"io"
 	"net"
 	"strings"
 	"time"
@@ -9,126 +11,183 @@ import (
 	log "github.com/inconshreveable/log15"
 
 	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/token"
 )
 
-//connectAndSendMsg establishes a connection to server and sends msg. It returns the server info on
-//the result channel if it was not able to send the whole msg to it, else nil.
-func connectAndSendMsg(msg message.Message, server net.Addr, result chan<- net.Addr) {
-	conf := &tls.Config{
-		InsecureSkipVerify: true,
-	}
-	switch server.(type) {
-	case *net.TCPAddr:
-		conn, err := tls.Dial(server.Network(), server.String(), conf)
-		if err != nil {
-			log.Error("Was not able to establish a connection.", "server", server, "error", err)
-			result <- server
-			return
-		}
-		success := make(chan bool)
-		go listen(conn, msg.Token, success)
-		writer := cbor.NewWriter(conn)
-		if err := writer.Marshal(&msg); err != nil {
-			conn.Close()
-			log.Error("Was not able to frame the message.", "msg", msg, "server", server, "error", err)
-			result <- server
-			return
-		}
+var (
+	//errNotification is returned if the server responded with an error notification.
+	errNotification = errors.New("server responded with an error notification")
+	//errMsgTooLarge is returned if the server responded that the message exceeds its maximum
+	//message size.
+	errMsgTooLarge = errors.New("server responded that the message is too large")
+	//errNoResponse is returned if the server neither acknowledged the message nor responded with
+	//an error notification. The push is retried as the message might not have been processed.
+	errNoResponse = errors.New("server did not respond to the message")
+)
+
+//defaultPushAckTimeout is the time a server is given to acknowledge a pushed message if
+//Config.PushAckTimeout is not set.
+const defaultPushAckTimeout = time.Second
 
-		if <-success {
+//pushResult contains the outcome of pushing a message to a server.
+type pushResult struct {
+	Server   net.Addr
+	Attempts int
+	//Latency is the time it took to establish the connection and to send the message.
+	Latency time.Duration
+	Err     error
+}
+
+//connectAndSendMsg establishes a connection to server with dialer and sends msg encoded with c.
+//It returns the outcome on the result channel. The push is considered successful if the whole msg
+//has been sent, the server acknowledged it and the server did not respond with an error
+//notification for msg's token within ackTimeout after msg has been sent. If the server did not
+//respond at all, errNoResponse is returned.
+func connectAndSendMsg(ctx context.Context, msg message.Message, server net.Addr, dialer Dialer,
+	c codec.Codec, ackTimeout time.Duration, result chan<- pushResult) {
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, server)
+	if err != nil {
+		log.Error("Was not able to establish a connection.", "server", server, "error", err)
+		result <- pushResult{Server: server, Err: err}
+		return
+	}
+	success := make(chan error, 1)
+	sent := make(chan struct{})
+	go listen(conn, msg.Token, sent, ackTimeout, success)
+	if err := c.Encode(conn, &msg); err != nil {
+		conn.Close()
+		log.Error("Was not able to frame the message.", "msg", msg, "server", server, "error", err)
+		result <- pushResult{Server: server, Err: err}
+		return
+	}
+	close(sent)
+	latency := time.Since(start)
+	select {
+	case err := <-success:
+		if err == nil {
 			log.Debug("Successful published information.", "serverAddresses", server.String())
-			result <- nil
-		} else {
-			result <- server
 		}
-	default:
-		log.Error("Unsupported connection information type.", "conn", server)
-		result <- server
+		result <- pushResult{Server: server, Latency: latency, Err: err}
+	case <-ctx.Done():
+		conn.Close()
+		result <- pushResult{Server: server, Latency: latency, Err: ctx.Err()}
 	}
 }
 
-//listen receives incoming messages for one second. If the message's token matches the query's
-//token, it handles the response.
-func listen(conn net.Conn, token token.Token, success chan<- bool) {
-	//close connection after 1 second assuming everything went well
-	deadline := make(chan bool)
-	result := make(chan bool)
-	go func() {
-		time.Sleep(time.Second)
-		deadline <- true
-	}()
-	go waitForResponse(conn, token, result)
-	for true {
+//listen receives incoming messages until timeout has passed since sent has been closed and reports
+//the outcome of the push of the message with token on success. It reports nil if the server
+//acknowledged the message and did not respond with an error notification, errNoResponse if the
+//server did not respond or closed the connection and the first error notification otherwise.
+func listen(conn net.Conn, token token.Token, sent <-chan struct{}, timeout time.Duration,
+	success chan<- error) {
+	var deadline <-chan time.Time
+	done := make(chan struct{})
+	defer close(done)
+	defer conn.Close()
+	responses := make(chan error)
+	go waitForResponse(conn, token, responses, done)
+	acknowledged := false
+	for {
 		select {
+		case <-sent:
+			deadline = time.After(timeout)
+			sent = nil
+			continue
+		case err, ok := <-responses:
+			if ok && err != nil {
+				success <- err
+				return
+			}
+			if ok {
+				acknowledged = true
+				continue
+			}
 		case <-deadline:
-			conn.Close()
-			success <- true
+		}
+		if !acknowledged {
+			success <- errNoResponse
 			return
-		case err := <-result:
-			if err {
-				success <- false
-			} else {
-				go waitForResponse(conn, token, result)
-			}
 		}
+		success <- nil
+		return
 	}
 }
 
-func waitForResponse(conn net.Conn, token token.Token, serverError chan<- bool) {
-	reader := cbor.NewReader(conn)
-	var msg message.Message
-	if err := reader.Unmarshal(&msg); err != nil {
-		errs := strings.Split(err.Error(), ": ")
-		if errs[len(errs)-1] == "use of closed network connection" {
-			log.Info("Connection has been closed", "conn", conn.RemoteAddr())
-		} else {
-			log.Warn("Was not able to decode received message", "error", err)
-		}
-		serverError <- false
-		return
-	}
-	//Rainspub only accepts notification messages in response to published information.
-	if n, ok := msg.Content[0].(*section.Notification); ok && n.Token == token {
-		if handleResponse(conn, msg.Content[0].(*section.Notification)) {
-			conn.Close()
-			serverError <- true
+//waitForResponse reads the messages received on conn and sends the outcome of each notification
+//answering the message with token to responses. It closes responses when the connection has been
+//closed and returns when done is closed.
+func waitForResponse(conn net.Conn, token token.Token, responses chan<- error,
+	done <-chan struct{}) {
+	defer close(responses)
+	reader := codec.NewReader(conn, cbor.Limits{})
+	for {
+		msg, err := reader.Read()
+		if err != nil {
+			errs := strings.Split(err.Error(), ": ")
+			//The latter is reported by in-memory connections.
+			if last := errs[len(errs)-1]; last == "use of closed network connection" ||
+				last == "read/write on closed pipe" || err == io.EOF {
+				log.Info("Connection has been closed", "conn", conn.RemoteAddr())
+			} else {
+				log.Warn("Was not able to decode received message", "error", err)
+			}
 			return
 		}
-		serverError <- false
-		return
+		//Rainspub only accepts notification messages in response to published information.
+		for _, sec := range msg.Content {
+			n, ok := sec.(*section.Notification)
+			if !ok || n.Token != token {
+				log.Debug("Received section is not a notification for the sent message",
+					"messageToken", token, "section", sec)
+				continue
+			}
+			select {
+			case responses <- handleResponse(conn, n):
+			case <-done:
+				return
+			}
+		}
 	}
-	//TODO CFE do we need the token?
-	log.Debug("Token of sent message does not match the token of the received message",
-		"messageToken", token, "recvToken", msg.Token)
 }
 
-//handleResponse handles the received notification message and returns true if the connection can
-//be closed.
-func handleResponse(conn net.Conn, n *section.Notification) bool {
+//handleResponse handles the received notification message and returns an error if the
+//notification reports one. In this case the push has failed and the connection can be closed.
+func handleResponse(conn net.Conn, n *section.Notification) error {
 	switch n.Type {
-	case section.NTHeartbeat, section.NTNoAssertionsExist, section.NTNoAssertionAvail:
+	case section.NTHeartbeat, section.NTStaleAnswer, section.NTTokenTrace,
+		section.NTNoAssertionsExist,
+		section.NTNoAssertionAvail:
 	//nop
 	case section.NTCapHashNotKnown:
 	//TODO CFE send back the whole capability list in an empty message
 	case section.NTBadMessage:
 		log.Error("Sent msg was malformed", "data", n.Data)
+		return errNotification
+	case section.NTAccessDenied:
+		log.Error("Other server does not accept the sections from this address", "data", n.Data)
+		return errNotification
 	case section.NTRcvInconsistentMsg:
 		log.Error("Sent msg was inconsistent", "data", n.Data)
+		return errNotification
 	case section.NTMsgTooLarge:
-		log.Error("Sent msg was too large", "data", n.Data)
-		//What should we do in this case. apparently it is not possible to send a zone because
-		//it is too large. send shards instead?
+		log.Warn("Sent msg was too large", "data", n.Data)
+		return errMsgTooLarge
+	case section.NTRateLimited:
+		log.Warn("Other server limited the rate of responses", "data", n.Data)
+		return errNotification
 	case section.NTUnspecServerErr:
 		log.Error("Unspecified error of other server", "data", n.Data)
-		//TODO CFE resend?
+		return errNotification
 	case section.NTServerNotCapable:
 		log.Error("Other server was not capable", "data", n.Data)
 		//TODO CFE when can this occur?
+		return errNotification
 	default:
 		log.Error("Received non existing notification type")
 	}
-	return false
+	return nil
 }
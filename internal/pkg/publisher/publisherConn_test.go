@@ -0,0 +1,77 @@
+package publisher
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+func TestListen(t *testing.T) {
+	tok := token.New()
+	var tests = []struct {
+		responses []section.NotificationType
+		closed    bool
+		want      error
+	}{
+		{nil, false, errNoResponse},
+		{nil, true, errNoResponse},
+		{[]section.NotificationType{section.NTHeartbeat}, false, nil},
+		{[]section.NotificationType{section.NTHeartbeat}, true, nil},
+		{[]section.NotificationType{section.NTHeartbeat, section.NTRcvInconsistentMsg}, false,
+			errNotification},
+		{[]section.NotificationType{section.NTMsgTooLarge}, false, errMsgTooLarge},
+	}
+	for i, test := range tests {
+		client, server := net.Pipe()
+		success := make(chan error, 1)
+		sent := make(chan struct{})
+		close(sent)
+		go listen(client, tok, sent, time.Second, success)
+		//A notification for another message must not be attributed to the sent one.
+		msg := message.Message{Token: token.New(), Content: []section.Section{
+			&section.Notification{Type: section.NTHeartbeat, Token: token.New()}}}
+		for _, typ := range test.responses {
+			msg.Content = append(msg.Content, &section.Notification{Type: typ, Token: tok})
+		}
+		if err := (codec.CBOR{}).Encode(server, &msg); err != nil {
+			t.Fatalf("%d: was not able to send response: %v", i, err)
+		}
+		if test.closed {
+			server.Close()
+		}
+		if err := <-success; err != test.want {
+			t.Errorf("%d: wrong outcome. expected=%v actual=%v", i, test.want, err)
+		}
+		server.Close()
+	}
+}
+
+func TestListenAfterSent(t *testing.T) {
+	tok := token.New()
+	client, server := net.Pipe()
+	defer server.Close()
+	success := make(chan error, 1)
+	sent := make(chan struct{})
+	go listen(client, tok, sent, 50*time.Millisecond, success)
+	//The acknowledgement window only starts once the message has been sent.
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-success:
+		t.Fatalf("outcome reported before the message was sent: %v", err)
+	default:
+	}
+	close(sent)
+	msg := message.Message{Token: token.New(), Content: []section.Section{
+		&section.Notification{Type: section.NTHeartbeat, Token: tok}}}
+	if err := (codec.CBOR{}).Encode(server, &msg); err != nil {
+		t.Fatalf("was not able to send response: %v", err)
+	}
+	if err := <-success; err != nil {
+		t.Errorf("acknowledged push failed: %v", err)
+	}
+}
@@ -0,0 +1,407 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//dnsAlgED25519 is the DNSSEC algorithm number of Ed25519 (RFC 8080). It is the only DNSSEC
+//algorithm which can be expressed as a rains delegation.
+const dnsAlgED25519 = 15
+
+//rrsetKey identifies an RRset of a DNS zone.
+type rrsetKey struct {
+	name   string
+	rrtype uint16
+}
+
+//LoadDNSZone reads the DNS zone stored in master file format at path and maps its content to a
+//rains zone according to conf. DNSSEC records are not mapped but used to verify the zone if
+//conf.VerifyChain is set. The result has the same form as the output of zonefile.LoadZonefile.
+func LoadDNSZone(path string, conf DNSSECConfig) ([]section.WithSigForward, error) {
+	origin := dns.Fqdn(strings.ToLower(conf.Origin))
+	rrs, err := parseDNSFile(path, origin)
+	if err != nil {
+		return nil, err
+	}
+	if conf.VerifyChain {
+		if err := verifyDNSSEC(rrs, origin, conf.TrustAnchors, clock.Now()); err != nil {
+			return nil, err
+		}
+		log.Info("DNSSEC chain successfully verified", "zone", origin)
+	}
+	keysets := []dns.RR{}
+	for _, keysetPath := range conf.KeysetPaths {
+		keyset, err := parseDNSFile(keysetPath, origin)
+		if err != nil {
+			return nil, err
+		}
+		keysets = append(keysets, keyset...)
+	}
+	context := conf.Context
+	if context == "" {
+		context = "."
+	}
+	zone := &section.Zone{
+		SubjectZone: origin,
+		Context:     context,
+		Content:     dnsToAssertions(rrs, keysets, origin, conf),
+	}
+	return []section.WithSigForward{zone}, nil
+}
+
+//parseDNSFile returns all resource records stored in master file format at path. Relative names
+//are completed with origin.
+func parseDNSFile(path, origin string) ([]dns.RR, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var rrs []dns.RR
+	for token := range dns.ParseZone(file, origin, path) {
+		if token.Error != nil {
+			return nil, token.Error
+		}
+		rrs = append(rrs, token.RR)
+	}
+	return rrs, nil
+}
+
+//dnsToAssertions maps the records of the DNS zone origin to rains assertions, one per name. DS
+//records are mapped to delegations if a matching Ed25519 DNSKEY is contained in keysets or in the
+//zone itself. Record types without rains equivalent are skipped.
+func dnsToAssertions(rrs, keysets []dns.RR, origin string, conf DNSSECConfig) []*section.Assertion {
+	var childKeys []*dns.DNSKEY
+	for _, rr := range append(rrs, keysets...) {
+		if key, ok := rr.(*dns.DNSKEY); ok && !strings.EqualFold(key.Hdr.Name, origin) {
+			childKeys = append(childKeys, key)
+		}
+	}
+	content := make(map[string][]object.Object)
+	add := func(fqdn string, o object.Object) {
+		name, ok := relativeName(fqdn, origin)
+		if !ok {
+			log.Warn("Record is outside of the zone, skipped", "name", fqdn, "zone", origin)
+			return
+		}
+		content[name] = append(content[name], o)
+	}
+	for _, rr := range rrs {
+		owner := strings.ToLower(rr.Header().Name)
+		switch rr := rr.(type) {
+		case *dns.A:
+			add(owner, object.Object{Type: object.OTIP4Addr, Value: rr.A.String()})
+		case *dns.AAAA:
+			add(owner, object.Object{Type: object.OTIP6Addr, Value: rr.AAAA.String()})
+		case *dns.CNAME:
+			add(owner, object.Object{Type: object.OTName, Value: object.Name{
+				Name:  strings.ToLower(rr.Target),
+				Types: []object.Type{object.OTIP4Addr, object.OTIP6Addr, object.OTServiceInfo},
+			}})
+		case *dns.SRV:
+			add(owner, object.Object{Type: object.OTServiceInfo, Value: object.ServiceInfo{
+				Name:     strings.ToLower(rr.Target),
+				Port:     rr.Port,
+				Priority: uint(rr.Priority),
+			}})
+		case *dns.NS:
+			if owner == origin {
+				continue
+			}
+			target := strings.ToLower(rr.Ns)
+			add(owner, object.Object{Type: object.OTRedirection, Value: target})
+			if conf.NSServicePort > 0 {
+				add(target, object.Object{Type: object.OTServiceInfo, Value: object.ServiceInfo{
+					Name: target,
+					Port: uint16(conf.NSServicePort),
+				}})
+			}
+		case *dns.DS:
+			key := matchingKey(rr, childKeys)
+			if key == nil {
+				log.Warn("No matching DNSKEY for DS record, skipped", "name", owner,
+					"keyTag", rr.KeyTag)
+				continue
+			}
+			publicKey, err := dnskeyToPublicKey(key, conf.DelegationKeyPhase)
+			if err != nil {
+				log.Warn("DNSKEY cannot be expressed as delegation, skipped", "name", owner,
+					"error", err)
+				continue
+			}
+			add(owner, object.Object{Type: object.OTDelegation, Value: publicKey})
+		case *dns.SOA, *dns.DNSKEY, *dns.RRSIG, *dns.NSEC, *dns.NSEC3, *dns.NSEC3PARAM:
+			//DNS internal or DNSSEC material which is either mapped via DS or used for verification.
+		default:
+			log.Warn("Record type has no rains equivalent, skipped", "name", owner,
+				"type", dns.TypeToString[rr.Header().Rrtype])
+		}
+	}
+	var names []string
+	for name := range content {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var assertions []*section.Assertion
+	for _, name := range names {
+		assertions = append(assertions, &section.Assertion{SubjectName: name, Content: content[name]})
+	}
+	return assertions
+}
+
+//relativeName returns fqdn relative to origin in the form used for the subject name of rains
+//assertions. It returns false if fqdn is not part of origin.
+func relativeName(fqdn, origin string) (string, bool) {
+	if fqdn == origin {
+		return "@", true
+	}
+	if origin == "." {
+		return strings.TrimSuffix(fqdn, "."), true
+	}
+	if !strings.HasSuffix(fqdn, "."+origin) {
+		return "", false
+	}
+	return strings.TrimSuffix(fqdn, "."+origin), true
+}
+
+//matchingKey returns the key of keys whose digest is contained in ds or nil if there is none.
+func matchingKey(ds *dns.DS, keys []*dns.DNSKEY) *dns.DNSKEY {
+	for _, key := range keys {
+		if !strings.EqualFold(key.Hdr.Name, ds.Hdr.Name) || key.KeyTag() != ds.KeyTag ||
+			key.Algorithm != ds.Algorithm {
+			continue
+		}
+		if keyDS := key.ToDS(ds.DigestType); keyDS != nil &&
+			strings.EqualFold(keyDS.Digest, ds.Digest) {
+			return key
+		}
+	}
+	return nil
+}
+
+//dnskeyToPublicKey returns key as rains public key with the given key phase. Only Ed25519 keys are
+//supported.
+func dnskeyToPublicKey(key *dns.DNSKEY, keyPhase int) (keys.PublicKey, error) {
+	if key.Algorithm != dnsAlgED25519 {
+		return keys.PublicKey{}, fmt.Errorf("unsupported DNSSEC algorithm %d", key.Algorithm)
+	}
+	data, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	if err != nil || len(data) != ed25519.PublicKeySize {
+		return keys.PublicKey{}, errors.New("malformed Ed25519 public key")
+	}
+	return keys.PublicKey{
+		PublicKeyID: keys.PublicKeyID{
+			Algorithm: algorithmTypes.Ed25519,
+			KeySpace:  keys.RainsKeySpace,
+			KeyPhase:  keyPhase,
+		},
+		Key: ed25519.PublicKey(data),
+	}, nil
+}
+
+//verifyDNSSEC checks the chain of trust of the DNS zone origin at time now. The zone's DNSKEY RRset
+//must be signed by a key matching one of the DS records in trustAnchors and every authoritative
+//RRset of the zone must be signed by a key of this RRset.
+func verifyDNSSEC(rrs []dns.RR, origin string, trustAnchors []string, now time.Time) error {
+	rrsets := make(map[rrsetKey][]dns.RR)
+	sigs := make(map[rrsetKey][]*dns.RRSIG)
+	cuts := make(map[string]bool)
+	for _, rr := range rrs {
+		name := strings.ToLower(rr.Header().Name)
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs[rrsetKey{name, sig.TypeCovered}] = append(sigs[rrsetKey{name, sig.TypeCovered}], sig)
+			continue
+		}
+		rrsets[rrsetKey{name, rr.Header().Rrtype}] = append(rrsets[rrsetKey{name, rr.Header().Rrtype}], rr)
+		if rr.Header().Rrtype == dns.TypeNS && name != origin {
+			cuts[name] = true
+		}
+	}
+	var zoneKeys []*dns.DNSKEY
+	for _, rr := range rrsets[rrsetKey{origin, dns.TypeDNSKEY}] {
+		zoneKeys = append(zoneKeys, rr.(*dns.DNSKEY))
+	}
+	if len(zoneKeys) == 0 {
+		return fmt.Errorf("zone %s has no DNSKEY records", origin)
+	}
+	var anchorKeys []*dns.DNSKEY
+	for _, anchor := range trustAnchors {
+		rr, err := dns.NewRR(anchor)
+		if err != nil {
+			return fmt.Errorf("malformed trust anchor %q: %v", anchor, err)
+		}
+		ds, ok := rr.(*dns.DS)
+		if !ok {
+			return fmt.Errorf("trust anchor %q is not a DS record", anchor)
+		}
+		if key := matchingKey(ds, zoneKeys); key != nil {
+			anchorKeys = append(anchorKeys, key)
+		}
+	}
+	if len(anchorKeys) == 0 {
+		return fmt.Errorf("no DNSKEY of zone %s matches a trust anchor", origin)
+	}
+	keySet := rrsetKey{origin, dns.TypeDNSKEY}
+	if err := verifyRRset(rrsets[keySet], sigs[keySet], anchorKeys, now); err != nil {
+		return fmt.Errorf("DNSKEY RRset of %s is not signed by a trust anchor: %v", origin, err)
+	}
+	for key, rrset := range rrsets {
+		if !isAuthoritative(key, cuts) {
+			continue
+		}
+		if err := verifyRRset(rrset, sigs[key], zoneKeys, now); err != nil {
+			return fmt.Errorf("RRset %s %s: %v", key.name, dns.TypeToString[key.rrtype], err)
+		}
+	}
+	return nil
+}
+
+//isAuthoritative returns true if the RRset identified by key is signed by the zone. NS records at
+//and all records below a zone cut are not, except for the DS and NSEC records at the cut.
+func isAuthoritative(key rrsetKey, cuts map[string]bool) bool {
+	if cuts[key.name] {
+		return key.rrtype == dns.TypeDS || key.rrtype == dns.TypeNSEC
+	}
+	for cut := range cuts {
+		if strings.HasSuffix(key.name, "."+cut) {
+			return false
+		}
+	}
+	return true
+}
+
+//verifyRRset returns nil if one of sigs is currently valid and verifies rrset with one of keys.
+func verifyRRset(rrset []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY, now time.Time) error {
+	if len(sigs) == 0 {
+		return errors.New("RRset is not signed")
+	}
+	err := errors.New("no signature verifies with the zone's keys")
+	for _, sig := range sigs {
+		if !sig.ValidityPeriod(now) {
+			err = errors.New("signature is expired or not yet valid")
+			continue
+		}
+		for _, key := range keys {
+			if verifyRRSIG(sig, key, rrset) == nil {
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+//verifyRRSIG checks the signature sig on rrset with key. Ed25519 signatures are verified here as
+//the dns package does not support them.
+func verifyRRSIG(sig *dns.RRSIG, key *dns.DNSKEY, rrset []dns.RR) error {
+	if sig.Algorithm != dnsAlgED25519 {
+		return sig.Verify(key, rrset)
+	}
+	if sig.KeyTag != key.KeyTag() || sig.Algorithm != key.Algorithm ||
+		!strings.EqualFold(sig.SignerName, key.Hdr.Name) || key.Protocol != 3 {
+		return dns.ErrKey
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return dns.ErrKey
+	}
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		return dns.ErrSig
+	}
+	data, err := signedData(sig, rrset)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(publicKey), data, signature) {
+		return dns.ErrSig
+	}
+	return nil
+}
+
+//signedData returns the data covered by sig according to RFC 4034 section 3.1.8.1, i.e. the
+//RRSIG rdata without signature followed by the rrset in canonical form and order.
+func signedData(sig *dns.RRSIG, rrset []dns.RR) ([]byte, error) {
+	sigCopy := *sig
+	sigCopy.Hdr.Name = "."
+	sigCopy.SignerName = strings.ToLower(sig.SignerName)
+	sigCopy.Signature = ""
+	buf := make([]byte, dns.MaxMsgSize)
+	n, err := dns.PackRR(&sigCopy, buf, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	//The root name and the fixed header fields of the RR take 11 bytes.
+	data := append([]byte{}, buf[11:n]...)
+	//All records of the RRset have the same owner name and thus the same header length. The
+	//canonical order is defined on the rdata only.
+	var wires [][]byte
+	headerLen := 0
+	for _, rr := range rrset {
+		rr = dns.Copy(rr)
+		header := rr.Header()
+		header.Name = strings.ToLower(header.Name)
+		if labels := dns.SplitDomainName(header.Name); len(labels) > int(sig.Labels) {
+			header.Name = "*." + strings.Join(labels[len(labels)-int(sig.Labels):], ".") + "."
+		}
+		header.Ttl = sig.OrigTtl
+		canonicalRdata(rr)
+		n, err := dns.PackRR(rr, buf, 0, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		wires = append(wires, append([]byte{}, buf[:n]...))
+		if headerLen, err = dns.PackDomainName(header.Name, buf, 0, nil, false); err != nil {
+			return nil, err
+		}
+		headerLen += 10
+	}
+	sort.Slice(wires, func(i, j int) bool {
+		return bytes.Compare(wires[i][headerLen:], wires[j][headerLen:]) < 0
+	})
+	for i, wire := range wires {
+		if i > 0 && bytes.Equal(wire, wires[i-1]) {
+			continue
+		}
+		data = append(data, wire...)
+	}
+	return data, nil
+}
+
+//canonicalRdata lowercases the domain names in the rdata of rr as required by RFC 4034 section
+//6.2 for the record types supported by the bridge.
+func canonicalRdata(rr dns.RR) {
+	switch rr := rr.(type) {
+	case *dns.NS:
+		rr.Ns = strings.ToLower(rr.Ns)
+	case *dns.CNAME:
+		rr.Target = strings.ToLower(rr.Target)
+	case *dns.PTR:
+		rr.Ptr = strings.ToLower(rr.Ptr)
+	case *dns.MX:
+		rr.Mx = strings.ToLower(rr.Mx)
+	case *dns.SRV:
+		rr.Target = strings.ToLower(rr.Target)
+	case *dns.SOA:
+		rr.Ns = strings.ToLower(rr.Ns)
+		rr.Mbox = strings.ToLower(rr.Mbox)
+	case *dns.DNAME:
+		rr.Target = strings.ToLower(rr.Target)
+	}
+}
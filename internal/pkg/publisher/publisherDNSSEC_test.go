@@ -0,0 +1,131 @@
+package publisher
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/ed25519"
+)
+
+//Example 1 of RFC 8080 section 6.
+const (
+	rfc8080Seed   = "ODIyNjAzODQ2MjgwODAxMjI2NDUxOTAyMDQxNDIyNjI="
+	rfc8080DNSKEY = "example.com. 3600 IN DNSKEY 257 3 15 " +
+		"l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4="
+	rfc8080DS = "example.com. 3600 IN DS 3613 15 2 " +
+		"3aa5ab37efce57f737fc1627013fee07bdf241bd10f3b1964ab55c78e79a304b"
+	rfc8080MX    = "example.com. 3600 IN MX 10 mail.example.com."
+	rfc8080RRSIG = "example.com. 3600 IN RRSIG MX 15 2 3600 1440021600 1438207200 3613 " +
+		"example.com. oL9krJun7xfBOIWcGHi7mag5/hdZrKWw15jPGrHpjQeRAvTdszaPD+QLs3fx8A4M3e23mRZ9V" +
+		"rbpMngwcrqNAg=="
+)
+
+//rfc8080Time lies within the validity period of the example signature.
+var rfc8080Time = time.Unix(1439000000, 0)
+
+//newRR returns the resource record given in master file format.
+func newRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("Was not able to parse %q: %v", s, err)
+	}
+	return rr
+}
+
+//signRRset returns an RRSIG over rrset created with the example key of RFC 8080 which is valid
+//around rfc8080Time.
+func signRRset(t *testing.T, rrset []dns.RR) *dns.RRSIG {
+	t.Helper()
+	seed, _ := base64.StdEncoding.DecodeString(rfc8080Seed)
+	key := newRR(t, rfc8080DNSKEY).(*dns.DNSKEY)
+	header := rrset[0].Header()
+	sig := &dns.RRSIG{
+		Hdr: dns.RR_Header{Name: header.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET,
+			Ttl: header.Ttl},
+		TypeCovered: header.Rrtype,
+		Algorithm:   dnsAlgED25519,
+		Labels:      uint8(dns.CountLabel(header.Name)),
+		OrigTtl:     header.Ttl,
+		Expiration:  uint32(rfc8080Time.Add(time.Hour).Unix()),
+		Inception:   uint32(rfc8080Time.Add(-time.Hour).Unix()),
+		KeyTag:      key.KeyTag(),
+		SignerName:  key.Hdr.Name,
+	}
+	data, err := signedData(sig, rrset)
+	if err != nil {
+		t.Fatalf("Was not able to compute signed data: %v", err)
+	}
+	signature := ed25519.Sign(ed25519.NewKeyFromSeed(seed), data)
+	sig.Signature = base64.StdEncoding.EncodeToString(signature)
+	return sig
+}
+
+func TestVerifyRRSIG(t *testing.T) {
+	key := newRR(t, rfc8080DNSKEY).(*dns.DNSKEY)
+	sig := newRR(t, rfc8080RRSIG).(*dns.RRSIG)
+	var tests = []struct {
+		rrset []dns.RR
+		valid bool
+	}{
+		{[]dns.RR{newRR(t, rfc8080MX)}, true},
+		//Owner names are compared in canonical, i.e. lower case, form.
+		{[]dns.RR{newRR(t, "EXAMPLE.com. 3600 IN MX 10 mail.example.com.")}, true},
+		{[]dns.RR{newRR(t, "example.com. 3600 IN MX 20 mail.example.com.")}, false},
+		{[]dns.RR{newRR(t, "example.com. 3600 IN MX 10 mail2.example.com.")}, false},
+		{[]dns.RR{newRR(t, rfc8080MX), newRR(t, "example.com. 3600 IN MX 20 mx.example.com.")},
+			false},
+	}
+	for i, test := range tests {
+		if err := verifyRRSIG(sig, key, test.rrset); (err == nil) != test.valid {
+			t.Errorf("%d: wrong verification result. expected valid=%t actual=%v", i, test.valid,
+				err)
+		}
+	}
+	otherKey := newRR(t, rfc8080DNSKEY).(*dns.DNSKEY)
+	otherKey.Flags = 256
+	if err := verifyRRSIG(sig, otherKey, []dns.RR{newRR(t, rfc8080MX)}); err == nil {
+		t.Errorf("signature verified with a key of a different key tag")
+	}
+}
+
+func TestVerifyDNSSEC(t *testing.T) {
+	key := newRR(t, rfc8080DNSKEY)
+	mx := newRR(t, rfc8080MX)
+	a := newRR(t, "www.example.com. 3600 IN A 192.0.2.1")
+	zone := func(rrs ...dns.RR) []dns.RR {
+		return append(rrs, key, signRRset(t, []dns.RR{key}), newRR(t, rfc8080RRSIG))
+	}
+	var tests = []struct {
+		rrs    []dns.RR
+		now    time.Time
+		anchor string
+		errMsg string
+	}{
+		{zone(mx, a, signRRset(t, []dns.RR{a})), rfc8080Time, rfc8080DS, ""},
+		//Records below a zone cut are not signed by the zone.
+		{zone(mx, newRR(t, "sub.example.com. 3600 IN NS ns.sub.example.com."),
+			newRR(t, "ns.sub.example.com. 3600 IN A 192.0.2.2")), rfc8080Time, rfc8080DS, ""},
+		{zone(newRR(t, "example.com. 3600 IN MX 20 mail.example.com.")), rfc8080Time, rfc8080DS,
+			"no signature verifies"},
+		{zone(mx, a), rfc8080Time, rfc8080DS, "RRset is not signed"},
+		{zone(mx, a, signRRset(t, []dns.RR{newRR(t, "www.example.com. 3600 IN A 192.0.2.3")})),
+			rfc8080Time, rfc8080DS, "no signature verifies"},
+		{zone(mx), rfc8080Time.Add(24 * time.Hour), rfc8080DS, "expired"},
+		{zone(mx), rfc8080Time, strings.Replace(rfc8080DS, "3aa5", "3aa6", 1),
+			"matches a trust anchor"},
+		{[]dns.RR{mx, newRR(t, rfc8080RRSIG)}, rfc8080Time, rfc8080DS, "no DNSKEY records"},
+	}
+	for i, test := range tests {
+		err := verifyDNSSEC(test.rrs, "example.com.", []string{test.anchor}, test.now)
+		if test.errMsg == "" && err != nil {
+			t.Errorf("%d: valid zone did not verify: %v", i, err)
+		}
+		if test.errMsg != "" && (err == nil || !strings.Contains(err.Error(), test.errMsg)) {
+			t.Errorf("%d: wrong verification result. expected=%s actual=%v", i, test.errMsg, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package publisher
+
+import (
+	"errors"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/keyManager"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+//IssueDelegation validates the delegation request of a child zone stored at requestPath and adds
+//the resulting delegation and redirection assertions to the zone in the zonefile at zonefilePath.
+//Existing delegation and redirection assertions of the child zone are replaced. The zone must be
+//signed and published afterwards for the delegation to take effect.
+func IssueDelegation(zonefilePath, requestPath string) error {
+	request, err := keyManager.LoadDelegationRequest(requestPath)
+	if err != nil {
+		log.Error("Invalid delegation request", "path", requestPath, "error", err)
+		return err
+	}
+	encoder := zonefile.IO{}
+	zoneContent, err := encoder.LoadZonefile(zonefilePath)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	var zone *section.Zone
+	var output []section.Section
+	for _, s := range zoneContent {
+		switch s := s.(type) {
+		case *section.Zone:
+			zone = s
+		case *section.Shard, *section.Pshard:
+			log.Warn("Zonefile contains shards or pshards which do not include the issued delegation. They must be recreated",
+				"path", zonefilePath)
+		}
+		output = append(output, s)
+	}
+	if zone == nil {
+		return errors.New("zonefile does not contain a zone")
+	}
+	assertions, err := request.Assertions(zone.SubjectZone)
+	if err != nil {
+		log.Error("Was not able to issue delegation", "error", err)
+		return err
+	}
+	var content []*section.Assertion
+	for _, a := range zone.Content {
+		if a.SubjectName == assertions[0].SubjectName && isDelegationOnly(a) {
+			log.Info("Replacing existing assertion", "assertion", a)
+			continue
+		}
+		content = append(content, a)
+	}
+	for _, a := range assertions {
+		a.RemoveContextAndSubjectZone()
+		content = append(content, a)
+	}
+	zone.Content = content
+	if err := encoder.EncodeAndStore(zonefilePath, output); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	log.Info("Delegation issued", "zone", request.Zone, "key", request.PublicKey.PublicKeyID,
+		"redirections", request.Redirections)
+	return nil
+}
+
+//isDelegationOnly returns true if a only contains delegation and redirection objects.
+func isDelegationOnly(a *section.Assertion) bool {
+	for _, o := range a.Content {
+		if o.Type != object.OTDelegation && o.Type != object.OTRedirection {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package publisher
+
+import (
+	"os"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+//deltaSections returns the sections which must be pushed to a server holding the previously
+//published sections such that it afterwards holds the current sections. It consists of
+//  - all added or changed assertions as standalone assertions,
+//  - all added or changed shards which prove the absence of removed assertions in their range,
+//  - all pshards, which serve as a digest of the updated zone, and
+//  - the zone itself only if a removed assertion is not covered by a pushed shard.
+//Sections are compared by their zonefile encoding including signatures. It returns false if no
+//delta can be computed because one of the inputs does not contain a zone.
+func deltaSections(previous, current []section.Section) ([]section.Section, bool) {
+	prevZone, prevShards, _ := zoneParts(previous)
+	zone, shards, pshards := zoneParts(current)
+	if prevZone == nil || zone == nil {
+		return nil, false
+	}
+	encoder := zonefile.IO{}
+	prevAssertions := make(map[string]bool)
+	for _, a := range standaloneAssertions(prevZone) {
+		prevAssertions[encoder.EncodeSection(a)] = true
+	}
+	currAssertions := make(map[string]bool)
+	var delta []section.Section
+	for _, a := range standaloneAssertions(zone) {
+		encoding := encoder.EncodeSection(a)
+		currAssertions[encoding] = true
+		if !prevAssertions[encoding] {
+			delta = append(delta, a)
+		}
+	}
+	nofAssertions := len(delta)
+	prevShardSet := make(map[string]bool)
+	for _, s := range prevShards {
+		prevShardSet[encoder.EncodeSection(s)] = true
+	}
+	var pushedShards []*section.Shard
+	for _, s := range shards {
+		if !prevShardSet[encoder.EncodeSection(s)] {
+			pushedShards = append(pushedShards, s)
+			delta = append(delta, s)
+		}
+	}
+	for _, s := range pshards {
+		delta = append(delta, s)
+	}
+	removed := 0
+	includeZone := false
+	for _, a := range standaloneAssertions(prevZone) {
+		if currAssertions[encoder.EncodeSection(a)] {
+			continue
+		}
+		removed++
+		if !isCovered(a.SubjectName, pushedShards) {
+			includeZone = true
+		}
+	}
+	if includeZone {
+		delta = append(delta, zone)
+	}
+	log.Info("Computed differential push", "changedAssertions", nofAssertions,
+		"removedAssertions", removed, "shards", len(pushedShards), "pshards", len(pshards),
+		"zone", includeZone)
+	return delta, true
+}
+
+//zoneParts returns the zone, shards and pshards contained in sections.
+func zoneParts(sections []section.Section) (*section.Zone, []*section.Shard,
+	[]*section.Pshard) {
+	var zone *section.Zone
+	var shards []*section.Shard
+	var pshards []*section.Pshard
+	for _, s := range sections {
+		switch s := s.(type) {
+		case *section.Zone:
+			zone = s
+		case *section.Shard:
+			shards = append(shards, s)
+		case *section.Pshard:
+			pshards = append(pshards, s)
+		}
+	}
+	return zone, shards, pshards
+}
+
+//isCovered returns true if subjectName is in the range of one of the shards.
+func isCovered(subjectName string, shards []*section.Shard) bool {
+	for _, s := range shards {
+		if s.InRange(subjectName) {
+			return true
+		}
+	}
+	return false
+}
+
+//loadPushState returns the sections stored at path by storePushState. It returns false if there is
+//no state or it cannot be loaded.
+func loadPushState(path string) ([]section.Section, bool) {
+	sections, err := zonefile.IO{}.LoadZonefile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("Was not able to load state of the previous push", "path", path, "error", err)
+		}
+		return nil, false
+	}
+	var output []section.Section
+	for _, s := range sections {
+		output = append(output, s)
+	}
+	return output, true
+}
+
+//storePushState stores sections at path as the state of the last successful push.
+func storePushState(path string, sections []section.Section) error {
+	return zonefile.IO{}.EncodeAndStore(path, sections)
+}
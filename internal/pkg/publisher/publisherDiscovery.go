@@ -0,0 +1,158 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//discoveryQueryValidity is the time a discovery query is valid.
+const discoveryQueryValidity = 10 * time.Second
+
+//newDiscoveryResolver returns a resolver according to conf. If forwarders are configured, queries
+//are forwarded to them. Otherwise, a recursive lookup starting at the root servers is performed.
+func newDiscoveryResolver(conf DiscoveryConfig) *libresolve.Resolver {
+	var rootServers, forwarders []net.Addr
+	for _, info := range conf.RootServers {
+		rootServers = append(rootServers, info.Addr)
+	}
+	for _, info := range conf.Forwarders {
+		forwarders = append(forwarders, info.Addr)
+	}
+	mode := libresolve.Recursive
+	if len(forwarders) != 0 {
+		mode = libresolve.Forward
+	}
+	return libresolve.New(rootServers, forwarders, mode, nil, len(rootServers)+len(forwarders))
+}
+
+//discoverAuthServers returns the addresses of the zone's authoritative servers. It looks up the
+//redirection assertions of zone (which are stored at the parent), the service information of the
+//redirection targets, and the ip addresses of the service hosts. The discovery is aborted as soon
+//as ctx is done.
+func discoverAuthServers(ctx context.Context, zone, context string,
+	resolver *libresolve.Resolver) ([]connection.Info, error) {
+	var servers []connection.Info
+	redirs, err := lookupObjects(ctx, zone, context, []object.Type{object.OTRedirection},
+		resolver)
+	if err != nil {
+		return nil, err
+	}
+	for _, redir := range redirs {
+		target, ok := redir.Value.(string)
+		if !ok {
+			log.Warn("Received redirection is malformed", "value", redir.Value)
+			continue
+		}
+		srvs, err := lookupObjects(ctx, target, context, []object.Type{object.OTServiceInfo},
+			resolver)
+		if err != nil {
+			log.Warn("Was not able to obtain service information", "name", target, "error", err)
+			continue
+		}
+		for _, srv := range srvs {
+			srvInfo, ok := srv.Value.(object.ServiceInfo)
+			if !ok {
+				log.Warn("Received service information is malformed", "value", srv.Value)
+				continue
+			}
+			ips, err := lookupObjects(ctx, srvInfo.Name, context,
+				[]object.Type{object.OTIP4Addr, object.OTIP6Addr}, resolver)
+			if err != nil {
+				log.Warn("Was not able to obtain ip address", "name", srvInfo.Name, "error", err)
+				continue
+			}
+			for _, ip := range ips {
+				host, ok := ip.Value.(string)
+				if !ok {
+					log.Warn("Received ip address is malformed", "ip", ip.Value)
+					continue
+				}
+				addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(host, fmt.Sprint(srvInfo.Port)))
+				if err != nil {
+					log.Warn("Received ip address is malformed", "ip", ip.Value, "error", err)
+					continue
+				}
+				servers = append(servers, connection.Info{Type: connection.TCP, Addr: addr})
+			}
+		}
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no authoritative server found for zone %s", zone)
+	}
+	log.Info("Discovered authoritative servers", "zone", zone, "servers", servers)
+	return servers, nil
+}
+
+//lookupObjects resolves name in context for the given types and returns all objects of these types
+//that are contained in assertions with name as fully qualified domain name.
+func lookupObjects(ctx context.Context, name, context string, types []object.Type,
+	resolver *libresolve.Resolver) ([]object.Object, error) {
+	q := &query.Name{
+		Name:       name,
+		Context:    context,
+		Types:      types,
+		Expiration: clock.Now().Add(discoveryQueryValidity).Unix(),
+	}
+	answer, err := resolver.ClientLookup(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[object.Type]bool)
+	for _, t := range types {
+		wanted[t] = true
+	}
+	var assertions []*section.Assertion
+	for _, s := range answer.Content {
+		switch s := s.(type) {
+		case *section.Assertion:
+			assertions = append(assertions, s)
+		case *section.Shard:
+			s.AddCtxAndZoneToContent()
+			assertions = append(assertions, s.Content...)
+		case *section.Zone:
+			s.AddCtxAndZoneToContent()
+			assertions = append(assertions, s.Content...)
+		}
+	}
+	var objects []object.Object
+	for _, a := range assertions {
+		if a.FQDN() != name {
+			continue
+		}
+		for _, o := range a.Content {
+			if wanted[o.Type] {
+				objects = append(objects, o)
+			}
+		}
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("answer does not contain the requested types for %s", name)
+	}
+	return objects, nil
+}
+
+//mergeAuthServers returns the union of servers and discovered without duplicates.
+func mergeAuthServers(servers, discovered []connection.Info) []connection.Info {
+	seen := make(map[string]bool)
+	var output []connection.Info
+	for _, list := range [][]connection.Info{servers, discovered} {
+		for _, info := range list {
+			if !seen[info.Addr.String()] {
+				seen[info.Addr.String()] = true
+				output = append(output, info)
+			}
+		}
+	}
+	return output
+}
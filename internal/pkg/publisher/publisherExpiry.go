@@ -0,0 +1,60 @@
+package publisher
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//ExpiringSignature describes a signature which expires before a given point in time.
+type ExpiringSignature struct {
+	//Section is a human readable identifier of the signed section.
+	Section    string
+	KeyID      keys.PublicKeyID
+	ValidUntil int64
+}
+
+//ExpiringSignatures returns all signatures on sections and their contained assertions which
+//expire before deadline (unix timestamp in seconds). The result is sorted by validUntil in
+//ascending order.
+func ExpiringSignatures(sections []section.Section, deadline int64) []ExpiringSignature {
+	var output []ExpiringSignature
+	add := func(s section.WithSig, name string) {
+		for _, sig := range s.AllSigs() {
+			if sig.ValidUntil < deadline {
+				output = append(output, ExpiringSignature{
+					Section:    name,
+					KeyID:      sig.PublicKeyID,
+					ValidUntil: sig.ValidUntil,
+				})
+			}
+		}
+	}
+	addAssertions := func(assertions []*section.Assertion) {
+		for _, a := range assertions {
+			add(a, fmt.Sprintf("assertion %s", a.FQDN()))
+		}
+	}
+	for _, s := range sections {
+		switch s := s.(type) {
+		case *section.Assertion:
+			add(s, fmt.Sprintf("assertion %s", s.FQDN()))
+		case *section.Shard:
+			add(s, fmt.Sprintf("shard %s [%s,%s]", s.SubjectZone, s.RangeFrom, s.RangeTo))
+			s.AddCtxAndZoneToContent()
+			addAssertions(s.Content)
+			s.RemoveCtxAndZoneFromContent()
+		case *section.Pshard:
+			add(s, fmt.Sprintf("pshard %s [%s,%s]", s.SubjectZone, s.RangeFrom, s.RangeTo))
+		case *section.Zone:
+			add(s, fmt.Sprintf("zone %s", s.SubjectZone))
+			s.AddCtxAndZoneToContent()
+			addAssertions(s.Content)
+			s.RemoveCtxAndZoneFromContent()
+		}
+	}
+	sort.SliceStable(output, func(i, j int) bool { return output[i].ValidUntil < output[j].ValidUntil })
+	return output
+}
@@ -0,0 +1,209 @@
+package publisher
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//dryRunMaxValidity is an upper bound on the validity of signatures during a dry run. It is only
+//used to not cap the validity of the verified sections as a cache would.
+const dryRunMaxValidity = 100 * 365 * 24 * time.Hour
+
+//Report summarizes the outcome of a dry run. It contains everything that would have been sent to
+//the authoritative servers without contacting any of them.
+type Report struct {
+	Zone           string
+	Context        string
+	Assertions     int
+	Shards         int
+	Pshards        int
+	Signatures     int
+	WireSize       int
+	EarliestExpiry int64
+	//Verified is true if all sections are signed and all signatures verify against the public keys
+	//delegated to the zone.
+	Verified bool
+	//VerifySkipped is true if the verification was skipped because nothing is signed.
+	VerifySkipped bool
+	//VerifyError describes why the verification failed. It is empty if Verified or VerifySkipped is
+	//true.
+	VerifyError string
+}
+
+//Print writes a human readable form of the report to w.
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Dry run report for zone %s in context %s\n", r.Zone, r.Context)
+	fmt.Fprintf(w, "  assertions:         %d\n", r.Assertions)
+	fmt.Fprintf(w, "  shards:             %d\n", r.Shards)
+	fmt.Fprintf(w, "  pshards:            %d\n", r.Pshards)
+	fmt.Fprintf(w, "  signatures:         %d\n", r.Signatures)
+	fmt.Fprintf(w, "  wire size (est.):   %d bytes\n", r.WireSize)
+	if r.Signatures == 0 {
+		fmt.Fprintln(w, "  earliest expiry:    -")
+	} else {
+		fmt.Fprintf(w, "  earliest expiry:    %s\n", time.Unix(r.EarliestExpiry, 0).UTC())
+	}
+	if r.Verified {
+		fmt.Fprintln(w, "  verification:       ok")
+	} else if r.VerifySkipped {
+		fmt.Fprintln(w, "  verification:       skipped (no signatures)")
+	} else {
+		fmt.Fprintf(w, "  verification:       failed (%s)\n", r.VerifyError)
+	}
+}
+
+//dryRunReport returns a report about the zone, shards and pshards as they would be published.
+//Signatures are verified against the public keys delegated to the zone by the delegation assertions
+//in the zonefile at delegPath. The verification is skipped if there are no signatures at all.
+func dryRunReport(zone *section.Zone, shards []*section.Shard, pshards []*section.Pshard,
+	delegPath string) Report {
+	report := Report{
+		Zone:       zone.SubjectZone,
+		Context:    zone.Context,
+		Assertions: len(zone.Content),
+		Shards:     len(shards),
+		Pshards:    len(pshards),
+	}
+	output := []section.Section{zone}
+	for _, shard := range shards {
+		output = append(output, shard)
+	}
+	for _, pshard := range pshards {
+		output = append(output, pshard)
+	}
+	report.Signatures, report.EarliestExpiry = signatureStats(zone, shards, pshards)
+	report.WireSize = wireSize(output)
+	if report.Signatures == 0 {
+		report.VerifySkipped = true
+	} else if err := verifyZoneContent(zone, shards, pshards, delegPath); err != nil {
+		report.VerifyError = err.Error()
+	} else {
+		report.Verified = true
+	}
+	return report
+}
+
+//verifyZoneContent checks that the zone, shards, pshards and all contained assertions are signed
+//and that their signatures verify against the public keys delegated to the zone by the delegation
+//assertions in the zonefile at delegPath. Note that it removes expired signatures from the sections.
+func verifyZoneContent(zone *section.Zone, shards []*section.Shard, pshards []*section.Pshard,
+	delegPath string) error {
+	pkeys, err := LoadDelegatedKeys(delegPath, zone.SubjectZone)
+	if err != nil {
+		return err
+	}
+	maxVal := util.MaxCacheValidity{
+		AssertionValidity: dryRunMaxValidity,
+		ShardValidity:     dryRunMaxValidity,
+		PhardValidity:     dryRunMaxValidity,
+		ZoneValidity:      dryRunMaxValidity,
+	}
+	if len(zone.AllSigs()) == 0 {
+		return errors.New("zone is not signed")
+	}
+	zone.DontAddSigInMarshaller()
+	ok := siglib.CheckSectionSignatures(zone, pkeys, maxVal)
+	zone.AddSigInMarshaller()
+	if !ok {
+		return errors.New("zone signature is invalid")
+	}
+	zone.AddCtxAndZoneToContent()
+	defer zone.RemoveCtxAndZoneFromContent()
+	for _, a := range zone.Content {
+		if len(a.AllSigs()) == 0 {
+			return fmt.Errorf("assertion %s is not signed", a.SubjectName)
+		}
+		if !siglib.CheckSectionSignatures(a, pkeys, maxVal) {
+			return fmt.Errorf("signature of assertion %s is invalid", a.SubjectName)
+		}
+	}
+	for _, shard := range shards {
+		if len(shard.AllSigs()) == 0 {
+			return fmt.Errorf("shard [%s,%s] is not signed", shard.RangeFrom, shard.RangeTo)
+		}
+		shard.DontAddSigInMarshaller()
+		ok := siglib.CheckSectionSignatures(shard, pkeys, maxVal)
+		shard.AddSigInMarshaller()
+		if !ok {
+			return fmt.Errorf("signature of shard [%s,%s] is invalid", shard.RangeFrom, shard.RangeTo)
+		}
+		shard.AddCtxAndZoneToContent()
+		for _, a := range shard.Content {
+			if len(a.AllSigs()) == 0 {
+				shard.RemoveCtxAndZoneFromContent()
+				return fmt.Errorf("assertion %s is not signed", a.SubjectName)
+			}
+			if !siglib.CheckSectionSignatures(a, pkeys, maxVal) {
+				shard.RemoveCtxAndZoneFromContent()
+				return fmt.Errorf("signature of assertion %s is invalid", a.SubjectName)
+			}
+		}
+		shard.RemoveCtxAndZoneFromContent()
+	}
+	for _, pshard := range pshards {
+		if len(pshard.AllSigs()) == 0 {
+			return fmt.Errorf("pshard [%s,%s] is not signed", pshard.RangeFrom, pshard.RangeTo)
+		}
+		if !siglib.CheckSectionSignatures(pshard, pkeys, maxVal) {
+			return fmt.Errorf("signature of pshard [%s,%s] is invalid", pshard.RangeFrom, pshard.RangeTo)
+		}
+	}
+	return nil
+}
+
+//signatureStats returns the number of signatures on the zone, shards, pshards and all contained
+//assertions together with the earliest validUntil value among them. The latter is math.MaxInt64 if
+//there are no signatures.
+func signatureStats(zone *section.Zone, shards []*section.Shard, pshards []*section.Pshard) (
+	nofSigs int, earliestExpiry int64) {
+	earliestExpiry = math.MaxInt64
+	sigSections := []section.WithSig{zone}
+	for _, a := range zone.Content {
+		sigSections = append(sigSections, a)
+	}
+	for _, shard := range shards {
+		sigSections = append(sigSections, shard)
+		for _, a := range shard.Content {
+			sigSections = append(sigSections, a)
+		}
+	}
+	for _, pshard := range pshards {
+		sigSections = append(sigSections, pshard)
+	}
+	for _, s := range sigSections {
+		for _, sig := range s.AllSigs() {
+			nofSigs++
+			if sig.ValidUntil < earliestExpiry {
+				earliestExpiry = sig.ValidUntil
+			}
+		}
+	}
+	return
+}
+
+//wireSize returns the number of bytes of a message containing sections when encoded in cbor.
+func wireSize(sections []section.Section) int {
+	msg := message.Message{
+		Token:        token.New(),
+		Content:      sections,
+		Capabilities: []message.Capability{message.NoCapability},
+	}
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+		log.Warn("Was not able to encode message", "error", err)
+	}
+	return encoding.Len()
+}
@@ -0,0 +1,187 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+//testKeyID is the key identifier with which the test zones are signed.
+var testKeyID = keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519, KeyPhase: 1}
+
+//testZonefile stores a zonefile of zone ethz.ch. in dir and returns its path.
+func testZonefile(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "zonefile.txt")
+	content := ":Z: ethz.ch. . [\n    :A: www [ :ip4: 192.0.2.1 ]\n    :A: ftp [ :ip4: 192.0.2.2 ]\n]\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Was not able to store zonefile: %v", err)
+	}
+	return path
+}
+
+//testDelegation stores a delegation of zone ethz.ch. to publicKey in dir and returns its path. If
+//inZone is true, the delegation is contained in the parent zone as in the parent's zonefile.
+//Otherwise, it is stored alone as by keyManager.
+func testDelegation(t *testing.T, dir string, publicKey ed25519.PublicKey, inZone bool) string {
+	t.Helper()
+	a := &section.Assertion{
+		SubjectName: "ethz",
+		SubjectZone: "ch.",
+		Context:     ".",
+		Content: []object.Object{object.Object{
+			Type:  object.OTDelegation,
+			Value: keys.PublicKey{PublicKeyID: testKeyID, Key: publicKey},
+		}},
+	}
+	encoding := zonefile.IO{}.EncodeSection(a)
+	if inZone {
+		encoding = fmt.Sprintf(":Z: ch. . [\n    :A: ch [ :ip4: 192.0.2.3 ]\n    %s\n]\n", encoding)
+	}
+	path := filepath.Join(dir, "deleg.txt")
+	if err := ioutil.WriteFile(path, []byte(encoding), 0600); err != nil {
+		t.Fatalf("Was not able to store delegation: %v", err)
+	}
+	return path
+}
+
+//testConfig returns a config signing the zonefile at path with testKeyID.
+func testConfig(path string) Config {
+	now := time.Now()
+	return Config{
+		ZonefilePath: path,
+		MetaDataConf: MetaDataConfig{
+			AddSignatureMetaData:       true,
+			AddSigMetaDataToAssertions: true,
+			SignatureAlgorithm:         algorithmTypes.Ed25519,
+			KeyPhase:                   1,
+			SigValidSince:              now.Add(-time.Hour).Unix(),
+			SigValidUntil:              now.Add(time.Hour).Unix(),
+			SigSigningInterval:         time.Minute,
+		},
+		ConsistencyConf: ConsistencyConfig{SortZone: true},
+		DoSigning:       true,
+		MaxZoneSize:     50000,
+	}
+}
+
+func TestValidateKeys(t *testing.T) {
+	var tests = []struct {
+		config Config
+		valid  bool
+	}{
+		{Config{ZonefilePath: "zone", DoSigning: true, PrivateKeyPath: "keys"}, true},
+		{Config{ZonefilePath: "zone", DoSigning: true}, false},
+		{Config{ZonefilePath: "zone", DryRun: true, DelegationPath: "deleg"}, true},
+		{Config{ZonefilePath: "zone", DryRun: true}, false},
+		{Config{ZonefilePath: "zone", DoSigning: true, DryRun: true, DelegationPath: "deleg"}, false},
+	}
+	for i, test := range tests {
+		if err := test.config.Validate(); (err == nil) != test.valid {
+			t.Errorf("%d: unexpected validation result. expected valid=%t actual=%v", i, test.valid,
+				err)
+		}
+	}
+}
+
+func TestVerifyAgainstDelegation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+	signer := &KeySigner{keys: map[keys.PublicKeyID]interface{}{testKeyID: privateKey}}
+	zonefilePath := testZonefile(t, dir)
+
+	var tests = []struct {
+		delegated ed25519.PublicKey
+		inZone    bool
+		errMsg    string
+	}{
+		{publicKey, false, ""},
+		{publicKey, true, ""},
+		{otherKey, false, "zone signature is invalid"},
+		{otherKey, true, "zone signature is invalid"},
+	}
+	for i, test := range tests {
+		config := testConfig(zonefilePath)
+		config.DelegationPath = testDelegation(t, dir, test.delegated, test.inZone)
+		_, err := New(config, WithSigner(signer)).Sign(context.Background())
+		if test.errMsg == "" && err != nil {
+			t.Errorf("%d: verification against the delegated key failed: %v", i, err)
+		}
+		if test.errMsg != "" && (err == nil || !strings.Contains(err.Error(), test.errMsg)) {
+			t.Errorf("%d: wrong verification result. expected=%s actual=%v", i, test.errMsg, err)
+		}
+	}
+
+	//A dry run only needs the delegation, not the private keys.
+	config := testConfig(zonefilePath)
+	config.DoSigning = false
+	config.DryRun = true
+	config.DelegationPath = testDelegation(t, dir, publicKey, true)
+	zone, shards, pshards, err := New(config).prepare(context.Background(), &Summary{})
+	if err != nil {
+		t.Fatalf("dry run without signing was rejected: %v", err)
+	}
+	if report := dryRunReport(zone, shards, pshards, config.DelegationPath); report.Verified {
+		t.Errorf("unsigned zone was verified")
+	}
+}
+
+func TestDryRunReportUnsigned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	signer := &KeySigner{keys: map[keys.PublicKeyID]interface{}{testKeyID: privateKey}}
+	zonefilePath := testZonefile(t, dir)
+	delegPath := testDelegation(t, dir, publicKey, false)
+
+	//Without signature meta data, nothing is signed and the verification is skipped.
+	config := testConfig(zonefilePath)
+	config.DoSigning = false
+	config.MetaDataConf.AddSignatureMetaData = false
+	zone, shards, pshards, err := New(config).prepare(context.Background(), &Summary{})
+	if err != nil {
+		t.Fatalf("Was not able to prepare zone: %v", err)
+	}
+	report := dryRunReport(zone, shards, pshards, delegPath)
+	if report.Signatures != 0 || report.Verified || !report.VerifySkipped {
+		t.Errorf("verification of an unsigned zone was not skipped: %+v", report)
+	}
+
+	//A signed zone containing an unsigned assertion fails the verification.
+	config = testConfig(zonefilePath)
+	zone, shards, pshards, err = New(config, WithSigner(signer)).prepare(context.Background(),
+		&Summary{})
+	if err != nil {
+		t.Fatalf("Was not able to sign zone: %v", err)
+	}
+	if report := dryRunReport(zone, shards, pshards, delegPath); !report.Verified {
+		t.Fatalf("signed zone was not verified: %s", report.VerifyError)
+	}
+	zone.Content[0].DeleteAllSigs()
+	report = dryRunReport(zone, shards, pshards, delegPath)
+	if report.Verified || report.VerifySkipped ||
+		!strings.Contains(report.VerifyError, "is not signed") {
+		t.Errorf("unsigned assertion in a signed zone was not reported: %+v", report)
+	}
+}
@@ -0,0 +1,84 @@
+package publisher
+
+import (
+	"fmt"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//splitSections groups sections into chunks such that a message containing one chunk is at most
+//maxSize bytes large when encoded. The order of the sections is preserved. A zone or shard which
+//alone exceeds maxSize is replaced by its contained assertions as they are signed individually. It
+//returns an error if a section cannot be split further. If maxSize is not positive, all sections
+//are returned in one chunk.
+func splitSections(sections []section.Section, maxSize int) ([][]section.Section, error) {
+	if maxSize <= 0 {
+		return [][]section.Section{sections}, nil
+	}
+	var units []section.Section
+	var sizes []int
+	for _, s := range sections {
+		if size := wireSize([]section.Section{s}); size <= maxSize {
+			units, sizes = append(units, s), append(sizes, size)
+			continue
+		}
+		assertions := standaloneAssertions(s)
+		if len(assertions) == 0 {
+			return nil, fmt.Errorf("%T is larger than the maximum message size of %d bytes", s,
+				maxSize)
+		}
+		log.Warn("Section exceeds maximum message size, its assertions are sent separately",
+			"section", fmt.Sprintf("%T", s), "maxSize", maxSize)
+		for _, a := range assertions {
+			size := wireSize([]section.Section{a})
+			if size > maxSize {
+				return nil, fmt.Errorf("assertion %s is larger than the maximum message size of %d bytes",
+					a.FQDN(), maxSize)
+			}
+			units, sizes = append(units, a), append(sizes, size)
+		}
+	}
+	//The encoding of a message is the encoding of an empty message plus the encodings of its
+	//sections plus the growth of the content array's length header.
+	overhead := wireSize(nil)
+	var chunks [][]section.Section
+	var current []section.Section
+	size := overhead
+	for i, u := range units {
+		unitSize := sizes[i] - overhead
+		if len(current) != 0 &&
+			size+unitSize+cbor.ArrayHeaderGrowth(len(current)+1) > maxSize {
+			chunks = append(chunks, current)
+			current, size = nil, overhead
+		}
+		current = append(current, u)
+		size += unitSize
+	}
+	if len(current) != 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks, nil
+}
+
+//standaloneAssertions returns copies of the assertions contained in a zone or shard with context and
+//subject zone set such that they can be sent on their own. It returns nil for all other sections.
+func standaloneAssertions(s section.Section) []*section.Assertion {
+	var content []*section.Assertion
+	var zone, context string
+	switch s := s.(type) {
+	case *section.Zone:
+		content, zone, context = s.Content, s.SubjectZone, s.Context
+	case *section.Shard:
+		content, zone, context = s.Content, s.SubjectZone, s.Context
+	default:
+		return nil
+	}
+	var output []*section.Assertion
+	for _, a := range content {
+		output = append(output, a.Copy(context, zone))
+	}
+	return output
+}
@@ -0,0 +1,55 @@
+package publisher
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestSplitSections(t *testing.T) {
+	var sections []section.Section
+	var content []*section.Assertion
+	for i := 0; i < 300; i++ {
+		a := &section.Assertion{
+			SubjectName: fmt.Sprintf("host%d", i),
+			SubjectZone: "ethz.ch.",
+			Context:     ".",
+			Content: []object.Object{object.Object{Type: object.OTIP4Addr,
+				Value: fmt.Sprintf("192.0.2.%d", i%256)}},
+		}
+		sections = append(sections, a)
+		content = append(content, a.Copy("", ""))
+	}
+	//The zone is split into its assertions unless it fits into a message.
+	zone := &section.Zone{SubjectZone: "ethz.ch.", Context: ".", Content: content}
+	sections = append(sections, zone)
+	for _, maxSize := range []int{200, 1000, 4000, 20000} {
+		chunks, err := splitSections(sections, maxSize)
+		if err != nil {
+			t.Fatalf("%d: was not able to split sections: %v", maxSize, err)
+		}
+		count, expected := 0, 2*len(content)
+		if wireSize([]section.Section{zone}) <= maxSize {
+			expected = len(content) + 1
+		}
+		for i, chunk := range chunks {
+			if size := wireSize(chunk); size > maxSize {
+				t.Errorf("%d: chunk %d exceeds the maximum size. actual=%d", maxSize, i, size)
+			}
+			//Chunks are filled such that the next section did not fit anymore.
+			if i < len(chunks)-1 && wireSize(append(chunk[:len(chunk):len(chunk)],
+				chunks[i+1][0])) <= maxSize-8 {
+				t.Errorf("%d: chunk %d is not filled", maxSize, i)
+			}
+			count += len(chunk)
+		}
+		if count != expected {
+			t.Errorf("%d: sections are missing. expected=%d actual=%d", maxSize, expected, count)
+		}
+	}
+	if _, err := splitSections(sections, 10); err == nil {
+		t.Errorf("assertions larger than the maximum size were accepted")
+	}
+}
@@ -0,0 +1,122 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//Summary contains machine readable information about a publisher run. It is intended to be
+//consumed by monitoring systems.
+type Summary struct {
+	Zone    string
+	Context string
+	//Start and End are unix timestamps in seconds of the beginning and end of the run.
+	Start int64
+	End   int64
+	//Assertions, Shards and Pshards are the number of signed sections if signing is enabled.
+	//Otherwise, they are the number of processed sections. An assertion contained in the zone and
+	//in shards is counted once.
+	Assertions int
+	Shards     int
+	Pshards    int
+	Signed     bool
+	Signatures int
+	//Bytes is the size of the cbor encoded message containing all sections.
+	Bytes         int
+	SigningTimeMs int64
+	//NextResign is the unix timestamp in seconds at which the first signature expires. The zone
+	//must be resigned and published before this point in time. It is zero if there are no
+	//signatures.
+	NextResign int64
+	Servers    []ServerSummary
+	Success    bool
+	Error      string
+}
+
+//ServerSummary contains the outcome of pushing the zone to an authoritative server.
+type ServerSummary struct {
+	Server    string
+	Attempts  int
+	LatencyMs int64
+	Success   bool
+	Error     string
+}
+
+//Store writes the summary in json format to path. If path is "-", the summary is written to
+//stdout.
+func (s *Summary) Store(path string) error {
+	encoding, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	encoding = append(encoding, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(encoding)
+		return err
+	}
+	return ioutil.WriteFile(path, encoding, 0600)
+}
+
+//finish sets the end time and the overall outcome of the run.
+func (s *Summary) finish(err error) {
+	s.End = time.Now().Unix()
+	s.Success = err == nil
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+//addSectionStats adds the number of sections and information about their signatures to s.
+func (s *Summary) addSectionStats(zone *section.Zone, shards []*section.Shard,
+	pshards []*section.Pshard, signed bool) {
+	s.Assertions = countAssertions(zone, shards)
+	s.Shards = len(shards)
+	s.Pshards = len(pshards)
+	s.Signed = signed
+	var earliestExpiry int64
+	s.Signatures, earliestExpiry = signatureStats(zone, shards, pshards)
+	if earliestExpiry != math.MaxInt64 {
+		s.NextResign = earliestExpiry
+	}
+}
+
+//countAssertions returns the number of distinct assertions contained in zone and shards. The
+//copies of an assertion only differ in their signatures and in whether subject zone and context are
+//set, which is why they are identified by name and content.
+func countAssertions(zone *section.Zone, shards []*section.Shard) int {
+	assertions := make(map[string]bool)
+	add := func(content []*section.Assertion) {
+		for _, a := range content {
+			assertions[fmt.Sprintf("%s_%v", a.SubjectName, a.Content)] = true
+		}
+	}
+	add(zone.Content)
+	for _, shard := range shards {
+		add(shard.Content)
+	}
+	return len(assertions)
+}
+
+//addPushResults adds the outcome of the push per server to s.
+func (s *Summary) addPushResults(results map[string]pushResult) {
+	for server, result := range results {
+		summary := ServerSummary{
+			Server:    server,
+			Attempts:  result.Attempts,
+			LatencyMs: result.Latency.Nanoseconds() / int64(time.Millisecond),
+			Success:   result.Err == nil,
+		}
+		if result.Err != nil {
+			summary.Error = result.Err.Error()
+		}
+		s.Servers = append(s.Servers, summary)
+	}
+	sort.Slice(s.Servers, func(i, j int) bool { return s.Servers[i].Server < s.Servers[j].Server })
+}
@@ -0,0 +1,43 @@
+package publisher
+
+import (
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+)
+
+func TestAddSectionStats(t *testing.T) {
+	assertion := func(name, ip string) *section.Assertion {
+		return &section.Assertion{
+			SubjectName: name,
+			Content:     []object.Object{object.Object{Type: object.OTIP4Addr, Value: ip}},
+		}
+	}
+	www, ftp := assertion("www", "192.0.2.1"), assertion("ftp", "192.0.2.2")
+	zone := &section.Zone{SubjectZone: "ethz.ch.", Context: ".",
+		Content: []*section.Assertion{www, ftp}}
+	//Copies in shards carry their own signatures, subject zone and context.
+	signed := www.Copy(".", "ethz.ch.")
+	signed.AddSig(signature.Sig{ValidUntil: 1})
+	var tests = []struct {
+		shards     []*section.Shard
+		assertions int
+	}{
+		{nil, 2},
+		{[]*section.Shard{&section.Shard{Content: []*section.Assertion{www, ftp}}}, 2},
+		{[]*section.Shard{&section.Shard{Content: []*section.Assertion{signed}},
+			&section.Shard{Content: []*section.Assertion{ftp}}}, 2},
+		{[]*section.Shard{&section.Shard{Content: []*section.Assertion{
+			assertion("www", "192.0.2.3")}}}, 3},
+	}
+	for i, test := range tests {
+		s := &Summary{}
+		s.addSectionStats(zone, test.shards, nil, false)
+		if s.Assertions != test.assertions || s.Shards != len(test.shards) {
+			t.Errorf("%d: wrong counts. expected=%d/%d actual=%d/%d", i, test.assertions,
+				len(test.shards), s.Assertions, s.Shards)
+		}
+	}
+}
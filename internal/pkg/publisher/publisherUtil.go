@@ -1,21 +1,38 @@
 package publisher
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	log "github.com/inconshreveable/log15"
+	"gopkg.in/yaml.v2"
 
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/keyManager"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
-	"github.com/netsec-ethz/rains/internal/pkg/siglib"
 	"golang.org/x/crypto/ed25519"
 )
 
-//LoadConfig loads configuration information from configPath
+//envPrefix is the prefix of environment variables overriding path configurations.
+const envPrefix = "RAINSPUB_"
+
+//LoadConfig loads configuration information from configPath. The format is determined by the file
+//extension: .yaml and .yml files are parsed as YAML, .toml files as TOML and all others as JSON.
+//Unknown keys result in an error. The paths of the config file are overridden by the environment,
+//see overrideFromEnv.
 func LoadConfig(configPath string) (Config, error) {
 	var config Config
 	file, err := ioutil.ReadFile(configPath)
@@ -23,42 +40,185 @@ func LoadConfig(configPath string) (Config, error) {
 		log.Error("Could not open config file...", "path", configPath, "error", err)
 		return Config{}, err
 	}
-	if err = json.Unmarshal(file, &config); err != nil {
-		log.Error("Could not unmarshal json format of config", "error", err)
+	if len(bytes.TrimSpace(file)) == 0 {
+		log.Error("Config file is empty", "path", configPath)
+		return Config{}, fmt.Errorf("config file %s is empty", configPath)
+	}
+	data, err := configToJSON(configPath, file)
+	if err != nil {
+		log.Error("Could not parse config", "path", configPath, "error", err)
+		return Config{}, err
+	}
+	if err = json.NewDecoder(bytes.NewReader(data)).Decode(&config); err != nil {
+		err = configDecodeError(configPath, data, err)
+		log.Error("Could not unmarshal config", "error", err)
+		return Config{}, err
+	}
+	if key := unknownKey(data, reflect.TypeOf(config)); key != "" {
+		err = fmt.Errorf("%s: unknown key %q", configPath, key)
+		log.Error("Could not unmarshal config", "error", err)
 		return Config{}, err
 	}
+	overrideFromEnv(&config)
 	config.MetaDataConf.SigSigningInterval *= time.Second
+	config.PushBackoff *= time.Second
+	config.PushAckTimeout *= time.Second
 	return config, nil
 }
 
+//configToJSON returns the json encoding of the YAML or TOML encoded file. Json encoded files are
+//returned unmodified.
+func configToJSON(path string, file []byte) ([]byte, error) {
+	var value interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(file, &value); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		value = yamlToJSONValue(value)
+	case ".toml":
+		m := make(map[string]interface{})
+		if _, err := toml.Decode(string(file), &m); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		value = m
+	default:
+		return file, nil
+	}
+	return json.Marshal(value)
+}
+
+//yamlToJSONValue converts the maps with interface keys created by the yaml decoder to maps with
+//string keys such that they can be encoded in json.
+func yamlToJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for key, val := range v {
+			m[fmt.Sprint(key)] = yamlToJSONValue(val)
+		}
+		return m
+	case []interface{}:
+		for i, val := range v {
+			v[i] = yamlToJSONValue(val)
+		}
+	}
+	return value
+}
+
+//configDecodeError returns an error describing err in terms of the config file at path.
+func configDecodeError(path string, data []byte, err error) error {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		line := bytes.Count(data[:e.Offset], []byte("\n")) + 1
+		return fmt.Errorf("%s: syntax error in line %d: %v", path, line, e)
+	case *json.UnmarshalTypeError:
+		return fmt.Errorf("%s: value of key %s must be of type %v", path, e.Field, e.Type)
+	}
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return fmt.Errorf("%s: unexpected end of file, the config is incomplete", path)
+	}
+	return fmt.Errorf("%s: %v", path, err)
+}
+
+//unknownKey returns the first key of the json object data, or of an object nested in it, which
+//does not match a field of the struct type t or of the struct type of the matching field. Objects
+//in arrays are checked against the element type. Like the json decoder, it matches keys with field
+//names case-insensitively. It returns an empty string if all keys are known.
+func unknownKey(data []byte, t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(jsonUnmarshaler) {
+		return ""
+	}
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		var elements []json.RawMessage
+		json.Unmarshal(data, &elements)
+		for _, element := range elements {
+			if key := unknownKey(element, t.Elem()); key != "" {
+				return key
+			}
+		}
+		return ""
+	}
+	if t.Kind() != reflect.Struct {
+		return ""
+	}
+	var object map[string]json.RawMessage
+	if json.Unmarshal(data, &object) != nil {
+		return ""
+	}
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field, ok := jsonField(t, key)
+		if !ok {
+			return key
+		}
+		if nested := unknownKey(object[key], field.Type); nested != "" {
+			return nested
+		}
+	}
+	return ""
+}
+
+//jsonUnmarshaler is the type of json.Unmarshaler.
+var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+//jsonField returns the exported field of the struct type t into which the json decoder stores the
+//value of key.
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		if field.PkgPath == "" && strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+//overrideFromEnv overwrites the path configurations of config, i.e. its string fields whose name
+//ends with Path, with the values of the environment variables RAINSPUB_<NAME> where NAME is the
+//field's name in upper case, e.g. RAINSPUB_ZONEFILEPATH.
+func overrideFromEnv(config *Config) {
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Type.Kind() != reflect.String || !strings.HasSuffix(field.Name, "Path") {
+			continue
+		}
+		name := envPrefix + strings.ToUpper(field.Name)
+		if env, ok := os.LookupEnv(name); ok {
+			log.Info("Config value overridden by environment", "variable", name)
+			v.Field(i).SetString(env)
+		}
+	}
+}
+
 //LoadPrivateKeys reads private keys from the path provided in the config and returns a map from
-//PublicKeyID to the corresponding private key data.
+//PublicKeyID to the corresponding private key data. The keys are either pem encoded and encrypted
+//as generated by the keyManager or stored in plaintext json format. The passphrase of encrypted
+//keys is read from the environment variable RAINSPUB_KEY_PASSPHRASE or, if it is not set, from
+//the terminal, see keyManager.Passphrase.
 func LoadPrivateKeys(path string) (map[keys.PublicKeyID]interface{}, error) {
-	var privateKeys []keys.PrivateKey
-	file, err := ioutil.ReadFile(path)
+	privateKeys, err := keyManager.LoadPrivateKeys(path, keyManager.Passphrase)
 	if err != nil {
-		log.Error("Could not open config file...", "path", path, "error", err)
-		return nil, err
-	}
-	if err = json.Unmarshal(file, &privateKeys); err != nil {
-		log.Error("Could not unmarshal json format of private keys", "error", err)
+		log.Error("Was not able to load private keys", "path", path, "error", err)
 		return nil, err
 	}
 	output := make(map[keys.PublicKeyID]interface{})
-	for _, keyData := range privateKeys {
-		keyString := keyData.Key.(string)
-		privateKey := make([]byte, hex.DecodedLen(len([]byte(keyString))))
-		privateKey, err := hex.DecodeString(keyString)
-		if err != nil {
-			log.Error("Was not able to decode privateKey", "error", err)
-			return nil, err
-		}
-		if len(privateKey) != ed25519.PrivateKeySize {
-			log.Error("Private key length is incorrect", "expected", ed25519.PrivateKeySize,
-				"actual", len(privateKey))
-			return nil, errors.New("incorrect private key length")
-		}
-		output[keyData.PublicKeyID] = ed25519.PrivateKey(privateKey)
+	for _, key := range privateKeys {
+		output[key.PublicKeyID] = key.Key
 	}
 	return output, nil
 }
@@ -78,17 +238,17 @@ func StorePrivateKey(path string, privateKeys []keys.PrivateKey) error {
 //subjectZone and context to the contained assertions before signing them and removes them after the
 //signatures have been added. It returns an error if it was unable to sign the zone or any of the
 //contained assertions.
-func signZone(zone *section.Zone, keys map[keys.PublicKeyID]interface{}) error {
+func signZone(zone *section.Zone, signer Signer) error {
 	if zone == nil {
 		return errors.New("zone is nil")
 	}
 	zone.DontAddSigInMarshaller()
-	if err := signSection(zone, keys); err != nil {
+	if err := signSection(zone, signer); err != nil {
 		return err
 	}
 	zone.AddCtxAndZoneToContent()
 	for _, a := range zone.Content {
-		if err := signSection(a, keys); err != nil {
+		if err := signSection(a, signer); err != nil {
 			return err
 		}
 	}
@@ -100,17 +260,17 @@ func signZone(zone *section.Zone, keys map[keys.PublicKeyID]interface{}) error {
 //signShard signs the shard and all contained assertions with the zone's private key. It removes the
 //subjectZone and context of the contained assertions after the signatures have been added. It
 //returns an error if it was unable to sign the shard or any of the assertions.
-func signShard(s *section.Shard, keys map[keys.PublicKeyID]interface{}) error {
+func signShard(s *section.Shard, signer Signer) error {
 	if s == nil {
 		return errors.New("shard is nil")
 	}
 	s.DontAddSigInMarshaller()
-	if err := signSection(s, keys); err != nil {
+	if err := signSection(s, signer); err != nil {
 		return err
 	}
 	s.AddCtxAndZoneToContent()
 	for _, a := range s.Content {
-		if err := signSection(a, keys); err != nil {
+		if err := signSection(a, signer); err != nil {
 			return err
 		}
 	}
@@ -121,17 +281,18 @@ func signShard(s *section.Shard, keys map[keys.PublicKeyID]interface{}) error {
 
 //signSection computes the signature data for all contained signatures.
 //It returns an error if it was unable to create all signatures on the assertion.
-func signSection(s section.WithSigForward, keys map[keys.PublicKeyID]interface{}) error {
+func signSection(s section.WithSigForward, signer Signer) error {
 	if s == nil {
 		return errors.New("section is nil")
 	}
 	sigs := s.AllSigs()
 	s.DeleteAllSigs()
 	for _, sig := range sigs {
-		if sig.ValidUntil < time.Now().Unix() {
+		if sig.ValidUntil < clock.Now().Unix() {
 			log.Error("Signature validUntil is in the past")
-		} else if ok := siglib.SignSectionUnsafe(s, keys[sig.PublicKeyID], sig); !ok {
-			log.Error("Was not able to sign and add the signature", "section", s, "signature", sig)
+		} else if err := signer.Sign(s, sig); err != nil {
+			log.Error("Was not able to sign and add the signature", "section", s, "signature", sig,
+				"error", err)
 		} else {
 			continue
 		}
@@ -0,0 +1,126 @@
+package publisher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOverrideFromEnv(t *testing.T) {
+	env := map[string]string{
+		"RAINSPUB_ZONEFILEPATH":   "env/zonefile",
+		"RAINSPUB_DELEGATIONPATH": "env/delegation",
+		"RAINSPUB_AUDITLOGPATH":   "env/audit.log",
+		"RAINSPUB_DIFFSTATEPATH":  "env/diffstate",
+	}
+	for name, value := range env {
+		if previous, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, previous)
+		} else {
+			defer os.Unsetenv(name)
+		}
+		os.Setenv(name, value)
+	}
+	config := Config{ZonefilePath: "zonefile", DelegationPath: "delegation",
+		PrivateKeyPath: "private.pem", DiffStatePath: "diffstate"}
+	overrideFromEnv(&config)
+	expected := Config{ZonefilePath: "env/zonefile", DelegationPath: "env/delegation",
+		PrivateKeyPath: "private.pem", AuditLogPath: "env/audit.log", DiffStatePath: "env/diffstate"}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("wrong paths. expected=%+v actual=%+v", expected, config)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	expected := Config{ZonefilePath: "zone.txt", PushBackoff: 2 * time.Second,
+		ShardingConf: ShardingConfig{DoSharding: true, MaxShardSize: 1000}}
+	var tests = []struct {
+		file   string
+		config string
+		err    string
+	}{
+		{"valid.json", `{"ZonefilePath": "zone.txt", "PushBackoff": 2,
+			"ShardingConf": {"DoSharding": true, "MaxShardSize": 1000}}`, ""},
+		{"valid.yaml", "ZonefilePath: zone.txt\nPushBackoff: 2\nShardingConf:\n" +
+			"  DoSharding: true\n  MaxShardSize: 1000\n", ""},
+		{"valid.toml", "ZonefilePath = \"zone.txt\"\nPushBackoff = 2\n[ShardingConf]\n" +
+			"DoSharding = true\nMaxShardSize = 1000\n", ""},
+		{"unknown.json", `{"ZonefilePath": "zone.txt", "ZoneFile": "zone.txt"}`,
+			`unknown key "ZoneFile"`},
+		{"unknown.yml", "ZonefilePath: zone.txt\nZoneFile: zone.txt\n", `unknown key "ZoneFile"`},
+		{"nested.json", `{"ZonefilePath": "zone.txt", "ShardingConf": {"MaxShards": 3}}`,
+			`unknown key "MaxShards"`},
+		{"unknown.toml", "ZonefilePath = \"zone.txt\"\nZoneFile = \"zone.txt\"\n",
+			`unknown key "ZoneFile"`},
+		{"type.yaml", "PushRetries: three\n", "value of key PushRetries must be of type int"},
+		{"syntax.json", "{\n\"ZonefilePath\": \"zone.txt\",\n}", "syntax error in line 3"},
+		{"truncated.json", `{"ZonefilePath": `, "unexpected end of file"},
+		{"syntax.yaml", "ZonefilePath: [zone.txt\n", "syntax.yaml: yaml:"},
+		{"syntax.toml", "ZonefilePath = zone.txt\n", "syntax.toml:"},
+		{"empty.yaml", " \n", "is empty"},
+	}
+	for i, test := range tests {
+		path := filepath.Join(dir, test.file)
+		if err := ioutil.WriteFile(path, []byte(test.config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadConfig(path)
+		if test.err == "" {
+			if err != nil || !reflect.DeepEqual(config, expected) {
+				t.Errorf("%d: wrong config. expected=%+v actual=%+v error=%v", i, expected, config,
+					err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%d: wrong error. expected=%s actual=%v", i, test.err, err)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	var tests = []struct {
+		config Config
+		err    string
+	}{
+		{Config{ZonefilePath: "zone.txt"}, ""},
+		{Config{}, "ZonefilePath must be set"},
+		{Config{ZonefilePath: "zone.txt", DoSigning: true},
+			"PrivateKeyPath must be set when DoSigning is set"},
+		{Config{ZonefilePath: "zone.txt", DryRun: true},
+			"DelegationPath must be set when DryRun is set"},
+		{Config{ZonefilePath: "zone.txt", DoPublish: true},
+			"AuthServers must not be empty when DoPublish is set"},
+		{Config{ZonefilePath: "zone.txt", DoPublish: true,
+			DiscoveryConf: DiscoveryConfig{DoDiscovery: true}},
+			"RootServers or Forwarders must be set when DoDiscovery is set"},
+		{Config{ZonefilePath: "zone.txt", DiffPush: true},
+			"DiffStatePath must be set when DiffPush is set"},
+		{Config{ZonefilePath: "zone.txt", PushRetries: -1},
+			"PushRetries, PushBackoff and PushAckTimeout must not be negative"},
+		//all problems are reported at once
+		{Config{DryRun: true}, "ZonefilePath must be set; DelegationPath must be set"},
+	}
+	for i, test := range tests {
+		err := test.config.Validate()
+		if test.err == "" && err != nil {
+			t.Errorf("%d: valid config was rejected: %v", i, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%d: wrong error. expected=%s actual=%v", i, test.err, err)
+		}
+	}
+	config := Config{ZonefilePath: "zone.txt", DoSigning: true}
+	if err := config.validate(false); err != nil {
+		t.Errorf("PrivateKeyPath is required with a custom signer: %v", err)
+	}
+}
@@ -0,0 +1,332 @@
+package publisher
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	cbor "github.com/britram/borat"
+
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+//VerifyFailure describes a problem of a section found by VerifyZone.
+type VerifyFailure struct {
+	Section string `json:"section"`
+	Problem string `json:"problem"`
+}
+
+//String implements Stringer interface
+func (f VerifyFailure) String() string {
+	return fmt.Sprintf("%s: %s", f.Section, f.Problem)
+}
+
+//zoneVerifier collects the failures found while verifying the sections of a zone.
+type zoneVerifier struct {
+	pkeys    map[keys.PublicKeyID][]keys.PublicKey
+	now      int64
+	failures []VerifyFailure
+}
+
+//VerifyZone checks the sections of a signed zone as they are published and returns all failures
+//found. It verifies the signatures of all sections and contained assertions against pkeys at time
+//now, that shards only contain assertions within their range, that pshards' bloom filters contain
+//all assertions within their range, that all sections belong to the same zone and context, and that
+//the content of all sections is in canonical order. The sections are not modified.
+func VerifyZone(sections []section.WithSigForward, pkeys map[keys.PublicKeyID][]keys.PublicKey,
+	now int64) []VerifyFailure {
+	v := &zoneVerifier{pkeys: pkeys, now: now}
+	if len(sections) == 0 {
+		v.fail("zone", "no sections to verify")
+		return v.failures
+	}
+	zone, ctx := sections[0].GetSubjectZone(), sections[0].GetContext()
+	nofZones := 0
+	for _, s := range sections {
+		if z, ok := s.(*section.Zone); ok {
+			if nofZones == 0 {
+				zone, ctx = z.SubjectZone, z.Context
+			}
+			nofZones++
+		}
+	}
+	if nofZones == 0 {
+		v.fail("zone "+zone, "zone section is missing")
+	} else if nofZones > 1 {
+		v.fail("zone "+zone, fmt.Sprintf("found %d zone sections instead of one", nofZones))
+	}
+	var assertions []*section.Assertion
+	var shards []*section.Shard
+	var pshards []*section.Pshard
+	for _, s := range sections {
+		desc := describe(s)
+		if s.GetSubjectZone() != zone || s.GetContext() != ctx {
+			v.fail(desc, fmt.Sprintf("zone and context differ from zone %s in context %s", zone, ctx))
+		}
+		switch s := s.(type) {
+		case *section.Assertion:
+			v.verifySignatures(s, desc)
+			v.verifyObjectOrder(s, desc)
+			assertions = append(assertions, s)
+		case *section.Shard:
+			v.verifyShard(s, desc)
+			assertions = append(assertions, s.Content...)
+			shards = append(shards, s)
+		case *section.Zone:
+			v.verifyZoneSection(s, desc)
+			assertions = append(assertions, s.Content...)
+		case *section.Pshard:
+			rangeFrom, rangeTo := s.RangeFrom, s.RangeTo
+			s.RangeFrom, s.RangeTo = openRange(rangeFrom, rangeTo)
+			v.verifySignatures(s, desc)
+			s.RangeFrom, s.RangeTo = rangeFrom, rangeTo
+			pshards = append(pshards, s)
+		default:
+			v.fail(desc, "unsupported section type")
+		}
+	}
+	for i := 1; i < len(shards); i++ {
+		if shards[i-1].CompareTo(shards[i]) > 0 {
+			v.fail(describe(shards[i]), "shard is not in canonical order, it must precede "+
+				describe(shards[i-1]))
+		}
+	}
+	for i := 1; i < len(pshards); i++ {
+		if pshards[i-1].CompareTo(pshards[i]) > 0 {
+			v.fail(describe(pshards[i]), "pshard is not in canonical order, it must precede "+
+				describe(pshards[i-1]))
+		}
+	}
+	for _, p := range pshards {
+		v.verifyBloomFilter(p, describe(p), assertions)
+	}
+	return v.failures
+}
+
+func (v *zoneVerifier) fail(section, problem string) {
+	v.failures = append(v.failures, VerifyFailure{Section: section, Problem: problem})
+}
+
+//verifyZoneSection checks the signatures of z and its assertions and the order of its content.
+func (v *zoneVerifier) verifyZoneSection(z *section.Zone, desc string) {
+	z.DontAddSigInMarshaller()
+	v.verifySignatures(z, desc)
+	z.AddSigInMarshaller()
+	v.verifyContent(z.Content, z.SubjectZone, z.Context, desc)
+}
+
+//verifyShard checks the signatures of s and its assertions, the order of its content and that all
+//assertions are within the shard's range.
+func (v *zoneVerifier) verifyShard(s *section.Shard, desc string) {
+	rangeFrom, rangeTo := s.RangeFrom, s.RangeTo
+	s.RangeFrom, s.RangeTo = openRange(rangeFrom, rangeTo)
+	s.DontAddSigInMarshaller()
+	v.verifySignatures(s, desc)
+	s.AddSigInMarshaller()
+	s.RangeFrom, s.RangeTo = rangeFrom, rangeTo
+	for _, a := range s.Content {
+		if !s.InRange(a.SubjectName) {
+			v.fail(describeContained(a, desc), "assertion is outside of the shard's range")
+		}
+	}
+	v.verifyContent(s.Content, s.SubjectZone, s.Context, desc)
+}
+
+//verifyContent checks the signatures and the order of assertions contained in the section
+//described by desc which has the given zone and context.
+func (v *zoneVerifier) verifyContent(assertions []*section.Assertion, zone, ctx, desc string) {
+	for i, a := range assertions {
+		aDesc := describeContained(a, desc)
+		if a.SubjectZone != "" || a.Context != "" {
+			v.fail(aDesc, "contained assertion must not have a subject zone or context")
+		}
+		if i > 0 {
+			if cmp := assertions[i-1].CompareTo(a); cmp > 0 {
+				v.fail(aDesc, "assertion is not in canonical order, it must precede "+
+					describeContained(assertions[i-1], desc))
+			} else if cmp == 0 {
+				v.fail(aDesc, "assertion is contained twice")
+			}
+		}
+		//The signatures of contained assertions are computed over the assertion including the
+		//zone and context of the enclosing section.
+		subjectZone, context := a.SubjectZone, a.Context
+		a.SubjectZone, a.Context = zone, ctx
+		v.verifySignatures(a, aDesc)
+		a.SubjectZone, a.Context = subjectZone, context
+		v.verifyObjectOrder(a, aDesc)
+	}
+}
+
+//verifyObjectOrder checks that the objects of a are in canonical order.
+func (v *zoneVerifier) verifyObjectOrder(a *section.Assertion, desc string) {
+	for i, o := range a.Content {
+		if i > 0 && a.Content[i-1].CompareTo(o) > 0 {
+			v.fail(desc, fmt.Sprintf("object of type %s is not in canonical order", o.Type))
+		}
+		if name, ok := o.Value.(object.Name); ok && !sort.SliceIsSorted(name.Types,
+			func(i, j int) bool { return name.Types[i] < name.Types[j] }) {
+			v.fail(desc, "types of name object are not in canonical order")
+		}
+	}
+}
+
+//verifySignatures checks that s has at least one signature and that each of its signatures is
+//currently valid and verifies against a public key of v. The signatures of s are restored
+//afterwards.
+func (v *zoneVerifier) verifySignatures(s section.WithSig, desc string) {
+	if !siglib.CheckStringFields(s) {
+		v.fail(desc, "a string field contains a zonefile type marker")
+	}
+	sigs := s.AllSigs()
+	if len(sigs) == 0 {
+		v.fail(desc, "section is not signed")
+		return
+	}
+	s.DeleteAllSigs()
+	encoding := new(bytes.Buffer)
+	err := s.MarshalCBOR(cbor.NewCBORWriter(encoding))
+	for _, sig := range sigs {
+		s.AddSig(sig)
+	}
+	if err != nil {
+		v.fail(desc, fmt.Sprintf("was not able to encode section: %v", err))
+		return
+	}
+	for _, sig := range sigs {
+		sigDesc := fmt.Sprintf("signature with key %s", sig.PublicKeyID)
+		if sig.ValidUntil < v.now {
+			v.fail(desc, sigDesc+" is expired")
+		} else if sig.ValidSince > v.now {
+			v.fail(desc, sigDesc+" is not yet valid")
+		}
+		key, ok := v.publicKey(sig.MetaData())
+		if !ok {
+			v.fail(desc, "no public key to verify "+sigDesc)
+			continue
+		}
+		if !sig.VerifySignature(key.Key, encoding.Bytes()) {
+			v.fail(desc, sigDesc+" does not verify")
+		}
+	}
+}
+
+//publicKey returns a public key of v matching the key identifier of the signature's meta data
+//whose validity overlaps with the signature's.
+func (v *zoneVerifier) publicKey(meta signature.MetaData) (keys.PublicKey, bool) {
+	for _, key := range v.pkeys[meta.PublicKeyID] {
+		if key.ValidSince <= meta.ValidUntil && key.ValidUntil >= meta.ValidSince {
+			return key, true
+		}
+	}
+	return keys.PublicKey{}, false
+}
+
+//verifyBloomFilter checks that the bloom filter of p contains all types of all assertions within
+//p's range.
+func (v *zoneVerifier) verifyBloomFilter(p *section.Pshard, desc string,
+	assertions []*section.Assertion) {
+	r := *p
+	r.RangeFrom, r.RangeTo = openRange(p.RangeFrom, p.RangeTo)
+	for _, a := range assertions {
+		if !r.InRange(a.SubjectName) {
+			continue
+		}
+		for _, o := range a.Content {
+			ok, err := p.BloomFilter.Contains(a.SubjectName, p.SubjectZone, p.Context, o.Type)
+			if err != nil {
+				v.fail(desc, fmt.Sprintf("was not able to query bloom filter: %v", err))
+				return
+			}
+			if !ok {
+				v.fail(desc, fmt.Sprintf("bloom filter does not contain %s of type %s", a.SubjectName,
+					o.Type))
+			}
+		}
+	}
+}
+
+//openRange returns the range boundaries as they are signed. Zonefiles denote an open range with <
+//and > while the publisher signs it as an empty string.
+func openRange(rangeFrom, rangeTo string) (string, string) {
+	if rangeFrom == "<" {
+		rangeFrom = ""
+	}
+	if rangeTo == ">" {
+		rangeTo = ""
+	}
+	return rangeFrom, rangeTo
+}
+
+//describe returns a short human readable identification of s.
+func describe(s section.Section) string {
+	switch s := s.(type) {
+	case *section.Assertion:
+		return fmt.Sprintf("assertion %s", s.FQDN())
+	case *section.Shard:
+		return fmt.Sprintf("shard %s [%s,%s]", s.SubjectZone, s.RangeFrom, s.RangeTo)
+	case *section.Pshard:
+		return fmt.Sprintf("pshard %s [%s,%s]", s.SubjectZone, s.RangeFrom, s.RangeTo)
+	case *section.Zone:
+		return fmt.Sprintf("zone %s", s.SubjectZone)
+	default:
+		return fmt.Sprintf("%T", s)
+	}
+}
+
+//describeContained returns a short human readable identification of a contained in the section
+//described by parent.
+func describeContained(a *section.Assertion, parent string) string {
+	return fmt.Sprintf("assertion %s in %s", a.SubjectName, parent)
+}
+
+//LoadDelegatedKeys returns the public keys delegated to zone by the delegation assertions in the
+//zonefile at path, e.g. the name_deleg.txt file generated by keyManager or the parent's zonefile.
+//An assertion outside of a zone, as in name_deleg.txt, delegates to zone if its subject name is the
+//first label of zone. The keys are valid at all times. It returns an error if the zonefile contains
+//no delegation for zone.
+func LoadDelegatedKeys(path, zone string) (map[keys.PublicKeyID][]keys.PublicKey, error) {
+	sections, err := zonefile.IO{}.LoadZonefile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Was not able to load delegations %s: %v", path, err)
+	}
+	var assertions []*section.Assertion
+	for _, s := range sections {
+		switch s := s.(type) {
+		case *section.Assertion:
+			assertions = append(assertions, s)
+		case *section.Shard:
+			s.AddCtxAndZoneToContent()
+			assertions = append(assertions, s.Content...)
+		case *section.Zone:
+			s.AddCtxAndZoneToContent()
+			assertions = append(assertions, s.Content...)
+		}
+	}
+	zone = strings.TrimSuffix(zone, ".")
+	label := strings.SplitN(zone, ".", 2)[0]
+	pkeys := make(map[keys.PublicKeyID][]keys.PublicKey)
+	for _, a := range assertions {
+		if a.SubjectZone == "" && a.SubjectName != label ||
+			a.SubjectZone != "" && strings.TrimSuffix(a.FQDN(), ".") != zone {
+			continue
+		}
+		for _, o := range a.Content {
+			if key, ok := o.Value.(keys.PublicKey); ok && o.Type == object.OTDelegation {
+				key.ValidSince, key.ValidUntil = 0, math.MaxInt64
+				pkeys[key.PublicKeyID] = append(pkeys[key.PublicKeyID], key)
+			}
+		}
+	}
+	if len(pkeys) == 0 {
+		return nil, fmt.Errorf("%s contains no delegation for zone %s", path, zone)
+	}
+	return pkeys, nil
+}
@@ -1,12 +1,14 @@
 package query
 
 import (
-	"errors"
 	"fmt"
 	"sort"
+	"strconv"
+	"strings"
 
 	cbor "github.com/britram/borat"
 
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
 )
 
@@ -21,60 +23,79 @@ type Name struct {
 	CurrentTime int64
 }
 
-// UnmarshalMap unpacks a CBOR marshaled map to this struct.
+// UnmarshalMap unpacks a CBOR marshaled map to this struct. It returns a *cbor.DecodeError
+// identifying the offending key if m is malformed.
 func (q *Name) UnmarshalMap(m map[int]interface{}) error {
-	if n, ok := m[6].(string); ok {
-		q.Context = n
-	} else {
-		return errors.New("cbor query map does not contain a context name")
-	}
-	if n, ok := m[8].(string); ok {
-		q.Name = n
-	} else {
-		return errors.New("cbor query map does not contain a fqdn name")
-	}
-	q.Types = make([]object.Type, 0)
-	if types, ok := m[10].([]interface{}); ok {
-		for _, qt := range types {
-			t, ok := qt.(int)
-			if !ok {
-				return errors.New("cbor query encoding of a type array's element should be an int")
-			}
-			q.Types = append(q.Types, object.Type(t))
+	if err := rcbor.CheckKeys("query", m, 6, 8, 10, 12, 13, 14, 17); err != nil {
+		return err
+	}
+	ints := make(map[int]int)
+	for _, key := range []int{12, 14, 17} {
+		val, ok := m[key]
+		if !ok {
+			return rcbor.MissingKey("query", key)
 		}
-	} else {
-		return errors.New("cbor query map does not contain a types array")
-	}
-	if exp, ok := m[12].(int); ok {
-		q.Expiration = int64(exp)
-	} else {
-		return errors.New("cbor query map does not contain an expiration")
-	}
-	q.Options = make([]Option, 0)
-	if opts, ok := m[13].([]interface{}); ok {
-		for _, opt := range opts {
-			o, ok := opt.(int)
-			if !ok {
-				return errors.New("cbor query encoding of a option array's element should be an int")
-			}
-			q.Options = append(q.Options, Option(o))
+		if ints[key], ok = val.(int); !ok {
+			return rcbor.WrongType("query", key, "int")
 		}
-	} else {
-		return errors.New("cbor query map does not contain a query options array")
 	}
-	if ct, ok := m[14].(int); ok {
-		q.CurrentTime = int64(ct)
-	} else {
-		return errors.New("cbor query map does not contain the current time")
+	if ints[17] < 0 {
+		return rcbor.OutOfRange("query", 17, ints[17])
 	}
-	var ok bool
-	q.KeyPhase, ok = m[17].(int)
-	if !ok {
-		return errors.New("cbor query encoding of the key phase should be an int")
+	strs := make(map[int]string)
+	for _, key := range []int{6, 8} {
+		val, ok := m[key]
+		if !ok {
+			return rcbor.MissingKey("query", key)
+		}
+		if strs[key], ok = val.(string); !ok {
+			return rcbor.WrongType("query", key, "string")
+		}
+	}
+	types, err := unmarshalInts(m, 10, int(object.OTName), int(object.OTNextKey))
+	if err != nil {
+		return err
+	}
+	opts, err := unmarshalInts(m, 13, int(QOMinE2ELatency), int(QONoProactiveCaching))
+	if err != nil {
+		return err
+	}
+	q.Context, q.Name = strs[6], strs[8]
+	q.Expiration, q.CurrentTime, q.KeyPhase = int64(ints[12]), int64(ints[14]), ints[17]
+	q.Types = make([]object.Type, len(types))
+	for i, t := range types {
+		q.Types[i] = object.Type(t)
+	}
+	q.Options = make([]Option, len(opts))
+	for i, o := range opts {
+		q.Options[i] = Option(o)
 	}
 	return nil
 }
 
+//unmarshalInts decodes the mandatory array at key of the CBOR map m whose elements must lie
+//between min and max.
+func unmarshalInts(m map[int]interface{}, key, min, max int) ([]int, error) {
+	val, ok := m[key]
+	if !ok {
+		return nil, rcbor.MissingKey("query", key)
+	}
+	elems, ok := val.([]interface{})
+	if !ok {
+		return nil, rcbor.WrongType("query", key, "array")
+	}
+	result := make([]int, len(elems))
+	for i, elem := range elems {
+		if result[i], ok = elem.(int); !ok {
+			return nil, rcbor.WrongType("query", key, "array of ints")
+		}
+		if result[i] < min || result[i] > max {
+			return nil, rcbor.OutOfRange("query", key, result[i])
+		}
+	}
+	return result, nil
+}
+
 // MarshalCBOR implements the CBORMarshaler interface.
 func (q *Name) MarshalCBOR(w *cbor.CBORWriter) error {
 	m := make(map[int]interface{})
@@ -199,3 +220,91 @@ const (
 	QONoVerificationDelegation Option = 7
 	QONoProactiveCaching       Option = 8
 )
+
+//optionNames contains the symbolic names of the query options.
+var optionNames = map[Option]string{
+	QOMinE2ELatency:            "min-latency",
+	QOMinLastHopAnswerSize:     "min-answer-size",
+	QOMinInfoLeakage:           "min-info-leakage",
+	QOCachedAnswersOnly:        "cached-only",
+	QOExpiredAssertionsOk:      "expired-ok",
+	QOTokenTracing:             "token-tracing",
+	QONoVerificationDelegation: "no-verification-delegation",
+	QONoProactiveCaching:       "no-proactive-caching",
+}
+
+//optionDescriptions contains a short description of each query option.
+var optionDescriptions = map[Option]string{
+	QOMinE2ELatency:            "Minimize end-to-end latency",
+	QOMinLastHopAnswerSize:     "Minimize last-hop answer size (bandwidth)",
+	QOMinInfoLeakage:           "Minimize information leakage beyond first hop",
+	QOCachedAnswersOnly:        "No information leakage beyond first hop: cached answers only",
+	QOExpiredAssertionsOk:      "Expired assertions are acceptable",
+	QOTokenTracing:             "Enable query token tracing",
+	QONoVerificationDelegation: "Disable verification delegation (client protocol only)",
+	QONoProactiveCaching:       "Suppress proactive caching of future assertions",
+}
+
+//conflictingOptions contains pairs of query options which must not be combined together with the
+//reason why.
+var conflictingOptions = []struct {
+	a, b   Option
+	reason string
+}{
+	{QOCachedAnswersOnly, QOMinInfoLeakage, "cached answers only already prevents any information leakage"},
+	{QOCachedAnswersOnly, QOTokenTracing, "a query answered from the cache cannot be traced beyond the first hop"},
+}
+
+//Options returns all query options in ascending order.
+func Options() []Option {
+	var opts []Option
+	for o := range optionNames {
+		opts = append(opts, o)
+	}
+	sort.Slice(opts, func(i, j int) bool { return opts[i] < opts[j] })
+	return opts
+}
+
+//Name returns the symbolic name of the option or its number if the option is unknown.
+func (o Option) Name() string {
+	if name, ok := optionNames[o]; ok {
+		return name
+	}
+	return strconv.Itoa(int(o))
+}
+
+//Description returns a short description of the option.
+func (o Option) Description() string {
+	if desc, ok := optionDescriptions[o]; ok {
+		return desc
+	}
+	return "Unknown query option"
+}
+
+//ParseOption returns the query option identified by s. It accepts the symbolic name of an option
+//(case insensitive) or its number.
+func ParseOption(s string) (Option, error) {
+	name := strings.ToLower(s)
+	for o, n := range optionNames {
+		if n == name || strconv.Itoa(int(o)) == s {
+			return o, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown query option: %s", s)
+}
+
+//CheckOptions returns an error if an option is contained several times in opts or if opts contains
+//two options which contradict each other.
+func CheckOptions(opts []Option) error {
+	for i, o := range opts {
+		if containsOption(o, opts[:i]) {
+			return fmt.Errorf("query option %s is given several times", o.Name())
+		}
+	}
+	for _, c := range conflictingOptions {
+		if containsOption(c.a, opts) && containsOption(c.b, opts) {
+			return fmt.Errorf("query options %s and %s conflict: %s", c.a.Name(), c.b.Name(), c.reason)
+		}
+	}
+	return nil
+}
@@ -133,3 +133,53 @@ func checkQuery(q1, q2 *Name, t *testing.T) {
 		}
 	}
 }
+
+func TestParseOption(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  Option
+		valid bool
+	}{
+		{"min-latency", QOMinE2ELatency, true},
+		{"Cached-Only", QOCachedAnswersOnly, true},
+		{"no-proactive-caching", QONoProactiveCaching, true},
+		{"4", QOCachedAnswersOnly, true},
+		{"8", QONoProactiveCaching, true},
+		{"0", 0, false},
+		{"9", 0, false},
+		{"fast", 0, false},
+	}
+	for i, test := range tests {
+		opt, err := ParseOption(test.input)
+		if (err == nil) != test.valid || opt != test.want {
+			t.Errorf("%d: ParseOption(%s)=(%v,%v), expected %v", i, test.input, opt, err, test.want)
+		}
+		if test.valid {
+			if back, err := ParseOption(opt.Name()); err != nil || back != opt {
+				t.Errorf("%d: Name() of %v does not parse back, got %v", i, opt, back)
+			}
+		}
+	}
+	if opts := Options(); len(opts) != 8 || opts[0] != QOMinE2ELatency || opts[7] != QONoProactiveCaching {
+		t.Errorf("Options() returned unexpected options %v", opts)
+	}
+}
+
+func TestCheckOptions(t *testing.T) {
+	var tests = []struct {
+		input []Option
+		valid bool
+	}{
+		{nil, true},
+		{[]Option{QOMinE2ELatency, QOCachedAnswersOnly}, true},
+		{[]Option{QOMinInfoLeakage, QOMinE2ELatency, QOTokenTracing}, true},
+		{[]Option{QOMinE2ELatency, QOMinE2ELatency}, false},
+		{[]Option{QOCachedAnswersOnly, QOMinInfoLeakage}, false},
+		{[]Option{QOTokenTracing, QOExpiredAssertionsOk, QOCachedAnswersOnly}, false},
+	}
+	for i, test := range tests {
+		if err := CheckOptions(test.input); (err == nil) != test.valid {
+			t.Errorf("%d: CheckOptions(%v)=%v, expected valid=%v", i, test.input, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package rainsd
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//aclConfig lists the ip addresses and networks in CIDR notation from which a server accepts each
+//kind of message. An empty list accepts messages of its kind from all addresses.
+type aclConfig struct {
+	Query   []string //senders of queries
+	Push    []string //senders of sections which do not answer a query of the server
+	Publish []string //senders of sections of the zones over which the server has authority
+}
+
+//acl restricts the ip addresses from which a server accepts queries and sections. Senders without
+//an ip address, e.g. over in-memory connections, are not restricted. It is safe for concurrent use.
+type acl struct {
+	mux                  sync.RWMutex
+	query, push, publish []*net.IPNet
+}
+
+//Replace sets the content of the acl to the networks of config. The acl is not modified if an entry
+//is malformed.
+func (a *acl) Replace(config aclConfig) error {
+	query, push, publish, err := parseACL(config)
+	if err != nil {
+		return err
+	}
+	a.set(query, push, publish)
+	return nil
+}
+
+//parseACL returns the networks of config allowed to send queries, to push sections and to publish
+//sections.
+func parseACL(config aclConfig) (query, push, publish []*net.IPNet, err error) {
+	lists := make([][]*net.IPNet, 3)
+	for i, entries := range [][]string{config.Query, config.Push, config.Publish} {
+		for _, entry := range entries {
+			network, err := parseNetwork(entry)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid ACL: %v", err)
+			}
+			lists[i] = append(lists[i], network)
+		}
+	}
+	return lists[0], lists[1], lists[2], nil
+}
+
+//set replaces the networks allowed to send queries, to push sections and to publish sections.
+func (a *acl) set(query, push, publish []*net.IPNet) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.query, a.push, a.publish = query, push, publish
+}
+
+//networks returns the networks allowed to send queries, to push sections and to publish sections.
+func (a *acl) networks() (query, push, publish []*net.IPNet) {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	return a.query, a.push, a.publish
+}
+
+//allowed returns true if networks is empty or contains the ip address of addr.
+func allowed(networks []*net.IPNet, addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if len(networks) == 0 || !ok {
+		return true
+	}
+	for _, network := range networks {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+//permit returns false if the acl of s does not allow sender to send msg, in which case s answers
+//with a NTAccessDenied notification instead of processing msg. Sections of a zone over which s has
+//authority are only accepted from its publishers. Other sections are only accepted from the
+//addresses allowed to push unless they answer a query s sent.
+func (s *Server) permit(msg *message.Message, sender net.Addr) bool {
+	queriers, pushers, publishers := s.acl.networks()
+	reason := ""
+	for _, sec := range msg.Content {
+		switch sec.(type) {
+		case *query.Name:
+			if !allowed(queriers, sender) {
+				reason = "queries are not accepted"
+			}
+		case *section.Assertion, *section.Shard, *section.Pshard, *section.Zone:
+			sec := sec.(section.WithSigForward)
+			if isAuthoritative(sec, s.config.ZoneAuthority, s.config.ContextAuthority) {
+				if !allowed(publishers, sender) {
+					reason = "sections of zone " + sec.GetSubjectZone() + " are only accepted from " +
+						"its publishers"
+				}
+			} else if !allowed(pushers, sender) && !s.caches.PendingKeys.ContainsToken(msg.Token) &&
+				!s.activeTokens.contains(msg.Token) {
+				reason = "pushed sections are not accepted"
+			}
+		}
+		if reason != "" {
+			log.Info("Access denied", "sender", sender, "token", msg.Token, "reason", reason)
+			sendNotificationMsg(msg.Token, sender, section.NTAccessDenied,
+				fmt.Sprintf("%s from %v", reason, sender), s)
+			return false
+		}
+	}
+	return true
+}
+
+//activeTokens stores the tokens of the queries a server forwarded until they expire, such that
+//their answers are not taken for pushed sections. It is safe for concurrent use.
+type activeTokens struct {
+	mux     sync.Mutex
+	maxSize int
+	tokens  map[token.Token]int64
+}
+
+//newActiveTokens returns an empty activeTokens storing at most maxSize tokens. The size is not
+//limited if maxSize is not positive.
+func newActiveTokens(maxSize int) *activeTokens {
+	return &activeTokens{maxSize: maxSize, tokens: make(map[token.Token]int64)}
+}
+
+//add stores tok until expiration.
+func (t *activeTokens) add(tok token.Token, expiration int64) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if _, ok := t.tokens[tok]; !ok && t.maxSize > 0 && len(t.tokens) >= t.maxSize {
+		log.Warn("Active token cache is full, the answer will be taken for pushed sections",
+			"token", tok)
+		return
+	}
+	if expiration > t.tokens[tok] {
+		t.tokens[tok] = expiration
+	}
+}
+
+//contains returns true if tok is stored and has not expired.
+func (t *activeTokens) contains(tok token.Token) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.tokens[tok] >= clock.Now().Unix()
+}
+
+//removeExpired deletes all expired tokens.
+func (t *activeTokens) removeExpired() {
+	now := clock.Now().Unix()
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for tok, expiration := range t.tokens {
+		if expiration < now {
+			delete(t.tokens, tok)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package rainsd
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+const (
+	//maxTrackedZones is the number of zones whose queries are counted separately. Queries for
+	//further zones are counted under OtherZones.
+	maxTrackedZones = 1000
+	//maxTrackedPeers is the number of peers whose traffic is counted. If it is exceeded, the peer
+	//which has not been seen for the longest time is forgotten.
+	maxTrackedPeers = 1000
+	//recentNotifications is the number of sent and received notifications which are kept.
+	recentNotifications = 50
+)
+
+//OtherZones is the name under which the queries for zones exceeding maxTrackedZones are counted.
+const OtherZones = "other"
+
+//AdminNotification is a notification recently sent or received by the server.
+type AdminNotification struct {
+	Time time.Time
+	//Sent is true if the server sent the notification and false if it received it.
+	Sent bool
+	Peer string
+	Type section.NotificationType
+	Data string `json:",omitempty"`
+}
+
+//AdminPeer contains the traffic exchanged with a peer since the server started.
+type AdminPeer struct {
+	Addr string
+	//Connected is true if the server has an open connection to the peer.
+	Connected bool
+	Received  int
+	Sent      int
+	//Failed is the number of messages which could not be sent to the peer.
+	Failed    int
+	LastError string `json:",omitempty"`
+	LastSeen  time.Time
+}
+
+//activity counts the queries per zone and the messages exchanged with each peer and keeps the
+//most recent notifications. It is safe for concurrent use.
+type activity struct {
+	mux     sync.Mutex
+	queries map[string]int
+	peers   map[string]*AdminPeer
+	//notifications is a ring buffer of which next is the oldest entry once it is full.
+	notifications []AdminNotification
+	next          int
+}
+
+//newActivity returns an activity without any queries, peers and notifications.
+func newActivity() *activity {
+	return &activity{queries: make(map[string]int), peers: make(map[string]*AdminPeer)}
+}
+
+//query counts a received query for a name in zone.
+func (a *activity) query(zone string) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if _, ok := a.queries[zone]; !ok && len(a.queries) >= maxTrackedZones {
+		zone = OtherZones
+	}
+	a.queries[zone]++
+}
+
+//received counts a message received from peer.
+func (a *activity) received(peer net.Addr) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	p := a.peer(peer)
+	p.Received++
+	p.LastSeen = time.Now()
+}
+
+//sent counts a message sent to peer or, if err is not nil, a message which could not be sent.
+func (a *activity) sent(peer net.Addr, err error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	p := a.peer(peer)
+	if err != nil {
+		p.Failed++
+		p.LastError = err.Error()
+		return
+	}
+	p.Sent++
+	p.LastSeen = time.Now()
+}
+
+//peer returns the entry of addr and creates it if necessary. a.mux must be held.
+func (a *activity) peer(addr net.Addr) *AdminPeer {
+	key := addr.String()
+	if p, ok := a.peers[key]; ok {
+		return p
+	}
+	if len(a.peers) >= maxTrackedPeers {
+		oldest := ""
+		for k, p := range a.peers {
+			if oldest == "" || p.LastSeen.Before(a.peers[oldest].LastSeen) {
+				oldest = k
+			}
+		}
+		delete(a.peers, oldest)
+	}
+	p := &AdminPeer{Addr: key}
+	a.peers[key] = p
+	return p
+}
+
+//notification keeps n which has been sent to respectively received from peer.
+func (a *activity) notification(sent bool, peer net.Addr, n *section.Notification) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	entry := AdminNotification{Time: time.Now(), Sent: sent, Type: n.Type, Data: n.Data}
+	if peer != nil {
+		entry.Peer = peer.String()
+	}
+	if len(a.notifications) < recentNotifications {
+		a.notifications = append(a.notifications, entry)
+		return
+	}
+	a.notifications[a.next] = entry
+	a.next = (a.next + 1) % recentNotifications
+}
+
+//snapshot returns the number of queries per zone, the peers sorted by address of which the ones
+//in connected are marked as such, and the recent notifications starting with the newest one.
+func (a *activity) snapshot(connected []net.Addr) (map[string]int, []AdminPeer,
+	[]AdminNotification) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	queries := make(map[string]int, len(a.queries))
+	for zone, count := range a.queries {
+		queries[zone] = count
+	}
+	isConnected := make(map[string]bool)
+	for _, addr := range connected {
+		isConnected[addr.String()] = true
+	}
+	peers := []AdminPeer{}
+	for _, p := range a.peers {
+		peer := *p
+		peer.Connected = isConnected[peer.Addr]
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Addr < peers[j].Addr })
+	notifications := []AdminNotification{}
+	for i := len(a.notifications) - 1; i >= 0; i-- {
+		notifications = append(notifications,
+			a.notifications[(a.next+i)%len(a.notifications)])
+	}
+	return queries, peers, notifications
+}
@@ -0,0 +1,409 @@
+package rainsd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+//Commands understood by the admin socket.
+const (
+	AdminStats           = "stats"
+	AdminCacheFlush      = "cache-flush"
+	AdminCacheDump       = "cache-dump"
+	AdminCacheExport     = "cache-export"
+	AdminConnections     = "conns"
+	AdminLogLevel        = "loglevel"
+	AdminBlacklistAdd    = "blacklist-add"
+	AdminBlacklistRemove = "blacklist-remove"
+	AdminBlacklistList   = "blacklist-list"
+	AdminReload          = "reload"
+	AdminTrace           = "trace"
+)
+
+//Names of the caches which can be flushed or dumped over the admin socket.
+const (
+	CacheAssertions    = "assertions"
+	CacheNegAssertions = "negassertions"
+	CacheZoneKeys      = "zonekeys"
+	CacheAll           = "all"
+)
+
+//AdminRequest is a command sent to the admin socket of a rainsd server. Requests and responses are
+//encoded as json, one per line.
+type AdminRequest struct {
+	Command string
+	Args    []string
+}
+
+//AdminResponse is the answer of a rainsd server to an AdminRequest. Error is empty if the command
+//succeeded. The content of Result depends on the command.
+type AdminResponse struct {
+	Error  string          `json:",omitempty"`
+	Result json.RawMessage `json:",omitempty"`
+}
+
+//AdminStatistics is the result of the stats command.
+type AdminStatistics struct {
+	Uptime time.Duration
+	//Mode is the operating mode of the server, e.g. recursive.
+	Mode   string
+	Caches map[string]int
+	//Capacities contains the maximum number of entries of the caches in Caches by name.
+	Capacities  map[string]int
+	Queues      map[string]int
+	Workers     map[string]int
+	Connections int
+	Blacklisted int
+	//Mirror contains the counters of the traffic mirrored to the shadow server. It is nil if
+	//mirroring is disabled.
+	Mirror map[string]int
+	//Prefetch contains the number of answers whose cache hits are counted and of answers refreshed
+	//before they expired. It is nil if prefetching is disabled.
+	Prefetch map[string]int
+	//RateLimit contains the number of accounts of the response rate limiter and of dropped and
+	//slipped answers. It is nil if response rate limiting is disabled.
+	RateLimit map[string]int
+	//Inconsistencies contains the number of sections per zone which have been dropped because they
+	//contradicted cached sections.
+	Inconsistencies map[string]int
+	//Queries contains the number of received queries per zone of the queried name.
+	Queries map[string]int
+	//Peers contains the traffic exchanged with the peers of the server sorted by address.
+	Peers []AdminPeer
+	//Notifications contains the recently sent and received notifications, the newest first.
+	Notifications []AdminNotification
+}
+
+//AdminFlushResult is the result of the cache-flush command. It contains the number of entries
+//removed per cache.
+type AdminFlushResult map[string]int
+
+//AdminCall sends req to the admin socket at socketPath and returns the server's response.
+func AdminCall(socketPath string, req AdminRequest, timeout time.Duration) (AdminResponse, error) {
+	conn, err := net.DialTimeout("unix", socketPath, timeout)
+	if err != nil {
+		return AdminResponse{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return AdminResponse{}, fmt.Errorf("could not send request: %v", err)
+	}
+	var resp AdminResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return AdminResponse{}, fmt.Errorf("could not read response: %v", err)
+	}
+	return resp, nil
+}
+
+//serveAdmin listens on the admin socket configured in AdminSocketPath and handles the requests of
+//all connecting clients. Access is restricted to the user running the server by the permissions
+//of the socket file.
+func (s *Server) serveAdmin() {
+	path := s.config.AdminSocketPath
+	listener, err := listenAdmin(path)
+	if err != nil {
+		log.Error("Could not listen on admin socket", "path", path, "error", err)
+		return
+	}
+	log.Info("Admin socket started", "path", path)
+	go func() {
+		<-s.adminShutdown
+		listener.Close()
+		os.Remove(path)
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Info("Admin socket closed", "path", path, "error", err)
+			return
+		}
+		go s.handleAdminConnection(conn)
+	}
+}
+
+//listenAdmin creates the admin socket at path, replacing a stale one. The socket is created in a
+//directory only accessible by the user running the server and moved to path after its permissions
+//have been restricted. Thus, no other user can connect to it in between.
+func listenAdmin(path string) (*net.UnixListener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not remove stale admin socket: %v", err)
+	}
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".rainsd-admin")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmpPath := filepath.Join(dir, "socket")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	//The socket file is moved, it is removed at its final path when the server stops.
+	listener.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("could not restrict permissions of admin socket: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+//handleAdminConnection answers all requests received over conn.
+func (s *Server) handleAdminConnection(conn net.Conn) {
+	defer conn.Close()
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+	for {
+		var req AdminRequest
+		if err := decoder.Decode(&req); err != nil {
+			return
+		}
+		log.Info("Received admin request", "command", req.Command, "args", req.Args)
+		var resp AdminResponse
+		result, err := s.handleAdminRequest(req)
+		if err == nil {
+			resp.Result, err = json.Marshal(result)
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		if err := encoder.Encode(resp); err != nil {
+			log.Warn("Could not send admin response", "error", err)
+			return
+		}
+	}
+}
+
+//handleAdminRequest executes the command of req and returns its result.
+func (s *Server) handleAdminRequest(req AdminRequest) (interface{}, error) {
+	switch req.Command {
+	case AdminStats:
+		return s.statistics(), nil
+	case AdminCacheFlush:
+		if len(req.Args) == 0 || len(req.Args) > 2 {
+			return nil, errors.New("usage: cache-flush <assertions|negassertions|all> [zone]")
+		}
+		zone := ""
+		if len(req.Args) == 2 {
+			zone = req.Args[1]
+		}
+		return s.flushCache(req.Args[0], zone)
+	case AdminCacheDump:
+		if len(req.Args) != 1 {
+			return nil, errors.New("usage: cache-dump <assertions|negassertions|zonekeys>")
+		}
+		return s.dumpCache(req.Args[0])
+	case AdminCacheExport:
+		if len(req.Args) != 1 {
+			return nil, errors.New("usage: cache-export <assertions|negassertions|zonekeys>")
+		}
+		sections, err := s.cacheContent(req.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		return encodeCacheSnapshot(sections, s.config.ZoneAuthority, s.config.ContextAuthority)
+	case AdminConnections:
+		addrs := []string{}
+		for _, addr := range s.caches.ConnCache.Addrs() {
+			addrs = append(addrs, addr.String())
+		}
+		sort.Strings(addrs)
+		return addrs, nil
+	case AdminLogLevel:
+		if len(req.Args) != 1 {
+			return nil, errors.New("usage: loglevel <debug|info|warn|error|crit>")
+		}
+		return req.Args[0], s.setLogLevel(req.Args[0])
+	case AdminBlacklistAdd:
+		if len(req.Args) == 0 {
+			return nil, errors.New("usage: blacklist-add <ip|cidr>...")
+		}
+		for _, entry := range req.Args {
+			if err := s.blacklist.Add(entry); err != nil {
+				return nil, err
+			}
+		}
+		return s.blacklist.Entries(), nil
+	case AdminBlacklistRemove:
+		if len(req.Args) == 0 {
+			return nil, errors.New("usage: blacklist-remove <ip|cidr>...")
+		}
+		for _, entry := range req.Args {
+			if ok, err := s.blacklist.Remove(entry); err != nil {
+				return nil, err
+			} else if !ok {
+				return nil, fmt.Errorf("%s is not blacklisted", entry)
+			}
+		}
+		return s.blacklist.Entries(), nil
+	case AdminBlacklistList:
+		return s.blacklist.Entries(), nil
+	case AdminReload:
+		return s.Reload()
+	case AdminTrace:
+		if len(req.Args) != 1 {
+			return nil, errors.New("usage: trace <token>")
+		}
+		tok, err := parseToken(req.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		hops, ok := s.tokenTracer.hops(tok)
+		if !ok {
+			return nil, fmt.Errorf("no query with token %s has been traced", tok)
+		}
+		return hops, nil
+	}
+	return nil, fmt.Errorf("unknown command: %s", req.Command)
+}
+
+//statistics returns the current utilization of the server's caches, queues and workers together
+//with the activity since the server started.
+func (s *Server) statistics() AdminStatistics {
+	stats := AdminStatistics{
+		Uptime: time.Since(s.startTime),
+		Caches: map[string]int{
+			"connections":      s.caches.ConnCache.Len(),
+			"capabilities":     s.caches.Capabilities.Len(),
+			CacheZoneKeys:      s.caches.ZoneKeyCache.Len(),
+			"pendingKeys":      s.caches.PendingKeys.Len(),
+			"pendingQueries":   s.caches.PendingQueries.Len(),
+			CacheAssertions:    s.caches.AssertionsCache.Len(),
+			CacheNegAssertions: s.caches.NegAssertionCache.Len(),
+		},
+		Queues: map[string]int{
+			"prio":         len(s.queues.Prio),
+			"delegation":   len(s.queues.Delegation),
+			"normal":       len(s.queues.Normal),
+			"bulk":         len(s.queues.Bulk),
+			"notification": len(s.queues.Notify),
+		},
+		Workers: map[string]int{
+			"prio":         len(s.queues.PrioW),
+			"normal":       len(s.queues.NormalW),
+			"notification": len(s.queues.NotifyW),
+		},
+		Capacities:  s.capacities(),
+		Mode:        s.config.Mode,
+		Connections: s.caches.ConnCache.Len(),
+		Blacklisted: s.blacklist.Len(),
+	}
+	stats.Queries, stats.Peers, stats.Notifications = s.activity.snapshot(
+		s.caches.ConnCache.Addrs())
+	if s.mirror != nil {
+		stats.Mirror = s.mirror.statistics()
+	}
+	if s.prefetch != nil {
+		stats.Prefetch = s.prefetch.statistics()
+	}
+	if s.rateLimiter != nil {
+		stats.RateLimit = s.rateLimiter.statistics()
+	}
+	stats.Inconsistencies = s.inconsistentZones.statistics()
+	return stats
+}
+
+//flushCache removes all entries of zone from the named cache or all entries if zone is empty.
+//Zone keys cannot be flushed as signatures could not be verified anymore afterwards.
+func (s *Server) flushCache(name, zone string) (AdminFlushResult, error) {
+	result := AdminFlushResult{}
+	if name == CacheAssertions || name == CacheAll {
+		before := s.caches.AssertionsCache.Len()
+		for _, zone := range zonesOf(s.caches.AssertionsCache.Checkpoint(), zone) {
+			s.caches.AssertionsCache.RemoveZone(zone)
+		}
+		result[CacheAssertions] = before - s.caches.AssertionsCache.Len()
+	}
+	if name == CacheNegAssertions || name == CacheAll {
+		before := s.caches.NegAssertionCache.Len()
+		for _, zone := range zonesOf(s.caches.NegAssertionCache.Checkpoint(), zone) {
+			s.caches.NegAssertionCache.RemoveZone(zone)
+		}
+		result[CacheNegAssertions] = before - s.caches.NegAssertionCache.Len()
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("cache %s cannot be flushed", name)
+	}
+	log.Info("Flushed cache over admin socket", "cache", name, "zone", zone, "removed", result)
+	return result, nil
+}
+
+//zonesOf returns the distinct subject zones of sections. If zone is not empty, only zone is
+//returned if it is among them.
+func zonesOf(sections []section.Section, zone string) []string {
+	zones := make(map[string]bool)
+	for _, s := range sections {
+		if sec, ok := s.(section.WithSig); ok {
+			if zone == "" || sec.GetSubjectZone() == zone {
+				zones[sec.GetSubjectZone()] = true
+			}
+		}
+	}
+	var output []string
+	for z := range zones {
+		output = append(output, z)
+	}
+	return output
+}
+
+//cacheContent returns all sections of the named cache.
+func (s *Server) cacheContent(name string) ([]section.Section, error) {
+	switch name {
+	case CacheAssertions:
+		return s.caches.AssertionsCache.Checkpoint(), nil
+	case CacheNegAssertions:
+		return s.caches.NegAssertionCache.Checkpoint(), nil
+	case CacheZoneKeys:
+		return s.caches.ZoneKeyCache.Checkpoint(), nil
+	}
+	return nil, fmt.Errorf("cache %s cannot be dumped", name)
+}
+
+//dumpCache returns the content of the named cache in zonefile format.
+func (s *Server) dumpCache(name string) ([]string, error) {
+	sections, err := s.cacheContent(name)
+	if err != nil {
+		return nil, err
+	}
+	encoder := zonefile.IO{}
+	output := []string{}
+	for _, s := range sections {
+		output = append(output, encoder.EncodeSection(s))
+	}
+	return output, nil
+}
+
+//setLogLevel changes the level of the handler the server has been started with to level.
+func (s *Server) setLogLevel(level string) error {
+	lvl, err := log.LvlFromString(level)
+	if err != nil {
+		return err
+	}
+	s.applyLogLevel(lvl)
+	return nil
+}
+
+//applyLogLevel changes the level of the handler the server has been started with to lvl.
+func (s *Server) applyLogLevel(lvl log.Lvl) {
+	log.Root().SetHandler(log.LvlFilterHandler(lvl, s.logHandler))
+	s.reloadable.mux.Lock()
+	s.reloadable.logLevel = lvl.String()
+	s.reloadable.mux.Unlock()
+	log.Info("Changed log level", "level", lvl)
+}
@@ -0,0 +1,128 @@
+package rainsd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	log "github.com/inconshreveable/log15"
+)
+
+func TestBlacklist(t *testing.T) {
+	b := newBlacklist()
+	for _, entry := range []string{"192.0.2.1", "198.51.100.0/24", "2001:db8::/32"} {
+		if err := b.Add(entry); err != nil {
+			t.Fatalf("Was not able to add %s: %v", entry, err)
+		}
+	}
+	for _, entry := range []string{"192.0.2", "198.51.100.0/33", ""} {
+		if err := b.Add(entry); err == nil {
+			t.Errorf("malformed entry %q was added", entry)
+		}
+	}
+	var tests = []struct {
+		addr        net.Addr
+		blacklisted bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.2")}, false},
+		{&net.TCPAddr{IP: net.ParseIP("198.51.100.77")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db9::1")}, false},
+		{&net.UnixAddr{Name: "192.0.2.1"}, false},
+	}
+	for i, test := range tests {
+		if b.Contains(test.addr) != test.blacklisted {
+			t.Errorf("%d: wrong blacklist result for %s. expected=%t", i, test.addr,
+				test.blacklisted)
+		}
+	}
+	if ok, err := b.Remove("192.0.2.1"); !ok || err != nil {
+		t.Errorf("blacklisted address was not removed. removed=%t error=%v", ok, err)
+	}
+	if ok, _ := b.Remove("192.0.2.1"); ok {
+		t.Errorf("address was removed twice")
+	}
+	if err := b.Replace([]string{"203.0.113.1", "malformed"}); err == nil {
+		t.Errorf("blacklist with a malformed entry was accepted")
+	}
+	expected := []string{"198.51.100.0/24", "2001:db8::/32"}
+	if !reflect.DeepEqual(b.Entries(), expected) || b.Len() != 2 {
+		t.Errorf("wrong entries. expected=%v actual=%v", expected, b.Entries())
+	}
+}
+
+func TestHandleAdminRequest(t *testing.T) {
+	defer log.Root().SetHandler(log.Root().GetHandler())
+	s := &Server{blacklist: newBlacklist(), logHandler: log.DiscardHandler()}
+	var tests = []struct {
+		req    AdminRequest
+		result interface{}
+		valid  bool
+	}{
+		{AdminRequest{Command: AdminBlacklistList}, []string{}, true},
+		{AdminRequest{AdminBlacklistAdd, []string{"192.0.2.1", "198.51.100.0/24"}},
+			[]string{"192.0.2.1/32", "198.51.100.0/24"}, true},
+		{AdminRequest{AdminBlacklistAdd, []string{"192.0.2.300"}}, nil, false},
+		{AdminRequest{Command: AdminBlacklistAdd}, nil, false},
+		{AdminRequest{AdminBlacklistRemove, []string{"192.0.2.1"}},
+			[]string{"198.51.100.0/24"}, true},
+		{AdminRequest{AdminBlacklistRemove, []string{"192.0.2.1"}}, nil, false},
+		{AdminRequest{Command: AdminBlacklistList}, []string{"198.51.100.0/24"}, true},
+		{AdminRequest{AdminLogLevel, []string{"warn"}}, "warn", true},
+		{AdminRequest{AdminLogLevel, []string{"verbose"}}, "verbose", false},
+		{AdminRequest{Command: AdminLogLevel}, nil, false},
+		{AdminRequest{Command: AdminCacheFlush}, nil, false},
+		{AdminRequest{AdminCacheDump, []string{"a", "b"}}, nil, false},
+		{AdminRequest{AdminTrace, []string{"malformed"}}, nil, false},
+		{AdminRequest{Command: "unknown"}, nil, false},
+	}
+	for i, test := range tests {
+		result, err := s.handleAdminRequest(test.req)
+		if (err == nil) != test.valid {
+			t.Errorf("%d: wrong outcome of %v. expected valid=%t actual=%v", i, test.req,
+				test.valid, err)
+		}
+		if !reflect.DeepEqual(result, test.result) {
+			t.Errorf("%d: wrong result of %v. expected=%v actual=%v", i, test.req, test.result,
+				result)
+		}
+	}
+}
+
+func TestListenAdmin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rainsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "admin.sock")
+	//A stale socket is replaced.
+	if err := ioutil.WriteFile(path, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := listenAdmin(path)
+	if err != nil {
+		t.Fatalf("Was not able to listen on admin socket: %v", err)
+	}
+	defer listener.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("admin socket does not exist: %v", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Errorf("wrong mode of admin socket. actual=%v", info.Mode())
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("temporary directory was not removed. files=%d", len(files))
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Was not able to connect to admin socket: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,85 @@
+package rainsd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/keyManager"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"golang.org/x/crypto/ed25519"
+)
+
+//defaultAnswerSigValidity is how long the signature of a sent message is valid if
+//AnswerSigValidity is not set.
+const defaultAnswerSigValidity = 5 * time.Minute
+
+//messageSigner signs the messages a server sends with the server's infrastructure key, such that
+//clients knowing the public key, e.g. from an :infra: object of the server's name, can
+//authenticate which server produced an answer, even if its sections were served from the cache.
+type messageSigner struct {
+	id       keys.PublicKeyID
+	key      ed25519.PrivateKey
+	validity time.Duration
+}
+
+//newMessageSigner returns a messageSigner with the ed25519 private key of InfrastructureKeyPhase
+//stored at InfrastructureKeyPath. An encrypted key is decrypted with the passphrase stored at
+//InfrastructureKeyPassphrasePath. It returns nil if SignAnswers is not set.
+func newMessageSigner(config rainsdConfig) (*messageSigner, error) {
+	if !config.SignAnswers {
+		return nil, nil
+	}
+	if config.InfrastructureKeyPath == "" {
+		return nil, errors.New("InfrastructureKeyPath must be set when SignAnswers is set")
+	}
+	var passphrase func() (string, error)
+	if config.InfrastructureKeyPassphrasePath != "" {
+		passphrase = keyManager.PassphraseFile(config.InfrastructureKeyPassphrasePath)
+	}
+	privateKeys, err := keyManager.LoadPrivateKeys(config.InfrastructureKeyPath, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("could not load infrastructure key: %v", err)
+	}
+	signer := &messageSigner{
+		id: keys.PublicKeyID{
+			Algorithm: algorithmTypes.Ed25519,
+			KeySpace:  keys.RainsKeySpace,
+			KeyPhase:  config.InfrastructureKeyPhase,
+		},
+		validity: config.AnswerSigValidity,
+	}
+	for _, key := range privateKeys {
+		if key.PublicKeyID == signer.id {
+			signer.key, _ = key.Key.(ed25519.PrivateKey)
+		}
+	}
+	if signer.key == nil {
+		return nil, fmt.Errorf("%s contains no ed25519 key of key phase %d",
+			config.InfrastructureKeyPath, config.InfrastructureKeyPhase)
+	}
+	if signer.validity <= 0 {
+		signer.validity = defaultAnswerSigValidity
+	}
+	return signer, nil
+}
+
+//sign adds a signature over msg to it which is valid from now on for the signer's validity. msg
+//must not be changed afterwards.
+func (m *messageSigner) sign(msg *message.Message) error {
+	now := clock.Now()
+	sig := signature.Sig{
+		PublicKeyID: m.id,
+		ValidSince:  now.Unix(),
+		ValidUntil:  now.Add(m.validity).Unix(),
+	}
+	if !siglib.SignMessageUnsafe(msg, m.key, sig) {
+		return errors.New("could not sign message")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package rainsd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/keyManager"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestNewMessageSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rainsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	id := keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519, KeySpace: keys.RainsKeySpace,
+		KeyPhase: 1}
+	encrypted, err := keyManager.EncryptPrivateKeys(
+		[]keys.PrivateKey{{PublicKeyID: id, Key: privateKey}}, "secret")
+	if err != nil {
+		t.Fatalf("Was not able to encrypt private key: %v", err)
+	}
+	keyPath, pwdPath := filepath.Join(dir, "infra_sec.pem"), filepath.Join(dir, "passphrase")
+	if err := ioutil.WriteFile(keyPath, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pwdPath, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		config rainsdConfig
+		valid  bool
+	}{
+		{rainsdConfig{SignAnswers: true, InfrastructureKeyPath: keyPath,
+			InfrastructureKeyPassphrasePath: pwdPath, InfrastructureKeyPhase: 1}, true},
+		//the server must not prompt for the passphrase
+		{rainsdConfig{SignAnswers: true, InfrastructureKeyPath: keyPath,
+			InfrastructureKeyPhase: 1}, false},
+		{rainsdConfig{SignAnswers: true, InfrastructureKeyPath: keyPath,
+			InfrastructureKeyPassphrasePath: pwdPath, InfrastructureKeyPhase: 2}, false},
+		{rainsdConfig{SignAnswers: true}, false},
+	}
+	for i, test := range tests {
+		signer, err := newMessageSigner(test.config)
+		if (err == nil) != test.valid {
+			t.Errorf("%d: wrong outcome. expected valid=%t actual=%v", i, test.valid, err)
+		}
+		if test.valid && signer != nil {
+			msg := message.Message{}
+			if err := signer.sign(&msg); err != nil || len(msg.Signatures) != 1 {
+				t.Errorf("%d: message was not signed. error=%v", i, err)
+			}
+		}
+	}
+	if signer, err := newMessageSigner(rainsdConfig{}); signer != nil || err != nil {
+		t.Errorf("signer was created without SignAnswers. error=%v", err)
+	}
+}
@@ -0,0 +1,233 @@
+package rainsd
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//defaultLastHopAnswerSize is the maximum encoded length of an answer to a query with the
+//QOMinLastHopAnswerSize option if MaxLastHopAnswerByteLength is not set.
+const defaultLastHopAnswerSize = 16 * 1024
+
+//shapeAnswer returns answer unchanged unless one of queries has the QOMinLastHopAnswerSize option.
+//In that case, the assertions contained in shards and zones of answer are sent on their own, and
+//for each query only the assertions needed to contain all its answering objects are kept,
+//preferring the ones with the fewest objects of other types. Shards, zones and pshards are only
+//kept to prove the nonexistence of types without an assertion. Signed assertions cannot be
+//stripped of objects of other types as their signatures would not be valid anymore. If a query is
+//neither answered nor denied by answer, e.g. because answer is a referral, no section is removed.
+//An answer which is still longer than MaxLastHopAnswerByteLength is truncated and ends with a
+//NTMsgTooLarge notification with token tok.
+func (s *Server) shapeAnswer(answer []section.Section, queries []section.Section,
+	tok token.Token) []section.Section {
+	minimize := false
+	for _, sec := range queries {
+		if q, ok := sec.(*query.Name); ok && q.ContainsOption(query.QOMinLastHopAnswerSize) {
+			minimize = true
+		}
+	}
+	if !minimize || len(answer) == 0 {
+		return answer
+	}
+	if minimal, ok := minimalAnswer(answer, queries); ok {
+		answer = minimal
+	}
+	maxSize := int(s.config.MaxLastHopAnswerByteLength)
+	if maxSize <= 0 {
+		maxSize = defaultLastHopAnswerSize
+	}
+	return truncateAnswer(answer, maxSize, tok)
+}
+
+//withoutLastHopOptions returns a copy of q without the QOMinLastHopAnswerSize option, which only
+//concerns the answer to the client and is thus not forwarded.
+func withoutLastHopOptions(q *query.Name) *query.Name {
+	forwarded := *q
+	forwarded.Options = nil
+	for _, opt := range q.Options {
+		if opt != query.QOMinLastHopAnswerSize {
+			forwarded.Options = append(forwarded.Options, opt)
+		}
+	}
+	return &forwarded
+}
+
+//minimalAnswer returns for each query of queries the smallest set of sections of answer answering
+//it, followed by the notifications of answer. It returns false if a query is neither answered nor
+//denied by the sections of answer.
+func minimalAnswer(answer []section.Section, queries []section.Section) (
+	[]section.Section, bool) {
+	minimal := []section.Section{}
+	added := make(map[string]bool)
+	for _, sec := range queries {
+		q, ok := sec.(*query.Name)
+		if !ok {
+			return nil, false
+		}
+		sections, ok := minimalQueryAnswer(q, answer)
+		if !ok {
+			return nil, false
+		}
+		for _, sec := range sections {
+			if hash := sec.(section.WithSig).Hash(); !added[hash] {
+				added[hash] = true
+				minimal = append(minimal, sec)
+			}
+		}
+	}
+	for _, sec := range answer {
+		if _, ok := sec.(*section.Notification); ok {
+			minimal = append(minimal, sec)
+		}
+	}
+	log.Debug("Minimized answer", "queries", queries, "sections", len(answer),
+		"minimized", len(minimal))
+	return minimal, true
+}
+
+//minimalQueryAnswer returns the assertions of answer with the fewest objects of types not asked for
+//which together contain all objects of a type of q contained in answer, and the smallest section of
+//answer proving that the types without such an object do not exist. An assertion whose objects
+//are all contained in a newer or smaller assertion, e.g. an older copy, is omitted. It returns
+//false if there is no proof for the remaining types.
+func minimalQueryAnswer(q *query.Name, answer []section.Section) ([]section.Section, bool) {
+	subject, zone, err := toSubjectZone(q.Name)
+	if err != nil {
+		return nil, false
+	}
+	var candidates []*section.Assertion
+	var containers []section.WithSigForward
+	for _, sec := range answer {
+		switch sec := sec.(type) {
+		case *section.Assertion:
+			if sec.FQDN() == q.Name && sec.Context == q.Context && answersQuery(sec, q) {
+				candidates = append(candidates, sec)
+			}
+		case *section.Shard, *section.Pshard, *section.Zone:
+			container := sec.(section.WithSigForward)
+			if container.GetSubjectZone() == zone && container.GetContext() == q.Context {
+				containers = append(containers, container)
+			}
+		}
+	}
+	for _, sec := range filterAnswer(q, subject, containers) {
+		if a, ok := sec.(*section.Assertion); ok {
+			candidates = append(candidates, a)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ei, ej := otherObjects(candidates[i], q), otherObjects(candidates[j], q)
+		if ei != ej {
+			return ei < ej
+		}
+		return candidates[i].ValidUntil() > candidates[j].ValidUntil()
+	})
+	sections := []section.Section{}
+	missing := make(map[object.Type]bool)
+	for _, t := range q.Types {
+		missing[t] = true
+	}
+	covered := make(map[string]bool)
+	for _, a := range candidates {
+		answers := false
+		for _, o := range a.Content {
+			if asked(o.Type, q) && !covered[o.String()] {
+				answers = true
+				covered[o.String()] = true
+				delete(missing, o.Type)
+			}
+		}
+		if answers {
+			sections = append(sections, a)
+		}
+	}
+	if len(missing) == 0 {
+		return sections, true
+	}
+	types := []object.Type{}
+	for _, t := range q.Types {
+		if missing[t] {
+			types = append(types, t)
+		}
+	}
+	proof := nonexistenceProof(q, subject, types, containers)
+	if proof == nil {
+		return nil, false
+	}
+	return append(sections, proof), true
+}
+
+//otherObjects returns the number of objects of a whose type is not asked for in q.
+func otherObjects(a *section.Assertion, q *query.Name) int {
+	count := 0
+	for _, o := range a.Content {
+		if !asked(o.Type, q) {
+			count++
+		}
+	}
+	return count
+}
+
+//asked returns true if t is one of the types of q.
+func asked(t object.Type, q *query.Name) bool {
+	for _, qt := range q.Types {
+		if qt == t {
+			return true
+		}
+	}
+	return false
+}
+
+//truncateAnswer returns the longest prefix of answer which is together with a NTMsgTooLarge
+//notification with token tok at most maxSize bytes long when encoded, followed by this
+//notification. It returns answer unchanged if it is short enough.
+func truncateAnswer(answer []section.Section, maxSize int, tok token.Token) []section.Section {
+	if encodedLength(answer) <= maxSize {
+		return answer
+	}
+	notification := &section.Notification{Type: section.NTMsgTooLarge, Token: tok}
+	overhead := encodedLength(nil)
+	size := overhead
+	kept := 0
+	for kept < len(answer) {
+		secSize := encodedLength(answer[kept:kept+1]) - overhead
+		notification.Data = truncationNote(kept+1, len(answer))
+		noteSize := encodedLength([]section.Section{notification}) - overhead
+		if size+secSize+noteSize+cbor.ArrayHeaderGrowth(kept+2) > maxSize {
+			break
+		}
+		size += secSize
+		kept++
+	}
+	log.Info("Answer is too large for the last hop, truncating it", "token", tok,
+		"sections", len(answer), "kept", kept, "maxSize", maxSize)
+	notification.Data = truncationNote(kept, len(answer))
+	return append(answer[:kept:kept], notification)
+}
+
+//truncationNote returns the data of the notification of an answer truncated to kept of total
+//sections, which tells the client how to obtain the complete answer.
+func truncationNote(kept, total int) string {
+	return fmt.Sprintf("answer truncated to %d of %d sections, query fewer names or types per "+
+		"message or omit the option to minimize the last-hop answer size", kept, total)
+}
+
+//encodedLength returns the length of a CBOR encoded message containing sections.
+func encodedLength(sections []section.Section) int {
+	msg := message.Message{Token: token.New(), Content: sections}
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+		log.Warn("Was not able to encode answer", "error", err)
+	}
+	return encoding.Len()
+}
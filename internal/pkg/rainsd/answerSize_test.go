@@ -0,0 +1,47 @@
+package rainsd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+func TestTruncateAnswer(t *testing.T) {
+	answer := []section.Section{}
+	for i := 0; i < 40; i++ {
+		answer = append(answer, &section.Assertion{SubjectName: fmt.Sprintf("host%d", i),
+			SubjectZone: "example.", Context: ".", Content: []object.Object{
+				{Type: object.OTIP4Addr, Value: fmt.Sprintf("192.0.2.%d", i)}}})
+	}
+	full := encodedLength(answer)
+	for i, maxSize := range []int{0, 200, 1000, full - 1, full} {
+		truncated := truncateAnswer(answer, maxSize, token.New())
+		if maxSize >= full {
+			if len(truncated) != len(answer) {
+				t.Errorf("%d: answer of %d bytes truncated to %d bytes", i, full, maxSize)
+			}
+			continue
+		}
+		kept := len(truncated) - 1
+		if n, ok := truncated[kept].(*section.Notification); !ok ||
+			n.Type != section.NTMsgTooLarge {
+			t.Errorf("%d: truncated answer does not end with a NTMsgTooLarge notification", i)
+			continue
+		}
+		if kept > 0 && encodedLength(truncated) > maxSize {
+			t.Errorf("%d: truncated answer of %d bytes is larger than %d", i,
+				encodedLength(truncated), maxSize)
+		}
+		if kept < len(answer) {
+			longer := append(answer[:kept+1:kept+1], &section.Notification{
+				Type: section.NTMsgTooLarge, Data: truncationNote(kept+1, len(answer))})
+			if encodedLength(longer) <= maxSize {
+				t.Errorf("%d: kept %d sections although %d fit into %d bytes", i, kept, kept+1,
+					maxSize)
+			}
+		}
+	}
+}
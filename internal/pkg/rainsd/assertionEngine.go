@@ -9,6 +9,7 @@ import (
 
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/util"
 )
@@ -18,23 +19,54 @@ import (
 //The section's signatures MUST have already been verified and there MUST be at least one valid
 //rains signature on the message
 func (s *Server) assert(ss util.SectionWithSigSender) {
+	sections := make([]section.Section, len(ss.Sections))
+	for i, sec := range ss.Sections {
+		sections[i] = sec
+	}
+	sections, ok := s.intercept(BeforeCaching, ss.Token, ss.Sender, sections)
+	if !ok {
+		return
+	}
+	ss.Sections = ss.Sections[:0]
+	for _, sec := range sections {
+		ss.Sections = append(ss.Sections, sec.(section.WithSigForward))
+	}
 	log.Debug("Adding section to cache", "section", ss)
-	if sectionsAreInconsistent(ss.Sections, s.caches.AssertionsCache, s.caches.NegAssertionCache) {
-		log.Warn("section is inconsistent with cached elements.", "sections", ss.Sections)
-		sendNotificationMsg(ss.Token, ss.Sender, section.NTRcvInconsistentMsg, "", s)
+	if zone, reason := inconsistency(ss.Sections, s.config.ZoneAuthority,
+		s.config.ContextAuthority, s.caches.AssertionsCache, s.caches.NegAssertionCache); reason != "" {
+		log.Warn("section is inconsistent with cached elements.", "zone", zone, "reason", reason,
+			"sections", ss.Sections)
+		s.inconsistentZones.flag(zone)
+		sendNotificationMsg(ss.Token, ss.Sender, section.NTRcvInconsistentMsg, reason, s)
 		return
 	}
-	addSectionsToCache(ss.Sections, s.config.ZoneAuthority, s.config.ContextAuthority,
-		s.caches.AssertionsCache, s.caches.NegAssertionCache, s.caches.ZoneKeyCache)
+	pending := s.takePending(ss.Token)
+	if proactiveCaching(pending) {
+		addSectionsToCache(ss.Sections, s.config.ZoneAuthority, s.config.ContextAuthority,
+			s.caches.AssertionsCache, s.caches.NegAssertionCache, s.caches.ZoneKeyCache)
+	} else {
+		log.Info("Answer is not cached as all its queries suppress proactive caching",
+			"token", ss.Token)
+	}
 	pendingKeysCallback(ss, s.caches.PendingKeys, s.queues.Normal)
-	pendingQueriesCallback(ss, s)
+	pendingQueriesCallback(ss, pending, s)
 	log.Info(fmt.Sprintf("Finished handling %T", ss.Sections), "section", ss.Sections)
 }
 
-//sectionsAreInconsistent returns true if at least one section is not consistent with cached element
-//which are valid at the same time.
-func sectionsAreInconsistent(sec []section.WithSigForward, assertionsCache cache.Assertion,
-	negAssertionCache cache.NegativeAssertion) bool {
+//proactiveCaching returns false if the sections answering the pending queries must not be cached
+//because all of them have the QONoProactiveCaching option. Sections which do not answer a pending
+//query, e.g. pushed ones, are always cached.
+func proactiveCaching(pending []util.MsgSectionSender) bool {
+	if len(pending) == 0 {
+		return true
+	}
+	for _, ss := range pending {
+		for _, sec := range ss.Sections {
+			if q, ok := sec.(*query.Name); !ok || !q.ContainsOption(query.QONoProactiveCaching) {
+				return true
+			}
+		}
+	}
 	return false
 }
 
@@ -115,6 +147,10 @@ func addAssertionToCache(a *section.Assertion, isAuthoritative bool, assertionsC
 //assertionsCache.
 func addShardToCache(shard *section.Shard, isAuthoritative bool, assertionsCache cache.Assertion,
 	negAssertionCache cache.NegativeAssertion, zoneKeyCache cache.ZonePublicKey) {
+	if isAuthoritative {
+		removeOutdatedAssertions(shard.SubjectZone, shard.Context, shard.InRange, shard.Content,
+			assertionsCache)
+	}
 	for _, assertion := range shard.Content {
 		if shouldAssertionBeCached(assertion) {
 			a := assertion.Copy(shard.Context, shard.SubjectZone)
@@ -133,10 +169,27 @@ func addPshardToCache(pshard *section.Pshard, isAuthoritative bool, assertionsCa
 	log.Debug("Added pshard to cache", "pshard", *pshard)
 }
 
+//removeOutdatedAssertions removes all cached assertions of zone and context in range which are not
+//contained in content. An authoritative shard or zone lists all assertions in its range. Thus,
+//cached assertions missing in it have been removed by the zone authority, e.g. in a differential
+//push.
+func removeOutdatedAssertions(zone, context string, inRange func(string) bool,
+	content []*section.Assertion, assertionsCache cache.Assertion) {
+	keep := make(map[string]bool)
+	for _, a := range content {
+		keep[a.Copy(context, zone).Hash()] = true
+	}
+	assertionsCache.RemoveOutdated(zone, context, inRange, keep)
+}
+
 //addZoneToCache adds zone and all contained shards to the negAssertion cache and all contained
 //assertions to the assertionCache.
 func addZoneToCache(zone *section.Zone, isAuthoritative bool, assertionsCache cache.Assertion,
 	negAssertionCache cache.NegativeAssertion, zoneKeyCache cache.ZonePublicKey) {
+	if isAuthoritative {
+		removeOutdatedAssertions(zone.SubjectZone, zone.Context, func(string) bool { return true },
+			zone.Content, assertionsCache)
+	}
 	for _, assertion := range zone.Content {
 		if shouldAssertionBeCached(assertion) {
 			a := assertion.Copy(zone.Context, zone.SubjectZone)
@@ -155,8 +208,9 @@ func pendingKeysCallback(mss util.SectionWithSigSender, pendingKeys cache.Pendin
 	}
 }
 
-func pendingQueriesCallback(mss util.SectionWithSigSender, s *Server) {
-	msss := s.caches.PendingQueries.GetAndRemove(mss.Token)
+//pendingQueriesCallback answers the queries msss which were waiting for the sections of mss.
+func pendingQueriesCallback(mss util.SectionWithSigSender, msss []util.MsgSectionSender,
+	s *Server) {
 	if len(msss) == 0 {
 		return
 	}
@@ -165,6 +219,7 @@ func pendingQueriesCallback(mss util.SectionWithSigSender, s *Server) {
 		answer = append(answer, sec)
 	}
 	for _, ss := range msss {
-		sendSections(answer, ss.Token, ss.Sender, s)
+		sendAnswer(addDenial(s.shapeAnswer(answer, ss.Sections, ss.Token), ss.Sections, ss.Token),
+			ss.Token, ss.Sender, s)
 	}
 }
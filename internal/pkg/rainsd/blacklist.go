@@ -0,0 +1,132 @@
+package rainsd
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//blacklist stores ip addresses and networks from which no connections are accepted. It is safe for
+//concurrent use.
+type blacklist struct {
+	mux      sync.RWMutex
+	networks map[string]*net.IPNet
+}
+
+func newBlacklist() *blacklist {
+	return &blacklist{networks: make(map[string]*net.IPNet)}
+}
+
+//parseNetwork returns the network described by entry which is either an ip address or a network in
+//CIDR notation.
+func parseNetwork(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("malformed network %s: %v", entry, err)
+		}
+		return network, nil
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("malformed ip address %s", entry)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+//Add adds the ip address or network entry to the blacklist.
+func (b *blacklist) Add(entry string) error {
+	network, err := parseNetwork(entry)
+	if err != nil {
+		return err
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.networks[network.String()] = network
+	return nil
+}
+
+//Remove deletes the ip address or network entry from the blacklist. It returns false if entry was
+//not blacklisted.
+func (b *blacklist) Remove(entry string) (bool, error) {
+	network, err := parseNetwork(entry)
+	if err != nil {
+		return false, err
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	_, ok := b.networks[network.String()]
+	delete(b.networks, network.String())
+	return ok, nil
+}
+
+//Replace sets the content of the blacklist to entries. The blacklist is not modified if an entry
+//is malformed.
+func (b *blacklist) Replace(entries []string) error {
+	networks, err := parseBlacklist(entries)
+	if err != nil {
+		return err
+	}
+	b.set(networks)
+	return nil
+}
+
+//parseBlacklist returns the networks of entries by their CIDR notation.
+func parseBlacklist(entries []string) (map[string]*net.IPNet, error) {
+	networks := make(map[string]*net.IPNet)
+	for _, entry := range entries {
+		network, err := parseNetwork(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks[network.String()] = network
+	}
+	return networks, nil
+}
+
+//set replaces the content of the blacklist with networks.
+func (b *blacklist) set(networks map[string]*net.IPNet) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.networks = networks
+}
+
+//Entries returns all blacklisted networks in CIDR notation.
+func (b *blacklist) Entries() []string {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	entries := []string{}
+	for entry := range b.networks {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+//Contains returns true if the ip address of addr is blacklisted.
+func (b *blacklist) Contains(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	for _, network := range b.networks {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+//Len returns the number of blacklisted networks.
+func (b *blacklist) Len() int {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return len(b.networks)
+}
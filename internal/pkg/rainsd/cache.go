@@ -44,13 +44,27 @@ func initCaches(config rainsdConfig) *Caches {
 
 	caches.PendingQueries = cache.NewPendingQuery(config.PendingQueryCacheSize)
 
-	caches.AssertionsCache = cache.NewAssertion(config.AssertionCacheSize)
+	assertions := cache.NewAssertion(config.AssertionCacheSize)
+	//Expired assertions are kept as long as they can be served stale.
+	assertions.Retention = config.MaxStaleAnswerAge
+	caches.AssertionsCache = assertions
 
 	caches.NegAssertionCache = cache.NewNegAssertion(config.NegativeAssertionCacheSize)
 
 	return caches
 }
 
+//resize changes the sizes of the caches to the ones of config.
+func (c *Caches) resize(config rainsdConfig) {
+	c.ConnCache.Resize(config.MaxConnections)
+	c.Capabilities.Resize(config.CapabilitiesCacheSize)
+	c.ZoneKeyCache.Resize(config.ZoneKeyCacheSize)
+	c.PendingKeys.Resize(config.PendingKeyCacheSize)
+	c.PendingQueries.Resize(config.PendingQueryCacheSize)
+	c.AssertionsCache.Resize(config.AssertionCacheSize)
+	c.NegAssertionCache.Resize(config.NegativeAssertionCacheSize)
+}
+
 func initReapers(config rainsdConfig, caches *Caches, stop chan bool) {
 	go repeatFuncCaller(caches.ZoneKeyCache.RemoveExpiredKeys, config.ReapVerifyTimeout, stop)
 	go repeatFuncCaller(caches.PendingKeys.RemoveExpiredValues, config.ReapVerifyTimeout, stop)
@@ -0,0 +1,241 @@
+package rainsd
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cache"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//inconsistency returns a description of the first contradiction between a section of sections and
+//a cached section valid at the same time, together with the zone of the section. It returns an
+//empty description if all sections are consistent with the caches. Shards and zones list all
+//assertions of their zone and context in their range and pshards deny the types their bloom filter
+//does not contain. A section contradicts a cached section if one of them denies an object the
+//other asserts. Sections of zones over which the server has authority are not checked as they
+//replace the cached content of their range.
+func inconsistency(sections []section.WithSigForward, authZone, authContext []string,
+	assertionsCache cache.Assertion, negAssertionCache cache.NegativeAssertion) (string, string) {
+	for _, sec := range sections {
+		if isAuthoritative(sec, authZone, authContext) {
+			continue
+		}
+		zone, context := sec.GetSubjectZone(), sec.GetContext()
+		cached, _ := negAssertionCache.Get(zone, context, sec)
+		reason := ""
+		switch sec := sec.(type) {
+		case *section.Assertion:
+			reason = isAssertionConsistent(sec, cached)
+		case *section.Shard:
+			reason = isShardConsistent(sec, sec.InRange, sec.Content,
+				assertionsCache.GetInRange(zone, context, sec.InRange), cached)
+		case *section.Zone:
+			inRange := func(string) bool { return true }
+			reason = isShardConsistent(sec, inRange, sec.Content,
+				assertionsCache.GetInRange(zone, context, inRange), cached)
+		case *section.Pshard:
+			reason = isPshardConsistent(sec, assertionsCache.GetInRange(zone, context, sec.InRange),
+				cached)
+		}
+		if reason != "" {
+			return zone, reason
+		}
+	}
+	return "", ""
+}
+
+//isAssertionConsistent returns a description of the contradiction between a and a section of
+//cached or an empty string if there is none.
+func isAssertionConsistent(a *section.Assertion, cached []section.WithSigForward) string {
+	for _, c := range cached {
+		if !validAtSameTime(a, c) {
+			continue
+		}
+		switch c := c.(type) {
+		case *section.Shard:
+			if reason := deniedByListing(a, c.InRange, c.Content, c); reason != "" {
+				return reason
+			}
+		case *section.Zone:
+			if reason := deniedByListing(a, func(string) bool { return true }, c.Content,
+				c); reason != "" {
+				return reason
+			}
+		case *section.Pshard:
+			if reason := deniedByPshard(a, c); reason != "" {
+				return reason
+			}
+		}
+	}
+	return ""
+}
+
+//isShardConsistent returns a description of the contradiction between the shard or zone sec, which
+//lists content in range, and the cached assertions and shards, zones and pshards overlapping its
+//range or an empty string if there is none.
+func isShardConsistent(sec section.WithSigForward, inRange func(string) bool,
+	content []*section.Assertion, assertions []*section.Assertion,
+	cached []section.WithSigForward) string {
+	for _, a := range assertions {
+		if validAtSameTime(sec, a) {
+			if reason := deniedByListing(a, inRange, content, sec); reason != "" {
+				return reason
+			}
+		}
+	}
+	for _, c := range cached {
+		if !validAtSameTime(sec, c) {
+			continue
+		}
+		var cInRange func(string) bool
+		var cContent []*section.Assertion
+		switch c := c.(type) {
+		case *section.Shard:
+			cInRange, cContent = c.InRange, c.Content
+		case *section.Zone:
+			cInRange, cContent = func(string) bool { return true }, c.Content
+		case *section.Pshard:
+			for _, a := range content {
+				if reason := deniedByPshard(a.Copy(sec.GetContext(), sec.GetSubjectZone()),
+					c); reason != "" {
+					return reason
+				}
+			}
+			continue
+		}
+		for _, a := range cContent {
+			if reason := deniedByListing(a, inRange, content, sec); reason != "" {
+				return reason
+			}
+		}
+		for _, a := range content {
+			if reason := deniedByListing(a, cInRange, cContent, c); reason != "" {
+				return reason
+			}
+		}
+	}
+	return ""
+}
+
+//isPshardConsistent returns a description of the contradiction between p and the cached
+//assertions in its range and the cached shards and zones overlapping its range or an empty string
+//if there is none. Pshards are not compared with each other as bloom filters cannot be compared.
+func isPshardConsistent(p *section.Pshard, assertions []*section.Assertion,
+	cached []section.WithSigForward) string {
+	for _, a := range assertions {
+		if validAtSameTime(p, a) {
+			if reason := deniedByPshard(a, p); reason != "" {
+				return reason
+			}
+		}
+	}
+	for _, c := range cached {
+		var content []*section.Assertion
+		switch c := c.(type) {
+		case *section.Shard:
+			content = c.Content
+		case *section.Zone:
+			content = c.Content
+		}
+		if !validAtSameTime(p, c) {
+			continue
+		}
+		for _, a := range content {
+			if reason := deniedByPshard(a.Copy(c.GetContext(), c.GetSubjectZone()), p); reason != "" {
+				return reason
+			}
+		}
+	}
+	return ""
+}
+
+//deniedByListing returns a description of the contradiction if the subject name of a is in range
+//but not all objects of a are listed in content, the assertions of the shard or zone listing.
+func deniedByListing(a *section.Assertion, inRange func(string) bool,
+	content []*section.Assertion, listing section.WithSigForward) string {
+	if !inRange(a.SubjectName) {
+		return ""
+	}
+	listed := make(map[string]bool)
+	for _, l := range content {
+		if l.SubjectName == a.SubjectName {
+			for _, o := range l.Content {
+				listed[o.String()] = true
+			}
+		}
+	}
+	var missing []string
+	for _, o := range a.Content {
+		if !listed[o.String()] {
+			missing = append(missing, o.String())
+		}
+	}
+	if len(missing) == 0 {
+		return ""
+	}
+	sort.Strings(missing)
+	kind := "shard of zone"
+	if _, ok := listing.(*section.Zone); ok {
+		kind = "zone"
+	}
+	return fmt.Sprintf("%s %s does not list %v of name %s", kind, listing.GetSubjectZone(),
+		missing, a.SubjectName)
+}
+
+//deniedByPshard returns a description of the contradiction if p denies the existence of an object
+//type of a.
+func deniedByPshard(a *section.Assertion, p *section.Pshard) string {
+	if !p.InRange(a.SubjectName) {
+		return ""
+	}
+	types := make(map[object.Type]bool)
+	for _, o := range a.Content {
+		types[o.Type] = true
+	}
+	for t := range types {
+		q := &query.Name{Context: a.Context, Name: a.FQDN(), Types: []object.Type{t}}
+		if nonexistent, err := p.IsNonexistent(q); err == nil && nonexistent {
+			return fmt.Sprintf("pshard of zone %s denies type %v of name %s", p.SubjectZone, t,
+				a.SubjectName)
+		}
+	}
+	return ""
+}
+
+//validAtSameTime returns true if the validity periods of a and b overlap.
+func validAtSameTime(a, b section.WithSig) bool {
+	return a.ValidSince() <= b.ValidUntil() && b.ValidSince() <= a.ValidUntil()
+}
+
+//inconsistentZones counts the sections per zone which have been dropped because they contradicted
+//cached sections. A zone with inconsistencies is misconfigured or one of its signing keys is
+//compromised. It is safe for concurrent use.
+type inconsistentZones struct {
+	mux   sync.Mutex
+	zones map[string]int
+}
+
+//flag counts a dropped inconsistent section of zone.
+func (z *inconsistentZones) flag(zone string) {
+	z.mux.Lock()
+	defer z.mux.Unlock()
+	if z.zones == nil {
+		z.zones = make(map[string]int)
+	}
+	z.zones[zone]++
+}
+
+//statistics returns the number of dropped inconsistent sections per zone.
+func (z *inconsistentZones) statistics() map[string]int {
+	z.mux.Lock()
+	defer z.mux.Unlock()
+	stats := make(map[string]int, len(z.zones))
+	for zone, count := range z.zones {
+		stats[zone] = count
+	}
+	return stats
+}
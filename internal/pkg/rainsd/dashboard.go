@@ -0,0 +1,164 @@
+package rainsd
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	log "github.com/inconshreveable/log15"
+)
+
+//serveDashboard serves the web dashboard on DashboardAddress until the server shuts down. The
+//page at / shows the statistics returned by /stats, which are the same as the ones of the admin
+//command stats. The dashboard is read only and does not require authentication, so it should
+//only be reachable by the operators, e.g. by listening on the loopback interface.
+func (s *Server) serveDashboard() {
+	listener, err := net.Listen("tcp", s.config.DashboardAddress)
+	if err != nil {
+		log.Error("Could not listen on dashboard address", "addr", s.config.DashboardAddress,
+			"error", err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(dashboardPage))
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(s.statistics()); err != nil {
+			log.Warn("Could not send dashboard statistics", "error", err)
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-s.shutdown
+		server.Close()
+	}()
+	log.Info("Dashboard started", "addr", listener.Addr())
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		log.Error("Dashboard stopped", "error", err)
+	}
+}
+
+//dashboardPage polls /stats every two seconds and shows the utilization of the caches, queues and
+//workers, the query rate per zone computed from the difference of consecutive polls, the traffic
+//exchanged with each peer and the recent notifications.
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>rainsd</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 1.5em; }
+table { border-collapse: collapse; min-width: 30em; }
+th, td { text-align: left; padding: 0.2em 1em 0.2em 0; border-bottom: 1px solid #ddd; }
+td.num { text-align: right; }
+.bar { display: inline-block; height: 0.8em; background: #4a90d9; }
+.full { background: #d9534f; }
+.down { color: #d9534f; }
+#error { color: #d9534f; }
+</style>
+</head>
+<body>
+<h1>rainsd <span id="uptime"></span></h1>
+<div id="error"></div>
+<h2>Caches</h2>
+<table id="caches"></table>
+<h2>Backlog</h2>
+<table id="backlog"></table>
+<h2>Queries per zone</h2>
+<table id="queries"></table>
+<h2>Peers</h2>
+<table id="peers"></table>
+<h2>Recent notifications</h2>
+<table id="notifications"></table>
+<script>
+"use strict";
+const interval = 2000;
+let previous = null;
+
+function esc(v) {
+	return String(v).replace(/[&<>"]/g, c => ({"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;"})[c]);
+}
+
+function table(id, header, rows) {
+	let html = "<tr>" + header.map(h => "<th>" + esc(h) + "</th>").join("") + "</tr>";
+	for (const row of rows) {
+		html += "<tr>" + row.map(c => typeof c === "number" ? "<td class=num>" + c + "</td>" :
+			"<td>" + (c && c.html !== undefined ? c.html : esc(c)) + "</td>").join("") + "</tr>";
+	}
+	document.getElementById(id).innerHTML = html;
+}
+
+function bar(value, max) {
+	if (!max) {
+		return {html: ""};
+	}
+	const ratio = Math.min(value / max, 1);
+	return {html: "<span class='bar" + (ratio >= 0.9 ? " full" : "") + "' style='width:" +
+		Math.round(ratio * 200) + "px'></span> " + Math.round(ratio * 100) + "%"};
+}
+
+function render(stats, now) {
+	document.getElementById("uptime").textContent = "up " + Math.round(stats.Uptime / 1e9) + "s, " +
+		stats.Connections + " connections, " + stats.Blacklisted + " blacklisted";
+	table("caches", ["Cache", "Entries", "Capacity", "Utilization"],
+		Object.keys(stats.Caches).sort().map(name => [name, stats.Caches[name],
+			stats.Capacities[name] || "", bar(stats.Caches[name], stats.Capacities[name])]));
+	const backlog = [["pending queries", stats.Caches.pendingQueries],
+		["pending keys", stats.Caches.pendingKeys]];
+	for (const name of Object.keys(stats.Queues).sort()) {
+		backlog.push(["queue " + name, stats.Queues[name]]);
+		backlog.push(["busy workers " + name, stats.Workers[name]]);
+	}
+	table("backlog", ["", "Length"], backlog);
+	const queries = Object.keys(stats.Queries || {}).map(zone => {
+		let rate = "";
+		if (previous && previous.stats.Queries) {
+			const delta = stats.Queries[zone] - (previous.stats.Queries[zone] || 0);
+			rate = Math.round(delta / ((now - previous.time) / 1000) * 10) / 10;
+		}
+		return [zone, stats.Queries[zone], rate];
+	});
+	queries.sort((a, b) => (b[2] || 0) - (a[2] || 0) || b[1] - a[1]);
+	table("queries", ["Zone", "Total", "Queries/s"], queries);
+	table("peers", ["Peer", "Status", "Received", "Sent", "Failed", "Last seen", "Last error"],
+		(stats.Peers || []).map(p => [p.Addr,
+			p.Connected ? "connected" : {html: "<span class=down>disconnected</span>"},
+			p.Received, p.Sent, p.Failed,
+			p.LastSeen.startsWith("0001") ? "" : new Date(p.LastSeen).toLocaleTimeString(),
+			p.LastError || ""]));
+	table("notifications", ["Time", "Direction", "Peer", "Type", "Data"],
+		(stats.Notifications || []).map(n => [new Date(n.Time).toLocaleTimeString(),
+			n.Sent ? "sent" : "received", n.Peer, n.Type, n.Data || ""]));
+}
+
+async function poll() {
+	try {
+		const response = await fetch("stats", {cache: "no-store"});
+		if (!response.ok) {
+			throw new Error(response.status + " " + response.statusText);
+		}
+		const stats = await response.json();
+		const now = Date.now();
+		render(stats, now);
+		previous = {stats: stats, time: now};
+		document.getElementById("error").textContent = "";
+	} catch (e) {
+		document.getElementById("error").textContent = "Could not load statistics: " + e;
+	}
+	setTimeout(poll, interval);
+}
+poll();
+</script>
+</body>
+</html>
+`
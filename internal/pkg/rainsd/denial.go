@@ -0,0 +1,124 @@
+package rainsd
+
+import (
+	"strings"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//nonexistenceProof returns the section of sections with the fewest assertions which proves that
+//subject has no object of the given types in the context and zone of q. It returns nil if none of
+//the sections proves it.
+func nonexistenceProof(q *query.Name, subject string, types []object.Type,
+	sections []section.WithSigForward) section.WithSigForward {
+	denied := &query.Name{Context: q.Context, Name: q.Name, Types: types}
+	var proof section.WithSigForward
+	for _, sec := range sections {
+		if provesNonexistence(sec, denied, subject) &&
+			(proof == nil || contentLength(sec) < contentLength(proof)) {
+			proof = sec
+		}
+	}
+	return proof
+}
+
+//provesNonexistence returns true if sec is a shard or zone whose range includes subject and which
+//does not contain an assertion about subject answering q, or a pshard whose Bloom filter contains
+//none of the types of q for subject. The names of shards, zones and pshards are relative to their
+//zone, such that subject is the name of q without the zone.
+func provesNonexistence(sec section.Section, q *query.Name, subject string) bool {
+	var content []*section.Assertion
+	switch sec := sec.(type) {
+	case *section.Shard:
+		if !sec.InRange(subject) || sec.Context != q.Context {
+			return false
+		}
+		content = sec.Content
+	case *section.Zone:
+		if sec.Context != q.Context {
+			return false
+		}
+		content = sec.Content
+	case *section.Pshard:
+		nonexistent, err := sec.IsNonexistent(q)
+		return err == nil && nonexistent
+	default:
+		return false
+	}
+	for _, a := range content {
+		if a.SubjectName == subject && answersQuery(a, q) {
+			return false
+		}
+	}
+	return true
+}
+
+//addDenial appends a NTNoAssertionsExist notification with token tok to answer if answer contains
+//no assertion but for each query of queries a section proving that the queried name has none of
+//the queried types. The proofs are thus sent together with the notification, which lets clients
+//distinguish an authenticated denial of existence from an unavailable answer.
+func addDenial(answer []section.Section, queries []section.Section,
+	tok token.Token) []section.Section {
+	if len(answer) == 0 {
+		return answer
+	}
+	for _, sec := range answer {
+		if _, ok := sec.(*section.Assertion); ok {
+			return answer
+		}
+	}
+	names := []string{}
+	for _, sec := range queries {
+		q, ok := sec.(*query.Name)
+		if !ok {
+			return answer
+		}
+		subject, _, err := toSubjectZone(q.Name)
+		if err != nil {
+			return answer
+		}
+		proven := false
+		for _, proof := range answer {
+			if proven = provesNonexistence(proof, q, subject); proven {
+				break
+			}
+		}
+		if !proven {
+			return answer
+		}
+		names = append(names, q.Name)
+	}
+	//answer may be shared by the answers to several clients which get notifications with different
+	//tokens.
+	return append(answer[:len(answer):len(answer)], &section.Notification{
+		Type:  section.NTNoAssertionsExist,
+		Token: tok,
+		Data:  "no assertions exist for " + strings.Join(names, " "),
+	})
+}
+
+//answersQuery returns true if a contains an object of a type asked for in q.
+func answersQuery(a *section.Assertion, q *query.Name) bool {
+	for _, o := range a.Content {
+		for _, t := range q.Types {
+			if o.Type == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//contentLength returns the number of assertions contained in sec. Pshards contain none.
+func contentLength(sec section.WithSigForward) int {
+	switch sec := sec.(type) {
+	case *section.Shard:
+		return len(sec.Content)
+	case *section.Zone:
+		return len(sec.Content)
+	}
+	return 0
+}
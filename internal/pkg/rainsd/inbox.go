@@ -3,25 +3,30 @@ package rainsd
 import (
 	"fmt"
 	"net"
-	"time"
+	"sort"
+	"strings"
 
 	"github.com/netsec-ethz/rains/internal/pkg/cache"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
-	"github.com/netsec-ethz/rains/internal/pkg/token"
 	"github.com/netsec-ethz/rains/internal/pkg/util"
 )
 
 type InputQueues struct {
 	//incoming messages are buffered in one of these channels until they get processed by a worker
 	//go routine the prioChannel only contains incoming sections in response to a delegation query
-	//issued by this server.
-	Prio   chan util.MsgSectionSender
-	Normal chan util.MsgSectionSender
-	Notify chan util.MsgSectionSender
+	//issued by this server. The delegation channel contains delegation queries of other servers,
+	//the normal channel all other queries and sections answering queries forwarded by this server
+	//and the bulk channel all other sections, e.g. pushed or published zones.
+	Prio       chan util.MsgSectionSender
+	Delegation chan util.MsgSectionSender
+	Normal     chan util.MsgSectionSender
+	Bulk       chan util.MsgSectionSender
+	Notify     chan util.MsgSectionSender
 
 	//These channels limit the number of go routines working on the different queues to avoid memory
 	//exhaustion.
@@ -30,17 +35,22 @@ type InputQueues struct {
 	NotifyW chan struct{}
 }
 
-//deliver pushes all incoming messages to the prio or normal channel.
-//A message is added to the priority channel if it is the response to a non-expired delegation query
+//deliver pushes all incoming messages to the prio, delegation, normal or bulk channel.
+//A message is added to the priority channel if it is the response to a non-expired delegation query,
+//delegation queries are added to the delegation channel and other queries to the normal channel as
+//are sections answering a query forwarded by this server. It returns true if sections of msg have
+//been added to the bulk channel, i.e. they have been pushed.
 func deliver(msg *message.Message, sender net.Addr, prioChannel chan util.MsgSectionSender,
-	normalChannel chan util.MsgSectionSender, notificationChannel chan util.MsgSectionSender,
-	pendingKeys cache.PendingKey) {
+	delegationChannel chan util.MsgSectionSender, normalChannel chan util.MsgSectionSender,
+	bulkChannel chan util.MsgSectionSender, notificationChannel chan util.MsgSectionSender,
+	pendingKeys cache.PendingKey, forwarded *activeTokens, connCache cache.Connection) bool {
 
 	//TODO Check message signatures here once they are implemented
 
-	processCapability(msg.Capabilities, sender, msg.Token)
+	processCapability(msg.Capabilities, sender, connCache)
 
 	//handle notification separately. Assertions and Queries are processed together respectively.
+	delegationQueries := []section.Section{}
 	queries := []section.Section{}
 	sections := []section.Section{}
 	for _, m := range msg.Content {
@@ -51,10 +61,21 @@ func deliver(msg *message.Message, sender net.Addr, prioChannel chan util.MsgSec
 				trace(msg.Token, fmt.Sprintf("added message section to queue: %v", m))
 			}
 		case *query.Name:
+			if isDelegationQuery(m) {
+				log.Debug(fmt.Sprintf("add %T to delegation queue", m))
+				delegationQueries = append(delegationQueries, m)
+				trace(msg.Token, fmt.Sprintf("sent query section %v to delegation channel", m))
+				continue
+			}
 			log.Debug(fmt.Sprintf("add %T to normal queue", m))
 			queries = append(queries, m)
 			trace(msg.Token, fmt.Sprintf("sent query section %v to normal channel", m))
 		case *section.Notification:
+			if m.Type == section.NTNoAssertionsExist && containsProof(msg.Content) {
+				//The attached proof answers the pending queries once it is verified.
+				log.Debug("Drop denial of existence with proof", "token", msg.Token)
+				continue
+			}
 			log.Debug("Add notification to notification queue", "token", msg.Token)
 			notificationChannel <- util.MsgSectionSender{
 				Sender:   sender,
@@ -65,9 +86,13 @@ func deliver(msg *message.Message, sender net.Addr, prioChannel chan util.MsgSec
 		default:
 			log.Warn(fmt.Sprintf("unsupported message section type %T", m))
 			trace(msg.Token, fmt.Sprintf("unsupported message section type: %T", m))
-			return
+			return false
 		}
 	}
+	if len(delegationQueries) > 0 {
+		delegationChannel <- util.MsgSectionSender{Sender: sender, Sections: delegationQueries,
+			Token: msg.Token}
+	}
 	if len(queries) > 0 {
 		normalChannel <- util.MsgSectionSender{Sender: sender, Sections: queries, Token: msg.Token}
 	}
@@ -76,17 +101,47 @@ func deliver(msg *message.Message, sender net.Addr, prioChannel chan util.MsgSec
 		if pendingKeys.ContainsToken(msg.Token) {
 			log.Debug("add section with signature to priority queue", "token", msg.Token)
 			prioChannel <- mss
-		} else {
+		} else if forwarded.contains(msg.Token) {
 			log.Debug("add section with signature to normal queue", "token", msg.Token)
 			normalChannel <- mss
+		} else {
+			log.Debug("add section with signature to bulk queue", "token", msg.Token)
+			bulkChannel <- mss
+			return true
+		}
+	}
+	return false
+}
+
+//isDelegationQuery returns true if q asks for the delegation of a zone.
+func isDelegationQuery(q *query.Name) bool {
+	for _, t := range q.Types {
+		if t == object.OTDelegation {
+			return true
 		}
 	}
+	return false
 }
 
-//processCapability processes capabilities and sends a notification back to the sender if the hash
-//is not understood.
-func processCapability(caps []message.Capability, sender net.Addr, token token.Token) {
-	log.Debug("Processing Capabilities not yet supported")
+//containsProof returns true if sections contain a shard, pshard or zone which may prove that no
+//assertion exists.
+func containsProof(sections []section.Section) bool {
+	for _, sec := range sections {
+		switch sec.(type) {
+		case *section.Shard, *section.Pshard, *section.Zone:
+			return true
+		}
+	}
+	return false
+}
+
+//processCapability stores the capabilities listed by the sender such that the messages sent to it
+//are encoded in a codec it supports. Capability hashes are not yet supported.
+func processCapability(caps []message.Capability, sender net.Addr, connCache cache.Connection) {
+	if len(caps) > 0 && strings.HasPrefix(string(caps[0]), "urn:") {
+		log.Debug("Process capabilities", "capabilities", caps, "sender", sender)
+		connCache.AddCapabilityList(sender, caps)
+	}
 	/*log.Debug("Process capabilities", "capabilities", caps)
 	if len(caps) > 0 {
 		isHash := !strings.HasPrefix(string(caps[0]), "urn:")
@@ -115,39 +170,156 @@ func isZoneBlacklisted(zone string) bool {
 	return false
 }
 
-//workBoth works on the prioChannel and on the normalChannel. A worker only fetches a message from
-//the normalChannel if the prioChannel is empty. the channel normalWorkers enforces a maximum number
-//of go routines working on the prioChannel and normalChannel.
+//workBoth works on the prioChannel, the delegationChannel, the normalChannel and the bulkChannel. A
+//worker only fetches a message from the other channels if the prioChannel is empty. While several
+//of them contain messages, the messages are taken in the ratio of DelegationQueueWeight to
+//NormalQueueWeight to BulkQueueWeight, such that a flood of pushed zones cannot starve the queries.
+//the channel normalWorkers enforces a maximum number of go routines working on the four channels.
 func (s *Server) workBoth() {
+	queues := []chan util.MsgSectionSender{s.queues.Delegation, s.queues.Normal, s.queues.Bulk}
+	scheduler := newWeightedScheduler(
+		weightOrDefault(s.config.DelegationQueueWeight, defaultDelegationQueueWeight),
+		weightOrDefault(s.config.NormalQueueWeight, defaultNormalQueueWeight),
+		weightOrDefault(s.config.BulkQueueWeight, defaultBulkQueueWeight))
 	for {
 		select {
 		case <-s.shutdown:
-			//Avoid closing the s.queues.Normal channel before server.Shutdown() has sent a dummy
-			//message in case this worker is not waiting on the s.queues.Normal channel
-			time.Sleep(time.Second)
-			close(s.queues.Normal)
-			close(s.queues.NormalW)
 			return
-		default:
+		case s.queues.NormalW <- struct{}{}:
 		}
-		s.queues.NormalW <- struct{}{}
 		select {
 		case msg := <-s.queues.Prio:
-			go prioWorkerHandler(s, msg, false)
+			s.process(func() { prioWorkerHandler(s, msg, false) })
 			continue
 		default:
 			//do nothing
 		}
+		if msg, ok := scheduler.next(queues); ok {
+			s.process(func() { normalWorkerHandler(s, msg) })
+			continue
+		}
+		//Block until any queue has a message instead of polling them.
 		select {
+		case msg := <-s.queues.Prio:
+			s.process(func() { prioWorkerHandler(s, msg, false) })
+		case msg := <-s.queues.Delegation:
+			scheduler.served(delegationQueue)
+			s.process(func() { normalWorkerHandler(s, msg) })
 		case msg := <-s.queues.Normal:
-			go normalWorkerHandler(s, msg)
-		default:
+			scheduler.served(normalQueue)
+			s.process(func() { normalWorkerHandler(s, msg) })
+		case msg := <-s.queues.Bulk:
+			scheduler.served(bulkQueue)
+			s.process(func() { normalWorkerHandler(s, msg) })
+		case <-s.shutdown:
 			<-s.queues.NormalW
+			return
+		}
+	}
+}
+
+//Indices of the queues scheduled by workBoth.
+const (
+	delegationQueue = iota
+	normalQueue
+	bulkQueue
+)
+
+const (
+	defaultDelegationQueueWeight = 8
+	defaultNormalQueueWeight     = 4
+	defaultBulkQueueWeight       = 1
+)
+
+//weightOrDefault returns weight or defaultWeight if weight is 0.
+func weightOrDefault(weight, defaultWeight uint) uint {
+	if weight == 0 {
+		return defaultWeight
+	}
+	return weight
+}
+
+//weightedScheduler decides from which queue the next message is taken, such that the messages are
+//taken in the ratio of the queues' weights while several of them contain messages. It is only used
+//by a single go routine.
+type weightedScheduler struct {
+	weights []int
+	//shares are the number of messages taken from each queue in the current round. A round ends
+	//when each queue has received its share or was empty.
+	shares []int
+}
+
+//newWeightedScheduler returns a weightedScheduler for queues with the given weights. A weight of 0
+//is treated as 1.
+func newWeightedScheduler(weights ...uint) *weightedScheduler {
+	w := &weightedScheduler{weights: make([]int, len(weights)), shares: make([]int, len(weights))}
+	for i, weight := range weights {
+		w.weights[i] = int(weight)
+		if weight == 0 {
+			w.weights[i] = 1
+		}
+	}
+	return w
+}
+
+//order returns the indices of the queues, starting with the one which has received the smallest
+//part of its share in the current round.
+func (w *weightedScheduler) order() []int {
+	order := make([]int, len(w.weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		return w.shares[a]*w.weights[b] < w.shares[b]*w.weights[a]
+	})
+	return order
+}
+
+//next takes a message from the first non empty queue in order without blocking. It returns false
+//if all queues are empty. The queues must be in the order of the scheduler's weights.
+func (w *weightedScheduler) next(queues []chan util.MsgSectionSender) (
+	util.MsgSectionSender, bool) {
+	for _, i := range w.order() {
+		select {
+		case msg := <-queues[i]:
+			w.served(i)
+			return msg, true
+		default:
+			w.idle(i)
 		}
 	}
+	return util.MsgSectionSender{}, false
+}
+
+//served counts a message taken from the queue with index i.
+func (w *weightedScheduler) served(i int) {
+	w.shares[i]++
+	w.endRound()
 }
 
-//normalWorkerHandler handles sections on the normalChannel
+//idle marks the queue with index i as empty. It does not receive more messages in the current round
+//such that a queue filling up again cannot take over the workers until the others had their share.
+func (w *weightedScheduler) idle(i int) {
+	if w.shares[i] < w.weights[i] {
+		w.shares[i] = w.weights[i]
+	}
+	w.endRound()
+}
+
+//endRound starts a new round if each queue has received its share.
+func (w *weightedScheduler) endRound() {
+	for i, share := range w.shares {
+		if share < w.weights[i] {
+			return
+		}
+	}
+	for i := range w.shares {
+		w.shares[i] = 0
+	}
+}
+
+//normalWorkerHandler handles sections on the delegationChannel, normalChannel and bulkChannel
 func normalWorkerHandler(s *Server, msg util.MsgSectionSender) {
 	if msg.Sections != nil {
 		s.verify(msg)
@@ -167,17 +339,16 @@ func (s *Server) workPrio() {
 	for {
 		select {
 		case <-s.shutdown:
-			//Avoid closing the s.queues.Prio channel before server.Shutdown() has sent a dummy
-			//message in case this worker is not waiting on the s.queues.Prio channel
-			time.Sleep(time.Second)
-			close(s.queues.Prio)
-			close(s.queues.PrioW)
 			return
-		default:
+		case s.queues.PrioW <- struct{}{}:
+		}
+		select {
+		case msg := <-s.queues.Prio:
+			s.process(func() { prioWorkerHandler(s, msg, true) })
+		case <-s.shutdown:
+			<-s.queues.PrioW
+			return
 		}
-		s.queues.PrioW <- struct{}{}
-		msg := <-s.queues.Prio
-		go prioWorkerHandler(s, msg, true)
 	}
 }
 
@@ -188,6 +359,8 @@ func prioWorkerHandler(s *Server, msg util.MsgSectionSender, prioWorker bool) {
 	}
 	if prioWorker {
 		<-s.queues.PrioW
+	} else {
+		<-s.queues.NormalW
 	}
 }
 
@@ -198,17 +371,16 @@ func (s *Server) workNotification() {
 	for {
 		select {
 		case <-s.shutdown:
-			//Avoid closing the s.queues.Notify channel before server.Shutdown() has sent a dummy
-			//message in case this worker is not waiting on the s.queues.Notify channel
-			time.Sleep(time.Second)
-			close(s.queues.Notify)
-			close(s.queues.NotifyW)
 			return
-		default:
+		case s.queues.NotifyW <- struct{}{}:
+		}
+		select {
+		case msg := <-s.queues.Notify:
+			s.process(func() { handleNotification(s, msg) })
+		case <-s.shutdown:
+			<-s.queues.NotifyW
+			return
 		}
-		s.queues.NotifyW <- struct{}{}
-		msg := <-s.queues.Notify
-		go handleNotification(s, msg)
 	}
 }
 
@@ -217,5 +389,5 @@ func handleNotification(s *Server, msg util.MsgSectionSender) {
 	if msg.Sections != nil {
 		s.notify(msg)
 	}
-	<-s.queues.NormalW
+	<-s.queues.NotifyW
 }
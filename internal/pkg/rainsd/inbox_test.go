@@ -0,0 +1,120 @@
+package rainsd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//fillQueue returns a queue containing n messages.
+func fillQueue(n int) chan util.MsgSectionSender {
+	queue := make(chan util.MsgSectionSender, n)
+	for i := 0; i < n; i++ {
+		queue <- util.MsgSectionSender{}
+	}
+	return queue
+}
+
+func TestWeightedSchedulerRatio(t *testing.T) {
+	var tests = []struct {
+		weights  []uint
+		filled   []int
+		taken    int
+		expected []int
+	}{
+		{[]uint{8, 4, 1}, []int{0, 50, 50}, 25, []int{0, 20, 5}},
+		{[]uint{8, 4, 1}, []int{50, 50, 50}, 26, []int{16, 8, 2}},
+		{[]uint{8, 4, 1}, []int{0, 0, 50}, 10, []int{0, 0, 10}},
+		{[]uint{8, 4, 1}, []int{3, 50, 50}, 13, []int{3, 8, 2}},
+		{[]uint{0, 1, 0}, []int{50, 50, 50}, 9, []int{3, 3, 3}},
+	}
+	for i, test := range tests {
+		scheduler := newWeightedScheduler(test.weights...)
+		queues := make([]chan util.MsgSectionSender, len(test.filled))
+		for j, n := range test.filled {
+			queues[j] = fillQueue(n)
+		}
+		for j := 0; j < test.taken; j++ {
+			if _, ok := scheduler.next(queues); !ok {
+				t.Fatalf("%d: no message taken although the queues are not empty", i)
+			}
+		}
+		for j, queue := range queues {
+			if taken := test.filled[j] - len(queue); taken != test.expected[j] {
+				t.Errorf("%d: wrong number of messages taken from queue %d. expected=%d actual=%d",
+					i, j, test.expected[j], taken)
+			}
+		}
+	}
+	scheduler := newWeightedScheduler(8, 4, 1)
+	if _, ok := scheduler.next([]chan util.MsgSectionSender{fillQueue(0), fillQueue(0),
+		fillQueue(0)}); ok {
+		t.Error("message taken from empty queues")
+	}
+}
+
+func TestWeightedSchedulerBulkFlood(t *testing.T) {
+	scheduler := newWeightedScheduler(8, 4, 1)
+	queues := []chan util.MsgSectionSender{fillQueue(0), make(chan util.MsgSectionSender, 1),
+		fillQueue(1000)}
+	query := util.MsgSectionSender{Token: token.New()}
+	for i := 0; i < 100; i++ {
+		//Bulk messages are processed while no query is waiting.
+		for j := 0; j < i%7; j++ {
+			if msg, ok := scheduler.next(queues); !ok || msg.Token == query.Token {
+				t.Fatalf("%d: expected a bulk message. ok=%t", i, ok)
+			}
+		}
+		queues[normalQueue] <- query
+		//A query waits at most for the bulk queue's share of one round.
+		for steps := 1; ; steps++ {
+			msg, ok := scheduler.next(queues)
+			if !ok {
+				t.Fatalf("%d: no message taken", i)
+			}
+			if msg.Token == query.Token {
+				break
+			}
+			if steps > 1 {
+				t.Fatalf("%d: query starved by bulk messages, waited %d steps", i, steps)
+			}
+		}
+	}
+}
+
+func TestDeliverQueries(t *testing.T) {
+	delegation := &query.Name{Name: "ch.", Context: ".",
+		Types: []object.Type{object.OTIP4Addr, object.OTDelegation}}
+	address := &query.Name{Name: "ethz.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr}}
+	msg := &message.Message{Token: token.New(),
+		Content: []section.Section{delegation, address, delegation}}
+	queues := []chan util.MsgSectionSender{make(chan util.MsgSectionSender, 1),
+		make(chan util.MsgSectionSender, 1), make(chan util.MsgSectionSender, 1)}
+	if deliver(msg, nil, nil, queues[delegationQueue], queues[normalQueue], queues[bulkQueue], nil,
+		nil, nil, nil) {
+		t.Error("queries were reported as pushed sections")
+	}
+	expected := [][]section.Section{{delegation, delegation}, {address}, nil}
+	for i, queue := range queues {
+		if expected[i] == nil {
+			if len(queue) != 0 {
+				t.Errorf("%d: unexpected message in queue", i)
+			}
+			continue
+		}
+		if len(queue) != 1 {
+			t.Errorf("%d: wrong number of messages. expected=1 actual=%d", i, len(queue))
+			continue
+		}
+		mss := <-queue
+		if mss.Token != msg.Token || !reflect.DeepEqual(mss.Sections, expected[i]) {
+			t.Errorf("%d: wrong message. expected=%v actual=%v", i, expected[i], mss.Sections)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package rainsd
+
+import (
+	"net"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//Stage identifies the point in the processing of queries and sections at which a middleware is
+//called.
+type Stage int
+
+//Stages at which middlewares can be registered.
+const (
+	//BeforeCacheLookup is reached by verified, non expired queries before they are answered from
+	//the cache or forwarded.
+	BeforeCacheLookup Stage = iota
+	//BeforeCaching is reached by received assertions, shards, pshards and zones whose signatures
+	//have been verified, before they are cached and used to answer pending queries.
+	BeforeCaching
+	//BeforeForwarding is reached by queries which cannot be answered from the cache, before they
+	//are sent to the recursive resolver. This includes the delegation queries of an authoritative
+	//server for missing public keys.
+	BeforeForwarding
+	//BeforeAnswer is reached by the answer to a query before it is sent to the client.
+	BeforeAnswer
+	numStages
+)
+
+//stageNames contains the names of the stages as returned by String.
+var stageNames = [numStages]string{"BeforeCacheLookup", "BeforeCaching", "BeforeForwarding",
+	"BeforeAnswer"}
+
+//String returns the name of the stage, e.g. BeforeAnswer.
+func (s Stage) String() string {
+	if s < 0 || s >= numStages {
+		return "UnknownStage"
+	}
+	return stageNames[s]
+}
+
+//Request is the data passed to a middleware.
+type Request struct {
+	Stage Stage
+	//Token is the token of the message containing Sections.
+	Token token.Token
+	//Peer is the client which sent the queries or sections respectively to which the answer is
+	//sent. It is nil for forwarded queries as they may combine the queries of several clients.
+	Peer net.Addr
+	//Sections are the queries, the received sections or the answer. A middleware may modify them,
+	//e.g. remove sections or rewrite queries. At BeforeCacheLookup and BeforeForwarding, all
+	//sections must be queries. At BeforeCaching, they must be assertions, shards, pshards or
+	//zones. Other sections are dropped. Modifying a signed section invalidates its signatures.
+	Sections []section.Section
+}
+
+//Middleware observes or modifies the request and returns true if its processing should continue.
+//If it returns false, the request is dropped without notifying the peer and the following
+//middlewares are not called. The request is also dropped if the middlewares removed all of its
+//sections. A middleware is called concurrently for different requests.
+type Middleware func(r *Request) bool
+
+//middlewares contains the middlewares of a server by stage in the order in which they have been
+//registered. It is safe for concurrent use.
+type middlewares struct {
+	mux    sync.RWMutex
+	chains [numStages][]Middleware
+}
+
+//Use appends m to the middlewares called at stage, such that it is called after all middlewares
+//previously registered for stage. It can be called before or while the server is running, e.g. to
+//add custom policies, filters or logging to an embedded server.
+func (s *Server) Use(stage Stage, m Middleware) {
+	if stage < 0 || stage >= numStages {
+		log.Error("Middleware registered for unknown stage", "stage", int(stage))
+		return
+	}
+	s.middlewares.mux.Lock()
+	defer s.middlewares.mux.Unlock()
+	s.middlewares.chains[stage] = append(s.middlewares.chains[stage], m)
+}
+
+//intercept passes sections through the middlewares of stage. It returns the possibly modified
+//sections and false if a middleware dropped them or removed all sections of a type allowed at
+//stage.
+func (s *Server) intercept(stage Stage, tok token.Token, peer net.Addr,
+	sections []section.Section) ([]section.Section, bool) {
+	s.middlewares.mux.RLock()
+	chain := s.middlewares.chains[stage]
+	s.middlewares.mux.RUnlock()
+	if len(chain) == 0 {
+		return sections, true
+	}
+	r := &Request{Stage: stage, Token: tok, Peer: peer, Sections: sections}
+	for i, m := range chain {
+		if !m(r) {
+			log.Info("Middleware dropped request", "stage", stage, "middleware", i, "token", tok)
+			return nil, false
+		}
+	}
+	allowed := []section.Section{}
+	for _, sec := range r.Sections {
+		if allowedAt(stage, sec) {
+			allowed = append(allowed, sec)
+		} else {
+			log.Warn("Middleware returned section not allowed at stage", "stage", stage,
+				"section", sec)
+		}
+	}
+	return allowed, len(allowed) > 0 || len(sections) == 0
+}
+
+//allowedAt returns true if sec can be processed after the middlewares of stage.
+func allowedAt(stage Stage, sec section.Section) bool {
+	switch stage {
+	case BeforeCacheLookup, BeforeForwarding:
+		_, ok := sec.(*query.Name)
+		return ok
+	case BeforeCaching:
+		switch sec.(type) {
+		case *section.Assertion, *section.Shard, *section.Pshard, *section.Zone:
+			return true
+		}
+		return false
+	}
+	return sec != nil
+}
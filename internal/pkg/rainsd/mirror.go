@@ -0,0 +1,264 @@
+package rainsd
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/probe"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//mirrorQueueSize is the number of sampled queries waiting to be sent to the shadow server. Further
+//queries are dropped such that a slow shadow server never delays the processing of queries.
+const mirrorQueueSize = 1000
+
+//Names of the counters of the mirror reported by the stats command.
+const (
+	MirrorSent      = "sent"
+	MirrorDropped   = "dropped"
+	MirrorFailed    = "failed"
+	MirrorIdentical = "identical"
+	MirrorDifferent = "different"
+)
+
+//mirror asynchronously sends a fraction of the received queries to a shadow server. The shadow's
+//answers are discarded or, if diff is set, compared with the answers of this server. It is safe
+//for concurrent use.
+type mirror struct {
+	addr     net.Addr
+	fraction float64
+	diff     bool
+	queue    chan message.Message
+
+	mux sync.Mutex
+	//pending contains the mirrored queries for which not both answers have arrived yet.
+	pending  map[token.Token]*mirroredQuery
+	counters map[string]int
+}
+
+//mirroredQuery stores the answers to a mirrored query until both of them have arrived.
+type mirroredQuery struct {
+	query      message.Message
+	expiration time.Time
+	primary    *message.Message
+	shadow     *message.Message
+}
+
+//newMirror returns a mirror sending the given fraction of queries to the shadow server at address
+//(host:port).
+func newMirror(address string, fraction float64, diff bool) (*mirror, error) {
+	if fraction < 0 || fraction > 1 {
+		return nil, fmt.Errorf("MirrorFraction must be between 0 and 1, got %v", fraction)
+	}
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MirrorAddress %s: %v", address, err)
+	}
+	m := &mirror{
+		addr:     addr,
+		fraction: fraction,
+		diff:     diff,
+		queue:    make(chan message.Message, mirrorQueueSize),
+		pending:  make(map[token.Token]*mirroredQuery),
+		counters: map[string]int{MirrorSent: 0, MirrorDropped: 0, MirrorFailed: 0},
+	}
+	if diff {
+		m.counters[MirrorIdentical] = 0
+		m.counters[MirrorDifferent] = 0
+	}
+	return m, nil
+}
+
+//offer samples msg received by this server and schedules it to be sent to the shadow server. Only
+//messages containing a query are mirrored. Capabilities and signatures are dropped.
+func (m *mirror) offer(msg *message.Message) {
+	if !containsQuery(msg) || rand.Float64() >= m.fraction {
+		return
+	}
+	mirrored := message.Message{Token: msg.Token, Content: msg.Content}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.diff {
+		if _, ok := m.pending[msg.Token]; ok {
+			//The token is already used by another mirrored query, the answers would be mixed up.
+			m.counters[MirrorDropped]++
+			return
+		}
+		m.pending[msg.Token] = &mirroredQuery{query: mirrored, expiration: queryExpiration(msg)}
+	}
+	select {
+	case m.queue <- mirrored:
+	default:
+		m.counters[MirrorDropped]++
+		delete(m.pending, msg.Token)
+	}
+}
+
+//answered is called with each answer sent by this server. It is compared with the shadow's answer
+//if the answered query has been mirrored.
+func (m *mirror) answered(msg *message.Message) {
+	if !m.diff || containsQuery(msg) {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if q, ok := m.pending[probe.AnswerToken(*msg)]; ok && q.primary == nil {
+		q.primary = msg
+		m.compare(probe.AnswerToken(*msg), q)
+	}
+}
+
+//shadowAnswered is called with each message received from the shadow server.
+func (m *mirror) shadowAnswered(msg *message.Message) {
+	if !m.diff {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if q, ok := m.pending[probe.AnswerToken(*msg)]; ok && q.shadow == nil {
+		q.shadow = msg
+		m.compare(probe.AnswerToken(*msg), q)
+	}
+}
+
+//compare logs the difference between the answers to q once both of them have arrived. It must be
+//called with m.mux held.
+func (m *mirror) compare(tok token.Token, q *mirroredQuery) {
+	if q.primary == nil || q.shadow == nil {
+		return
+	}
+	delete(m.pending, tok)
+	missing, added := probe.DiffLines(probe.AnswerLines(*q.primary), probe.AnswerLines(*q.shadow))
+	if len(missing) == 0 && len(added) == 0 {
+		m.counters[MirrorIdentical]++
+		return
+	}
+	m.counters[MirrorDifferent]++
+	log.Warn("Shadow server answered differently", "shadow", m.addr, "query", q.query.Content,
+		"missing", strings.Join(missing, "; "), "added", strings.Join(added, "; "))
+}
+
+//reap removes all mirrored queries which expired before both answers arrived. A query the shadow
+//did not answer counts as failed.
+func (m *mirror) reap() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	now := clock.Now()
+	for tok, q := range m.pending {
+		if now.After(q.expiration) {
+			if q.shadow == nil {
+				m.counters[MirrorFailed]++
+			}
+			delete(m.pending, tok)
+		}
+	}
+}
+
+//statistics returns a copy of the mirror's counters.
+func (m *mirror) statistics() map[string]int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	counters := make(map[string]int, len(m.counters))
+	for k, v := range m.counters {
+		counters[k] = v
+	}
+	return counters
+}
+
+//run sends the queued queries to the shadow server until shutdown is closed. The connection to the
+//shadow is established when needed and reopened after a failure.
+func (m *mirror) run(shutdown <-chan bool) {
+	var conn net.Conn
+	var writer cbor.Writer
+	closeConn := func() {
+		if conn != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+	defer closeConn()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+			m.reap()
+		case msg := <-m.queue:
+			if conn == nil {
+				c, err := connection.CreateConnection(m.addr)
+				if err != nil {
+					log.Warn("Could not connect to shadow server", "shadow", m.addr, "error", err)
+					m.failed(msg.Token)
+					continue
+				}
+				conn, writer = c, cbor.NewWriter(c)
+				go m.receive(c)
+			}
+			if err := writer.Marshal(&msg); err != nil {
+				log.Warn("Could not mirror query", "shadow", m.addr, "error", err)
+				m.failed(msg.Token)
+				closeConn()
+				continue
+			}
+			m.mux.Lock()
+			m.counters[MirrorSent]++
+			m.mux.Unlock()
+		}
+	}
+}
+
+//receive passes all messages received over conn from the shadow server to shadowAnswered until
+//the connection is closed.
+func (m *mirror) receive(conn net.Conn) {
+	reader := cbor.NewReader(conn)
+	for {
+		var msg message.Message
+		if err := reader.Unmarshal(&msg); err != nil {
+			log.Debug("Connection to shadow server closed", "shadow", m.addr, "error", err)
+			return
+		}
+		m.shadowAnswered(&msg)
+	}
+}
+
+//failed counts the mirrored query with token tok as failed.
+func (m *mirror) failed(tok token.Token) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.counters[MirrorFailed]++
+	delete(m.pending, tok)
+}
+
+//containsQuery returns true if msg contains a query.
+func containsQuery(msg *message.Message) bool {
+	for _, sec := range msg.Content {
+		if _, ok := sec.(*query.Name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//queryExpiration returns the latest expiration of the queries contained in msg.
+func queryExpiration(msg *message.Message) time.Time {
+	var expiration int64
+	for _, sec := range msg.Content {
+		if q, ok := sec.(*query.Name); ok && q.Expiration > expiration {
+			expiration = q.Expiration
+		}
+	}
+	return time.Unix(expiration, 0)
+}
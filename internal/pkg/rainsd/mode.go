@@ -0,0 +1,72 @@
+package rainsd
+
+import (
+	"fmt"
+
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+)
+
+//Operating modes of a server, see the Mode entry of its config.
+const (
+	//ModeAuthoritative servers only answer queries for the zones over which they have authority
+	//and never forward queries of clients. They only cache the sections of these zones and the
+	//answers to their own delegation queries.
+	ModeAuthoritative = "authoritative"
+	//ModeForwarder servers send all queries which they cannot answer from their caches to the
+	//configured upstream resolvers.
+	ModeForwarder = "forwarder"
+	//ModeRecursive servers resolve all queries which they cannot answer from their caches
+	//recursively starting at the root servers.
+	ModeRecursive = "recursive"
+)
+
+//operatingMode returns the mode of a server with config. If the config does not set a mode, it is
+//derived from it: servers with authority over a zone are authoritative, servers with forwarders
+//are forwarders and all other servers are recursive. An error is returned if the config lacks an
+//entry the mode requires.
+func operatingMode(config rainsdConfig) (string, error) {
+	switch config.Mode {
+	case "":
+		if len(config.ZoneAuthority) > 0 {
+			return ModeAuthoritative, nil
+		}
+		if len(config.Forwarders) > 0 {
+			return ModeForwarder, nil
+		}
+		return ModeRecursive, nil
+	case ModeAuthoritative:
+		if len(config.ZoneAuthority) == 0 {
+			return "", fmt.Errorf("mode %s requires a ZoneAuthority", config.Mode)
+		}
+	case ModeForwarder:
+		if len(config.Forwarders) == 0 {
+			return "", fmt.Errorf("mode %s requires Forwarders", config.Mode)
+		}
+	case ModeRecursive:
+		if len(config.Forwarders) > 0 {
+			return "", fmt.Errorf("mode %s does not use Forwarders", config.Mode)
+		}
+	default:
+		return "", fmt.Errorf("unknown Mode %s, expected %s, %s or %s", config.Mode,
+			ModeAuthoritative, ModeForwarder, ModeRecursive)
+	}
+	return config.Mode, nil
+}
+
+//newForwardingResolver returns a resolver which forwards queries to the Forwarders (host:port) of
+//config, the fastest first, and sends the answers to the server at config.ServerAddress. Forwarders
+//which do not answer are retried as configured, see configureUpstream.
+func newForwardingResolver(config rainsdConfig) (*libresolve.Resolver, error) {
+	forwarders, err := resolveUpstream(config.Forwarders, "forwarder")
+	if err != nil {
+		return nil, err
+	}
+	resolver := libresolve.New(nil, forwarders, libresolve.Forward, config.ServerAddress.Addr,
+		config.MaxConnections)
+	if config.ServerAddress.Type == connection.Mem {
+		resolver.Dialer = connection.MemDialer{}
+	}
+	configureUpstream(resolver, config)
+	return resolver, nil
+}
@@ -15,8 +15,16 @@ import (
 func (s *Server) notify(msgSender util.MsgSectionSender) {
 	notifLog := log.New("notificationMsgSection", msgSender.Sections[0])
 	sec := msgSender.Sections[0].(*section.Notification)
+	s.activity.notification(false, msgSender.Sender, sec)
 	switch sec.Type {
 	case section.NTHeartbeat:
+	case section.NTStaleAnswer:
+		//The expired assertions of the answer are dropped when they are verified. The queries stay
+		//pending such that they can still be answered from the own caches.
+		notifLog.Info("Other server answered with expired assertions")
+	case section.NTTokenTrace:
+		//The trace reports of upstream servers are recorded when the resolver receives them.
+		notifLog.Debug("Other server reported the path of a traced query")
 	case section.NTCapHashNotKnown:
 		if len(sec.Data) == 0 {
 			caps, _ := s.caches.ConnCache.GetCapabilityList(s.config.ServerAddress.Addr)
@@ -43,15 +51,22 @@ func (s *Server) notify(msgSender util.MsgSectionSender) {
 	case section.NTBadMessage:
 		notifLog.Error("Sent msg was malformed")
 		dropPendingSectionsAndQueries(msgSender.Token, sec, true, s)
+	case section.NTAccessDenied:
+		notifLog.Error("Other server denied access")
+		dropPendingSectionsAndQueries(msgSender.Token, sec, false, s)
 	case section.NTRcvInconsistentMsg:
 		notifLog.Error("Sent msg was inconsistent")
 		dropPendingSectionsAndQueries(msgSender.Token, sec, true, s)
 	case section.NTMsgTooLarge:
 		notifLog.Error("Sent msg was too large")
 		//TODO CFE resend message in smaller chunks
+	case section.NTRateLimited:
+		notifLog.Warn("Other server limited the rate of responses")
+		dropPendingSectionsAndQueries(msgSender.Token, sec, false, s)
 	case section.NTNoAssertionsExist:
-		notifLog.Info("Bad request, only clients receive this notification type")
-		sendNotificationMsg(msgSender.Token, msgSender.Sender, section.NTBadMessage, "", s)
+		//Denials with a proof are answered by the proof, see deliver.
+		notifLog.Info("No assertions exist")
+		dropPendingSectionsAndQueries(msgSender.Token, sec, false, s)
 	case section.NTUnspecServerErr:
 		notifLog.Error("Unspecified error of other server")
 		dropPendingSectionsAndQueries(msgSender.Token, sec, false, s)
@@ -73,7 +88,9 @@ func capabilityIsHash(capabilities string) bool {
 }
 
 //dropPendingSectionsAndQueries removes all entries from the pending caches matching token and
-//forwards the received notification or unspecServerErr depending on serverError flag
+//forwards the received notification or unspecServerErr depending on serverError flag. Unless the
+//notification denies the existence of an answer, queries which can be answered with stale
+//assertions are answered instead.
 func dropPendingSectionsAndQueries(token token.Token, notification *section.Notification,
 	serverError bool, s *Server) {
 	if ss, ok := s.caches.PendingKeys.GetAndRemove(token); ok {
@@ -83,8 +100,11 @@ func dropPendingSectionsAndQueries(token token.Token, notification *section.Noti
 			sendNotificationMsg(ss.Token, ss.Sender, notification.Type, notification.Data, s)
 		}
 	}
-	sectionSenders := s.caches.PendingQueries.GetAndRemove(token)
+	sectionSenders := s.takePending(token)
 	for _, ss := range sectionSenders {
+		if notification.Type != section.NTNoAssertionsExist && s.answerStale(ss) {
+			continue
+		}
 		if serverError {
 			sendNotificationMsg(ss.Token, ss.Sender, section.NTUnspecServerErr, "", s)
 		} else {
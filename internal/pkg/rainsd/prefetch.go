@@ -0,0 +1,101 @@
+package rainsd
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//maxPrefetchNames is the number of queried names whose cache hits are counted. Hits for further
+//names are not counted until the answers of counted names expired.
+const maxPrefetchNames = 10000
+
+//prefetcher counts the cache hits of the answers to each queried context, name and types and
+//decides when a popular answer is refreshed before it expires, such that clients never wait for
+//the answer to be looked up again. It is safe for concurrent use.
+type prefetcher struct {
+	mux sync.Mutex
+	//hits is the number of cache hits after which an answer is refreshed.
+	hits int
+	//window is the number of seconds before its expiration in which an answer is refreshed.
+	window  int64
+	answers map[string]*prefetchAnswer
+	//prefetched is the number of answers refreshed so far.
+	prefetched int
+}
+
+//prefetchAnswer counts the cache hits of an answer.
+type prefetchAnswer struct {
+	hits int
+	//validUntil is the time at which the newest assertion of the answer expires. Hits are counted
+	//anew when it changes, i.e. when the answer has been refreshed.
+	validUntil int64
+	refreshed  bool
+}
+
+//newPrefetcher returns a prefetcher refreshing answers which had at least hits cache hits within
+//window seconds of their expiration. It returns nil if window is not positive.
+func newPrefetcher(hits int, window int64) *prefetcher {
+	if window <= 0 {
+		return nil
+	}
+	return &prefetcher{hits: hits, window: window, answers: make(map[string]*prefetchAnswer)}
+}
+
+//hit counts a cache hit of the answer sections to q. It returns true if the answer must be
+//refreshed now, which happens at most once per answer. Answers without assertions, e.g. denials of
+//existence, are not counted.
+func (p *prefetcher) hit(q *query.Name, sections []section.Section) bool {
+	validUntil := int64(0)
+	for _, sec := range sections {
+		if a, ok := sec.(*section.Assertion); ok && a.ValidUntil() > validUntil {
+			validUntil = a.ValidUntil()
+		}
+	}
+	if validUntil == 0 {
+		return false
+	}
+	key := fmt.Sprintf("%s %s %v", q.Context, q.Name, q.Types)
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	answer, ok := p.answers[key]
+	if !ok {
+		if len(p.answers) >= maxPrefetchNames {
+			return false
+		}
+		answer = &prefetchAnswer{}
+		p.answers[key] = answer
+	}
+	if answer.validUntil != validUntil {
+		*answer = prefetchAnswer{validUntil: validUntil}
+	}
+	answer.hits++
+	if answer.refreshed || answer.hits < p.hits || validUntil-clock.Now().Unix() > p.window {
+		return false
+	}
+	answer.refreshed = true
+	p.prefetched++
+	return true
+}
+
+//removeExpired forgets the hits of all answers which expired.
+func (p *prefetcher) removeExpired() {
+	now := clock.Now().Unix()
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for key, answer := range p.answers {
+		if answer.validUntil < now {
+			delete(p.answers, key)
+		}
+	}
+}
+
+//statistics returns the number of answers whose hits are counted and of refreshed answers.
+func (p *prefetcher) statistics() map[string]int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return map[string]int{"tracked": len(p.answers), "prefetched": p.prefetched}
+}
@@ -4,9 +4,9 @@ import (
 	"fmt"
 	"net"
 	"strings"
-	"time"
 
 	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
@@ -17,21 +17,35 @@ import (
 
 //processQuery processes msgSender containing a query section
 func (s *Server) processQuery(msgSender util.MsgSectionSender) {
+	sections, ok := s.intercept(BeforeCacheLookup, msgSender.Token, msgSender.Sender,
+		msgSender.Sections)
+	if !ok {
+		return
+	}
+	msgSender.Sections = sections
+	if isTraced(msgSender.Sections) {
+		s.tokenTracer.start(msgSender.Token)
+		s.tokenTracer.recordEvent(msgSender.Token, traceReceived, msgSender.Sender,
+			queriesDetail(msgSender.Sections))
+	}
 	queries := []*query.Name{}
 	for _, sec := range msgSender.Sections {
 		if q, ok := sec.(*query.Name); ok {
+			if _, zone, err := toSubjectZone(q.Name); err == nil {
+				s.activity.query(zone)
+			}
 			queries = append(queries, q)
 		} else {
 			log.Error("Not supported query message section. This case must be prevented beforehand")
 			return
 		}
 	}
-	if len(s.config.ZoneAuthority) == 0 {
-		//caching resolver
-		answerQueriesCachingResolver(msgSender, s)
-	} else {
+	if s.config.Mode == ModeAuthoritative {
 		//naming server
 		answerQueriesAuthoritative(queries, msgSender.Sender, msgSender.Token, s)
+	} else {
+		//caching resolver
+		answerQueriesCachingResolver(msgSender, s)
 	}
 }
 
@@ -42,14 +56,20 @@ func answerQueriesCachingResolver(ss util.MsgSectionSender, s *Server) {
 	sections := []section.Section{}
 	for _, q := range ss.Sections {
 		q := q.(*query.Name)
-		if secs := cacheLookup(q, ss.Sender, ss.Token, s); secs != nil {
+		if secs, complete := cacheLookup(q, ss.Sender, ss.Token, s); complete {
 			sections = append(sections, secs...)
+			if s.prefetch != nil && s.prefetch.hit(q, secs) {
+				log.Info("Prefetching popular answer before it expires", "query", q)
+				s.refresh([]section.Section{q})
+			}
 		} else {
 			queries = append(queries, q)
 		}
 	}
 	if len(queries) == 0 {
-		sendSections(sections, ss.Token, ss.Sender, s)
+		s.tokenTracer.recordEvent(ss.Token, traceCached, nil, "all queries answered from the cache")
+		sections = s.shapeAnswer(sections, ss.Sections, ss.Token)
+		sendAnswer(addDenial(sections, ss.Sections, ss.Token), ss.Token, ss.Sender, s)
 		return
 	}
 
@@ -58,7 +78,7 @@ func answerQueriesCachingResolver(ss util.MsgSectionSender, s *Server) {
 	if !ss.Sections[0].(*query.Name).ContainsOption(query.QOTokenTracing) {
 		tok = token.New()
 	}
-	validUntil := time.Now().Add(s.config.QueryValidity).Unix() //Upper bound for forwarded query expiration time
+	validUntil := clock.Now().Add(s.config.QueryValidity).Unix() //Upper bound for forwarded query expiration time
 	for _, q := range queries {
 		if q.Expiration < validUntil {
 			validUntil = q.Expiration
@@ -70,10 +90,14 @@ func answerQueriesCachingResolver(ss util.MsgSectionSender, s *Server) {
 		qs := []section.Section{}
 		for _, q := range queries {
 			q.Expiration = validUntil
-			qs = append(qs, q)
+			qs = append(qs, withoutLastHopOptions(q))
 		}
 		s.sendToRecursiveResolver(message.Message{Token: tok, Content: qs})
+		s.expireForwarded(tok, validUntil)
+		return
 	}
+	s.tokenTracer.recordEvent(ss.Token, tracePending, nil,
+		"waiting for the answer to an identical forwarded query")
 	log.Info("Query has already been sent to recursive resolver", "queries", queries)
 }
 
@@ -88,6 +112,8 @@ func answerQueriesAuthoritative(qs []*query.Name, sender net.Addr, token token.T
 			if i == len(s.config.ZoneAuthority)-1 {
 				log.Info("Query is not about a name this zone has authority over", "name", q.Name,
 					"authZone", s.config.ZoneAuthority, "authContxt", s.config.ContextAuthority)
+				sendNotificationMsg(token, sender, section.NTNoAssertionAvail, fmt.Sprintf(
+					"%s is not in a zone over which the server has authority", q.Name), s)
 				return
 			}
 		}
@@ -96,8 +122,9 @@ func answerQueriesAuthoritative(qs []*query.Name, sender net.Addr, token token.T
 	queries := []*query.Name{}
 	sections := []section.Section{}
 	for _, q := range qs {
-		if secs := cacheLookup(q, sender, token, s); secs != nil {
+		if secs, _ := cacheLookup(q, sender, token, s); secs != nil {
 			sections = append(sections, secs...)
+			sections = append(sections, referralGlue(q, secs, s)...)
 		} else {
 			queries = append(queries, q)
 		}
@@ -107,52 +134,90 @@ func answerQueriesAuthoritative(qs []*query.Name, sender net.Addr, token token.T
 		//glueRecordNames assumes that the names of delegates do not contain a dot '.'.
 		names := glueRecordNames(queries, s.config.ZoneAuthority)
 		for name := range names {
-			glueRecords := glueRecordLookup(name.Zone, name.Context, s)
-			if len(glueRecords) < 4 {
+			glueRecords, ok := glueRecordLookup(name.Zone, name.Context, s)
+			if !ok {
 				log.Warn("Not enough matching glue records")
 				return
 			}
 			sections = append(sections, glueRecords...)
 		}
 	}
-	sendSections(sections, token, sender, s)
+	answered := []section.Section{}
+	for _, q := range qs {
+		answered = append(answered, q)
+	}
+	sections = s.shapeAnswer(sections, answered, token)
+	sendAnswer(addDenial(sections, answered, token), token, sender, s)
 	log.Info("Finished handling query by sending records from cache", "queries", qs,
 		"sections", sections)
 }
 
-//cacheLookup answers q with a cached entry if there is one. True is returned in case of a cache hit
-func cacheLookup(q *query.Name, sender net.Addr, token token.Token, s *Server) []section.Section {
+//cacheLookup answers q with cached entries if there are some. The types of q which are not answered
+//by a cached assertion are looked up in the negative assertion cache. True is returned if each
+//type of q is answered by an assertion or a section proving its nonexistence.
+func cacheLookup(q *query.Name, sender net.Addr, token token.Token, s *Server) (
+	[]section.Section, bool) {
 	assertions := assertionCacheLookup(q, s)
-	if len(assertions) > 0 {
-		return assertions
+	missing := missingTypes(q.Types, assertions)
+	if len(missing) == 0 {
+		return assertions, true
 	}
 
 	log.Debug("No direct entry found in assertion cache.", "name", q.Name,
-		"context", q.Context, "type", q.Types)
+		"context", q.Context, "type", missing)
 	//negative answer lookup (note that it can occur a positive answer if assertion removed from cache)
-	sections := negativeCacheLookup(q, sender, token, s)
+	sections := negativeCacheLookup(&query.Name{Context: q.Context, Name: q.Name, Types: missing},
+		sender, token, s)
+	sections = append(assertions, sections...)
 	if len(sections) > 0 {
-		return sections
+		return sections, len(missingTypes(missing, sections)) == 0
 	}
-	return nil
+	return nil, false
 }
 
-func assertionCacheLookup(q *query.Name, s *Server) (assertions []section.Section) {
-	assertionSet := make(map[string]bool)
-	asKey := func(a *section.Assertion) string {
-		return fmt.Sprintf("%s_%s_%s", a.SubjectName, a.SubjectZone, a.Context)
+//missingTypes returns the types which are neither answered by an assertion of sections nor proven
+//not to exist by a shard, pshard or zone of sections. Such a section is only contained in an answer
+//if it proves the nonexistence of all types not answered by an assertion.
+func missingTypes(types []object.Type, sections []section.Section) (missing []object.Type) {
+	answered := make(map[object.Type]bool)
+	for _, sec := range sections {
+		a, ok := sec.(*section.Assertion)
+		if !ok {
+			return nil
+		}
+		for _, o := range a.Content {
+			answered[o.Type] = true
+		}
+	}
+	for _, t := range types {
+		if !answered[t] {
+			missing = append(missing, t)
+		}
 	}
+	return
+}
 
+//assertionCacheLookup returns the valid cached assertions about the name of q containing an object
+//of a queried type.
+func assertionCacheLookup(q *query.Name, s *Server) []section.Section {
+	return cachedAssertions(q, clock.Now().Unix(), s)
+}
+
+//cachedAssertions returns the cached assertions about the name of q containing an object of a
+//queried type which are valid after validAfter. The assertion cache indexes each object type of an
+//assertion separately, such that an assertion with several queried types is only returned once.
+func cachedAssertions(q *query.Name, validAfter int64, s *Server) (assertions []section.Section) {
+	assertionSet := make(map[string]bool)
 	for _, t := range q.Types {
 		if asserts, ok := s.caches.AssertionsCache.Get(q.Name, q.Context, t, true); ok {
 			for _, a := range asserts {
-				if _, ok := assertionSet[asKey(a)]; ok {
+				if assertionSet[a.Hash()] {
 					continue
 				}
-				if a.ValidUntil() > time.Now().Unix() {
+				if a.ValidUntil() > validAfter {
 					log.Debug(fmt.Sprintf("appending valid assertion: %v", a))
 					assertions = append(assertions, a)
-					assertionSet[asKey(a)] = true
+					assertionSet[a.Hash()] = true
 				}
 			}
 		}
@@ -160,31 +225,128 @@ func assertionCacheLookup(q *query.Name, s *Server) (assertions []section.Sectio
 	return
 }
 
+//negativeCacheLookup answers q from the cached shards and zones of the splits of its name into a
+//zone and a subject name, see zoneSplits, which are looked up in order until one answers q. The
+//zone one label above the name may also deny it. The other zones may not, as their shards and zones
+//do not list the names of a child zone whose delegation is not cached, such that only the
+//assertions they contain are accepted.
 func negativeCacheLookup(q *query.Name, sender net.Addr, token token.Token, s *Server) []section.Section {
-	subject, zone, err := toSubjectZone(q.Name)
+	_, zone, err := toSubjectZone(q.Name)
 	if err != nil {
 		sendNotificationMsg(token, sender, section.NTRcvInconsistentMsg,
 			"query name must end with root zone dot '.'", s)
 		log.Warn("failed to concert query name to subject and zone", "error", err)
 		return nil
 	}
-	answer, _ := s.caches.NegAssertionCache.Get(zone, q.Context, section.StringInterval{Name: subject})
-	return filterAnswer(answer)
+	for _, split := range zoneSplits(q.Name, q.Context, s) {
+		cached, _ := s.caches.NegAssertionCache.Get(split.zone, q.Context,
+			section.StringInterval{Name: split.name})
+		answer := filterAnswer(q, split.name, cached)
+		if split.zone == zone && len(answer) > 0 || containsAssertion(answer) {
+			log.Debug("Split into zone and name", "subject", split.name, "zone", split.zone)
+			return answer
+		}
+	}
+	return nil
+}
+
+//containsAssertion returns true if sections contains an assertion.
+func containsAssertion(sections []section.Section) bool {
+	for _, sec := range sections {
+		if _, ok := sec.(*section.Assertion); ok {
+			return true
+		}
+	}
+	return false
 }
 
-func filterAnswer(sections []section.WithSigForward) (answer []section.Section) {
-	//TODO CFE For each type check if one of the zone or shards contain the queried
-	//assertion. If there is at least one assertion answer with it. If no assertion is
-	//contained in a zone or shard for any of the queried connection, answer with the shortest
-	//element. shortest according to what? size in bytes? how to efficiently determine that.
-	//e.g. using gob encoding. alternatively we could also count the number of contained
-	//elements.
-	for _, s := range sections {
-		answer = append(answer, s)
+//filterAnswer answers q with the assertions about subject contained in the shards and zones of
+//sections, whose ranges include subject. Only contained assertions which are signed themselves and
+//still valid are returned, together with the context and zone of the enclosing section. If the
+//assertions do not answer all types of q, the section with the fewest assertions proving that the
+//remaining types do not exist is added. If no contained assertion answers q, only this section is
+//returned.
+func filterAnswer(q *query.Name, subject string, sections []section.WithSigForward) (
+	answer []section.Section) {
+	missing := make(map[object.Type]bool)
+	for _, t := range q.Types {
+		missing[t] = true
+	}
+	added := make(map[string]bool)
+	for _, sec := range sections {
+		var content []*section.Assertion
+		switch sec := sec.(type) {
+		case *section.Shard:
+			content = sec.Content
+		case *section.Zone:
+			content = sec.Content
+		}
+		for _, a := range content {
+			if a.SubjectName != subject || len(a.AllSigs()) == 0 ||
+				a.ValidUntil() <= clock.Now().Unix() || !answersQuery(a, q) {
+				continue
+			}
+			a = a.Copy(sec.GetContext(), sec.GetSubjectZone())
+			if added[a.Hash()] {
+				continue
+			}
+			added[a.Hash()] = true
+			answer = append(answer, a)
+			for _, o := range a.Content {
+				delete(missing, o.Type)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		types := []object.Type{}
+		for _, t := range q.Types {
+			if missing[t] {
+				types = append(types, t)
+				delete(missing, t)
+			}
+		}
+		if proof := nonexistenceProof(q, subject, types, sections); proof != nil {
+			answer = append(answer, proof)
+		}
 	}
 	return
 }
 
+//referralGlue returns the glue records of the zone q.Name if q asks for the redirection of a zone
+//delegated by s, which answer contains, such that a resolver minimizing its queries can contact
+//the zone's servers. The glue records contained in answer are omitted.
+func referralGlue(q *query.Name, answer []section.Section, s *Server) []section.Section {
+	redirected := false
+	contained := make(map[string]bool)
+	for _, sec := range answer {
+		if a, ok := sec.(*section.Assertion); ok {
+			contained[a.Hash()] = true
+			for _, o := range a.Content {
+				redirected = redirected || o.Type == object.OTRedirection && a.FQDN() == q.Name
+			}
+		}
+	}
+	if !redirected || !asked(object.OTRedirection, q) {
+		return nil
+	}
+	for i, zone := range s.config.ZoneAuthority {
+		if zone == q.Name && s.config.ContextAuthority[i] == q.Context {
+			return nil
+		}
+	}
+	glueRecords, ok := glueRecordLookup(q.Name, q.Context, s)
+	if !ok {
+		log.Warn("Not enough matching glue records for redirection", "zone", q.Name)
+	}
+	var glue []section.Section
+	for _, sec := range glueRecords {
+		if !contained[sec.(*section.Assertion).Hash()] {
+			glue = append(glue, sec)
+		}
+	}
+	return glue
+}
+
 //glueRecordNames returns the unique names for which glue records should be looked up based on qs.
 //It assumes that the names of all delegates do not contain a dot '.'.
 func glueRecordNames(qs []*query.Name, zoneAuths []string) map[zoneContext]bool {
@@ -209,17 +371,64 @@ func glueRecordNames(qs []*query.Name, zoneAuths []string) map[zoneContext]bool
 	return result
 }
 
-func glueRecordLookup(name, context string, s *Server) (assertions []section.Section) {
-	types := []object.Type{object.OTDelegation, object.OTRedirection, object.OTServiceInfo, object.OTIP4Addr}
-	names := []string{name, name, "ns." + name, "ns1." + name}
+//glueRecordLookup returns the cached delegation, redirection and service information of the zone
+//name together with the addresses of its servers. The delegation and redirection may be objects of
+//the same assertion, which is returned only once. It returns false if any of them or the addresses
+//of all servers are missing.
+func glueRecordLookup(name, context string, s *Server) (assertions []section.Section, ok bool) {
+	types := []object.Type{object.OTDelegation, object.OTRedirection, object.OTServiceInfo}
+	names := []string{name, name, "ns." + name}
+	added := make(map[string]bool)
+	add := func(a *section.Assertion) {
+		if !added[a.Hash()] {
+			added[a.Hash()] = true
+			assertions = append(assertions, a)
+		}
+	}
+	ok = true
+	var servers []string
 	for i, t := range types {
-		if asserts, ok := s.caches.AssertionsCache.Get(names[i], context, t, false); !ok {
+		if asserts, cached := s.caches.AssertionsCache.Get(names[i], context, t, false); !cached {
 			log.Error("No glue record in cache!", "Name", names[i], "Type", t)
+			ok = false
 		} else {
-			assertions = append(assertions, asserts[0]) //FIXME CFE, handle if there are more assertions in response
+			add(asserts[0]) //FIXME CFE, handle if there are more assertions in response
+			if t == object.OTServiceInfo {
+				servers = serverNames(asserts[0])
+			}
 		}
 	}
-	return
+	//The addresses of all servers are added such that a resolver can fall back to another server
+	//if one of them is not reachable.
+	addressFound := false
+	for _, server := range servers {
+		found := false
+		for _, t := range []object.Type{object.OTIP4Addr, object.OTIP6Addr} {
+			if asserts, cached := s.caches.AssertionsCache.Get(server, context, t, false); cached {
+				add(asserts[0])
+				found = true
+			}
+		}
+		if !found {
+			log.Error("No glue record in cache!", "Name", server, "Type", object.OTIP4Addr)
+		}
+		addressFound = addressFound || found
+	}
+	return assertions, ok && addressFound
+}
+
+//serverNames returns the distinct names of the servers contained in the service information of a.
+func serverNames(a *section.Assertion) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, o := range a.Content {
+		if srv, ok := o.Value.(object.ServiceInfo); ok && o.Type == object.OTServiceInfo &&
+			!seen[srv.Name] {
+			seen[srv.Name] = true
+			names = append(names, srv.Name)
+		}
+	}
+	return names
 }
 
 // toSubjectZone splits a name into a subject and zone.
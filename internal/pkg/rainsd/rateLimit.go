@@ -0,0 +1,222 @@
+package rainsd
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//maxRateLimitAccounts is the number of accounts of client prefixes and names whose responses are
+//counted. Once it is reached, the least recently debited account is forgotten for each new one.
+const maxRateLimitAccounts = 100000
+
+//Default parameters of the response rate limiter if they are not set in the config.
+const (
+	defaultRateLimitWindow     = 15 * time.Second
+	defaultRateLimitIPv4Prefix = 24
+	defaultRateLimitIPv6Prefix = 56
+)
+
+//Names of the counters of the response rate limiter reported by the stats command.
+const (
+	RateLimitAccounts = "accounts"
+	RateLimitDropped  = "dropped"
+	RateLimitSlipped  = "slipped"
+)
+
+//rateLimiter limits the rate of the answers a server sends to the clients of a network prefix for
+//the same names, such that the server cannot be abused to reflect large answers, e.g. shards and
+//zones, to a victim. Each account has a balance of responses which is credited with rate responses
+//per second up to rate and debited with each answer. Answers of an account without balance are
+//dropped, except for every slip-th one which is replaced by a short NTRateLimited notification,
+//such that legitimate clients of the prefix learn to retry later. The debt of an account is
+//limited to window seconds worth of responses. It is safe for concurrent use.
+type rateLimiter struct {
+	rate       float64
+	window     time.Duration
+	slip       int
+	ipv4, ipv6 net.IPMask
+
+	mux      sync.Mutex
+	accounts map[string]*rateAccount
+	//recent contains the keys of the accounts, the most recently debited first.
+	recent   *list.List
+	counters map[string]int
+}
+
+//rateAccount is the balance of responses of a client prefix for a set of names.
+type rateAccount struct {
+	//elem is the account's element in rateLimiter.recent.
+	elem    *list.Element
+	balance float64
+	updated time.Time
+	//limited is the number of answers of the account which have been limited in a row.
+	limited int
+}
+
+//newRateLimiter returns a rateLimiter with the parameters of config. It returns nil if
+//ResponsesPerSecond is not positive.
+func newRateLimiter(config rainsdConfig) (*rateLimiter, error) {
+	if config.ResponsesPerSecond <= 0 {
+		return nil, nil
+	}
+	r := &rateLimiter{
+		rate:     config.ResponsesPerSecond,
+		window:   config.RateLimitWindow,
+		slip:     config.RateLimitSlip,
+		accounts: make(map[string]*rateAccount),
+		recent:   list.New(),
+		counters: map[string]int{RateLimitDropped: 0, RateLimitSlipped: 0},
+	}
+	if r.window <= 0 {
+		r.window = defaultRateLimitWindow
+	}
+	if r.slip < 0 {
+		return nil, fmt.Errorf("RateLimitSlip must not be negative, got %d", r.slip)
+	}
+	ipv4, ipv6 := config.RateLimitIPv4Prefix, config.RateLimitIPv6Prefix
+	if ipv4 == 0 {
+		ipv4 = defaultRateLimitIPv4Prefix
+	}
+	if ipv6 == 0 {
+		ipv6 = defaultRateLimitIPv6Prefix
+	}
+	if ipv4 < 0 || ipv4 > 32 || ipv6 < 0 || ipv6 > 128 {
+		return nil, fmt.Errorf("invalid rate limit prefix lengths /%d and /%d", ipv4, ipv6)
+	}
+	r.ipv4, r.ipv6 = net.CIDRMask(ipv4, 32), net.CIDRMask(ipv6, 128)
+	return r, nil
+}
+
+//limit debits the account of the prefix of destination and the names of answer. It returns answer
+//unchanged if the account has a balance, a NTRateLimited notification with token tok if the
+//answer slips and false if it is dropped. Answers without assertions, shards, pshards or zones and
+//answers to destinations without an ip address, e.g. over in-memory connections, are not limited.
+func (r *rateLimiter) limit(tok token.Token, destination net.Addr,
+	answer []section.Section) ([]section.Section, bool) {
+	tcpAddr, ok := destination.(*net.TCPAddr)
+	names := answerNames(answer)
+	if !ok || names == "" {
+		return answer, true
+	}
+	prefix := r.prefix(tcpAddr.IP)
+	key := prefix + " " + names
+	now := clock.Now()
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	account, ok := r.accounts[key]
+	if ok {
+		r.recent.MoveToFront(account.elem)
+	} else {
+		if len(r.accounts) >= maxRateLimitAccounts {
+			r.remove(r.recent.Back())
+		}
+		account = &rateAccount{elem: r.recent.PushFront(key), balance: r.rate, updated: now}
+		r.accounts[key] = account
+	}
+	r.credit(account, now)
+	account.balance--
+	if min := -r.rate * r.window.Seconds(); account.balance < min {
+		account.balance = min
+	}
+	if account.balance >= 0 {
+		account.limited = 0
+		return answer, true
+	}
+	account.limited++
+	if r.slip == 0 || account.limited%r.slip != 0 {
+		r.counters[RateLimitDropped]++
+		log.Debug("Rate limit exceeded, dropping answer", "prefix", prefix, "names", names,
+			"token", tok)
+		return nil, false
+	}
+	r.counters[RateLimitSlipped]++
+	log.Debug("Rate limit exceeded, answering with a notification", "prefix", prefix, "names",
+		names, "token", tok)
+	return []section.Section{&section.Notification{
+		Type:  section.NTRateLimited,
+		Token: tok,
+		Data:  fmt.Sprintf("answers for %s to %s are rate limited, retry later", names, prefix),
+	}}, true
+}
+
+//credit adds the responses accrued since the account was last updated to its balance.
+func (r *rateLimiter) credit(account *rateAccount, now time.Time) {
+	if elapsed := now.Sub(account.updated); elapsed > 0 {
+		account.balance += elapsed.Seconds() * r.rate
+		if account.balance > r.rate {
+			account.balance = r.rate
+		}
+	}
+	account.updated = now
+}
+
+//prefix returns the network prefix of ip to which its answers are accounted in CIDR notation.
+func (r *rateLimiter) prefix(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		ones, _ := r.ipv4.Size()
+		return fmt.Sprintf("%s/%d", ip4.Mask(r.ipv4), ones)
+	}
+	ones, _ := r.ipv6.Size()
+	return fmt.Sprintf("%s/%d", ip.Mask(r.ipv6), ones)
+}
+
+//answerNames returns the sorted fully qualified names of the assertions of answer and the subject
+//zones of its shards, pshards and zones, separated by spaces. Denials of different names of a zone
+//are thus accounted together.
+func answerNames(answer []section.Section) string {
+	set := make(map[string]bool)
+	for _, sec := range answer {
+		switch sec := sec.(type) {
+		case *section.Assertion:
+			set[sec.FQDN()] = true
+		case *section.Shard, *section.Pshard, *section.Zone:
+			set[sec.(section.WithSigForward).GetSubjectZone()] = true
+		}
+	}
+	names := []string{}
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+//removeExpired forgets the accounts whose balance has been fully credited again.
+func (r *rateLimiter) removeExpired() {
+	now := clock.Now()
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, account := range r.accounts {
+		r.credit(account, now)
+		if account.balance >= r.rate {
+			r.remove(account.elem)
+		}
+	}
+}
+
+//remove forgets the account of elem. The caller must hold the lock.
+func (r *rateLimiter) remove(elem *list.Element) {
+	delete(r.accounts, r.recent.Remove(elem).(string))
+}
+
+//statistics returns the number of accounts and of dropped and slipped answers.
+func (r *rateLimiter) statistics() map[string]int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	stats := map[string]int{RateLimitAccounts: len(r.accounts)}
+	for name, count := range r.counters {
+		stats[name] = count
+	}
+	return stats
+}
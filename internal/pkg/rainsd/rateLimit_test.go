@@ -0,0 +1,129 @@
+package rainsd
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//Outcomes of rateLimiter.limit.
+const (
+	answered = "answered"
+	slipped  = "slipped"
+	dropped  = "dropped"
+)
+
+//rateLimitOutcome returns whether the answer about name to ip is answered, slipped or dropped by r.
+func rateLimitOutcome(r *rateLimiter, ip, name string) string {
+	answer := []section.Section{&section.Assertion{SubjectName: name, SubjectZone: "ethz.ch.",
+		Content: []object.Object{object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}}}}
+	limited, ok := r.limit(token.New(), &net.TCPAddr{IP: net.ParseIP(ip)}, answer)
+	switch {
+	case !ok:
+		return dropped
+	case reflect.DeepEqual(limited, answer):
+		return answered
+	case len(limited) == 1:
+		if n, isNotification := limited[0].(*section.Notification); isNotification &&
+			n.Type == section.NTRateLimited {
+			return slipped
+		}
+	}
+	return fmt.Sprintf("unexpected answer %v", limited)
+}
+
+func TestRateLimit(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	defer clock.Set(fake)()
+	r, err := newRateLimiter(rainsdConfig{ResponsesPerSecond: 2, RateLimitWindow: time.Second,
+		RateLimitSlip: 2})
+	if err != nil {
+		t.Fatalf("Was not able to create rate limiter: %v", err)
+	}
+	var tests = []struct {
+		advance time.Duration
+		ip      string
+		name    string
+		outcome string
+	}{
+		{0, "192.0.2.1", "www", answered},
+		{0, "192.0.2.1", "www", answered},
+		{0, "192.0.2.1", "www", dropped},
+		{0, "192.0.2.1", "www", slipped},
+		{0, "192.0.2.1", "www", dropped},
+		//clients of the same prefix share the balance
+		{0, "192.0.2.77", "www", slipped},
+		{0, "198.51.100.1", "www", answered},
+		{0, "192.0.2.1", "ftp", answered},
+		//the debt is limited to the window, so two seconds restore the full balance
+		{2 * time.Second, "192.0.2.1", "www", answered},
+		{0, "192.0.2.1", "www", answered},
+		{0, "192.0.2.1", "www", dropped},
+		{time.Second, "192.0.2.1", "www", answered},
+	}
+	for i, test := range tests {
+		fake.Advance(test.advance)
+		if outcome := rateLimitOutcome(r, test.ip, test.name); outcome != test.outcome {
+			t.Errorf("%d: wrong outcome. expected=%s actual=%s", i, test.outcome, outcome)
+		}
+	}
+	expected := map[string]int{RateLimitAccounts: 3, RateLimitDropped: 3, RateLimitSlipped: 2}
+	if stats := r.statistics(); !reflect.DeepEqual(stats, expected) {
+		t.Errorf("wrong statistics. expected=%v actual=%v", expected, stats)
+	}
+	//Answers without names and to destinations without an ip address are not limited.
+	notification := []section.Section{&section.Notification{Type: section.NTHeartbeat}}
+	if _, ok := r.limit(token.New(), &net.TCPAddr{IP: net.ParseIP("192.0.2.1")},
+		notification); !ok {
+		t.Errorf("notification was dropped")
+	}
+	answer := []section.Section{&section.Assertion{SubjectName: "www", SubjectZone: "ethz.ch."}}
+	if _, ok := r.limit(token.New(), &net.UnixAddr{Name: "rainsd.sock"}, answer); !ok {
+		t.Errorf("answer over a unix socket was dropped")
+	}
+	fake.Advance(time.Minute)
+	r.removeExpired()
+	if stats := r.statistics(); stats[RateLimitAccounts] != 0 {
+		t.Errorf("credited accounts were not removed. stats=%v", stats)
+	}
+}
+
+func TestRateLimitEviction(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	defer clock.Set(fake)()
+	r, err := newRateLimiter(rainsdConfig{ResponsesPerSecond: 1})
+	if err != nil {
+		t.Fatalf("Was not able to create rate limiter: %v", err)
+	}
+	rateLimitOutcome(r, "192.0.2.1", "old")
+	rateLimitOutcome(r, "192.0.2.1", "www")
+	if outcome := rateLimitOutcome(r, "192.0.2.1", "www"); outcome != dropped {
+		t.Fatalf("answer over the limit was not dropped. outcome=%s", outcome)
+	}
+	for i := 0; i < maxRateLimitAccounts-1; i++ {
+		rateLimitOutcome(r, "198.51.100.1", fmt.Sprintf("host%d", i))
+	}
+	//the account of old is evicted first as it was debited least recently
+	if _, ok := r.accounts["192.0.2.0/24 old.ethz.ch."]; ok {
+		t.Errorf("least recently debited account was not evicted")
+	}
+	if outcome := rateLimitOutcome(r, "192.0.2.1", "www"); outcome != dropped {
+		t.Errorf("account was evicted before older ones. outcome=%s", outcome)
+	}
+	if len(r.accounts) != maxRateLimitAccounts || r.recent.Len() != maxRateLimitAccounts {
+		t.Errorf("wrong number of accounts. expected=%d actual=%d/%d", maxRateLimitAccounts,
+			len(r.accounts), r.recent.Len())
+	}
+	//new accounts are limited once all accounts are in use
+	rateLimitOutcome(r, "203.0.113.1", "www")
+	if outcome := rateLimitOutcome(r, "203.0.113.1", "www"); outcome != dropped {
+		t.Errorf("answer of a new account was not limited. outcome=%s", outcome)
+	}
+}
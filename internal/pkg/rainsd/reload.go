@@ -0,0 +1,255 @@
+package rainsd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//reloadable contains the settings of the config which are replaced while the server is running
+//when the configuration is reloaded, see Reload. It is safe for concurrent use.
+type reloadable struct {
+	mux sync.RWMutex
+	//certPool stores received certificates
+	certPool *x509.CertPool
+	//tlsCert holds the tls certificate of this server
+	tlsCert          tls.Certificate
+	maxCacheValidity util.MaxCacheValidity
+	//capacities contains the maximum number of entries of the caches by name.
+	capacities map[string]int
+	//config contains the settings applied last. Reload compares it with the reloaded settings.
+	config rainsdConfig
+	//logLevel is the log level set last. It is empty while the level of the handler the server
+	//has been started with is used.
+	logLevel string
+}
+
+//cacheSizes lists the settings of the cache sizes together with the names of the capacities.
+var cacheSizes = []struct {
+	setting, capacity string
+	size              func(rainsdConfig) int
+}{
+	{"MaxConnections", "connections", func(c rainsdConfig) int { return c.MaxConnections }},
+	{"CapabilitiesCacheSize", "capabilities",
+		func(c rainsdConfig) int { return c.CapabilitiesCacheSize }},
+	{"ZoneKeyCacheSize", CacheZoneKeys, func(c rainsdConfig) int { return c.ZoneKeyCacheSize }},
+	{"PendingKeyCacheSize", "pendingKeys",
+		func(c rainsdConfig) int { return c.PendingKeyCacheSize }},
+	{"PendingQueryCacheSize", "pendingQueries",
+		func(c rainsdConfig) int { return c.PendingQueryCacheSize }},
+	{"AssertionCacheSize", CacheAssertions,
+		func(c rainsdConfig) int { return c.AssertionCacheSize }},
+	{"NegativeAssertionCacheSize", CacheNegAssertions,
+		func(c rainsdConfig) int { return c.NegativeAssertionCacheSize }},
+}
+
+//set replaces the settings with the ones of config and the server's TLS certificate with cert,
+//which is contained in pool.
+func (r *reloadable) set(config rainsdConfig, pool *x509.CertPool, cert tls.Certificate) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.certPool, r.tlsCert = pool, cert
+	r.maxCacheValidity = config.MaxCacheValidity
+	r.capacities = make(map[string]int, len(cacheSizes))
+	for _, c := range cacheSizes {
+		r.capacities[c.capacity] = c.size(config)
+	}
+	r.config = config
+}
+
+//certificate returns the TLS certificate the server presents to its peers. It is used as
+//GetCertificate function of the listener's TLS config such that new connections use the
+//certificate loaded last.
+func (s *Server) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.reloadable.mux.RLock()
+	defer s.reloadable.mux.RUnlock()
+	cert := s.reloadable.tlsCert
+	return &cert, nil
+}
+
+//peerCertPool returns the pool of certificates used for connections to other servers.
+func (s *Server) peerCertPool() *x509.CertPool {
+	s.reloadable.mux.RLock()
+	defer s.reloadable.mux.RUnlock()
+	return s.reloadable.certPool
+}
+
+//maxCacheValidity returns the maximum validity of sections in the caches.
+func (s *Server) maxCacheValidity() util.MaxCacheValidity {
+	s.reloadable.mux.RLock()
+	defer s.reloadable.mux.RUnlock()
+	return s.reloadable.maxCacheValidity
+}
+
+//capacities returns the maximum number of entries of the caches by name.
+func (s *Server) capacities() map[string]int {
+	s.reloadable.mux.RLock()
+	defer s.reloadable.mux.RUnlock()
+	capacities := make(map[string]int, len(s.reloadable.capacities))
+	for name, capacity := range s.reloadable.capacities {
+		capacities[name] = capacity
+	}
+	return capacities
+}
+
+//Reload loads the configuration file again and applies the settings which can be changed while
+//the server is running, i.e. the blacklist, the ACL, the log level, the sizes of the caches, the
+//maximum validity of cached sections, the TLS certificate and the root servers or forwarders of
+//the server's resolver. It returns the names of the settings which changed. No setting is applied
+//if any of them is invalid or the upstream servers cannot be replaced, see checkUpstream. The
+//caches are resized in place, such that only the least recently used entries are removed if a
+//cache shrinks. The operating mode cannot be changed.
+func (s *Server) Reload() ([]string, error) {
+	s.reloadMux.Lock()
+	defer s.reloadMux.Unlock()
+	config, err := loadConfig(s.configPath)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := operatingMode(config)
+	if err != nil {
+		return nil, err
+	}
+	if mode != s.config.Mode {
+		return nil, fmt.Errorf("mode cannot be changed from %s to %s without a restart",
+			s.config.Mode, mode)
+	}
+	roots, err := resolveUpstream(config.RootServers, "root server")
+	if err != nil {
+		return nil, err
+	}
+	forwarders, err := resolveUpstream(config.Forwarders, "forwarder")
+	if err != nil {
+		return nil, err
+	}
+	pool, cert, err := loadTLSCertificate(config.TLSCertificateFile, config.TLSPrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	blacklisted, err := parseBlacklist(config.Blacklist)
+	if err != nil {
+		return nil, err
+	}
+	query, push, publish, err := parseACL(config.ACL)
+	if err != nil {
+		return nil, err
+	}
+	var lvl log.Lvl
+	if config.LogLevel != "" {
+		if lvl, err = log.LvlFromString(config.LogLevel); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range cacheSizes {
+		if c.size(config) <= 0 {
+			return nil, fmt.Errorf("%s must be positive, got %d", c.setting, c.size(config))
+		}
+	}
+
+	s.reloadable.mux.RLock()
+	previous, tlsCert, logLevel := s.reloadable.config, s.reloadable.tlsCert,
+		s.reloadable.logLevel
+	s.reloadable.mux.RUnlock()
+	ownResolver := s.resolver != nil && !s.externalResolver
+	if err := checkUpstream(mode, config, previous, ownResolver); err != nil {
+		return nil, err
+	}
+
+	//All settings are valid, only the changed ones are applied from here on.
+	applied := []string{}
+	next := &blacklist{networks: blacklisted}
+	if !reflect.DeepEqual(next.Entries(), s.blacklist.Entries()) {
+		s.blacklist.set(blacklisted)
+		applied = append(applied, "Blacklist")
+	}
+	if q, p, pub := s.acl.networks(); !reflect.DeepEqual([][]*net.IPNet{query, push, publish},
+		[][]*net.IPNet{q, p, pub}) {
+		s.acl.set(query, push, publish)
+		applied = append(applied, "ACL")
+	}
+	if config.LogLevel != "" && lvl.String() != logLevel {
+		s.applyLogLevel(lvl)
+		applied = append(applied, "LogLevel")
+	}
+	for _, c := range cacheSizes {
+		if c.size(config) != c.size(previous) {
+			applied = append(applied, c.setting)
+		}
+	}
+	if config.MaxCacheValidity != previous.MaxCacheValidity {
+		applied = append(applied, "MaxCacheValidity")
+	}
+	if config.TLSCertificateFile != previous.TLSCertificateFile ||
+		!reflect.DeepEqual(cert.Certificate, tlsCert.Certificate) {
+		applied = append(applied, "TLSCertificateFile")
+	}
+	if config.TLSPrivateKeyFile != previous.TLSPrivateKeyFile ||
+		!reflect.DeepEqual(cert.PrivateKey, tlsCert.PrivateKey) {
+		applied = append(applied, "TLSPrivateKeyFile")
+	}
+	s.caches.resize(config)
+	s.reloadable.set(config, pool, cert)
+	if !equalStrings(config.Forwarders, previous.Forwarders) {
+		s.resolver.SetUpstream(nil, forwarders)
+		applied = append(applied, "Forwarders")
+	} else if !equalStrings(config.RootServers, previous.RootServers) {
+		s.resolver.SetUpstream(roots, nil)
+		applied = append(applied, "RootServers")
+	}
+	log.Info("Reloaded configuration", "path", s.configPath, "applied", applied)
+	return applied, nil
+}
+
+//checkUpstream returns an error if the root servers or forwarders of config differ from the ones of
+//previous but cannot be applied to the server's resolver. Only the upstream servers the server in
+//mode uses can be replaced and only if the server has created its resolver from previous,
+//indicated by ownResolver. A resolver set with SetResolver keeps its own upstream servers.
+func checkUpstream(mode string, config, previous rainsdConfig, ownResolver bool) error {
+	rootsChanged := !equalStrings(config.RootServers, previous.RootServers)
+	forwardersChanged := !equalStrings(config.Forwarders, previous.Forwarders)
+	switch {
+	case !rootsChanged && !forwardersChanged:
+		return nil
+	case mode == ModeForwarder && rootsChanged:
+		return fmt.Errorf("RootServers are not used in mode %s", mode)
+	case mode != ModeForwarder && forwardersChanged:
+		return fmt.Errorf("Forwarders are not used in mode %s", mode)
+	case !ownResolver:
+		return fmt.Errorf("upstream servers cannot be changed without a restart as the server " +
+			"has not created its resolver from them")
+	}
+	return nil
+}
+
+//equalStrings returns true if a and b contain the same strings in the same order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//resolveUpstream returns the addresses of the upstream servers given as host:port. kind describes
+//the servers in the returned error.
+func resolveUpstream(servers []string, kind string) ([]net.Addr, error) {
+	var addrs []net.Addr
+	for _, server := range servers {
+		addr, err := net.ResolveTCPAddr("tcp", server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %s: %v", kind, server, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
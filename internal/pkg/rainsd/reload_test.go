@@ -0,0 +1,32 @@
+package rainsd
+
+import "testing"
+
+func TestCheckUpstream(t *testing.T) {
+	roots := rainsdConfig{RootServers: []string{"192.0.2.1:5022"}}
+	forwarders := rainsdConfig{Forwarders: []string{"192.0.2.2:5022"}}
+	var tests = []struct {
+		mode              string
+		config, previous  rainsdConfig
+		ownResolver, isOk bool
+	}{
+		{ModeRecursive, roots, roots, false, true},
+		{ModeRecursive, roots, rainsdConfig{}, true, true},
+		{ModeRecursive, roots, rainsdConfig{}, false, false},
+		{ModeAuthoritative, roots, rainsdConfig{}, false, false},
+		{ModeForwarder, forwarders, rainsdConfig{Forwarders: []string{"192.0.2.3:5022"}}, true,
+			true},
+		{ModeForwarder, forwarders, rainsdConfig{Forwarders: []string{"192.0.2.3:5022"}}, false,
+			false},
+		{ModeForwarder, rainsdConfig{RootServers: roots.RootServers,
+			Forwarders: forwarders.Forwarders}, forwarders, true, false},
+		{ModeRecursive, rainsdConfig{RootServers: roots.RootServers,
+			Forwarders: forwarders.Forwarders}, roots, true, false},
+	}
+	for i, test := range tests {
+		err := checkUpstream(test.mode, test.config, test.previous, test.ownResolver)
+		if (err == nil) != test.isOk {
+			t.Errorf("%d: expected ok=%t, got error %v", i, test.isOk, err)
+		}
+	}
+}
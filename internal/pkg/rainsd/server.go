@@ -1,24 +1,28 @@
 package rainsd
 
 import (
-	"crypto/tls"
-	"crypto/x509"
+	"context"
+	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/capture"
 	"github.com/netsec-ethz/rains/internal/pkg/connection"
 	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
 	"github.com/netsec-ethz/rains/internal/pkg/util"
 )
 
-const (
-	nofReapers       = 3
-	nofCheckPointers = 3
-	shutdownChannels = nofReapers + nofCheckPointers
-)
+//drainInterval is how often Shutdown checks whether all queries have been processed.
+const drainInterval = 10 * time.Millisecond
 
 //Server represents a rainsd server instance.
 type Server struct {
+	//inFlight is the number of messages taken from the queues and lookups of the resolver which
+	//are still being processed. It is accessed atomically.
+	inFlight int64
 	//inputChannel is used by this server to receive messages from other servers
 	inputChannel *connection.Channel
 	//recursiveResolver is the input channel of a recursive resolver which handles all recursive lookups
@@ -26,54 +30,167 @@ type Server struct {
 	sendToRecResolver func(connection.Message)
 	//resolver can be configured as a forwarder or perform recursive lookup by itself.
 	resolver *libresolve.Resolver
+	//externalResolver is set if the resolver has been set with SetResolver instead of being
+	//created from the config, in which case its upstream servers are not reloaded.
+	externalResolver bool
 	//config contains configurations of this server
 	config rainsdConfig
 	//authority states the names over which this server has authority
 	authority map[zoneContext]bool
-	//certPool stores received certificates
-	certPool *x509.CertPool
-	//tlsCert holds the tls certificate of this server
-	tlsCert tls.Certificate
+	//reloadable contains the TLS certificate and the settings which are replaced when the
+	//configuration is reloaded.
+	reloadable reloadable
+	//reloadMux prevents that the configuration is reloaded concurrently.
+	reloadMux sync.Mutex
 	//capabilityHash contains the sha256 hash of this server's capability list
 	capabilityHash string
 	//capabilityList contains the string representation of this server's capability list.
 	capabilityList string
-	//shutdown can be used to stop the go routines handling the input channels and closes them.
+	//shutdown is closed to stop the go routines handling the input channels, the reapers and the
+	//checkpointing, which in turn close the input channels.
 	shutdown chan bool
 	//queues store the incoming sections and keeps track of how many go routines are working on it.
 	queues InputQueues
 	//caches contains all caches of this server
 	caches *Caches
+	//configPath is the path to the configuration file from which the config is reloaded.
+	configPath string
+	//startTime is the point in time when the server was created.
+	startTime time.Time
+	//logHandler is the handler used for logging before the log level is changed.
+	logHandler log.Handler
+	//blacklist contains the addresses from which no connections are accepted.
+	blacklist *blacklist
+	//acl restricts the addresses from which queries and sections are accepted.
+	acl *acl
+	//activeTokens contains the tokens of the forwarded queries whose answers are not restricted
+	//by the acl as pushed sections.
+	activeTokens *activeTokens
+	//adminShutdown is used to close the admin socket.
+	adminShutdown chan bool
+	//listenerShutdown is used to close the listener accepting connections from other servers.
+	listenerShutdown chan bool
+	//listening is closed as soon as the server accepts connections or has failed to do so, in
+	//which case listenErr is set.
+	listening chan struct{}
+	listenErr error
+	//capture records the received queries and the sent answers if CapturePath is set.
+	capture *capture.Writer
+	//mirror sends a fraction of the received queries to a shadow server if MirrorAddress is set.
+	mirror *mirror
+	//middlewares are called at the stages of processing queries and sections, see Use.
+	middlewares middlewares
+	//activity counts queries and messages for the stats command and the dashboard.
+	activity *activity
+	//unverifiedZones contains the content of the signed AuthoritativeZoneFiles which is verified
+	//once the server is listening.
+	unverifiedZones []util.MsgSectionSender
+	//prefetch refreshes popular answers before they expire. It is nil if PrefetchWindow is not set.
+	prefetch *prefetcher
+	//rateLimiter limits the rate of answers per client prefix and names. It is nil if
+	//ResponsesPerSecond is not set.
+	rateLimiter *rateLimiter
+	//inconsistentZones counts the sections per zone dropped because they contradicted the caches.
+	inconsistentZones inconsistentZones
+	//tokenTracer records the path of queries with the TokenTracing option through the server.
+	tokenTracer *tokenTracer
+	//signer signs the sent answers and notifications. It is nil if SignAnswers is not set.
+	signer *messageSigner	//forwarded contains the expiration timers of the queries forwarded to the resolver.
+	forwarded *forwardTimers
 }
 
 //New returns a pointer to a newly created rainsd server instance with the given config. The server
 //logs with the provided level of logging.
 func New(configPath string, id string) (server *Server, err error) {
 	server = &Server{
-		inputChannel: &connection.Channel{RemoteChan: make(chan connection.Message, 100)},
+		inputChannel:     &connection.Channel{RemoteChan: make(chan connection.Message, 100)},
+		configPath:       configPath,
+		startTime:        time.Now(),
+		logHandler:       log.Root().GetHandler(),
+		blacklist:        newBlacklist(),
+		acl:              &acl{},
+		forwarded:        newForwardTimers(),
+		adminShutdown:    make(chan bool, 1),
+		listenerShutdown: make(chan bool, 1),
+		listening:        make(chan struct{}),
+		activity:         newActivity(),
+		tokenTracer:      newTokenTracer(),
 	}
 	server.inputChannel.SetRemoteAddr(connection.ChannelAddr{ID: id})
 	if server.config, err = loadConfig(configPath); err != nil {
 		return nil, err
 	}
+	if err = server.blacklist.Replace(server.config.Blacklist); err != nil {
+		return nil, err
+	}
+	if err = server.acl.Replace(server.config.ACL); err != nil {
+		return nil, err
+	}
+	server.activeTokens = newActiveTokens(int(server.config.ActiveTokenCacheSize))
+	if server.config.LogLevel != "" {
+		if err = server.setLogLevel(server.config.LogLevel); err != nil {
+			return nil, err
+		}
+	}
 	server.authority = make(map[zoneContext]bool)
 	for i, context := range server.config.ContextAuthority {
 		server.authority[zoneContext{Zone: server.config.ZoneAuthority[i], Context: context}] = true
 	}
-	if server.certPool, server.tlsCert, err = loadTLSCertificate(server.config.TLSCertificateFile,
-		server.config.TLSPrivateKeyFile); err != nil {
+	pool, cert, err := loadTLSCertificate(server.config.TLSCertificateFile,
+		server.config.TLSPrivateKeyFile)
+	if err != nil {
 		return nil, err
 	}
+	server.reloadable.set(server.config, pool, cert)
 	server.capabilityHash, server.capabilityList = initOwnCapabilities(server.config.Capabilities)
+	if server.config.CapturePath != "" {
+		if server.capture, err = capture.Create(server.config.CapturePath); err != nil {
+			return nil, err
+		}
+	}
+	if server.config.MirrorAddress != "" {
+		if server.mirror, err = newMirror(server.config.MirrorAddress,
+			server.config.MirrorFraction, server.config.MirrorDiff); err != nil {
+			return nil, err
+		}
+	}
+	if server.config.Mode, err = operatingMode(server.config); err != nil {
+		return nil, err
+	}
+	if server.config.Mode == ModeForwarder {
+		if server.resolver, err = newForwardingResolver(server.config); err != nil {
+			return nil, err
+		}
+	} else if len(server.config.RootServers) > 0 {
+		if server.resolver, err = newRecursiveResolver(server.config); err != nil {
+			return nil, err
+		}
+	}
+	server.prefetch = newPrefetcher(server.config.PrefetchHits,
+		int64(server.config.PrefetchWindow/time.Second))
+	if server.rateLimiter, err = newRateLimiter(server.config); err != nil {
+		return nil, err
+	}
+	if server.signer, err = newMessageSigner(server.config); err != nil {
+		return nil, err
+	}
 
-	server.shutdown = make(chan bool, shutdownChannels)
+	server.shutdown = make(chan bool)
+	if server.config.DelegationBufferSize == 0 {
+		server.config.DelegationBufferSize = server.config.NormalBufferSize
+	}
+	if server.config.BulkBufferSize == 0 {
+		server.config.BulkBufferSize = server.config.NormalBufferSize
+	}
 	server.queues = InputQueues{
-		Prio:    make(chan util.MsgSectionSender, server.config.PrioBufferSize),
-		Normal:  make(chan util.MsgSectionSender, server.config.NormalBufferSize),
-		Notify:  make(chan util.MsgSectionSender, server.config.NotificationBufferSize),
-		PrioW:   make(chan struct{}, server.config.PrioWorkerCount),
-		NormalW: make(chan struct{}, server.config.NormalWorkerCount),
-		NotifyW: make(chan struct{}, server.config.NotificationWorkerCount),
+		Prio:       make(chan util.MsgSectionSender, server.config.PrioBufferSize),
+		Delegation: make(chan util.MsgSectionSender, server.config.DelegationBufferSize),
+		Normal:     make(chan util.MsgSectionSender, server.config.NormalBufferSize),
+		Bulk:       make(chan util.MsgSectionSender, server.config.BulkBufferSize),
+		Notify:     make(chan util.MsgSectionSender, server.config.NotificationBufferSize),
+		PrioW:      make(chan struct{}, server.config.PrioWorkerCount),
+		NormalW:    make(chan struct{}, server.config.NormalWorkerCount),
+		NotifyW:    make(chan struct{}, server.config.NotificationWorkerCount),
 	}
 	server.caches = initCaches(server.config)
 	if err = loadRootZonePublicKey(server.config.RootZonePublicKeyPath, server.caches.ZoneKeyCache,
@@ -81,23 +198,44 @@ func New(configPath string, id string) (server *Server, err error) {
 		log.Warn("Failed to load root zone public key")
 		return nil, err
 	}
+	if err = server.loadZoneFiles(); err != nil {
+		log.Warn("Failed to load authoritative zone files", "error", err)
+		return nil, err
+	}
 	log.Info("Successfully initialized server", "id", id)
 	return
 }
 
-//Addr returns the server's address
+//Addr returns the server's address. If the configured port is zero, the port chosen by the
+//operating system is only returned once Listening has returned.
 func (s *Server) Addr() net.Addr {
 	return s.config.ServerAddress.Addr
 }
 
+//Listening waits until the server accepts connections and returns the address it listens on. It
+//returns an error if the listener could not be started or did not start within timeout.
+func (s *Server) Listening(timeout time.Duration) (net.Addr, error) {
+	select {
+	case <-s.listening:
+		if s.listenErr != nil {
+			return nil, s.listenErr
+		}
+		return s.config.ServerAddress.Addr, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("server did not start listening within %v", timeout)
+	}
+}
+
 //SetRecursiveResolver adds a channel which handles recursive lookups for this server
 func (s *Server) SetRecursiveResolver(write func(connection.Message)) {
 	s.sendToRecResolver = write
 }
 
-//SetResolver adds a resolver which can forward or recursively resolve queries for this server
+//SetResolver adds a resolver which can forward or recursively resolve queries for this server. It
+//replaces the resolver created from the RootServers or Forwarders of the server's config.
 func (s *Server) SetResolver(resolver *libresolve.Resolver) {
 	s.resolver = resolver
+	s.externalResolver = true
 }
 
 //Start starts up the server and it begins to listen for incoming connections according to its
@@ -108,6 +246,13 @@ func (s *Server) Start(monitorResources bool) error {
 	go s.workNotification()
 	log.Debug("Goroutines working on input queue started")
 	initReapers(s.config, s.caches, s.shutdown)
+	go repeatFuncCaller(s.activeTokens.removeExpired, s.config.ReapEngineTimeout, s.shutdown)
+	if s.prefetch != nil {
+		go repeatFuncCaller(s.prefetch.removeExpired, s.config.ReapEngineTimeout, s.shutdown)
+	}
+	if s.rateLimiter != nil {
+		go repeatFuncCaller(s.rateLimiter.removeExpired, s.config.ReapEngineTimeout, s.shutdown)
+	}
 	if s.config.PreLoadCaches {
 		loadCaches(s.config.CheckPointPath, s.caches, s.config.ZoneAuthority, s.config.ContextAuthority)
 		log.Info("Caches loaded from checkpoint",
@@ -120,6 +265,19 @@ func (s *Server) Start(monitorResources bool) error {
 	if monitorResources {
 		go measureSystemRessources()
 	}
+	if s.config.AdminSocketPath != "" {
+		go s.serveAdmin()
+	}
+	if s.config.DashboardAddress != "" {
+		go s.serveDashboard()
+	}
+	if s.mirror != nil {
+		go s.mirror.run(s.shutdown)
+	}
+	if len(s.unverifiedZones) > 0 {
+		go s.verifyZoneFiles()
+	}
+	s.resignZoneFiles()
 	// Initialize Rayhaan's tracer?
 	/*if traceAddr != "" {
 		t, err := NewTracer(traceSrvID, traceAddr)
@@ -134,15 +292,78 @@ func (s *Server) Start(monitorResources bool) error {
 	return nil
 }
 
-//Shutdown closes the input channels and stops the function creating new go routines to handle the
-//input. Already running worker go routines will finish eventually.
-func (s *Server) Shutdown() {
-	for i := 0; i < shutdownChannels; i++ {
-		s.shutdown <- true
+//Shutdown stops accepting new connections and waits until the queued messages, the queries being
+//processed and the queries forwarded to upstream servers are done, such that the server can be
+//replaced without dropping queries. It then stops the workers, checkpoints the caches if
+//CheckPointPath is set and closes all connections. If ctx is done before the server is drained,
+//the remaining queries are dropped and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.resolver != nil {
+		//The resolver sends the answers to forwarded queries over its own connection.
+		if err := s.resolver.Connect(s.config.ServerAddress.Addr); err != nil {
+			log.Warn("Resolver could not connect before shutdown", "error", err)
+		}
+	}
+	select {
+	case s.listenerShutdown <- true:
+	default:
+	}
+	err := s.drain(ctx)
+	if err != nil {
+		log.Warn("Shutdown before all queries were processed", "queued", s.queued(),
+			"inFlight", atomic.LoadInt64(&s.inFlight), "pending", s.caches.PendingQueries.Len(),
+			"error", err)
+	}
+	close(s.shutdown)
+	s.forwarded.stopAll()
+	select {
+	case s.adminShutdown <- true:
+	default:
+	}
+	if s.capture != nil {
+		s.capture.Close()
 	}
-	s.queues.Normal <- util.MsgSectionSender{}
-	s.queues.Prio <- util.MsgSectionSender{}
-	s.queues.Notify <- util.MsgSectionSender{}
+	if s.resolver != nil {
+		s.resolver.Close()
+	}
+	if s.config.CheckPointPath != "" {
+		storeCachesContent(s.config, s.caches)
+	}
+	for _, addr := range s.caches.ConnCache.Addrs() {
+		s.caches.ConnCache.CloseAndRemoveConnections(addr)
+	}
+	return err
+}
+
+//drain waits until the queues are empty and no message, lookup or pending query is processed
+//anymore or until ctx is done, in which case ctx's error is returned.
+func (s *Server) drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for s.queued() != 0 || atomic.LoadInt64(&s.inFlight) != 0 ||
+		s.caches.PendingQueries.Len() != 0 || s.caches.PendingKeys.Len() != 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+//queued returns the number of messages in the queues.
+func (s *Server) queued() int {
+	return len(s.queues.Prio) + len(s.queues.Delegation) + len(s.queues.Normal) +
+		len(s.queues.Bulk) + len(s.queues.Notify)
+}
+
+//process calls handle in a new go routine and counts it as in flight until it returns.
+func (s *Server) process(handle func()) {
+	atomic.AddInt64(&s.inFlight, 1)
+	go func() {
+		defer atomic.AddInt64(&s.inFlight, -1)
+		handle()
+	}()
 }
 
 //Write delivers an encoded rains message and a response inputChannel to the server.
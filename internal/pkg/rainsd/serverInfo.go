@@ -35,12 +35,19 @@ type rainsdConfig struct {
 
 	//inbox
 	MaxMsgByteLength        uint
+	MaxMsgNestingDepth      int
+	MaxMsgElements          int
 	PrioBufferSize          uint
+	DelegationBufferSize    uint //defaults to NormalBufferSize if 0
 	NormalBufferSize        uint
+	BulkBufferSize          uint //defaults to NormalBufferSize if 0
 	NotificationBufferSize  uint
 	PrioWorkerCount         uint
 	NormalWorkerCount       uint
 	NotificationWorkerCount uint
+	DelegationQueueWeight   uint //defaults to 8 if 0
+	NormalQueueWeight       uint //defaults to 4 if 0
+	BulkQueueWeight         uint //defaults to 1 if 0
 	CapabilitiesCacheSize   int
 	PeerToCapCacheSize      uint
 	ActiveTokenCacheSize    uint
@@ -68,6 +75,57 @@ type rainsdConfig struct {
 	ZoneAuthority              []string
 	MaxCacheValidity           util.MaxCacheValidity //in hours
 	ReapEngineTimeout          time.Duration         //in seconds
+	MaxStaleAnswerAge          time.Duration         //in seconds, serving stale is disabled if 0
+	PrefetchHits               int
+	PrefetchWindow             time.Duration //in seconds, prefetching is disabled if 0
+	MaxLastHopAnswerByteLength uint          //defaults to 16 KiB if 0
+
+	//admin
+	AdminSocketPath  string //admin socket is disabled if empty
+	Blacklist        []string
+	ACL              aclConfig
+	LogLevel         string
+	CapturePath      string //capturing is disabled if empty
+	DashboardAddress string //dashboard is disabled if empty
+
+	//mirror
+	MirrorAddress  string //mirroring is disabled if empty
+	MirrorFraction float64
+	MirrorDiff     bool
+
+	//rate limiting
+	ResponsesPerSecond  float64       //response rate limiting is disabled if 0
+	RateLimitWindow     time.Duration //in seconds, defaults to 15 if 0
+	RateLimitSlip       int
+	RateLimitIPv4Prefix int //defaults to 24 if 0
+	RateLimitIPv6Prefix int //defaults to 56 if 0
+
+	//recursion
+	Mode                 string   //derived from the other entries if empty
+	RootServers          []string //recursive resolution is disabled if empty
+	Forwarders           []string
+	UpstreamTimeout      time.Duration //in milliseconds, 10 seconds if 0
+	UpstreamRetries      int
+	UpstreamRetryBackoff time.Duration //in milliseconds, 100 if 0
+
+	//answer signing
+	SignAnswers                     bool
+	InfrastructureKeyPath           string
+	InfrastructureKeyPassphrasePath string //required if the infrastructure key is encrypted
+	InfrastructureKeyPhase          int
+	AnswerSigValidity               time.Duration //in seconds, 300 if 0
+
+	//zone files
+	AuthoritativeZoneFiles []zoneFileConfig
+}
+
+//zoneFileConfig lists the configuration of a zone file which is loaded at startup and whose zone is
+//served authoritatively.
+type zoneFileConfig struct {
+	Path           string
+	PrivateKeyPath string //the zone file must be signed if empty
+	KeyPhase       int
+	SigValidity    time.Duration //in hours
 }
 
 type missingKeyMetaData struct {
@@ -11,10 +11,12 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/netsec-ethz/rains/internal/pkg/cache"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
@@ -33,6 +35,9 @@ type checkPointValue struct {
 	Sections   []section.Section
 	ValidSince []int64
 	ValidUntil []int64
+	//Authoritative states for each section whether this server is authoritative for it. It is
+	//missing in checkpoints written by older versions.
+	Authoritative []bool
 }
 
 // trace is a wrapper function which all callees wishing to submit a trace should use,
@@ -52,19 +57,61 @@ func sendNotificationMsg(tok token.Token, destination net.Addr,
 		Token: tok,
 		Data:  data,
 	}
-	sendSection(notification, token.Token{}, destination, s)
+	s.activity.notification(true, destination, notification)
+	s.tokenTracer.recordEvent(tok, traceNotified, destination,
+		fmt.Sprintf("%d %s", notificationType, data))
+	sections := []section.Section{notification}
+	if report, ok := s.tokenTracer.report(tok, s.config.ServerAddress.Addr); ok {
+		sections = append(sections, report)
+	}
+	sendSections(sections, token.Token{}, destination, s)
+}
+
+//acknowledge sends a NTHeartbeat notification for the pushed message with token to sender. It
+//tells a publisher that the server has accepted the sections for processing. Errors found during
+//their verification are reported with a separate notification.
+func acknowledge(tok token.Token, sender net.Addr, s *Server) {
+	sendNotificationMsg(tok, sender, section.NTHeartbeat, "pushed sections accepted", s)
 }
 
 //sendSections creates a messages containing token and sections and sends it to destination. If
-//token is empty, a new token is generated
+//token is empty, a new token is generated. The message is signed with the server's infrastructure
+//key if answer signing is enabled. It is not sent if it cannot be signed.
 func sendSections(sections []section.Section, tok token.Token, destination net.Addr, s *Server) error {
 	if tok == [16]byte{} {
 		tok = token.New()
 	}
 	msg := message.Message{Token: tok, Content: sections}
+	if s.signer != nil {
+		if err := s.signer.sign(&msg); err != nil {
+			log.Error("Could not sign message, dropping it", "token", tok, "error", err)
+			return err
+		}
+	}
 	return s.sendTo(msg, destination, 1, 1)
 }
 
+//sendAnswer passes the answer sections through the BeforeAnswer middlewares and the response rate
+//limiter and sends them to destination as sendSections does, unless they have been dropped. If
+//the answered queries are traced, the answer is followed by a NTTokenTrace notification.
+func sendAnswer(sections []section.Section, tok token.Token, destination net.Addr, s *Server) {
+	sections, ok := s.intercept(BeforeAnswer, tok, destination, sections)
+	if !ok {
+		return
+	}
+	if s.rateLimiter != nil {
+		if sections, ok = s.rateLimiter.limit(tok, destination, sections); !ok {
+			return
+		}
+	}
+	s.tokenTracer.recordEvent(tok, traceAnswered, destination,
+		fmt.Sprintf("%d sections", len(sections)))
+	if report, ok := s.tokenTracer.report(tok, s.config.ServerAddress.Addr); ok {
+		sections = append(sections, report)
+	}
+	sendSections(sections, tok, destination, s)
+}
+
 //sendSection creates a messages containing token and section and sends it to destination. If
 //token is empty, a new token is generated
 func sendSection(sec section.Section, token token.Token, destination net.Addr, s *Server) error {
@@ -99,10 +146,19 @@ func loadConfig(configPath string) (rainsdConfig, error) {
 	config.QueryValidity *= time.Second
 	config.AddressQueryValidity *= time.Second
 	config.ReapEngineTimeout *= time.Second
+	config.MaxStaleAnswerAge *= time.Second
+	config.PrefetchWindow *= time.Second
+	config.RateLimitWindow *= time.Second
+	config.AnswerSigValidity *= time.Second
+	config.UpstreamTimeout *= time.Millisecond
+	config.UpstreamRetryBackoff *= time.Millisecond
 	config.MaxCacheValidity.AddressAssertionValidity *= time.Hour
 	config.MaxCacheValidity.AssertionValidity *= time.Hour
 	config.MaxCacheValidity.ShardValidity *= time.Hour
 	config.MaxCacheValidity.ZoneValidity *= time.Hour
+	for i := range config.AuthoritativeZoneFiles {
+		config.AuthoritativeZoneFiles[i].SigValidity *= time.Hour
+	}
 	return config, nil
 }
 
@@ -194,29 +250,55 @@ func initStoreCachesContent(config rainsdConfig, caches *Caches, stop chan bool)
 	time.Sleep(100 * time.Millisecond)
 	go repeatFuncCaller(func() {
 		checkpoint(path.Join(config.CheckPointPath, aCheckPointFileName),
-			caches.AssertionsCache.Checkpoint)
+			caches.AssertionsCache.Checkpoint, config)
 	}, config.AssertionCheckPointInterval, stop)
 	go repeatFuncCaller(func() {
 		checkpoint(path.Join(config.CheckPointPath, nCheckPointFileName),
-			caches.NegAssertionCache.Checkpoint)
+			caches.NegAssertionCache.Checkpoint, config)
 	}, config.NegAssertionCheckPointInterval, stop)
 	go repeatFuncCaller(func() {
 		checkpoint(path.Join(config.CheckPointPath, zCheckPointFileName),
-			caches.ZoneKeyCache.Checkpoint)
+			caches.ZoneKeyCache.Checkpoint, config)
 	}, config.ZoneKeyCheckPointInterval, stop)
 }
 
-func checkpoint(path string, values func() []section.Section) {
-	value := checkPointValue{Sections: values()}
-	for _, s := range value.Sections {
-		value.ValidSince = append(value.ValidSince, s.(section.WithSigForward).ValidSince())
-		value.ValidUntil = append(value.ValidUntil, s.(section.WithSigForward).ValidUntil())
-	}
+//storeCachesContent checkpoints the assertion, negative assertion and zone key caches to
+//config.CheckPointPath.
+func storeCachesContent(config rainsdConfig, caches *Caches) {
+	checkpoint(path.Join(config.CheckPointPath, aCheckPointFileName),
+		caches.AssertionsCache.Checkpoint, config)
+	checkpoint(path.Join(config.CheckPointPath, nCheckPointFileName),
+		caches.NegAssertionCache.Checkpoint, config)
+	checkpoint(path.Join(config.CheckPointPath, zCheckPointFileName),
+		caches.ZoneKeyCache.Checkpoint, config)
+}
+
+//checkpointMux prevents that a checkpoint is written by the periodic checkpointing and by Shutdown
+//at the same time.
+var checkpointMux sync.Mutex
+
+func checkpoint(path string, values func() []section.Section, config rainsdConfig) {
+	value := newCheckPointValue(values(), config.ZoneAuthority, config.ContextAuthority)
+	checkpointMux.Lock()
+	defer checkpointMux.Unlock()
 	if err := util.Save(path, value); err != nil {
 		log.Error("Was not able to checkpoint cache", "path", path, "error", err)
 	}
 }
 
+//newCheckPointValue returns the checkpoint of sections. Sections for which authZone and authContext
+//grant authority are marked as authoritative.
+func newCheckPointValue(sections []section.Section, authZone, authContext []string) checkPointValue {
+	value := checkPointValue{Sections: sections}
+	for _, s := range value.Sections {
+		s := s.(section.WithSigForward)
+		value.ValidSince = append(value.ValidSince, s.ValidSince())
+		value.ValidUntil = append(value.ValidUntil, s.ValidUntil())
+		value.Authoritative = append(value.Authoritative, isAuthoritative(s, authZone, authContext))
+	}
+	return value
+}
+
 func loadCaches(cpPath string, caches *Caches, authZone, authContext []string) {
 
 	//load assertion check point
@@ -226,7 +308,7 @@ func loadCaches(cpPath string, caches *Caches, authZone, authContext []string) {
 	}
 	for _, s := range sections {
 		if s, ok := s.(*section.Assertion); ok {
-			caches.AssertionsCache.Add(s, time.Now().Add(24*time.Hour).Unix(),
+			caches.AssertionsCache.Add(s, clock.Now().Add(24*time.Hour).Unix(),
 				isAuthoritative(s, authZone, authContext))
 		} else {
 			log.Warn("Invalid type for assertion cache", "type", fmt.Sprintf("%T", s))
@@ -241,13 +323,13 @@ func loadCaches(cpPath string, caches *Caches, authZone, authContext []string) {
 	for _, s := range sections {
 		switch s := s.(type) {
 		case *section.Shard:
-			caches.NegAssertionCache.AddShard(s, time.Now().Add(24*time.Hour).Unix(),
+			caches.NegAssertionCache.AddShard(s, clock.Now().Add(24*time.Hour).Unix(),
 				isAuthoritative(s, authZone, authContext))
 		case *section.Pshard:
-			caches.NegAssertionCache.AddPshard(s, time.Now().Add(24*time.Hour).Unix(),
+			caches.NegAssertionCache.AddPshard(s, clock.Now().Add(24*time.Hour).Unix(),
 				isAuthoritative(s, authZone, authContext))
 		case *section.Zone:
-			caches.NegAssertionCache.AddZone(s, time.Now().Add(24*time.Hour).Unix(),
+			caches.NegAssertionCache.AddZone(s, clock.Now().Add(24*time.Hour).Unix(),
 				isAuthoritative(s, authZone, authContext))
 		default:
 			log.Warn("Invalid type for negative Assertion cache", "type", fmt.Sprintf("%T", s))
@@ -0,0 +1,83 @@
+package rainsd
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//Provenance of a cached section.
+const (
+	//ProvenanceAuthoritative marks sections of zones for which the server is authoritative.
+	ProvenanceAuthoritative = "authoritative"
+	//ProvenanceCached marks sections which the server obtained from other servers.
+	ProvenanceCached = "cached"
+	//ProvenanceUnknown marks sections of checkpoints which do not record the provenance.
+	ProvenanceUnknown = "unknown"
+)
+
+//SnapshotEntry is a section of a cache snapshot together with its provenance. The validity of
+//Section is the one it had in the cache.
+type SnapshotEntry struct {
+	Section    section.WithSigForward
+	Provenance string
+}
+
+//LoadCacheSnapshot returns the entries of the cache checkpoint stored at path.
+func LoadCacheSnapshot(path string) ([]SnapshotEntry, error) {
+	value := checkPointValue{}
+	if err := util.Load(path, &value); err != nil {
+		return nil, err
+	}
+	return value.entries()
+}
+
+//DecodeCacheSnapshot returns the entries of the gob encoded cache snapshot data as exported over
+//the admin socket.
+func DecodeCacheSnapshot(data []byte) ([]SnapshotEntry, error) {
+	value := checkPointValue{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return nil, fmt.Errorf("could not decode cache snapshot: %v", err)
+	}
+	return value.entries()
+}
+
+//encodeCacheSnapshot returns the gob encoding of the checkpoint of sections.
+func encodeCacheSnapshot(sections []section.Section, authZone, authContext []string) ([]byte,
+	error) {
+	var buf bytes.Buffer
+	value := newCheckPointValue(sections, authZone, authContext)
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, fmt.Errorf("could not encode cache snapshot: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+//entries returns the sections of the checkpoint with their validity and provenance.
+func (v checkPointValue) entries() ([]SnapshotEntry, error) {
+	if len(v.ValidSince) != len(v.Sections) || len(v.ValidUntil) != len(v.Sections) {
+		return nil, fmt.Errorf("malformed checkpoint: %d sections but %d/%d validity values",
+			len(v.Sections), len(v.ValidSince), len(v.ValidUntil))
+	}
+	var entries []SnapshotEntry
+	for i, s := range v.Sections {
+		sec, ok := s.(section.WithSigForward)
+		if !ok {
+			return nil, fmt.Errorf("malformed checkpoint: unexpected section type %T", s)
+		}
+		sec.SetValidSince(v.ValidSince[i])
+		sec.SetValidUntil(v.ValidUntil[i])
+		entry := SnapshotEntry{Section: sec, Provenance: ProvenanceUnknown}
+		if len(v.Authoritative) == len(v.Sections) {
+			entry.Provenance = ProvenanceCached
+			if v.Authoritative[i] {
+				entry.Provenance = ProvenanceAuthoritative
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
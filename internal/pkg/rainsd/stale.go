@@ -0,0 +1,61 @@
+package rainsd
+
+import (
+	"context"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//answerStale answers the queries of ss with the cached assertions answering them which expired
+//less than MaxStaleAnswerAge ago, followed by a NTStaleAnswer notification, and sends the queries
+//to the recursive resolver again to refresh the cache. It returns false without answering if
+//serving stale is disabled or a query has no such assertion.
+func (s *Server) answerStale(ss util.MsgSectionSender) bool {
+	if s.config.MaxStaleAnswerAge <= 0 {
+		return false
+	}
+	answer := []section.Section{}
+	for _, sec := range ss.Sections {
+		q, ok := sec.(*query.Name)
+		if !ok {
+			return false
+		}
+		assertions := cachedAssertions(q, clock.Now().Add(-s.config.MaxStaleAnswerAge).Unix(), s)
+		if len(assertions) == 0 {
+			return false
+		}
+		answer = append(answer, assertions...)
+	}
+	log.Info("Answering queries with stale assertions", "token", ss.Token, "queries", ss.Sections)
+	answer = append(s.shapeAnswer(answer, ss.Sections, ss.Token), &section.Notification{
+		Type:  section.NTStaleAnswer,
+		Token: ss.Token,
+		Data:  "the answer could not be refreshed",
+	})
+	sendAnswer(answer, ss.Token, ss.Sender, s)
+	s.refresh(ss.Sections)
+	return true
+}
+
+//refresh sends queries with a new token to the recursive resolver, which does not answer them from
+//its own cache. The answer is not sent to any client but replaces the expired or expiring
+//assertions in the caches.
+func (s *Server) refresh(queries []section.Section) {
+	validUntil := clock.Now().Add(s.config.QueryValidity).Unix()
+	qs := []section.Section{}
+	for _, sec := range queries {
+		q := withoutLastHopOptions(sec.(*query.Name))
+		q.Expiration = validUntil
+		qs = append(qs, q)
+	}
+	s.sendToRecursiveResolverCtx(libresolve.WithoutCache(context.Background()),
+		message.Message{Token: token.New(), Content: qs})
+}
@@ -6,17 +6,22 @@ package rainsd
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 
+	"github.com/netsec-ethz/rains/internal/pkg/capture"
 	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
 	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
 )
@@ -26,39 +31,41 @@ func (s *Server) sendTo(msg message.Message, receiver net.Addr, retries,
 	backoffMilliSeconds int) (err error) {
 	conns, ok := s.caches.ConnCache.GetConnection(receiver)
 	if !ok {
-		conn, err := createConnection(receiver, s.config.KeepAlivePeriod, s.certPool)
+		conn, err := createConnection(receiver, s.config.KeepAlivePeriod, s.peerCertPool())
 		//add connection to cache
 		conns = append(conns, conn)
 		if err != nil {
 			log.Warn("Could not establish connection", "error", err, "receiver", receiver)
+			s.activity.sent(receiver, err)
 			return err
 		}
 		s.caches.ConnCache.AddConnection(conn)
 		//handle connection
-		if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
-			go s.handleConnection(conn, tcpAddr)
-		} else {
-			log.Warn("Type assertion failed. Expected *net.TCPAddr", "addr", conn.RemoteAddr())
-		}
-		//add capabilities to message
-		msg.Capabilities = []message.Capability{message.Capability(s.capabilityHash)}
+		go s.handleConnection(conn, conn.RemoteAddr())
+		//add capabilities to message. They are listed instead of hashed as the receiver needs them
+		//to select the codec of its answers.
+		msg.Capabilities = s.config.Capabilities
 	}
+	peerCaps, _ := s.caches.ConnCache.GetCapabilityList(receiver)
+	c := codec.Negotiate(s.config.Capabilities, peerCaps)
 	for _, conn := range conns {
 		log.Debug("Send message", "dst", conn.RemoteAddr(), "content", msg)
 		//FIXME CFE, cannot write to conn directly because if conn is a channel it does not work.
 		//This is because the cbor library writes multiple times to the connection, but the channel
 		//receiver only listens for one message. Is there a way for the receiver to determine when a
 		//message is processed and then stop listening?
-		encoding := new(bytes.Buffer)
-		if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
-			log.Warn(fmt.Sprintf("failed to marshal message to conn: %v", err))
+		if err := c.Encode(conn, &msg); err != nil {
+			log.Warn(fmt.Sprintf("failed to send message to conn: %v", err), "codec", c.Capability())
+			s.activity.sent(receiver, err)
 			s.caches.ConnCache.CloseAndRemoveConnection(conn)
 			continue
 		}
-		if _, err := conn.Write(encoding.Bytes()); err != nil {
-			log.Warn("Was not able to send encoded message")
-		}
 		log.Debug("Send successful", "receiver", receiver)
+		s.activity.sent(receiver, nil)
+		s.record(capture.Response, receiver, &msg)
+		if s.mirror != nil {
+			s.mirror.answered(&msg)
+		}
 		return nil
 	}
 	if retries > 0 {
@@ -69,16 +76,55 @@ func (s *Server) sendTo(msg message.Message, receiver net.Addr, retries,
 	return errors.New("Was not able to send the mesage. No retries left")
 }
 
+//sendToRecursiveResolver hands the queries of msg, which the server cannot answer from its caches,
+//to the server's resolver. It sends the answers to the server, which caches them and answers the
+//pending queries. Without a resolver, msg is sent to the recursive resolver set with
+//SetRecursiveResolver.
 func (s *Server) sendToRecursiveResolver(msg message.Message) {
+	s.sendToRecursiveResolverCtx(context.Background(), msg)
+}
+
+//sendToRecursiveResolverCtx is like sendToRecursiveResolver but the lookups of the server's resolver
+//are derived from parent.
+func (s *Server) sendToRecursiveResolverCtx(parent context.Context, msg message.Message) {
+	content, ok := s.intercept(BeforeForwarding, msg.Token, nil, msg.Content)
+	if !ok {
+		return
+	}
+	msg.Content = content
+	for _, sec := range msg.Content {
+		if q, ok := sec.(*query.Name); ok {
+			s.activeTokens.add(msg.Token, q.Expiration)
+		}
+	}
+	if s.tokenTracer.traced(msg.Token) {
+		s.tokenTracer.recordEvent(msg.Token, traceForwarded, nil, queriesDetail(msg.Content))
+		parent = libresolve.WithTrace(parent, func(step libresolve.TraceStep) {
+			s.tokenTracer.recordStep(msg.Token, step)
+		})
+	}
 	if s.resolver != nil {
 		for _, sec := range msg.Content {
 			if q, ok := sec.(*query.Name); ok {
-				go s.resolver.ServerLookup(q, s.config.ServerAddress.Addr, msg.Token)
+				q := q
+				s.process(func() {
+					//The answer is of no use to the client after the query expired.
+					ctx, cancel := context.WithDeadline(parent, time.Unix(q.Expiration, 0))
+					defer cancel()
+					if err := s.resolver.ServerLookup(ctx, q, s.config.ServerAddress.Addr,
+						msg.Token); err != nil {
+						s.upstreamFailed(msg.Token,
+							lookupFailure(ctx.Err() == context.DeadlineExceeded))
+					}
+				})
 			}
 		}
+	} else if s.sendToRecResolver == nil {
+		log.Warn("No recursive resolver configured, dropping queries", "token", msg.Token,
+			"queries", msg.Content)
 	} else {
 		encoding := new(bytes.Buffer)
-		if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+		if err := (codec.CBOR{}).Encode(encoding, &msg); err != nil {
 			log.Warn(fmt.Sprintf("failed to marshal message to conn: %v", err))
 		}
 		message := connection.Message{
@@ -90,6 +136,26 @@ func (s *Server) sendToRecursiveResolver(msg message.Message) {
 	}
 }
 
+//newRecursiveResolver returns a resolver which looks up queries recursively starting at the root
+//servers (host:port) of config. It follows the redirections and delegations from the root servers,
+//remembers the servers of the zones it learns along the way and sends the answers to the server at
+//config.ServerAddress. Servers which do not answer are retried as configured, see
+//configureUpstream.
+func newRecursiveResolver(config rainsdConfig) (*libresolve.Resolver, error) {
+	roots, err := resolveUpstream(config.RootServers, "root server")
+	if err != nil {
+		return nil, err
+	}
+	resolver := libresolve.New(roots, nil, libresolve.Recursive, config.ServerAddress.Addr,
+		config.MaxConnections)
+	if config.ServerAddress.Type == connection.Mem {
+		//In-memory servers are identified by an address of the form host:port.
+		resolver.Dialer = connection.MemDialer{}
+	}
+	configureUpstream(resolver, config)
+	return resolver, nil
+}
+
 //createConnection establishes a connection with receiver
 func createConnection(receiver net.Addr, keepAlive time.Duration, pool *x509.CertPool) (net.Conn, error) {
 	switch receiver.(type) {
@@ -98,6 +164,8 @@ func createConnection(receiver net.Addr, keepAlive time.Duration, pool *x509.Cer
 			KeepAlive: keepAlive,
 		}
 		return tls.DialWithDialer(dialer, receiver.Network(), receiver.String(), &tls.Config{RootCAs: pool, InsecureSkipVerify: true})
+	case *connection.MemAddr:
+		return connection.DialMem(context.Background(), receiver)
 	default:
 		return nil, errors.New("No matching type found for Connection info")
 	}
@@ -108,36 +176,62 @@ func (s *Server) listen() {
 	srvLogger := log.New("addr", s.config.ServerAddress.Addr.String())
 	//always listen on channel
 	go s.handleChannel()
+	var listener net.Listener
+	var err error
 	switch s.config.ServerAddress.Type {
 	case connection.TCP:
 		srvLogger.Info("Start TCP listener")
-		tlsConfig := &tls.Config{Certificates: []tls.Certificate{s.tlsCert}, InsecureSkipVerify: true}
-		listener, err := tls.Listen(s.config.ServerAddress.Addr.Network(),
+		//The certificate is looked up per connection such that a reloaded one is used.
+		tlsConfig := &tls.Config{GetCertificate: s.certificate, InsecureSkipVerify: true}
+		listener, err = tls.Listen(s.config.ServerAddress.Addr.Network(),
 			s.config.ServerAddress.Addr.String(), tlsConfig)
-		if err != nil {
-			srvLogger.Error("Listener error on startup", "error", err)
-			return
-		}
-		defer listener.Close()
-		defer srvLogger.Info("Shutdown listener")
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				srvLogger.Error("listener could not accept connection", "error", err)
-				continue
-			}
-			if isIPBlacklisted(conn.RemoteAddr()) {
-				continue
-			}
-			s.caches.ConnCache.AddConnection(conn)
-			if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
-				go s.handleConnection(conn, tcpAddr)
-			} else {
-				log.Warn("Type assertion failed. Expected *net.TCPAddr", "addr", conn.RemoteAddr())
-			}
+	case connection.Mem:
+		srvLogger.Info("Start in-memory listener")
+		addr, ok := s.config.ServerAddress.Addr.(*connection.MemAddr)
+		if !ok {
+			err = errors.New("in-memory listener requires a *connection.MemAddr")
+			break
 		}
+		listener, err = connection.ListenMem(addr)
 	default:
 		log.Warn("Unsupported Network address type.")
+		err = errors.New("unsupported network address type")
+	}
+	if err != nil {
+		srvLogger.Error("Listener error on startup", "error", err)
+		s.listenErr = err
+		close(s.listening)
+		return
+	}
+	//The address is updated in case the operating system or the in-memory network has chosen it.
+	s.config.ServerAddress.Addr = listener.Addr()
+	close(s.listening)
+	//closed tells the accepting loop that the listener's error is due to the shutdown.
+	closed := make(chan struct{})
+	go func() {
+		<-s.listenerShutdown
+		close(closed)
+		listener.Close()
+	}()
+	defer srvLogger.Info("Shutdown listener")
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-closed:
+				return
+			default:
+			}
+			srvLogger.Error("listener could not accept connection", "error", err)
+			continue
+		}
+		if s.blacklist.Contains(conn.RemoteAddr()) {
+			log.Info("Refused connection from blacklisted address", "addr", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		s.caches.ConnCache.AddConnection(conn)
+		go s.handleConnection(conn, conn.RemoteAddr())
 	}
 }
 
@@ -151,45 +245,112 @@ func (s *Server) handleChannel() {
 			msg.Sender.LocalChan = s.inputChannel.RemoteChan
 			msg.Sender.SetLocalAddr(s.inputChannel.RemoteAddr().(connection.ChannelAddr))
 			s.caches.ConnCache.AddConnection(msg.Sender)
-			m := &message.Message{}
-			reader := cbor.NewReader(bytes.NewBuffer(msg.Msg))
-			if err := reader.Unmarshal(m); err != nil {
+			m, err := codec.NewReader(bytes.NewBuffer(msg.Msg), s.msgLimits()).Read()
+			if err != nil {
 				log.Warn(fmt.Sprintf("failed to unmarshal msg recv over channel: %v", err))
 				continue
 			}
-			deliver(m, msg.Sender.RemoteAddr(),
-				s.queues.Prio, s.queues.Normal, s.queues.Notify, s.caches.PendingKeys)
+			if deliver(m, msg.Sender.RemoteAddr(), s.queues.Prio, s.queues.Delegation,
+				s.queues.Normal, s.queues.Bulk, s.queues.Notify, s.caches.PendingKeys,
+				s.activeTokens, s.caches.ConnCache) {
+				acknowledge(m.Token, msg.Sender.RemoteAddr(), s)
+			}
 		}
 	}
 }
 
+//msgLimits returns the limits a received message must stay within.
+func (s *Server) msgLimits() cbor.Limits {
+	return cbor.Limits{
+		MaxSize:   int(s.config.MaxMsgByteLength),
+		MaxDepth:  s.config.MaxMsgNestingDepth,
+		MaxLength: s.config.MaxMsgElements,
+	}
+}
+
 //handleConnection deframes all incoming messages on conn and passes them to the inbox along with the dstAddr
 func (s *Server) handleConnection(conn net.Conn, dstAddr net.Addr) {
 	log.Info("New connection", "serverAddr", s.Addr(), "conn", dstAddr)
-	reader := cbor.NewReader(conn)
+	reader := codec.NewReader(conn, s.msgLimits())
 	for {
-		var msg message.Message
 		select {
 		case <-s.shutdown:
 			return
 		default:
 		}
-		//FIXME CFE how to check efficiently that message is not too large?
-		if err := reader.Unmarshal(&msg); err != nil {
-			if err.Error() == "failed to read tag: EOF" {
-				log.Info("Connection has been closed", "conn", dstAddr)
-			} else {
-				log.Warn(fmt.Sprintf("failed to read from client: %v", err))
-			}
+		env, err := reader.ReadEnvelope()
+		if checksumErr, ok := err.(*codec.ChecksumError); ok {
+			//The corrupted frame has been skipped.
+			log.Warn("Dropped message corrupted in transport", "conn", dstAddr, "error", checksumErr)
+			continue
+		}
+		if err != nil {
+			logReadError(err, dstAddr)
+			break
+		}
+		if !s.admit(env, conn.RemoteAddr()) {
+			break
+		}
+		msg, err := env.Decode()
+		if err != nil {
+			logReadError(err, dstAddr)
 			break
 		}
-		deliver(&msg, conn.RemoteAddr(),
-			s.queues.Prio, s.queues.Normal, s.queues.Notify, s.caches.PendingKeys)
+		s.activity.received(conn.RemoteAddr())
+		s.record(capture.Query, conn.RemoteAddr(), msg)
+		if !s.permit(msg, conn.RemoteAddr()) {
+			continue
+		}
+		if s.mirror != nil {
+			s.mirror.offer(msg)
+		}
+		if deliver(msg, conn.RemoteAddr(), s.queues.Prio, s.queues.Delegation, s.queues.Normal,
+			s.queues.Bulk, s.queues.Notify, s.caches.PendingKeys, s.activeTokens,
+			s.caches.ConnCache) {
+			acknowledge(msg.Token, conn.RemoteAddr(), s)
+		}
 	}
 	s.caches.ConnCache.CloseAndRemoveConnection(conn)
 }
 
-//isIPBlacklisted returns true if addr is blacklisted
-func isIPBlacklisted(addr net.Addr) bool {
-	return false
+//record appends msg exchanged with peer to the capture if capturing is enabled. Only received
+//messages containing a query and sent messages without a query, i.e. answers, are recorded.
+func (s *Server) record(typ string, peer net.Addr, msg *message.Message) {
+	if s.capture == nil {
+		return
+	}
+	if containsQuery(msg) != (typ == capture.Query) {
+		return
+	}
+	if err := s.capture.Write(typ, peer, msg); err != nil {
+		log.Warn("Was not able to capture message", "type", typ, "peer", peer, "error", err)
+	}
+}
+
+//admit returns false if the message with envelope env must be dropped before its sections are
+//decoded. This is the case if sender has been blacklisted after the connection was accepted.
+func (s *Server) admit(env *message.Envelope, sender net.Addr) bool {
+	if s.blacklist.Contains(sender) {
+		log.Info("Dropped message from blacklisted address", "addr", sender, "token", env.Token,
+			"sections", len(env.SectionTypes), "size", env.Size())
+		return false
+	}
+	return true
+}
+
+//logReadError logs why reading a message from the connection to dstAddr failed.
+func logReadError(err error, dstAddr net.Addr) {
+	if err == io.EOF || err.Error() == "failed to read tag: EOF" {
+		log.Info("Connection has been closed", "conn", dstAddr)
+	} else if _, ok := err.(*cbor.LimitError); ok {
+		log.Warn("Closing connection after too large message", "conn", dstAddr, "error", err)
+	} else if tagErr, ok := err.(*cbor.TagError); ok {
+		log.Warn("Closing connection after message with unknown CBOR tag", "conn", dstAddr,
+			"tag", tagErr.Tag, "error", err)
+	} else if decodeErr, ok := err.(*cbor.DecodeError); ok {
+		log.Warn("Closing connection after malformed message", "conn", dstAddr,
+			"item", decodeErr.Item, "key", decodeErr.Key, "error", err)
+	} else {
+		log.Warn(fmt.Sprintf("failed to read from client: %v", err))
+	}
 }
@@ -0,0 +1,211 @@
+package rainsd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//maxTracedTokens is the number of traced queries whose path a server remembers. If it is
+//exceeded, the path of the query which has been traced first is forgotten.
+const maxTracedTokens = 1000
+
+//Events on the path of a traced query through a server.
+const (
+	traceReceived  = "received"
+	traceCached    = "cached"
+	tracePending   = "pending"
+	traceForwarded = "forwarded"
+	traceUpstream  = "upstream"
+	traceAnswered  = "answered"
+	traceNotified  = "notified"
+)
+
+//AdminTraceHop is an event on the path of a traced query through the server, e.g. the query sent
+//to an upstream server. The trace command returns the hops of a token in the order they happened.
+type AdminTraceHop struct {
+	Time  time.Time
+	Event string
+	//Peer is the address of the client or of the upstream server.
+	Peer string `json:",omitempty"`
+	//Token is the token of the query sent to the upstream server Peer.
+	Token string `json:",omitempty"`
+	//RTT is the time the upstream server Peer took to answer.
+	RTT    time.Duration `json:",omitempty"`
+	Detail string        `json:",omitempty"`
+}
+
+//describe returns a single line description of h relative to the time start of the first hop.
+func (h AdminTraceHop) describe(start time.Time) string {
+	desc := fmt.Sprintf("+%v %s", h.Time.Sub(start).Round(time.Microsecond), h.Event)
+	if h.Peer != "" {
+		desc += " " + h.Peer
+	}
+	if h.Token != "" {
+		desc += " token=" + h.Token
+	}
+	if h.RTT != 0 {
+		desc += " rtt=" + h.RTT.Round(time.Microsecond).String()
+	}
+	if h.Detail != "" {
+		desc += ": " + h.Detail
+	}
+	return desc
+}
+
+//tokenTracer records the path of the queries with the TokenTracing option through a server by
+//their token: when they are received, answered from the cache or forwarded, each query the
+//resolver sends to an upstream server with its token, round trip time and answer, and the sent
+//answer. Upstream servers receive the queries with the TokenTracing option as well, such that
+//their trace reports become part of the path. It is safe for concurrent use.
+type tokenTracer struct {
+	mux    sync.Mutex
+	traces map[token.Token][]AdminTraceHop
+	//order contains the traced tokens in the order they have been started.
+	order []token.Token
+}
+
+//newTokenTracer returns a tokenTracer without any traced tokens.
+func newTokenTracer() *tokenTracer {
+	return &tokenTracer{traces: make(map[token.Token][]AdminTraceHop)}
+}
+
+//isTraced returns true if one of the queries of sections has the TokenTracing option.
+func isTraced(sections []section.Section) bool {
+	for _, sec := range sections {
+		if q, ok := sec.(*query.Name); ok && q.ContainsOption(query.QOTokenTracing) {
+			return true
+		}
+	}
+	return false
+}
+
+//start traces the queries with token tok unless it is already traced.
+func (t *tokenTracer) start(tok token.Token) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if _, ok := t.traces[tok]; ok {
+		return
+	}
+	if len(t.order) >= maxTracedTokens {
+		delete(t.traces, t.order[0])
+		t.order = t.order[1:]
+	}
+	t.traces[tok] = []AdminTraceHop{}
+	t.order = append(t.order, tok)
+}
+
+//traced returns true if the queries with token tok are traced.
+func (t *tokenTracer) traced(tok token.Token) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	_, ok := t.traces[tok]
+	return ok
+}
+
+//record adds hop to the path of the queries with token tok if they are traced.
+func (t *tokenTracer) record(tok token.Token, hop AdminTraceHop) {
+	hop.Time = time.Now()
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if hops, ok := t.traces[tok]; ok {
+		t.traces[tok] = append(hops, hop)
+	}
+}
+
+//recordEvent adds event with peer and detail to the path of the queries with token tok if they
+//are traced.
+func (t *tokenTracer) recordEvent(tok token.Token, event string, peer net.Addr, detail string) {
+	hop := AdminTraceHop{Event: event, Detail: detail}
+	if peer != nil {
+		hop.Peer = peer.String()
+	}
+	t.record(tok, hop)
+}
+
+//recordStep adds the query the resolver sent to an upstream server during the lookup of the
+//forwarded queries with token tok to their path. The trace report contained in the upstream
+//server's answer is added as detail.
+func (t *tokenTracer) recordStep(tok token.Token, step libresolve.TraceStep) {
+	hop := AdminTraceHop{Event: traceUpstream, Token: step.Token.String(), RTT: step.RTT}
+	if step.Server != nil {
+		hop.Peer = step.Server.String()
+	}
+	details := []string{}
+	if step.Err != nil {
+		details = append(details, "error: "+step.Err.Error())
+	} else {
+		details = append(details, fmt.Sprintf("%d sections", len(step.Answer.Content)))
+	}
+	if step.Redirect != "" {
+		details = append(details, "redirected to "+step.Redirect)
+	}
+	for _, sec := range step.Answer.Content {
+		if n, ok := sec.(*section.Notification); ok && n.Type == section.NTTokenTrace {
+			details = append(details, "["+n.Data+"]")
+		}
+	}
+	hop.Detail = strings.Join(details, ", ")
+	t.record(tok, hop)
+}
+
+//hops returns the path of the queries with token tok or false if they are not traced.
+func (t *tokenTracer) hops(tok token.Token) ([]AdminTraceHop, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	hops, ok := t.traces[tok]
+	return append([]AdminTraceHop{}, hops...), ok
+}
+
+//report returns a NTTokenTrace notification with token tok describing the path of the queries
+//with token tok through the server at addr or false if they are not traced.
+func (t *tokenTracer) report(tok token.Token, addr net.Addr) (*section.Notification, bool) {
+	hops, ok := t.hops(tok)
+	if !ok {
+		return nil, false
+	}
+	descs := []string{}
+	for _, hop := range hops {
+		descs = append(descs, hop.describe(hops[0].Time))
+	}
+	return &section.Notification{
+		Type:  section.NTTokenTrace,
+		Token: tok,
+		Data:  fmt.Sprintf("trace of %s by %v: %s", tok, addr, strings.Join(descs, "; ")),
+	}, true
+}
+
+//queriesDetail returns a description of the queries of sections for the path of a traced query.
+func queriesDetail(sections []section.Section) string {
+	descs := []string{}
+	for _, sec := range sections {
+		if q, ok := sec.(*query.Name); ok {
+			types := []string{}
+			for _, t := range q.Types {
+				types = append(types, t.Name())
+			}
+			descs = append(descs, fmt.Sprintf("%s %s [%s]", q.Context, q.Name,
+				strings.Join(types, " ")))
+		}
+	}
+	return strings.Join(descs, ", ")
+}
+
+//parseToken returns the token encoded as hex string in s.
+func parseToken(s string) (token.Token, error) {
+	tok := token.Token{}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(tok) {
+		return tok, fmt.Errorf("invalid token %q, expected %d hex encoded bytes", s, len(tok))
+	}
+	copy(tok[:], b)
+	return tok, nil
+}
@@ -0,0 +1,136 @@
+package rainsd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//configureUpstream sets the time resolver gives each root server, forwarder or authoritative
+//server to answer and how often and after which backoff it asks the ones which did not answer
+//again, according to config.
+func configureUpstream(resolver *libresolve.Resolver, config rainsdConfig) {
+	if config.UpstreamTimeout > 0 {
+		resolver.HopTimeout = config.UpstreamTimeout
+	}
+	resolver.Retries = config.UpstreamRetries
+	if config.UpstreamRetryBackoff > 0 {
+		resolver.RetryBackoff = config.UpstreamRetryBackoff
+	}
+}
+
+//lookupFailure returns the notification sent to the clients whose queries could not be answered
+//because the lookup of the forwarded query failed. Either the queries expired before any upstream
+//server answered or all upstream servers failed.
+func lookupFailure(expired bool) *section.Notification {
+	if expired {
+		return &section.Notification{
+			Type: section.NTNoAssertionAvail,
+			Data: "the query expired before an upstream server answered",
+		}
+	}
+	return &section.Notification{
+		Type: section.NTServerNotCapable,
+		Data: "no upstream server could answer the query",
+	}
+}
+
+//upstreamFailed answers the queries waiting for the forwarded query with token tok. It is called
+//when the recursive resolver failed to answer the forwarded query after trying all upstream
+//servers or did not answer it before it expired. The queries are answered with stale assertions
+//if serving stale is enabled and otherwise with the notification failure, as no answer is going
+//to arrive for them.
+func (s *Server) upstreamFailed(tok token.Token, failure *section.Notification) {
+	for _, ss := range s.takePending(tok) {
+		if s.answerStale(ss) {
+			continue
+		}
+		log.Info("Upstream lookup failed, notifying client", "token", ss.Token, "queries",
+			ss.Sections, "notification", failure.Type)
+		sendNotificationMsg(ss.Token, ss.Sender, failure.Type, failure.Data, s)
+	}
+}
+
+//expireForwarded calls upstreamFailed for the forwarded query with token tok if it is still pending
+//when it expires at validUntil. The timer is stopped when the answer arrives or the server shuts
+//down.
+func (s *Server) expireForwarded(tok token.Token, validUntil int64) {
+	s.forwarded.start(tok, validUntil, func() {
+		s.upstreamFailed(tok, lookupFailure(true))
+	})
+}
+
+//takePending returns and removes the queries waiting for the forwarded query with token tok and
+//stops its expiration timer.
+func (s *Server) takePending(tok token.Token) []util.MsgSectionSender {
+	s.forwarded.stop(tok)
+	return s.caches.PendingQueries.GetAndRemove(tok)
+}
+
+//forwardTimers contains the expiration timers of the forwarded queries by their token.
+type forwardTimers struct {
+	mux    sync.Mutex
+	timers map[token.Token]*time.Timer
+}
+
+//newForwardTimers returns an empty forwardTimers.
+func newForwardTimers() *forwardTimers {
+	return &forwardTimers{timers: make(map[token.Token]*time.Timer)}
+}
+
+//start calls expired once validUntil has passed unless tok's timer is stopped before. Timers run
+//on the system clock, so a timer firing before validUntil according to the clock in use is
+//started again for the remaining time.
+func (f *forwardTimers) start(tok token.Token, validUntil int64, expired func()) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.timers == nil {
+		return
+	}
+	if timer, ok := f.timers[tok]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Duration(validUntil-clock.Now().Unix())*time.Second, func() {
+		f.mux.Lock()
+		if f.timers[tok] != timer {
+			f.mux.Unlock()
+			return
+		}
+		delete(f.timers, tok)
+		f.mux.Unlock()
+		if clock.Now().Unix() < validUntil {
+			f.start(tok, validUntil, expired)
+			return
+		}
+		expired()
+	})
+	f.timers[tok] = timer
+}
+
+//stop stops the timer of tok if it has not fired yet.
+func (f *forwardTimers) stop(tok token.Token) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if timer, ok := f.timers[tok]; ok {
+		timer.Stop()
+		delete(f.timers, tok)
+	}
+}
+
+//stopAll stops all timers. No timer is started afterwards.
+func (f *forwardTimers) stopAll() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	for _, timer := range f.timers {
+		timer.Stop()
+	}
+	f.timers = nil
+}
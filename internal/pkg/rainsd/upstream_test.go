@@ -0,0 +1,38 @@
+package rainsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+func TestForwardTimers(t *testing.T) {
+	now := time.Now()
+	defer clock.Set(clock.NewFake(now))()
+	f := newForwardTimers()
+	fired := make(chan token.Token, 3)
+	expired, stopped, pending := token.New(), token.New(), token.New()
+	for _, tok := range []token.Token{expired, stopped} {
+		tok := tok
+		f.start(tok, now.Unix(), func() { fired <- tok })
+	}
+	f.stop(stopped)
+	f.start(pending, now.Unix()+3600, func() { fired <- pending })
+	select {
+	case tok := <-fired:
+		if tok != expired {
+			t.Errorf("timer of %v fired instead of %v", tok, expired)
+		}
+	case <-time.After(time.Second):
+		t.Error("timer of the expired query did not fire")
+	}
+	f.stopAll()
+	f.start(token.New(), now.Unix(), func() { fired <- token.New() })
+	select {
+	case tok := <-fired:
+		t.Errorf("timer of %v fired after it was stopped", tok)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/netsec-ethz/rains/internal/pkg/cache"
 
 	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
@@ -23,7 +24,7 @@ import (
 //verify verifies msgSender. It checks the consistency of the msgSender.Section and if it is
 //inconsistent a notification msg is sent. (Consistency with cached elements is checked later in the
 //engine) It validates all signatures (including contained once), stripping of expired once. If no
-//signature remains on an assertion, shard, zone, addressAssertion or addressZone it gets dropped
+//signature remains on an assertion, shard, pshard or zone it gets dropped
 //(signatures of contained sections are not taken into account). If there happens an error in the
 //signature verification process of any signature, the whole msgSender gets dropped (signatures of
 //contained sections are also considered)
@@ -33,7 +34,7 @@ func (s *Server) verify(msgSender util.MsgSectionSender) {
 	switch msgSender.Sections[0].(type) {
 	case *section.Assertion, *section.Shard, *section.Pshard, *section.Zone:
 		isAuthoritative := hasAuthority(msgSender, s)
-		if len(s.config.ZoneAuthority) != 0 {
+		if s.config.Mode == ModeAuthoritative {
 			//An authoritative server drops all messages containing sections over which it has no
 			//authority and are not a response to a query issued by this server
 			if !isAuthoritative && !s.caches.PendingKeys.ContainsToken(msgSender.Token) {
@@ -131,8 +132,8 @@ func contextInvalid(context string) bool {
 
 //isQueryExpired returns true if the query has expired
 func isQueryExpired(expires int64) bool {
-	if expires < time.Now().Unix() {
-		log.Warn("Query expired", "expirationTime", expires, "now", time.Now().Unix())
+	if expires < clock.Now().Unix() {
+		log.Warn("Query expired", "expirationTime", expires, "now", clock.Now().Unix())
 		return true
 	}
 	log.Debug("Query is not expired")
@@ -168,7 +169,7 @@ func verifySignatures(ss util.MsgSectionSender, keys map[keys.PublicKeyID][]keys
 		sec := sec.(section.WithSigForward)
 		sections = append(sections, sec)
 		sec.DontAddSigInMarshaller()
-		if !validSignature(sec, keys, s.config.MaxCacheValidity) {
+		if !validSignature(sec, keys, s.maxCacheValidity()) {
 			return nil, false
 		}
 		sec.AddSigInMarshaller()
@@ -268,7 +269,7 @@ func getQueryValidity(sigs []signature.Sig, delegQValidity time.Duration) (valid
 		}
 	}
 	//upper bound the validity time
-	upperBound := time.Now().Add(delegQValidity).Unix()
+	upperBound := clock.Now().Add(delegQValidity).Unix()
 	if validity > upperBound {
 		validity = upperBound
 	}
@@ -0,0 +1,39 @@
+package rainsd
+
+import (
+	"strings"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+)
+
+//zoneSplits returns the splits of the fully qualified name into a zone and a subject name under
+//which shards and zones answering a query for name may be cached, in the order in which they are
+//looked up. Subject names may contain dots, such that name is not necessarily split at its first
+//dot. The zones are the suffixes of name from its most specific known zone, see isKnownZone, down
+//to the zone one label above name. The most specific known zone comes first, followed by the more
+//specific zones which are not known, the zone one label above name last. Less specific zones than
+//the most specific known one are omitted as they delegated the names below it.
+func zoneSplits(name, context string, s *Server) []zoneAndName {
+	var unknown []zoneAndName
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for i := 1; ; i++ {
+		split := zoneAndName{zone: strings.Join(labels[i:], ".") + ".",
+			name: strings.Join(labels[:i], ".")}
+		if split.zone == "." || isKnownZone(split.zone, context, s) {
+			return append([]zoneAndName{split}, unknown...)
+		}
+		unknown = append([]zoneAndName{split}, unknown...)
+	}
+}
+
+//isKnownZone returns true if s has authority over zone in context or caches the delegation of
+//zone, i.e. zone is a known delegation point.
+func isKnownZone(zone, context string, s *Server) bool {
+	for i, auth := range s.config.ZoneAuthority {
+		if auth == zone && s.config.ContextAuthority[i] == context {
+			return true
+		}
+	}
+	_, ok := s.caches.AssertionsCache.Get(zone, context, object.OTDelegation, true)
+	return ok
+}
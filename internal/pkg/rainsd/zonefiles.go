@@ -0,0 +1,198 @@
+package rainsd
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/publisher"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+//defaultZoneSigValidity is the validity of the signatures created for a zone file whose SigValidity
+//is not set.
+const defaultZoneSigValidity = 24 * time.Hour
+
+//sigValidity returns the validity of the signatures created for the zone file.
+func (zf zoneFileConfig) sigValidity() time.Duration {
+	if zf.SigValidity <= 0 {
+		return defaultZoneSigValidity
+	}
+	return zf.SigValidity
+}
+
+//loadZoneFiles loads the AuthoritativeZoneFiles of the config and adds their zones to the zones
+//over which s has authority. Zone files with a private key are signed and their content is added
+//to the caches as authoritative, such that it is never evicted. The content of the other zone
+//files must already be signed. It is verified like pushed sections once s is listening.
+func (s *Server) loadZoneFiles() error {
+	for _, zf := range s.config.AuthoritativeZoneFiles {
+		if zf.PrivateKeyPath != "" {
+			if err := s.signZoneFile(zf); err != nil {
+				return err
+			}
+			continue
+		}
+		sections, err := loadSignedZoneFile(zf.Path)
+		if err != nil {
+			return err
+		}
+		s.addAuthority(sections[0].(section.WithSigForward))
+		s.unverifiedZones = append(s.unverifiedZones, util.MsgSectionSender{
+			Sender:   s.config.ServerAddress.Addr,
+			Token:    token.New(),
+			Sections: sections,
+		})
+	}
+	return nil
+}
+
+//signZoneFile signs the content of zf with its private key and adds it to the caches as
+//authoritative.
+func (s *Server) signZoneFile(zf zoneFileConfig) error {
+	now := clock.Now()
+	config := publisher.Config{
+		ZonefilePath:   zf.Path,
+		PrivateKeyPath: zf.PrivateKeyPath,
+		MetaDataConf: publisher.MetaDataConfig{
+			AddSignatureMetaData:       true,
+			AddSigMetaDataToAssertions: true,
+			AddSigMetaDataToShards:     true,
+			AddSigMetaDataToPshards:    true,
+			SignatureAlgorithm:         algorithmTypes.Ed25519,
+			KeyPhase:                   zf.KeyPhase,
+			SigValidSince:              now.Unix(),
+			SigValidUntil:              now.Add(zf.sigValidity()).Unix(),
+		},
+		ConsistencyConf: publisher.ConsistencyConfig{SortShards: true, SortZone: true},
+		DoSigning:       true,
+	}
+	output, err := publisher.New(config).Sign(context.Background())
+	if err != nil {
+		return fmt.Errorf("could not sign zone file %s: %v", zf.Path, err)
+	}
+	sections := make([]section.WithSigForward, len(output))
+	maxVal := s.maxCacheValidity()
+	for i, sec := range output {
+		sections[i] = sec.(section.WithSigForward)
+		setSignatureValidity(sections[i], maxVal)
+	}
+	s.addAuthority(sections[0])
+	addSectionsToCache(sections, s.config.ZoneAuthority, s.config.ContextAuthority,
+		s.caches.AssertionsCache, s.caches.NegAssertionCache, s.caches.ZoneKeyCache)
+	log.Info("Zone file signed and loaded", "path", zf.Path, "zone", sections[0].GetSubjectZone(),
+		"context", sections[0].GetContext(), "validUntil", config.MetaDataConf.SigValidUntil)
+	return nil
+}
+
+//setSignatureValidity sets the validity of sec and its content to the validity of their
+//signatures bounded by maxVal. It is used for sections the server signed itself, which are cached
+//without being verified.
+func setSignatureValidity(sec section.WithSig, maxVal util.MaxCacheValidity) {
+	for _, sig := range sec.AllSigs() {
+		util.UpdateSectionValidity(sec, 0, math.MaxInt64, sig.ValidSince, sig.ValidUntil, maxVal)
+	}
+	switch sec := sec.(type) {
+	case *section.Zone:
+		for _, a := range sec.Content {
+			setSignatureValidity(a, maxVal)
+		}
+	case *section.Shard:
+		for _, a := range sec.Content {
+			setSignatureValidity(a, maxVal)
+		}
+	}
+}
+
+//loadSignedZoneFile returns the zone, shards and pshards of the zone file at path. It returns an
+//error if the zone file does not contain exactly one zone or a section is not signed or
+//inconsistent, as the server would otherwise drop the sections when verifying them.
+func loadSignedZoneFile(path string) ([]section.Section, error) {
+	content, err := zonefile.IO{}.LoadZonefile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not load zone file %s: %v", path, err)
+	}
+	var sections []section.Section
+	for _, sec := range content {
+		if len(sec.AllSigs()) == 0 {
+			return nil, fmt.Errorf("zone file %s contains an unsigned section: %v", path, sec)
+		}
+		if !sec.IsConsistent() || contextInvalid(sec.GetContext()) {
+			return nil, fmt.Errorf("zone file %s contains an inconsistent section: %v", path, sec)
+		}
+		if _, ok := sec.(*section.Zone); ok {
+			sections = append([]section.Section{sec}, sections...)
+		} else {
+			sections = append(sections, sec)
+		}
+	}
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("zone file %s is empty", path)
+	}
+	zone, ok := sections[0].(*section.Zone)
+	if !ok {
+		return nil, fmt.Errorf("zone file %s contains no zone", path)
+	}
+	for _, sec := range sections[1:] {
+		sec := sec.(section.WithSigForward)
+		if _, ok := sec.(*section.Zone); ok || sec.GetSubjectZone() != zone.SubjectZone ||
+			sec.GetContext() != zone.Context {
+			return nil, fmt.Errorf("zone file %s contains sections of more than one zone", path)
+		}
+	}
+	return sections, nil
+}
+
+//addAuthority adds the zone and context of sec to the ones over which s has authority.
+func (s *Server) addAuthority(sec section.WithSigForward) {
+	zc := zoneContext{Zone: sec.GetSubjectZone(), Context: sec.GetContext()}
+	if s.authority[zc] {
+		return
+	}
+	s.authority[zc] = true
+	s.config.ZoneAuthority = append(s.config.ZoneAuthority, zc.Zone)
+	s.config.ContextAuthority = append(s.config.ContextAuthority, zc.Context)
+}
+
+//verifyZoneFiles verifies the content of the zone files which were signed before they were
+//loaded as soon as s is listening, such that missing delegations can be obtained, and adds it to
+//the caches.
+func (s *Server) verifyZoneFiles() {
+	<-s.listening
+	if s.listenErr != nil {
+		return
+	}
+	for _, ss := range s.unverifiedZones {
+		s.verify(ss)
+	}
+}
+
+//resignZoneFiles signs the zone files with a private key again after half of the validity of their
+//signatures such that the served signatures never expire.
+func (s *Server) resignZoneFiles() {
+	for _, zf := range s.config.AuthoritativeZoneFiles {
+		if zf.PrivateKeyPath == "" {
+			continue
+		}
+		go func(zf zoneFileConfig) {
+			for {
+				select {
+				case <-s.shutdown:
+					return
+				case <-time.After(zf.sigValidity() / 2):
+				}
+				if err := s.signZoneFile(zf); err != nil {
+					log.Error("Could not sign zone file again", "path", zf.Path, "error", err)
+				}
+			}
+		}(zf)
+	}
+}
@@ -1,13 +1,14 @@
 package section
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"time"
 
 	cbor "github.com/britram/borat"
 
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
 	"github.com/netsec-ethz/rains/internal/pkg/signature"
@@ -25,45 +26,49 @@ type Assertion struct {
 	sign        bool  //set to true before signing and false afterwards
 }
 
-// UnmarshalMap provides functionality to unmarshal a map read in by CBOR.
+// UnmarshalMap provides functionality to unmarshal a map read in by CBOR. It returns a
+// *cbor.DecodeError identifying the offending key if m is malformed.
 func (a *Assertion) UnmarshalMap(m map[int]interface{}) error {
-	if sigs, ok := m[0].([]interface{}); ok {
-		a.Signatures = make([]signature.Sig, len(sigs))
-		for i, sig := range sigs {
-			sigVal, ok := sig.([]interface{})
-			if !ok {
-				return errors.New("cbor zone signatures entry is not an array")
-			}
-			if err := a.Signatures[i].UnmarshalArray(sigVal); err != nil {
-				return err
-			}
-		}
-	} else {
-		return errors.New("cbor zone map does not contain a signature")
+	if err := rcbor.CheckKeys("assertion", m, 0, 3, 4, 6, 7); err != nil {
+		return err
+	}
+	sigs, err := unmarshalSignatures("assertion", m)
+	if err != nil {
+		return err
+	}
+	name, err := unmarshalString("assertion", m, 3, true)
+	if err != nil {
+		return err
+	}
+	//subject zone and context are omitted in a contained assertion
+	zone, err := unmarshalString("assertion", m, 4, false)
+	if err != nil {
+		return err
+	}
+	ctx, err := unmarshalString("assertion", m, 6, false)
+	if err != nil {
+		return err
 	}
-	if sn, ok := m[3].(string); ok {
-		a.SubjectName = sn
-	} else {
-		return errors.New("cbor assertion map does not contain a subject name")
+	val, ok := m[7]
+	if !ok {
+		return rcbor.MissingKey("assertion", 7)
 	}
-	if sz, ok := m[4].(string); ok {
-		a.SubjectZone = sz
-	} //subject zone is omitted in a contained assertion
-
-	if ctx, ok := m[6].(string); ok {
-		a.Context = ctx
-	} //context is omitted in a contained assertion
-
-	if objs, ok := m[7]; ok {
-		a.Content = make([]object.Object, len(objs.([]interface{})))
-		for i, obj := range objs.([]interface{}) {
-			if err := a.Content[i].UnmarshalArray(obj.([]interface{})); err != nil {
-				return err
-			}
+	objs, ok := val.([]interface{})
+	if !ok {
+		return rcbor.WrongType("assertion", 7, "array")
+	}
+	content := make([]object.Object, len(objs))
+	for i, obj := range objs {
+		o, ok := obj.([]interface{})
+		if !ok {
+			return rcbor.WrongType("assertion", 7, "array of object arrays")
+		}
+		if err := content[i].UnmarshalArray(o); err != nil {
+			return err
 		}
-	} else {
-		return errors.New("cbor assertion map does not contain an object array")
 	}
+	a.Signatures, a.SubjectName, a.SubjectZone, a.Context = sigs, name, zone, ctx
+	a.Content = content
 	return nil
 }
 
@@ -86,6 +91,18 @@ func (a *Assertion) MarshalCBOR(w *cbor.CBORWriter) error {
 	return w.WriteIntMap(m)
 }
 
+//MarshalJSON implements the json.Marshaler interface.
+func (a *Assertion) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type        string          `json:"type"`
+		SubjectName string          `json:"subjectName"`
+		SubjectZone string          `json:"subjectZone,omitempty"`
+		Context     string          `json:"context,omitempty"`
+		Objects     []object.Object `json:"objects"`
+		Signatures  []signature.Sig `json:"signatures,omitempty"`
+	}{"assertion", a.SubjectName, a.SubjectZone, a.Context, a.Content, a.Signatures})
+}
+
 //AllSigs returns all assertion's signatures
 func (a *Assertion) AllSigs() []signature.Sig {
 	return a.Signatures
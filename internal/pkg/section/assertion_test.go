@@ -1,6 +1,7 @@
 package section
 
 import (
+	"encoding/json"
 	"math/rand"
 	"reflect"
 	"sort"
@@ -304,3 +305,29 @@ func shuffleSections(sections []Section) {
 		sections[i], sections[j] = sections[j], sections[i]
 	}
 }
+
+func TestAssertionMarshalJSON(t *testing.T) {
+	var tests = []struct {
+		input *Assertion
+		want  string
+	}{
+		{&Assertion{SubjectName: "name", Content: object.AllObjects()[1:3]},
+			`{"type":"assertion","subjectName":"name","objects":[{"type":"ip6","value":"2001:db8::"},` +
+				`{"type":"ip4","value":"192.0.2.0"}]}`},
+		{&Assertion{SubjectName: "name", SubjectZone: "zone", Context: "ctx", Content: object.AllObjects()[:1],
+			Signatures: []signature.Sig{signature.Sig{PublicKeyID: keys.PublicKeyID{KeySpace: keys.RainsKeySpace, Algorithm: algorithmTypes.Ed25519}, ValidSince: 1000, ValidUntil: 2000, Data: []byte("SigData")}}},
+			`{"type":"assertion","subjectName":"name","subjectZone":"zone","context":"ctx","objects":` +
+				`[{"type":"name","value":{"name":"example.com","types":["ip4","ip6"]}}],"signatures":` +
+				`[{"algorithm":"Ed25519","keySpace":"RainsKeySpace","keyPhase":0,"validSince":1000,` +
+				`"validUntil":2000,"data":"53696744617461"}]}`},
+	}
+	for i, test := range tests {
+		encoding, err := json.Marshal(test.input)
+		if err != nil {
+			t.Errorf("%d: Was not able to encode assertion: %v", i, err)
+		}
+		if string(encoding) != test.want {
+			t.Errorf("%d: Wrong json encoding. expected=%s, actual=%s", i, test.want, encoding)
+		}
+	}
+}
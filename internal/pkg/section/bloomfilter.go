@@ -4,11 +4,11 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"hash/fnv"
 
 	cbor "github.com/britram/borat"
 	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/bitarray"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
 	"golang.org/x/crypto/sha3"
@@ -21,24 +21,32 @@ type BloomFilter struct {
 	Filter    bitarray.BitArray
 }
 
-// UnmarshalArray takes in a CBOR decoded array and populates the object.
+// UnmarshalArray takes in a CBOR decoded array and populates the object. It returns a
+// *cbor.DecodeError identifying the offending element if in is malformed.
 func (b *BloomFilter) UnmarshalArray(in []interface{}) error {
 	if len(in) != 3 {
-		return fmt.Errorf("cbor array encoding of bloom filter is not of length 3. actual=%d", len(in))
+		return rcbor.WrongLength("bloom filter", -1, 3, len(in))
 	}
 	algo, ok := in[0].(int)
 	if !ok {
-		return errors.New("cbor encoding of the algorithm should be an int")
+		return rcbor.WrongType("bloom filter", 0, "int")
+	}
+	if BloomFilterAlgo(algo).NumberOfHashes() == -1 {
+		return rcbor.OutOfRange("bloom filter", 0, algo)
 	}
 	b.Algorithm = BloomFilterAlgo(algo)
 	hash, ok := in[1].(int)
 	if !ok {
-		return errors.New("cbor encoding of the hash should be an int")
+		return rcbor.WrongType("bloom filter", 1, "int")
+	}
+	if algorithmTypes.Hash(hash) < algorithmTypes.NoHashAlgo ||
+		algorithmTypes.Hash(hash) > algorithmTypes.Fnv128 {
+		return rcbor.OutOfRange("bloom filter", 1, hash)
 	}
 	b.Hash = algorithmTypes.Hash(hash)
 	filter, ok := in[2].([]byte)
 	if !ok {
-		return errors.New("cbor encoding of the filter should be an byte array")
+		return rcbor.WrongType("bloom filter", 2, "byte string")
 	}
 	b.Filter = bitarray.BitArray(filter)
 	return nil
@@ -52,6 +60,9 @@ func (b BloomFilter) MarshalCBOR(w *cbor.CBORWriter) error {
 //Contains returns true if a might be part of the set represented by the bloom filter. It
 //returns false if a is certainly not part of the set.
 func (b BloomFilter) Contains(name, zone, context string, t object.Type) (bool, error) {
+	if len(b.Filter) == 0 {
+		return false, errors.New("bloom filter is empty")
+	}
 	hash1, hash2, err := calcHash(b.Hash, encoding(name, zone, context, t))
 	if err != nil {
 		return false, err
@@ -69,6 +80,9 @@ func (b BloomFilter) Contains(name, zone, context string, t object.Type) (bool,
 //Add sets the corresponding bits to 1 in the bloom filter based on BloomFilterAlgo and
 //the hash function defined in b.
 func (b BloomFilter) Add(name, zone, context string, t object.Type) error {
+	if len(b.Filter) == 0 {
+		return errors.New("bloom filter is empty")
+	}
 	hash1, hash2, err := calcHash(b.Hash, encoding(name, zone, context, t))
 	if err != nil {
 		return err
@@ -9,7 +9,7 @@ import (
 	"github.com/netsec-ethz/rains/internal/pkg/signature"
 )
 
-//Section can be either an Assertion, Shard, Zone, Query, Notification, AddressAssertion, AddressZone, AddressQuery section
+//Section can be either an Assertion, Shard, Pshard, Zone, Query or Notification section
 type Section interface {
 	Sort()
 	String() string
@@ -18,7 +18,7 @@ type Section interface {
 }
 
 //WithSig is an interface for a section protected by a signature. In the current
-//implementation it can be an Assertion, Shard, Zone, AddressAssertion, AddressZone
+//implementation it can be an Assertion, Shard, Pshard or Zone
 type WithSig interface {
 	Section
 	AllSigs() []signature.Sig
@@ -38,7 +38,7 @@ type WithSig interface {
 	NeededKeys(map[signature.MetaData]bool)
 }
 
-//WithSigForward can be either an Assertion, Shard or Zone
+//WithSigForward can be either an Assertion, Shard, Pshard or Zone
 type WithSigForward interface {
 	WithSig
 	Interval
@@ -46,8 +46,8 @@ type WithSigForward interface {
 	DontAddSigInMarshaller()
 }
 
-//Query is the interface for a query section. In the current implementation it can be
-//a query or an addressQuery
+//Query is the interface for a query section. In the current implementation it can only be
+//a name query, as there are no address queries
 type Query interface {
 	GetContext() string
 	GetExpiration() int64
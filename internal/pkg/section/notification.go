@@ -2,11 +2,12 @@ package section
 
 import (
 	"encoding/hex"
-	"errors"
+	"encoding/json"
 	"fmt"
 
 	cbor "github.com/britram/borat"
 
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/signature"
 	"github.com/netsec-ethz/rains/internal/pkg/token"
@@ -19,25 +20,40 @@ type Notification struct {
 	Data  string
 }
 
-// UnmarshalMap unpacks a CBOR unmarshaled map to this object.
+// UnmarshalMap unpacks a CBOR unmarshaled map to this object. It returns a *cbor.DecodeError
+// identifying the offending key if m is malformed.
 func (n *Notification) UnmarshalMap(m map[int]interface{}) error {
-	tok, ok := m[2].([]byte)
-	if !ok || len(tok) != 16 {
-		return errors.New("cbor notification encoding of the token should be a byte array of length 16")
+	if err := rcbor.CheckKeys("notification", m, 2, 21, 22); err != nil {
+		return err
 	}
-	for i, val := range tok {
-		n.Token[i] = val
+	val, ok := m[2]
+	if !ok {
+		return rcbor.MissingKey("notification", 2)
 	}
-	if not, ok := m[21].(int); ok {
-		n.Type = NotificationType(not)
-	} else {
-		return errors.New("cbor notification map does not contain type")
+	tok, ok := val.([]byte)
+	if !ok {
+		return rcbor.WrongType("notification", 2, "byte string")
 	}
-	if data, ok := m[22].(string); ok {
-		n.Data = string(data)
-	} else {
-		return errors.New("cbor notification map does not contain data")
+	if len(tok) != 16 {
+		return rcbor.WrongLength("notification", 2, 16, len(tok))
 	}
+	if val, ok = m[21]; !ok {
+		return rcbor.MissingKey("notification", 21)
+	}
+	not, ok := val.(int)
+	if !ok {
+		return rcbor.WrongType("notification", 21, "int")
+	}
+	if !NotificationType(not).valid() {
+		return rcbor.OutOfRange("notification", 21, not)
+	}
+	data, err := unmarshalString("notification", m, 22, true)
+	if err != nil {
+		return err
+	}
+	copy(n.Token[:], tok)
+	n.Type = NotificationType(not)
+	n.Data = data
 	return nil
 }
 
@@ -50,6 +66,16 @@ func (n *Notification) MarshalCBOR(w *cbor.CBORWriter) error {
 	return w.WriteIntMap(m)
 }
 
+//MarshalJSON implements the json.Marshaler interface.
+func (n *Notification) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type             string           `json:"type"`
+		Token            string           `json:"token"`
+		NotificationType NotificationType `json:"notificationType"`
+		Data             string           `json:"data,omitempty"`
+	}{"notification", n.Token.String(), n.Type, n.Data})
+}
+
 //Sort sorts the content of the notification lexicographically.
 func (n *Notification) Sort() {
 	//notification is already sorted (it does not contain a list of elements).
@@ -97,13 +123,34 @@ type NotificationType int
 
 //go:generate -type=NotificationType
 const (
-	NTHeartbeat          NotificationType = 100
-	NTCapHashNotKnown    NotificationType = 399
-	NTBadMessage         NotificationType = 400
+	NTHeartbeat NotificationType = 100
+	//NTStaleAnswer follows the assertions of an answer which a server could not refresh and which
+	//expired since it cached them.
+	NTStaleAnswer NotificationType = 110
+	//NTTokenTrace follows the sections of an answer to a query with the TokenTracing option. Its
+	//data is the path of the query through the server, see rainsctl trace.
+	NTTokenTrace      NotificationType = 120
+	NTCapHashNotKnown NotificationType = 399
+	NTBadMessage      NotificationType = 400
+	//NTAccessDenied is sent instead of processing a message whose sender is not allowed to send it
+	//by the access control lists of a server.
+	NTAccessDenied       NotificationType = 401
 	NTRcvInconsistentMsg NotificationType = 403
 	NTNoAssertionsExist  NotificationType = 404
 	NTMsgTooLarge        NotificationType = 413
-	NTUnspecServerErr    NotificationType = 500
-	NTServerNotCapable   NotificationType = 501
-	NTNoAssertionAvail   NotificationType = 504
+	//NTRateLimited is sent instead of an answer by a server limiting the rate of its responses.
+	NTRateLimited      NotificationType = 429
+	NTUnspecServerErr  NotificationType = 500
+	NTServerNotCapable NotificationType = 501
+	NTNoAssertionAvail NotificationType = 504
 )
+
+//valid returns true if t is a known notification type.
+func (t NotificationType) valid() bool {
+	switch t {
+	case NTHeartbeat, NTStaleAnswer, NTTokenTrace, NTCapHashNotKnown, NTBadMessage, NTAccessDenied,
+		NTRcvInconsistentMsg, NTNoAssertionsExist, NTMsgTooLarge, NTRateLimited, NTUnspecServerErr, NTServerNotCapable, NTNoAssertionAvail:
+		return true
+	}
+	return false
+}
@@ -1,12 +1,15 @@
 package section
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	cbor "github.com/britram/borat"
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/signature"
@@ -25,53 +28,42 @@ type Pshard struct {
 	sign        bool  //set to true before signing and false afterwards
 }
 
-// UnmarshalMap decodes the output from the CBOR decoder into this struct.
+// UnmarshalMap decodes the output from the CBOR decoder into this struct. It returns a
+// *cbor.DecodeError identifying the offending key if m is malformed.
 func (s *Pshard) UnmarshalMap(m map[int]interface{}) error {
-	if sigs, ok := m[0].([]interface{}); ok {
-		s.Signatures = make([]signature.Sig, len(sigs))
-		for i, sig := range sigs {
-			sigVal, ok := sig.([]interface{})
-			if !ok {
-				return errors.New("cbor zone signatures entry is not an array")
-			}
-			if err := s.Signatures[i].UnmarshalArray(sigVal); err != nil {
-				return err
-			}
-		}
-	} else {
-		return errors.New("cbor zone map does not contain a signature")
+	if err := rcbor.CheckKeys("pshard", m, 0, 4, 6, 11, 23); err != nil {
+		return err
 	}
-	if zone, ok := m[4].(string); ok {
-		s.SubjectZone = zone
-	} else {
-		return errors.New("cbor pshard map does not contain a subject zone")
+	sigs, err := unmarshalSignatures("pshard", m)
+	if err != nil {
+		return err
 	}
-	if ctx, ok := m[6].(string); ok {
-		s.Context = ctx
-	} else {
-		return errors.New("cbor pshard map does not contain a context")
+	zone, err := unmarshalString("pshard", m, 4, true)
+	if err != nil {
+		return err
 	}
-	if srange, ok := m[11].([]interface{}); ok {
-		begin, ok := srange[0].(string)
-		if !ok {
-			return errors.New("cbor pshard encoding of rangeFrom should be a string")
-		}
-		s.RangeFrom = begin
-		end, ok := srange[1].(string)
-		if !ok {
-			return errors.New("cbor pshard encoding of rangeEnd should be a string")
-		}
-		s.RangeTo = end
-	} else {
-		return errors.New("cbor pshard map does not contain a range")
+	ctx, err := unmarshalString("pshard", m, 6, true)
+	if err != nil {
+		return err
 	}
-	if ds, ok := m[23].([]interface{}); ok {
-		if err := s.BloomFilter.UnmarshalArray(ds); err != nil {
-			return err
-		}
-	} else {
-		return errors.New("cbor pshard map does not contain a bloom filter")
+	begin, end, err := unmarshalRange("pshard", m)
+	if err != nil {
+		return err
+	}
+	val, ok := m[23]
+	if !ok {
+		return rcbor.MissingKey("pshard", 23)
 	}
+	ds, ok := val.([]interface{})
+	if !ok {
+		return rcbor.WrongType("pshard", 23, "array")
+	}
+	var filter BloomFilter
+	if err := filter.UnmarshalArray(ds); err != nil {
+		return err
+	}
+	s.Signatures, s.SubjectZone, s.Context = sigs, zone, ctx
+	s.RangeFrom, s.RangeTo, s.BloomFilter = begin, end, filter
 	return nil
 }
 
@@ -91,6 +83,28 @@ func (s *Pshard) MarshalCBOR(w *cbor.CBORWriter) error {
 	return w.WriteIntMap(m)
 }
 
+//MarshalJSON implements the json.Marshaler interface. The bloom filter is encoded in hexadecimal.
+func (s *Pshard) MarshalJSON() ([]byte, error) {
+	type bloomFilter struct {
+		Algorithm BloomFilterAlgo `json:"algorithm"`
+		Hash      string          `json:"hash"`
+		Filter    string          `json:"filter"`
+	}
+	return json.Marshal(struct {
+		Type        string          `json:"type"`
+		SubjectZone string          `json:"subjectZone"`
+		Context     string          `json:"context"`
+		RangeFrom   string          `json:"rangeFrom"`
+		RangeTo     string          `json:"rangeTo"`
+		BloomFilter bloomFilter     `json:"bloomFilter"`
+		Signatures  []signature.Sig `json:"signatures,omitempty"`
+	}{"pshard", s.SubjectZone, s.Context, s.RangeFrom, s.RangeTo, bloomFilter{
+		Algorithm: s.BloomFilter.Algorithm,
+		Hash:      s.BloomFilter.Hash.String(),
+		Filter:    hex.EncodeToString(s.BloomFilter.Filter),
+	}, s.Signatures})
+}
+
 //AllSigs returns the pshard's signatures
 func (s *Pshard) AllSigs() []signature.Sig {
 	return s.Signatures
@@ -227,10 +241,15 @@ func (s *Pshard) IsNonexistent(q *query.Name) (bool, error) {
 	if q.Context != s.Context {
 		return false, errors.New("query has different context")
 	}
-	if strings.HasSuffix(q.Name, s.SubjectZone) {
+	var name string
+	switch {
+	case s.SubjectZone == ".":
+		name = strings.TrimSuffix(q.Name, ".")
+	case strings.HasSuffix(q.Name, "."+s.SubjectZone):
+		name = strings.TrimSuffix(q.Name, "."+s.SubjectZone)
+	default:
 		return false, errors.New("query has different suffix")
 	}
-	name := strings.TrimSuffix(q.Name, s.SubjectZone)
 	if !s.InRange(name) {
 		return false, errors.New("query is not in pshard's range")
 	}
@@ -3,6 +3,11 @@ package section
 import (
 	"sort"
 	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/datastructures/bitarray"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
 )
 
 func TestPshardCopy(t *testing.T) {
@@ -80,6 +85,49 @@ func TestPshardInRange(t *testing.T) {
 	}
 }
 
+func TestPshardIsNonexistent(t *testing.T) {
+	newPshard := func(zone string, names ...string) *Pshard {
+		s := &Pshard{SubjectZone: zone, Context: ".", RangeFrom: "", RangeTo: "x",
+			BloomFilter: BloomFilter{BloomKM12, algorithmTypes.Fnv64, make(bitarray.BitArray, 256)}}
+		for _, name := range names {
+			if err := s.AddAssertion(&Assertion{SubjectName: name, SubjectZone: zone, Context: ".",
+				Content: []object.Object{{Type: object.OTIP4Addr, Value: "192.0.2.1"}}}); err != nil {
+				t.Fatalf("Was not able to add %s: %v", name, err)
+			}
+		}
+		return s
+	}
+	ip4, ip6 := object.OTIP4Addr, object.OTIP6Addr
+	var tests = []struct {
+		pshard  *Pshard
+		context string
+		name    string
+		types   []object.Type
+		want    bool
+		wantErr bool
+	}{
+		{newPshard("ethz.ch.", "www"), ".", "www.ethz.ch.", []object.Type{ip4}, false, false},
+		{newPshard("ethz.ch.", "www"), ".", "www.ethz.ch.", []object.Type{ip6}, true, false},
+		{newPshard("ethz.ch.", "www"), ".", "ftp.ethz.ch.", []object.Type{ip4}, true, false},
+		{newPshard("ethz.ch.", "www"), ".", "www.ethz.ch.", []object.Type{ip6, ip4}, false, false},
+		{newPshard(".", "ch"), ".", "ch.", []object.Type{ip4}, false, false},
+		{newPshard(".", "ch"), ".", "de.", []object.Type{ip4}, true, false},
+		{newPshard("ethz.ch.", "www"), ".", "www.xethz.ch.", []object.Type{ip4}, false, true},
+		{newPshard("ethz.ch.", "www"), ".", "www.ethz.ch.com.", []object.Type{ip4}, false, true},
+		{newPshard("ethz.ch.", "www"), ".", "zzz.ethz.ch.", []object.Type{ip4}, false, true},
+		{newPshard("ethz.ch.", "www"), "cx-ethz.ch.", "www.ethz.ch.", []object.Type{ip4}, false,
+			true},
+	}
+	for i, test := range tests {
+		q := &query.Name{Context: test.context, Name: test.name, Types: test.types}
+		got, err := test.pshard.IsNonexistent(q)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("%d: IsNonexistent(%s %v) = %t, %v. expected %t, error %t", i, test.name,
+				test.types, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
 func checkPshard(s1, s2 *Pshard, t *testing.T) {
 	if s1.Context != s2.Context {
 		t.Error("Pshard context mismatch")
@@ -1,7 +1,7 @@
 package section
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
@@ -10,6 +10,7 @@ import (
 	cbor "github.com/britram/borat"
 	log "github.com/inconshreveable/log15"
 
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/signature"
 )
@@ -27,66 +28,34 @@ type Shard struct {
 	sign        bool  //set to true before signing and false afterwards
 }
 
-// UnmarshalMap converts a CBOR decoded map to this Shard.
+// UnmarshalMap converts a CBOR decoded map to this Shard. It returns a *cbor.DecodeError
+// identifying the offending key if m is malformed.
 func (s *Shard) UnmarshalMap(m map[int]interface{}) error {
-	if sigs, ok := m[0].([]interface{}); ok {
-		s.Signatures = make([]signature.Sig, len(sigs))
-		for i, sig := range sigs {
-			sigVal, ok := sig.([]interface{})
-			if !ok {
-				return errors.New("cbor zone signatures entry is not an array")
-			}
-			if err := s.Signatures[i].UnmarshalArray(sigVal); err != nil {
-				return err
-			}
-		}
-	} else {
-		return errors.New("cbor zone map does not contain a signature")
+	if err := rcbor.CheckKeys("shard", m, 0, 4, 6, 11, 23); err != nil {
+		return err
 	}
-	// SubjectZone
-	if zone, ok := m[4].(string); ok {
-		s.SubjectZone = zone
-	} else {
-		return errors.New("cbor shard map does not contain a subject zone")
+	sigs, err := unmarshalSignatures("shard", m)
+	if err != nil {
+		return err
 	}
-	// Context
-	if ctx, ok := m[6].(string); ok {
-		s.Context = ctx
-	} else {
-		return errors.New("cbor shard map does not contain a context")
+	zone, err := unmarshalString("shard", m, 4, true)
+	if err != nil {
+		return err
 	}
-	// RangeFrom/RangeTo
-	if srange, ok := m[11].([]interface{}); ok {
-		begin, ok := srange[0].(string)
-		if !ok {
-			return errors.New("cbor shard encoding of rangeFrom should be a string")
-		}
-		s.RangeFrom = begin
-		end, ok := srange[1].(string)
-		if !ok {
-			return errors.New("cbor shard encoding of rangeEnd should be a string")
-		}
-		s.RangeTo = end
-	} else {
-		return errors.New("cbor shard map does not contain a range")
+	ctx, err := unmarshalString("shard", m, 6, true)
+	if err != nil {
+		return err
 	}
-	// Content
-	if cont, ok := m[23].([]interface{}); ok {
-		s.Content = make([]*Assertion, 0)
-		for _, obj := range cont {
-			as := &Assertion{}
-			a, ok := obj.(map[int]interface{})
-			if !ok {
-				return errors.New("cbor shard content entry is not a map")
-			}
-			if err := as.UnmarshalMap(a); err != nil {
-				return err
-			}
-			s.Content = append(s.Content, as)
-		}
-	} else {
-		return errors.New("cbor shard map does not contain a content")
+	begin, end, err := unmarshalRange("shard", m)
+	if err != nil {
+		return err
 	}
+	content, err := unmarshalAssertions("shard", m)
+	if err != nil {
+		return err
+	}
+	s.Signatures, s.SubjectZone, s.Context = sigs, zone, ctx
+	s.RangeFrom, s.RangeTo, s.Content = begin, end, content
 	return nil
 }
 
@@ -107,6 +76,20 @@ func (s *Shard) MarshalCBOR(w *cbor.CBORWriter) error {
 	return w.WriteIntMap(m)
 }
 
+//MarshalJSON implements the json.Marshaler interface. An empty RangeFrom or RangeTo denotes an
+//unbounded range.
+func (s *Shard) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type        string          `json:"type"`
+		SubjectZone string          `json:"subjectZone"`
+		Context     string          `json:"context"`
+		RangeFrom   string          `json:"rangeFrom"`
+		RangeTo     string          `json:"rangeTo"`
+		Assertions  []*Assertion    `json:"assertions"`
+		Signatures  []signature.Sig `json:"signatures,omitempty"`
+	}{"shard", s.SubjectZone, s.Context, s.RangeFrom, s.RangeTo, s.Content, s.Signatures})
+}
+
 //AllSigs returns the shard's signatures
 func (s *Shard) AllSigs() []signature.Sig {
 	return s.Signatures
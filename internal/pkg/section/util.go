@@ -5,6 +5,10 @@ import (
 	"time"
 
 	log "github.com/inconshreveable/log15"
+
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
 )
 
 func UpdateValidity(validSince, validUntil, oldValidSince, oldValidUntil int64,
@@ -13,8 +17,8 @@ func UpdateValidity(validSince, validUntil, oldValidSince, oldValidUntil int64,
 		oldValidSince = math.MaxInt64
 	}
 	if validSince < oldValidSince {
-		if validSince > time.Now().Add(maxValidity).Unix() {
-			oldValidSince = time.Now().Add(maxValidity).Unix()
+		if validSince > clock.Now().Add(maxValidity).Unix() {
+			oldValidSince = clock.Now().Add(maxValidity).Unix()
 			log.Warn("newValidSince exceeded maxValidity", "oldValidSince", oldValidSince,
 				"newValidSince", validSince, "maxValidity", maxValidity)
 		} else {
@@ -22,8 +26,8 @@ func UpdateValidity(validSince, validUntil, oldValidSince, oldValidUntil int64,
 		}
 	}
 	if validUntil > oldValidUntil {
-		if validUntil > time.Now().Add(maxValidity).Unix() {
-			oldValidUntil = time.Now().Add(maxValidity).Unix()
+		if validUntil > clock.Now().Add(maxValidity).Unix() {
+			oldValidUntil = clock.Now().Add(maxValidity).Unix()
 			log.Warn("newValidUntil exceeded maxValidity", "oldValidSince", oldValidSince,
 				"newValidSince", validSince, "maxValidity", maxValidity)
 		} else {
@@ -32,3 +36,96 @@ func UpdateValidity(validSince, validUntil, oldValidSince, oldValidUntil int64,
 	}
 	return oldValidSince, oldValidUntil
 }
+
+//unmarshalSignatures decodes the signatures at key 0 of the CBOR map of item. The key is omitted
+//if the section is not signed, e.g. an assertion contained in a shard.
+func unmarshalSignatures(item string, m map[int]interface{}) ([]signature.Sig, error) {
+	val, ok := m[0]
+	if !ok {
+		return nil, nil
+	}
+	sigs, ok := val.([]interface{})
+	if !ok {
+		return nil, rcbor.WrongType(item, 0, "array")
+	}
+	result := make([]signature.Sig, len(sigs))
+	for i, sig := range sigs {
+		sigVal, ok := sig.([]interface{})
+		if !ok {
+			return nil, rcbor.WrongType(item, 0, "array of signature arrays")
+		}
+		if err := result[i].UnmarshalArray(sigVal); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+//unmarshalString decodes the string at key of the CBOR map of item. If the key is absent, it
+//returns an error if the string is mandatory and the empty string otherwise.
+func unmarshalString(item string, m map[int]interface{}, key int, mandatory bool) (string,
+	error) {
+	val, ok := m[key]
+	if !ok {
+		if mandatory {
+			return "", rcbor.MissingKey(item, key)
+		}
+		return "", nil
+	}
+	s, ok := val.(string)
+	if !ok {
+		return "", rcbor.WrongType(item, key, "string")
+	}
+	return s, nil
+}
+
+//unmarshalRange decodes the mandatory shard range at key 11 of the CBOR map of item.
+func unmarshalRange(item string, m map[int]interface{}) (string, string, error) {
+	val, ok := m[11]
+	if !ok {
+		return "", "", rcbor.MissingKey(item, 11)
+	}
+	srange, ok := val.([]interface{})
+	if !ok {
+		return "", "", rcbor.WrongType(item, 11, "array")
+	}
+	if len(srange) != 2 {
+		return "", "", rcbor.WrongLength(item, 11, 2, len(srange))
+	}
+	begin, ok := srange[0].(string)
+	if !ok {
+		return "", "", rcbor.WrongType(item, 11, "array of strings")
+	}
+	end, ok := srange[1].(string)
+	if !ok {
+		return "", "", rcbor.WrongType(item, 11, "array of strings")
+	}
+	return begin, end, nil
+}
+
+//unmarshalAssertions decodes the mandatory contained assertions at key 23 of the CBOR map of
+//item.
+func unmarshalAssertions(item string, m map[int]interface{}) ([]*Assertion, error) {
+	val, ok := m[23]
+	if !ok {
+		return nil, rcbor.MissingKey(item, 23)
+	}
+	cont, ok := val.([]interface{})
+	if !ok {
+		return nil, rcbor.WrongType(item, 23, "array")
+	}
+	//The assertions are allocated at once as zones and shards can contain many of them.
+	slab := make([]Assertion, len(cont))
+	assertions := make([]*Assertion, len(cont))
+	for i, obj := range cont {
+		a, ok := obj.(map[int]interface{})
+		if !ok {
+			return nil, rcbor.WrongType(item, 23, "array of assertion maps")
+		}
+		if err := slab[i].UnmarshalMap(a); err != nil {
+			return nil, err
+		}
+		assertions[i] = &slab[i]
+	}
+	return assertions, nil
+}
@@ -1,7 +1,7 @@
 package section
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
@@ -10,6 +10,7 @@ import (
 	cbor "github.com/britram/borat"
 	log "github.com/inconshreveable/log15"
 
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/signature"
 )
@@ -25,51 +26,29 @@ type Zone struct {
 	sign        bool  //set to true before signing and false afterwards
 }
 
-// UnmarshalMap decodes the output from the CBOR decoder into this struct.
+// UnmarshalMap decodes the output from the CBOR decoder into this struct. It returns a
+// *cbor.DecodeError identifying the offending key if m is malformed.
 func (z *Zone) UnmarshalMap(m map[int]interface{}) error {
-	if sigs, ok := m[0].([]interface{}); ok {
-		z.Signatures = make([]signature.Sig, len(sigs))
-		for i, sig := range sigs {
-			sigVal, ok := sig.([]interface{})
-			if !ok {
-				return errors.New("cbor zone signatures entry is not an array")
-			}
-			if err := z.Signatures[i].UnmarshalArray(sigVal); err != nil {
-				return err
-			}
-		}
-	} else {
-		return errors.New("cbor zone map does not contain a signature")
+	if err := rcbor.CheckKeys("zone", m, 0, 4, 6, 23); err != nil {
+		return err
 	}
-	// SubjectZone
-	if zone, ok := m[4].(string); ok {
-		z.SubjectZone = zone
-	} else {
-		return errors.New("cbor zone map does not contain a subject zone")
+	sigs, err := unmarshalSignatures("zone", m)
+	if err != nil {
+		return err
 	}
-	// Context
-	if ctx, ok := m[6].(string); ok {
-		z.Context = ctx
-	} else {
-		return errors.New("cbor zone map does not contain a context")
+	zone, err := unmarshalString("zone", m, 4, true)
+	if err != nil {
+		return err
 	}
-	// Content
-	if cont, ok := m[23].([]interface{}); ok {
-		z.Content = make([]*Assertion, 0)
-		for _, obj := range cont {
-			as := &Assertion{}
-			a, ok := obj.(map[int]interface{})
-			if !ok {
-				return errors.New("cbor zone content entry is not a map")
-			}
-			if err := as.UnmarshalMap(a); err != nil {
-				return err
-			}
-			z.Content = append(z.Content, as)
-		}
-	} else {
-		return errors.New("cbor zone map does not contain a content")
+	ctx, err := unmarshalString("zone", m, 6, true)
+	if err != nil {
+		return err
+	}
+	content, err := unmarshalAssertions("zone", m)
+	if err != nil {
+		return err
 	}
+	z.Signatures, z.SubjectZone, z.Context, z.Content = sigs, zone, ctx, content
 	return nil
 }
 
@@ -85,6 +64,17 @@ func (z *Zone) MarshalCBOR(w *cbor.CBORWriter) error {
 	return w.WriteIntMap(m)
 }
 
+//MarshalJSON implements the json.Marshaler interface.
+func (z *Zone) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type        string          `json:"type"`
+		SubjectZone string          `json:"subjectZone"`
+		Context     string          `json:"context"`
+		Assertions  []*Assertion    `json:"assertions"`
+		Signatures  []signature.Sig `json:"signatures,omitempty"`
+	}{"zone", z.SubjectZone, z.Context, z.Content, z.Signatures})
+}
+
 //AllSigs returns the zone's signatures
 func (z *Zone) AllSigs() []signature.Sig {
 	return z.Signatures
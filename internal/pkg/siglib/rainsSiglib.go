@@ -7,11 +7,11 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
-	"time"
 
 	cbor "github.com/britram/borat"
 	log "github.com/inconshreveable/log15"
 
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
@@ -59,7 +59,7 @@ func CheckSectionSignatures(s section.WithSig, pkeys map[keys.PublicKeyID][]keys
 	}
 	for _, sig := range sigs {
 		if keys, ok := pkeys[sig.PublicKeyID]; ok {
-			if int64(sig.ValidUntil) < time.Now().Unix() {
+			if int64(sig.ValidUntil) < clock.Now().Unix() {
 				log.Info("signature is expired", "signature", sig)
 				continue
 			}
@@ -109,7 +109,7 @@ func CheckSignatureNotExpired(s section.WithSig) bool {
 		return true
 	}
 	for _, sig := range s.AllSigs() {
-		if int64(sig.ValidUntil) < time.Now().Unix() {
+		if int64(sig.ValidUntil) < clock.Now().Unix() {
 			log.Warn("signature is expired", "signature", sig)
 			return false
 		}
@@ -188,6 +188,41 @@ func SignMessageUnsafe(msg *message.Message, privateKey interface{}, sig signatu
 	return true
 }
 
+//CheckMessageSignatures verifies the signatures on msg with the public keys of pkeys, e.g. the
+//infrastructure key of the server which sent msg. It returns true if msg has at least one
+//signature and all of them are currently valid and correct. The signatures are kept on msg.
+func CheckMessageSignatures(msg *message.Message, pkeys map[keys.PublicKeyID][]keys.PublicKey) bool {
+	if msg == nil || len(msg.Signatures) == 0 {
+		log.Debug("Message contains no signatures")
+		return false
+	}
+	sigs := msg.Signatures
+	msg.Signatures = nil
+	defer func() { msg.Signatures = sigs }()
+	encoding := new(bytes.Buffer)
+	if err := msg.MarshalCBOR(cbor.NewCBORWriter(encoding)); err != nil {
+		log.Warn("Was not able to marshal message.", "error", err)
+		return false
+	}
+	now := clock.Now().Unix()
+	for _, sig := range sigs {
+		if sig.ValidSince > now || sig.ValidUntil < now {
+			log.Info("message signature is not valid now", "signature", sig)
+			return false
+		}
+		key, ok := getPublicKey(pkeys[sig.PublicKeyID], sig.MetaData())
+		if !ok {
+			log.Warn("No publicKey matching the message signature", "publicKeyID", sig.PublicKeyID)
+			return false
+		}
+		if !sig.VerifySignature(key.Key, encoding.Bytes()) {
+			log.Warn("Message sig does not match", "signature", sig)
+			return false
+		}
+	}
+	return true
+}
+
 //checkMessageStringFields returns true if the capabilities and all string fields in the contained
 //sections of the given message do not contain a zone file type marker, i.e. not a substring
 //matching regrex expression '\s:\S+:\s'
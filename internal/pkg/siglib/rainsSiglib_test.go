@@ -197,6 +197,52 @@ func TestCheckSectionSignaturesErrors(t *testing.T) {
 	}
 }
 
+func TestCheckMessageSignatures(t *testing.T) {
+	genPublicKey, genPrivateKey, _ := ed25519.GenerateKey(nil)
+	otherPublicKey, _, _ := ed25519.GenerateKey(nil)
+	newMsg := func() *message.Message {
+		return &message.Message{Token: token.New(), Content: []section.Section{section.GetNotification()}}
+	}
+	signed := func(sig signature.Sig) *message.Message {
+		msg := newMsg()
+		if !SignMessageUnsafe(msg, genPrivateKey, sig) {
+			t.Fatal("Was not able to sign message")
+		}
+		return msg
+	}
+	pkeys := func(key ed25519.PublicKey) map[keys.PublicKeyID][]keys.PublicKey {
+		sig := section.Signature()
+		return map[keys.PublicKeyID][]keys.PublicKey{sig.PublicKeyID: []keys.PublicKey{
+			keys.PublicKey{PublicKeyID: sig.PublicKeyID, ValidSince: sig.ValidSince,
+				ValidUntil: sig.ValidUntil, Key: key}}}
+	}
+	expired := section.Signature()
+	expired.ValidSince, expired.ValidUntil = 0, time.Now().Add(-time.Minute).Unix()
+	tampered := signed(section.Signature())
+	tampered.Token = token.New()
+	var tests = []struct {
+		input *message.Message
+		pkeys map[keys.PublicKeyID][]keys.PublicKey
+		want  bool
+	}{
+		{nil, pkeys(genPublicKey), false},                           //msg nil
+		{newMsg(), pkeys(genPublicKey), false},                      //no signatures
+		{signed(section.Signature()), pkeys(genPublicKey), true},    //valid
+		{signed(section.Signature()), pkeys(otherPublicKey), false}, //wrong key
+		{signed(section.Signature()), nil, false},                   //no matching key
+		{signed(expired), pkeys(genPublicKey), false},               //sig expired
+		{tampered, pkeys(genPublicKey), false},                      //message changed
+	}
+	for i, test := range tests {
+		if res := CheckMessageSignatures(test.input, test.pkeys); res != test.want {
+			t.Errorf("%d: expected=%v, actual=%v", i, test.want, res)
+		}
+		if test.input != nil && test.want && len(test.input.Signatures) != 1 {
+			t.Errorf("%d: signature was removed from message", i)
+		}
+	}
+}
+
 func TestCheckMessageStringFields(t *testing.T) {
 	log.Root().SetHandler(log.DiscardHandler())
 	msg := message.GetMessage()
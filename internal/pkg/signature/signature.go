@@ -3,48 +3,62 @@ package signature
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 
 	cbor "github.com/britram/borat"
 	log "github.com/inconshreveable/log15"
 	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"golang.org/x/crypto/ed25519"
 )
 
-// UnmarshalArray takes in a CBOR decoded array and populates Sig.
+// UnmarshalArray takes in a CBOR decoded array and populates Sig. It returns a
+// *cbor.DecodeError identifying the offending element if in is malformed.
 func (sig *Sig) UnmarshalArray(in []interface{}) error {
 	if len(in) != 6 {
-		return fmt.Errorf("expected 6 items in input array but got %d", len(in))
+		return rcbor.WrongLength("signature", -1, 6, len(in))
 	}
 	algo, ok := in[0].(int)
 	if !ok {
-		return errors.New("cbor encoding of the algorithm should be an int")
+		return rcbor.WrongType("signature", 0, "int")
+	}
+	switch algorithmTypes.Signature(algo) {
+	case algorithmTypes.Ed25519, algorithmTypes.Ed448:
+	default:
+		return rcbor.OutOfRange("signature", 0, algo)
 	}
 	sig.PublicKeyID.Algorithm = algorithmTypes.Signature(algo)
 	keySpace, ok := in[1].(int)
 	if !ok {
-		return errors.New("cbor encoding of the key space should be an int")
+		return rcbor.WrongType("signature", 1, "int")
+	}
+	if keys.KeySpaceID(keySpace) != keys.RainsKeySpace {
+		return rcbor.OutOfRange("signature", 1, keySpace)
 	}
 	sig.PublicKeyID.KeySpace = keys.KeySpaceID(keySpace)
 	sig.PublicKeyID.KeyPhase, ok = in[2].(int)
 	if !ok {
-		return errors.New("cbor encoding of the key phase should be an int")
+		return rcbor.WrongType("signature", 2, "int")
+	}
+	if sig.PublicKeyID.KeyPhase < 0 {
+		return rcbor.OutOfRange("signature", 2, sig.PublicKeyID.KeyPhase)
 	}
 	validSince, ok := in[3].(int)
 	if !ok {
-		return errors.New("cbor encoding of the validSince should be an int")
+		return rcbor.WrongType("signature", 3, "int")
 	}
 	sig.ValidSince = int64(validSince)
 	validUntil, ok := in[4].(int)
 	if !ok {
-		return errors.New("cbor encoding of the validUntil should be an int")
+		return rcbor.WrongType("signature", 4, "int")
 	}
 	sig.ValidUntil = int64(validUntil)
 	data, ok := in[5].([]byte)
 	if !ok {
-		return errors.New("cbor encoding of the data should be a byte array")
+		return rcbor.WrongType("signature", 5, "byte string")
 	}
 	sig.Data = data
 	return nil
@@ -59,6 +73,22 @@ func (sig Sig) MarshalCBOR(w *cbor.CBORWriter) error {
 	return w.WriteArray(res)
 }
 
+//MarshalJSON implements the json.Marshaler interface. The signature data is encoded in hexadecimal.
+func (sig Sig) MarshalJSON() ([]byte, error) {
+	data := ""
+	if d, ok := sig.Data.([]byte); ok {
+		data = hex.EncodeToString(d)
+	}
+	return json.Marshal(struct {
+		Algorithm  string `json:"algorithm"`
+		KeySpace   string `json:"keySpace"`
+		KeyPhase   int    `json:"keyPhase"`
+		ValidSince int64  `json:"validSince"`
+		ValidUntil int64  `json:"validUntil"`
+		Data       string `json:"data"`
+	}{sig.Algorithm.String(), sig.KeySpace.String(), sig.KeyPhase, sig.ValidSince, sig.ValidUntil, data})
+}
+
 //MetaData contains meta data of the signature
 type MetaData struct {
 	keys.PublicKeyID
@@ -136,20 +166,20 @@ func (sig Sig) CompareTo(s Sig) int {
 	return 0
 }
 
-//SignData adds signature meta data to encoding. It then signs the encoding with privateKey and updates sig.Data field with the generated signature
+//SignData adds signature meta data to encoding. It then signs the canonical form of the encoding
+//with privateKey and updates sig.Data field with the generated signature
 //In case of an error an error is returned indicating the cause, otherwise nil is returned
 func (sig *Sig) SignData(privateKey interface{}, encoding []byte) error {
 	if privateKey == nil {
 		return errors.New("privateKey is nil")
 	}
-	sigEncoding := new(bytes.Buffer)
-	if err := sig.MarshalCBOR(cbor.NewCBORWriter(sigEncoding)); err != nil {
-		return err
-	}
-	encoding = append(encoding, sigEncoding.Bytes()...)
 	switch sig.Algorithm {
 	case algorithmTypes.Ed25519:
 		if pkey, ok := privateKey.(ed25519.PrivateKey); ok {
+			encoding, err := sig.SigningBytes(encoding)
+			if err != nil {
+				return err
+			}
 			log.Debug("Sign data", "signature", sig, "privateKey", hex.EncodeToString(privateKey.(ed25519.PrivateKey)), "encoding", encoding)
 			sig.Data = ed25519.Sign(pkey, encoding)
 			return nil
@@ -160,7 +190,9 @@ func (sig *Sig) SignData(privateKey interface{}, encoding []byte) error {
 	}
 }
 
-//VerifySignature adds signature meta data to the encoding. It then signs the encoding with privateKey and compares the resulting signature with the sig.Data.
+//VerifySignature adds signature meta data to the encoding. It then verifies sig.Data on the
+//canonical form of the encoding with publicKey. Signatures over any other form of the encoding are
+//rejected.
 //Returns true if there exist signatures and they are identical
 func (sig *Sig) VerifySignature(publicKey interface{}, encoding []byte) bool {
 	if sig.Data == nil {
@@ -175,17 +207,21 @@ func (sig *Sig) VerifySignature(publicKey interface{}, encoding []byte) bool {
 	//sig.Data = []byte{}
 	sig.sign = true
 	sigEncoding := new(bytes.Buffer)
-	if err := sig.MarshalCBOR(cbor.NewCBORWriter(sigEncoding)); err != nil {
+	err := sig.MarshalCBOR(cbor.NewCBORWriter(sigEncoding))
+	sig.sign = false
+	if err != nil {
 		log.Error("Was not able to cbor encode signature")
 		return false
 	}
-	encoding = append(encoding, sigEncoding.Bytes()...)
 	switch sig.Algorithm {
 	case algorithmTypes.Ed25519:
 		if pkey, ok := publicKey.(ed25519.PublicKey); ok {
-			ok = ed25519.Verify(pkey, encoding, sig.Data.([]byte))
-			sig.sign = false
-			return ok
+			canonical, err := canonicalEncoding(encoding, sigEncoding.Bytes())
+			if err != nil {
+				log.Warn("Could not canonicalize encoding", "error", err)
+				return false
+			}
+			return ed25519.Verify(pkey, canonical, sig.Data.([]byte))
 		}
 		log.Warn("Could not assert type ed25519.PublicKey", "publicKeyType", fmt.Sprintf("%T", publicKey))
 	default:
@@ -193,3 +229,30 @@ func (sig *Sig) VerifySignature(publicKey interface{}, encoding []byte) bool {
 	}
 	return false
 }
+
+//SigningBytes returns the bytes which are signed by sig for the section or message encoding: the
+//canonical form of encoding followed by the canonical encoding of the signature meta data of sig.
+//The encoding must not contain any signatures.
+func (sig Sig) SigningBytes(encoding []byte) ([]byte, error) {
+	sig.sign = true
+	sigEncoding := new(bytes.Buffer)
+	if err := sig.MarshalCBOR(cbor.NewCBORWriter(sigEncoding)); err != nil {
+		return nil, err
+	}
+	return canonicalEncoding(encoding, sigEncoding.Bytes())
+}
+
+//canonicalEncoding returns the canonical CBOR encoding of the section or message encoding followed
+//by the one of the signature meta data sigEncoding. Signing the canonical encoding makes signatures
+//independent of how an implementation orders map keys or encodes integers.
+func canonicalEncoding(encoding, sigEncoding []byte) ([]byte, error) {
+	canonical, err := rcbor.Canonical(encoding)
+	if err != nil {
+		return nil, fmt.Errorf("encoding is not valid CBOR: %v", err)
+	}
+	canonicalSig, err := rcbor.Canonical(sigEncoding)
+	if err != nil {
+		return nil, fmt.Errorf("signature meta data encoding is not valid CBOR: %v", err)
+	}
+	return append(canonical, canonicalSig...), nil
+}
@@ -1,8 +1,11 @@
 package signature
 
 import (
+	"bytes"
+	"encoding/hex"
 	"testing"
 
+	cbor "github.com/britram/borat"
 	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"golang.org/x/crypto/ed25519"
@@ -46,3 +49,35 @@ func TestVerifySignatureErrors(t *testing.T) {
 		}
 	}
 }
+
+func TestSignatureCanonicalEncoding(t *testing.T) {
+	pkey, skey, _ := ed25519.GenerateKey(nil)
+	//the same map with unsorted keys and non shortest integers
+	encoding, _ := hex.DecodeString("a2616202616101")
+	nonCanonical, _ := hex.DecodeString("bf616219000261611801ff")
+	sig := &Sig{PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519}, ValidUntil: 300}
+	if err := sig.SignData(skey, encoding); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !sig.VerifySignature(pkey, encoding) {
+		t.Fatal("Signature should verify on the signed encoding")
+	}
+	if !sig.VerifySignature(pkey, nonCanonical) {
+		t.Fatal("Signature should verify on an equivalent non canonical encoding")
+	}
+	encoding[len(encoding)-1] = 2
+	if sig.VerifySignature(pkey, encoding) {
+		t.Fatal("Signature should not verify on a different encoding")
+	}
+	//a signature over the non canonical encoding as is
+	sig.sign = true
+	sigEncoding := new(bytes.Buffer)
+	if err := sig.MarshalCBOR(cbor.NewCBORWriter(sigEncoding)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sig.sign = false
+	sig.Data = ed25519.Sign(skey, append(nonCanonical, sigEncoding.Bytes()...))
+	if sig.VerifySignature(pkey, nonCanonical) {
+		t.Fatal("Signature over a non canonical encoding should not verify")
+	}
+}
@@ -1,6 +1,8 @@
 package util
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/gob"
 	"errors"
 	"fmt"
@@ -9,7 +11,7 @@ import (
 	"time"
 
 	log "github.com/inconshreveable/log15"
-	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/codec"
 	"github.com/netsec-ethz/rains/internal/pkg/connection"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
@@ -161,18 +163,40 @@ func NewNotificationMessage(tok token.Token, t section.NotificationType, data st
 //or an error.
 func SendQuery(msg message.Message, addr net.Addr, timeout time.Duration) (
 	message.Message, error) {
-	conn, err := connection.CreateConnection(addr)
+	return SendQueryTLS(msg, addr, timeout, nil)
+}
+
+//SendQueryTLS works like SendQuery but uses tlsConfig to establish the connection. If tlsConfig is
+//nil, the server's certificate is not verified.
+func SendQueryTLS(msg message.Message, addr net.Addr, timeout time.Duration,
+	tlsConfig *tls.Config) (message.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return SendQueryContext(ctx, msg, addr, tlsConfig)
+}
+
+//SendQueryContext works like SendQueryTLS but gives up dialing, sending the query and waiting for
+//the response as soon as ctx is done instead of after a fixed timeout.
+func SendQueryContext(ctx context.Context, msg message.Message, addr net.Addr,
+	tlsConfig *tls.Config) (message.Message, error) {
+	conn, err := connection.CreateTLSConnectionContext(ctx, addr, tlsConfig)
 	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return message.Message{}, fmt.Errorf("timed out connecting to %s", addr)
+		}
 		return message.Message{}, err
 	}
 	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
 
-	done := make(chan message.Message)
-	ec := make(chan error)
+	//buffered such that Listen returns when the connection is closed before it answered
+	done := make(chan message.Message, 1)
+	ec := make(chan error, 1)
 	go connection.Listen(conn, msg.Token, done, ec)
 
-	writer := cbor.NewWriter(conn)
-	if err := writer.Marshal(&msg); err != nil {
+	if err := (codec.CBOR{}).Encode(conn, &msg); err != nil {
 		return message.Message{}, fmt.Errorf("failed to marshal message: %v", err)
 	}
 
@@ -181,7 +205,10 @@ func SendQuery(msg message.Message, addr net.Addr, timeout time.Duration) (
 		return msg, nil
 	case err := <-ec:
 		return message.Message{}, err
-	case <-time.After(timeout):
-		return message.Message{}, fmt.Errorf("timed out waiting for response")
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return message.Message{}, fmt.Errorf("timed out waiting for response")
+		}
+		return message.Message{}, ctx.Err()
 	}
 }
@@ -222,6 +222,17 @@ func encodeObjects(o []object.Object, indent string) string {
 	return strings.Join(objects, "\n")
 }
 
+//EncodeObjectValue returns the value of o in zonefile format without the type marker.
+func EncodeObjectValue(o object.Object) string {
+	encoding := encodeObjects([]object.Object{o}, "")
+	if strings.HasPrefix(encoding, ":") {
+		if end := strings.Index(encoding[1:], ":"); end != -1 {
+			encoding = encoding[end+2:]
+		}
+	}
+	return strings.TrimSpace(encoding)
+}
+
 //addIndentToType returns the object type ot with appropriate indent such that the object value start at the same
 //indent.
 func addIndentToType(ot string) string {
@@ -0,0 +1,98 @@
+package benchmark
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	log "github.com/inconshreveable/log15"
+)
+
+var profiles = flag.String("profiles", "", "Directory to which a cpu and a heap profile of each "+
+	"benchmark run are written. No profiles are written if it is empty.")
+
+//workDir contains the keys, zonefiles and configurations of the engine benchmarks.
+var workDir string
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	log.Root().SetHandler(log.DiscardHandler())
+	var err error
+	if workDir, err = ioutil.TempDir("", "rainsbench"); err != nil {
+		fmt.Fprintf(os.Stderr, "Was not able to create work directory: %v\n", err)
+		os.Exit(1)
+	}
+	if *profiles != "" {
+		if err := os.MkdirAll(*profiles, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Was not able to create profile directory: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	code := m.Run()
+	stopEngines()
+	os.RemoveAll(workDir)
+	os.Exit(code)
+}
+
+//profile writes a cpu profile of the remaining run of b and a heap profile at its end to the
+//profile directory. The files are named after the benchmark, e.g.
+//BenchmarkAssertionCacheAdd_size=1000_goroutines=8.cpu.pprof. As a benchmark function is run
+//several times with increasing b.N, the profiles of the last and longest run are kept. The
+//returned function stops the profiling and must be deferred by the benchmark.
+func profile(b *testing.B) func() {
+	if *profiles == "" {
+		return func() {}
+	}
+	prefix := filepath.Join(*profiles, strings.Replace(b.Name(), "/", "_", -1))
+	cpu, err := os.Create(prefix + ".cpu.pprof")
+	if err != nil {
+		b.Fatalf("Was not able to create cpu profile: %v", err)
+	}
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		//Another cpu profile is running, e.g. because of go test -cpuprofile.
+		cpu.Close()
+		b.Logf("Was not able to start cpu profile: %v", err)
+		cpu = nil
+	}
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			cpu.Close()
+		}
+		heap, err := os.Create(prefix + ".heap.pprof")
+		if err != nil {
+			b.Errorf("Was not able to create heap profile: %v", err)
+			return
+		}
+		defer heap.Close()
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(heap); err != nil {
+			b.Errorf("Was not able to write heap profile: %v", err)
+		}
+	}
+}
+
+//parallel calls f b.N times distributed over the given number of go routines and returns when all
+//calls returned. f is called with the index of the go routine and the number of the call.
+func parallel(b *testing.B, goroutines int, f func(g, i int)) {
+	var next int64 = -1
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			defer wg.Done()
+			for i := int(atomic.AddInt64(&next, 1)); i < b.N; i = int(atomic.AddInt64(&next, 1)) {
+				f(g, i)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,228 @@
+package benchmark
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cache"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+var (
+	//cacheSizes are the numbers of entries of the caches under test.
+	cacheSizes = []int{1000, 10000, 100000}
+	//concurrency are the numbers of go routines accessing a cache simultaneously.
+	concurrency = []int{1, 8, 64}
+)
+
+//forEachConfig runs f as sub benchmark of b for each combination of cache size and concurrency.
+func forEachConfig(b *testing.B, f func(b *testing.B, size, goroutines int)) {
+	for _, size := range cacheSizes {
+		for _, goroutines := range concurrency {
+			b.Run(fmt.Sprintf("size=%d/goroutines=%d", size, goroutines), func(b *testing.B) {
+				f(b, size, goroutines)
+			})
+		}
+	}
+}
+
+//BenchmarkAssertionCacheAdd measures adding assertions to a full cache such that each addition
+//evicts the least recently used entry.
+func BenchmarkAssertionCacheAdd(b *testing.B) {
+	forEachConfig(b, func(b *testing.B, size, goroutines int) {
+		c := cache.NewAssertion(size)
+		expiration := time.Now().Add(time.Hour).Unix()
+		for _, a := range Assertions(0, size) {
+			c.Add(a, expiration, false)
+		}
+		assertions := Assertions(size, size)
+		defer profile(b)()
+		b.ReportAllocs()
+		b.ResetTimer()
+		parallel(b, goroutines, func(g, i int) {
+			c.Add(assertions[i%size], expiration, false)
+		})
+	})
+}
+
+//BenchmarkAssertionCacheGet measures looking up cached assertions by name.
+func BenchmarkAssertionCacheGet(b *testing.B) {
+	forEachConfig(b, func(b *testing.B, size, goroutines int) {
+		c := cache.NewAssertion(size)
+		expiration := time.Now().Add(time.Hour).Unix()
+		assertions := Assertions(0, size)
+		names := make([]string, size)
+		for i, a := range assertions {
+			c.Add(a, expiration, false)
+			names[i] = a.FQDN()
+		}
+		defer profile(b)()
+		b.ReportAllocs()
+		b.ResetTimer()
+		parallel(b, goroutines, func(g, i int) {
+			if _, ok := c.Get(names[i%size], ".", object.OTIP4Addr, true); !ok {
+				b.Errorf("%s is not cached", names[i%size])
+			}
+		})
+	})
+}
+
+//BenchmarkAssertionCacheExpire measures removing expired assertions from a full cache of which
+//half of the entries are expired.
+func BenchmarkAssertionCacheExpire(b *testing.B) {
+	for _, size := range cacheSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			assertions := Assertions(0, size)
+			defer profile(b)()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				c := cache.NewAssertion(size)
+				for j, a := range assertions {
+					c.Add(a, halfExpired(j), false)
+				}
+				b.StartTimer()
+				c.RemoveExpiredValues()
+			}
+		})
+	}
+}
+
+//BenchmarkNegAssertionCacheAdd measures adding shards of distinct zones to a full cache.
+func BenchmarkNegAssertionCacheAdd(b *testing.B) {
+	forEachConfig(b, func(b *testing.B, size, goroutines int) {
+		c := cache.NewNegAssertion(size)
+		expiration := time.Now().Add(time.Hour).Unix()
+		for _, s := range Shards(0, size) {
+			c.AddShard(s, expiration, false)
+		}
+		shards := Shards(size, size)
+		defer profile(b)()
+		b.ReportAllocs()
+		b.ResetTimer()
+		parallel(b, goroutines, func(g, i int) {
+			c.AddShard(shards[i%size], expiration, false)
+		})
+	})
+}
+
+//BenchmarkNegAssertionCacheGet measures looking up the shard covering a name. The cache is large
+//enough to hold all shards as it already evicts a shard when it reaches its capacity.
+func BenchmarkNegAssertionCacheGet(b *testing.B) {
+	forEachConfig(b, func(b *testing.B, size, goroutines int) {
+		c := cache.NewNegAssertion(2 * size)
+		expiration := time.Now().Add(time.Hour).Unix()
+		shards := Shards(0, size)
+		for _, s := range shards {
+			c.AddShard(s, expiration, false)
+		}
+		interval := section.StringInterval{Name: "www"}
+		defer profile(b)()
+		b.ReportAllocs()
+		b.ResetTimer()
+		parallel(b, goroutines, func(g, i int) {
+			if _, ok := c.Get(shards[i%size].SubjectZone, ".", interval); !ok {
+				b.Errorf("No shard of %s is cached", shards[i%size].SubjectZone)
+			}
+		})
+	})
+}
+
+//BenchmarkNegAssertionCacheExpire measures removing expired shards from a full cache of which
+//half of the entries are expired.
+func BenchmarkNegAssertionCacheExpire(b *testing.B) {
+	for _, size := range cacheSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			shards := Shards(0, size)
+			defer profile(b)()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				c := cache.NewNegAssertion(size)
+				for j, s := range shards {
+					c.AddShard(s, halfExpired(j), false)
+				}
+				b.StartTimer()
+				c.RemoveExpiredValues()
+			}
+		})
+	}
+}
+
+//BenchmarkZoneKeyCacheAdd measures adding public keys of distinct zones to a full cache.
+func BenchmarkZoneKeyCacheAdd(b *testing.B) {
+	forEachConfig(b, func(b *testing.B, size, goroutines int) {
+		c := cache.NewZoneKey(size, size, 5)
+		delegations, pkeys := Delegations(0, size, time.Hour)
+		for i, a := range delegations {
+			c.Add(a, pkeys[i], false)
+		}
+		delegations, pkeys = Delegations(size, size, time.Hour)
+		defer profile(b)()
+		b.ReportAllocs()
+		b.ResetTimer()
+		parallel(b, goroutines, func(g, i int) {
+			c.Add(delegations[i%size], pkeys[i%size], false)
+		})
+	})
+}
+
+//BenchmarkZoneKeyCacheGet measures looking up the public key of a zone matching a signature. The
+//cache is large enough to hold all keys as it already evicts a key when it reaches its capacity.
+func BenchmarkZoneKeyCacheGet(b *testing.B) {
+	forEachConfig(b, func(b *testing.B, size, goroutines int) {
+		c := cache.NewZoneKey(2*size, 2*size, 5)
+		delegations, pkeys := Delegations(0, size, time.Hour)
+		for i, a := range delegations {
+			c.Add(a, pkeys[i], false)
+		}
+		sigMetaData := SigMetaData()
+		defer profile(b)()
+		b.ReportAllocs()
+		b.ResetTimer()
+		parallel(b, goroutines, func(g, i int) {
+			if _, _, ok := c.Get(delegations[i%size].FQDN(), ".", sigMetaData); !ok {
+				b.Errorf("No key of %s is cached", delegations[i%size].FQDN())
+			}
+		})
+	})
+}
+
+//BenchmarkZoneKeyCacheExpire measures removing expired public keys from a full cache of which
+//half of the entries are expired.
+func BenchmarkZoneKeyCacheExpire(b *testing.B) {
+	for _, size := range cacheSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			valid, validKeys := Delegations(0, size/2, time.Hour)
+			expired, expiredKeys := Delegations(size/2, size-size/2, -time.Minute)
+			defer profile(b)()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				c := cache.NewZoneKey(2*size, 2*size, 5)
+				for j, a := range valid {
+					c.Add(a, validKeys[j], false)
+				}
+				for j, a := range expired {
+					c.Add(a, expiredKeys[j], false)
+				}
+				b.StartTimer()
+				c.RemoveExpiredKeys()
+			}
+		})
+	}
+}
+
+//halfExpired returns an expiration time in the past for every second entry and one in the future
+//for all others.
+func halfExpired(i int) int64 {
+	if i%2 == 0 {
+		return time.Now().Add(-time.Minute).Unix()
+	}
+	return time.Now().Add(time.Hour).Unix()
+}
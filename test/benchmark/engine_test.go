@@ -0,0 +1,366 @@
+package benchmark
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/publisher"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+	"github.com/netsec-ethz/rains/tools/keycreator"
+)
+
+const (
+	//startTimeout is the time an engine is given to start listening.
+	startTimeout = 5 * time.Second
+	//cacheTimeout is the time an engine is given to verify and cache a published zone.
+	cacheTimeout = time.Minute
+	//queryTimeout is the time a client waits for the answer to a query.
+	queryTimeout = 10 * time.Second
+	//queryZoneSize is the number of assertions of the zone served by the engine answering queries.
+	queryZoneSize = 10000
+)
+
+var (
+	//publishSizes are the numbers of assertions of the zones published to the engine.
+	publishSizes = []int{1000, 10000}
+	//clients are the numbers of clients querying the engine simultaneously.
+	clients = []int{1, 8, 64}
+)
+
+//engine is an authoritative rainsd server of the root zone listening on an in-memory address.
+type engine struct {
+	server      *rainsd.Server
+	addr        net.Addr
+	adminSocket string
+}
+
+var (
+	//rootKeys creates the root key pair used to sign all synthetic zones on first use.
+	rootKeys sync.Once
+	//signedZones contains the sections of the signed synthetic root zones by their number of
+	//assertions.
+	signedZones = make(map[int][]section.Section)
+	//queryEngine is the engine answering queries. It is started on first use and shared by all
+	//query benchmarks.
+	queryEngine *engine
+	//engineCount is the number of engines started so far, used to name their files.
+	engineCount int
+)
+
+//rootKeyPath returns the path to the self signed root delegation assertion.
+func rootKeyPath() string {
+	return filepath.Join(workDir, "rootDelegationAssertion.gob")
+}
+
+//rootPrivateKeyPath returns the path to the private key of the root zone.
+func rootPrivateKeyPath() string {
+	return filepath.Join(workDir, "privateKeyRoot.txt")
+}
+
+//signedZone returns the sections of a synthetic root zone containing n assertions signed with the
+//root key. The zone is signed once per size.
+func signedZone(b *testing.B, n int) []section.Section {
+	b.Helper()
+	rootKeys.Do(func() {
+		if err := keycreator.DelegationAssertion(".", ".", rootKeyPath(),
+			rootPrivateKeyPath()); err != nil {
+			b.Fatalf("Was not able to generate the root key: %v", err)
+		}
+	})
+	if sections, ok := signedZones[n]; ok {
+		return sections
+	}
+	path := filepath.Join(workDir, fmt.Sprintf("zonefile%d.txt", n))
+	if err := ioutil.WriteFile(path, []byte(Zonefile(".", n)), 0600); err != nil {
+		b.Fatalf("Was not able to store zonefile: %v", err)
+	}
+	now := time.Now()
+	config := publisher.Config{
+		ZonefilePath:   path,
+		PrivateKeyPath: rootPrivateKeyPath(),
+		MetaDataConf: publisher.MetaDataConfig{
+			AddSignatureMetaData:       true,
+			AddSigMetaDataToAssertions: true,
+			SignatureAlgorithm:         algorithmTypes.Ed25519,
+			KeyPhase:                   1,
+			SigValidSince:              now.Add(-time.Hour).Unix(),
+			SigValidUntil:              now.Add(24 * time.Hour).Unix(),
+			SigSigningInterval:         time.Minute,
+		},
+		ConsistencyConf: publisher.ConsistencyConfig{SortZone: true},
+		DoSigning:       true,
+		MaxZoneSize:     2 * n,
+		OutputPath:      path + ".signed",
+	}
+	if err := publisher.New(config).Publish(); err != nil {
+		b.Fatalf("Was not able to sign zone: %v", err)
+	}
+	signed, err := zonefile.IO{}.LoadZonefile(config.OutputPath)
+	if err != nil {
+		b.Fatalf("Was not able to load signed zone: %v", err)
+	}
+	sections := make([]section.Section, len(signed))
+	for i, s := range signed {
+		sections[i] = s
+	}
+	signedZones[n] = sections
+	return sections
+}
+
+//startEngine starts an authoritative server of the root zone whose assertion cache holds up to
+//2*assertions entries.
+func startEngine(b *testing.B, assertions int) *engine {
+	b.Helper()
+	engineCount++
+	name := fmt.Sprintf("engine%d", engineCount)
+	e := &engine{adminSocket: filepath.Join(workDir, name+".sock")}
+	configPath := filepath.Join(workDir, name+".conf")
+	if err := ioutil.WriteFile(configPath, engineConfig(b, name, e.adminSocket, assertions),
+		0600); err != nil {
+		b.Fatalf("Was not able to store config: %v", err)
+	}
+	var err error
+	if e.server, err = rainsd.New(configPath, name); err != nil {
+		b.Fatalf("Was not able to create server: %v", err)
+	}
+	go e.server.Start(false)
+	if e.addr, err = e.server.Listening(startTimeout); err != nil {
+		b.Fatalf("Server did not start: %v", err)
+	}
+	return e
+}
+
+//engineConfig returns the json encoded configuration of an engine.
+func engineConfig(b *testing.B, name, adminSocket string, assertions int) []byte {
+	address := map[string]interface{}{"Type": "Mem", "Addr": map[string]interface{}{"ID": ""}}
+	config, err := json.Marshal(map[string]interface{}{
+		"RootZonePublicKeyPath":          rootKeyPath(),
+		"AssertionCheckPointInterval":    3600,
+		"NegAssertionCheckPointInterval": 3600,
+		"ZoneKeyCheckPointInterval":      3600,
+		"CheckPointPath":                 filepath.Join(workDir, "checkpoint", name) + "/",
+		"ServerAddress":                  address,
+		"PublisherAddress":               address,
+		"MaxConnections":                 1000,
+		"KeepAlivePeriod":                60,
+		"TCPTimeout":                     300,
+		"TLSCertificateFile":             "../integration/testdata/cert/server.crt",
+		"TLSPrivateKeyFile":              "../integration/testdata/cert/server.key",
+		"MaxMsgByteLength":               1 << 26,
+		"PrioBufferSize":                 1000,
+		"NormalBufferSize":               1000,
+		"NotificationBufferSize":         10,
+		"PrioWorkerCount":                2,
+		"NormalWorkerCount":              10,
+		"NotificationWorkerCount":        2,
+		"CapabilitiesCacheSize":          50,
+		"PeerToCapCacheSize":             1000,
+		"ActiveTokenCacheSize":           1000,
+		"Capabilities":                   []string{"urn:x-rains:tlssrv"},
+		"ZoneKeyCacheSize":               1000,
+		"ZoneKeyCacheWarnSize":           750,
+		"MaxPublicKeysPerZone":           5,
+		"PendingKeyCacheSize":            1000,
+		"InfrastructureKeyCacheSize":     1,
+		"ExternalKeyCacheSize":           1,
+		"DelegationQueryValidity":        5,
+		"ReapVerifyTimeout":              1800,
+		"AssertionCacheSize":             2 * assertions,
+		"NegativeAssertionCacheSize":     1000,
+		"PendingQueryCacheSize":          100,
+		"RedirectionCacheSize":           1000,
+		"RedirectionCacheWarnSize":       750,
+		"QueryValidity":                  5,
+		"AddressQueryValidity":           5,
+		"ContextAuthority":               []string{"."},
+		"ZoneAuthority":                  []string{"."},
+		"MaxCacheValidity": map[string]int{
+			"AssertionValidity":        720,
+			"ShardValidity":            720,
+			"ZoneValidity":             720,
+			"AddressAssertionValidity": 720,
+		},
+		"ReapEngineTimeout": 1800,
+		"AdminSocketPath":   adminSocket,
+	})
+	if err != nil {
+		b.Fatalf("Was not able to encode config: %v", err)
+	}
+	return config
+}
+
+//publish sends sections to e in a single message and waits until e cached count assertions.
+func (e *engine) publish(sections []section.Section, count int) error {
+	conn, err := connection.DialMem(context.Background(), e.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	msg := message.Message{Token: token.New(), Content: sections}
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+		return err
+	}
+	if _, err := conn.Write(encoding.Bytes()); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(cacheTimeout)
+	for {
+		resp, err := rainsd.AdminCall(e.adminSocket,
+			rainsd.AdminRequest{Command: rainsd.AdminStats}, time.Second)
+		if err != nil {
+			return err
+		}
+		if resp.Error != "" {
+			return errors.New(resp.Error)
+		}
+		var stats rainsd.AdminStatistics
+		if err := json.Unmarshal(resp.Result, &stats); err != nil {
+			return err
+		}
+		if stats.Caches[rainsd.CacheAssertions] >= count {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("engine cached %d instead of %d assertions within %v",
+				stats.Caches[rainsd.CacheAssertions], count, cacheTimeout)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+//stopEngines shuts down the engine shared by the query benchmarks.
+func stopEngines() {
+	if queryEngine != nil {
+		queryEngine.server.Shutdown(context.Background())
+	}
+}
+
+//client is a connection to an engine over which queries are sent one after the other.
+type client struct {
+	conn   net.Conn
+	reader cbor.Reader
+}
+
+//query sends a query for the ip4 address of name and waits for the answer. It returns an error if
+//the answer does not contain an assertion.
+func (c *client) query(name string) error {
+	q := &query.Name{
+		Context:    ".",
+		Name:       name,
+		Types:      []object.Type{object.OTIP4Addr},
+		Expiration: time.Now().Add(queryTimeout).Unix(),
+	}
+	msg := message.Message{Token: token.New(), Content: []section.Section{q}}
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+		return err
+	}
+	c.conn.SetDeadline(time.Now().Add(queryTimeout))
+	if _, err := c.conn.Write(encoding.Bytes()); err != nil {
+		return err
+	}
+	for {
+		var answer message.Message
+		if err := c.reader.Unmarshal(&answer); err != nil {
+			return err
+		}
+		if answer.Token != msg.Token {
+			continue
+		}
+		for _, s := range answer.Content {
+			if _, ok := s.(*section.Assertion); ok {
+				return nil
+			}
+		}
+		return fmt.Errorf("answer for %s contains no assertion: %v", name, answer.Content)
+	}
+}
+
+//BenchmarkEnginePublish measures how fast an engine verifies and caches a pushed zone of
+//synthetic assertions. Each iteration starts a new engine.
+func BenchmarkEnginePublish(b *testing.B) {
+	for _, size := range publishSizes {
+		b.Run(fmt.Sprintf("assertions=%d", size), func(b *testing.B) {
+			sections := signedZone(b, size)
+			defer profile(b)()
+			b.ReportAllocs()
+			b.ResetTimer()
+			var elapsed time.Duration
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				e := startEngine(b, size)
+				b.StartTimer()
+				start := time.Now()
+				err := e.publish(sections, size)
+				elapsed += time.Since(start)
+				b.StopTimer()
+				e.server.Shutdown(context.Background())
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+			}
+			b.Logf("%.0f assertions/s", float64(size*b.N)/elapsed.Seconds())
+		})
+	}
+}
+
+//BenchmarkEngineQuery measures the throughput of an engine answering queries for random names of
+//its zone sent by several clients simultaneously.
+func BenchmarkEngineQuery(b *testing.B) {
+	for _, n := range clients {
+		b.Run(fmt.Sprintf("clients=%d", n), func(b *testing.B) {
+			if queryEngine == nil {
+				e := startEngine(b, queryZoneSize)
+				if err := e.publish(signedZone(b, queryZoneSize), queryZoneSize); err != nil {
+					e.server.Shutdown(context.Background())
+					b.Fatal(err)
+				}
+				queryEngine = e
+			}
+			conns := make([]*client, n)
+			for i := range conns {
+				conn, err := connection.DialMem(context.Background(), queryEngine.addr)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer conn.Close()
+				conns[i] = &client{conn: conn, reader: cbor.NewReader(conn)}
+			}
+			names := make([]string, b.N)
+			for i := range names {
+				names[i] = hostName(rand.Intn(queryZoneSize)) + "."
+			}
+			defer profile(b)()
+			b.ReportAllocs()
+			b.ResetTimer()
+			start := time.Now()
+			parallel(b, n, func(g, i int) {
+				if err := conns[g].query(names[i]); err != nil {
+					b.Error(err)
+				}
+			})
+			b.Logf("%.0f queries/s", float64(b.N)/time.Since(start).Seconds())
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package benchmark
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+)
+
+//zone is the zone of all synthetic sections.
+const zone = "bench."
+
+//hostName returns the subject name of the i-th synthetic assertion.
+func hostName(i int) string {
+	return fmt.Sprintf("host%d", i)
+}
+
+//Assertions returns n synthetic assertions about the names host<offset>.bench. up to
+//host<offset+n-1>.bench., each containing an IPv4 address. Their content differs such that each of
+//them is cached separately.
+func Assertions(offset, n int) []*section.Assertion {
+	assertions := make([]*section.Assertion, n)
+	for i := range assertions {
+		id := offset + i
+		assertions[i] = &section.Assertion{
+			SubjectName: hostName(id),
+			SubjectZone: zone,
+			Context:     ".",
+			Content: []object.Object{{Type: object.OTIP4Addr,
+				Value: fmt.Sprintf("10.%d.%d.%d", id>>16&0xff, id>>8&0xff, id&0xff)}},
+		}
+	}
+	return assertions
+}
+
+//Shards returns n synthetic shards, each covering the whole namespace of its own zone
+//zone<offset+i>.bench.
+func Shards(offset, n int) []*section.Shard {
+	shards := make([]*section.Shard, n)
+	for i := range shards {
+		shards[i] = &section.Shard{
+			SubjectZone: fmt.Sprintf("zone%d.%s", offset+i, zone),
+			Context:     ".",
+			RangeFrom:   "",
+			RangeTo:     "",
+		}
+	}
+	return shards
+}
+
+//Delegations returns n synthetic delegation assertions for the zones zone<offset+i>.bench. whose
+//keys are valid for validity from now on, together with the keys.
+func Delegations(offset, n int, validity time.Duration) ([]*section.Assertion, []keys.PublicKey) {
+	assertions := make([]*section.Assertion, n)
+	pkeys := make([]keys.PublicKey, n)
+	now := time.Now()
+	for i := range assertions {
+		pkeys[i] = keys.PublicKey{
+			PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519, KeyPhase: 1},
+			ValidSince:  now.Add(-time.Hour).Unix(),
+			ValidUntil:  now.Add(validity).Unix(),
+			Key:         ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)),
+		}
+		binary.BigEndian.PutUint32(pkeys[i].Key.(ed25519.PublicKey), uint32(offset+i))
+		assertions[i] = &section.Assertion{
+			SubjectName: fmt.Sprintf("zone%d", offset+i),
+			SubjectZone: zone,
+			Context:     ".",
+			Content:     []object.Object{{Type: object.OTDelegation, Value: pkeys[i]}},
+		}
+	}
+	return assertions, pkeys
+}
+
+//SigMetaData returns the signature meta data matching the keys returned by Delegations.
+func SigMetaData() signature.MetaData {
+	now := time.Now()
+	return signature.MetaData{
+		Algorithm:  algorithmTypes.Ed25519,
+		KeyPhase:   1,
+		ValidSince: now.Unix(),
+		ValidUntil: now.Add(time.Hour).Unix(),
+	}
+}
+
+//Zonefile returns the zonefile of the zone name containing n assertions with the subject names
+//and content of the ones returned by Assertions(0, n).
+func Zonefile(name string, n int) string {
+	records := make([]string, n)
+	for i, a := range Assertions(0, n) {
+		records[i] = fmt.Sprintf(":A: %s [ :ip4: %s ]", a.SubjectName, a.Content[0].Value)
+	}
+	return fmt.Sprintf(":Z: %s . [\n    %s\n]\n", name, strings.Join(records, "\n    "))
+}
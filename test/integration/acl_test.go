@@ -0,0 +1,55 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/publisher"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestAccessControl(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.Publish()
+	//All clients, publishers and servers of the topology use the loopback interface.
+	tp.ACL = map[string][]string{"Query": {"192.0.2.0/24"}}
+	closed := tp.CachingResolver("closed")
+	tp.ACL = map[string][]string{"Push": {"192.0.2.0/24"}}
+	resolver := tp.CachingResolver("resolver")
+	tp.ACL = map[string][]string{"Publish": {"192.0.2.0/24"}}
+	uzh := tp.AddZone("uzh.ch.", ":A: www [ :ip4: 192.0.2.2 ]")
+	tp.ACL = nil
+
+	msg, err := closed.Query("www.ethz.ch.", object.OTIP4Addr)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(msg.Content) != 1 {
+		t.Fatalf("expected a notification. actual=%v", msg.Content)
+	}
+	if n, ok := msg.Content[0].(*section.Notification); !ok || n.Type != section.NTAccessDenied {
+		t.Fatalf("expected an access denied notification. actual=%v", msg.Content[0])
+	}
+
+	//Answers to forwarded queries are not pushed sections.
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	path, _, err := ethz.zonefile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := []connection.Info{{Type: tp.connectionType(), Addr: resolver.Addr}}
+	if err := publisher.New(ethz.publisherConfig(path, servers)).Publish(); err == nil {
+		t.Error("The resolver accepted pushed sections")
+	}
+
+	if err := uzh.publish(); err == nil {
+		t.Error("The server of uzh.ch. accepted its zone from a publisher not in its ACL")
+	}
+	uzh.Servers[0].ExpectNotCached("assertions", "www", "192.0.2.2")
+}
@@ -0,0 +1,199 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestAnswerFromShardsAndZones(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]", ":A: mail [ :ip4: 192.0.2.3 ]")
+	tp.Publish()
+	server := ethz.Servers[0]
+	//Only the shards and zones of ethz.ch. remain to answer queries.
+	var removed rainsd.AdminFlushResult
+	server.admin(rainsd.AdminCacheFlush, &removed, rainsd.CacheAssertions, "ethz.ch.")
+	if removed[rainsd.CacheAssertions] == 0 {
+		t.Fatal("No assertions of ethz.ch. were flushed")
+	}
+	server.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+
+	var tests = []struct {
+		name       string
+		types      []object.Type
+		assertions int
+		proofs     int
+	}{
+		{"www.ethz.ch.", []object.Type{object.OTIP4Addr}, 1, 0},
+		{"www.ethz.ch.", []object.Type{object.OTIP6Addr}, 0, 1},
+		{"www.ethz.ch.", []object.Type{object.OTIP4Addr, object.OTIP6Addr}, 1, 1},
+		{"mail.ethz.ch.", []object.Type{object.OTIP4Addr, object.OTIP4Addr}, 1, 0},
+		{"ftp.ethz.ch.", []object.Type{object.OTIP4Addr}, 0, 1},
+	}
+	for i, test := range tests {
+		msg, err := server.Query(test.name, test.types...)
+		if err != nil {
+			t.Fatalf("%d: query for %s failed: %v", i, test.name, err)
+		}
+		assertions, proofs := 0, 0
+		for _, sec := range msg.Content {
+			switch sec := sec.(type) {
+			case *section.Assertion:
+				if sec.FQDN() != test.name || sec.Context != "." || len(sec.AllSigs()) == 0 {
+					t.Errorf("%d: wrong assertion in answer: %v", i, sec)
+				}
+				assertions++
+			case *section.Shard, *section.Zone:
+				proofs++
+			}
+		}
+		if assertions != test.assertions || proofs != test.proofs {
+			t.Errorf("%d: wrong answer to query for %s %v. expected %d assertions and %d proofs, got %v",
+				i, test.name, test.types, test.assertions, test.proofs, msg.Content)
+		}
+	}
+
+	//The extracted assertions carry their own signatures, such that resolvers can verify them.
+	resolver := tp.CachingResolver("resolver")
+	resolver.ExpectAssertion("mail.ethz.ch.", object.OTIP4Addr,
+		":A: mail ethz.ch. . [ :ip4: 192.0.2.3 ]")
+	resolver.ExpectCached(rainsd.CacheAssertions, "mail", "192.0.2.3")
+}
+
+func TestAnswerForNamesWithDots(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	ethz := tp.AddZone("ethz.ch.", ":A: www.inf [ :ip4: 192.0.2.7 ]")
+	tp.Publish()
+	server := ethz.Servers[0]
+	var removed rainsd.AdminFlushResult
+	server.admin(rainsd.AdminCacheFlush, &removed, rainsd.CacheAssertions, "ethz.ch.")
+	if removed[rainsd.CacheAssertions] == 0 {
+		t.Fatal("No assertions of ethz.ch. were flushed")
+	}
+	//The name is not split at its first dot, as inf.ethz.ch. is not a zone.
+	server.ExpectAssertion("www.inf.ethz.ch.", object.OTIP4Addr,
+		":A: www.inf ethz.ch. . [ :ip4: 192.0.2.7 ]")
+	resolver := tp.CachingResolver("resolver")
+	resolver.ExpectAssertion("www.inf.ethz.ch.", object.OTIP4Addr,
+		":A: www.inf ethz.ch. . [ :ip4: 192.0.2.7 ]")
+}
+
+func TestDenialOfExistence(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.Publish()
+	resolver := tp.CachingResolver("resolver")
+
+	var tests = []struct {
+		node   *Node
+		name   string
+		types  []object.Type
+		denied bool
+	}{
+		{ethz.Servers[0], "ftp.ethz.ch.", []object.Type{object.OTIP4Addr}, true},
+		{ethz.Servers[0], "www.ethz.ch.", []object.Type{object.OTIP6Addr}, true},
+		{ethz.Servers[0], "www.ethz.ch.", []object.Type{object.OTIP4Addr}, false},
+		{ethz.Servers[0], "www.ethz.ch.", []object.Type{object.OTIP4Addr, object.OTIP6Addr}, false},
+		{resolver, "ftp.ethz.ch.", []object.Type{object.OTIP4Addr}, true},
+		{resolver, "www.ethz.ch.", []object.Type{object.OTIP6Addr}, true},
+		{resolver, "www.ethz.ch.", []object.Type{object.OTIP4Addr}, false},
+	}
+	for i, test := range tests {
+		msg, err := test.node.Query(test.name, test.types...)
+		if err != nil {
+			t.Fatalf("%d: query for %s failed: %v", i, test.name, err)
+		}
+		var denial *section.Notification
+		proofs := 0
+		for _, sec := range msg.Content {
+			switch sec := sec.(type) {
+			case *section.Notification:
+				denial = sec
+			case *section.Shard, *section.Pshard, *section.Zone:
+				proofs++
+			}
+		}
+		if !test.denied {
+			if denial != nil {
+				t.Errorf("%d: unexpected notification in answer to %s: %v", i, test.name, denial)
+			}
+			continue
+		}
+		if denial == nil || denial.Type != section.NTNoAssertionsExist || denial.Token != msg.Token {
+			t.Errorf("%d: answer to %s %v has no denial of existence for token %v: %v", i,
+				test.name, test.types, msg.Token, msg.Content)
+		}
+		if proofs == 0 {
+			t.Errorf("%d: denial of existence for %s has no proof: %v", i, test.name, msg.Content)
+		}
+	}
+	for _, n := range resolver.Statistics().Notifications {
+		if n.Type == section.NTBadMessage {
+			t.Errorf("Resolver sent or received bad message notification: %v", n)
+		}
+	}
+}
+
+func TestMinLastHopAnswerSize(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	//No signed assertion fits into an answer of the authoritative server of ethz.ch.
+	tp.MaxLastHopAnswerSize = 100
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.Publish()
+	tp.MaxLastHopAnswerSize = 0
+	resolver := tp.CachingResolver("resolver")
+	minimize := []query.Option{query.QOMinLastHopAnswerSize}
+
+	var tests = []struct {
+		node       *Node
+		opts       []query.Option
+		types      []object.Type
+		assertions int
+		proofs     int
+		truncated  bool
+	}{
+		{ethz.Servers[0], nil, []object.Type{object.OTIP4Addr}, 1, 0, false},
+		{ethz.Servers[0], minimize, []object.Type{object.OTIP4Addr}, 0, 0, true},
+		{resolver, minimize, []object.Type{object.OTIP4Addr}, 1, 0, false},
+		{resolver, minimize, []object.Type{object.OTIP4Addr, object.OTIP6Addr}, 1, 1, false},
+	}
+	for i, test := range tests {
+		msg, err := test.node.QueryWithOptions("www.ethz.ch.", test.opts, test.types...)
+		if err != nil {
+			t.Fatalf("%d: query failed: %v", i, err)
+		}
+		assertions, proofs, truncated := 0, 0, false
+		for _, sec := range msg.Content {
+			switch sec := sec.(type) {
+			case *section.Assertion:
+				assertions++
+			case *section.Shard, *section.Pshard, *section.Zone:
+				proofs++
+			case *section.Notification:
+				truncated = truncated || sec.Type == section.NTMsgTooLarge && sec.Token == msg.Token
+			}
+		}
+		if assertions != test.assertions || proofs != test.proofs || truncated != test.truncated {
+			t.Errorf("%d: wrong answer to query for %v. expected %d assertions, %d proofs and "+
+				"truncated=%v, got %v", i, test.types, test.assertions, test.proofs, test.truncated,
+				msg.Content)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package integration
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/capture"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+)
+
+func TestCapture(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.Publish()
+	tp.Capture = true
+	resolver := tp.CachingResolver("resolver")
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	resolver.Stop()
+	records, err := capture.ReadFile(resolver.CapturePath())
+	if err != nil {
+		t.Fatalf("Was not able to read capture: %v", err)
+	}
+	var captured []string
+	for _, r := range records {
+		msg, err := r.Decode()
+		if err != nil {
+			t.Fatalf("Was not able to decode captured message: %v", err)
+		}
+		captured = append(captured, fmt.Sprintf("%s %v", r.Type, *msg))
+	}
+	var tests = []struct {
+		typ     string
+		content string
+	}{
+		{capture.Query, "NA=www.ethz.ch."},
+		{capture.Response, "OV:192.0.2.1"},
+	}
+	for _, test := range tests {
+		found := false
+		for _, c := range captured {
+			found = found || strings.HasPrefix(c, test.typ) && strings.Contains(c, test.content)
+		}
+		if !found {
+			t.Errorf("No %s containing %s was captured: %v", test.typ, test.content, captured)
+		}
+	}
+}
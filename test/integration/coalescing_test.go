@@ -0,0 +1,83 @@
+package integration
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestQueryCoalescing(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]", ":A: www [ :ip6: 2001:db8::1 ]")
+	tp.Publish()
+	resolver := tp.CachingResolver("resolver")
+
+	//The first forwarded query is held back until all clients wait for an answer.
+	const clients = 8
+	var forwarded int32
+	release := make(chan struct{})
+	resolver.Server.Use(rainsd.BeforeForwarding, func(r *rainsd.Request) bool {
+		for _, sec := range r.Sections {
+			//The delegations needed to verify the answer are looked up separately.
+			if sec.(*query.Name).Name == "www.ethz.ch." &&
+				atomic.AddInt32(&forwarded, 1) == 1 {
+				<-release
+			}
+		}
+		return true
+	})
+	var wg sync.WaitGroup
+	ask := func(types ...object.Type) {
+		defer wg.Done()
+		msg, err := resolver.Query("www.ethz.ch.", types...)
+		if err != nil {
+			t.Errorf("Query for %v failed: %v", types, err)
+			return
+		}
+		for _, sec := range msg.Content {
+			if a, ok := sec.(*section.Assertion); ok && a.FQDN() == "www.ethz.ch." {
+				return
+			}
+		}
+		t.Errorf("Answer to query for %v contains no assertion: %v", types, msg)
+	}
+	wg.Add(1)
+	go ask(object.OTIP4Addr, object.OTIP6Addr)
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&forwarded) == 0; {
+		if time.Now().After(deadline) {
+			close(release)
+			t.Fatal("Query was not forwarded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		if i%2 == 0 {
+			go ask(object.OTIP4Addr)
+		} else {
+			go ask(object.OTIP6Addr, object.OTIP4Addr)
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); resolver.Statistics().
+		Caches["pendingQueries"] < clients+1; {
+		if time.Now().After(deadline) {
+			t.Errorf("Not all clients are waiting. stats=%v", resolver.Statistics().Caches)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&forwarded); n != 1 {
+		t.Errorf("Identical queries were forwarded %d times, want 1", n)
+	}
+}
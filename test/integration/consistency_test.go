@@ -0,0 +1,37 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/publisher"
+)
+
+func TestInconsistentSections(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.Publish()
+	resolver := tp.CachingResolver("resolver")
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+
+	//The zone is signed again with a different address while the cached assertion is still valid.
+	ethz.Records = []string{":A: www [ :ip4: 192.0.2.9 ]"}
+	path, _, err := ethz.zonefile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := []connection.Info{{Type: tp.connectionType(), Addr: resolver.Addr}}
+	if err := publisher.New(ethz.publisherConfig(path, servers)).Publish(); err == nil {
+		t.Error("The resolver accepted sections contradicting its cache")
+	}
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	if dropped := resolver.Statistics().Inconsistencies["ethz.ch."]; dropped != 1 {
+		t.Errorf("expected one inconsistent section of ethz.ch. actual=%d", dropped)
+	}
+}
@@ -0,0 +1,66 @@
+package integration
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+)
+
+func TestDashboard(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.Publish()
+	tp.Dashboard = true
+	resolver := tp.CachingResolver("resolver")
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+
+	url := "http://" + resolver.DashboardAddress()
+	var resp *http.Response
+	var err error
+	for deadline := time.Now().Add(startTimeout); time.Now().Before(deadline); {
+		if resp, err = http.Get(url + "/"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Dashboard is not reachable: %v", err)
+	}
+	page, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "stats") {
+		t.Errorf("Dashboard page not served: status=%d err=%v", resp.StatusCode, err)
+	}
+
+	resp, err = http.Get(url + "/stats")
+	if err != nil {
+		t.Fatalf("Was not able to get statistics: %v", err)
+	}
+	defer resp.Body.Close()
+	var stats rainsd.AdminStatistics
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("Malformed statistics: %v", err)
+	}
+	if stats.Queries["ethz.ch."] == 0 {
+		t.Errorf("Query for www.ethz.ch. not counted. queries=%v", stats.Queries)
+	}
+	if stats.Capacities["assertions"] != 10000 {
+		t.Errorf("Wrong assertion cache capacity. capacities=%v", stats.Capacities)
+	}
+	if len(stats.Peers) == 0 {
+		t.Error("No peers reported")
+	}
+	if resp, err := http.Get(url + "/unknown"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Unknown path served: resp=%v err=%v", resp, err)
+	}
+}
@@ -0,0 +1,123 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestDelegationChain(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.AddZone("inf.ethz.ch.", ":A: www [ :ip4: 192.0.2.2 ]", ":A: www [ :ip6: 2001:db8::2 ]")
+	tp.Publish()
+	resolver := tp.CachingResolver("resolver")
+
+	var tests = []struct {
+		name      string
+		typ       object.Type
+		assertion string
+		value     string
+	}{
+		{"www.inf.ethz.ch.", object.OTIP4Addr, ":A: www inf.ethz.ch. . [ :ip4: 192.0.2.2 ]", "192.0.2.2"},
+		{"www.inf.ethz.ch.", object.OTIP6Addr, ":A: www inf.ethz.ch. . [ :ip6: 2001:db8::2 ]", "2001:db8::2"},
+		{"www.ethz.ch.", object.OTIP4Addr, ":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]", "192.0.2.1"},
+	}
+	for _, test := range tests {
+		resolver.ExpectAssertion(test.name, test.typ, test.assertion)
+		resolver.ExpectCached(rainsd.CacheAssertions, test.value)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	ch := tp.AddZone("ch.")
+	ch.AddServer()
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	ethz.AddServer()
+	tp.Publish()
+	//The servers with the highest priority are down. The lookup continues at the second server of
+	//each zone.
+	ch.Servers[0].Stop()
+	ethz.Servers[0].Stop()
+	resolver := tp.CachingResolver("resolver")
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	resolver.ExpectCached(rainsd.CacheAssertions, "192.0.2.1")
+}
+
+func TestBuiltinRecursion(t *testing.T) {
+	for _, inMemory := range []bool{false, true} {
+		tp := NewTopology(t)
+		defer tp.Close()
+		tp.InMemory = inMemory
+		tp.AddZone(".")
+		tp.AddZone("ch.")
+		tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+		tp.AddZone("inf.ethz.ch.", ":A: www [ :ip4: 192.0.2.2 ]")
+		tp.Publish()
+		tp.BuiltinRecursion = true
+		resolver := tp.CachingResolver("resolver")
+		resolver.ExpectAssertion("www.inf.ethz.ch.", object.OTIP4Addr,
+			":A: www inf.ethz.ch. . [ :ip4: 192.0.2.2 ]")
+		resolver.ExpectCached(rainsd.CacheAssertions, "192.0.2.2")
+		resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+			":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+		resolver.ExpectCached(rainsd.CacheAssertions, "192.0.2.1")
+		tp.stop()
+	}
+}
+
+func TestMultipleObjectTypes(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	ch := tp.AddZone("ch.")
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]", ":A: www [ :ip6: 2001:db8::1 ]",
+		":A: www [ :srv: mail.ethz.ch. 25 0 ]")
+	tp.Publish()
+	resolver := tp.CachingResolver("resolver")
+
+	var tests = []struct {
+		server     *Node
+		name       string
+		types      []object.Type
+		assertions int
+	}{
+		{ethz.Servers[0], "www.ethz.ch.", []object.Type{object.OTIP6Addr}, 1},
+		{ethz.Servers[0], "www.ethz.ch.", []object.Type{object.OTIP4Addr, object.OTIP6Addr}, 2},
+		{ethz.Servers[0], "www.ethz.ch.",
+			[]object.Type{object.OTServiceInfo, object.OTIP6Addr, object.OTIP4Addr}, 3},
+		{ch.Servers[0], "ethz.ch.", []object.Type{object.OTRedirection, object.OTDelegation}, 2},
+	}
+	//The resolver answers the queries for types it has not cached yet by a lookup.
+	for _, viaResolver := range []bool{false, true} {
+		for i, test := range tests {
+			n := test.server
+			if viaResolver {
+				n = resolver
+			}
+			msg, err := n.Query(test.name, test.types...)
+			if err != nil {
+				t.Fatalf("%s %d: query for %s failed: %v", n.Name, i, test.name, err)
+			}
+			assertions := 0
+			for _, sec := range msg.Content {
+				if a, ok := sec.(*section.Assertion); ok && a.FQDN() == test.name {
+					assertions++
+				}
+			}
+			if assertions != test.assertions {
+				t.Errorf("%s %d: wrong answer to query for %s %v. expected %d assertions, got %v",
+					n.Name, i, test.name, test.types, test.assertions, msg.Content)
+			}
+		}
+	}
+}
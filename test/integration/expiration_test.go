@@ -0,0 +1,128 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestExpiration(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.Publish()
+	resolver := tp.CachingResolver("resolver")
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	resolver.ExpectCached(rainsd.CacheAssertions, "192.0.2.1")
+	//The signatures of the topology are valid for a day. Once they expired, the cached assertion
+	//must not be served anymore, neither by the caching resolver nor by the authoritative servers.
+	tp.Advance(25 * time.Hour)
+	tp.QueryTimeout = 2 * time.Second
+	msg, err := resolver.Query("www.ethz.ch.", object.OTIP4Addr)
+	if err != nil {
+		return
+	}
+	for _, s := range msg.Content {
+		if _, ok := s.(*section.Assertion); ok {
+			t.Errorf("Expired assertion was served: %v", msg)
+		}
+	}
+}
+
+func TestServeStale(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.Publish()
+	tp.MaxStaleAnswerAge = 2 * time.Hour
+	resolver := tp.CachingResolver("resolver")
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	//The authority of ethz.ch. becomes unreachable while the cached assertion expires.
+	ethz.Servers[0].Stop()
+	tp.Advance(25 * time.Hour)
+	msg, err := resolver.Query("www.ethz.ch.", object.OTIP4Addr)
+	if err != nil {
+		t.Fatalf("Stale assertion was not served: %v", err)
+	}
+	if len(msg.Content) != 2 {
+		t.Fatalf("Expected the stale assertion and a notification. answer=%v", msg.Content)
+	}
+	if a, ok := msg.Content[0].(*section.Assertion); !ok || a.SubjectName != "www" {
+		t.Errorf("Expected the stale assertion. actual=%v", msg.Content[0])
+	}
+	if n, ok := msg.Content[1].(*section.Notification); !ok || n.Type != section.NTStaleAnswer ||
+		n.Token != msg.Token {
+		t.Errorf("Expected a stale answer notification. actual=%v", msg.Content[1])
+	}
+	//Assertions which expired more than MaxStaleAnswerAge ago are not served anymore.
+	tp.Advance(2 * time.Hour)
+	tp.QueryTimeout = 2 * time.Second
+	msg, err = resolver.Query("www.ethz.ch.", object.OTIP4Addr)
+	if err != nil {
+		return
+	}
+	for _, s := range msg.Content {
+		if _, ok := s.(*section.Assertion); ok {
+			t.Errorf("Assertion expired for too long was served: %v", msg)
+		}
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	//The assertions of ethz.ch. expire long before the delegation to it, such that the refreshed
+	//assertion is not bounded by the delegation key cached together with the original one.
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	ethz.SigValidity = 6 * time.Hour
+	tp.Publish()
+	tp.PrefetchHits = 2
+	tp.PrefetchWindow = 2 * time.Hour
+	resolver := tp.CachingResolver("resolver")
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	//The zones are signed again shortly before the cached assertion expires. The second cache hit
+	//within the prefetch window refreshes it.
+	tp.Advance(5 * time.Hour)
+	tp.Publish()
+	for i := 0; i < 2; i++ {
+		resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+			":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	}
+	if prefetched := resolver.Statistics().Prefetch["prefetched"]; prefetched != 1 {
+		t.Fatalf("Expected one prefetched answer. actual=%d", prefetched)
+	}
+	deadline := time.Now().Add(publishTimeout)
+	for {
+		cached := 0
+		for _, entry := range resolver.Cached(rainsd.CacheAssertions) {
+			if containsAll(entry, []string{"www", "192.0.2.1"}) {
+				cached++
+			}
+		}
+		if cached >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The refreshed assertion was not cached")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	//The refreshed assertion is served from the cache after the original one expired, even
+	//though the authority is not reachable anymore.
+	ethz.Servers[0].Stop()
+	tp.Advance(2 * time.Hour)
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+}
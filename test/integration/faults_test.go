@@ -0,0 +1,91 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+)
+
+func TestLatency(t *testing.T) {
+	const latency = 200 * time.Millisecond
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	proxy := ethz.Servers[0].Proxy(1)
+	proxy.SetFaults(Faults{Latency: latency, Jitter: latency / 2})
+	tp.Publish()
+	resolver := tp.CachingResolver("resolver")
+	start := time.Now()
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	if elapsed := time.Since(start); elapsed < latency {
+		t.Errorf("Lookup took %v, less than the latency of %v", elapsed, latency)
+	}
+	if stats := proxy.Stats(); stats.Connections == 0 {
+		t.Errorf("No connection passed through the proxy: %+v", stats)
+	}
+}
+
+func TestFailoverOnFaults(t *testing.T) {
+	var tests = []struct {
+		name       string
+		faults     Faults
+		hopTimeout time.Duration
+		checkFn    func(ProxyStats) bool
+	}{
+		{"reset", Faults{Reset: 1}, 0, func(s ProxyStats) bool { return s.Reset > 0 }},
+		{"reset during handshake", Faults{Reset: 1, ResetAfter: 64}, 0,
+			func(s ProxyStats) bool { return s.Reset > 0 }},
+		//A black holed connection is only given up after the hop timeout.
+		{"loss", Faults{Loss: 1}, 2 * time.Second, func(s ProxyStats) bool { return s.Lost > 0 }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tp := NewTopology(t)
+			defer tp.Close()
+			tp.AddZone(".")
+			tp.AddZone("ch.")
+			ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+			ethz.AddServer()
+			proxy := ethz.Servers[0].Proxy(1)
+			proxy.SetFaults(test.faults)
+			tp.Publish()
+			resolver := tp.CachingResolver("resolver")
+			resolver.resolver.HopTimeout = test.hopTimeout
+			resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+				":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+			resolver.ExpectCached(rainsd.CacheAssertions, "192.0.2.1")
+			if stats := proxy.Stats(); !test.checkFn(stats) {
+				t.Errorf("Faults were not injected: %+v", stats)
+			}
+		})
+	}
+}
+
+func TestForwarderFanOut(t *testing.T) {
+	const latency = 3 * time.Second
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.Publish()
+	slow := tp.CachingResolver("slow")
+	fast := tp.CachingResolver("fast")
+	slow.Proxy(1).SetFaults(Faults{Latency: latency})
+	//Both upstream resolvers have the answer cached such that only the proxy delays them.
+	for _, n := range []*Node{slow, fast} {
+		n.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr, ":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	}
+	forwarder := tp.Forwarder("forwarder", slow, fast)
+	start := time.Now()
+	forwarder.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	if elapsed := time.Since(start); elapsed >= latency {
+		t.Errorf("Lookup took %v, the forwarders were not queried in parallel", elapsed)
+	}
+}
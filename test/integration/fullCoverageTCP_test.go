@@ -3,7 +3,6 @@ package integration
 import (
 	"bufio"
 	"bytes"
-	"fmt"
 	"io/ioutil"
 	"net"
 	"strings"
@@ -11,37 +10,25 @@ import (
 	"time"
 
 	log "github.com/inconshreveable/log15"
-	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
-	"github.com/netsec-ethz/rains/internal/pkg/publisher"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
-	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/token"
 	"github.com/netsec-ethz/rains/internal/pkg/util"
 	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
-	"github.com/netsec-ethz/rains/tools/keycreator"
 )
 
 func TestFullCoverage(t *testing.T) {
-	h := log.CallerFileHandler(log.StdoutHandler)
-	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
-	//Generate self signed root key
-	keycreator.DelegationAssertion(".", ".", "testdata/keys/selfSignedRootDelegationAssertion.gob", "testdata/keys/privateKeyRoot.txt")
-	//Start authoritative Servers and publish zonefiles to them
-	rootServer := startAuthServer(t, "Root", nil)
-	chServer := startAuthServer(t, "ch", []net.Addr{rootServer.Addr()})
-	ethzChServer := startAuthServer(t, "ethz.ch", []net.Addr{rootServer.Addr()})
+	//Start authoritative servers and publish the zones to them
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	tp.AddZone("ethz.ch.", loadRecords(t, "testdata/zonefiles/ethz.ch.txt")...)
+	tp.Publish()
 	log.Info("all authoritative servers successfully started")
 	//Start client resolver
-	cachingResolver, err := rainsd.New("testdata/conf/resolver.conf", "resolver")
-	if err != nil {
-		t.Fatalf("Was not able to create client resolver: %v", err)
-	}
-	cachingResolver.SetResolver(libresolve.New([]net.Addr{rootServer.Addr()}, nil,
-		libresolve.Recursive, cachingResolver.Addr(), 1000))
-	go cachingResolver.Start(false)
-	time.Sleep(1000 * time.Millisecond)
+	cachingResolver := tp.CachingResolver("resolver")
 	log.Info("caching server successfully started")
 
 	//Send queries to client resolver and observe the recursive lookup results.
@@ -52,55 +39,28 @@ func TestFullCoverage(t *testing.T) {
 	log.Info("successfully decoded answers", "answers", answers)
 	log.Info("begin sending queries which require recursive lookup")
 	for i, query := range queries {
-		sendQueryVerifyResponse(t, *query, cachingResolver.Addr(), answers[i])
+		cachingResolver.ExpectAnswer(*query, answers[i])
 	}
 	log.Info("Done sending queries for recursive lookups")
 
 	//Shut down authoritative servers
-	rootServer.Shutdown()
-	chServer.Shutdown()
-	ethzChServer.Shutdown()
-	time.Sleep(500 * time.Millisecond)
+	for _, z := range []string{".", "ch.", "ethz.ch."} {
+		tp.zones[z].Servers[0].Stop()
+	}
 	log.Info("begin sending queries which should be cached by recursive lookup")
 	for i, query := range queries {
-		sendQueryVerifyResponse(t, *query, cachingResolver.Addr(), answers[i])
+		cachingResolver.ExpectAnswer(*query, answers[i])
 	}
 	log.Info("Done sending queries for cached entries from a recursive lookup")
 
 	//Restart caching resolver from checkpoint
-	time.Sleep(1000 * time.Millisecond) //make sure that caches are checkpointed
-	cachingResolver.Shutdown()
-	cachingResolver2, err := rainsd.New("testdata/conf/resolver2.conf", "resolver2")
-	if err != nil {
-		t.Fatalf("Was not able to create client resolver: %v", err)
-	}
-	go cachingResolver2.Start(false)
-	time.Sleep(500 * time.Millisecond)
-	log.Info("caching server successfully started")
+	cachingResolver2 := cachingResolver.Restart()
+	log.Info("caching server successfully restarted")
 	log.Info("begin sending queries which should be cached by pre load")
 	for i, query := range queries {
-		sendQueryVerifyResponse(t, *query, cachingResolver2.Addr(), answers[i])
+		cachingResolver2.ExpectAnswer(*query, answers[i])
 	}
 	log.Info("Done sending queries for cached entries that are preloaded")
-	cachingResolver2.Shutdown()
-}
-
-func startAuthServer(t *testing.T, name string, rootServers []net.Addr) *rainsd.Server {
-	server, err := rainsd.New("testdata/conf/namingServer"+name+".conf", "nameServer"+name)
-	if err != nil {
-		t.Fatal(fmt.Sprintf("Was not able to create %s server: ", name), err)
-	}
-	server.SetResolver(libresolve.New(rootServers, nil, libresolve.Recursive, server.Addr(), 1000))
-	go server.Start(false)
-	time.Sleep(250 * time.Millisecond)
-	config, err := publisher.LoadConfig("testdata/conf/publisher" + name + ".conf")
-	if err != nil {
-		t.Fatal(fmt.Sprintf("Was not able to load %s publisher config: ", name), err)
-	}
-	pubServer := publisher.New(config)
-	pubServer.Publish()
-	time.Sleep(1000 * time.Millisecond)
-	return server
 }
 
 func loadQueriesAndAnswers(t *testing.T) (string, string) {
@@ -141,13 +101,21 @@ func decodeAnswers(input []byte, t *testing.T) []section.WithSigForward {
 }
 
 func sendQueryVerifyResponse(t *testing.T, query query.Name, connInfo net.Addr,
-	answer section.Section) {
+	timeout time.Duration, answer section.Section) {
+	t.Helper()
 	msg := message.Message{Token: token.New(), Content: []section.Section{&query}}
-	log.Error("Integration test sends query", "msg", msg)
-	answerMsg, err := util.SendQuery(msg, connInfo, time.Second)
+	log.Info("Integration test sends query", "msg", msg)
+	answerMsg, err := util.SendQuery(msg, connInfo, timeout)
 	if err != nil {
 		t.Fatalf("could not send query or receive answer. query=%v err=%v", msg.Content, err)
 	}
+	//A denial of existence is followed by a notification with the query's token.
+	if n := len(answerMsg.Content); n == 2 {
+		if notif, ok := answerMsg.Content[1].(*section.Notification); ok &&
+			notif.Type == section.NTNoAssertionsExist && notif.Token == msg.Token {
+			answerMsg.Content = answerMsg.Content[:1]
+		}
+	}
 	if len(answerMsg.Content) != 1 {
 		t.Fatalf("Got not exactly one answer for the query. msg=%v", answerMsg)
 	}
@@ -0,0 +1,106 @@
+package integration
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestMiddleware(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]", ":A: blocked [ :ip4: 192.0.2.2 ]")
+	tp.Publish()
+	tp.QueryTimeout = 2 * time.Second
+	resolver := tp.CachingResolver("resolver")
+
+	var mux sync.Mutex
+	var calls []string
+	record := func(call string) {
+		mux.Lock()
+		defer mux.Unlock()
+		calls = append(calls, call)
+	}
+	resolver.Server.Use(rainsd.BeforeCacheLookup, func(r *rainsd.Request) bool {
+		for _, sec := range r.Sections {
+			if q := sec.(*query.Name); q.Name == "alias.ethz.ch." {
+				q.Name = "www.ethz.ch."
+			}
+		}
+		return true
+	})
+	resolver.Server.Use(rainsd.BeforeForwarding, func(r *rainsd.Request) bool {
+		if r.Peer != nil {
+			t.Errorf("Forwarded queries have peer %v", r.Peer)
+		}
+		for _, sec := range r.Sections {
+			record("forward " + sec.(*query.Name).Name)
+		}
+		return true
+	})
+	resolver.Server.Use(rainsd.BeforeCaching, func(r *rainsd.Request) bool {
+		for _, sec := range r.Sections {
+			if a, ok := sec.(*section.Assertion); ok && a.SubjectZone == "ethz.ch." {
+				record("cache " + a.FQDN())
+			}
+		}
+		return true
+	})
+	resolver.Server.Use(rainsd.BeforeAnswer, func(r *rainsd.Request) bool {
+		for _, sec := range r.Sections {
+			if a, ok := sec.(*section.Assertion); ok && a.FQDN() == "blocked.ethz.ch." {
+				record("drop " + a.FQDN())
+				return false
+			}
+		}
+		return true
+	})
+	resolver.Server.Use(rainsd.BeforeAnswer, func(r *rainsd.Request) bool {
+		if r.Peer == nil {
+			t.Error("Answer has no peer")
+		}
+		for _, sec := range r.Sections {
+			if a, ok := sec.(*section.Assertion); ok {
+				record("answer " + a.FQDN())
+			}
+		}
+		return true
+	})
+
+	resolver.ExpectAssertion("alias.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	if _, err := resolver.Query("blocked.ethz.ch.", object.OTIP4Addr); err == nil {
+		t.Error("Answer dropped by a middleware was sent")
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	var tests = []struct {
+		call string
+		want bool
+	}{
+		{"forward www.ethz.ch.", true},
+		{"forward alias.ethz.ch.", false},
+		{"cache www.ethz.ch.", true},
+		{"answer www.ethz.ch.", true},
+		{"cache blocked.ethz.ch.", true},
+		{"drop blocked.ethz.ch.", true},
+		{"answer blocked.ethz.ch.", false},
+	}
+	for _, test := range tests {
+		found := false
+		for _, call := range calls {
+			found = found || call == test.call
+		}
+		if found != test.want {
+			t.Errorf("Middleware call %q found=%t, want %t. calls=%v", test.call, found, test.want,
+				calls)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+)
+
+func TestMirror(t *testing.T) {
+	var tests = []struct {
+		name     string
+		shadowIP string
+		counter  string
+	}{
+		{"identical", "192.0.2.1", rainsd.MirrorIdentical},
+		{"different", "192.0.2.2", rainsd.MirrorDifferent},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shadowTp := NewTopology(t)
+			defer shadowTp.Close()
+			shadowTp.AddZone(".")
+			shadowTp.AddZone("ch.")
+			shadowTp.AddZone("ethz.ch.", ":A: www [ :ip4: "+test.shadowIP+" ]")
+			shadowTp.Publish()
+			shadow := shadowTp.CachingResolver("shadow")
+
+			tp := NewTopology(t)
+			defer tp.Close()
+			tp.AddZone(".")
+			tp.AddZone("ch.")
+			tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+			tp.Publish()
+			resolver := tp.MirroringResolver("resolver", shadow)
+			resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+				":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+			//The shadow resolves the mirrored query independently of the resolver's answer.
+			deadline := time.Now().Add(tp.QueryTimeout)
+			stats := resolver.Statistics()
+			for stats.Mirror[test.counter] == 0 {
+				if time.Now().After(deadline) {
+					t.Fatalf("Mirrored query was not compared. stats=%v", stats.Mirror)
+				}
+				time.Sleep(100 * time.Millisecond)
+				stats = resolver.Statistics()
+			}
+			if stats.Mirror[rainsd.MirrorSent] != 1 || stats.Mirror[rainsd.MirrorFailed] != 0 ||
+				stats.Mirror[rainsd.MirrorDropped] != 0 {
+				t.Errorf("Wrong mirror statistics: %v", stats.Mirror)
+			}
+		})
+	}
+}
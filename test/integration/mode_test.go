@@ -0,0 +1,53 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/publisher"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestOperatingModes(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	ch := tp.AddZone("ch.", ":A: www [ :ip4: 192.0.2.5 ]")
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.Publish()
+	resolver := tp.CachingResolver("resolver")
+	forwarder := tp.ConfiguredForwarder("forwarder", resolver)
+	forwarder.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	if mode := forwarder.Statistics().Mode; mode != rainsd.ModeForwarder {
+		t.Errorf("unexpected mode of forwarder. expected=%s actual=%s", rainsd.ModeForwarder, mode)
+	}
+
+	server := ethz.Servers[0]
+	if mode := server.Statistics().Mode; mode != rainsd.ModeAuthoritative {
+		t.Errorf("unexpected mode of server. expected=%s actual=%s", rainsd.ModeAuthoritative, mode)
+	}
+	//Queries for other zones are neither forwarded nor left unanswered.
+	msg, err := server.Query("www.ch.", object.OTIP4Addr)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(msg.Content) != 1 {
+		t.Fatalf("expected a notification. actual=%v", msg.Content)
+	}
+	if n, ok := msg.Content[0].(*section.Notification); !ok || n.Type != section.NTNoAssertionAvail {
+		t.Fatalf("expected a no assertion available notification. actual=%v", msg.Content[0])
+	}
+	//Sections of other zones pushed to the server are not cached.
+	path, _, err := ch.zonefile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := []connection.Info{{Type: tp.connectionType(), Addr: server.Addr}}
+	if err := publisher.New(ch.publisherConfig(path, servers)).Publish(); err != nil {
+		t.Fatal(err)
+	}
+	server.ExpectNotCached("assertions", "192.0.2.5")
+}
@@ -0,0 +1,91 @@
+package integration
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/capture"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestNoProactiveCaching(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.Publish()
+	resolver := tp.CachingResolver("resolver")
+	msg, err := resolver.QueryWithOptions("www.ethz.ch.",
+		[]query.Option{query.QONoProactiveCaching}, object.OTIP4Addr)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(msg.Content) != 1 {
+		t.Fatalf("expected the assertion as answer. actual=%v", msg.Content)
+	}
+	if _, ok := msg.Content[0].(*section.Assertion); !ok {
+		t.Fatalf("expected the assertion as answer. actual=%v", msg.Content)
+	}
+	resolver.ExpectNotCached(rainsd.CacheAssertions, "www", "192.0.2.1")
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	resolver.ExpectCached(rainsd.CacheAssertions, "www", "192.0.2.1")
+}
+
+func TestMinInfoLeakage(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.Capture = true
+	root := tp.AddZone(".")
+	ch := tp.AddZone("ch.")
+	tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.Publish()
+	tp.Capture = false
+	resolver := tp.CachingResolver("resolver")
+	msg, err := resolver.QueryWithOptions("www.ethz.ch.",
+		[]query.Option{query.QOMinInfoLeakage}, object.OTIP4Addr)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(msg.Content) != 1 {
+		t.Fatalf("expected the assertion as answer. actual=%v", msg.Content)
+	}
+
+	var tests = []struct {
+		node     *Node
+		expected string
+	}{
+		{root.Servers[0], "NA=ch."},
+		{ch.Servers[0], "NA=ethz.ch."},
+	}
+	for _, test := range tests {
+		test.node.Stop()
+		records, err := capture.ReadFile(test.node.CapturePath())
+		if err != nil {
+			t.Fatalf("Was not able to read capture of %s: %v", test.node.Name, err)
+		}
+		found := false
+		for _, r := range records {
+			if r.Type != capture.Query {
+				continue
+			}
+			msg, err := r.Decode()
+			if err != nil {
+				t.Fatalf("Was not able to decode captured message: %v", err)
+			}
+			q := fmt.Sprintf("%v", *msg)
+			if strings.Contains(q, "www") {
+				t.Errorf("%s received the full name: %s", test.node.Name, q)
+			}
+			found = found || strings.Contains(q, test.expected)
+		}
+		if !found {
+			t.Errorf("%s received no query containing %s", test.node.Name, test.expected)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package integration
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+//Faults describes the faults a Proxy injects into the connections it forwards. The proxy forwards
+//a TCP stream which carries TLS, so faults affect connections as a whole or delay their data.
+//Reordering of single packets is invisible to the servers. Messages sent over different
+//connections, however, overtake each other if the connections have a different latency.
+type Faults struct {
+	//Latency delays each chunk of data forwarded in either direction.
+	Latency time.Duration
+	//Jitter adds a random delay between zero and Jitter to the latency of each connection.
+	Jitter time.Duration
+	//Loss is the probability that a connection is black holed, i.e. it is accepted but no data is
+	//forwarded as if all its packets were lost.
+	Loss float64
+	//Reset is the probability that a connection is reset after ResetAfter bytes have been
+	//forwarded towards the server. If ResetAfter is zero, the connection is reset right away.
+	Reset      float64
+	ResetAfter int
+}
+
+//ProxyStats counts the connections handled by a Proxy.
+type ProxyStats struct {
+	Connections int
+	Lost        int
+	Reset       int
+}
+
+//Proxy forwards the connections it accepts to a server and injects faults into them. The faults
+//of each connection are drawn from a random source with a fixed seed in the order in which the
+//connections are accepted, such that a test sees the same faults in every run.
+type Proxy struct {
+	Addr   net.Addr
+	target net.Addr
+
+	listener net.Listener
+	mux      sync.Mutex
+	faults   Faults
+	random   *rand.Rand
+	stats    ProxyStats
+	conns    map[net.Conn]bool
+	closed   bool
+}
+
+//connFaults are the faults injected into a single connection. resetAfter is negative if the
+//connection is not reset.
+type connFaults struct {
+	latency    time.Duration
+	lost       bool
+	resetAfter int
+}
+
+//Proxy puts a proxy in front of n and returns it. The delegations and root server addresses of
+//the topology point to the proxy instead of n, such that all queries of other servers to n pass
+//through it. It must be called before the topology is published.
+func (n *Node) Proxy(seed int64) *Proxy {
+	t := n.topology.t
+	t.Helper()
+	if n.topology.InMemory {
+		t.Fatalf("A proxy cannot be put in front of in-memory server %s", n.Name)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Was not able to start proxy for %s: %v", n.Name, err)
+	}
+	p := &Proxy{
+		Addr:     listener.Addr(),
+		target:   n.Addr,
+		listener: listener,
+		random:   rand.New(rand.NewSource(seed)),
+		conns:    make(map[net.Conn]bool),
+	}
+	n.proxy = p
+	n.topology.addCleanup(p.Close)
+	go p.serve()
+	log.Info("Proxy started", "addr", p.Addr, "target", n.Addr)
+	return p
+}
+
+//SetFaults changes the faults injected into connections accepted from now on.
+func (p *Proxy) SetFaults(faults Faults) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.faults = faults
+}
+
+//Stats returns the number of connections handled so far.
+func (p *Proxy) Stats() ProxyStats {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.stats
+}
+
+//Close stops the proxy and closes all its connections.
+func (p *Proxy) Close() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	p.listener.Close()
+	for conn := range p.conns {
+		conn.Close()
+	}
+}
+
+func (p *Proxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		if faults, ok := p.accept(conn); ok {
+			go p.forward(conn, faults)
+		}
+	}
+}
+
+//accept registers conn and draws its faults. It returns false if the proxy has been closed.
+func (p *Proxy) accept(conn net.Conn) (connFaults, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.closed {
+		conn.Close()
+		return connFaults{}, false
+	}
+	p.conns[conn] = true
+	p.stats.Connections++
+	//The same number of values is drawn for every connection such that the faults of a connection
+	//do not depend on the faults configured for earlier ones.
+	jitter, loss, reset := p.random.Int63(), p.random.Float64(), p.random.Float64()
+	faults := connFaults{
+		latency:    p.faults.Latency,
+		lost:       loss < p.faults.Loss,
+		resetAfter: -1,
+	}
+	if p.faults.Jitter > 0 {
+		faults.latency += time.Duration(jitter % int64(p.faults.Jitter+1))
+	}
+	if faults.lost {
+		p.stats.Lost++
+	} else if reset < p.faults.Reset {
+		faults.resetAfter = p.faults.ResetAfter
+		p.stats.Reset++
+	}
+	return faults, true
+}
+
+//forward relays the data of conn to and from the target while injecting faults.
+func (p *Proxy) forward(conn net.Conn, faults connFaults) {
+	defer p.release(conn)
+	if faults.lost {
+		io.Copy(ioutil.Discard, conn)
+		return
+	}
+	if faults.resetAfter == 0 {
+		reset(conn)
+		return
+	}
+	server, err := net.Dial(p.target.Network(), p.target.String())
+	if err != nil {
+		log.Warn("Proxy could not reach its target", "target", p.target, "error", err)
+		return
+	}
+	p.mux.Lock()
+	p.conns[server] = true
+	p.mux.Unlock()
+	defer p.release(server)
+	done := make(chan bool, 2)
+	go func() {
+		relay(server, conn, faults.latency, faults.resetAfter)
+		done <- true
+	}()
+	go func() {
+		relay(conn, server, faults.latency, -1)
+		done <- true
+	}()
+	//The connection is closed towards both sides as soon as one direction ends.
+	<-done
+	if faults.resetAfter > 0 {
+		reset(conn)
+		reset(server)
+	} else {
+		conn.Close()
+		server.Close()
+	}
+	<-done
+}
+
+//relay copies the data read from src to dst. Each chunk is delayed by latency. After resetAfter
+//bytes, the relay stops. The limit is ignored if resetAfter is negative.
+func relay(dst, src net.Conn, latency time.Duration, resetAfter int) {
+	buf := make([]byte, 4096)
+	forwarded := 0
+	for {
+		n, err := src.Read(buf)
+		if resetAfter >= 0 && forwarded+n > resetAfter {
+			n = resetAfter - forwarded
+		}
+		if n > 0 {
+			time.Sleep(latency)
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+			forwarded += n
+		}
+		if err != nil || forwarded == resetAfter {
+			return
+		}
+	}
+}
+
+//reset closes conn such that its peer receives a TCP reset instead of a regular end of stream.
+func reset(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
+
+func (p *Proxy) release(conn net.Conn) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	delete(p.conns, conn)
+	conn.Close()
+}
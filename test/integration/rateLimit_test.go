@@ -0,0 +1,46 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestResponseRateLimiting(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]", ":A: mail [ :ip4: 192.0.2.3 ]")
+	tp.Publish()
+	tp.ResponsesPerSecond = 1
+	tp.RateLimitSlip = 1
+	resolver := tp.CachingResolver("resolver")
+	tp.ResponsesPerSecond, tp.RateLimitSlip = 0, 0
+
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	msg, err := resolver.Query("www.ethz.ch.", object.OTIP4Addr)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(msg.Content) != 1 {
+		t.Fatalf("expected a notification. actual=%v", msg.Content)
+	}
+	if n, ok := msg.Content[0].(*section.Notification); !ok || n.Type != section.NTRateLimited {
+		t.Fatalf("expected a rate limited notification. actual=%v", msg.Content[0])
+	}
+	//Answers for other names are accounted separately.
+	resolver.ExpectAssertion("mail.ethz.ch.", object.OTIP4Addr,
+		":A: mail ethz.ch. . [ :ip4: 192.0.2.3 ]")
+	tp.Advance(2 * time.Second)
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	if stats := resolver.Statistics().RateLimit; stats[rainsd.RateLimitSlipped] != 1 ||
+		stats[rainsd.RateLimitDropped] != 0 {
+		t.Errorf("expected one slipped answer. stats=%v", stats)
+	}
+}
@@ -0,0 +1,126 @@
+package integration
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+)
+
+func TestConfigReload(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]", ":A: ftp [ :ip4: 192.0.2.2 ]")
+	tp.Publish()
+	tp.BuiltinRecursion = true
+	resolver := tp.CachingResolver("resolver")
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	resolver.ExpectAssertion("ftp.ethz.ch.", object.OTIP4Addr,
+		":A: ftp ethz.ch. . [ :ip4: 192.0.2.2 ]")
+
+	certPath, keyPath := filepath.Join(tp.dir, "server.crt"), filepath.Join(tp.dir, "server.key")
+	for src, dst := range map[string]string{"testdata/cert/server.crt": certPath,
+		"testdata/cert/server.key": keyPath} {
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(dst, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//The backup root server is never asked as the first one answers.
+	roots := append(append([]string{}, resolver.rootServers...), "127.0.0.1:1")
+	reconfigure(t, resolver, map[string]interface{}{"AssertionCacheSize": 2,
+		"TLSCertificateFile": certPath, "TLSPrivateKeyFile": keyPath, "RootServers": roots})
+	var applied []string
+	resolver.admin(rainsd.AdminReload, &applied)
+	expected := []string{"AssertionCacheSize", "TLSCertificateFile", "TLSPrivateKeyFile",
+		"RootServers"}
+	if !reflect.DeepEqual(applied, expected) {
+		t.Errorf("wrong settings applied. expected=%v actual=%v", expected, applied)
+	}
+	applied = nil
+	if resolver.admin(rainsd.AdminReload, &applied); len(applied) != 0 {
+		t.Errorf("unchanged settings were applied. applied=%v", applied)
+	}
+	var stats rainsd.AdminStatistics
+	resolver.admin(rainsd.AdminStats, &stats)
+	if stats.Capacities[rainsd.CacheAssertions] != 2 || stats.Caches[rainsd.CacheAssertions] >= 2 {
+		t.Errorf("assertion cache was not resized. capacities=%v caches=%v", stats.Capacities,
+			stats.Caches)
+	}
+	resolver.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+
+	//Invalid settings are rejected before any setting is applied.
+	var tests = []struct {
+		setting string
+		invalid interface{}
+	}{
+		{"RootServers", []string{"invalid"}},
+		{"Blacklist", []string{"192.0.2.300"}},
+		{"ACL", map[string][]string{"Query": []string{"192.0.2.0/33"}}},
+		{"LogLevel", "verbose"},
+		{"NegativeAssertionCacheSize", -1},
+		{"TLSCertificateFile", filepath.Join(tp.dir, "missing.crt")},
+		{"Mode", rainsd.ModeForwarder},
+	}
+	//valid contains the settings restored after each test.
+	valid := map[string]interface{}{"RootServers": roots, "Blacklist": nil, "ACL": nil,
+		"LogLevel": "", "NegativeAssertionCacheSize": 1000, "TLSCertificateFile": certPath,
+		"Mode": rainsd.ModeRecursive}
+	for i, test := range tests {
+		config := map[string]interface{}{"AssertionCacheSize": 100,
+			"Blacklist": []string{"198.51.100.1"}, test.setting: test.invalid}
+		if test.setting == "Mode" {
+			config["Forwarders"] = []string{"192.0.2.1:5022"}
+		}
+		reconfigure(t, resolver, config)
+		if err := resolver.adminCall(rainsd.AdminReload, &applied); err == nil {
+			t.Errorf("%d: configuration with an invalid %s was applied", i, test.setting)
+		}
+		resolver.admin(rainsd.AdminStats, &stats)
+		if stats.Capacities[rainsd.CacheAssertions] != 2 {
+			t.Errorf("%d: rejected configuration was applied. capacities=%v", i,
+				stats.Capacities)
+		}
+		var blacklist []string
+		if resolver.admin(rainsd.AdminBlacklistList, &blacklist); len(blacklist) != 0 {
+			t.Errorf("%d: blacklist of rejected configuration was applied. blacklist=%v", i,
+				blacklist)
+		}
+		reconfigure(t, resolver, map[string]interface{}{test.setting: valid[test.setting],
+			"Blacklist": nil, "Forwarders": nil})
+	}
+}
+
+//reconfigure changes the entries of the configuration file of n to the values of changes.
+func reconfigure(t *testing.T, n *Node, changes map[string]interface{}) {
+	t.Helper()
+	path := filepath.Join(n.topology.dir, n.Name+".conf")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Was not able to read config of %s: %v", n.Name, err)
+	}
+	config := make(map[string]interface{})
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatalf("Was not able to decode config of %s: %v", n.Name, err)
+	}
+	for key, value := range changes {
+		config[key] = value
+	}
+	if data, err = json.Marshal(config); err != nil {
+		t.Fatalf("Was not able to encode config of %s: %v", n.Name, err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Was not able to write config of %s: %v", n.Name, err)
+	}
+}
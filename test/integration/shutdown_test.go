@@ -0,0 +1,45 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	const latency = 200 * time.Millisecond
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	ethz.Servers[0].Proxy(1).SetFaults(Faults{Latency: latency})
+	tp.Publish()
+	resolver := tp.CachingResolver("resolver")
+
+	answers := make(chan message.Message, 1)
+	go func() {
+		msg, err := resolver.Query("www.ethz.ch.", object.OTIP4Addr)
+		if err != nil {
+			t.Errorf("query in flight during shutdown failed: %v", err)
+		}
+		answers <- msg
+	}()
+	//Stop the resolver while it waits for the delayed authoritative server.
+	time.Sleep(latency / 2)
+	resolver.Stop()
+	msg := <-answers
+	if len(msg.Content) != 1 {
+		t.Fatalf("expected the assertion in the answer. actual=%v", msg.Content)
+	}
+	if _, ok := msg.Content[0].(*section.Assertion); !ok {
+		t.Errorf("expected an assertion. actual=%v", msg.Content[0])
+	}
+
+	if _, err := resolver.Query("www.ethz.ch.", object.OTIP4Addr); err == nil {
+		t.Errorf("stopped server accepted a connection")
+	}
+}
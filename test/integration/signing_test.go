@@ -0,0 +1,55 @@
+package integration
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestAnswerSigning(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.Publish()
+	unsigned := tp.CachingResolver("unsigned")
+	tp.InfrastructureKeyPath = filepath.Join(tp.dir, "infrastructureKey.txt")
+	publicKey := generateZoneKey(t, tp.InfrastructureKeyPath)
+	resolver := tp.CachingResolver("resolver")
+	otherKey := generateZoneKey(t, filepath.Join(tp.dir, "otherKey.txt"))
+	id := keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519, KeySpace: keys.RainsKeySpace,
+		KeyPhase: 1}
+	pkeys := func(key ed25519.PublicKey) map[keys.PublicKeyID][]keys.PublicKey {
+		return map[keys.PublicKeyID][]keys.PublicKey{id: {{PublicKeyID: id, ValidUntil: 1 << 62,
+			Key: key}}}
+	}
+
+	//The second answer is served from the cache and signed as well.
+	for i := 0; i < 2; i++ {
+		msg, err := resolver.Query("www.ethz.ch.", object.OTIP4Addr)
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		if len(msg.Signatures) != 1 || !siglib.CheckMessageSignatures(&msg, pkeys(publicKey)) {
+			t.Errorf("%d: answer is not signed with the infrastructure key. actual=%v", i,
+				msg.Signatures)
+		}
+		if siglib.CheckMessageSignatures(&msg, pkeys(otherKey)) {
+			t.Errorf("%d: answer verified with a different key", i)
+		}
+	}
+
+	msg, err := unsigned.Query("www.ethz.ch.", object.OTIP4Addr)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(msg.Signatures) != 0 {
+		t.Errorf("expected an unsigned answer. actual=%v", msg.Signatures)
+	}
+}
@@ -0,0 +1,31 @@
+package integration
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+)
+
+func TestInMemorySimulation(t *testing.T) {
+	const tlds, slds = 8, 25
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.InMemory = true
+	tp.AddZone(".")
+	for i := 0; i < tlds; i++ {
+		tld := fmt.Sprintf("tld%d.", i)
+		tp.AddZone(tld)
+		for j := 0; j < slds; j++ {
+			tp.AddZone(fmt.Sprintf("sld%d.%s", j, tld),
+				fmt.Sprintf(":A: www [ :ip4: 192.0.%d.%d ]", i, j))
+		}
+	}
+	tp.Publish()
+	resolver := tp.CachingResolver("resolver")
+	for i := 0; i < tlds; i++ {
+		j := (i * 7) % slds
+		resolver.ExpectAssertion(fmt.Sprintf("www.sld%d.tld%d.", j, i), object.OTIP4Addr,
+			fmt.Sprintf(":A: www sld%d.tld%d. . [ :ip4: 192.0.%d.%d ]", j, i, i, j))
+	}
+}
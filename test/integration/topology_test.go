@@ -0,0 +1,941 @@
+package integration
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/publisher"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+	"github.com/netsec-ethz/rains/tools/keycreator"
+)
+
+const (
+	//startTimeout is the time a server is given to start listening.
+	startTimeout = 5 * time.Second
+	//publishTimeout is the time an authoritative server is given to verify and cache a zone.
+	publishTimeout = 10 * time.Second
+	//adminTimeout is the time a server is given to answer a request on its admin socket.
+	adminTimeout = time.Second
+	//stopTimeout is the time a stopped server is given to process the queries it has received.
+	stopTimeout = time.Second
+)
+
+//memServers is the number of in-memory servers started by all topologies of the test binary.
+var memServers int32
+
+//Topology is a set of rainsd servers running on ephemeral ports of the loopback interface. Zones
+//are added together with their authoritative servers and published with Publish, which also adds
+//the delegations of all zones to their parent zone. The caching resolvers of a topology resolve
+//recursively starting at the servers of the root zone. All servers are stopped and all files
+//created for the topology are removed at the end of the test.
+type Topology struct {
+	//QueryTimeout is the time a client waits for the answer to a query.
+	QueryTimeout time.Duration
+	//Capture, if set, lets all servers started afterwards record the queries they receive and the
+	//answers they send to the file returned by Node.CapturePath.
+	Capture bool
+	//Dashboard, if set, lets all servers started afterwards serve the dashboard on the address
+	//returned by Node.DashboardAddress.
+	Dashboard bool
+	//BuiltinRecursion, if set, lets all caching resolvers started afterwards resolve queries with
+	//the recursive resolver rainsd creates from the RootServers of its config instead of one set
+	//by the topology. It must be set after publishing, such that the root servers are known.
+	BuiltinRecursion bool
+	//MaxStaleAnswerAge, if set, lets all servers started afterwards answer with assertions which
+	//expired less than MaxStaleAnswerAge ago if they cannot obtain a fresh answer.
+	MaxStaleAnswerAge time.Duration
+	//PrefetchHits and PrefetchWindow, if set, let all servers started afterwards refresh answers
+	//with at least PrefetchHits cache hits within PrefetchWindow of their expiration.
+	PrefetchHits   int
+	PrefetchWindow time.Duration
+	//MaxLastHopAnswerSize, if set, limits the answers of all servers started afterwards to queries
+	//with the option to minimize the last-hop answer size to MaxLastHopAnswerSize bytes.
+	MaxLastHopAnswerSize int
+	//ACL, if set, restricts the addresses from which all servers started afterwards accept
+	//queries and sections. It maps Query, Push and Publish to lists of networks in CIDR notation.
+	ACL map[string][]string
+	//ResponsesPerSecond and RateLimitSlip, if set, let all servers started afterwards limit the
+	//rate of their answers per client prefix and names.
+	ResponsesPerSecond float64
+	RateLimitSlip      int
+	//UpstreamRetries and UpstreamRetryBackoff, if set, let the resolvers rainsd creates from the
+	//config of all servers started afterwards ask upstream servers which did not answer again.
+	UpstreamRetries      int
+	UpstreamRetryBackoff time.Duration
+	//InfrastructureKeyPath, if set, lets all servers started afterwards sign their answers with
+	//the private key of key phase 1 stored at InfrastructureKeyPath, e.g. by generateZoneKey.
+	InfrastructureKeyPath string
+	//InMemory, if set before the first zone is added, connects all servers, publishers and
+	//resolvers of the topology with in-memory connections instead of TLS over TCP.
+	InMemory bool
+	t        *testing.T
+	dir      string
+	//rootKeyPath is the path to the self signed delegation assertion of the root zone.
+	rootKeyPath string
+	zones       map[string]*Zone
+	nodes       []*Node
+	//clock is the clock of all servers, caches and publishers while the topology exists.
+	clock *clock.Shifted
+	//cleanups are called in reverse order when the topology is closed.
+	cleanups []func()
+}
+
+//Zone is a zone of a topology together with its authoritative servers.
+type Zone struct {
+	//Name is the fully qualified name of the zone, e.g. ethz.ch.
+	Name string
+	//Records contains the zone's assertions in zonefile format with names relative to the zone,
+	//e.g. ':A: www [ :ip4: 192.0.2.1 ]'. Delegations to child zones are added when publishing.
+	Records []string
+	//Servers are the authoritative servers of the zone in decreasing order of priority.
+	Servers []*Node
+	//SigValidity, if set, is the time for which the signatures of the zone are valid after it is
+	//published instead of a day.
+	SigValidity time.Duration
+	topology    *Topology
+	keyPath     string
+	publicKey   ed25519.PublicKey
+}
+
+//Node is a rainsd server of a topology.
+type Node struct {
+	Name     string
+	Server   *rainsd.Server
+	Addr     net.Addr
+	topology *Topology
+	//zone is the zone over which the server has authority. It is empty for caching resolvers.
+	zone        string
+	resolver    *libresolve.Resolver
+	adminSocket string
+	checkpoints string
+	capturePath string
+	dashboard   string
+	//rootServers are the root servers in the node's config. They are only set for caching
+	//resolvers using the recursive resolver of rainsd.
+	rootServers []string
+	//forwarders are the upstream resolvers in the node's config. They are only set for forwarders
+	//using the forwarding resolver of rainsd.
+	forwarders []string
+	stopped    bool
+	//proxy, if not nil, is advertised to other servers instead of the server itself.
+	proxy *Proxy
+	//shadow, if not nil, is the server to which n mirrors the queries it receives.
+	shadow *Node
+	//zoneFiles are the zone files which the server loads at startup.
+	zoneFiles []map[string]interface{}
+}
+
+//NewTopology returns an empty topology with a newly generated root key. The test must close it
+//when done.
+func NewTopology(t *testing.T) *Topology {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	dir, err := ioutil.TempDir("", "rainsTopology")
+	if err != nil {
+		t.Fatalf("Was not able to create the topology's directory: %v", err)
+	}
+	tp := &Topology{
+		QueryTimeout: 10 * time.Second,
+		t:            t,
+		dir:          dir,
+		zones:        make(map[string]*Zone),
+		clock:        &clock.Shifted{},
+	}
+	tp.addCleanup(func() { os.RemoveAll(dir) })
+	tp.addCleanup(clock.Set(tp.clock))
+	tp.rootKeyPath = filepath.Join(tp.dir, "rootDelegationAssertion.gob")
+	if err := keycreator.DelegationAssertion(".", ".", tp.rootKeyPath,
+		filepath.Join(tp.dir, "privateKeyRoot.txt")); err != nil {
+		tp.Close()
+		t.Fatalf("Was not able to generate the root key: %v", err)
+	}
+	tp.addCleanup(tp.stop)
+	return tp
+}
+
+//Close stops all servers and proxies of the topology, restores the clock and removes its
+//directory.
+func (tp *Topology) Close() {
+	for i := len(tp.cleanups) - 1; i >= 0; i-- {
+		tp.cleanups[i]()
+	}
+	tp.cleanups = nil
+}
+
+//addCleanup registers f to be called when the topology is closed.
+func (tp *Topology) addCleanup(f func()) {
+	tp.cleanups = append(tp.cleanups, f)
+}
+
+//Advance moves the clock of the topology forward by d, e.g. to let signatures and cache entries
+//expire without waiting.
+func (tp *Topology) Advance(d time.Duration) {
+	tp.clock.Advance(d)
+}
+
+//AddZone adds the zone name with the given records to the topology and starts its first
+//authoritative server.
+func (tp *Topology) AddZone(name string, records ...string) *Zone {
+	tp.t.Helper()
+	if _, ok := tp.zones[name]; ok {
+		tp.t.Fatalf("zone %s has already been added", name)
+	}
+	z := &Zone{Name: name, Records: records, topology: tp}
+	if name == "." {
+		z.keyPath = filepath.Join(tp.dir, "privateKeyRoot.txt")
+	} else {
+		z.keyPath = filepath.Join(tp.dir, "privateKey-"+label(name)+".txt")
+		z.publicKey = generateZoneKey(tp.t, z.keyPath)
+	}
+	tp.zones[name] = z
+	z.AddServer()
+	return z
+}
+
+//AddServer starts an additional authoritative server for z. It must be called before the zone is
+//published.
+func (z *Zone) AddServer() *Node {
+	z.topology.t.Helper()
+	n := z.topology.startNode(fmt.Sprintf("ns%d.%s", len(z.Servers)+1, label(z.Name)), z.Name,
+		false, "", nil)
+	z.Servers = append(z.Servers, n)
+	return n
+}
+
+//Publish publishes all zones to their authoritative servers, parent zones before their children,
+//and waits until the servers have verified and cached them. The zones of the same depth are
+//published concurrently.
+func (tp *Topology) Publish() {
+	tp.t.Helper()
+	for _, n := range tp.nodes {
+		if n.resolver != nil {
+			n.resolver.RootNameServers = tp.rootServers()
+		}
+	}
+	zones := make([]*Zone, 0, len(tp.zones))
+	for _, z := range tp.zones {
+		if z.Name != "." {
+			if _, ok := tp.zones[parentZone(z.Name)]; !ok {
+				tp.t.Fatalf("parent zone of %s is missing", z.Name)
+			}
+		}
+		zones = append(zones, z)
+	}
+	sort.Slice(zones, func(i, j int) bool {
+		if di, dj := depth(zones[i].Name), depth(zones[j].Name); di != dj {
+			return di < dj
+		}
+		return zones[i].Name < zones[j].Name
+	})
+	for len(zones) > 0 {
+		level := 0
+		for level < len(zones) && depth(zones[level].Name) == depth(zones[0].Name) {
+			level++
+		}
+		errs := make([]error, level)
+		var wg sync.WaitGroup
+		for i, z := range zones[:level] {
+			wg.Add(1)
+			go func(i int, z *Zone) {
+				defer wg.Done()
+				errs[i] = z.publish()
+			}(i, z)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				tp.t.Fatal(err)
+			}
+		}
+		zones = zones[level:]
+	}
+}
+
+//publish stores z together with the delegations to its child zones in a zonefile and publishes it.
+func (z *Zone) publish() error {
+	path, content, err := z.zonefile()
+	if err != nil {
+		return err
+	}
+	var servers []connection.Info
+	for _, n := range z.Servers {
+		servers = append(servers, connection.Info{Type: z.topology.connectionType(), Addr: n.Addr})
+	}
+	var opts []publisher.Option
+	if z.topology.InMemory {
+		opts = append(opts, publisher.WithDialer(connection.MemDialer{}))
+	}
+	if err := publisher.New(z.publisherConfig(path, servers), opts...).Publish(); err != nil {
+		return fmt.Errorf("Was not able to publish zone %s: %v", z.Name, err)
+	}
+	for _, n := range z.Servers {
+		if err := n.waitForAssertions(strings.Count(content, ":A:")); err != nil {
+			return err
+		}
+	}
+	log.Info("Zone published", "zone", z.Name, "servers", len(z.Servers))
+	return nil
+}
+
+//zonefile stores z together with the delegations to its child zones in a zonefile and returns its
+//path and content.
+func (z *Zone) zonefile() (string, string, error) {
+	records := append([]string{}, z.Records...)
+	for _, child := range z.topology.zones {
+		if child.Name != "." && parentZone(child.Name) == z.Name {
+			records = append(records, child.delegation()...)
+		}
+	}
+	path := filepath.Join(z.topology.dir, "zonefile-"+label(z.Name)+".txt")
+	content := fmt.Sprintf(":Z: %s . [\n    %s\n]\n", z.Name, strings.Join(records, "\n    "))
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		return "", "", fmt.Errorf("Was not able to store zonefile of %s: %v", z.Name, err)
+	}
+	return path, content, nil
+}
+
+//publisherConfig returns the config with which the zonefile of z at path is signed and published
+//to servers.
+func (z *Zone) publisherConfig(path string, servers []connection.Info) publisher.Config {
+	now := clock.Now()
+	validity := 24 * time.Hour
+	if z.SigValidity > 0 {
+		validity = z.SigValidity
+	}
+	return publisher.Config{
+		ZonefilePath:   path,
+		AuthServers:    servers,
+		PrivateKeyPath: z.keyPath,
+		ShardingConf:   publisher.ShardingConfig{IncludeShards: true},
+		PShardingConf:  publisher.PShardingConfig{IncludePshards: true},
+		MetaDataConf: publisher.MetaDataConfig{
+			AddSignatureMetaData:       true,
+			AddSigMetaDataToAssertions: true,
+			AddSigMetaDataToShards:     true,
+			AddSigMetaDataToPshards:    true,
+			SignatureAlgorithm:         algorithmTypes.Ed25519,
+			KeyPhase:                   1,
+			SigValidSince:              now.Add(-time.Hour).Unix(),
+			SigValidUntil:              now.Add(validity).Unix(),
+			SigSigningInterval:         time.Minute,
+		},
+		ConsistencyConf: publisher.ConsistencyConfig{SortShards: true, SortZone: true},
+		DoSigning:       true,
+		MaxZoneSize:     50000,
+		DoPublish:       true,
+	}
+}
+
+//delegation returns the assertions delegating z to its servers in its parent zone.
+func (z *Zone) delegation() []string {
+	name := relativeName(z.Name, parentZone(z.Name))
+	records := []string{
+		fmt.Sprintf(":A: %s [ :redir: ns.%s ]", name, z.Name),
+		fmt.Sprintf(":A: %s [ :deleg: :ed25519: 1 %s ]", name, hex.EncodeToString(z.publicKey)),
+	}
+	var srvs []string
+	for i, n := range z.Servers {
+		addr := tcpAddr(n.advertised())
+		srvs = append(srvs, fmt.Sprintf(":srv: ns%d.%s %d %d", i+1, z.Name, addr.Port, i))
+		records = append(records, fmt.Sprintf(":A: ns%d.%s [ :ip4: %s ]", i+1, name, addr.IP))
+	}
+	return append(records, fmt.Sprintf(":A: ns.%s [ %s ]", name, strings.Join(srvs, " ")))
+}
+
+//CachingResolver starts a caching resolver which recursively resolves queries starting at the
+//servers of the root zone.
+func (tp *Topology) CachingResolver(name string) *Node {
+	tp.t.Helper()
+	return tp.startNode(name, "", false, "", nil)
+}
+
+//MirroringResolver starts a caching resolver like CachingResolver which mirrors all received
+//queries to shadow and compares shadow's answers with its own.
+func (tp *Topology) MirroringResolver(name string, shadow *Node) *Node {
+	tp.t.Helper()
+	return tp.startNode(name, "", false, "", shadow)
+}
+
+//Forwarder starts a caching resolver which forwards queries to upstream. The upstream resolvers
+//are queried in parallel, staggered by the resolver's ForwarderStagger.
+func (tp *Topology) Forwarder(name string, upstream ...*Node) *Node {
+	tp.t.Helper()
+	n := tp.startNode(name, "", false, "", nil)
+	n.resolver.Mode = libresolve.Forward
+	for _, u := range upstream {
+		n.resolver.Forwarders = append(n.resolver.Forwarders, u.advertised())
+	}
+	return n
+}
+
+//ConfiguredForwarder starts a caching resolver in the forwarder mode of rainsd, which forwards
+//queries to the upstream resolvers listed in its config.
+func (tp *Topology) ConfiguredForwarder(name string, upstream ...*Node) *Node {
+	tp.t.Helper()
+	n := tp.newNode(name, "", "", nil)
+	for _, u := range upstream {
+		n.forwarders = append(n.forwarders, u.advertised().String())
+	}
+	tp.start(n, false)
+	return n
+}
+
+//Restart stops n and starts a new server with the same role which loads the content of n's
+//caches from its last checkpoint.
+func (n *Node) Restart() *Node {
+	n.topology.t.Helper()
+	//The caches are checkpointed when n is stopped.
+	n.Stop()
+	restarted := n.topology.startNode(n.Name+"-restarted", n.zone, true, n.checkpoints,
+		n.shadow)
+	if z, ok := n.topology.zones[n.zone]; ok {
+		for i, server := range z.Servers {
+			if server == n {
+				z.Servers[i] = restarted
+			}
+		}
+	}
+	return restarted
+}
+
+//LoadingServer starts an additional authoritative server for z which loads the zone from a
+//zonefile at startup instead of receiving it from a publisher, and waits until it has cached the
+//zone. If presigned is set, the zonefile is signed beforehand and the server verifies it,
+//resolving the delegation of z recursively from the root servers. Otherwise the server signs the
+//zonefile with the zone's private key. It must be called after the zone is published. The server
+//is not added to the delegation of z.
+func (z *Zone) LoadingServer(presigned bool) *Node {
+	tp := z.topology
+	tp.t.Helper()
+	path, content, err := z.zonefile()
+	if err != nil {
+		tp.t.Fatal(err)
+	}
+	zoneFile := map[string]interface{}{"Path": path, "PrivateKeyPath": z.keyPath, "KeyPhase": 1}
+	if presigned {
+		signedPath := filepath.Join(tp.dir, "zonefile-"+label(z.Name)+"-signed.txt")
+		config := z.publisherConfig(path, nil)
+		config.OutputPath = signedPath
+		config.DoPublish = false
+		if err := publisher.New(config).Publish(); err != nil {
+			tp.t.Fatalf("Was not able to sign zone %s: %v", z.Name, err)
+		}
+		zoneFile = map[string]interface{}{"Path": signedPath}
+	}
+	n := tp.newNode(fmt.Sprintf("loading%d.%s", len(tp.nodes), label(z.Name)), z.Name, "", nil)
+	n.zoneFiles = []map[string]interface{}{zoneFile}
+	//The recursive resolver of rainsd is available as soon as the server verifies the zonefile.
+	for _, root := range tp.rootServers() {
+		n.rootServers = append(n.rootServers, root.String())
+	}
+	tp.start(n, false)
+	if err := n.waitForAssertions(strings.Count(content, ":A:")); err != nil {
+		tp.t.Fatal(err)
+	}
+	return n
+}
+
+//startNode starts a rainsd server. If zone is empty, the server is a caching resolver. If preload
+//is set, the server loads its caches from the checkpoints stored at checkpoints. If shadow is not
+//nil, the server mirrors all received queries to it.
+func (tp *Topology) startNode(name, zone string, preload bool, checkpoints string,
+	shadow *Node) *Node {
+	tp.t.Helper()
+	n := tp.newNode(name, zone, checkpoints, shadow)
+	if tp.BuiltinRecursion && zone == "" {
+		for _, root := range tp.rootServers() {
+			n.rootServers = append(n.rootServers, root.String())
+		}
+	}
+	tp.start(n, preload)
+	return n
+}
+
+//newNode returns a node which has not been started yet, see startNode.
+func (tp *Topology) newNode(name, zone, checkpoints string, shadow *Node) *Node {
+	tp.t.Helper()
+	if shadow != nil && tp.InMemory {
+		tp.t.Fatalf("%s cannot mirror queries over in-memory connections", name)
+	}
+	n := &Node{
+		Name:        name,
+		topology:    tp,
+		zone:        zone,
+		adminSocket: filepath.Join(tp.dir, fmt.Sprintf("admin%d.sock", len(tp.nodes))),
+		checkpoints: checkpoints,
+		shadow:      shadow,
+	}
+	if n.checkpoints == "" {
+		n.checkpoints = filepath.Join(tp.dir, "checkpoint", name) + "/"
+	}
+	if tp.Capture {
+		n.capturePath = filepath.Join(tp.dir, name+".capture")
+	}
+	if tp.Dashboard {
+		n.dashboard = tp.freeAddress()
+	}
+	return n
+}
+
+//start starts the server of n. If preload is set, the server loads its caches from n's
+//checkpoints.
+func (tp *Topology) start(n *Node, preload bool) {
+	tp.t.Helper()
+	name := n.Name
+	configPath := filepath.Join(tp.dir, name+".conf")
+	if err := ioutil.WriteFile(configPath, tp.serverConfig(n, preload), 0600); err != nil {
+		tp.t.Fatalf("Was not able to store config of %s: %v", name, err)
+	}
+	server, err := rainsd.New(configPath, name)
+	if err != nil {
+		tp.t.Fatalf("Was not able to create server %s: %v", name, err)
+	}
+	n.Server = server
+	tp.nodes = append(tp.nodes, n)
+	go server.Start(false)
+	if n.Addr, err = server.Listening(startTimeout); err != nil {
+		tp.t.Fatalf("Server %s did not start: %v", name, err)
+	}
+	if n.rootServers == nil && n.forwarders == nil {
+		n.resolver = libresolve.New(tp.rootServers(), nil, libresolve.Recursive, n.Addr, 1000)
+		if tp.InMemory {
+			n.resolver.Dialer = connection.MemDialer{}
+		}
+		server.SetResolver(n.resolver)
+	}
+	log.Info("Server started", "name", name, "addr", n.Addr)
+}
+
+//serverConfig returns the json encoded configuration of n.
+func (tp *Topology) serverConfig(n *Node, preload bool) []byte {
+	checkPointInterval, zoneAuthority, contextAuthority := 3600, []string{}, []string{}
+	mode := rainsd.ModeRecursive
+	if n.zone == "" {
+		checkPointInterval = 1
+	} else {
+		zoneAuthority, contextAuthority = []string{n.zone}, []string{"."}
+		mode = rainsd.ModeAuthoritative
+	}
+	if n.forwarders != nil {
+		mode = rainsd.ModeForwarder
+	}
+	address := map[string]interface{}{
+		"Type": "TCP",
+		"Addr": map[string]interface{}{"IP": "127.0.0.1", "Port": 0},
+	}
+	if tp.InMemory {
+		//The ID has the form of a TCP address such that resolvers reach the server under the
+		//address published in the delegation of its zone. It is unique within the process as all
+		//in-memory listeners share the same namespace.
+		i := atomic.AddInt32(&memServers, 1)
+		address = map[string]interface{}{
+			"Type": "Mem",
+			"Addr": map[string]interface{}{"ID": fmt.Sprintf("10.%d.%d.%d:5022", i>>16&0xff,
+				i>>8&0xff, i&0xff)},
+		}
+	}
+	mirrorAddress := ""
+	if n.shadow != nil {
+		mirrorAddress = n.shadow.Addr.String()
+	}
+	config, err := json.Marshal(map[string]interface{}{
+		"RootZonePublicKeyPath":          tp.rootKeyPath,
+		"AssertionCheckPointInterval":    checkPointInterval,
+		"NegAssertionCheckPointInterval": checkPointInterval,
+		"ZoneKeyCheckPointInterval":      checkPointInterval,
+		"CheckPointPath":                 n.checkpoints,
+		"PreLoadCaches":                  preload,
+		"ServerAddress":                  address,
+		"PublisherAddress":               address,
+		"MaxConnections":                 1000,
+		"KeepAlivePeriod":                60,
+		"TCPTimeout":                     300,
+		"TLSCertificateFile":             "testdata/cert/server.crt",
+		"TLSPrivateKeyFile":              "testdata/cert/server.key",
+		"MaxMsgByteLength":               65536,
+		"PrioBufferSize":                 20,
+		"NormalBufferSize":               100,
+		"NotificationBufferSize":         10,
+		"PrioWorkerCount":                2,
+		"NormalWorkerCount":              10,
+		"NotificationWorkerCount":        2,
+		"CapabilitiesCacheSize":          50,
+		"PeerToCapCacheSize":             1000,
+		"ActiveTokenCacheSize":           1000,
+		"Capabilities":                   []string{"urn:x-rains:tlssrv"},
+		"ZoneKeyCacheSize":               1000,
+		"ZoneKeyCacheWarnSize":           750,
+		"MaxPublicKeysPerZone":           5,
+		"PendingKeyCacheSize":            1000,
+		"InfrastructureKeyCacheSize":     1,
+		"ExternalKeyCacheSize":           1,
+		"DelegationQueryValidity":        5,
+		"ReapVerifyTimeout":              1800,
+		"AssertionCacheSize":             10000,
+		"NegativeAssertionCacheSize":     1000,
+		"PendingQueryCacheSize":          100,
+		"RedirectionCacheSize":           1000,
+		"RedirectionCacheWarnSize":       750,
+		"QueryValidity":                  5,
+		"AddressQueryValidity":           5,
+		"ContextAuthority":               contextAuthority,
+		"ZoneAuthority":                  zoneAuthority,
+		"MaxCacheValidity": map[string]int{
+			"AssertionValidity":        720,
+			"ShardValidity":            720,
+			"ZoneValidity":             720,
+			"AddressAssertionValidity": 720,
+		},
+		"ReapEngineTimeout":          1800,
+		"AdminSocketPath":            n.adminSocket,
+		"CapturePath":                n.capturePath,
+		"MirrorAddress":              mirrorAddress,
+		"MirrorFraction":             1,
+		"MirrorDiff":                 true,
+		"DashboardAddress":           n.dashboard,
+		"RootServers":                n.rootServers,
+		"Forwarders":                 n.forwarders,
+		"Mode":                       mode,
+		"AuthoritativeZoneFiles":     n.zoneFiles,
+		"MaxStaleAnswerAge":          tp.MaxStaleAnswerAge / time.Second,
+		"PrefetchHits":               tp.PrefetchHits,
+		"PrefetchWindow":             tp.PrefetchWindow / time.Second,
+		"MaxLastHopAnswerByteLength": tp.MaxLastHopAnswerSize,
+		"ACL":                        tp.ACL,
+		"ResponsesPerSecond":         tp.ResponsesPerSecond,
+		"RateLimitSlip":              tp.RateLimitSlip,
+		"UpstreamRetries":            tp.UpstreamRetries,
+		"UpstreamRetryBackoff":       tp.UpstreamRetryBackoff / time.Millisecond,
+		"SignAnswers":                tp.InfrastructureKeyPath != "",
+		"InfrastructureKeyPath":      tp.InfrastructureKeyPath,
+		"InfrastructureKeyPhase":     1,
+	})
+	if err != nil {
+		tp.t.Fatalf("Was not able to encode config of %s: %v", n.Name, err)
+	}
+	return config
+}
+
+//rootServers returns the addresses of the authoritative servers of the root zone.
+func (tp *Topology) rootServers() []net.Addr {
+	var addrs []net.Addr
+	if root, ok := tp.zones["."]; ok {
+		for _, n := range root.Servers {
+			addrs = append(addrs, n.advertised())
+		}
+	}
+	return addrs
+}
+
+//freeAddress returns a loopback address with a port which is currently not in use.
+func (tp *Topology) freeAddress() string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tp.t.Fatalf("Was not able to find a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+//DashboardAddress returns the address on which n serves its dashboard. It is empty if the
+//topology did not enable the dashboard when n was started.
+func (n *Node) DashboardAddress() string {
+	return n.dashboard
+}
+
+//CapturePath returns the path of the file to which n records its traffic. It is empty if the
+//topology did not capture traffic when n was started.
+func (n *Node) CapturePath() string {
+	return n.capturePath
+}
+
+//connectionType returns the type of the connections between the servers of tp.
+func (tp *Topology) connectionType() connection.Type {
+	if tp.InMemory {
+		return connection.Mem
+	}
+	return connection.TCP
+}
+
+//tcpAddr returns addr as TCP address. The ID of an in-memory address has the form of a TCP address.
+func tcpAddr(addr net.Addr) *net.TCPAddr {
+	if a, ok := addr.(*net.TCPAddr); ok {
+		return a
+	}
+	a, _ := net.ResolveTCPAddr("tcp", addr.String())
+	return a
+}
+
+//advertised returns the address at which other servers reach n.
+func (n *Node) advertised() net.Addr {
+	if n.proxy != nil {
+		return n.proxy.Addr
+	}
+	return n.Addr
+}
+
+//Stop shuts n down. Other servers can no longer reach it afterwards.
+func (n *Node) Stop() {
+	if n.stopped {
+		return
+	}
+	n.stopped = true
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	if err := n.Server.Shutdown(ctx); err != nil {
+		log.Warn("Server stopped before all queries were processed", "name", n.Name, "error", err)
+	}
+	if n.resolver != nil {
+		n.resolver.Close()
+	}
+	log.Info("Server stopped", "name", n.Name)
+}
+
+//stop shuts all servers of the topology down.
+func (tp *Topology) stop() {
+	for _, n := range tp.nodes {
+		n.Stop()
+	}
+}
+
+//Query sends a query for name and types to n and returns the answer.
+func (n *Node) Query(name string, types ...object.Type) (message.Message, error) {
+	return n.QueryWithOptions(name, nil, types...)
+}
+
+//QueryWithOptions sends a query for name and types with the query options opts to n and returns
+//the answer.
+func (n *Node) QueryWithOptions(name string, opts []query.Option, types ...object.Type) (
+	message.Message, error) {
+	q := &query.Name{
+		Context:    ".",
+		Name:       name,
+		Types:      types,
+		Expiration: clock.Now().Add(time.Hour).Unix(),
+		Options:    opts,
+	}
+	msg := message.Message{Token: token.New(), Content: []section.Section{q}}
+	return util.SendQuery(msg, n.Addr, n.topology.QueryTimeout)
+}
+
+//ExpectAnswer sends q to n and fails the test if the answer is not exactly answer, ignoring
+//signatures.
+func (n *Node) ExpectAnswer(q query.Name, answer section.Section) {
+	n.topology.t.Helper()
+	sendQueryVerifyResponse(n.topology.t, q, n.Addr, n.topology.QueryTimeout, answer)
+}
+
+//ExpectAssertion sends a query for name and typ to n and fails the test if the answer is not
+//exactly the assertion given in zonefile format, ignoring signatures.
+func (n *Node) ExpectAssertion(name string, typ object.Type, assertion string) {
+	n.topology.t.Helper()
+	q := query.Name{
+		Context:    ".",
+		Name:       name,
+		Types:      []object.Type{typ},
+		Expiration: clock.Now().Add(time.Hour).Unix(),
+	}
+	n.ExpectAnswer(q, decodeAnswers([]byte(assertion), n.topology.t)[0])
+}
+
+//Statistics returns the statistics of n, see rainsctl stats.
+func (n *Node) Statistics() rainsd.AdminStatistics {
+	n.topology.t.Helper()
+	var stats rainsd.AdminStatistics
+	n.admin(rainsd.AdminStats, &stats)
+	return stats
+}
+
+//Cached returns the content of the named cache of n in zonefile format, e.g. of
+//rainsd.CacheAssertions.
+func (n *Node) Cached(cache string) []string {
+	n.topology.t.Helper()
+	var entries []string
+	n.admin(rainsd.AdminCacheDump, &entries, cache)
+	return entries
+}
+
+//ExpectCached fails the test if no entry of the named cache of n contains all of substrings.
+func (n *Node) ExpectCached(cache string, substrings ...string) {
+	n.topology.t.Helper()
+	entries := n.Cached(cache)
+	for _, entry := range entries {
+		if containsAll(entry, substrings) {
+			return
+		}
+	}
+	n.topology.t.Fatalf("%s cache of %s does not contain %q. content=%v", cache, n.Name,
+		substrings, entries)
+}
+
+//ExpectNotCached fails the test if an entry of the named cache of n contains all of substrings.
+func (n *Node) ExpectNotCached(cache string, substrings ...string) {
+	n.topology.t.Helper()
+	for _, entry := range n.Cached(cache) {
+		if containsAll(entry, substrings) {
+			n.topology.t.Fatalf("%s cache of %s unexpectedly contains %s", cache, n.Name, entry)
+		}
+	}
+}
+
+//waitForAssertions waits until the assertion cache of n contains at least count entries. Failed
+//admin requests are retried as the admin socket is created concurrently with the server's start. It
+//returns an error if this takes longer than publishTimeout.
+func (n *Node) waitForAssertions(count int) error {
+	deadline := time.Now().Add(publishTimeout)
+	for {
+		var stats rainsd.AdminStatistics
+		err := n.adminCall(rainsd.AdminStats, &stats)
+		if err == nil && stats.Caches[rainsd.CacheAssertions] >= count {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("%s cached %d instead of %d assertions within %v", n.Name,
+				stats.Caches[rainsd.CacheAssertions], count, publishTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+//admin sends command with args to the admin socket of n and decodes the result into result. It
+//fails the test if the request fails.
+func (n *Node) admin(command string, result interface{}, args ...string) {
+	n.topology.t.Helper()
+	if err := n.adminCall(command, result, args...); err != nil {
+		n.topology.t.Fatal(err)
+	}
+}
+
+//adminCall works like admin but returns an error instead of failing the test, such that it can be
+//called from other go routines than the test's.
+func (n *Node) adminCall(command string, result interface{}, args ...string) error {
+	resp, err := rainsd.AdminCall(n.adminSocket, rainsd.AdminRequest{Command: command, Args: args},
+		adminTimeout)
+	if err != nil {
+		return fmt.Errorf("admin request %s to %s failed: %v", command, n.Name, err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("admin request %s to %s failed: %s", command, n.Name, resp.Error)
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("Was not able to decode result of %s: %v", command, err)
+	}
+	return nil
+}
+
+//loadRecords returns the records of the zone contained in the zonefile at path.
+func loadRecords(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Was not able to read zonefile: %v", err)
+	}
+	content := string(data)
+	start, end := strings.Index(content, "["), strings.LastIndex(content, "]")
+	if !strings.HasPrefix(content, ":Z:") || start < 0 || end < start {
+		t.Fatalf("%s does not contain a single zone", path)
+	}
+	var records []string
+	for _, line := range strings.Split(content[start+1:end], "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			records = append(records, line)
+		}
+	}
+	return records
+}
+
+//generateZoneKey generates a new key pair for a zone, stores the private key at path and returns
+//the public key.
+func generateZoneKey(t *testing.T, path string) ed25519.PublicKey {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Was not able to generate key: %v", err)
+	}
+	data, err := json.Marshal([]keys.PrivateKey{{
+		PublicKeyID: keys.PublicKeyID{
+			Algorithm: algorithmTypes.Ed25519,
+			KeySpace:  keys.RainsKeySpace,
+			KeyPhase:  1,
+		},
+		Key: hex.EncodeToString(privateKey),
+	}})
+	if err == nil {
+		err = ioutil.WriteFile(path, data, 0600)
+	}
+	if err != nil {
+		t.Fatalf("Was not able to store private key: %v", err)
+	}
+	return publicKey
+}
+
+//label returns a name for zone which can be used in file names.
+func label(zone string) string {
+	if zone == "." {
+		return "root"
+	}
+	return strings.TrimSuffix(zone, ".")
+}
+
+//parentZone returns the name of the zone containing zone, e.g. ch. for ethz.ch.
+func parentZone(zone string) string {
+	if i := strings.Index(zone, "."); i >= 0 && i < len(zone)-1 {
+		return zone[i+1:]
+	}
+	return "."
+}
+
+//depth returns the number of labels of zone, e.g. 2 for ethz.ch.
+func depth(zone string) int {
+	if zone == "." {
+		return 0
+	}
+	return strings.Count(zone, ".")
+}
+
+//relativeName returns the name of zone relative to its parent zone, e.g. ethz for ethz.ch.
+func relativeName(zone, parent string) string {
+	name := strings.TrimSuffix(zone, ".")
+	if parent == "." {
+		return name
+	}
+	return strings.TrimSuffix(name, "."+strings.TrimSuffix(parent, "."))
+}
+
+func containsAll(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if !strings.Contains(s, sub) {
+			return false
+		}
+	}
+	return true
+}
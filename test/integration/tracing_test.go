@@ -0,0 +1,84 @@
+package integration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestTokenTracing(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	tp.Publish()
+	tp.BuiltinRecursion = true
+	resolver := tp.CachingResolver("resolver")
+	tracing := []query.Option{query.QOTokenTracing}
+
+	msg, err := resolver.QueryWithOptions("www.ethz.ch.", tracing, object.OTIP4Addr)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	report := traceReport(t, msg.Content)
+	if !strings.Contains(report, "trace of "+msg.Token.String()) {
+		t.Errorf("trace report is not about the query's token. actual=%s", report)
+	}
+	var hops []rainsd.AdminTraceHop
+	resolver.admin(rainsd.AdminTrace, &hops, msg.Token.String())
+	events := []string{}
+	var last rainsd.AdminTraceHop
+	for _, hop := range hops {
+		events = append(events, hop.Event)
+		if hop.Event == "upstream" {
+			last = hop
+		}
+	}
+	if events[0] != "received" || events[1] != "forwarded" || events[len(events)-1] != "answered" {
+		t.Errorf("unexpected path of the query. actual=%v", events)
+	}
+	//Each upstream server traced the forwarded query and reported its path.
+	if last.Token == "" || !strings.Contains(last.Detail, "trace of "+last.Token) {
+		t.Fatalf("upstream server did not report the path of the query. actual=%+v", last)
+	}
+	var upstream []rainsd.AdminTraceHop
+	ethz.Servers[0].admin(rainsd.AdminTrace, &upstream, last.Token)
+	if len(upstream) != 2 || upstream[0].Event != "received" || upstream[1].Event != "answered" {
+		t.Errorf("unexpected path of the query at the authoritative server. actual=%+v", upstream)
+	}
+
+	msg, err = resolver.QueryWithOptions("www.ethz.ch.", tracing, object.OTIP4Addr)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if report := traceReport(t, msg.Content); !strings.Contains(report, "cached") {
+		t.Errorf("trace report does not contain the cache hit. actual=%s", report)
+	}
+
+	msg, err = resolver.Query("www.ethz.ch.", object.OTIP4Addr)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(msg.Content) != 1 {
+		t.Errorf("expected only the assertion in the answer to an untraced query. actual=%v",
+			msg.Content)
+	}
+}
+
+//traceReport returns the data of the trace report following the assertion in content.
+func traceReport(t *testing.T, content []section.Section) string {
+	t.Helper()
+	if len(content) != 2 {
+		t.Fatalf("expected the assertion and a trace report. actual=%v", content)
+	}
+	n, ok := content[1].(*section.Notification)
+	if !ok || n.Type != section.NTTokenTrace {
+		t.Fatalf("expected a token trace notification. actual=%v", content[1])
+	}
+	return n.Data
+}
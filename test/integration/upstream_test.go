@@ -0,0 +1,43 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestUpstreamRetries(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]")
+	proxy := ethz.Servers[0].Proxy(1)
+	proxy.SetFaults(Faults{Reset: 1})
+	tp.Publish()
+	tp.BuiltinRecursion = true
+	//Without retries, the client is notified as soon as the only server of the zone failed.
+	resolver := tp.CachingResolver("resolver")
+	msg, err := resolver.Query("www.ethz.ch.", object.OTIP4Addr)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(msg.Content) != 1 {
+		t.Fatalf("expected a notification. actual=%v", msg.Content)
+	}
+	if n, ok := msg.Content[0].(*section.Notification); !ok || n.Type != section.NTServerNotCapable {
+		t.Fatalf("expected a server not capable notification. actual=%v", msg.Content[0])
+	}
+
+	tp.UpstreamRetries = 3
+	tp.UpstreamRetryBackoff = time.Second
+	retrying := tp.CachingResolver("retrying")
+	time.AfterFunc(300*time.Millisecond, func() { proxy.SetFaults(Faults{}) })
+	retrying.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+		":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+	if stats := proxy.Stats(); stats.Reset == 0 {
+		t.Errorf("Faults were not injected: %+v", stats)
+	}
+}
@@ -0,0 +1,32 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+)
+
+func TestAuthoritativeZoneFiles(t *testing.T) {
+	tp := NewTopology(t)
+	defer tp.Close()
+	tp.AddZone(".")
+	tp.AddZone("ch.")
+	ethz := tp.AddZone("ethz.ch.", ":A: www [ :ip4: 192.0.2.1 ]", ":A: mail [ :ip4: 192.0.2.3 ]")
+	tp.Publish()
+	for _, presigned := range []bool{false, true} {
+		server := ethz.LoadingServer(presigned)
+		server.ExpectAssertion("www.ethz.ch.", object.OTIP4Addr,
+			":A: www ethz.ch. . [ :ip4: 192.0.2.1 ]")
+		server.ExpectAssertion("mail.ethz.ch.", object.OTIP4Addr,
+			":A: mail ethz.ch. . [ :ip4: 192.0.2.3 ]")
+		//Names without assertion are denied with the loaded zone.
+		msg, err := server.Query("ftp.ethz.ch.", object.OTIP4Addr)
+		if err != nil {
+			t.Fatalf("presigned=%v: query failed: %v", presigned, err)
+		}
+		if len(msg.Content) != 2 {
+			t.Fatalf("presigned=%v: expected a proof and a notification. answer=%v", presigned,
+				msg.Content)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	log "github.com/inconshreveable/log15"
 
 	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/clock"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
@@ -65,8 +66,8 @@ func DelegationAssertion(context, zone, rootPath, keyPath string) error {
 func addSignature(a section.WithSig, key ed25519.PrivateKey, publicKeyID keys.PublicKeyID) bool {
 	sig := signature.Sig{
 		PublicKeyID: publicKeyID,
-		ValidSince:  time.Now().Unix(),
-		ValidUntil:  time.Now().Add(365 * 24 * time.Hour).Unix(),
+		ValidSince:  clock.Now().Unix(),
+		ValidUntil:  clock.Now().Add(365 * 24 * time.Hour).Unix(),
 	}
 	return siglib.SignSection(a, key, sig)
 }